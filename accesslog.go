@@ -0,0 +1,92 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/rely"
+)
+
+// accessRecorder captures the status and size of a response. It can be hijacked,
+// so that WebSocket upgrades go through it.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (a *accessRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessRecorder) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += int64(n)
+	return n, err
+}
+
+func (a *accessRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := a.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		a.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (a *accessRecorder) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (a *accessRecorder) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// withAccessLog logs a line per HTTP request served by the handler, in logfmt, e.g.
+//
+//	access method=GET path=/stats status=200 duration_ms=1.204 bytes=512 ip=203.0.113.7 kind=http
+//
+// kind is "websocket" for upgrades, whose duration covers the handshake only.
+// A sampleRate fraction of the successful requests is logged; errors (status 400 and above) always are.
+func withAccessLog(sampleRate float64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 400 && sampleRate < 1 && rand.Float64() >= sampleRate {
+			return
+		}
+
+		kind := "http"
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			kind = "websocket"
+		}
+		relayLog.Info("access", "method", r.Method, "path", r.URL.Path, "status", status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000, "bytes", recorder.bytes,
+			"ip", rely.GetIP(r).String(), "kind", kind)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	handler := withAccessLog(1, hello)
+
+	r := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	r.Header.Set("X-Real-IP", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if line := buf.String(); !strings.Contains(line, "INFO access module=relay method=GET path=/stats status=200 duration_ms=") ||
+		!strings.Contains(line, " bytes=5 ip=203.0.113.7 kind=http") {
+		t.Errorf("unexpected access log line %q", line)
+	}
+
+	// Sampling drops successful requests, never errors
+	buf.Reset()
+	sampled := withAccessLog(1e-9, hello)
+	sampled.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	sampled.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if lines := strings.Count(buf.String(), "access "); lines != 1 || !strings.Contains(buf.String(), "path=/missing status=404") {
+		t.Errorf("expected only the error to be logged, got %q", buf.String())
+	}
+}
+
+func TestAccessLogHijack(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	logged := make(chan struct{})
+	upgrade := withAccessLog(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack through the access log: %v", err)
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		conn.Close()
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrade.ServeHTTP(w, r)
+		close(logged)
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: relay\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	conn.Read(make([]byte, 512))
+	conn.Close()
+	<-logged
+
+	if !strings.Contains(buf.String(), "status=101") || !strings.Contains(buf.String(), "kind=websocket") {
+		t.Errorf("unexpected access log %q", buf.String())
+	}
+}
@@ -0,0 +1,116 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptiveSteps is the number of levels between normal operation and the tightest policy.
+	adaptiveSteps = 4
+
+	// adaptiveMinEvents is the minimum number of events in an interval for its share
+	// of spam to be meaningful.
+	adaptiveMinEvents = 20
+)
+
+// Adaptive tightens the low tier's policy during spam waves, and relaxes it afterwards.
+// A wave is detected when, within an interval, the share of events rejected as spam
+// reaches RejectRatio or the relay accepts more than StoreGrowth events. Each interval
+// of wave tightens the policy by one step, each calm interval relaxes it by one, so that
+// at the tightest the low tier's daily rate is scaled by MinRateFactor and its events
+// must carry MaxPoW bits of proof of work.
+type Adaptive struct {
+	name     string
+	obs      *Observability
+	accepted atomic.Uint64 // events accepted in the current interval
+	spam     atomic.Uint64 // events rejected as spam in the current interval
+	rejected atomic.Uint64 // other events rejected in the current interval
+	level    atomic.Int32  // from 0 (normal) to adaptiveSteps (tightest)
+
+	RejectRatio   float64 // Share of spam in an interval signaling a wave
+	StoreGrowth   uint64  // Accepted events in an interval signaling a wave (0 disables)
+	MinRateFactor float64 // Factor applied to the low tier's daily rate at the tightest
+	MaxPoW        int     // NIP-13 difficulty required from the low tier at the tightest
+	Interval      time.Duration
+}
+
+func NewAdaptive(ctx context.Context, name string, cfg Config, obs *Observability) *Adaptive {
+	a := &Adaptive{
+		name:          name,
+		obs:           obs,
+		RejectRatio:   cfg.AdaptiveRejectRatio,
+		StoreGrowth:   uint64(cfg.AdaptiveStoreGrowth),
+		MinRateFactor: cfg.AdaptiveMinRateFactor,
+		MaxPoW:        cfg.AdaptiveMaxPoW,
+		Interval:      time.Duration(cfg.AdaptiveIntervalSeconds) * time.Second,
+	}
+
+	go a.controller(ctx)
+	return a
+}
+
+// Record counts the outcome of an event.
+func (a *Adaptive) Record(err error) {
+	switch {
+	case err == nil:
+		a.accepted.Add(1)
+	case isSpam(err):
+		a.spam.Add(1)
+	default:
+		// Rejections such as rate limits are left out, as tightening the policy causes them
+		a.rejected.Add(1)
+	}
+}
+
+// RateFactor returns the factor currently applied to the low tier's daily rate.
+func (a *Adaptive) RateFactor() float64 {
+	return a.MinRateFactor + (1-a.MinRateFactor)*float64(adaptiveSteps-a.level.Load())/adaptiveSteps
+}
+
+// PoW returns the NIP-13 difficulty currently required from the low tier.
+func (a *Adaptive) PoW() int {
+	return a.MaxPoW * int(a.level.Load()) / adaptiveSteps
+}
+
+// evaluate ends the current interval, and tightens or relaxes the policy by one step.
+func (a *Adaptive) evaluate() {
+	accepted, spam, rejected := a.accepted.Swap(0), a.spam.Swap(0), a.rejected.Swap(0)
+	total := accepted + spam + rejected
+
+	wave := (total >= adaptiveMinEvents && float64(spam) >= a.RejectRatio*float64(total)) ||
+		(a.StoreGrowth > 0 && accepted > a.StoreGrowth)
+
+	level := a.level.Load()
+	switch {
+	case wave && level < adaptiveSteps:
+		a.level.Add(1)
+		a.obs.adaptiveTightenedCount.Add(1)
+		limiterLog.Warn("spam wave, tightening low tier policy", "relay", a.name, "spam", spam, "total", total,
+			"accepted", accepted, "rate_factor", a.RateFactor(), "pow", a.PoW())
+
+	case !wave && level > 0:
+		a.level.Add(-1)
+		limiterLog.Info("no spam wave, relaxing low tier policy", "relay", a.name, "spam", spam, "total", total,
+			"accepted", accepted, "rate_factor", a.RateFactor(), "pow", a.PoW())
+	}
+}
+
+func (a *Adaptive) controller(ctx context.Context) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			a.evaluate()
+		}
+	}
+}
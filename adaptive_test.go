@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAdaptive(t *testing.T) {
+	a := &Adaptive{obs: &Observability{}, RejectRatio: 0.5, StoreGrowth: 100, MinRateFactor: 0.2, MaxPoW: 20}
+	record := func(accepted, spam, rateLimited int) {
+		for range accepted {
+			a.Record(nil)
+		}
+		for range spam {
+			a.Record(ErrURLNotAllowed)
+		}
+		for range rateLimited {
+			a.Record(ErrRateLimited)
+		}
+		a.evaluate()
+	}
+
+	if a.RateFactor() != 1 || a.PoW() != 0 {
+		t.Fatalf("normal operation: got rate factor %v, PoW %d", a.RateFactor(), a.PoW())
+	}
+
+	// Too few events to tell, then rate limiting alone is no wave
+	record(1, 9, 0)
+	record(10, 0, 90)
+	if a.level.Load() != 0 {
+		t.Fatalf("unexpected tightening to level %d", a.level.Load())
+	}
+
+	// Spam waves tighten the policy one step per interval, within the bounds
+	for range adaptiveSteps + 2 {
+		record(10, 30, 0)
+	}
+	if a.RateFactor() != 0.2 || a.PoW() != 20 || a.obs.adaptiveTightenedCount.Load() != adaptiveSteps {
+		t.Errorf("tightest: got rate factor %v, PoW %d", a.RateFactor(), a.PoW())
+	}
+
+	// Calm intervals relax it, one step at a time
+	record(50, 0, 0)
+	if a.RateFactor() != 0.4 || a.PoW() != 15 {
+		t.Errorf("relaxed one step: got rate factor %v, PoW %d", a.RateFactor(), a.PoW())
+	}
+
+	// Store growth alone is a wave
+	record(101, 0, 0)
+	if a.level.Load() != adaptiveSteps {
+		t.Errorf("store growth: got level %d", a.level.Load())
+	}
+}
@@ -0,0 +1,256 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// flagUpdate is the body of a request changing a feature flag.
+type flagUpdate struct {
+	Flag    string `json:"flag"`
+	Tier    string `json:"tier"`
+	Enabled bool   `json:"enabled"`
+}
+
+// jobRun is the body of a request running a job.
+type jobRun struct {
+	Job string `json:"job"`
+}
+
+// rankUpdate is the body of a request setting a rank override.
+type rankUpdate struct {
+	Pubkey string   `json:"pubkey"` // hex or npub
+	Rank   *float64 `json:"rank"`   // null removes the override
+}
+
+// relayStats is the body of a /stats response.
+type relayStats struct {
+	Relay    string                     `json:"relay"`
+	Metrics  map[string]uint64          `json:"metrics"`
+	Flags    map[string]map[string]bool `json:"flags"`
+	Storage  *LedgerReport              `json:"storage,omitempty"`
+	Backfill *BackfillReport            `json:"backfill,omitempty"`
+	Queries  []ShapeStats               `json:"queries,omitempty"` // per filter shape, the most time-consuming first
+	Store    string                     `json:"store"`             // "ok", or "read-only: <reason>" when the store is unwritable
+
+	Reputation *ReputationStatus `json:"reputation,omitempty"` // pubkeys and IP groups reported by peers
+	Blocklist  *BlocklistStatus  `json:"blocklist,omitempty"`  // entries of the blocklists
+}
+
+// adminHandler serves the admin API of a relay under root, and reports whether the request
+// was for it. Requests must carry the relay's ADMIN_TOKEN as a bearer token.
+//   - GET  <root>/stats         metrics, feature flags, storage per tier, backfill usage and query statistics
+//   - GET  <root>/admin/flags   feature flags
+//   - POST <root>/admin/flags   change a feature flag, e.g. {"flag":"url_policy","tier":"mid","enabled":true}
+//   - GET  <root>/admin/config  editable settings
+//   - POST <root>/admin/config  change settings, e.g. {"MID_THRESHOLD":"0.6"}, persisted and applied live
+//   - GET  <root>/admin/jobs    scheduled jobs, with their next and last runs
+//   - POST <root>/admin/jobs    run a job now and wait for it, e.g. {"job":"prune"}
+//   - GET  <root>/admin/ranks   rank overrides
+//   - POST <root>/admin/ranks   pin a pubkey's rank, e.g. {"pubkey":"<hex>","rank":0.8}, persisted like settings
+//   - DELETE <root>/admin/ranks?pubkey=<hex>  remove a rank override
+//   - GET  <root>/admin/candidate  how the decisions of the candidate config differ from the active ones
+//   - DELETE <root>/admin/candidate  start the comparison over
+//
+// The config editor page at <root>/admin is served without a token, as it holds no data:
+// it asks for the token and uses the API above.
+func adminHandler(w http.ResponseWriter, r *http.Request, root string, cfg Config, d *Deps) bool {
+	if cfg.AdminToken == "" {
+		return false
+	}
+
+	pagePath, statsPath := path.Join(root, "admin"), path.Join(root, "stats")
+	flagsPath, configPath := path.Join(root, "admin", "flags"), path.Join(root, "admin", "config")
+	jobsPath, ranksPath := path.Join(root, "admin", "jobs"), path.Join(root, "admin", "ranks")
+	candidatePath := path.Join(root, "admin", "candidate")
+	if r.URL.Path == pagePath && r.Method == http.MethodGet {
+		serveAdminPage(w, r)
+		return true
+	}
+	if r.URL.Path != statsPath && r.URL.Path != flagsPath && r.URL.Path != configPath && r.URL.Path != jobsPath && r.URL.Path != ranksPath && r.URL.Path != candidatePath {
+		return false
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return true
+	}
+
+	switch {
+	case r.URL.Path == statsPath && r.Method == http.MethodGet:
+		writeJSON(w, d.stats())
+
+	case r.URL.Path == flagsPath && r.Method == http.MethodGet:
+		writeJSON(w, d.Flags.State())
+
+	case r.URL.Path == flagsPath && r.Method == http.MethodPost:
+		var update flagUpdate
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+		flag, ok := parseFlag(update.Flag)
+		if !ok {
+			http.Error(w, "flag must be one of: "+strings.Join(flagNames[:], ", "), http.StatusBadRequest)
+			return true
+		}
+		tier, ok := parseTier(update.Tier)
+		if !ok {
+			http.Error(w, "tier must be one of: low, mid, high", http.StatusBadRequest)
+			return true
+		}
+
+		d.Flags.Set(flag, tier, update.Enabled)
+		writeJSON(w, d.Flags.State())
+
+	case r.URL.Path == configPath && d.Settings == nil:
+		http.Error(w, "this relay's settings can't be edited", http.StatusNotFound)
+
+	case r.URL.Path == configPath && r.Method == http.MethodGet:
+		writeJSON(w, d.Settings.Values())
+
+	case r.URL.Path == configPath && r.Method == http.MethodPost:
+		var changes map[string]string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&changes); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+
+		updated, err := d.Settings.Update(changes)
+		switch {
+		case errors.Is(err, ErrUnknownSetting) || errors.Is(err, ErrInvalidSetting):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return true
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+
+		d.Linkage.SetBanned(updated.BannedPubkeys)
+		writeJSON(w, d.Settings.Values())
+
+	case r.URL.Path == jobsPath && d.Jobs == nil:
+		http.Error(w, "this relay has no jobs", http.StatusNotFound)
+
+	case r.URL.Path == jobsPath && r.Method == http.MethodGet:
+		writeJSON(w, d.Jobs.Status(d.Name))
+
+	case r.URL.Path == jobsPath && r.Method == http.MethodPost:
+		var run jobRun
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&run); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+
+		// The job isn't interrupted if the client gives up waiting, only on shutdown
+		err := d.Jobs.RunNow(context.WithoutCancel(r.Context()), d.Name, run.Job)
+		switch {
+		case errors.Is(err, ErrUnknownJob):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return true
+		case errors.Is(err, ErrJobRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return true
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		writeJSON(w, d.Jobs.Status(d.Name))
+
+	case r.URL.Path == ranksPath && (d.Settings == nil || d.Management == nil):
+		http.Error(w, "this relay's settings can't be edited", http.StatusNotFound)
+
+	case r.URL.Path == ranksPath && r.Method == http.MethodGet:
+		writeJSON(w, listRankOverrides(d.Settings.Config()))
+
+	case r.URL.Path == ranksPath && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		var update rankUpdate
+		if r.Method == http.MethodDelete {
+			update.Pubkey = r.URL.Query().Get("pubkey")
+		} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&update); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+		if prefix, value, err := nip19.Decode(update.Pubkey); err == nil && prefix == "npub" {
+			update.Pubkey = value.(string)
+		}
+		if !nostr.IsValid32ByteHex(update.Pubkey) {
+			http.Error(w, "pubkey must be a hex pubkey or an npub", http.StatusBadRequest)
+			return true
+		}
+		if update.Rank != nil && (math.IsNaN(*update.Rank) || *update.Rank < 0 || *update.Rank > 1) {
+			http.Error(w, "rank must be a number between 0 and 1, or null", http.StatusBadRequest)
+			return true
+		}
+
+		if err := d.Management.SetRankOverride(d, update.Pubkey, update.Rank); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		writeJSON(w, listRankOverrides(d.Settings.Config()))
+
+	case r.URL.Path == candidatePath && d.Candidate == nil:
+		http.Error(w, "this relay has no candidate config", http.StatusNotFound)
+
+	case r.URL.Path == candidatePath && r.Method == http.MethodGet:
+		writeJSON(w, d.Candidate.Report())
+
+	case r.URL.Path == candidatePath && r.Method == http.MethodDelete:
+		d.Candidate.Reset(d.now())
+		writeJSON(w, d.Candidate.Report())
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+	return true
+}
+
+// stats returns the stats of the relay, as served by /stats.
+func (d *Deps) stats() relayStats {
+	metrics := make(map[string]uint64)
+	for _, m := range d.Obs.Snapshot() {
+		metrics[m.Name] = m.Value
+	}
+	stats := relayStats{Relay: d.Name, Metrics: metrics, Flags: d.Flags.State(), Store: "ok"}
+	if degraded, reason := d.StoreHealth.Degraded(); degraded {
+		stats.Store = "read-only: " + reason
+	}
+	if d.Retention != nil && d.Retention.Ledger != nil {
+		report := d.Retention.Ledger.Report()
+		stats.Storage = &report
+	}
+	if d.Backfill != nil {
+		report := d.Backfill.Report()
+		stats.Backfill = &report
+	}
+	if d.Queries != nil {
+		stats.Queries = d.Queries.Report()
+	}
+	if d.Reputation != nil {
+		status := d.Reputation.Status()
+		stats.Reputation = &status
+	}
+	if d.Blocklist != nil {
+		status := d.Blocklist.Status()
+		stats.Blocklist = &status
+	}
+	return stats
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
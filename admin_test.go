@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	cfg := Config{AdminToken: "secret"}
+	d := &Deps{Name: "community", Obs: &Observability{}, Flags: NewFeatureFlags(cfg)}
+	d.Obs.bannedCount.Add(3)
+
+	serve := func(method, target, token, body string) (*httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		return w, adminHandler(w, r, "/community", cfg, d)
+	}
+
+	if _, handled := serve(http.MethodGet, "/community/other", "secret", ""); handled {
+		t.Error("other paths should be left to the relay")
+	}
+	if w, _ := serve(http.MethodGet, "/community/stats", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got status %d", w.Code)
+	}
+
+	w, _ := serve(http.MethodGet, "/community/stats", "secret", "")
+	var stats relayStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("invalid stats %q: %v", w.Body.String(), err)
+	}
+	if stats.Relay != "community" || stats.Metrics["banned"] != 3 || stats.Flags["shadow_ban"]["low"] {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	w, _ = serve(http.MethodPost, "/community/admin/flags", "secret", `{"flag":"shadow_ban","tier":"low","enabled":true}`)
+	if w.Code != http.StatusOK || !d.Flags.Enabled(FlagShadowBan, TierLow) {
+		t.Errorf("setting a flag: got status %d, body %q", w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{`{"flag":"free_lunch","tier":"low"}`, `{"flag":"backfill","tier":"top"}`, `not json`} {
+		if w, _ := serve(http.MethodPost, "/community/admin/flags", "secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", body, w.Code)
+		}
+	}
+
+	if w, _ := serve(http.MethodPost, "/community/admin/jobs", "secret", `{"job":"prune"}`); w.Code != http.StatusNotFound {
+		t.Errorf("running a job without a scheduler: got status %d, want 404", w.Code)
+	}
+	pruned := 0
+	d.Jobs = NewScheduler()
+	d.Jobs.Add("community", jobPrune, "0 * * * *", func(context.Context) error { pruned++; return nil })
+	w, _ = serve(http.MethodPost, "/community/admin/jobs", "secret", `{"job":"prune"}`)
+	if w.Code != http.StatusOK || pruned != 1 {
+		t.Errorf("running a job: got status %d, body %q", w.Code, w.Body.String())
+	}
+	w, _ = serve(http.MethodGet, "/community/admin/jobs", "secret", "")
+	var jobs []JobStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil || len(jobs) != 1 || jobs[0].LastRun.IsZero() {
+		t.Errorf("unexpected jobs %q: %v", w.Body.String(), err)
+	}
+
+	if adminHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stats", nil), "/", Config{}, d) {
+		t.Error("admin API should be disabled without a token")
+	}
+}
+
+func TestAdminRanks(t *testing.T) {
+	getenv := func(string) string { return "" }
+	cfg := parseConfig(getenv)
+	cfg.AdminToken = "secret"
+	d := &Deps{Obs: &Observability{}, Flags: NewFeatureFlags(cfg), Linkage: NewIPLinkage(t.Context(), nil), Management: NewManagement()}
+	d.Settings = NewSettings(cfg, nil, getenv, nil)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		adminHandler(w, r, "/", cfg, d)
+		return w
+	}
+
+	pubkey := strings.Repeat("ab", 32)
+	w := serve(http.MethodPost, "/admin/ranks", `{"pubkey":"`+pubkey+`","rank":0.8}`)
+	var overrides []pubkeyRank
+	if err := json.Unmarshal(w.Body.Bytes(), &overrides); err != nil || len(overrides) != 1 || overrides[0].Rank != 0.8 {
+		t.Fatalf("set: got %d %q, %v", w.Code, w.Body.String(), err)
+	}
+	if rank, ok := operatorRank(pubkey, d.Settings.Config()); !ok || rank != 0.8 {
+		t.Errorf("the override should take precedence over the rank provider, got %v, %v", rank, ok)
+	}
+
+	for _, body := range []string{`{"pubkey":"nobody","rank":0.5}`, `{"pubkey":"` + pubkey + `","rank":1.5}`, `not json`} {
+		if w := serve(http.MethodPost, "/admin/ranks", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", body, w.Code)
+		}
+	}
+
+	if w := serve(http.MethodDelete, "/admin/ranks?pubkey="+pubkey, ""); w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Errorf("remove: got %d %q", w.Code, w.Body.String())
+	}
+	if _, ok := operatorRank(pubkey, d.Settings.Config()); ok {
+		t.Error("expected the override to be removed")
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	getenv := func(string) string { return "" }
+	cfg := parseConfig(getenv)
+	cfg.AdminToken = "secret"
+	d := &Deps{Obs: &Observability{}, Flags: NewFeatureFlags(cfg), Linkage: NewIPLinkage(t.Context(), nil)}
+	d.Settings = NewSettings(cfg, nil, getenv, nil)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		adminHandler(w, r, "/", cfg, d)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/admin", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "admin/config") {
+		t.Errorf("editor page: got status %d", w.Code)
+	}
+
+	w := serve(http.MethodPost, "/admin/config", `{"MID_THRESHOLD":"0.6","BANNED_PUBKEYS":"spammer"}`)
+	var values []SettingValue
+	if err := json.Unmarshal(w.Body.Bytes(), &values); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	if d.Settings.Config().MidThreshold != 0.6 || !d.Linkage.IsBanned("spammer") {
+		t.Error("changes should be applied live")
+	}
+	for _, v := range values {
+		if v.Key == "MID_THRESHOLD" && v.Value != "0.6" {
+			t.Errorf("got MID_THRESHOLD %q", v.Value)
+		}
+	}
+
+	for _, body := range []string{`{"STORE_PATH":"/tmp"}`, `{"MID_THRESHOLD":"x"}`, `[]`} {
+		if w := serve(http.MethodPost, "/admin/config", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// assetTypes maps the image types the relay can host to their file extension.
+var assetTypes = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/x-icon":  ".ico",
+	"image/svg+xml": ".svg",
+}
+
+// Asset is an image served by the relay itself, under its root path.
+type Asset struct {
+	Name        string // file name, e.g. "icon.png"
+	ContentType string
+	Data        []byte
+}
+
+// isURL returns whether the value of an image setting is a URL rather than an image to host.
+func isURL(value string) bool {
+	return strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://")
+}
+
+// loadAsset loads the image given by the value of an image setting: a file path,
+// or the image bytes encoded in base64 with a "base64:" prefix. It returns nil
+// if the value is empty or a URL, as there is nothing to host then.
+func loadAsset(name, value string) (*Asset, error) {
+	if value == "" || isURL(value) {
+		return nil, nil
+	}
+
+	var data []byte
+	var err error
+	if encoded, ok := strings.CutPrefix(value, "base64:"); ok {
+		data, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		data, err = os.ReadFile(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(data)
+	if strings.EqualFold(filepath.Ext(value), ".svg") || bytes.Contains(data[:min(len(data), 512)], []byte("<svg")) {
+		contentType = "image/svg+xml"
+	}
+	ext, ok := assetTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type %s", contentType)
+	}
+	return &Asset{Name: name + ext, ContentType: contentType, Data: data}, nil
+}
+
+// Path returns the path of the asset under the relay's root path.
+func (a *Asset) Path(root string) string {
+	return path.Join(root, a.Name)
+}
+
+func (a *Asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 1 day
+	w.WriteHeader(http.StatusOK)
+	w.Write(a.Data)
+}
+
+// assetURL returns the URL of an image setting for clients fetching it through the request:
+// URLs are returned as they are, hosted assets get an absolute URL on the request's host.
+func assetURL(r *http.Request, root, value string, asset *Asset) string {
+	if asset == nil {
+		return value
+	}
+
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + asset.Path(root)
+}
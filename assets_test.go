@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"image/color"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAsset(t *testing.T) {
+	png := generateFavicon(color.RGBA{52, 152, 219, 255})
+	svg := filepath.Join(t.TempDir(), "logo.svg")
+	if err := os.WriteFile(svg, []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value       string
+		name        string
+		contentType string
+	}{
+		{value: "", name: ""},
+		{value: "https://example.com/icon.png", name: ""},
+		{value: "base64:" + base64.StdEncoding.EncodeToString(png), name: "icon.png", contentType: "image/png"},
+		{value: svg, name: "icon.svg", contentType: "image/svg+xml"},
+	}
+
+	for _, test := range tests {
+		asset, err := loadAsset("icon", test.value)
+		if err != nil {
+			t.Fatalf("%q: %v", test.value, err)
+		}
+		if test.name == "" {
+			if asset != nil {
+				t.Errorf("%q: got asset %s, want none", test.value, asset.Name)
+			}
+			continue
+		}
+		if asset == nil || asset.Name != test.name || asset.ContentType != test.contentType {
+			t.Errorf("%q: got %+v, want %s (%s)", test.value, asset, test.name, test.contentType)
+		}
+	}
+
+	if _, err := loadAsset("icon", "base64:"+base64.StdEncoding.EncodeToString([]byte("not an image"))); err == nil {
+		t.Error("expected an error for data that is not an image")
+	}
+	if _, err := loadAsset("icon", filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestAssetURL(t *testing.T) {
+	asset := &Asset{Name: "icon.png"}
+
+	r := httptest.NewRequest("GET", "/community", nil)
+	r.Host = "relay.example.com"
+	if got := assetURL(r, "/community", "", asset); got != "http://relay.example.com/community/icon.png" {
+		t.Errorf("got %s", got)
+	}
+
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if got := assetURL(r, "/", "", asset); got != "https://relay.example.com/icon.png" {
+		t.Errorf("got %s", got)
+	}
+
+	if got := assetURL(r, "/", "https://cdn.example.com/icon.png", nil); got != "https://cdn.example.com/icon.png" {
+		t.Errorf("URLs should be kept as they are, got %s", got)
+	}
+}
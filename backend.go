@@ -0,0 +1,153 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/fiatjaf/eventstore/postgresql"
+)
+
+// Event store backends
+const (
+	backendBadger   = "badger"
+	backendLMDB     = "lmdb"
+	backendSQLite   = "sqlite3"
+	backendPostgres = "postgresql"
+)
+
+// sqlQueryLimit caps the events a query returns with the SQL backends. It's at least
+// pruneBatchSize, for pruning, compaction and exports to get whole batches.
+const sqlQueryLimit = 1000
+
+// EventStore is an event store backend. All the backends the relay supports can count events.
+type EventStore interface {
+	eventstore.Store
+	eventstore.Counter
+}
+
+// cgoBackends creates the backends written in C (LMDB and SQLite), which backend_cgo.go
+// registers in builds with cgo enabled.
+var cgoBackends = map[string]func(cfg Config) EventStore{}
+
+// openStores opens the event store of the config, and the Badger store keeping the relay's
+// own records: deletion tombstones, onboarding, compaction counts, the rank refresh queue
+// and the schema version. With the Badger backend, both are the same store, at STORE_PATH;
+// with the others, the records are kept in a Badger store at STORE_PATH.
+func openStores(cfg Config) (EventStore, *badger.BadgerBackend, error) {
+	meta := &badger.BadgerBackend{Path: cfg.StorePath}
+	if err := meta.Init(); err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize badger backend at %s: %w", cfg.StorePath, err)
+	}
+	if cfg.EventStoreBackend == backendBadger {
+		return meta, meta, nil
+	}
+
+	db, err := openEventStore(cfg)
+	if err != nil {
+		meta.Close()
+		return nil, nil, err
+	}
+	return db, meta, nil
+}
+
+// openEventStore opens the event store of the config alone.
+func openEventStore(cfg Config) (EventStore, error) {
+	var db EventStore
+	location := eventStoreLocation(cfg)
+	switch cfg.EventStoreBackend {
+	case backendLMDB, backendSQLite:
+		open, ok := cgoBackends[cfg.EventStoreBackend]
+		if !ok {
+			return nil, fmt.Errorf("the %s backend requires a build with cgo enabled", cfg.EventStoreBackend)
+		}
+		db = open(cfg)
+	case backendPostgres:
+		// Don't log the credentials of the URL
+		db, location = &postgresql.PostgresBackend{DatabaseURL: cfg.PostgresURL, QueryLimit: sqlQueryLimit}, "POSTGRES_URL"
+	default:
+		db = &badger.BadgerBackend{Path: cfg.StorePath}
+	}
+
+	if err := db.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize %s backend at %s: %w", cfg.EventStoreBackend, location, err)
+	}
+	return db, nil
+}
+
+// tenantEventStore gives a virtual relay without its own event store location
+// one under ./tenants, like its STORE_PATH. PostgreSQL databases can't be derived,
+// so virtual relays using it must set their own POSTGRES_URL.
+func tenantEventStore(cfg Config, name string, env map[string]string) (Config, error) {
+	switch cfg.EventStoreBackend {
+	case backendLMDB:
+		if _, ok := env["LMDB_PATH"]; !ok {
+			cfg.LMDBPath = filepath.Join("tenants", name+"-lmdb")
+		}
+	case backendSQLite:
+		if _, ok := env["SQLITE_PATH"]; !ok {
+			cfg.SQLitePath = filepath.Join("tenants", name+".sqlite")
+		}
+	case backendPostgres:
+		if _, ok := env["POSTGRES_URL"]; !ok {
+			return cfg, fmt.Errorf("virtual relay %q must set its own POSTGRES_URL", name)
+		}
+	}
+	return cfg, nil
+}
+
+// storeSize returns the bytes the event store of the config takes on disk. With
+// the Badger backend, Badger updates it about every minute.
+func storeSize(cfg Config, db EventStore) (int64, error) {
+	switch store := db.(type) {
+	case *badger.BadgerBackend:
+		lsm, vlog := store.DB.Size()
+		return lsm + vlog, nil
+	case *postgresql.PostgresBackend:
+		var size int64
+		err := store.DB.QueryRow("SELECT pg_total_relation_size('event')").Scan(&size)
+		return size, err
+	}
+
+	// LMDB is a directory, SQLite a file along with its write-ahead log
+	var size int64
+	for _, path := range []string{eventStoreLocation(cfg), eventStoreLocation(cfg) + "-wal"} {
+		err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// eventStoreLocation returns the path or URL identifying the event store of the config,
+// which no two relays may share.
+func eventStoreLocation(cfg Config) string {
+	switch cfg.EventStoreBackend {
+	case backendLMDB:
+		return filepath.Clean(cfg.LMDBPath)
+	case backendSQLite:
+		return filepath.Clean(cfg.SQLitePath)
+	case backendPostgres:
+		return cfg.PostgresURL
+	default:
+		return filepath.Clean(cfg.StorePath)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build cgo
+
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"github.com/fiatjaf/eventstore/lmdb"
+	"github.com/fiatjaf/eventstore/sqlite3"
+)
+
+func init() {
+	cgoBackends[backendLMDB] = func(cfg Config) EventStore {
+		return &lmdb.LMDBBackend{Path: cfg.LMDBPath}
+	}
+	cgoBackends[backendSQLite] = func(cfg Config) EventStore {
+		return &sqlite3.SQLite3Backend{DatabaseURL: cfg.SQLitePath, QueryLimit: sqlQueryLimit}
+	}
+}
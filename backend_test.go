@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/sqlite3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOpenStores(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := parseConfig(func(string) string { return "" })
+	cfg.StorePath = filepath.Join(dir, "badger")
+	db, meta, err := openStores(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db != EventStore(meta) {
+		t.Error("the badger backend should keep events and records in the same store")
+	}
+	meta.Close()
+
+	cfg.StorePath = filepath.Join(dir, "records")
+	cfg.EventStoreBackend, cfg.SQLitePath = backendSQLite, filepath.Join(dir, "events.sqlite")
+	db, meta, err = openStores(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer meta.Close()
+	if _, ok := db.(*sqlite3.SQLite3Backend); !ok {
+		t.Fatalf("expected a SQLite store, got %T", db)
+	}
+
+	e := signedEvent(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, nil)
+	if err := db.SaveEvent(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if getEventByID(ctx, db, e.ID) == nil {
+		t.Error("expected the event to be stored in SQLite")
+	}
+	if count, _ := meta.CountEvents(ctx, nostr.Filter{}); count != 0 {
+		t.Errorf("expected no event in the records store, got %d", count)
+	}
+	if size, err := storeSize(cfg, db); err != nil || size == 0 {
+		t.Errorf("expected the size of the SQLite store, got %d, %v", size, err)
+	}
+}
+
+func TestEventStoreConfig(t *testing.T) {
+	for env, valid := range map[string]bool{
+		"EVENTSTORE_BACKEND=lmdb":                                    true,
+		"EVENTSTORE_BACKEND=mysql":                                   false,
+		"EVENTSTORE_BACKEND=postgresql":                              false,
+		"EVENTSTORE_BACKEND=postgresql POSTGRES_URL=postgres://h/db": true,
+		"EVENTSTORE_BACKEND=sqlite3 INDEX_WARMUP=true":               false,
+		"EVENTSTORE_BACKEND=sqlite3 SNAPSHOT_SCHEDULE=0_3_*_*_*":     false,
+		"EVENTSTORE_BACKEND=badger SNAPSHOT_SCHEDULE=0_3_*_*_*":      true,
+	} {
+		vars := make(map[string]string)
+		for _, pair := range strings.Fields(env) {
+			key, value, _ := strings.Cut(pair, "=")
+			vars[key] = strings.ReplaceAll(value, "_", " ")
+		}
+		_, err := buildConfig(func(key string) string { return vars[key] })
+		if (err == nil) != valid {
+			t.Errorf("%s: got error %v, want valid %v", env, err, valid)
+		}
+	}
+
+	// Virtual relays get their own event stores
+	cfg := parseConfig(func(key string) string { return map[string]string{"EVENTSTORE_BACKEND": "sqlite3"}[key] })
+	tenant, err := tenantEventStore(cfg, "community", nil)
+	if err != nil || tenant.SQLitePath != filepath.Join("tenants", "community.sqlite") {
+		t.Errorf("expected a SQLite store under ./tenants, got %q, %v", tenant.SQLitePath, err)
+	}
+	cfg.EventStoreBackend = backendPostgres
+	if _, err := tenantEventStore(cfg, "community", nil); err == nil {
+		t.Error("virtual relays should have to set their own POSTGRES_URL")
+	}
+}
@@ -0,0 +1,124 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Backfill policies of a tier, for events older than backfillAgeThreshold
+const (
+	backfillNormal   = "normal"   // rate limited like recent events
+	backfillFree     = "free"     // skip rate limiting, up to the daily cap
+	backfillDiscount = "discount" // rate limited at a fraction of their cost
+	backfillReject   = "reject"   // rejected as too old
+)
+
+const (
+	// backfillDays is the number of days of backfill history kept by a Backfill.
+	backfillDays = 30
+
+	// backfillTopPubkeys is how many of the day's heaviest backfill users a report lists.
+	backfillTopPubkeys = 20
+)
+
+// Backfill accounts for the events that skip rate limiting through the free backfill
+// path, per pubkey and per day (UTC), and caps how many a pubkey may backfill a day,
+// so that the exemption can't be used as an unlimited write channel.
+type Backfill struct {
+	DailyCap int // events a pubkey may backfill a day, 0 means no cap
+
+	mu      sync.Mutex
+	today   map[string]int // events each pubkey backfilled on the last day of history
+	history []BackfillDay  // oldest first, at most backfillDays
+}
+
+// BackfillDay is the backfill usage of a day (UTC).
+type BackfillDay struct {
+	Date    string `json:"date"`
+	Events  int    `json:"events"`  // events that skipped rate limiting
+	Pubkeys int    `json:"pubkeys"` // pubkeys that backfilled
+	Capped  int    `json:"capped"`  // events over the daily cap, rate limited instead
+}
+
+// BackfillUsage is the number of events a pubkey backfilled on a day.
+type BackfillUsage struct {
+	Pubkey string `json:"pubkey"`
+	Events int    `json:"events"`
+}
+
+// BackfillReport is the backfill usage of the day, by pubkey, with the daily history.
+type BackfillReport struct {
+	DailyCap int             `json:"daily_cap"`
+	Top      []BackfillUsage `json:"top"` // the heaviest users of the day, most events first
+	History  []BackfillDay   `json:"history"`
+}
+
+// backfillPolicy returns the backfill policy of the tier: normal when its backfill flag is off,
+// and free when the flag was switched on for a tier configured as normal.
+func backfillPolicy(tier Tier, cfg Config, flags *FeatureFlags) string {
+	if !flags.Enabled(FlagBackfill, tier) {
+		return backfillNormal
+	}
+	if policy := cfg.BackfillPolicies[tier]; policy != "" && policy != backfillNormal {
+		return policy
+	}
+	return backfillFree
+}
+
+func NewBackfill(dailyCap int) *Backfill {
+	return &Backfill{DailyCap: dailyCap, today: make(map[string]int)}
+}
+
+// Use records that the pubkey backfills an event, and reports whether it may skip rate
+// limiting: it may not once it reached DailyCap today. A nil Backfill allows everything.
+func (b *Backfill) Use(pubkey string, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	date := now.UTC().Format(time.DateOnly)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.history) == 0 || b.history[len(b.history)-1].Date != date {
+		b.history = append(b.history, BackfillDay{Date: date})
+		if len(b.history) > backfillDays {
+			b.history = b.history[len(b.history)-backfillDays:]
+		}
+		clear(b.today)
+	}
+	day := &b.history[len(b.history)-1]
+
+	if b.DailyCap > 0 && b.today[pubkey] >= b.DailyCap {
+		day.Capped++
+		return false
+	}
+	if b.today[pubkey] == 0 {
+		day.Pubkeys++
+	}
+	b.today[pubkey]++
+	day.Events++
+	return true
+}
+
+// Report returns the heaviest backfill users of the last day of history, and the history.
+func (b *Backfill) Report() BackfillReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	report := BackfillReport{DailyCap: b.DailyCap, History: slices.Clone(b.history)}
+	for _, pubkey := range slices.Sorted(maps.Keys(b.today)) {
+		report.Top = append(report.Top, BackfillUsage{Pubkey: pubkey, Events: b.today[pubkey]})
+	}
+	slices.SortStableFunc(report.Top, func(a, b BackfillUsage) int { return cmp.Compare(b.Events, a.Events) })
+	if len(report.Top) > backfillTopPubkeys {
+		report.Top = report.Top[:backfillTopPubkeys]
+	}
+	return report
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBackfillCap(t *testing.T) {
+	b := NewBackfill(2)
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := b.Use("alice", day); got != want {
+			t.Errorf("event %d of alice: expected %v, got %v", i, want, got)
+		}
+	}
+	if !b.Use("bob", day) {
+		t.Error("the cap is per pubkey")
+	}
+
+	// The cap starts over the next day (UTC)
+	if !b.Use("alice", day.Add(12*time.Hour)) {
+		t.Error("expected alice to backfill again the next day")
+	}
+
+	report := b.Report()
+	want := []BackfillDay{
+		{Date: "2026-03-01", Events: 3, Pubkeys: 2, Capped: 2},
+		{Date: "2026-03-02", Events: 1, Pubkeys: 1},
+	}
+	if len(report.History) != 2 || report.History[0] != want[0] || report.History[1] != want[1] {
+		t.Errorf("history: expected %+v, got %+v", want, report.History)
+	}
+	if len(report.Top) != 1 || report.Top[0] != (BackfillUsage{Pubkey: "alice", Events: 1}) {
+		t.Errorf("top: expected alice's usage of the day, got %+v", report.Top)
+	}
+}
+
+func TestBackfillReport(t *testing.T) {
+	b := NewBackfill(0)
+	now := time.Now()
+	for i := range backfillTopPubkeys + 5 {
+		for range i + 1 {
+			b.Use(string(rune('a'+i)), now)
+		}
+	}
+
+	report := b.Report()
+	if len(report.Top) != backfillTopPubkeys || report.Top[0].Events != backfillTopPubkeys+5 {
+		t.Fatalf("expected the %d heaviest users, most events first, got %+v", backfillTopPubkeys, report.Top)
+	}
+	for i := 1; i < len(report.Top); i++ {
+		if report.Top[i].Events > report.Top[i-1].Events {
+			t.Errorf("top is not sorted: %+v", report.Top)
+		}
+	}
+	if report.History[0].Capped != 0 {
+		t.Errorf("no event is capped without a cap, got %d", report.History[0].Capped)
+	}
+}
+
+func TestBackfillDailyCap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"HIGH_THRESHOLD": "0.9", "BACKFILL_DAILY_CAP": "2"}[key]
+	})
+	obs := &Observability{}
+	db := newTestDB(t)
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0.95})
+
+	for i := range 3 {
+		e := &nostr.Event{Kind: 1, CreatedAt: nostr.Now() - 3*86400 + nostr.Timestamp(i), Content: "old note"}
+		e.Sign(sk)
+		if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	if got := obs.backfillCount.Load(); got != 2 {
+		t.Errorf("expected 2 events to be backfilled, got %d", got)
+	}
+	if got := obs.backfillCappedCount.Load(); got != 1 {
+		t.Errorf("expected the event over the cap to be rate limited instead, got %d", got)
+	}
+}
+
+func TestBackfillPolicies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, err := buildConfig(func(key string) string {
+		return map[string]string{
+			"HIGH_THRESHOLD":       "0.9",
+			"BACKFILL_POLICY_LOW":  "reject",
+			"BACKFILL_POLICY_MID":  "Discount",
+			"BACKFILL_POLICY_HIGH": "normal",
+			"BACKFILL_DISCOUNT":    "0.25",
+		}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := &Observability{}
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+	if d.Flags.Enabled(FlagBackfill, TierHigh) {
+		t.Error("a normal policy must switch the backfill flag off")
+	}
+
+	publish := func(rank float64, age time.Duration) (string, error) {
+		sk := nostr.GeneratePrivateKey()
+		pubkey, _ := nostr.GetPublicKey(sk)
+		cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: rank})
+		e := &nostr.Event{Kind: 1, CreatedAt: nostr.Timestamp(time.Now().Add(-age).Unix()), Content: "note of " + pubkey}
+		e.Sign(sk)
+		return pubkey, handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+	}
+
+	if _, err := publish(0, 48*time.Hour); !errors.Is(err, ErrEventTooOld) {
+		t.Errorf("old low tier event: got %v, want %v", err, ErrEventTooOld)
+	}
+	if _, err := publish(0, time.Hour); err != nil {
+		t.Errorf("recent low tier event: %v", err)
+	}
+
+	pubkey, err := publish(0.7, 48*time.Hour)
+	if err != nil {
+		t.Fatalf("old mid tier event: %v", err)
+	}
+	capacity := calculateDailyRate(0.7, cfg) / 24
+	if tokens := d.Limiter.(*Limiter).GetTokens(pubkey); tokens < capacity-0.26 || tokens > capacity-0.24 {
+		t.Errorf("expected the old event to cost a quarter token out of %v, %v left", capacity, tokens)
+	}
+	if got := obs.backfillDiscountedCount.Load(); got != 1 {
+		t.Errorf("expected 1 discounted event, got %d", got)
+	}
+
+	// Switching the flag off makes old events normal
+	d.Flags.Set(FlagBackfill, TierLow, false)
+	if _, err := publish(0, 48*time.Hour); err != nil {
+		t.Errorf("old low tier event with the flag off: %v", err)
+	}
+	// Switching it on for a normal tier makes backfill free
+	d.Flags.Set(FlagBackfill, TierHigh, true)
+	if _, err := publish(0.95, 48*time.Hour); err != nil || obs.backfillCount.Load() != 1 {
+		t.Errorf("old high tier event with the flag on: %v, %d backfilled", err, obs.backfillCount.Load())
+	}
+
+	if _, err := buildConfig(func(key string) string {
+		return map[string]string{"BACKFILL_POLICY_MID": "maybe"}[key]
+	}); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,241 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ErrBlocklisted is returned for events listed by a blocklist the relay subscribes to.
+var ErrBlocklisted = errors.New("blocked: event is on a blocklist")
+
+// maxBlocklist bounds the size of a blocklist fetched from a source.
+const maxBlocklist = 64 << 20
+
+// blocklistPurgeBatch is how many entries a store query looks up when purging listed events.
+const blocklistPurgeBatch = 500
+
+// Blocklist refuses to store or serve the events listed by externally published blocklists,
+// like the hash lists of abuse material or the feeds of moderation services. Each source is
+// a URL or a file, listing one hex hash per line: an event ID, or the SHA-256 of a file that
+// events reference in an "x" tag (NIP-94) or an "imeta" tag (NIP-92). Blank lines and
+// anything after a "#" are ignored, as is everything after the first field of a line.
+type Blocklist struct {
+	sources []string
+	fetch   func(ctx context.Context, source string) ([]byte, error)
+
+	mu      sync.RWMutex
+	lists   map[string]map[string]bool // entries of each source, as last fetched
+	entries map[string]string          // source of each entry
+	synced  time.Time
+}
+
+// BlocklistStatus is the merged state of the blocklists, as served by /stats.
+type BlocklistStatus struct {
+	Entries int       `json:"entries"`
+	Sources int       `json:"sources"` // sources fetched at least once
+	Synced  time.Time `json:"synced,omitzero"`
+}
+
+func NewBlocklist(cfg Config) *Blocklist {
+	b := &Blocklist{sources: cfg.BlocklistSources, lists: make(map[string]map[string]bool), entries: make(map[string]string)}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	b.fetch = func(ctx context.Context, source string) ([]byte, error) {
+		if !isURL(source) {
+			return os.ReadFile(source)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxBlocklist+1))
+		if err == nil && len(data) > maxBlocklist {
+			err = fmt.Errorf("list is larger than %d bytes", maxBlocklist)
+		}
+		return data, err
+	}
+	return b
+}
+
+// parseBlocklist returns the hex hashes listed in the data.
+func parseBlocklist(data []byte) map[string]bool {
+	entries := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) == 0 {
+			continue
+		}
+		if entry := strings.ToLower(fields[0]); nostr.IsValid32ByteHex(entry) {
+			entries[entry] = true
+		}
+	}
+	return entries
+}
+
+// Blocked returns the entry listing the event, and its source, if any.
+func (b *Blocklist) Blocked(e *nostr.Event) (entry, source string, ok bool) {
+	if b == nil {
+		return "", "", false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.entries) == 0 {
+		return "", "", false
+	}
+
+	if source, ok := b.entries[e.ID]; ok {
+		return e.ID, source, true
+	}
+	for _, hash := range fileHashes(e) {
+		if source, ok := b.entries[hash]; ok {
+			return hash, source, true
+		}
+	}
+	return "", "", false
+}
+
+// fileHashes returns the SHA-256 hashes of the files the event references.
+func fileHashes(e *nostr.Event) []string {
+	var hashes []string
+	for _, tag := range e.Tags {
+		switch {
+		case len(tag) < 2:
+		case tag[0] == "x":
+			hashes = append(hashes, strings.ToLower(tag[1]))
+		case tag[0] == "imeta":
+			for _, field := range tag[1:] {
+				if hash, ok := strings.CutPrefix(field, "x "); ok {
+					hashes = append(hashes, strings.ToLower(strings.TrimSpace(hash)))
+				}
+			}
+		}
+	}
+	return hashes
+}
+
+// Sync fetches the lists of the sources, merges them, and removes the stored events they
+// newly list. A source failing keeps its previous list, unless all do.
+func (b *Blocklist) Sync(ctx context.Context, d *Deps) error {
+	lists := make(map[string]map[string]bool, len(b.sources))
+	var errs []error
+	for _, source := range b.sources {
+		data, err := b.fetch(ctx, source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			continue
+		}
+		lists[source] = parseBlocklist(data)
+	}
+	if len(lists) == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		relayLog.WarnContext(ctx, "failed to fetch blocklist", "error", err)
+	}
+
+	b.mu.Lock()
+	for source, list := range lists {
+		added, removed := 0, 0
+		for entry := range list {
+			if !b.lists[source][entry] {
+				added++
+			}
+		}
+		for entry := range b.lists[source] {
+			if !list[entry] {
+				removed++
+			}
+		}
+		b.lists[source] = list
+		relayLog.InfoContext(ctx, "fetched blocklist", "source", source, "entries", len(list), "added", added, "removed", removed)
+	}
+	previous := b.entries
+	b.entries = make(map[string]string)
+	for _, source := range b.sources {
+		for entry := range b.lists[source] {
+			if _, ok := b.entries[entry]; !ok {
+				b.entries[entry] = source
+			}
+		}
+	}
+	var added []string
+	for entry := range b.entries {
+		if _, ok := previous[entry]; !ok {
+			added = append(added, entry)
+		}
+	}
+	b.synced = d.now()
+	entries := len(b.entries)
+	b.mu.Unlock()
+
+	slices.Sort(added)
+	purged := b.purge(ctx, added, d)
+	relayLog.InfoContext(ctx, "merged blocklists", "lists", len(lists), "entries", entries, "added", len(added), "purged", purged)
+	return nil
+}
+
+// purge removes the stored events listed by the entries, by ID or by the hash of a file
+// in an "x" tag, and returns how many were removed. Events referencing a listed file in
+// an "imeta" tag, which the store doesn't index, are only withheld by Query.
+func (b *Blocklist) purge(ctx context.Context, entries []string, d *Deps) int {
+	purged := 0
+	for batch := range slices.Chunk(entries, blocklistPurgeBatch) {
+		for _, filter := range []nostr.Filter{{IDs: batch}, {Tags: nostr.TagMap{"x": batch}}} {
+			events, err := d.DB.QueryEvents(ctx, filter)
+			if err != nil {
+				return purged
+			}
+			var listed []*nostr.Event
+			for e := range events {
+				listed = append(listed, e)
+			}
+			for _, e := range listed {
+				if err := d.DB.DeleteEvent(ctx, e); err != nil {
+					eventLog.ErrorContext(ctx, "failed to delete event", "event", e.ID, "error", err)
+					continue
+				}
+				d.Retention.Deleted(e)
+				if d.Latest != nil {
+					d.Latest.Removed(e)
+				}
+				d.Obs.blocklistPurgedCount.Add(1)
+				entry, source, _ := b.Blocked(e)
+				relayLog.WarnContext(ctx, "removed blocklisted event", "event", e.ID, "pubkey", e.PubKey, "kind", e.Kind, "entry", entry, "source", source)
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+// Status returns the number of entries and sources in the last merge.
+func (b *Blocklist) Status() BlocklistStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return BlocklistStatus{Entries: len(b.entries), Sources: len(b.lists), Synced: b.synced}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	id, hash := strings.Repeat("ab", 32), strings.Repeat("CD", 32)
+	entries := parseBlocklist([]byte("# hashes of known abuse material\n\n" + id + "\n" + hash + ",2024-05-01,csam # reported\nnot a hash\n" + id[:10] + "\n"))
+	if len(entries) != 2 || !entries[id] || !entries[strings.ToLower(hash)] {
+		t.Errorf("expected the two hashes, lowercased, got %v", entries)
+	}
+}
+
+func TestBlocklistSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, clock)
+
+	sk := nostr.GeneratePrivateKey()
+	fileHash, imetaHash := strings.Repeat("1f", 32), strings.Repeat("2e", 32)
+	note := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: "listed by ID"}
+	file := &nostr.Event{Kind: kindFileMetadata, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"x", fileHash}}}
+	picture := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: "listed by file", Tags: nostr.Tags{{"imeta", "url https://example.com/a.jpg", "x " + imetaHash}}}
+	kept := &nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Now(), Content: "not listed"}
+	for _, e := range []*nostr.Event{note, file, picture, kept} {
+		e.Sign(sk)
+		if err := Save(ctx, e, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lists := map[string]string{
+		"https://lists.example.com/csam.txt": fileHash + "\n" + imetaHash + "\n",
+		"/etc/wotrlay/blocklist.txt":         "# moderation feed\n" + note.ID + "\n",
+	}
+	d.Blocklist = NewBlocklist(Config{BlocklistSources: []string{"https://lists.example.com/csam.txt", "/etc/wotrlay/blocklist.txt"}})
+	d.Blocklist.fetch = func(_ context.Context, source string) ([]byte, error) {
+		if list, ok := lists[source]; ok {
+			return []byte(list), nil
+		}
+		return nil, errors.New("unreachable")
+	}
+	if err := d.Blocklist.Sync(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if status := d.Blocklist.Status(); status.Entries != 3 || status.Sources != 2 {
+		t.Errorf("expected 3 entries from 2 sources, got %+v", status)
+	}
+
+	// Events listed by ID or by an "x" tag are removed, those listed by an "imeta" tag withheld
+	if got := obs.blocklistPurgedCount.Load(); got != 2 {
+		t.Errorf("expected 2 events purged, got %d", got)
+	}
+	served, err := Query(ctx, ipClient{ip: "203.0.113.7"}, nostr.Filters{{Kinds: []int{nostr.KindTextNote, kindFileMetadata}}}, cfg, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || served[0].ID != kept.ID {
+		t.Errorf("expected only the unlisted event to be served, got %d events", len(served))
+	}
+	if stored := queryStored(t, ctx, d, nostr.Filter{IDs: []string{picture.ID}}); len(stored) != 1 || obs.blocklistWithheldCount.Load() != 1 {
+		t.Errorf("expected the event referencing a listed file in imeta to be withheld, got %d stored", len(stored))
+	}
+
+	// Listed events are refused, even when resubmitted
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, note, cfg, d); !errors.Is(err, ErrBlocklisted) {
+		t.Errorf("expected the listed event to be refused, got %v", err)
+	}
+	if entry, source, _ := d.Blocklist.Blocked(file); entry != fileHash || source != "https://lists.example.com/csam.txt" {
+		t.Errorf("expected the file to be listed by its hash, got %q from %q", entry, source)
+	}
+
+	// A source failing keeps its previous list, and all of them failing fails the sync
+	delete(lists, "/etc/wotrlay/blocklist.txt")
+	if err := d.Blocklist.Sync(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := d.Blocklist.Blocked(note); !ok {
+		t.Error("expected the list of the failing source to be kept")
+	}
+	clear(lists)
+	if err := d.Blocklist.Sync(ctx, d); err == nil {
+		t.Error("expected an error when no source can be fetched")
+	}
+
+	var disabled *Blocklist
+	if _, _, ok := disabled.Blocked(note); ok {
+		t.Error("a nil Blocklist lists nothing")
+	}
+}
@@ -0,0 +1,214 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// candidateRecentDiffs is how many of the last decisions that differ a CandidateReport lists.
+const candidateRecentDiffs = 50
+
+// CandidateReport summarizes how the decisions of the candidate config differ from those
+// of the active config, since the relay started or the report was reset.
+type CandidateReport struct {
+	Since      time.Time         `json:"since"`
+	Changes    map[string]string `json:"changes"` // variables the candidate config sets
+	SampleRate float64           `json:"sample_rate"`
+
+	Evaluated     int            `json:"evaluated"`      // events decided about by both configs
+	Agreed        int            `json:"agreed"`         // events both accept, or reject for the same reason
+	NewlyAccepted map[string]int `json:"newly_accepted"` // active rejection reason -> events the candidate accepts
+	NewlyRejected map[string]int `json:"newly_rejected"` // candidate rejection reason -> events the active config accepts
+	Changed       map[string]int `json:"changed"`        // "active reason -> candidate reason" -> events both reject
+
+	Recent []CandidateDiff `json:"recent"` // the last decisions that differ, most recent first
+}
+
+// CandidateDiff is an event the candidate config decides about differently.
+// Active and Candidate are "accepted", or the rejection reason.
+type CandidateDiff struct {
+	Time      time.Time `json:"time"`
+	EventID   string    `json:"event_id"`
+	Pubkey    string    `json:"pubkey"`
+	Kind      int       `json:"kind"`
+	Active    string    `json:"active"`
+	Candidate string    `json:"candidate"`
+}
+
+// Candidate runs a candidate policy configuration in shadow mode: the events of a sample
+// of the pubkeys also go through the policy pipeline under the candidate config, which
+// never stores them, and the decisions are compared with those of the active config, so
+// that threshold changes can be evaluated on live traffic before they're rolled out.
+//
+// Sampling is by pubkey rather than by event, so that the candidate's rate limits see
+// every event of the pubkeys they apply to. The candidate has its own token buckets,
+// duplicate content window, backfill caps and feature flags; ranks, bans, onboarding
+// stages and the spam wave controller are the relay's.
+type Candidate struct {
+	cfg        Config
+	changes    map[string]string
+	sampleRate float64
+	d          *Deps // the shadow dependencies of the candidate
+	obs        *Observability
+
+	mu     sync.Mutex
+	report CandidateReport
+}
+
+// NewCandidate returns the Candidate of the relay of d, whose config is that of the relay,
+// read with getenv, changed by the variables of the CandidateFile.
+// It returns nil if CandidateFile is unset.
+func NewCandidate(ctx context.Context, cfg Config, getenv func(string) string, d *Deps) (*Candidate, error) {
+	if cfg.CandidateFile == "" {
+		return nil, nil
+	}
+	changes, err := godotenv.Read(cfg.CandidateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CANDIDATE_FILE: %w", err)
+	}
+	candidate, err := buildConfig(tenantEnv(changes, getenv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid candidate config: %w", err)
+	}
+	return newCandidate(ctx, candidate, changes, cfg.CandidateSampleRate, d), nil
+}
+
+// newCandidate returns the Candidate evaluating the candidate config against the relay of d.
+//
+// The candidate shares the zap and file validators of the relay: they cache what they
+// fetch, and fetch in the background once per URL, so the candidate deciding about an
+// event first doesn't fetch anything the relay won't look up next. Its quotas are its own.
+func newCandidate(ctx context.Context, candidate Config, changes map[string]string, sampleRate float64, d *Deps) *Candidate {
+	shadow := &Deps{
+		Name:          d.Name,
+		Shadow:        true,
+		Cache:         d.Cache,
+		Limiter:       NewLimiter(ctx),
+		GlobalLimiter: d.GlobalLimiter,
+		DB:            d.DB,
+		Meta:          d.Meta,
+		Obs:           &Observability{},
+		Retention:     d.Retention,
+		StoreHealth:   d.StoreHealth,
+		Linkage:       d.Linkage,
+		Blocklist:     d.Blocklist,
+		Dedup:         NewContentDedup(ctx, time.Duration(candidate.DuplicateContentWindowMinutes)*time.Minute),
+		Zaps:          d.Zaps,
+		ZapTrust:      NewZapTrust(),
+		Media:         d.Media,
+		Flags:         NewFeatureFlags(candidate),
+		URLs:          NewURLDetector(candidate.URLTLDValidation, candidate.URLExtraTLDs),
+		Payments:      d.Payments,
+		Backfill:      NewBackfill(candidate.BackfillDailyCap),
+		Clock:         d.Clock,
+	}
+	if candidate.OnboardingEnabled {
+		shadow.Onboarding = d.Onboarding
+	}
+	if candidate.AdaptiveEnabled {
+		shadow.Adaptive = d.Adaptive
+	}
+
+	c := &Candidate{cfg: candidate, changes: changes, sampleRate: sampleRate, d: shadow, obs: d.Obs}
+	c.Reset(d.now())
+	return c
+}
+
+// Decide returns the decision of the candidate config about the event, if its author is
+// sampled. It must be called before the relay decides, so that both see the same store.
+// A nil Candidate samples nothing.
+func (c *Candidate) Decide(ctx context.Context, client rely.Client, e *nostr.Event) (sampled bool, err error) {
+	if c == nil || !c.sampled(e.PubKey) {
+		return false, nil
+	}
+	return true, handleEvent(ctx, client, e, c.cfg, c.d)
+}
+
+// sampled returns whether the events of the pubkey are evaluated.
+func (c *Candidate) sampled(pubkey string) bool {
+	if c.sampleRate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(pubkey))
+	return float64(h.Sum64()) < c.sampleRate*math.MaxUint64
+}
+
+// Compare records the decisions of the active config (active) and of the candidate
+// config (candidate) about the event, nil meaning accepted.
+func (c *Candidate) Compare(e *nostr.Event, active, candidate error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.obs.candidateEvaluatedCount.Add(1)
+	c.report.Evaluated++
+	activeDecision, candidateDecision := decisionOf(active), decisionOf(candidate)
+	switch {
+	case activeDecision == candidateDecision:
+		c.report.Agreed++
+		return
+	case candidate == nil:
+		c.report.NewlyAccepted[activeDecision]++
+	case active == nil:
+		c.report.NewlyRejected[candidateDecision]++
+	default:
+		c.report.Changed[activeDecision+" -> "+candidateDecision]++
+	}
+
+	c.obs.candidateDifferedCount.Add(1)
+	diff := CandidateDiff{Time: now, EventID: e.ID, Pubkey: e.PubKey, Kind: e.Kind, Active: activeDecision, Candidate: candidateDecision}
+	c.report.Recent = slices.Insert(c.report.Recent, 0, diff)
+	if len(c.report.Recent) > candidateRecentDiffs {
+		c.report.Recent = c.report.Recent[:candidateRecentDiffs]
+	}
+}
+
+// decisionOf returns "accepted" if err is nil, and the rejection reason otherwise.
+func decisionOf(err error) string {
+	if err == nil {
+		return "accepted"
+	}
+	return err.Error()
+}
+
+// Report returns the comparison of the decisions so far.
+func (c *Candidate) Report() CandidateReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := c.report
+	report.NewlyAccepted = maps.Clone(c.report.NewlyAccepted)
+	report.NewlyRejected = maps.Clone(c.report.NewlyRejected)
+	report.Changed = maps.Clone(c.report.Changed)
+	report.Recent = slices.Clone(c.report.Recent)
+	return report
+}
+
+// Reset starts the comparison over.
+func (c *Candidate) Reset(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.report = CandidateReport{
+		Since:         now,
+		Changes:       c.changes,
+		SampleRate:    c.sampleRate,
+		NewlyAccepted: make(map[string]int),
+		NewlyRejected: make(map[string]int),
+		Changed:       make(map[string]int),
+		Recent:        []CandidateDiff{},
+	}
+}
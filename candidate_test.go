@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCandidateShadow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, clock)
+
+	changes := map[string]string{"LOW_TIER_KINDS": "7"}
+	candidate, err := buildConfig(func(key string) string { return changes[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Candidate = newCandidate(ctx, candidate, changes, 1, d)
+	if d.Candidate.d.Zaps != d.Zaps || d.Candidate.d.Media != d.Media || d.Candidate.d.Limiter == d.Limiter {
+		t.Error("expected the candidate to share the validators of the relay, but not its quotas")
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	d.Cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0})
+	publish := func(kind int) (active, shadow error) {
+		e := &nostr.Event{Kind: kind, CreatedAt: nostr.Now(), Content: "event of kind " + string(rune('0'+kind))}
+		e.Sign(sk)
+		sampled, shadow := d.Candidate.Decide(ctx, ipClient{ip: "203.0.113.7"}, e)
+		if !sampled {
+			t.Fatal("every pubkey is sampled at a rate of 1")
+		}
+		active = handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+		d.Candidate.Compare(e, active, shadow, time.Now())
+		return active, shadow
+	}
+
+	if active, shadow := publish(1); active != nil || !errors.Is(shadow, ErrKindNotAllowed) {
+		t.Errorf("kind 1: expected the active config to accept and the candidate to reject, got %v and %v", active, shadow)
+	}
+	if active, shadow := publish(7); !errors.Is(active, ErrKindNotAllowed) || shadow != nil {
+		t.Errorf("kind 7: expected the candidate to accept and the active config to reject, got %v and %v", active, shadow)
+	}
+	if _, shadow := publish(6); !errors.Is(shadow, ErrKindNotAllowed) {
+		t.Errorf("kind 6: expected both configs to reject, got %v", shadow)
+	}
+
+	report := d.Candidate.Report()
+	if report.Evaluated != 3 || report.Agreed != 1 {
+		t.Errorf("expected 3 events evaluated and 1 agreed, got %+v", report)
+	}
+	if report.NewlyAccepted[ErrKindNotAllowed.Error()] != 1 || report.NewlyRejected[ErrKindNotAllowed.Error()] != 1 {
+		t.Errorf("expected a newly accepted and a newly rejected event, got %v and %v", report.NewlyAccepted, report.NewlyRejected)
+	}
+	if len(report.Recent) != 2 || report.Recent[0].Kind != 7 || report.Recent[0].Candidate != "accepted" {
+		t.Errorf("expected the last differences, most recent first, got %+v", report.Recent)
+	}
+	if obs.candidateEvaluatedCount.Load() != 3 || obs.candidateDifferedCount.Load() != 2 {
+		t.Errorf("unexpected metrics: %d evaluated, %d differed", obs.candidateEvaluatedCount.Load(), obs.candidateDifferedCount.Load())
+	}
+
+	// The candidate never stores the events it accepts
+	if n, _ := d.DB.CountEvents(ctx, nostr.Filter{Kinds: []int{7}}); n != 0 {
+		t.Errorf("expected the candidate's decision not to be stored, got %d events", n)
+	}
+
+	d.Candidate.Reset(time.Now())
+	if report := d.Candidate.Report(); report.Evaluated != 0 || len(report.NewlyAccepted) != 0 || len(report.Recent) != 0 {
+		t.Errorf("expected an empty report after a reset, got %+v", report)
+	}
+}
+
+func TestCandidateSampling(t *testing.T) {
+	c := &Candidate{sampleRate: 0.25}
+	sampled := 0
+	for range 1000 {
+		pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		if c.sampled(pubkey) {
+			sampled++
+			if !c.sampled(pubkey) {
+				t.Fatal("a pubkey must stay sampled")
+			}
+		}
+	}
+	if sampled < 180 || sampled > 320 {
+		t.Errorf("expected about a quarter of the pubkeys to be sampled, got %d out of 1000", sampled)
+	}
+
+	var disabled *Candidate
+	if sampled, _ := disabled.Decide(context.Background(), ipClient{}, &nostr.Event{}); sampled {
+		t.Error("a nil Candidate samples nothing")
+	}
+}
+
+func TestNewCandidate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "candidate.env")
+	if err := os.WriteFile(path, []byte("MID_THRESHOLD=0.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CANDIDATE_FILE": path, "RATE_MULTIPLIER": "2"}
+	cfg := parseConfig(func(key string) string { return env[key] })
+	obs := &Observability{}
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, &replayClock{})
+
+	c, err := NewCandidate(ctx, cfg, func(key string) string { return env[key] }, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.cfg.MidThreshold != 0.4 || c.cfg.RateMultiplier != 2 || c.sampleRate != 0.1 {
+		t.Errorf("expected the relay's config with the candidate's changes, got %v %v at %v", c.cfg.MidThreshold, c.cfg.RateMultiplier, c.sampleRate)
+	}
+	if report := c.Report(); report.Changes["MID_THRESHOLD"] != "0.4" {
+		t.Errorf("expected the report to list the changes, got %v", report.Changes)
+	}
+
+	if err := os.WriteFile(path, []byte("MID_THRESHOLD=2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCandidate(ctx, cfg, func(key string) string { return env[key] }, d); err == nil {
+		t.Error("expected an error for an invalid candidate config")
+	}
+	if c, err := NewCandidate(ctx, Config{}, os.Getenv, d); c != nil || err != nil {
+		t.Errorf("expected no candidate without CANDIDATE_FILE, got %v (%v)", c, err)
+	}
+}
+
+func TestAdminCandidate(t *testing.T) {
+	cfg := Config{AdminToken: "secret"}
+	d := &Deps{Name: "default", Obs: &Observability{}, Flags: NewFeatureFlags(cfg)}
+	serve := func(method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/candidate", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		adminHandler(w, r, "/", cfg, d)
+		return w
+	}
+
+	if w := serve(http.MethodGet); w.Code != http.StatusNotFound {
+		t.Errorf("without a candidate: got status %d, want 404", w.Code)
+	}
+
+	d.Candidate = &Candidate{changes: map[string]string{"MID_THRESHOLD": "0.4"}, sampleRate: 0.1, obs: d.Obs}
+	d.Candidate.Reset(time.Now())
+	d.Candidate.Compare(&nostr.Event{ID: "1", Kind: 1}, nil, ErrRateLimited, time.Now())
+
+	var report CandidateReport
+	w := serve(http.MethodGet)
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.NewlyRejected[ErrRateLimited.Error()] != 1 {
+		t.Errorf("unexpected report %q (%v)", w.Body.String(), err)
+	}
+	w = serve(http.MethodDelete)
+	if !strings.Contains(w.Body.String(), `"evaluated":0`) {
+		t.Errorf("expected a reset report, got %q", w.Body.String())
+	}
+}
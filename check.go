@@ -0,0 +1,144 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pippellia-btc/rely"
+)
+
+// WriteCheck tells a client whether a pubkey can publish to the relay, and how much.
+type WriteCheck struct {
+	Pubkey    string      `json:"pubkey"`
+	Rank      float64     `json:"rank"`
+	Tier      string      `json:"tier"`
+	Pending   bool        `json:"rank_pending,omitempty"`     // the rank isn't known yet, and is being looked up
+	Stage     string      `json:"onboarding_stage,omitempty"` // for pubkeys going through onboarding
+	CanWrite  bool        `json:"can_write"`
+	Reason    string      `json:"reason,omitempty"`         // why the pubkey can't write
+	Kinds     []KindRange `json:"kinds,omitempty"`          // kinds the pubkey may publish, all if empty
+	DailyRate float64     `json:"daily_rate"`               // events per day the rate limit allows
+	Tokens    float64     `json:"tokens"`                   // events the pubkey can publish right now
+	Capacity  float64     `json:"capacity"`                 // tokens the pubkey's bucket holds when full
+	RefillIn  int         `json:"refill_in"`                // seconds until the bucket is full again
+	PoW       int         `json:"pow_difficulty,omitempty"` // NIP-13 difficulty paying for an event instead of tokens
+	Member    time.Time   `json:"member_until,omitzero"`    // when the pubkey's paid membership expires
+	Backfill  string      `json:"backfill,omitempty"`       // backfill policy of events older than 24h
+}
+
+// checkWrite returns what the relay's policy allows the pubkey to write.
+// Shadow bans and ban evasion suspicion are not disclosed.
+func checkWrite(ctx context.Context, pubkey string, cfg Config, d *Deps) WriteCheck {
+	if d.Linkage.IsBanned(pubkey) {
+		return WriteCheck{Pubkey: pubkey, Tier: TierLow.String(), Reason: ErrBanned.Error()}
+	}
+
+	rank, known := checkRank(pubkey, cfg, d)
+	if cfg.ZapTrustEnabled {
+		rank = d.ZapTrust.Boost(ctx, pubkey, rank, cfg, d)
+	}
+	tier := tierFor(rank, cfg)
+
+	// mirror the pubkey token bucket of handleEvent, for an event of unit cost
+	dailyRate := calculateDailyRate(rank, cfg)
+	var stage string
+	if isOnboarding(pubkey, rank, cfg, d) {
+		onboardingStage := d.Onboarding.Peek(pubkey, d.now())
+		dailyRate = onboardingDailyRate(onboardingStage, cfg)
+		stage = onboardingStage.String()
+	}
+	if d.Adaptive != nil && tier == TierLow {
+		dailyRate *= d.Adaptive.RateFactor()
+	}
+	refillRate := dailyRate / secondsPerDay
+	capacity := max(dailyRate/24.0, 1)
+
+	check := WriteCheck{
+		Pubkey:    pubkey,
+		Rank:      rank,
+		Tier:      tier.String(),
+		Pending:   !known,
+		Stage:     stage,
+		CanWrite:  true,
+		DailyRate: dailyRate,
+		Capacity:  capacity,
+	}
+	if d.Limiter != nil {
+		check.Tokens = d.Limiter.Peek(pubkey, capacity, refillRate)
+		if refillRate > 0 {
+			check.RefillIn = int(math.Ceil((capacity - check.Tokens) / refillRate))
+		}
+	}
+	if rank <= 0 {
+		check.PoW = cfg.UnrankedPoWDifficulty
+	}
+	if d.Flags != nil {
+		check.Backfill = backfillPolicy(tier, cfg, d.Flags)
+	}
+	if d.Payments != nil {
+		check.Member, _ = d.Payments.Member(pubkey, d.now())
+	}
+	if err := checkWriteWindow(tier, d.now(), cfg); err != nil {
+		check.CanWrite, check.Reason = false, err.Error()
+	}
+	if kinds := allowedKinds(tier, cfg); !kinds.Any {
+		check.Kinds = kinds.Ranges
+	}
+	return check
+}
+
+// checkRank returns the rank of the pubkey as the relay knows it, and whether it is known.
+// Checks never wait on the rank provider nor spend the refresh budget of incoming events:
+// an unknown pubkey is checked at rank 0, and queued for an opportunistic refresh.
+func checkRank(pubkey string, cfg Config, d *Deps) (float64, bool) {
+	if rank, ok := operatorRank(pubkey, cfg); ok {
+		return rank, true
+	}
+
+	rank, known := knownRank(pubkey, cfg, d)
+	if d.Payments != nil {
+		if _, ok := d.Payments.Member(pubkey, d.now()); ok {
+			return max(rank, d.Payments.Rank), true
+		}
+	}
+	if !known {
+		d.Cache.Prefetch(pubkey)
+	}
+	return rank, known
+}
+
+// checkHandler serves GET <root>/check?pubkey=<hex or npub>, letting clients warn users
+// before they compose events destined for rejection, and reports whether the request was for it.
+// Requests are limited to CheckRatePerMinute per IP group.
+func checkHandler(w http.ResponseWriter, r *http.Request, root string, cfg Config, d *Deps) bool {
+	if r.URL.Path != path.Join(root, "check") || r.Method != http.MethodGet {
+		return false
+	}
+	group := rely.GetIP(r).Group()
+	if !d.GlobalLimiter.Allow("check:"+group, cfg.CheckRatePerMinute, cfg.CheckRatePerMinute/60) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return true
+	}
+
+	pubkey := r.URL.Query().Get("pubkey")
+	if prefix, value, err := nip19.Decode(pubkey); err == nil && prefix == "npub" {
+		pubkey = value.(string)
+	}
+	if !nostr.IsValid32ByteHex(pubkey) {
+		http.Error(w, "pubkey must be a hex pubkey or an npub", http.StatusBadRequest)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	writeJSON(w, checkWrite(ctx, pubkey, cfg, d))
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestCheckHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	newcomer, trusted, banned := strings.Repeat("01", 32), strings.Repeat("02", 32), strings.Repeat("03", 32)
+
+	cache := NewRankCache(ctx, cfg, obs)
+	cache.Update(time.Now(), PubRank{Pubkey: newcomer, Rank: 0}, PubRank{Pubkey: trusted, Rank: 0.9})
+	d := &Deps{Cache: cache, Limiter: NewLimiter(ctx), GlobalLimiter: NewLimiter(ctx), Obs: obs, Linkage: NewIPLinkage(ctx, []string{banned})}
+
+	check := func(pubkey string) (int, WriteCheck) {
+		w := httptest.NewRecorder()
+		if !checkHandler(w, httptest.NewRequest(http.MethodGet, "/check?pubkey="+pubkey, nil), "/", cfg, d) {
+			t.Fatal("request not handled")
+		}
+		var c WriteCheck
+		json.Unmarshal(w.Body.Bytes(), &c)
+		return w.Code, c
+	}
+
+	if _, c := check(newcomer); !c.CanWrite || c.Tier != "low" || len(c.Kinds) != 1 || c.Kinds[0] != (KindRange{1, 1}) || c.DailyRate != calculateDailyRate(0, cfg) {
+		t.Errorf("newcomer: got %+v", c)
+	} else if c.Tokens != c.Capacity || c.RefillIn != 0 {
+		t.Errorf("newcomer: expected a full bucket, got %+v", c)
+	}
+
+	rate := calculateDailyRate(0.9, cfg)
+	d.Limiter.Consume(trusted, 3, rate/24, rate/secondsPerDay)
+	if _, c := check(trusted); c.Capacity-c.Tokens < 2.99 || c.RefillIn <= 0 {
+		t.Errorf("trusted: expected the consumed tokens to show, got %+v", c)
+	}
+
+	npub, _ := nip19.EncodePublicKey(trusted)
+	if _, c := check(npub); !c.CanWrite || c.Pubkey != trusted || c.Tier == "low" || c.Kinds != nil {
+		t.Errorf("trusted: got %+v", c)
+	}
+
+	// Unknown pubkeys are checked at rank 0 without waiting on the rank provider
+	unknown := strings.Repeat("04", 32)
+	if _, c := check(unknown); !c.Pending || c.Rank != 0 || c.Tier != "low" {
+		t.Errorf("unknown: got %+v", c)
+	}
+	if obs.rankPrefetched.Load() != 1 || d.GlobalLimiter.Peek("global-rank-refresh", 1, 1) < 1 {
+		t.Errorf("expected the unknown pubkey to be prefetched without spending the refresh budget")
+	}
+	if _, c := check(newcomer); c.Pending {
+		t.Errorf("newcomer: expected a known rank, got %+v", c)
+	}
+
+	if _, c := check(banned); c.CanWrite || c.Reason != ErrBanned.Error() {
+		t.Errorf("banned: got %+v", c)
+	}
+
+	if code, _ := check("nobody"); code != http.StatusBadRequest {
+		t.Errorf("invalid pubkey: got status %d", code)
+	}
+
+	if checkHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/check", nil), "/", cfg, d) {
+		t.Error("only GET requests should be handled")
+	}
+}
+
+func TestCheckHandlerRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	cfg.CheckRatePerMinute = 2
+	obs := &Observability{}
+	d := &Deps{Cache: NewRankCache(ctx, cfg, obs), Limiter: NewLimiter(ctx), GlobalLimiter: NewLimiter(ctx), Obs: obs, Linkage: NewIPLinkage(ctx, nil)}
+
+	codes := make([]int, 3)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		checkHandler(w, httptest.NewRequest(http.MethodGet, "/check?pubkey="+strings.Repeat("01", 32), nil), "/", cfg, d)
+		codes[i] = w.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the third request to be rate-limited, got %v", codes)
+	}
+}
@@ -0,0 +1,62 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// command is a subcommand of wotrlay. run gets the arguments following the command's
+// name, and returns the process exit code: 2 for invalid arguments.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands are the subcommands of wotrlay, in the order `wotrlay help` lists them.
+var commands = []command{
+	{"serve", "run the relay (the default without a command)", serve},
+	{"import", "store the events of a JSONL dump", importCommand},
+	{"export", "dump the stored events as JSONL, or a labeled dataset with -labeled", export},
+	{"compact", "reclaim the disk space of the Badger stores", compactCommand},
+	{"rank", "query the rank provider, or edit the rank overrides", rankCommand},
+	{"verify", "check the integrity of the event store", verify},
+	{"replay", "simulate the policy on captured traffic", replay},
+	{"smoketest", "check a deployed relay", smoketest},
+}
+
+// runCommand runs the command of args (the arguments of the process), serve without one,
+// and returns the process exit code.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return serve(nil)
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return 2
+}
+
+// printUsage lists the commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: wotrlay [command] [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nRun `wotrlay <command> -h` for the flags of a command.")
+}
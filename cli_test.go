@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestRunCommand(t *testing.T) {
+	if code := runCommand([]string{"relay"}); code != 2 {
+		t.Errorf("unknown command: got exit code %d, want 2", code)
+	}
+	if code := runCommand([]string{"serve", "extra"}); code != 2 {
+		t.Errorf("serve with an argument: got exit code %d, want 2", code)
+	}
+	if code := runCommand([]string{"help"}); code != 0 {
+		t.Errorf("help: got exit code %d, want 0", code)
+	}
+
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		if seen[cmd.name] || cmd.summary == "" {
+			t.Errorf("command %q must be listed once, with a summary", cmd.name)
+		}
+		seen[cmd.name] = true
+	}
+}
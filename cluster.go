@@ -0,0 +1,466 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/redis/go-redis/v9"
+)
+
+// clusterPrefix namespaces every key and channel wotrlay uses in Redis.
+const clusterPrefix = "wotrlay:"
+
+// clusterLimiterRetry is how long a limiter uses node-local buckets after Redis failed,
+// before trying it again.
+const clusterLimiterRetry = 10 * time.Second
+
+// Cluster coordinates several wotrlay instances through Redis: token buckets are
+// shared, ranks fetched by one node are visible to all, and stored events are
+// gossiped to the other nodes.
+type Cluster struct {
+	client *redis.Client
+	nodeID string
+	leader atomic.Bool
+
+	// RankTTL: how long shared ranks are kept in Redis
+	RankTTL time.Duration
+
+	// LeaseTTL: how long the leader lease lasts without being renewed
+	LeaseTTL time.Duration
+
+	// MetricsInterval: how often this node's observability counters are pushed to Redis
+	MetricsInterval time.Duration
+}
+
+// clusterRanks is the message gossiped when a node fetched ranks from the provider.
+type clusterRanks struct {
+	Node      string    `json:"node"`
+	Timestamp time.Time `json:"ts"`
+	Ranks     []PubRank `json:"ranks"`
+}
+
+// clusterEvent is the message gossiped when a node stored an event.
+type clusterEvent struct {
+	Node  string       `json:"node"`
+	Event *nostr.Event `json:"event"`
+}
+
+// NewCluster connects to the Redis server at redisURL (e.g. "redis://localhost:6379/0").
+func NewCluster(ctx context.Context, redisURL, nodeID string) (*Cluster, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return &Cluster{
+		client:          client,
+		nodeID:          nodeID,
+		RankTTL:         7 * 24 * time.Hour,
+		LeaseTTL:        15 * time.Second,
+		MetricsInterval: 30 * time.Second,
+	}, nil
+}
+
+func (c *Cluster) Close() error {
+	return c.client.Close()
+}
+
+// renewScript extends the leader lease if it is still held by this node.
+// KEYS[1] = lease, ARGV = node ID, ttl (ms)
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the leader lease if it is held by this node.
+// KEYS[1] = lease, ARGV = node ID
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// IsLeader reports whether this node currently holds the leader lease, and
+// should therefore run the cluster-wide background jobs.
+func (c *Cluster) IsLeader() bool {
+	return c.leader.Load()
+}
+
+// Campaign competes for the leader lease until the context is done, renewing
+// it while held. The lease is released on exit so another node can take over.
+func (c *Cluster) Campaign(ctx context.Context) {
+	ticker := time.NewTicker(c.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		c.campaignOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			// The script only deletes the lease if this node still holds it
+			c.leader.Store(false)
+			releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			releaseScript.Run(releaseCtx, c.client, []string{clusterPrefix + "leader"}, c.nodeID)
+			cancel()
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaignOnce renews the lease if held, or tries to acquire it otherwise.
+func (c *Cluster) campaignOnce(ctx context.Context) {
+	key := clusterPrefix + "leader"
+
+	var leader bool
+	if c.leader.Load() {
+		renewed, err := renewScript.Run(ctx, c.client, []string{key}, c.nodeID, c.LeaseTTL.Milliseconds()).Int()
+		leader = err == nil && renewed == 1
+	} else {
+		acquired, err := c.client.SetNX(ctx, key, c.nodeID, c.LeaseTTL).Result()
+		leader = err == nil && acquired
+	}
+
+	if leader != c.leader.Swap(leader) {
+		if leader {
+			relayLog.Info("cluster: node is now the leader", "node", c.nodeID)
+		} else {
+			relayLog.Info("cluster: node is no longer the leader", "node", c.nodeID)
+		}
+	}
+}
+
+// QueueRefresh hands pubkeys needing a rank refresh over to the leader.
+func (c *Cluster) QueueRefresh(ctx context.Context, pubkeys []string) {
+	if len(pubkeys) == 0 {
+		return
+	}
+	members := make([]any, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		members[i] = pubkey
+	}
+	if err := c.client.SAdd(ctx, clusterPrefix+"refresh", members...).Err(); err != nil {
+		rankLog.Error("cluster: failed to queue rank refresh", "error", err)
+	}
+}
+
+// DrainRefresh pops up to max pubkeys queued for a rank refresh by other nodes.
+func (c *Cluster) DrainRefresh(ctx context.Context, max int) []string {
+	pubkeys, err := c.client.SPopN(ctx, clusterPrefix+"refresh", int64(max)).Result()
+	if err != nil {
+		rankLog.Error("cluster: failed to drain rank refresh queue", "error", err)
+		return nil
+	}
+	return pubkeys
+}
+
+// Limiter returns a rate limiter whose buckets are shared by every node of the cluster.
+// The namespace keeps the buckets of different (virtual) relays apart.
+func (c *Cluster) Limiter(ctx context.Context, namespace string) *ClusterLimiter {
+	return &ClusterLimiter{
+		client:   c.client,
+		prefix:   clusterPrefix + "bucket:" + namespace + ":",
+		fallback: NewLimiter(ctx),
+		retry:    clusterLimiterRetry,
+	}
+}
+
+// PublishRanks stores the ranks in Redis and notifies the other nodes.
+func (c *Cluster) PublishRanks(ctx context.Context, ts time.Time, ranks []PubRank) {
+	if len(ranks) == 0 {
+		return
+	}
+
+	pipe := c.client.Pipeline()
+	for _, r := range ranks {
+		value, _ := json.Marshal(TimeRank{Timestamp: ts, Rank: r.Rank})
+		pipe.Set(ctx, clusterPrefix+"rank:"+r.Pubkey, value, c.RankTTL)
+	}
+
+	message, _ := json.Marshal(clusterRanks{Node: c.nodeID, Timestamp: ts, Ranks: ranks})
+	pipe.Publish(ctx, clusterPrefix+"ranks", message)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		rankLog.Error("cluster: failed to publish ranks", "error", err)
+	}
+}
+
+// LoadRanks returns the ranks of the pubkeys shared by other nodes, if any.
+func (c *Cluster) LoadRanks(ctx context.Context, pubkeys []string) map[string]TimeRank {
+	keys := make([]string, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		keys[i] = clusterPrefix + "rank:" + pubkey
+	}
+
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		rankLog.Error("cluster: failed to load ranks", "error", err)
+		return nil
+	}
+
+	ranks := make(map[string]TimeRank, len(pubkeys))
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var rank TimeRank
+		if err := json.Unmarshal([]byte(s), &rank); err == nil {
+			ranks[pubkeys[i]] = rank
+		}
+	}
+	return ranks
+}
+
+// SyncRanks adds the ranks published by other nodes to the cache until the context is done.
+func (c *Cluster) SyncRanks(ctx context.Context, cache *RankCache) {
+	sub := c.client.Subscribe(ctx, clusterPrefix+"ranks")
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var update clusterRanks
+		if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil || update.Node == c.nodeID {
+			continue
+		}
+		cache.Update(update.Timestamp, update.Ranks...)
+	}
+}
+
+// PublishEvent gossips an event stored by this node to the other nodes serving the relay.
+func (c *Cluster) PublishEvent(ctx context.Context, relay string, e *nostr.Event) {
+	message, _ := json.Marshal(clusterEvent{Node: c.nodeID, Event: e})
+	if err := c.client.Publish(ctx, clusterPrefix+"events:"+relay, message).Err(); err != nil {
+		eventLog.ErrorContext(ctx, "cluster: failed to gossip event", "event", e.ID, "error", err)
+	}
+}
+
+// SyncEvents calls store with every event gossiped by other nodes for the relay,
+// until the context is done. Events with invalid signatures are dropped.
+func (c *Cluster) SyncEvents(ctx context.Context, relay string, store func(*nostr.Event)) {
+	sub := c.client.Subscribe(ctx, clusterPrefix+"events:"+relay)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var gossip clusterEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &gossip); err != nil || gossip.Node == c.nodeID || gossip.Event == nil {
+			continue
+		}
+		if ok, err := gossip.Event.CheckSignature(); err != nil || !ok {
+			continue
+		}
+		store(gossip.Event)
+	}
+}
+
+// bucketScript atomically refills and consumes a token bucket stored as a hash.
+// KEYS[1] = bucket, ARGV = cost, capacity, refill rate (tokens/s), now (s), ttl (s)
+var bucketScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return allowed
+`)
+
+// ClusterLimiter is a token bucket limiter backed by Redis, so that every node of
+// the cluster draws from the same buckets. If Redis is unreachable it falls back
+// to node-local buckets, without waiting for Redis until retry has passed.
+type ClusterLimiter struct {
+	client   *redis.Client
+	prefix   string
+	fallback *Limiter
+	retry    time.Duration
+
+	// unavailableUntil is when Redis is tried again after a failure, in Unix
+	// nanoseconds, or 0 while it's reachable
+	unavailableUntil atomic.Int64
+}
+
+// shared reports whether to use the shared buckets: Redis is reachable, or this call
+// is the one trying it again once retry has passed since it failed.
+func (l *ClusterLimiter) shared() bool {
+	until := l.unavailableUntil.Load()
+	if until == 0 {
+		return true
+	}
+	now := time.Now().UnixNano()
+	return now >= until && l.unavailableUntil.CompareAndSwap(until, now+int64(l.retry))
+}
+
+// failed switches to the local buckets until retry has passed, and logs it once per outage.
+func (l *ClusterLimiter) failed(err error) {
+	if l.unavailableUntil.Swap(time.Now().Add(l.retry).UnixNano()) == 0 {
+		limiterLog.Warn("cluster: shared limiter unavailable, using local buckets", "error", err, "retry", l.retry)
+	}
+}
+
+// reached switches back to the shared buckets after an outage.
+func (l *ClusterLimiter) reached() {
+	if l.unavailableUntil.Load() != 0 && l.unavailableUntil.Swap(0) != 0 {
+		limiterLog.Info("cluster: shared limiter available again")
+	}
+}
+
+// Allow checks if the bucket has at least 1 token and consumes it if so.
+func (l *ClusterLimiter) Allow(id string, capacity, refillRate float64) bool {
+	return l.Consume(id, 1, capacity, refillRate)
+}
+
+// Consume attempts to consume the specified cost from the shared bucket.
+func (l *ClusterLimiter) Consume(id string, cost float64, capacity, refillRate float64) bool {
+	if !l.shared() {
+		return l.fallback.Consume(id, cost, capacity, refillRate)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	now := float64(time.Now().UnixMicro()) / 1e6
+	ttl := int(l.fallback.TimeToLive.Seconds())
+	allowed, err := bucketScript.Run(ctx, l.client, []string{l.prefix + id}, cost, capacity, refillRate, now, ttl).Int()
+	if err != nil {
+		l.failed(err)
+		return l.fallback.Consume(id, cost, capacity, refillRate)
+	}
+	l.reached()
+	return allowed == 1
+}
+
+// Peek returns the tokens the shared bucket would hold now, without consuming from it.
+func (l *ClusterLimiter) Peek(id string, capacity, refillRate float64) float64 {
+	if !l.shared() {
+		return l.fallback.Peek(id, capacity, refillRate)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	bucket, err := l.client.HMGet(ctx, l.prefix+id, "tokens", "ts").Result()
+	if err != nil {
+		l.failed(err)
+		return l.fallback.Peek(id, capacity, refillRate)
+	}
+	l.reached()
+	tokens, ok1 := bucket[0].(string)
+	ts, ok2 := bucket[1].(string)
+	if !ok1 || !ok2 {
+		return capacity
+	}
+
+	t, _ := strconv.ParseFloat(tokens, 64)
+	last, _ := strconv.ParseFloat(ts, 64)
+	now := float64(time.Now().UnixMicro()) / 1e6
+	return min(t+max(now-last, 0)*refillRate, capacity)
+}
+
+// AllowDaily consumes one token from a shared bucket sized for dailyCap events per day.
+// A dailyCap <= 0 means no cap.
+func (l *ClusterLimiter) AllowDaily(id string, dailyCap float64) bool {
+	if dailyCap <= 0 {
+		return true
+	}
+	return l.Allow(id, dailyCap, dailyCap/secondsPerDay)
+}
+
+// PushMetrics periodically publishes this node's observability counters to Redis
+// until the context is done, so that any node can report cluster-wide totals.
+func (c *Cluster) PushMetrics(ctx context.Context, obs *Observability) {
+	ticker := time.NewTicker(c.MetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		c.pushMetricsOnce(ctx, obs)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pushMetricsOnce stores the counters in the node's metrics hash. The hash expires
+// if the node stops pushing, so departed nodes drop out of the totals.
+func (c *Cluster) pushMetricsOnce(ctx context.Context, obs *Observability) {
+	key := clusterPrefix + "metrics:" + c.nodeID
+	values := make(map[string]any)
+	for _, m := range obs.Snapshot() {
+		values[m.Name] = m.Value
+	}
+
+	pipe := c.client.Pipeline()
+	pipe.HSet(ctx, key, values)
+	pipe.Expire(ctx, key, 3*c.MetricsInterval)
+	if _, err := pipe.Exec(ctx); err != nil && ctx.Err() == nil {
+		relayLog.Error("cluster: failed to push metrics", "error", err)
+	}
+}
+
+// Metrics returns the observability counters summed over all live nodes, in the
+// same order as Observability.Snapshot, along with the number of nodes reporting.
+func (c *Cluster) Metrics(ctx context.Context) ([]Metric, int, error) {
+	var keys []string
+	iter := c.client.Scan(ctx, 0, clusterPrefix+"metrics:*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	totals := (&Observability{}).Snapshot()
+	nodes := 0
+	for _, key := range keys {
+		values, err := c.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		nodes++
+
+		for i := range totals {
+			if v, err := strconv.ParseUint(values[totals[i].Name], 10, 64); err == nil {
+				totals[i].Value += v
+			}
+		}
+	}
+	return totals, nodes, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newTestCluster returns two nodes joined through an in-memory Redis server.
+func newTestCluster(t *testing.T) (*Cluster, *Cluster) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	a, err := NewCluster(ctx, "redis://"+server.Addr(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCluster(ctx, "redis://"+server.Addr(), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestClusterLimiterSharedBuckets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := newTestCluster(t)
+	limiterA, limiterB := a.Limiter(ctx, "default"), b.Limiter(ctx, "default")
+
+	if !limiterA.Consume("alice", 1, 2, 0) || !limiterB.Consume("alice", 1, 2, 0) {
+		t.Fatal("expected the first two tokens to be available")
+	}
+	if limiterA.Consume("alice", 1, 2, 0) {
+		t.Error("bucket should be empty once both nodes consumed from it")
+	}
+
+	// Buckets of different relays are independent
+	if !b.Limiter(ctx, "cats").Allow("alice", 2, 0) {
+		t.Error("buckets of another relay should not be affected")
+	}
+}
+
+func TestClusterLimiterFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := miniredis.RunT(t)
+	c, err := NewCluster(ctx, "redis://"+server.Addr(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	limiter := c.Limiter(ctx, "default")
+	limiter.retry = 100 * time.Millisecond
+
+	// Redis going away switches to the local buckets, without waiting for it on every call
+	server.Close()
+	if !limiter.Allow("alice", 1, 0) || limiter.Allow("alice", 1, 0) {
+		t.Error("expected the local bucket of alice to hold 1 token")
+	}
+	if limiter.unavailableUntil.Load() == 0 {
+		t.Fatal("expected Redis to be marked unavailable")
+	}
+	start := time.Now()
+	for range 100 {
+		limiter.Allow("bob", 1000, 0)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected the local buckets to be used right away, took %v", elapsed)
+	}
+
+	// Redis is tried again after retry
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(limiter.retry)
+	if !limiter.Allow("alice", 1, 0) || limiter.unavailableUntil.Load() != 0 {
+		t.Error("expected the shared buckets to be used again")
+	}
+}
+
+func TestClusterSharedRanks(t *testing.T) {
+	ctx := context.Background()
+	a, b := newTestCluster(t)
+
+	ts := time.Now().Truncate(time.Second)
+	a.PublishRanks(ctx, ts, []PubRank{{Pubkey: "alice", Rank: 0.7}})
+
+	ranks := b.LoadRanks(ctx, []string{"alice", "bob"})
+	if rank, ok := ranks["alice"]; !ok || rank.Rank != 0.7 || !rank.Timestamp.Equal(ts) {
+		t.Errorf("expected alice's rank to be shared, got %+v", ranks)
+	}
+	if _, ok := ranks["bob"]; ok {
+		t.Error("bob's rank was never published")
+	}
+}
+
+func TestClusterEventGossip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := newTestCluster(t)
+
+	received := make(chan *nostr.Event, 1)
+	go b.SyncEvents(ctx, "default", func(e *nostr.Event) { received <- e })
+	time.Sleep(50 * time.Millisecond) // let the subscription settle
+
+	e := signedEvent(t, nostr.GeneratePrivateKey(), 1, nil)
+	a.PublishEvent(ctx, "default", e)
+
+	select {
+	case got := <-received:
+		if got.ID != e.ID {
+			t.Errorf("expected event %s, got %s", e.ID, got.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was not gossiped to the other node")
+	}
+}
+
+func TestClusterLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	a, b := newTestCluster(t)
+
+	a.campaignOnce(ctx)
+	b.campaignOnce(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to lead alone, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// Renewals keep the lease with the current leader
+	a.campaignOnce(ctx)
+	b.campaignOnce(ctx)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to keep the lease, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+
+	// Once a steps down, b takes over
+	campaignCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	a.Campaign(campaignCtx)
+	b.campaignOnce(ctx)
+	if a.IsLeader() || !b.IsLeader() {
+		t.Errorf("expected b to take over, got a=%v b=%v", a.IsLeader(), b.IsLeader())
+	}
+}
+
+func TestClusterRefreshQueue(t *testing.T) {
+	ctx := context.Background()
+	a, b := newTestCluster(t)
+
+	b.QueueRefresh(ctx, []string{"alice", "bob", "alice"})
+	queued := a.DrainRefresh(ctx, MaxPubkeysToRank)
+	if len(queued) != 2 {
+		t.Errorf("expected 2 distinct queued pubkeys, got %v", queued)
+	}
+	if again := a.DrainRefresh(ctx, MaxPubkeysToRank); len(again) != 0 {
+		t.Errorf("expected the queue to be drained, got %v", again)
+	}
+}
+
+func TestClusterMetricsAggregation(t *testing.T) {
+	ctx := context.Background()
+	a, b := newTestCluster(t)
+
+	obsA, obsB := &Observability{}, &Observability{}
+	obsA.rateLimitedCount.Add(3)
+	obsB.rateLimitedCount.Add(4)
+	obsB.rankCacheHits.Add(10)
+
+	a.pushMetricsOnce(ctx, obsA)
+	b.pushMetricsOnce(ctx, obsB)
+
+	totals, nodes, err := a.Metrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes != 2 {
+		t.Errorf("expected 2 nodes reporting, got %d", nodes)
+	}
+
+	got := make(map[string]uint64)
+	for _, m := range totals {
+		got[m.Name] = m.Value
+	}
+	for name, want := range map[string]uint64{"rate_limited": 7, "cache_hits": 10, "banned": 0} {
+		if value, ok := got[name]; !ok || value != want {
+			t.Errorf("expected %s=%d in aggregated metrics %v", name, want, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-72 moderated community kinds.
+const (
+	kindCommunity         = 34550
+	kindCommunityApproval = 4550
+)
+
+// communityAddress returns the address ("34550:<owner>:<d>") of the community the
+// event is posted to, taken from its "a" tag (or "A" for NIP-22 comments).
+func communityAddress(e *nostr.Event) (string, bool) {
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && (tag[0] == "a" || tag[0] == "A") && strings.HasPrefix(tag[1], strconv.Itoa(kindCommunity)+":") {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// isCommunityPost reports whether the event is a post to a moderated community,
+// as opposed to a community definition or approval.
+func isCommunityPost(e *nostr.Event) bool {
+	if e.Kind == kindCommunity || e.Kind == kindCommunityApproval {
+		return false
+	}
+	_, ok := communityAddress(e)
+	return ok
+}
+
+// communityModerators returns the owner and moderators of the community at the
+// address, or nil if the community definition isn't stored on the relay.
+func communityModerators(ctx context.Context, db EventStore, address string) map[string]bool {
+	parts := strings.SplitN(address, ":", 3)
+	if len(parts) != 3 || !nostr.IsValidPublicKey(parts[1]) {
+		return nil
+	}
+
+	community := queryOne(ctx, db, nostr.Filter{
+		Kinds:   []int{kindCommunity},
+		Authors: []string{parts[1]},
+		Tags:    nostr.TagMap{"d": {parts[2]}},
+	})
+	if community == nil {
+		return nil
+	}
+
+	moderators := map[string]bool{community.PubKey: true}
+	for _, tag := range community.Tags {
+		if len(tag) >= 4 && tag[0] == "p" && tag[3] == "moderator" {
+			moderators[tag[1]] = true
+		}
+	}
+	return moderators
+}
+
+// checkApproval verifies that a kind 4550 approval references a known community
+// and a post, and is signed by one of the community's moderators.
+func checkApproval(ctx context.Context, e *nostr.Event, db EventStore) error {
+	address, ok := communityAddress(e)
+	if !ok || e.Tags.Find("e") == nil {
+		return ErrInvalidApproval
+	}
+	if !communityModerators(ctx, db, address)[e.PubKey] {
+		return ErrInvalidApproval
+	}
+	return nil
+}
+
+// approvalChecker decides whether community posts may be served, caching
+// community moderators for the duration of a single query.
+type approvalChecker struct {
+	db         EventStore
+	moderators map[string]map[string]bool
+}
+
+func newApprovalChecker(db EventStore) *approvalChecker {
+	return &approvalChecker{db: db, moderators: make(map[string]map[string]bool)}
+}
+
+// Approved reports whether the event may be served: events that aren't community
+// posts always are, community posts only when authored or approved by a moderator.
+func (a *approvalChecker) Approved(ctx context.Context, e *nostr.Event) bool {
+	if !isCommunityPost(e) {
+		return true
+	}
+	address, _ := communityAddress(e)
+
+	moderators, ok := a.moderators[address]
+	if !ok {
+		moderators = communityModerators(ctx, a.db, address)
+		a.moderators[address] = moderators
+	}
+	if len(moderators) == 0 {
+		return false
+	}
+	if moderators[e.PubKey] {
+		return true
+	}
+
+	authors := make([]string, 0, len(moderators))
+	for pubkey := range moderators {
+		authors = append(authors, pubkey)
+	}
+	return hasEvent(ctx, a.db, nostr.Filter{
+		Kinds:   []int{kindCommunityApproval},
+		Authors: authors,
+		Tags:    nostr.TagMap{"a": {address}, "e": {e.ID}},
+	})
+}
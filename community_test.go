@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newTestDB opens a Badger backend in a temporary directory.
+func newTestDB(t *testing.T) *badger.BadgerBackend {
+	t.Helper()
+
+	db := &badger.BadgerBackend{Path: t.TempDir()}
+	if err := db.Init(); err != nil {
+		t.Fatalf("failed to initialize badger backend: %v", err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// signedEvent builds and signs an event with the given secret key.
+func signedEvent(t *testing.T, sk string, kind int, tags nostr.Tags) *nostr.Event {
+	t.Helper()
+
+	e := &nostr.Event{Kind: kind, Tags: tags, CreatedAt: nostr.Now()}
+	if err := e.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCommunityApprovals(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	ownerSK, modSK, userSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	owner, _ := nostr.GetPublicKey(ownerSK)
+	mod, _ := nostr.GetPublicKey(modSK)
+
+	community := signedEvent(t, ownerSK, kindCommunity, nostr.Tags{{"d", "cats"}, {"p", mod, "", "moderator"}})
+	if err := db.SaveEvent(ctx, community); err != nil {
+		t.Fatal(err)
+	}
+	address := "34550:" + owner + ":cats"
+
+	post := signedEvent(t, userSK, 1, nostr.Tags{{"a", address}})
+	modPost := signedEvent(t, modSK, 1, nostr.Tags{{"a", address}})
+	unrelated := signedEvent(t, userSK, 1, nil)
+
+	// Only moderators may approve
+	forged := signedEvent(t, userSK, kindCommunityApproval, nostr.Tags{{"a", address}, {"e", post.ID}})
+	if err := checkApproval(ctx, forged, db); !errors.Is(err, ErrInvalidApproval) {
+		t.Errorf("expected approval from non-moderator to be rejected, got %v", err)
+	}
+
+	checker := newApprovalChecker(db)
+	if !checker.Approved(ctx, unrelated) {
+		t.Error("events outside communities should always be served")
+	}
+	if !checker.Approved(ctx, modPost) {
+		t.Error("posts by moderators should be served without approval")
+	}
+	if checker.Approved(ctx, post) {
+		t.Error("unapproved community post should not be served")
+	}
+
+	approval := signedEvent(t, modSK, kindCommunityApproval, nostr.Tags{{"a", address}, {"e", post.ID}})
+	if err := checkApproval(ctx, approval, db); err != nil {
+		t.Fatalf("expected moderator approval to be accepted, got %v", err)
+	}
+	if err := db.SaveEvent(ctx, approval); err != nil {
+		t.Fatal(err)
+	}
+	if !newApprovalChecker(db).Approved(ctx, post) {
+		t.Error("approved community post should be served")
+	}
+}
+
+func TestCommunityPostUnknownCommunity(t *testing.T) {
+	db := newTestDB(t)
+
+	post := signedEvent(t, nostr.GeneratePrivateKey(), 1, nostr.Tags{{"a", "34550:" + nostr.GeneratePrivateKey() + ":unknown"}})
+	if newApprovalChecker(db).Approved(context.Background(), post) {
+		t.Error("posts to communities unknown to the relay should not be served")
+	}
+}
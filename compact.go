@@ -0,0 +1,94 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+)
+
+// CompactReport is the outcome of compacting a Badger store.
+type CompactReport struct {
+	Before, After int64 // on-disk size in bytes, LSM tree and value log together
+	Rewritten     int   // value log files rewritten
+}
+
+// compactCommand implements `wotrlay compact [flags]`, and returns the process exit code.
+func compactCommand(args []string) int {
+	cfg := loadConfig()
+
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	storePath := flags.String("store", cfg.StorePath, "Badger store to compact")
+	honeypotPath := flags.String("honeypot", cfg.HoneypotStorePath, "honeypot store to compact as well (empty skips it)")
+	discardRatio := flags.Float64("discard-ratio", 0.5, "rewrite the value log files at least this fraction of which is garbage")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay compact [flags]")
+		fmt.Fprintln(flags.Output(), "\nReclaims the disk space of deleted and replaced events in the Badger stores: merges the LSM")
+		fmt.Fprintln(flags.Output(), "tree into a single level, then rewrites the value log files holding mostly garbage.")
+		fmt.Fprintln(flags.Output(), "Badger stores can't be opened by two processes: stop the relay first.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *discardRatio <= 0 || *discardRatio >= 1 {
+		flags.Usage()
+		return 2
+	}
+
+	paths := []string{*storePath}
+	if *honeypotPath != "" {
+		if _, err := os.Stat(*honeypotPath); err == nil {
+			paths = append(paths, *honeypotPath)
+		}
+	}
+	for _, path := range paths {
+		db := &badger.BadgerBackend{Path: path}
+		if err := db.Init(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open store at %s: %v\n", path, err)
+			return 1
+		}
+		report, err := compactStore(db, *discardRatio)
+		db.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compact %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "compacted %s: %d to %d bytes, %d value log files rewritten\n", path, report.Before, report.After, report.Rewritten)
+	}
+	return 0
+}
+
+// compactStore flattens the LSM tree of the store, then runs the value log garbage
+// collection until no file is worth rewriting at the discard ratio.
+func compactStore(db *badger.BadgerBackend, discardRatio float64) (CompactReport, error) {
+	var report CompactReport
+	lsm, vlog := db.Size()
+	report.Before = lsm + vlog
+
+	if err := db.Flatten(runtime.NumCPU()); err != nil {
+		return report, err
+	}
+	for {
+		err := db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badgerdb.ErrNoRewrite) {
+			break
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Rewritten++
+	}
+
+	lsm, vlog = db.Size()
+	report.After = lsm + vlog
+	return report, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCompactStore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	for i := range 1000 {
+		e := testEvent(i, nostr.Timestamp(1000+i))
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := db.DeleteEvent(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := compactStore(db, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.CountEvents(ctx, nostr.Filter{}); err != nil || n != 500 {
+		t.Errorf("expected the 500 remaining events to survive, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,185 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// compactedKinds are the kinds of events compacted into counts once old: reactions
+// and zap receipts, which make up most events but are rarely read individually.
+var compactedKinds = []int{nostr.KindReaction, nostr.KindZap}
+
+// compactionPrefix is the key prefix under which the counts of compacted events are kept
+// in the event store. The event store only uses prefixes 0-8 and 255, the honeypot
+// labels 128, the store metadata 129, the tombstones 130, the refresh queue 131
+// and the onboarding records 132.
+//   - compactionPrefix <kind (2 bytes)> <target event ID> holds the count (8 bytes)
+const compactionPrefix byte = 133
+
+// Compaction replaces the reactions and zap receipts older than MaxAge by the number
+// of them each target event received, served in reply to NIP-45 COUNT requests.
+type Compaction struct {
+	db        EventStore
+	meta      *badger.BadgerBackend // where the counts are kept
+	retention *Retention
+	obs       *Observability
+
+	MaxAge time.Duration // Reactions and zap receipts older than this are compacted by the compact job
+}
+
+func NewCompaction(db EventStore, meta *badger.BadgerBackend, retention *Retention, obs *Observability, maxAge time.Duration) *Compaction {
+	return &Compaction{
+		db:        db,
+		meta:      meta,
+		retention: retention,
+		obs:       obs,
+		MaxAge:    maxAge,
+	}
+}
+
+// compactionTarget returns the ID of the event a reaction or zap receipt is about:
+// its last "e" tag, following NIP-25.
+func compactionTarget(e *nostr.Event) (string, bool) {
+	for _, tag := range slices.Backward(e.Tags) {
+		if len(tag) >= 2 && tag[0] == "e" && nostr.IsValid32ByteHex(tag[1]) {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// Compact counts and deletes the reactions and zap receipts older than MaxAge,
+// and returns how many were deleted. Events without a target are deleted uncounted.
+func (c *Compaction) Compact(ctx context.Context) int {
+	until := nostr.Timestamp(time.Now().Add(-c.MaxAge).Unix())
+	deleted := 0
+	for {
+		events, err := c.db.QueryEvents(ctx, nostr.Filter{Kinds: compactedKinds, Until: &until, Limit: pruneBatchSize})
+		if err != nil {
+			relayLog.Error("failed to query events to compact", "error", err)
+			return deleted
+		}
+
+		var batch []*nostr.Event
+		counts := make(map[string]uint64)
+		for event := range events {
+			batch = append(batch, event)
+			if target, ok := compactionTarget(event); ok {
+				counts[string(compactionKey(event.Kind, target))]++
+			}
+		}
+
+		// Counts are added before the events are deleted: if deleting fails,
+		// the events are counted twice rather than lost
+		if err := c.add(counts); err != nil {
+			relayLog.Error("failed to store the counts of compacted events", "error", err)
+			return deleted
+		}
+
+		removed := 0
+		for _, event := range batch {
+			if err := c.db.DeleteEvent(ctx, event); err != nil {
+				relayLog.Error("failed to delete compacted event", "event", event.ID, "error", err)
+				continue
+			}
+			c.retention.Deleted(event)
+			removed++
+		}
+
+		deleted += removed
+		c.obs.compactedCount.Add(uint64(removed))
+		if len(batch) < pruneBatchSize || removed == 0 || ctx.Err() != nil {
+			return deleted
+		}
+	}
+}
+
+// Count returns the number of compacted events matching the filter, and whether there were any.
+// Only filters asking for the reactions or zap receipts of events can match: compacted kinds,
+// "e" tags, and no other condition, as counts don't keep anything else.
+func (c *Compaction) Count(filter nostr.Filter) (int64, bool) {
+	targets := filter.Tags["e"]
+	if len(targets) == 0 || len(filter.Tags) > 1 || len(filter.Kinds) == 0 {
+		return 0, false
+	}
+	if len(filter.IDs) > 0 || len(filter.Authors) > 0 || filter.Since != nil || filter.Until != nil || filter.Search != "" {
+		return 0, false
+	}
+	for _, kind := range filter.Kinds {
+		if !slices.Contains(compactedKinds, kind) {
+			return 0, false
+		}
+	}
+
+	var total uint64
+	err := c.meta.View(func(txn *badgerdb.Txn) error {
+		for _, kind := range filter.Kinds {
+			for _, target := range targets {
+				count, err := compactedCount(txn, compactionKey(kind, target))
+				if err != nil {
+					return err
+				}
+				total += count
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		queryLog.Error("failed to read the counts of compacted events", "error", err)
+		return 0, false
+	}
+	return int64(total), total > 0
+}
+
+// add adds the counts to the stored ones.
+func (c *Compaction) add(counts map[string]uint64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	return c.meta.Update(func(txn *badgerdb.Txn) error {
+		for key, count := range counts {
+			stored, err := compactedCount(txn, []byte(key))
+			if err != nil {
+				return err
+			}
+			if err := txn.Set([]byte(key), binary.BigEndian.AppendUint64(nil, stored+count)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func compactedCount(txn *badgerdb.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badgerdb.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	err = item.Value(func(value []byte) error {
+		if len(value) == 8 {
+			count = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return count, err
+}
+
+func compactionKey(kind int, target string) []byte {
+	key := binary.BigEndian.AppendUint16([]byte{compactionPrefix}, uint16(kind))
+	return append(key, target...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCompaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := newTestDB(t)
+	obs := &Observability{}
+	c := &Compaction{db: db, meta: db, retention: NewRetention(ctx, db, 0, 0), obs: obs, MaxAge: 24 * time.Hour}
+
+	sk := nostr.GeneratePrivateKey()
+	target := signedEvent(t, sk, nostr.KindTextNote, nil)
+	old := nostr.Timestamp(time.Now().Add(-48 * time.Hour).Unix())
+	save := func(kind int, createdAt nostr.Timestamp, content string, tags nostr.Tags) *nostr.Event {
+		e := &nostr.Event{Kind: kind, CreatedAt: createdAt, Content: content, Tags: tags}
+		if err := e.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	onTarget := nostr.Tags{{"e", target.ID}}
+	save(nostr.KindReaction, old, "+", onTarget)
+	save(nostr.KindReaction, old, "🤙", onTarget)
+	save(nostr.KindZap, old, "", onTarget)
+	save(nostr.KindReaction, old, "+", nil) // no target
+	recent := save(nostr.KindReaction, nostr.Now(), "+", onTarget)
+	note := save(nostr.KindTextNote, old, "old note", nil)
+
+	if deleted := c.Compact(ctx); deleted != 4 {
+		t.Fatalf("expected 4 compacted events, got %d", deleted)
+	}
+	if getEventByID(ctx, db, recent.ID) == nil || getEventByID(ctx, db, note.ID) == nil {
+		t.Error("recent reactions and other kinds must be kept")
+	}
+
+	reactions := nostr.Filter{Kinds: []int{nostr.KindReaction}, Tags: nostr.TagMap{"e": {target.ID}}}
+	if count, ok := c.Count(reactions); !ok || count != 2 {
+		t.Errorf("compacted reactions: got %d, want 2", count)
+	}
+	both := nostr.Filter{Kinds: []int{nostr.KindReaction, nostr.KindZap}, Tags: nostr.TagMap{"e": {target.ID}}}
+	if count, _ := c.Count(both); count != 3 {
+		t.Errorf("compacted reactions and zaps: got %d, want 3", count)
+	}
+	for _, filter := range []nostr.Filter{
+		{Kinds: []int{nostr.KindReaction, nostr.KindTextNote}, Tags: nostr.TagMap{"e": {target.ID}}},
+		{Kinds: []int{nostr.KindReaction}, Authors: []string{target.PubKey}, Tags: nostr.TagMap{"e": {target.ID}}},
+		{Kinds: []int{nostr.KindReaction}},
+	} {
+		if count, ok := c.Count(filter); ok {
+			t.Errorf("counts don't answer %v, got %d", filter, count)
+		}
+	}
+
+	// COUNT adds the stored reactions to the compacted ones
+	d := &Deps{DB: db, Compaction: c}
+	count, approx, err := Count(ctx, nostr.Filters{reactions}, d)
+	if err != nil || count != 3 || !approx {
+		t.Errorf("COUNT: got %d (approximate %v, error %v), want approximately 3", count, approx, err)
+	}
+
+	// Compacting again adds to the counts
+	save(nostr.KindReaction, old, "❤️", onTarget)
+	c.Compact(ctx)
+	if count, _ := c.Count(reactions); count != 3 {
+		t.Errorf("compacted reactions after a second compaction: got %d, want 3", count)
+	}
+	if compacted := obs.compactedCount.Load(); compacted != 5 {
+		t.Errorf("expected 5 compacted events, got %d", compacted)
+	}
+}
@@ -0,0 +1,135 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/rely"
+)
+
+// connLimitGrace is how long an excess connection is left open after its NOTICE,
+// so that the NOTICE is written before the connection is closed.
+const connLimitGrace = time.Second
+
+// ConnLimiter caps the simultaneous websocket connections of each IP group
+// (IPv4 address or IPv6 /64) and of the whole process, across virtual relays.
+// Excess connections are told why with a NOTICE, then closed.
+//
+// The last Reserve connections of MaxTotal are held back for the community: once the
+// others are taken, new connections are only admitted into the reserve on probation,
+// and closed unless they authenticate (NIP-42) as a trusted pubkey within AuthGrace.
+// A flood of anonymous connections then can't lock the relay's members out.
+type ConnLimiter struct {
+	mu        sync.Mutex
+	groups    map[string]int              // open connections by IP group
+	admitted  map[rely.Client]string      // IP group of the admitted connections
+	probation map[rely.Client]*time.Timer // connections of the reserve yet to authenticate as trusted
+	obs       *Observability
+
+	MaxPerGroup int           // 0 means no limit
+	MaxTotal    int           // 0 means no limit
+	Reserve     int           // connections of MaxTotal held back for trusted pubkeys (0 disables)
+	AuthGrace   time.Duration // how long connections of the reserve have to authenticate
+}
+
+func NewConnLimiter(obs *Observability, maxPerGroup, maxTotal int) *ConnLimiter {
+	return &ConnLimiter{
+		groups:      make(map[string]int, 100),
+		admitted:    make(map[rely.Client]string, 100),
+		probation:   make(map[rely.Client]*time.Timer),
+		obs:         obs,
+		MaxPerGroup: maxPerGroup,
+		MaxTotal:    maxTotal,
+	}
+}
+
+// Admit counts the new connection, or returns the reason it exceeds a limit.
+// A connection admitted into the reserve is on probation: see OnProbation.
+func (l *ConnLimiter) Admit(c rely.Client) error {
+	group := c.IP().Group()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.MaxTotal > 0 && len(l.admitted) >= l.MaxTotal {
+		l.obs.connectionsRejectedCount.Add(1)
+		return ErrRelayFull
+	}
+	if l.MaxPerGroup > 0 && group != "" && l.groups[group] >= l.MaxPerGroup {
+		l.obs.connectionsRejectedCount.Add(1)
+		return ErrTooManyConnections
+	}
+
+	l.admitted[c] = group
+	l.groups[group]++
+	if l.MaxTotal > 0 && l.Reserve > 0 && len(l.admitted) > l.MaxTotal-l.Reserve {
+		l.obs.connectionsReservedCount.Add(1)
+		l.probation[c] = time.AfterFunc(l.AuthGrace, func() { l.expire(c) })
+	}
+	return nil
+}
+
+// OnProbation returns whether the connection was admitted into the reserve, and has yet
+// to authenticate as a trusted pubkey. A nil ConnLimiter has no reserve.
+func (l *ConnLimiter) OnProbation(c rely.Client) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.probation[c]
+	return ok
+}
+
+// Trusted ends the probation of the connection, which authenticated as a trusted pubkey.
+func (l *ConnLimiter) Trusted(c rely.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if timer, ok := l.probation[c]; ok {
+		timer.Stop()
+		delete(l.probation, c)
+	}
+}
+
+// expire closes the connection if it's still on probation.
+func (l *ConnLimiter) expire(c rely.Client) {
+	l.mu.Lock()
+	_, ok := l.probation[c]
+	delete(l.probation, c)
+	l.mu.Unlock()
+
+	if ok {
+		l.obs.connectionsDeferredCount.Add(1)
+		c.SendNotice(ErrRelayBusy.Error())
+		time.AfterFunc(connLimitGrace, c.Disconnect)
+	}
+}
+
+// Release stops counting the connection. Connections that weren't admitted are ignored.
+func (l *ConnLimiter) Release(c rely.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	group, ok := l.admitted[c]
+	if !ok {
+		return
+	}
+	delete(l.admitted, c)
+	if l.groups[group]--; l.groups[group] <= 0 {
+		delete(l.groups, group)
+	}
+	if timer, ok := l.probation[c]; ok {
+		timer.Stop()
+		delete(l.probation, c)
+	}
+}
+
+// Reject tells the client why it can't connect and closes its connection.
+// rely calls On.Connect from its registration loop, so the client must not be
+// disconnected synchronously.
+func (l *ConnLimiter) Reject(c rely.Client, reason error) {
+	c.SendNotice(reason.Error())
+	time.AfterFunc(connLimitGrace, c.Disconnect)
+}
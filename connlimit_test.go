@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	obs := &Observability{}
+	limiter := NewConnLimiter(obs, 2, 3)
+
+	a1, a2, a3 := &ipClient{ip: "203.0.113.7"}, &ipClient{ip: "203.0.113.7"}, &ipClient{ip: "203.0.113.7"}
+	b1, c1 := &ipClient{ip: "2001:db8::1"}, &ipClient{ip: "2001:db8::2"}
+
+	for _, c := range []*ipClient{a1, a2, b1} {
+		if err := limiter.Admit(c); err != nil {
+			t.Fatalf("connection from %s: %v", c.ip, err)
+		}
+	}
+	if err := limiter.Admit(a3); !errors.Is(err, ErrRelayFull) {
+		t.Errorf("connection over the total: got %v, want %v", err, ErrRelayFull)
+	}
+
+	// Freeing a slot of another group makes room in total, but not for the full group
+	limiter.Release(b1)
+	if err := limiter.Admit(a3); !errors.Is(err, ErrTooManyConnections) {
+		t.Errorf("connection over the group limit: got %v, want %v", err, ErrTooManyConnections)
+	}
+	if err := limiter.Admit(c1); err != nil {
+		t.Errorf("connection from the same /64 as a closed one: %v", err)
+	}
+
+	// Rejected connections are not counted when they disconnect
+	limiter.Release(a3)
+	if limiter.groups["203.0.113.7"] != 2 {
+		t.Errorf("expected 2 connections from the full group, got %d", limiter.groups["203.0.113.7"])
+	}
+	if rejected := obs.connectionsRejectedCount.Load(); rejected != 2 {
+		t.Errorf("expected 2 rejected connections, got %d", rejected)
+	}
+}
+
+// noticeClient records the NOTICEs sent to it.
+type noticeClient struct {
+	ipClient
+	notices chan string
+}
+
+func (c *noticeClient) SendNotice(msg string) { c.notices <- msg }
+func (c *noticeClient) Disconnect()           {}
+
+func TestConnLimiterReserve(t *testing.T) {
+	obs := &Observability{}
+	limiter := NewConnLimiter(obs, 0, 3)
+	limiter.Reserve, limiter.AuthGrace = 2, 50*time.Millisecond
+
+	newClient := func(ip string) *noticeClient {
+		return &noticeClient{ipClient: ipClient{ip: ip}, notices: make(chan string, 1)}
+	}
+	open, trusted, anonymous := newClient("203.0.113.7"), newClient("203.0.113.8"), newClient("203.0.113.9")
+	for _, c := range []*noticeClient{open, trusted, anonymous} {
+		if err := limiter.Admit(c); err != nil {
+			t.Fatalf("connection from %s: %v", c.ip, err)
+		}
+	}
+	if limiter.OnProbation(open) || !limiter.OnProbation(trusted) || !limiter.OnProbation(anonymous) {
+		t.Fatal("expected only the connections admitted into the reserve to be on probation")
+	}
+
+	// Connections of the reserve stay once trusted, and are closed otherwise
+	limiter.Trusted(trusted)
+	select {
+	case notice := <-anonymous.notices:
+		if notice != ErrRelayBusy.Error() {
+			t.Errorf("expected %q, got %q", ErrRelayBusy, notice)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection still on probation to be told it's closed")
+	}
+	select {
+	case notice := <-trusted.notices:
+		t.Errorf("expected the trusted connection to stay, got %q", notice)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if limiter.OnProbation(trusted) || limiter.OnProbation(anonymous) {
+		t.Error("expected the probations to be over")
+	}
+	if reserved, deferred := obs.connectionsReservedCount.Load(), obs.connectionsDeferredCount.Load(); reserved != 2 || deferred != 1 {
+		t.Errorf("expected 2 reserved and 1 deferred connections, got %d and %d", reserved, deferred)
+	}
+
+	// Releasing a connection on probation ends it without a NOTICE
+	limiter.Release(anonymous)
+	late := newClient("203.0.113.10")
+	if err := limiter.Admit(late); err != nil || !limiter.OnProbation(late) {
+		t.Fatalf("expected the connection to be admitted into the reserve, got %v", err)
+	}
+	limiter.Release(late)
+	if limiter.OnProbation(late) {
+		t.Error("expected the released connection to be off probation")
+	}
+
+	var disabled *ConnLimiter
+	if disabled.OnProbation(open) {
+		t.Error("a nil ConnLimiter has no reserve")
+	}
+}
+
+func TestKnownRank(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	operator := strings.Repeat("0a", 32)
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"RANK_OVERRIDES": operator + ":0.9"}[key]
+	})
+	obs := &Observability{}
+	global := NewLimiter(ctx)
+	d := &Deps{Cache: NewRankCache(ctx, cfg, obs), GlobalLimiter: global, Obs: obs}
+	d.Cache.Update(time.Now(), PubRank{Pubkey: "member", Rank: 0.7})
+
+	if rank, ok := knownRank(operator, cfg, d); !ok || rank != 0.9 {
+		t.Errorf("expected the operator's rank, got %v %v", rank, ok)
+	}
+	if rank, ok := knownRank("member", cfg, d); !ok || rank != 0.7 {
+		t.Errorf("expected the cached rank, got %v %v", rank, ok)
+	}
+
+	// Unknown pubkeys aren't looked up, so they don't spend the global refresh budget
+	if _, ok := knownRank("stranger", cfg, d); ok {
+		t.Error("expected no rank for an unknown pubkey")
+	}
+	if tokens := global.GetTokens("global-rank-refresh"); tokens != 0 {
+		t.Errorf("expected the global refresh budget to be untouched, got a bucket with %v tokens", tokens)
+	}
+}
@@ -0,0 +1,67 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache the answer to a preflight request.
+const corsMaxAge = "86400"
+
+// withCORS lets browser-based clients at the origins read the responses of the handler:
+// /check, /latest, /stats, the admin API, the NIP-86 management API and /metrics. Preflight
+// requests are answered directly. "*" allows any origin. The NIP-11 document allows any
+// origin on its own.
+//
+// The protected endpoints take bearer tokens or NIP-98 events rather than cookies,
+// so allowing their origin doesn't hand a page the user's credentials.
+func withCORS(origins []string, h http.Handler) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		switch {
+		case anyOrigin:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && slices.Contains(origins, strings.ToLower(origin)):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseCORSOrigins validates the origins, which must be "*" or scheme://host[:port],
+// and returns them lowercased.
+func parseCORSOrigins(origins []string) ([]string, error) {
+	parsed := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			parsed = append(parsed, origin)
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", origin)
+		}
+		parsed = append(parsed, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return parsed, nil
+}
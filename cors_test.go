@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	request := func(handler http.Handler, method, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/stats", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			r.Header.Set("Access-Control-Request-Headers", "authorization")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	open := withCORS([]string{"*"}, ok)
+	if w := request(open, http.MethodGet, "", false); w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Code != http.StatusTeapot {
+		t.Errorf("any origin: got %d %v", w.Code, w.Header())
+	}
+	w := request(open, http.MethodOptions, "https://dashboard.example.com", true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Headers") == "" || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("preflight: got %d %v", w.Code, w.Header())
+	}
+
+	// Listed origins are echoed, others get no CORS headers and their preflight reaches the handler
+	listed := withCORS([]string{"https://dashboard.example.com"}, ok)
+	w = request(listed, http.MethodGet, "https://Dashboard.example.com", false)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://Dashboard.example.com" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("listed origin: got %v", w.Header())
+	}
+	w = request(listed, http.MethodOptions, "https://evil.example.com", true)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Code != http.StatusTeapot {
+		t.Errorf("other origin: got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestParseCORSOrigins(t *testing.T) {
+	origins, err := parseCORSOrigins([]string{"*", "HTTPS://Dashboard.example.com/", "http://localhost:5173"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origins[1] != "https://dashboard.example.com" || origins[2] != "http://localhost:5173" {
+		t.Errorf("unexpected origins %v", origins)
+	}
+	for _, invalid := range []string{"dashboard.example.com", "ftp://example.com", "https://example.com/path", "https://"} {
+		if _, err := parseCORSOrigins([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
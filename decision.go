@@ -0,0 +1,149 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DecisionRecord describes what the relay decided about an incoming event.
+type DecisionRecord struct {
+	Time      time.Time `json:"time"`
+	TraceID   string    `json:"trace_id,omitempty"` // trace ID of the event's log lines
+	Relay     string    `json:"relay"`
+	EventID   string    `json:"event_id"`
+	Pubkey    string    `json:"pubkey"`
+	Kind      int       `json:"kind"`
+	Size      int       `json:"size"`           // size of the serialized event in bytes
+	Rank      *float64  `json:"rank,omitempty"` // cached rank of the author, if known
+	Accepted  bool      `json:"accepted"`
+	Reason    string    `json:"reason,omitempty"`
+	LatencyUs int64     `json:"latency_us"`
+}
+
+// DecisionLog writes a sample of decision records to a JSON Lines file, one record per
+// line, for offline analysis of policy effectiveness. The format is ClickHouse's
+// JSONEachRow and is readable by DuckDB, Spark and most Parquet converters.
+// Records are written in the background; when the writer falls behind they are dropped.
+type DecisionLog struct {
+	mu      sync.RWMutex
+	closed  bool
+	records chan DecisionRecord
+	done    chan struct{}
+
+	file *os.File
+	obs  *Observability
+
+	SampleRate float64 // Fraction of events recorded, in (0, 1]
+}
+
+func NewDecisionLog(path string, sampleRate float64, obs *Observability) (*DecisionLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decision log: %w", err)
+	}
+
+	l := &DecisionLog{
+		records:    make(chan DecisionRecord, 1000),
+		done:       make(chan struct{}),
+		file:       file,
+		obs:        obs,
+		SampleRate: sampleRate,
+	}
+
+	go l.writer()
+	return l, nil
+}
+
+// Record samples the decision about the event, and queues it for writing without blocking.
+// err is the reason the event was rejected, nil if it was accepted.
+func (l *DecisionLog) Record(ctx context.Context, relay string, e *nostr.Event, rank *float64, err error, latency time.Duration) {
+	if l.SampleRate < 1 && rand.Float64() >= l.SampleRate {
+		return
+	}
+
+	record := DecisionRecord{
+		Time:      time.Now().UTC(),
+		TraceID:   traceID(ctx),
+		Relay:     relay,
+		EventID:   e.ID,
+		Pubkey:    e.PubKey,
+		Kind:      e.Kind,
+		Size:      len(e.String()),
+		Rank:      rank,
+		Accepted:  err == nil,
+		LatencyUs: latency.Microseconds(),
+	}
+	if err != nil {
+		record.Reason = err.Error()
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+
+	select {
+	case l.records <- record:
+	default:
+		l.obs.decisionsDropped.Add(1)
+	}
+}
+
+// Close writes the queued records and closes the file.
+func (l *DecisionLog) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.records)
+	l.mu.Unlock()
+
+	<-l.done
+	return l.file.Close()
+}
+
+// writer encodes the records to the file, flushing every second.
+func (l *DecisionLog) writer() {
+	defer close(l.done)
+
+	buf := bufio.NewWriter(l.file)
+	encoder := json.NewEncoder(buf)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	flush := func() {
+		if err := buf.Flush(); err != nil {
+			relayLog.Error("failed to write decision log", "error", err)
+		}
+	}
+	defer flush()
+
+	for {
+		select {
+		case record, ok := <-l.records:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(record); err != nil {
+				relayLog.Error("failed to encode decision record", "error", err)
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
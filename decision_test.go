@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDecisionLogWritesRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	decisions, err := NewDecisionLog(path, 1, &Observability{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rank := 0.42
+	accepted := &nostr.Event{ID: "a", PubKey: "alice", Kind: 1, Content: "hello"}
+	rejected := &nostr.Event{ID: "b", PubKey: "bob", Kind: 7}
+	decisions.Record(context.Background(), "main", accepted, &rank, nil, 150*time.Microsecond)
+	decisions.Record(context.Background(), "main", rejected, nil, ErrRateLimited, time.Millisecond)
+
+	if err := decisions.Close(); err != nil {
+		t.Fatal(err)
+	}
+	decisions.Record(context.Background(), "main", accepted, nil, nil, 0) // ignored after Close
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []DecisionRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record DecisionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	first, second := records[0], records[1]
+	if !first.Accepted || first.Reason != "" || first.Rank == nil || *first.Rank != rank || first.LatencyUs != 150 {
+		t.Errorf("accepted record: got %+v", first)
+	}
+	if first.Size != len(accepted.String()) {
+		t.Errorf("accepted record size: got %d, want %d", first.Size, len(accepted.String()))
+	}
+	if second.Accepted || second.Reason != ErrRateLimited.Error() || second.Rank != nil || second.Kind != 7 {
+		t.Errorf("rejected record: got %+v", second)
+	}
+}
+
+func TestDecisionLogSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	decisions, err := NewDecisionLog(path, 0.1, &Observability{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := &nostr.Event{ID: "a", PubKey: "alice", Kind: 1}
+	for range 500 {
+		decisions.Record(context.Background(), "main", event, nil, nil, 0)
+	}
+	if err := decisions.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	for _, b := range data {
+		if b == '\n' {
+			lines++
+		}
+	}
+	if lines == 0 || lines > 150 {
+		t.Errorf("got %d of 500 records at sample rate 0.1", lines)
+	}
+}
@@ -0,0 +1,148 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// dedupMinLength is the minimum length (in runes) of normalized content tracked by
+// ContentDedup, so that short greetings like "gm" shared by many users aren't flagged.
+const dedupMinLength = 20
+
+// dedupMaxContents bounds the contents tracked by ContentDedup: beyond it, the least
+// recently published are forgotten before they expire.
+const dedupMaxContents = 100000
+
+// ContentDedup tracks which pubkeys recently published the same content, to catch
+// botnets posting identical copypasta from many fresh pubkeys.
+// Content is hashed after normalization, and sightings expire after Window.
+type ContentDedup struct {
+	mu sync.Mutex
+
+	// seen maps a content hash to the pubkeys that published it (with last-seen time)
+	seen *lru.Cache[[sha256.Size]byte, map[string]time.Time]
+
+	Window          time.Duration    // How long identical content is remembered
+	CleanupInterval time.Duration    // How often to scan for cleanup
+	Clock           func() time.Time // Source of the current time, time.Now if nil
+}
+
+func NewContentDedup(ctx context.Context, window time.Duration) *ContentDedup {
+	seen, _ := lru.New[[sha256.Size]byte, map[string]time.Time](dedupMaxContents)
+	d := &ContentDedup{
+		seen:            seen,
+		Window:          window,
+		CleanupInterval: time.Minute,
+	}
+
+	go d.cleaner(ctx)
+	return d
+}
+
+// Count returns how many distinct pubkeys, including this one, would have published the
+// content within the window if the pubkey published it now.
+// Content too short to be meaningful is not tracked and always returns 0.
+func (d *ContentDedup) Count(content, pubkey string) int {
+	hash, ok := contentHash(content)
+	if !ok {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	pubkeys, _ := d.seen.Peek(hash)
+	count := 1
+	for other, lastSeen := range pubkeys {
+		if other != pubkey && now.Sub(lastSeen) <= d.Window {
+			count++
+		}
+	}
+	return count
+}
+
+// Record notes that the pubkey published the content. Only accepted events are recorded,
+// so that the pubkeys refused for copying the content don't count.
+func (d *ContentDedup) Record(content, pubkey string) {
+	hash, ok := contentHash(content)
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pubkeys, ok := d.seen.Get(hash)
+	if !ok {
+		pubkeys = make(map[string]time.Time, 1)
+		d.seen.Add(hash, pubkeys)
+	}
+	pubkeys[pubkey] = d.now()
+}
+
+// contentHash returns the hash of the normalized content, and false if it is too short to track.
+func contentHash(content string) ([sha256.Size]byte, bool) {
+	normalized := normalizeContent(content)
+	if utf8.RuneCountInString(normalized) < dedupMinLength {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256([]byte(normalized)), true
+}
+
+func (d *ContentDedup) now() time.Time {
+	if d.Clock != nil {
+		return d.Clock()
+	}
+	return time.Now()
+}
+
+// normalizeContent reduces content to a canonical form, so that trivial variations
+// (case, whitespace, invisible characters, look-alike letters) hash the same.
+func normalizeContent(content string) string {
+	content = foldConfusables(normalizeURLText(content))
+	return strings.Join(strings.Fields(strings.ToLower(content)), " ")
+}
+
+// Clean removes sightings older than the window.
+func (d *ContentDedup) Clean() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for _, hash := range d.seen.Keys() {
+		pubkeys, _ := d.seen.Peek(hash)
+		for pubkey, lastSeen := range pubkeys {
+			if now.Sub(lastSeen) > d.Window {
+				delete(pubkeys, pubkey)
+			}
+		}
+		if len(pubkeys) == 0 {
+			d.seen.Remove(hash)
+		}
+	}
+}
+
+func (d *ContentDedup) cleaner(ctx context.Context) {
+	timer := time.NewTicker(d.CleanupInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			d.Clean()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+func TestContentDedupCountsDistinctPubkeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dedup := NewContentDedup(ctx, time.Hour)
+	const spam = "Claim your free airdrop before it's gone!"
+
+	if got := dedup.Count(spam, "alice"); got != 1 {
+		t.Errorf("first sighting: got %d, want 1", got)
+	}
+	dedup.Record(spam, "alice")
+	if got := dedup.Count(spam, "alice"); got != 1 {
+		t.Errorf("same pubkey again: got %d, want 1", got)
+	}
+	if got := dedup.Count("  CLAIM your free\tairdrop before it's gone! ", "bob"); got != 2 {
+		t.Errorf("normalized copy from another pubkey: got %d, want 2", got)
+	}
+	dedup.Record("  CLAIM your free\tairdrop before it's gone! ", "bob")
+	if got := dedup.Count("Clаim your free airdrop before it's gone!", "carol"); got != 3 {
+		t.Errorf("look-alike copy from another pubkey: got %d, want 3", got)
+	}
+	if got := dedup.Count("Something else entirely, written by hand", "dave"); got != 1 {
+		t.Errorf("different content: got %d, want 1", got)
+	}
+
+	// Counting doesn't record: carol's copy was never accepted
+	if got := dedup.Count(spam, "dave"); got != 3 {
+		t.Errorf("copy from a fourth pubkey: got %d, want 3", got)
+	}
+}
+
+func TestContentDedupIgnoresShortContent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dedup := NewContentDedup(ctx, time.Hour)
+	for _, pubkey := range []string{"alice", "bob", "carol"} {
+		dedup.Record("gm", pubkey)
+		if got := dedup.Count("gm", pubkey); got != 0 {
+			t.Errorf("short content should not be tracked, got %d", got)
+		}
+	}
+	if dedup.seen.Len() != 0 {
+		t.Errorf("expected no content tracked, got %d", dedup.seen.Len())
+	}
+}
+
+func TestContentDedupWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dedup := NewContentDedup(ctx, time.Hour)
+	const spam = "Claim your free airdrop before it's gone!"
+	dedup.Record(spam, "alice")
+
+	// Age alice's sighting past the window
+	dedup.Window = 0
+	dedup.Clean()
+	if dedup.seen.Len() != 0 {
+		t.Fatalf("expected expired sightings to be cleaned, got %d", dedup.seen.Len())
+	}
+
+	dedup.Window = time.Hour
+	if got := dedup.Count(spam, "bob"); got != 1 {
+		t.Errorf("after expiry: got %d, want 1", got)
+	}
+}
+
+func TestContentDedupBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dedup := NewContentDedup(ctx, time.Hour)
+	dedup.seen, _ = lru.New[[sha256.Size]byte, map[string]time.Time](2)
+	const spam = "Claim your free airdrop before it's gone!"
+	dedup.Record(spam, "alice")
+	dedup.Record("Something else entirely, written by hand", "bob")
+	dedup.Record("And a third message, long enough to count", "carol")
+
+	if dedup.seen.Len() != 2 || dedup.Count(spam, "dave") != 1 {
+		t.Errorf("expected the oldest content to be forgotten, got %d contents", dedup.seen.Len())
+	}
+}
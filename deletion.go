@@ -0,0 +1,208 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindDeletion is a NIP-09 deletion request.
+const kindDeletion = 5
+
+// deletedPrefix is the key prefix under which the event store keeps tombstones of the events
+// deleted by their authors, so that they can't be submitted again. The event store only uses
+// prefixes 0-8 and 255, the honeypot labels 128 and the store metadata 129.
+//   - deletedPrefix "e" <event id> <pubkey> records that the pubkey deleted the event ID
+//   - deletedPrefix "a" <kind:pubkey:d> holds the created_at of the deletion (big-endian)
+const deletedPrefix byte = 130
+
+// ErrDeleted is returned for events their author deleted with a NIP-09 deletion request.
+var ErrDeleted = errors.New("blocked: event was deleted by its author")
+
+// deletedEventKey is keyed by the deletion's author too, so that another pubkey "deleting"
+// the same ID can't replace the author's tombstone.
+func deletedEventKey(id, pubkey string) []byte {
+	return append(append([]byte{deletedPrefix, 'e'}, id...), pubkey...)
+}
+
+func deletedAddressKey(address string) []byte {
+	return append([]byte{deletedPrefix, 'a'}, address...)
+}
+
+// eventAddress returns the kind:pubkey:d address of replaceable and addressable events, or "".
+func eventAddress(e *nostr.Event) string {
+	switch {
+	case nostr.IsAddressableKind(e.Kind):
+		return strconv.Itoa(e.Kind) + ":" + e.PubKey + ":" + e.Tags.GetD()
+	case nostr.IsReplaceableKind(e.Kind):
+		return strconv.Itoa(e.Kind) + ":" + e.PubKey + ":"
+	default:
+		return ""
+	}
+}
+
+// ownAddress parses the kind:pubkey:d address of an "a" tag, if it belongs to the pubkey.
+func ownAddress(address, pubkey string) (kind int, dTag string, ok bool) {
+	parts := strings.SplitN(address, ":", 3)
+	if len(parts) != 3 || parts[1] != pubkey {
+		return 0, "", false
+	}
+	kind, err := strconv.Atoi(parts[0])
+	if err != nil || kind < 0 {
+		return 0, "", false
+	}
+	return kind, parts[2], true
+}
+
+// applyDeletion removes the events the stored deletion request refers to, and records
+// tombstones for them, including for events the relay doesn't have (yet). Only events of the
+// deletion's author are affected: "e" tags by ID, "a" tags all versions up to the deletion's
+// created_at. Deletion requests themselves can't be deleted. It returns how many events were removed.
+func applyDeletion(ctx context.Context, deletion *nostr.Event, d *Deps) int {
+	removed := 0
+	remove := func(e *nostr.Event) {
+		if e.PubKey != deletion.PubKey || e.Kind == kindDeletion {
+			return
+		}
+		if err := d.DB.DeleteEvent(ctx, e); err != nil {
+			eventLog.ErrorContext(ctx, "failed to delete event", "event", e.ID, "error", err)
+			return
+		}
+		d.Retention.Deleted(e)
+		if d.Latest != nil {
+			d.Latest.Removed(e)
+		}
+		removed++
+	}
+
+	err := d.Meta.Update(func(txn *badgerdb.Txn) error {
+		for _, tag := range deletion.Tags {
+			if len(tag) < 2 {
+				continue
+			}
+			switch tag[0] {
+			case "e":
+				if !nostr.IsValid32ByteHex(tag[1]) {
+					continue
+				}
+				if err := txn.Set(deletedEventKey(tag[1], deletion.PubKey), nil); err != nil {
+					return err
+				}
+
+			case "a":
+				if _, _, ok := ownAddress(tag[1], deletion.PubKey); !ok {
+					continue
+				}
+				// A deletion arriving after a more recent one doesn't shorten its reach
+				until, err := tombstoneUntil(txn, tag[1])
+				if err != nil {
+					return err
+				}
+				if until >= deletion.CreatedAt {
+					continue
+				}
+				if err := txn.Set(deletedAddressKey(tag[1]), binary.BigEndian.AppendUint64(nil, uint64(deletion.CreatedAt))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		eventLog.ErrorContext(ctx, "failed to record the tombstones of deletion", "deletion", deletion.ID, "error", err)
+	}
+
+	for _, tag := range deletion.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			if e := getEventByID(ctx, d.DB, tag[1]); e != nil {
+				remove(e)
+			}
+
+		case "a":
+			kind, dTag, ok := ownAddress(tag[1], deletion.PubKey)
+			if !ok {
+				continue
+			}
+			filter := nostr.Filter{Kinds: []int{kind}, Authors: []string{deletion.PubKey}, Until: &deletion.CreatedAt}
+			if nostr.IsAddressableKind(kind) {
+				filter.Tags = nostr.TagMap{"d": {dTag}}
+			}
+			events, err := d.DB.QueryEvents(ctx, filter)
+			if err != nil {
+				continue
+			}
+			var matched []*nostr.Event
+			for e := range events {
+				if eventAddress(e) == tag[1] {
+					matched = append(matched, e)
+				}
+			}
+			for _, e := range matched {
+				remove(e)
+			}
+		}
+	}
+
+	d.Obs.deletedEventCount.Add(uint64(removed))
+	return removed
+}
+
+// isDeleted reports whether the event was deleted by its author: its ID was in a deletion
+// request of the same pubkey, or its address was, by a request at least as recent.
+func isDeleted(db *badger.BadgerBackend, e *nostr.Event) bool {
+	address := eventAddress(e)
+	deleted := false
+	err := db.View(func(txn *badgerdb.Txn) error {
+		_, err := txn.Get(deletedEventKey(e.ID, e.PubKey))
+		switch {
+		case err == nil:
+			deleted = true
+			return nil
+		case !errors.Is(err, badgerdb.ErrKeyNotFound):
+			return err
+		}
+		if address == "" {
+			return nil
+		}
+
+		until, err := tombstoneUntil(txn, address)
+		deleted = until > 0 && until >= e.CreatedAt
+		return err
+	})
+	if err != nil {
+		eventLog.Error("failed to look up the tombstone of event", "event", e.ID, "error", err)
+	}
+	return deleted
+}
+
+// tombstoneUntil returns the created_at of the latest deletion of the address, 0 if there is none.
+func tombstoneUntil(txn *badgerdb.Txn, address string) (nostr.Timestamp, error) {
+	item, err := txn.Get(deletedAddressKey(address))
+	if errors.Is(err, badgerdb.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var until nostr.Timestamp
+	err = item.Value(func(value []byte) error {
+		if len(value) == 8 {
+			until = nostr.Timestamp(binary.BigEndian.Uint64(value))
+		}
+		return nil
+	})
+	return until, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDeletion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	db := newTestDB(t)
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+
+	authorSK, otherSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	author, _ := nostr.GetPublicKey(authorSK)
+	other, _ := nostr.GetPublicKey(otherSK)
+	cache.Update(time.Now(), PubRank{Pubkey: author, Rank: 0.9}, PubRank{Pubkey: other, Rank: 0})
+
+	publish := func(sk string, kind int, createdAt nostr.Timestamp, tags nostr.Tags) (*nostr.Event, error) {
+		e := &nostr.Event{Kind: kind, CreatedAt: createdAt, Tags: tags, Content: "content"}
+		e.Sign(sk)
+		return e, handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+	}
+	now := nostr.Now()
+
+	note, _ := publish(authorSK, 1, now-10, nil)
+	foreign, _ := publish(otherSK, 1, now-10, nil)
+	list, _ := publish(authorSK, 30000, now-10, nostr.Tags{{"d", "friends"}})
+	address := "30000:" + author + ":friends"
+
+	// Only the author's own events are deleted
+	if _, err := publish(authorSK, kindDeletion, now, nostr.Tags{{"e", note.ID}, {"e", foreign.ID}, {"a", address}}); err != nil {
+		t.Fatalf("deletion: %v", err)
+	}
+	if getEventByID(ctx, db, note.ID) != nil || getEventByID(ctx, db, list.ID) != nil {
+		t.Error("expected the author's events to be deleted")
+	}
+	if getEventByID(ctx, db, foreign.ID) == nil {
+		t.Error("expected another pubkey's event to be kept")
+	}
+	if obs.deletedEventCount.Load() != 2 {
+		t.Errorf("expected 2 deleted events, got %d", obs.deletedEventCount.Load())
+	}
+
+	// Deleted events can't come back, newer versions of a deleted address can
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, note, cfg, d); !errors.Is(err, ErrDeleted) {
+		t.Errorf("resubmitted note: got %v, want %v", err, ErrDeleted)
+	}
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, list, cfg, d); !errors.Is(err, ErrDeleted) {
+		t.Errorf("resubmitted list: got %v, want %v", err, ErrDeleted)
+	}
+	if _, err := publish(authorSK, 30000, now+10, nostr.Tags{{"d", "friends"}}); err != nil {
+		t.Errorf("newer version of a deleted address: %v", err)
+	}
+
+	// Low-trust pubkeys can delete their events too, and deleting ahead of time works
+	clock.advance(now + secondsPerDay)
+	later := &nostr.Event{Kind: 1, CreatedAt: now, Content: "not yet published"}
+	later.Sign(otherSK)
+	if _, err := publish(otherSK, kindDeletion, now, nostr.Tags{{"e", later.ID}, {"e", foreign.ID}}); err != nil {
+		t.Fatalf("low-trust deletion: %v", err)
+	}
+	if getEventByID(ctx, db, foreign.ID) != nil {
+		t.Error("expected the low-trust pubkey's event to be deleted")
+	}
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, later, cfg, d); !errors.Is(err, ErrDeleted) {
+		t.Errorf("event deleted before it was published: got %v, want %v", err, ErrDeleted)
+	}
+
+	// Another pubkey deleting the same ID doesn't undo the author's deletion
+	clock.advance(now + 2*secondsPerDay)
+	if _, err := publish(otherSK, kindDeletion, now+1, nostr.Tags{{"e", note.ID}}); err != nil {
+		t.Fatalf("stranger's deletion: %v", err)
+	}
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, note, cfg, d); !errors.Is(err, ErrDeleted) {
+		t.Errorf("note resubmitted after a stranger's deletion: got %v, want %v", err, ErrDeleted)
+	}
+}
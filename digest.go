@@ -0,0 +1,200 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Digest modes, sending the operators the new members of the community
+const (
+	digestOff  = "off"
+	digestNote = "note" // a text note of the relay's key, stored on the relay
+	digestDM   = "dm"   // NIP-17 direct messages to the ADMIN_PUBKEYS
+)
+
+// digestPrefix is the key prefix under which the pubkeys that crossed the mid threshold
+// are kept in the event store. The event store only uses prefixes 0-8 and 255, the
+// honeypot labels 128, the store metadata 129, the tombstones 130, the refresh queue 131,
+// the onboarding records 132, the compaction counts 133 and the payment records 134-135.
+//   - digestPrefix digestPending <pubkey> holds the JSON digestMember of a pubkey no digest listed yet
+//   - digestPrefix digestReported <pubkey> holds it once a digest listed the pubkey, so that
+//     listing the pending members doesn't walk the members of all the past digests
+const digestPrefix byte = 136
+
+const (
+	digestPending  byte = 0
+	digestReported byte = 1
+)
+
+// digestMaxListed is how many new members a digest lists, the most trusted first.
+const digestMaxListed = 100
+
+// digestMember is a pubkey that published at or above the mid threshold.
+type digestMember struct {
+	Pubkey  string    `json:"-"`
+	Crossed time.Time `json:"crossed"` // when its first event at the mid tier or above was accepted
+	Rank    float64   `json:"rank"`    // its rank then
+}
+
+// Digest reports to the operators the pubkeys that became active members of the community
+// since the last digest: those whose events were first accepted at or above MidThreshold,
+// so that human moderation can look at who joined the relay's trusted tiers. Each pubkey is
+// recorded once, in the relay's event store, and listed by a single digest.
+type Digest struct {
+	db    *badger.BadgerBackend
+	known *lru.Cache[string, struct{}] // pubkeys recorded, to skip the store on their next events
+
+	mode       string
+	secretKey  string
+	recipients []string
+}
+
+// NewDigest returns the Digest of the relay, or nil if DigestMode is off.
+func NewDigest(db *badger.BadgerBackend, cfg Config) *Digest {
+	if cfg.DigestMode == digestOff {
+		return nil
+	}
+	known, _ := lru.New[string, struct{}](10000)
+	return &Digest{db: db, known: known, mode: cfg.DigestMode, secretKey: cfg.DigestSecretKey, recipients: cfg.AdminPubkeys}
+}
+
+// Accepted records that an event of the pubkey, of the rank, was accepted: if the rank
+// is at or above MidThreshold for the first time, the pubkey goes to the next digest.
+// A nil Digest records nothing.
+func (g *Digest) Accepted(pubkey string, rank float64, now time.Time, cfg Config) {
+	if g == nil || rank < cfg.MidThreshold || g.known.Contains(pubkey) {
+		return
+	}
+	err := g.db.Update(func(txn *badgerdb.Txn) error {
+		for _, state := range []byte{digestPending, digestReported} {
+			if _, err := txn.Get(digestKey(state, pubkey)); !errors.Is(err, badgerdb.ErrKeyNotFound) {
+				return err
+			}
+		}
+		value, err := json.Marshal(digestMember{Crossed: now, Rank: rank})
+		if err != nil {
+			return err
+		}
+		return txn.Set(digestKey(digestPending, pubkey), value)
+	})
+	if err != nil {
+		relayLog.Error("failed to record a new member", "pubkey", pubkey, "error", err)
+		return
+	}
+	g.known.Add(pubkey, struct{}{})
+}
+
+// Pending returns the new members no digest listed yet, the most trusted first.
+func (g *Digest) Pending() ([]digestMember, error) {
+	var members []digestMember
+	err := g.db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{digestPrefix, digestPending}, PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var m digestMember
+			if err := it.Item().Value(func(value []byte) error { return json.Unmarshal(value, &m) }); err != nil {
+				return err
+			}
+			m.Pubkey = string(it.Item().Key()[2:])
+			members = append(members, m)
+		}
+		return nil
+	})
+	slices.SortStableFunc(members, func(a, b digestMember) int { return cmp.Compare(b.Rank, a.Rank) })
+	return members, err
+}
+
+// Send sends the digest of the new members, and moves them to the reported ones.
+// Nothing is sent when there is no new member.
+func (g *Digest) Send(ctx context.Context, now time.Time, cfg Config, d *Deps) error {
+	members, err := g.Pending()
+	if err != nil || len(members) == 0 {
+		return err
+	}
+	content := digestContent(members, now, cfg)
+
+	var events []nostr.Event
+	switch g.mode {
+	case digestNote:
+		note := nostr.Event{Kind: nostr.KindTextNote, CreatedAt: nostr.Timestamp(now.Unix()), Content: content}
+		if err := note.Sign(g.secretKey); err != nil {
+			return err
+		}
+		events = append(events, note)
+
+	case digestDM:
+		for _, admin := range g.recipients {
+			wrap, err := giftWrapDM(g.secretKey, content, nil, admin)
+			if err != nil {
+				return err
+			}
+			events = append(events, wrap)
+		}
+	}
+
+	for i := range events {
+		if err := Save(ctx, &events[i], d); err != nil {
+			return fmt.Errorf("failed to store the digest: %w", err)
+		}
+		if d.Live != nil {
+			d.Live.Stream(&events[i])
+		}
+	}
+
+	err = g.db.Update(func(txn *badgerdb.Txn) error {
+		for _, m := range members {
+			value, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := txn.Delete(digestKey(digestPending, m.Pubkey)); err != nil {
+				return err
+			}
+			if err := txn.Set(digestKey(digestReported, m.Pubkey), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.Obs.digestMembersCount.Add(uint64(len(members)))
+	relayLog.InfoContext(ctx, "digest sent", "members", len(members), "mode", g.mode)
+	return nil
+}
+
+func digestKey(state byte, pubkey string) []byte {
+	return append([]byte{digestPrefix, state}, pubkey...)
+}
+
+// digestContent lists the new members in plain text, with NIP-27 references to their profiles.
+func digestContent(members []digestMember, now time.Time, cfg Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "New members of %s as of %s: %d pubkeys published at or above the trust score of %.2f for the first time.\n",
+		cfg.RelayName, now.UTC().Format(time.DateOnly), len(members), cfg.MidThreshold)
+	for i, m := range members {
+		if i == digestMaxListed {
+			fmt.Fprintf(&b, "\n... and %d more.", len(members)-digestMaxListed)
+			break
+		}
+		npub, _ := nip19.EncodePublicKey(m.Pubkey)
+		fmt.Fprintf(&b, "\n- nostr:%s (rank %.2f, since %s)", npub, m.Rank, m.Crossed.UTC().Format(time.DateOnly))
+	}
+	return b.String()
+}
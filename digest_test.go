@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// newDigestDeps returns the deps of a relay sending digests in the mode, with the relay's key.
+func newDigestDeps(t *testing.T, ctx context.Context, mode string, env map[string]string) (Config, *Deps, string) {
+	t.Helper()
+	relayKey := nostr.GeneratePrivateKey()
+	cfg, err := buildConfig(func(key string) string {
+		if key == "DIGEST_MODE" {
+			return mode
+		}
+		if key == "DIGEST_SECRET_KEY" {
+			return relayKey
+		}
+		return env[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := &Observability{}
+	db := newTestDB(t)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), db, obs, clock)
+	d.Digest = NewDigest(db, cfg)
+	return cfg, d, relayKey
+}
+
+// queryStored returns the events of the store matching the filter.
+func queryStored(t *testing.T, ctx context.Context, d *Deps, filter nostr.Filter) []*nostr.Event {
+	t.Helper()
+	ch, err := d.DB.QueryEvents(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*nostr.Event
+	for e := range ch {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestDigestNote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, d, relayKey := newDigestDeps(t, ctx, digestNote, nil)
+	relayPubkey, _ := nostr.GetPublicKey(relayKey)
+	now := time.Now()
+
+	low, mid, high := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	lowPubkey, _ := nostr.GetPublicKey(low)
+	midPubkey, _ := nostr.GetPublicKey(mid)
+	highPubkey, _ := nostr.GetPublicKey(high)
+	d.Digest.Accepted(lowPubkey, cfg.MidThreshold/2, now, cfg)
+	d.Digest.Accepted(midPubkey, cfg.MidThreshold, now, cfg)
+	d.Digest.Accepted(highPubkey, 0.95, now, cfg)
+	d.Digest.Accepted(highPubkey, 0.99, now.Add(time.Hour), cfg)
+
+	pending, err := d.Digest.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Pubkey != highPubkey || pending[0].Rank != 0.95 || pending[1].Pubkey != midPubkey {
+		t.Fatalf("expected the high and mid pubkeys at their first crossing, most trusted first, got %+v", pending)
+	}
+
+	if err := d.Digest.Send(ctx, now, cfg, d); err != nil {
+		t.Fatal(err)
+	}
+	notes := queryStored(t, ctx, d, nostr.Filter{Authors: []string{relayPubkey}, Kinds: []int{nostr.KindTextNote}})
+	if len(notes) != 1 {
+		t.Fatalf("expected the digest note on the relay, got %d", len(notes))
+	}
+	for _, pubkey := range []string{highPubkey, midPubkey} {
+		npub, _ := nip19.EncodePublicKey(pubkey)
+		if !strings.Contains(notes[0].Content, "nostr:"+npub) {
+			t.Errorf("expected the digest to list %s, got %q", npub, notes[0].Content)
+		}
+	}
+	if got := d.Obs.digestMembersCount.Load(); got != 2 {
+		t.Errorf("expected 2 members reported, got %d", got)
+	}
+
+	// Members are reported once, and nothing is sent without new members
+	d.Digest.known.Purge()
+	d.Digest.Accepted(midPubkey, 0.9, now, cfg)
+	if pending, _ := d.Digest.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending member after the digest, got %+v", pending)
+	}
+	if err := d.Digest.Send(ctx, now, cfg, d); err != nil {
+		t.Fatal(err)
+	}
+	if notes := queryStored(t, ctx, d, nostr.Filter{Authors: []string{relayPubkey}}); len(notes) != 1 {
+		t.Errorf("expected no empty digest, got %d notes", len(notes))
+	}
+}
+
+func TestDigestDM(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	adminKey := nostr.GeneratePrivateKey()
+	adminPubkey, _ := nostr.GetPublicKey(adminKey)
+	cfg, d, relayKey := newDigestDeps(t, ctx, digestDM, map[string]string{"ADMIN_PUBKEYS": adminPubkey})
+	relayPubkey, _ := nostr.GetPublicKey(relayKey)
+
+	member, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	d.Digest.Accepted(member, 0.8, time.Now(), cfg)
+	if err := d.Digest.Send(ctx, time.Now(), cfg, d); err != nil {
+		t.Fatal(err)
+	}
+
+	wraps := queryStored(t, ctx, d, nostr.Filter{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": {adminPubkey}}})
+	if len(wraps) != 1 {
+		t.Fatalf("expected a gift wrap for the admin, got %d", len(wraps))
+	}
+	rumor, err := nip59.GiftUnwrap(*wraps[0], func(otherPubkey, ciphertext string) (string, error) {
+		key, err := nip44.GenerateConversationKey(otherPubkey, adminKey)
+		if err != nil {
+			return "", err
+		}
+		return nip44.Decrypt(ciphertext, key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, _ := nip19.EncodePublicKey(member)
+	if rumor.PubKey != relayPubkey || rumor.Kind != nostr.KindDirectMessage || !strings.Contains(rumor.Content, npub) {
+		t.Errorf("expected a direct message from the relay listing the member, got %+v", rumor)
+	}
+}
+
+func TestDigestConfig(t *testing.T) {
+	key := nostr.GeneratePrivateKey()
+	admin, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	for _, env := range []map[string]string{
+		{"DIGEST_MODE": "weekly"},
+		{"DIGEST_MODE": "note"},
+		{"DIGEST_MODE": "note", "DIGEST_SECRET_KEY": "nsec"},
+		{"DIGEST_MODE": "dm", "DIGEST_SECRET_KEY": key},
+		{"DIGEST_MODE": "note", "DIGEST_SECRET_KEY": key, "DIGEST_SCHEDULE": "every monday"},
+	} {
+		if _, err := buildConfig(func(k string) string { return env[k] }); err == nil {
+			t.Errorf("expected an error for %v", env)
+		}
+	}
+
+	cfg, err := buildConfig(func(k string) string {
+		return map[string]string{"DIGEST_MODE": "DM", "DIGEST_SECRET_KEY": key, "ADMIN_PUBKEYS": admin}[k]
+	})
+	if err != nil || cfg.DigestMode != digestDM || cfg.DigestSchedule != "0 9 * * 1" {
+		t.Errorf("expected the dm mode on mondays, got %q %q (%v)", cfg.DigestMode, cfg.DigestSchedule, err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errNonPublicAddress is returned when dialing an address outside the public internet.
+var errNonPublicAddress = errors.New("refusing to connect to a non-public address")
+
+// maxEgressRedirects bounds the redirects followed by the public client.
+const maxEgressRedirects = 5
+
+// isPublicIP reports whether the IP is routable on the public internet: not loopback,
+// private, link-local, multicast or unspecified.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// publicDialer returns a dialer that refuses non-public addresses. The check runs on the
+// address actually dialed, after DNS resolution, so a public hostname resolving to an
+// internal service (or rebinding to one) is refused too.
+func publicDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, host)
+			}
+			return nil
+		},
+	}
+}
+
+// newPublicClient returns an HTTP client for URLs taken from events, which anyone can
+// publish: it only connects to public addresses over HTTPS, redirects included.
+func newPublicClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         publicDialer(10 * time.Second).DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return errors.New("redirect to a non-https URL")
+			}
+			if len(via) >= maxEgressRedirects {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
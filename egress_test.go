@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1":    true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.0.10":    false,
+		"169.254.169.254": false,
+		"::1":             false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+	} {
+		if got := isPublicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", ip, got, public)
+		}
+	}
+}
+
+func TestPublicClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	if _, err := newPublicClient(time.Second).Do(req); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("expected the loopback server to be refused, got %v", err)
+	}
+}
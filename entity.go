@@ -0,0 +1,39 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"regexp"
+)
+
+// Entity spam actions for low-trust events embedding too many nostr references.
+const (
+	// entitySpamReject rejects the event at publish time.
+	entitySpamReject = "reject"
+	// entitySpamPoW accepts the event only with enough NIP-13 proof of work.
+	entitySpamPoW = "pow"
+)
+
+// nostrEntityRegex matches NIP-19 entities in content, with or without the NIP-21
+// "nostr:" prefix. Bech32 data uses a 32-character alphabet without "1", "b", "i" and "o".
+var nostrEntityRegex = regexp.MustCompile(`(?i)\b(?:nostr:)?(?:npub|nprofile|note|nevent|naddr)1[02-9ac-hj-np-z]{6,}`)
+
+// countNostrEntities returns the number of NIP-19 entities referenced in the content.
+func countNostrEntities(content string) int {
+	return len(nostrEntityRegex.FindAllStringIndex(content, -1))
+}
+
+// isEntitySpam reports whether the content references more than threshold nostr entities.
+// A threshold <= 0 disables detection.
+func isEntitySpam(content string, threshold int) bool {
+	if threshold <= 0 {
+		return false
+	}
+
+	// Fast path: every entity is longer than 10 bytes.
+	if len(content) <= threshold*10 {
+		return false
+	}
+	return countNostrEntities(content) > threshold
+}
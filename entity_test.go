@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testNpub   = "npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6"
+	testNevent = "nevent1qqstna2yrezu5wghjvswqqculvvwxsrcvu7uc0f78gan4xqhvz49d9spr3mhxue69uhkummnw3ez6un9d3shjtn4de6x2argwghx6egpr4mhxue69uhkummnw3ez6ur4vgh8wetvd3hhyer9wghxuet5nxnepm"
+)
+
+func TestCountNostrEntities(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{name: "plain text", content: "gm nostr", expected: 0},
+		{name: "nostr uri", content: "hello nostr:" + testNpub, expected: 1},
+		{name: "bare entity", content: "see " + testNevent, expected: 1},
+		{name: "mixed", content: "nostr:" + testNpub + " and nostr:" + testNevent + " " + testNpub, expected: 3},
+		{name: "word starting with prefix", content: "notebook1 npub", expected: 0},
+		{name: "entity inside a word", content: "xnpub1" + testNpub[5:], expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countNostrEntities(tt.content); got != tt.expected {
+				t.Errorf("countNostrEntities(%q) = %d, want %d", tt.content, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsEntitySpam(t *testing.T) {
+	spam := strings.Repeat("nostr:"+testNpub+" ", 6)
+
+	if isEntitySpam(spam, 0) {
+		t.Error("threshold 0 should disable detection")
+	}
+	if !isEntitySpam(spam, 5) {
+		t.Error("6 entities should exceed a threshold of 5")
+	}
+	if isEntitySpam(spam, 6) {
+		t.Error("6 entities should not exceed a threshold of 6")
+	}
+}
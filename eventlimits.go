@@ -0,0 +1,34 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventEnvelopeSize is the size of the `["EVENT",` and `]` around an event in a client message.
+const eventEnvelopeSize = len(`["EVENT",]`)
+
+// checkEventLimits enforces the limits on the number of tags, the content length
+// (in characters, as NIP-11 counts it) and the serialized size of an event,
+// cheapest first, so that oversized events are rejected before any other work.
+func checkEventLimits(e *nostr.Event, cfg Config) error {
+	if cfg.EventMaxTags > 0 && len(e.Tags) > cfg.EventMaxTags {
+		return fmt.Errorf("%w: %d tags, max %d", ErrTooManyTags, len(e.Tags), cfg.EventMaxTags)
+	}
+	if cfg.EventMaxContentLength > 0 && len(e.Content) > cfg.EventMaxContentLength {
+		if length := utf8.RuneCountInString(e.Content); length > cfg.EventMaxContentLength {
+			return fmt.Errorf("%w: %d characters, max %d", ErrContentTooLong, length, cfg.EventMaxContentLength)
+		}
+	}
+	if cfg.EventMaxSize > 0 {
+		if size := len(e.String()); size > cfg.EventMaxSize {
+			return fmt.Errorf("%w: %d bytes, max %d", ErrEventTooLarge, size, cfg.EventMaxSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckEventLimits(t *testing.T) {
+	cfg := Config{EventMaxSize: 1000, EventMaxTags: 3, EventMaxContentLength: 10}
+	sk := nostr.GeneratePrivateKey()
+	tags := func(n int) nostr.Tags {
+		var tags nostr.Tags
+		for range n {
+			tags = append(tags, nostr.Tag{"t", "x"})
+		}
+		return tags
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		tags     nostr.Tags
+		expected error
+	}{
+		{name: "within limits", content: "hello", tags: tags(3)},
+		{name: "multibyte content counted in characters", content: strings.Repeat("é", 10)},
+		{name: "too many tags", content: "hello", tags: tags(4), expected: ErrTooManyTags},
+		{name: "content too long", content: strings.Repeat("a", 11), expected: ErrContentTooLong},
+		{name: "too large", content: "hello", tags: nostr.Tags{{"t", strings.Repeat("a", 1000)}}, expected: ErrEventTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := signedEvent(t, sk, nostr.KindTextNote, tt.tags)
+			e.Content = tt.content
+			if err := checkEventLimits(e, cfg); !errors.Is(err, tt.expected) {
+				t.Errorf("checkEventLimits() = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+
+	if err := checkEventLimits(signedEvent(t, sk, nostr.KindTextNote, tags(10)), Config{}); err != nil {
+		t.Errorf("no limit expected without config, got %v", err)
+	}
+}
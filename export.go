@@ -0,0 +1,288 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// LabeledSample is a line of the dataset written by `wotrlay export -labeled`.
+type LabeledSample struct {
+	Label    string       `json:"label"`    // "spam" for honeypot samples, "ham" for accepted events
+	Decision string       `json:"decision"` // "rejected" or "accepted"
+	Reason   string       `json:"reason,omitempty"`
+	Rank     *float64     `json:"rank,omitempty"` // rank of the author when the event was received, if known
+	Event    *nostr.Event `json:"event"`
+}
+
+// export implements `wotrlay export [flags]`, and returns the process exit code.
+func export(args []string) int {
+	cfg := loadConfig()
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	storePath := flags.String("store", cfg.StorePath, "event store to export (Badger store of the relay's records with other backends)")
+	since := flags.Int64("since", 0, "only export the events created at or after this Unix timestamp")
+	limit := flags.Int("limit", 0, "max events to export, most recent first (0 exports all of them)")
+	labeled := flags.Bool("labeled", false, "export the honeypot and a sample of accepted events as a labeled dataset instead")
+	honeypotPath := flags.String("honeypot", cfg.HoneypotStorePath, "with -labeled, honeypot store to export as spam (empty skips it)")
+	accepted := flags.Int("accepted", 10000, "with -labeled, max accepted events to export, most recent first (0 skips them)")
+	decisionsPath := flags.String("decisions", cfg.DecisionLogFile, "with -labeled, decision log providing the rank of accepted events (empty skips it)")
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay export [flags]")
+		fmt.Fprintln(flags.Output(), "\nDumps the stored events as JSONL, most recent first, for `wotrlay import`. With -labeled,")
+		fmt.Fprintln(flags.Output(), "exports the honeypot and a sample of accepted events as a labeled JSONL dataset instead.")
+		fmt.Fprintln(flags.Output(), "Badger stores can't be opened by two processes: stop the relay or export from copies.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	ctx := context.Background()
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	if !*labeled {
+		cfg.StorePath = *storePath
+		db, meta, err := openStores(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer db.Close()
+		if db != EventStore(meta) {
+			defer meta.Close()
+		}
+		if err := checkStoreVersion(meta, eventStoreMigrations); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		n, err := exportEvents(ctx, db, nostr.Timestamp(*since), *limit, w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "exported %d events\n", n)
+		return 0
+	}
+
+	var ranks map[string]float64
+	if *decisionsPath != "" && *accepted > 0 {
+		var err error
+		if ranks, err = readAcceptedRanks(*decisionsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	if *honeypotPath != "" {
+		db := &badger.BadgerBackend{Path: *honeypotPath}
+		if err := db.Init(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open honeypot at %s: %v\n", *honeypotPath, err)
+			return 1
+		}
+		defer db.Close()
+		if err := checkStoreVersion(db, honeypotMigrations); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		n, err := exportHoneypot(ctx, db, w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "exported %d spam samples\n", n)
+	}
+
+	if *accepted > 0 {
+		cfg.StorePath = *storePath
+		db, meta, err := openStores(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer db.Close()
+		if db != EventStore(meta) {
+			defer meta.Close()
+		}
+		if err := checkStoreVersion(meta, eventStoreMigrations); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		n, err := exportAccepted(ctx, db, *accepted, ranks, w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "exported %d accepted events\n", n)
+	}
+	return 0
+}
+
+// errScanDone stops scanEvents early, without an error.
+var errScanDone = errors.New("scan done")
+
+// exportEvents writes the max most recent stored events (0 means all of them) created at
+// or after since, one per line, and returns how many were written.
+func exportEvents(ctx context.Context, db EventStore, since nostr.Timestamp, max int, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	n, err := scanEvents(ctx, db, max, func(e *nostr.Event) error {
+		if e.CreatedAt < since {
+			return errScanDone
+		}
+		return encoder.Encode(e)
+	})
+	if errors.Is(err, errScanDone) {
+		err = nil
+	}
+	return n, err
+}
+
+// exportHoneypot writes every honeypot sample with its label, and returns how many were written.
+func exportHoneypot(ctx context.Context, db *badger.BadgerBackend, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	return scanEvents(ctx, db, 0, func(e *nostr.Event) error {
+		sample := LabeledSample{Label: "spam", Decision: "rejected", Event: e}
+		if label, ok := readHoneypotLabel(db, e.ID); ok {
+			sample.Reason, sample.Rank = label.Reason, label.Rank
+		}
+		return encoder.Encode(sample)
+	})
+}
+
+// exportAccepted writes the max most recent stored events, with the rank their author had
+// when they were accepted if ranks has it, and returns how many were written.
+func exportAccepted(ctx context.Context, db EventStore, max int, ranks map[string]float64, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	return scanEvents(ctx, db, max, func(e *nostr.Event) error {
+		sample := LabeledSample{Label: "ham", Decision: "accepted", Event: e}
+		if rank, ok := ranks[e.ID]; ok {
+			sample.Rank = &rank
+		}
+		return encoder.Encode(sample)
+	})
+}
+
+// scanEvents calls fn with the events of the store from the most recent, until max events
+// (0 means all of them) were visited, and returns how many were.
+func scanEvents(ctx context.Context, db EventStore, max int, fn func(*nostr.Event) error) (int, error) {
+	return scanFilter(ctx, db, nostr.Filter{}, max, fn)
+}
+
+// scanFilter calls fn with the events matching the filter from the most recent, in batches,
+// until max events (0 means all of them) were visited, and returns how many were. The
+// filter's Until and Limit are replaced.
+func scanFilter(ctx context.Context, db EventStore, filter nostr.Filter, max int, fn func(*nostr.Event) error) (int, error) {
+	var until *nostr.Timestamp
+	visited := 0
+	limit := pruneBatchSize
+	boundary := make(map[string]bool) // events already visited at the until timestamp
+
+	for {
+		filter.Until, filter.Limit = until, limit
+		events, err := db.QueryEvents(ctx, filter)
+		if err != nil {
+			return visited, fmt.Errorf("failed to query events: %w", err)
+		}
+
+		var batch []*nostr.Event
+		for e := range events {
+			batch = append(batch, e)
+		}
+
+		fresh := 0
+		oldest := nostr.Now()
+		for _, e := range batch {
+			oldest = min(oldest, e.CreatedAt)
+			if boundary[e.ID] {
+				continue
+			}
+			if max > 0 && visited >= max {
+				return visited, nil
+			}
+			if err := fn(e); err != nil {
+				return visited, err
+			}
+			visited++
+			fresh++
+		}
+
+		if len(batch) < limit || ctx.Err() != nil {
+			return visited, ctx.Err()
+		}
+
+		// Continue from the oldest timestamp, skipping the events already visited at it.
+		// A batch with nothing new means the timestamp holds more events than a batch:
+		// query it again with a larger one.
+		if fresh == 0 {
+			limit *= 2
+			continue
+		}
+		limit = pruneBatchSize
+		if until == nil || oldest != *until {
+			clear(boundary)
+		}
+		for _, e := range batch {
+			if e.CreatedAt == oldest {
+				boundary[e.ID] = true
+			}
+		}
+		until = &oldest
+	}
+}
+
+// readAcceptedRanks returns the ranks recorded in a decision log for accepted events, by event ID.
+func readAcceptedRanks(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decision log: %w", err)
+	}
+	defer file.Close()
+
+	ranks := make(map[string]float64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record DecisionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Accepted && record.Rank != nil {
+			ranks[record.EventID] = *record.Rank
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read decision log: %w", err)
+	}
+	return ranks, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func testEvent(i int, createdAt nostr.Timestamp) *nostr.Event {
+	hex := fmt.Sprintf("%016x", i) + strings.Repeat("0", 48)
+	return &nostr.Event{ID: hex, PubKey: hex, Kind: 1, CreatedAt: createdAt}
+}
+
+func openTestStore(t *testing.T) *badger.BadgerBackend {
+	db := &badger.BadgerBackend{Path: t.TempDir()}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestScanEvents(t *testing.T) {
+	ctx := context.Background()
+	db := openTestStore(t)
+
+	// More events share a timestamp than fit in a batch
+	const total = 2*pruneBatchSize + 50
+	for i := range total {
+		createdAt := nostr.Timestamp(1000)
+		if i >= pruneBatchSize+100 {
+			createdAt = nostr.Timestamp(2000 - i)
+		}
+		if err := db.SaveEvent(ctx, testEvent(i, createdAt)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	n, err := scanEvents(ctx, db, 0, func(e *nostr.Event) error {
+		if seen[e.ID] {
+			t.Fatalf("event %s visited twice", e.ID)
+		}
+		seen[e.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != total || len(seen) != total {
+		t.Errorf("visited %d of %d events", n, total)
+	}
+
+	if n, _ := scanEvents(ctx, db, 10, func(*nostr.Event) error { return nil }); n != 10 {
+		t.Errorf("visited %d events with max 10", n)
+	}
+}
+
+func TestExportSamples(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	honeypotDB, storeDB := openTestStore(t), openTestStore(t)
+	honeypot := NewHoneypot(ctx, honeypotDB, 0, 0, &Observability{})
+
+	rank := 0.05
+	if !honeypot.Catch(ctx, testEvent(1, nostr.Now()), &rank, ErrLowQuality) {
+		t.Fatal("spam not caught")
+	}
+	for i := 2; i <= 4; i++ {
+		if err := storeDB.SaveEvent(ctx, testEvent(i, nostr.Now())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if n, err := exportHoneypot(ctx, honeypotDB, &buf); err != nil || n != 1 {
+		t.Fatalf("honeypot export: %d samples, %v", n, err)
+	}
+	ranks := map[string]float64{testEvent(2, 0).ID: 0.7}
+	if n, err := exportAccepted(ctx, storeDB, 2, ranks, &buf); err != nil || n != 2 {
+		t.Fatalf("accepted export: %d samples, %v", n, err)
+	}
+
+	var samples []LabeledSample
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var sample LabeledSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, sample)
+	}
+
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	spam := samples[0]
+	if spam.Label != "spam" || spam.Decision != "rejected" || spam.Reason != ErrLowQuality.Error() || spam.Rank == nil || *spam.Rank != rank {
+		t.Errorf("unexpected spam sample %+v", spam)
+	}
+	for _, ham := range samples[1:] {
+		if ham.Label != "ham" || ham.Decision != "accepted" || ham.Reason != "" {
+			t.Errorf("unexpected ham sample %+v", ham)
+		}
+		if ham.Event.ID == testEvent(2, 0).ID && (ham.Rank == nil || *ham.Rank != 0.7) {
+			t.Errorf("rank from the decision log missing in %+v", ham)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// Feedback modes, telling authors why their events were rejected
+const (
+	feedbackOff    = "off"
+	feedbackNotice = "notice" // an ephemeral event p-tagging the author
+	feedbackDM     = "dm"     // a NIP-17 direct message, stored for the author to fetch
+)
+
+// kindRejectionNotice is the ephemeral kind of the notices sent in the notice mode.
+const kindRejectionNotice = 21984
+
+// feedbackPerMinute bounds the feedback the relay sends, so that a flood of rejected
+// events from fresh keys can't make it sign and store as many messages.
+const feedbackPerMinute = 60
+
+// feedbackAdvice is what an author can do about the rejections worth explaining: those of
+// the trust policy, which legitimate users run into. Bans and spam are not explained.
+var feedbackAdvice = []struct {
+	err    error
+	advice string
+}{
+	{ErrKindNotAllowed, "This kind is only accepted from higher trust tiers."},
+	{ErrLongformNotAllowed, "Long-form articles are only accepted from higher trust tiers."},
+	{ErrFileNotAllowed, "File metadata is only accepted from higher trust tiers."},
+	{ErrURLNotAllowed, "Links are only accepted from higher trust tiers."},
+	{ErrRateLimited, "Your tier allows a limited number of events per day; tokens refill over time."},
+	{ErrIPRateLimited, "Too many events were published from your network; try again later."},
+	{ErrRepostLimited, "Your tier allows a limited number of reposts per day."},
+	{ErrFileLimited, "Your tier allows a limited number of files per day."},
+	{ErrEventTooOld, "Old events are only accepted from higher trust tiers."},
+	{ErrPoWRequired, "Attach NIP-13 proof of work to your events to get them accepted."},
+	{ErrHellthread, "Events tagging many people are only accepted from higher trust tiers."},
+	{ErrEntitySpam, "Events embedding many nostr references are only accepted from higher trust tiers."},
+	{ErrWritesClosed, "Writes from your tier are closed for now; try again later."},
+}
+
+// Feedback sends authors a message explaining why the relay rejected their event,
+// with their trust tier and how to improve it.
+type Feedback struct {
+	mode      string
+	secretKey string
+	cooldown  time.Duration // between two messages to a pubkey about the same reason
+}
+
+// NewFeedback returns the Feedback of the relay, or nil if FeedbackMode is off.
+func NewFeedback(cfg Config) *Feedback {
+	if cfg.FeedbackMode == feedbackOff {
+		return nil
+	}
+	return &Feedback{
+		mode:      cfg.FeedbackMode,
+		secretKey: cfg.FeedbackSecretKey,
+		cooldown:  time.Duration(cfg.FeedbackCooldownMinutes) * time.Minute,
+	}
+}
+
+// Send tells the author of the event why it was rejected with err, and returns the event
+// delivered, or nil if the rejection isn't explained or the author was told recently.
+func (f *Feedback) Send(ctx context.Context, e *nostr.Event, err error, cfg Config, d *Deps) *nostr.Event {
+	if f == nil || err == nil {
+		return nil
+	}
+	i := -1
+	for j, a := range feedbackAdvice {
+		if errors.Is(err, a.err) {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+
+	reason, _, _ := strings.Cut(err.Error(), ":")
+	if f.cooldown > 0 && !d.GlobalLimiter.Allow("feedback:"+e.PubKey+":"+reason, 1, 1/f.cooldown.Seconds()) {
+		return nil
+	}
+	if !d.GlobalLimiter.Allow("feedback", feedbackPerMinute, feedbackPerMinute/60.0) {
+		return nil
+	}
+
+	check := checkWrite(ctx, e.PubKey, cfg, d)
+	content := feedbackContent(e, err, feedbackAdvice[i].advice, check, cfg)
+	tags := nostr.Tags{
+		{"e", e.ID},
+		{"k", strconv.Itoa(e.Kind)},
+		{"reason", reason},
+		{"tier", check.Tier},
+	}
+
+	var notice nostr.Event
+	switch f.mode {
+	case feedbackNotice:
+		notice = nostr.Event{Kind: kindRejectionNotice, CreatedAt: nostr.Now(), Content: content, Tags: append(tags, nostr.Tag{"p", e.PubKey})}
+		if err := notice.Sign(f.secretKey); err != nil {
+			relayLog.ErrorContext(ctx, "failed to sign feedback", "error", err)
+			return nil
+		}
+
+	case feedbackDM:
+		wrap, err := giftWrapDM(f.secretKey, content, tags, e.PubKey)
+		if err != nil {
+			relayLog.ErrorContext(ctx, "failed to wrap feedback", "error", err)
+			return nil
+		}
+		notice = wrap
+		if err := Save(ctx, &notice, d); err != nil {
+			relayLog.WarnContext(ctx, "failed to store feedback", "error", err)
+			return nil
+		}
+	}
+
+	if d.Live != nil {
+		d.Live.Stream(&notice)
+	}
+	d.Obs.feedbackCount.Add(1)
+	return &notice
+}
+
+// giftWrapDM returns a NIP-17 direct message from the key to the recipient, gift wrapped (NIP-59).
+func giftWrapDM(secretKey, content string, tags nostr.Tags, recipient string) (nostr.Event, error) {
+	pubkey, err := nostr.GetPublicKey(secretKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	rumor := nostr.Event{
+		Kind:      nostr.KindDirectMessage,
+		PubKey:    pubkey,
+		CreatedAt: nostr.Now(),
+		Content:   content,
+		Tags:      append(tags, nostr.Tag{"p", recipient}),
+	}
+	rumor.ID = rumor.GetID()
+
+	key, err := nip44.GenerateConversationKey(recipient, secretKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	return nip59.GiftWrap(rumor, recipient,
+		func(plaintext string) (string, error) { return nip44.Encrypt(plaintext, key) },
+		func(seal *nostr.Event) error { return seal.Sign(secretKey) },
+		nil,
+	)
+}
+
+// feedbackContent explains the rejection of the event in plain text.
+func feedbackContent(e *nostr.Event, err error, advice string, check WriteCheck, cfg Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your event %s (kind %d) was rejected: %v.\n", e.ID, e.Kind, err)
+	fmt.Fprintf(&b, "Your trust tier on this relay is %s (rank %.2f), allowing %.0f events a day.\n", check.Tier, check.Rank, check.DailyRate)
+	b.WriteString(advice)
+	if errors.Is(err, ErrRateLimited) && check.RefillIn > 0 {
+		fmt.Fprintf(&b, " Your budget is full again in %s.", time.Duration(check.RefillIn)*time.Second)
+	}
+	b.WriteString("\nYour rank comes from the web of trust: being followed by people the relay trusts raises it.")
+	if check.PoW > 0 {
+		fmt.Fprintf(&b, " Until then, events with NIP-13 proof of work of difficulty %d are accepted.", check.PoW)
+	}
+	if cfg.PaymentsURL != "" {
+		fmt.Fprintf(&b, " A paid membership raises it too: %s", cfg.PaymentsURL)
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// newFeedbackDeps returns the deps of a relay sending feedback in the mode, with the relay's key.
+func newFeedbackDeps(t *testing.T, ctx context.Context, mode string) (Config, *Deps, string) {
+	t.Helper()
+	relayKey := nostr.GeneratePrivateKey()
+	cfg, err := buildConfig(func(key string) string {
+		return map[string]string{"FEEDBACK_MODE": mode, "FEEDBACK_SECRET_KEY": relayKey}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, clock)
+	d.Feedback = NewFeedback(cfg)
+	return cfg, d, relayKey
+}
+
+func TestFeedbackNotice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, d, relayKey := newFeedbackDeps(t, ctx, feedbackNotice)
+	relayPubkey, _ := nostr.GetPublicKey(relayKey)
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	d.Cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0})
+	e := &nostr.Event{Kind: 7, CreatedAt: nostr.Now(), Content: "+"}
+	e.Sign(sk)
+
+	err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+	if !errors.Is(err, ErrKindNotAllowed) {
+		t.Fatalf("expected %v, got %v", ErrKindNotAllowed, err)
+	}
+	notice := d.Feedback.Send(ctx, e, err, cfg, d)
+	if notice == nil {
+		t.Fatal("expected a notice")
+	}
+	if ok, _ := notice.CheckSignature(); !ok || notice.PubKey != relayPubkey || notice.Kind != kindRejectionNotice {
+		t.Errorf("expected an ephemeral notice signed by the relay, got %+v", notice)
+	}
+	for _, tag := range []nostr.Tag{{"p", pubkey}, {"e", e.ID}, {"k", "7"}, {"reason", "kind-not-allowed"}, {"tier", "low"}} {
+		if !notice.Tags.ContainsAny(tag[0], []string{tag[1]}) {
+			t.Errorf("expected the tag %v, got %v", tag, notice.Tags)
+		}
+	}
+	if !strings.Contains(notice.Content, "trust tier on this relay is low") {
+		t.Errorf("expected the tier to be explained, got %q", notice.Content)
+	}
+
+	// The author is told once about a reason, and never about bans
+	if d.Feedback.Send(ctx, e, err, cfg, d) != nil {
+		t.Error("expected no notice during the cooldown")
+	}
+	if d.Feedback.Send(ctx, e, ErrRateLimited, cfg, d) == nil {
+		t.Error("expected a notice for another reason")
+	}
+	if d.Feedback.Send(ctx, e, ErrBanned, cfg, d) != nil {
+		t.Error("bans must not be explained")
+	}
+	if got := d.Obs.feedbackCount.Load(); got != 2 {
+		t.Errorf("expected 2 notices sent, got %d", got)
+	}
+}
+
+func TestFeedbackDM(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg, d, relayKey := newFeedbackDeps(t, ctx, feedbackDM)
+	relayPubkey, _ := nostr.GetPublicKey(relayKey)
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	e := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	e.Sign(sk)
+
+	wrap := d.Feedback.Send(ctx, e, ErrRateLimited, cfg, d)
+	if wrap == nil || wrap.Kind != nostr.KindGiftWrap || !wrap.Tags.ContainsAny("p", []string{pubkey}) {
+		t.Fatalf("expected a gift wrap to the author, got %+v", wrap)
+	}
+	rumor, err := nip59.GiftUnwrap(*wrap, func(other, ciphertext string) (string, error) {
+		key, err := nip44.GenerateConversationKey(other, sk)
+		if err != nil {
+			return "", err
+		}
+		return nip44.Decrypt(ciphertext, key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rumor.PubKey != relayPubkey || rumor.Kind != nostr.KindDirectMessage || !strings.Contains(rumor.Content, "rate-limited") {
+		t.Errorf("unexpected message %+v", rumor)
+	}
+
+	stored, err := d.DB.QueryEvents(ctx, nostr.Filter{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": {pubkey}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range stored {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("expected the message to be stored for the author, got %d", n)
+	}
+}
+
+func TestFeedbackConfig(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"FEEDBACK_MODE": "email"},
+		{"FEEDBACK_MODE": "notice"},
+		{"FEEDBACK_MODE": "dm", "FEEDBACK_SECRET_KEY": "nsec1nope"},
+	} {
+		if _, err := buildConfig(func(key string) string { return env[key] }); err == nil {
+			t.Errorf("%v: expected an error", env)
+		}
+	}
+}
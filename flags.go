@@ -0,0 +1,83 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Flag is a policy capability that can be switched on or off per tier at runtime.
+type Flag int
+
+const (
+	// FlagURLPolicy: reject kind 1 events containing URLs
+	FlagURLPolicy Flag = iota
+	// FlagPoWFallback: accept entity spam carrying enough proof of work instead of rejecting it
+	FlagPoWFallback
+	// FlagBackfill: old events follow the tier's backfill policy instead of the normal rate limits
+	FlagBackfill
+	// FlagShadowBan: events are acknowledged as accepted but silently dropped
+	FlagShadowBan
+
+	flagCount
+)
+
+var flagNames = [flagCount]string{"url_policy", "pow_fallback", "backfill", "shadow_ban"}
+
+func (f Flag) String() string {
+	return flagNames[f]
+}
+
+// parseFlag parses a flag name, case-insensitively.
+func parseFlag(s string) (Flag, bool) {
+	i := slices.Index(flagNames[:], strings.ToLower(strings.TrimSpace(s)))
+	return Flag(i), i >= 0
+}
+
+// FeatureFlags holds the state of every flag for every tier. It starts from the
+// configuration and can then be changed at runtime through the admin API.
+type FeatureFlags struct {
+	enabled [flagCount][TierHigh + 1]atomic.Bool
+}
+
+// NewFeatureFlags returns the flags matching the configuration, so that the relay behaves
+// as configured until a flag is changed.
+func NewFeatureFlags(cfg Config) *FeatureFlags {
+	f := &FeatureFlags{}
+	f.Set(FlagURLPolicy, TierLow, cfg.URLPolicyEnabled)
+	f.Set(FlagPoWFallback, TierLow, cfg.EntitySpamAction == entitySpamPoW)
+	f.Set(FlagBackfill, TierHigh, cfg.HighThreshold != nil)
+	for tier, policy := range cfg.BackfillPolicies {
+		f.Set(FlagBackfill, tier, policy != backfillNormal)
+	}
+	for _, tier := range cfg.ShadowBanTiers {
+		f.Set(FlagShadowBan, tier, true)
+	}
+	return f
+}
+
+// Enabled returns whether the flag is on for events from pubkeys in the tier.
+func (f *FeatureFlags) Enabled(flag Flag, tier Tier) bool {
+	return f.enabled[flag][tier].Load()
+}
+
+// Set switches the flag on or off for the tier.
+func (f *FeatureFlags) Set(flag Flag, tier Tier, on bool) {
+	f.enabled[flag][tier].Store(on)
+}
+
+// State returns the state of every flag by name and tier name.
+func (f *FeatureFlags) State() map[string]map[string]bool {
+	state := make(map[string]map[string]bool, flagCount)
+	for flag := range flagCount {
+		tiers := make(map[string]bool, TierHigh+1)
+		for tier := TierLow; tier <= TierHigh; tier++ {
+			tiers[tier.String()] = f.Enabled(flag, tier)
+		}
+		state[flag.String()] = tiers
+	}
+	return state
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestNewFeatureFlags(t *testing.T) {
+	high := 0.9
+	flags := NewFeatureFlags(Config{
+		URLPolicyEnabled: true,
+		EntitySpamAction: entitySpamReject,
+		HighThreshold:    &high,
+		ShadowBanTiers:   []Tier{TierMid},
+	})
+
+	tests := []struct {
+		flag Flag
+		tier Tier
+		want bool
+	}{
+		{FlagURLPolicy, TierLow, true},
+		{FlagURLPolicy, TierMid, false},
+		{FlagPoWFallback, TierLow, false},
+		{FlagBackfill, TierMid, false},
+		{FlagBackfill, TierHigh, true},
+		{FlagShadowBan, TierLow, false},
+		{FlagShadowBan, TierMid, true},
+	}
+	for _, tt := range tests {
+		if got := flags.Enabled(tt.flag, tt.tier); got != tt.want {
+			t.Errorf("%s/%s: got %t, want %t", tt.flag, tt.tier, got, tt.want)
+		}
+	}
+
+	flags.Set(FlagURLPolicy, TierMid, true)
+	if state := flags.State(); !state["url_policy"]["mid"] || state["backfill"]["low"] {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	if flags := NewFeatureFlags(Config{EntitySpamAction: entitySpamPoW}); !flags.Enabled(FlagPoWFallback, TierLow) || flags.Enabled(FlagBackfill, TierHigh) {
+		t.Error("pow action should enable pow_fallback, and no high threshold disables backfill")
+	}
+}
+
+func TestParseFlag(t *testing.T) {
+	if flag, ok := parseFlag(" Shadow_Ban "); !ok || flag != FlagShadowBan {
+		t.Errorf("got %v %t, want shadow_ban", flag, ok)
+	}
+	if _, ok := parseFlag("free_lunch"); ok {
+		t.Error("unknown flag should not parse")
+	}
+}
@@ -0,0 +1,167 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	rankProviderRelatr = "relatr"
+	rankProviderLocal  = "local"
+)
+
+// followGraphBatch is the number of authors whose follow lists are fetched per query.
+const followGraphBatch = 250
+
+// FollowGraph is a rank provider computing trust scores locally, from the follow lists
+// (kind 3) crawled from seed relays. Seed pubkeys have rank 1. Other pubkeys get, for each
+// of their followers in the graph, the weight of the follower's depth (hops from the seeds),
+// and their rank is the sum of these weights, capped at 1. Follow lists are crawled up
+// to the depth of the last weight, and the graph is recomputed every Interval.
+type FollowGraph struct {
+	ranks atomic.Pointer[map[string]float64]
+
+	seeds   []string
+	weights []float64 // weight of a follow from a pubkey at each depth
+	fetch   func(ctx context.Context, authors []string) []*nostr.Event
+
+	Interval time.Duration // How often the graph is recomputed
+}
+
+func NewFollowGraph(ctx context.Context, cfg Config) *FollowGraph {
+	pool := nostr.NewSimplePool(ctx)
+	g := &FollowGraph{
+		seeds:    cfg.WoTSeedPubkeys,
+		weights:  cfg.WoTHopWeights,
+		Interval: time.Duration(cfg.WoTRecomputeHours) * time.Hour,
+	}
+
+	g.fetch = func(ctx context.Context, authors []string) []*nostr.Event {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		var events []*nostr.Event
+		filter := nostr.Filter{Kinds: []int{nostr.KindFollowList}, Authors: authors}
+		for e := range pool.FetchMany(ctx, cfg.WoTSeedRelays, filter) {
+			events = append(events, e.Event)
+		}
+		return events
+	}
+	return g
+}
+
+// Rank returns the rank of the pubkey in the last computed graph (0 if it isn't part of it).
+func (g *FollowGraph) Rank(pubkey string) float64 {
+	if ranks := g.ranks.Load(); ranks != nil {
+		return (*ranks)[pubkey]
+	}
+	return 0
+}
+
+// Compute crawls the follow lists and returns the rank of every pubkey in the graph.
+func (g *FollowGraph) Compute(ctx context.Context) (map[string]float64, error) {
+	depths := make(map[string]int, len(g.seeds))
+	for _, seed := range g.seeds {
+		depths[seed] = 0
+	}
+
+	// follows maps each crawled pubkey to the distinct pubkeys it follows
+	follows := make(map[string]map[string]struct{})
+	frontier := g.seeds
+	for depth := 0; depth < len(g.weights) && len(frontier) > 0; depth++ {
+		latest := make(map[string]*nostr.Event, len(frontier))
+		for start := 0; start < len(frontier); start += followGraphBatch {
+			end := min(start+followGraphBatch, len(frontier))
+			for _, e := range g.fetch(ctx, frontier[start:end]) {
+				if current, ok := latest[e.PubKey]; !ok || e.CreatedAt > current.CreatedAt {
+					latest[e.PubKey] = e
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		var next []string
+		for author, e := range latest {
+			followed := make(map[string]struct{})
+			for _, tag := range e.Tags {
+				if len(tag) < 2 || tag[0] != "p" || tag[1] == author || !nostr.IsValid32ByteHex(tag[1]) {
+					continue
+				}
+				followed[tag[1]] = struct{}{}
+				if _, seen := depths[tag[1]]; !seen {
+					depths[tag[1]] = depth + 1
+					next = append(next, tag[1])
+				}
+			}
+			follows[author] = followed
+		}
+		frontier = next
+	}
+
+	if len(follows) == 0 {
+		return nil, errors.New("no follow list found on the seed relays")
+	}
+
+	ranks := make(map[string]float64, len(depths))
+	for follower, followed := range follows {
+		weight := g.weights[depths[follower]]
+		for pubkey := range followed {
+			ranks[pubkey] = min(1, ranks[pubkey]+weight)
+		}
+	}
+	for _, seed := range g.seeds {
+		ranks[seed] = 1
+	}
+	return ranks, nil
+}
+
+// Run computes the graph now and then every Interval, calling updated after each computation.
+// When a computation fails, the previous graph is kept.
+func (g *FollowGraph) Run(ctx context.Context, updated func()) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		ranks, err := g.Compute(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			rankLog.Error("failed to compute the follow graph", "error", err)
+		default:
+			g.ranks.Store(&ranks)
+			rankLog.Info("computed the follow graph", "pubkeys", len(ranks), "duration", time.Since(start).Round(time.Second))
+			updated()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseWeights parses a comma-separated list of weights between 0 and 1.
+func parseWeights(items []string) ([]float64, bool) {
+	weights := make([]float64, 0, len(items))
+	for _, item := range items {
+		weight, err := strconv.ParseFloat(item, 64)
+		if err != nil || weight < 0 || weight > 1 {
+			return nil, false
+		}
+		weights = append(weights, weight)
+	}
+	return weights, len(weights) > 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestFollowGraphCompute(t *testing.T) {
+	pk := func(i int) string { return strings.Repeat(string(rune('a'+i)), 64) }
+	seed, friend, other, friendOfFriend, stranger := pk(0), pk(1), pk(2), pk(3), pk(4)
+
+	followList := func(author string, createdAt nostr.Timestamp, followed ...string) *nostr.Event {
+		e := &nostr.Event{PubKey: author, Kind: nostr.KindFollowList, CreatedAt: createdAt}
+		for _, pubkey := range followed {
+			e.Tags = append(e.Tags, nostr.Tag{"p", pubkey})
+		}
+		return e
+	}
+	lists := []*nostr.Event{
+		followList(seed, 1, friend, other, "not a pubkey"),
+		followList(friend, 1, stranger),
+		followList(friend, 2, friendOfFriend, friendOfFriend, seed),
+		followList(other, 1, friendOfFriend),
+		followList(friendOfFriend, 1, stranger),
+	}
+
+	var crawled []string
+	g := &FollowGraph{seeds: []string{seed}, weights: []float64{1, 0.25}}
+	g.fetch = func(_ context.Context, authors []string) []*nostr.Event {
+		crawled = append(crawled, authors...)
+		var events []*nostr.Event
+		for _, e := range lists {
+			if slices.Contains(authors, e.PubKey) {
+				events = append(events, e)
+			}
+		}
+		return events
+	}
+
+	ranks, err := g.Compute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{seed: 1, friend: 1, other: 1, friendOfFriend: 0.5}
+	for pubkey, rank := range want {
+		if ranks[pubkey] != rank {
+			t.Errorf("%s...: got rank %v, want %v", pubkey[:4], ranks[pubkey], rank)
+		}
+	}
+	if _, ok := ranks[stranger]; ok {
+		t.Error("only the latest follow list of a pubkey counts, and the crawl stops at the last weight's depth")
+	}
+	if slices.Contains(crawled, friendOfFriend) {
+		t.Error("pubkeys beyond the last weight's depth should not be crawled")
+	}
+
+	g.ranks.Store(&ranks)
+	if g.Rank(friendOfFriend) != 0.5 || g.Rank(stranger) != 0 {
+		t.Errorf("got ranks %v and %v", g.Rank(friendOfFriend), g.Rank(stranger))
+	}
+
+	g.fetch = func(context.Context, []string) []*nostr.Event { return nil }
+	if _, err := g.Compute(context.Background()); err == nil {
+		t.Error("expected an error when no follow list is found")
+	}
+}
+
+func TestRankCacheFollowGraph(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := NewRankCache(ctx, Config{RankProvider: rankProviderLocal}, &Observability{})
+	g := &FollowGraph{}
+	ranks := map[string]float64{"trusted": 0.8}
+	g.ranks.Store(&ranks)
+	cache.graph.Store(g)
+
+	if rank, err := cache.GetRank(ctx, "trusted"); err != nil || rank != 0.8 {
+		t.Errorf("got rank %v, %v", rank, err)
+	}
+	if rank, err := cache.GetRank(ctx, "unknown"); err != nil || rank != 0 {
+		t.Errorf("got rank %v, %v", rank, err)
+	}
+}
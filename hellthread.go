@@ -0,0 +1,42 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Hellthread actions for low-trust events tagging too many participants.
+const (
+	// hellthreadReject rejects the event at publish time.
+	hellthreadReject = "reject"
+	// hellthreadStrip stores the event but keeps it out of "#p" queries,
+	// so it is readable in its thread without reaching anyone's notifications.
+	hellthreadStrip = "strip"
+)
+
+// countPTags returns the number of distinct pubkeys referenced by "p" tags.
+func countPTags(e *nostr.Event) int {
+	seen := make(map[string]struct{}, 8)
+	for _, tag := range e.Tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			seen[tag[1]] = struct{}{}
+		}
+	}
+	return len(seen)
+}
+
+// isHellthread reports whether the event p-tags more than threshold distinct participants.
+// A threshold <= 0 disables detection.
+func isHellthread(e *nostr.Event, threshold int) bool {
+	if threshold <= 0 {
+		return false
+	}
+
+	// Fast path: can't exceed the threshold without enough tags.
+	if len(e.Tags) <= threshold {
+		return false
+	}
+	return countPTags(e) > threshold
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func pTags(n int) nostr.Tags {
+	tags := make(nostr.Tags, 0, n)
+	for i := range n {
+		tags = append(tags, nostr.Tag{"p", fmt.Sprintf("%064x", i)})
+	}
+	return tags
+}
+
+func TestIsHellthread(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      nostr.Tags
+		threshold int
+		expected  bool
+	}{
+		{name: "disabled", tags: pTags(500), threshold: 0, expected: false},
+		{name: "below threshold", tags: pTags(10), threshold: 20, expected: false},
+		{name: "at threshold", tags: pTags(20), threshold: 20, expected: false},
+		{name: "above threshold", tags: pTags(21), threshold: 20, expected: true},
+		{
+			name:      "duplicate p tags count once",
+			tags:      append(pTags(5), pTags(5)...),
+			threshold: 5,
+			expected:  false,
+		},
+		{
+			name:      "other tags are ignored",
+			tags:      append(pTags(3), nostr.Tag{"e", "abc"}, nostr.Tag{"t", "nostr"}, nostr.Tag{"t", "spam"}),
+			threshold: 3,
+			expected:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &nostr.Event{Kind: 1, Tags: tt.tags}
+			if got := isHellthread(e, tt.threshold); got != tt.expected {
+				t.Errorf("isHellthread() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestQueryStripsHellthreads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"HELLTHREAD_THRESHOLD": "5", "HELLTHREAD_ACTION": hellthreadStrip}[key]
+	})
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, clock)
+
+	// Mentions of the target, the most recent ones in hellthreads of an untrusted author
+	sk := nostr.GeneratePrivateKey()
+	target := fmt.Sprintf("%064x", 0)
+	now := nostr.Now()
+	var mentions []string
+	for i := range 9 {
+		tags := pTags(1)
+		if i >= 3 {
+			tags = pTags(10)
+		}
+		e := &nostr.Event{Kind: nostr.KindTextNote, Tags: tags, CreatedAt: now - nostr.Timestamp(10-i)}
+		if err := e.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.DB.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 {
+			mentions = append(mentions, e.ID)
+		}
+	}
+
+	// The stripped hellthreads don't take up the limit of the notification query
+	served, err := Query(ctx, nil, nostr.Filters{{Tags: nostr.TagMap{"p": {target}}, Limit: 3}}, cfg, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 3 {
+		t.Fatalf("expected the 3 plain mentions, got %d events", len(served))
+	}
+	for i, e := range served {
+		if want := mentions[2-i]; e.ID != want {
+			t.Errorf("event %d: got %s, want %s", i, e.ID, want)
+		}
+	}
+
+	// Other queries serve them
+	if served, _ := Query(ctx, nil, nostr.Filters{{Kinds: []int{nostr.KindTextNote}, Limit: 3}}, cfg, d); len(served) != 3 || len(served[0].Tags) != 10 {
+		t.Errorf("expected the 3 most recent hellthreads, got %d events", len(served))
+	}
+}
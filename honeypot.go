@@ -0,0 +1,128 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// spamErrors are the rejection reasons that mark an event as spam.
+// Policy rejections (kind gating, rate limits, proof of work) are not spam samples.
+var spamErrors = []error{
+	ErrBanned,
+	ErrURLNotAllowed,
+	ErrHellthread,
+	ErrEntitySpam,
+	ErrDuplicateContent,
+	ErrLowQuality,
+}
+
+// honeypotLabelPrefix is the key prefix under which the honeypot stores the label of each
+// sample, next to the events. The event store only uses prefixes 0-8 and 255.
+const honeypotLabelPrefix byte = 128
+
+// HoneypotLabel records why a honeypot sample was rejected.
+type HoneypotLabel struct {
+	Reason string    `json:"reason"`
+	Rank   *float64  `json:"rank,omitempty"` // cached rank of the author, if known
+	Time   time.Time `json:"time"`
+}
+
+// Honeypot collects spam samples: events rejected for spam reasons are stored in
+// an isolated store that is never queried, and the spammer is told they were accepted.
+type Honeypot struct {
+	db        *badger.BadgerBackend
+	retention *Retention
+	obs       *Observability
+	labelTTL  time.Duration // labels expire with the samples they describe (0 keeps them forever)
+}
+
+func NewHoneypot(ctx context.Context, db *badger.BadgerBackend, maxAge time.Duration, maxEvents int64, obs *Observability) *Honeypot {
+	return &Honeypot{
+		db:        db,
+		retention: NewRetention(ctx, db, maxAge, maxEvents),
+		obs:       obs,
+		labelTTL:  maxAge,
+	}
+}
+
+// isSpam returns whether the rejection reason marks the event as spam.
+func isSpam(err error) bool {
+	for _, spam := range spamErrors {
+		if errors.Is(err, spam) {
+			return true
+		}
+	}
+	return false
+}
+
+// Catch stores the event and its label if it was rejected for a spam reason, and reports
+// whether the rejection should be hidden from the client. Once the honeypot is full, spam
+// is still hidden but no longer stored.
+func (h *Honeypot) Catch(ctx context.Context, e *nostr.Event, rank *float64, err error) bool {
+	if !isSpam(err) {
+		return false
+	}
+
+	h.obs.honeypotCount.Add(1)
+	if h.retention.Full() {
+		return true
+	}
+
+	// Spammers often resend the same events, which need no logging
+	if err := h.db.SaveEvent(ctx, e); errors.Is(err, eventstore.ErrDupEvent) {
+		return true
+	} else if err != nil {
+		eventLog.ErrorContext(ctx, "failed to save event to the honeypot", "event", e.ID, "error", err)
+		return true
+	}
+	h.retention.Stored()
+
+	if err := h.label(e.ID, HoneypotLabel{Reason: err.Error(), Rank: rank, Time: time.Now().UTC()}); err != nil {
+		eventLog.ErrorContext(ctx, "failed to label event in the honeypot", "event", e.ID, "error", err)
+	}
+	return true
+}
+
+func (h *Honeypot) label(id string, label HoneypotLabel) error {
+	value, err := json.Marshal(label)
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(txn *badgerdb.Txn) error {
+		entry := badgerdb.NewEntry(honeypotLabelKey(id), value)
+		if h.labelTTL > 0 {
+			entry = entry.WithTTL(h.labelTTL)
+		}
+		return txn.SetEntry(entry)
+	})
+}
+
+// readHoneypotLabel returns the label of a honeypot sample, if it has one.
+func readHoneypotLabel(db *badger.BadgerBackend, id string) (HoneypotLabel, bool) {
+	var label HoneypotLabel
+	err := db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(honeypotLabelKey(id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(value []byte) error {
+			return json.Unmarshal(value, &label)
+		})
+	})
+	return label, err == nil
+}
+
+func honeypotLabelKey(id string) []byte {
+	return append([]byte{honeypotLabelPrefix}, id...)
+}
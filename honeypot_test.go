@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHoneypotCatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := &badger.BadgerBackend{Path: t.TempDir()}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	obs := &Observability{}
+	honeypot := NewHoneypot(ctx, db, 0, 2, obs)
+
+	tests := []struct {
+		id   string
+		err  error
+		want bool
+	}{
+		{"spam", ErrDuplicateContent, true},
+		{"wrapped", fmt.Errorf("%w: extra context", ErrURLNotAllowed), true},
+		{"limited", ErrRateLimited, false},
+		{"kind", ErrKindNotAllowed, false},
+		{"overflow", ErrBanned, true}, // hidden, but the honeypot is full
+	}
+	for i, tt := range tests {
+		hex := strings.Repeat(fmt.Sprintf("%02x", i+1), 32)
+		e := &nostr.Event{ID: hex, PubKey: hex, Kind: 1, CreatedAt: nostr.Now()}
+		if tt.id == "overflow" {
+			honeypot.retention.Stored() // fill the quota of 2
+		}
+		if got := honeypot.Catch(ctx, e, nil, tt.err); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.id, got, tt.want)
+		}
+	}
+
+	count, err := db.CountEvents(ctx, nostr.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d stored samples, want 2", count)
+	}
+	if caught := obs.honeypotCount.Load(); caught != 3 {
+		t.Errorf("got %d caught events, want 3", caught)
+	}
+}
@@ -0,0 +1,144 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ImportReport is the outcome of importing a dump of events.
+type ImportReport struct {
+	Imported   int
+	Duplicates int // events already stored
+	Invalid    int // lines that aren't events, or events whose ID or signature is wrong
+	Deleted    int // events their author deleted, or older versions of replaceable events
+}
+
+// importCommand implements `wotrlay import [flags] <events.jsonl|->`, and returns the process exit code.
+func importCommand(args []string) int {
+	cfg := loadConfig()
+
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	storePath := flags.String("store", cfg.StorePath, "event store to import into (Badger store of the relay's records with other backends)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay import [flags] <events.jsonl|->")
+		fmt.Fprintln(flags.Output(), "\nStores the events of a JSONL dump, like one written by `wotrlay export`, as they are:")
+		fmt.Fprintln(flags.Output(), "they don't go through the policy. Invalid events and events deleted by their author are skipped.")
+		fmt.Fprintln(flags.Output(), "Badger stores can't be opened by two processes: stop the relay first.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input := os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg.StorePath = *storePath
+	db, meta, err := openStores(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer db.Close()
+	if db != EventStore(meta) {
+		defer meta.Close()
+	}
+	if err := migrateStore(meta, eventStoreMigrations); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	obs := &Observability{}
+	d := &Deps{Name: "import", DB: db, Meta: meta, Obs: obs, Retention: NewRetention(ctx, db, 0, 0)}
+	report, err := importEvents(ctx, input, d, func(problem string) {
+		fmt.Fprintln(os.Stderr, problem)
+	})
+	fmt.Fprintf(os.Stderr, "imported %d events: %d duplicates, %d invalid, %d deleted or outdated\n",
+		report.Imported, report.Duplicates, report.Invalid, report.Deleted)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// importEvents stores the events read from r, one per line, passing each line it skips
+// as invalid to report. Replaceable events keep their newest version, and deletion
+// requests apply to the events imported before and after them.
+func importEvents(ctx context.Context, r io.Reader, d *Deps, report func(string)) (ImportReport, error) {
+	var result ImportReport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var e nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			result.Invalid++
+			report(fmt.Sprintf("line %d: invalid event: %v", line, err))
+			continue
+		}
+		if ok, _ := e.CheckSignature(); !ok || !e.CheckID() {
+			result.Invalid++
+			report(fmt.Sprintf("line %d: event %s has an invalid ID or signature", line, e.ID))
+			continue
+		}
+		if isDeleted(d.Meta, &e) {
+			result.Deleted++
+			continue
+		}
+		// Checked upfront, as the store logs duplicates as failures
+		if n, err := d.DB.CountEvents(ctx, nostr.Filter{IDs: []string{e.ID}}); err == nil && n > 0 {
+			result.Duplicates++
+			continue
+		}
+
+		err := store(ctx, &e, d)
+		switch {
+		case errors.Is(err, eventstore.ErrDupEvent):
+			result.Duplicates++
+		case errors.Is(err, ErrOutdated):
+			result.Deleted++
+		case err != nil:
+			return result, fmt.Errorf("line %d: failed to store event %s: %w", line, e.ID, err)
+		default:
+			result.Imported++
+		}
+	}
+	return result, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestImportExport(t *testing.T) {
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+	now := nostr.Now()
+
+	note := signedEvent(t, sk, nostr.KindTextNote, nil)
+	deleted := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"t", "deleted"}})
+	deletion := signedEvent(t, sk, kindDeletion, nostr.Tags{{"e", deleted.ID}})
+	profile := &nostr.Event{Kind: nostr.KindProfileMetadata, CreatedAt: now, Content: `{"name":"new"}`}
+	profile.Sign(sk)
+	older := &nostr.Event{Kind: nostr.KindProfileMetadata, CreatedAt: now - 60, Content: `{"name":"old"}`}
+	older.Sign(sk)
+	forged := *note
+	forged.Content = "forged"
+
+	var dump bytes.Buffer
+	for _, e := range []*nostr.Event{note, deletion, deleted, profile, older, &forged, note} {
+		line, _ := json.Marshal(e)
+		dump.Write(append(line, '\n'))
+	}
+	dump.WriteString("not an event\n")
+
+	db := newTestDB(t)
+	d := &Deps{Name: "import", DB: db, Meta: db, Obs: &Observability{}, Retention: NewRetention(ctx, db, 0, 0)}
+	var problems []string
+	report, err := importEvents(ctx, &dump, d, func(problem string) { problems = append(problems, problem) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ImportReport{Imported: 3, Duplicates: 1, Invalid: 2, Deleted: 2}
+	if report != want || len(problems) != 2 {
+		t.Errorf("expected %+v, got %+v (%v)", want, report, problems)
+	}
+
+	// The dump of the store imports into another one as it is
+	var exported bytes.Buffer
+	n, err := exportEvents(ctx, db, 0, 0, &exported)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 events exported, got %d (%v)", n, err)
+	}
+	if !strings.Contains(exported.String(), note.ID) || !strings.Contains(exported.String(), profile.ID) || strings.Contains(exported.String(), older.ID) {
+		t.Errorf("expected the note, the deletion and the latest profile, got %s", exported.String())
+	}
+	other := newTestDB(t)
+	od := &Deps{Name: "import", DB: other, Meta: other, Obs: &Observability{}, Retention: NewRetention(ctx, other, 0, 0)}
+	if report, err := importEvents(ctx, &exported, od, func(string) {}); err != nil || report.Imported != 3 {
+		t.Errorf("expected the dump to import, got %+v (%v)", report, err)
+	}
+
+	var recent bytes.Buffer
+	if n, err := exportEvents(ctx, db, now+1, 0, &recent); err != nil || n != 0 || recent.Len() != 0 {
+		t.Errorf("expected no event since a later timestamp, got %d (%v)", n, err)
+	}
+}
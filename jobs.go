@@ -0,0 +1,307 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Built-in jobs
+const (
+	jobPrune        = "prune"           // delete the events past their retention, and old honeypot samples
+	jobCompact      = "compact"         // compact old reactions and zap receipts into counts
+	jobBackup       = "backup"          // snapshot the event stores
+	jobRankBackfill = "rank-backfill"   // refresh the ranks of recent authors missing from the cache
+	jobReport       = "report"          // post the stats of every relay to REPORT_WEBHOOK
+	jobReputation   = "reputation-sync" // merge the reputation lists of the peers
+	jobPayments     = "payments"        // grant the memberships of the paid invoices
+	jobDigest       = "digest"          // send the operators the new members of the community
+	jobBlocklist    = "blocklist-sync"  // fetch the blocklists and remove the events they list
+)
+
+// rankBackfillEvents is how many of the most recent events a rank backfill looks at.
+const rankBackfillEvents = 10000
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// JobStatus is the state of a scheduled job, as served by the admin API.
+type JobStatus struct {
+	Name      string    `json:"name"`
+	Schedule  string    `json:"schedule"`
+	Next      time.Time `json:"next,omitzero"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+	Running   bool      `json:"running"`
+}
+
+// scheduledJob is a job of a relay, or of the whole process when relay is empty.
+type scheduledJob struct {
+	name, relay string
+	expr        string
+	schedule    *Schedule
+	run         func(ctx context.Context) error
+
+	mu      sync.Mutex // guards the fields below
+	running bool
+	lastRun time.Time
+	lastErr error
+}
+
+// Scheduler runs the maintenance jobs of the relays on cron schedules. A job still
+// running when it's due again is skipped rather than run twice at once.
+type Scheduler struct {
+	jobs []*scheduledJob
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex      // guards the fields below
+	ctx     context.Context // context of Run, which also cancels the jobs run by RunNow
+	stopped bool            // Run returned, so no job may start
+
+	Clock func() time.Time // Source of the current time, time.Now if nil
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+func (s *Scheduler) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// Add schedules the job of the relay, or of the whole process if relay is empty.
+// Jobs must be added before Run is called.
+func (s *Scheduler) Add(relay, name, expr string, run func(ctx context.Context) error) error {
+	schedule, err := ParseSchedule(expr)
+	if err != nil {
+		return fmt.Errorf("%s job: %w", name, err)
+	}
+	if schedule.Next(s.now()).IsZero() {
+		relayLog.Warn("job schedule never fires", "job", name, "relay", relay, "schedule", expr)
+	}
+
+	s.jobs = append(s.jobs, &scheduledJob{name: name, relay: relay, expr: expr, schedule: schedule, run: run})
+	return nil
+}
+
+// Run starts the jobs due at each minute until the context is done, then waits
+// for those running to return.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+		s.wg.Wait()
+	}()
+
+	for {
+		now := s.now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		for _, job := range s.jobs {
+			if !job.schedule.matches(next) {
+				continue
+			}
+			if !job.start() {
+				relayLog.Warn("job still running, skipping this run", "job", job.name, "relay", job.relay)
+				continue
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.execute(ctx, job)
+			}()
+		}
+	}
+}
+
+// RunNow runs the job of the relay, and the process-wide job of that name, right away.
+// The jobs are cancelled when the context of Run is done, and Run waits for them to return.
+func (s *Scheduler) RunNow(ctx context.Context, relay, name string) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return context.Canceled
+	}
+	s.wg.Add(1)
+	defer s.wg.Done()
+	if s.ctx != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(s.ctx, cancel)()
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	found := false
+	for _, job := range s.jobs {
+		if job.name != name || job.relay != relay && job.relay != "" {
+			continue
+		}
+		found = true
+		if !job.start() {
+			errs = append(errs, ErrJobRunning)
+			continue
+		}
+		errs = append(errs, s.execute(ctx, job))
+	}
+
+	if !found {
+		return fmt.Errorf("%w %q", ErrUnknownJob, name)
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns the state of the jobs of the relay and of the process-wide jobs.
+func (s *Scheduler) Status(relay string) []JobStatus {
+	var status []JobStatus
+	for _, job := range s.jobs {
+		if job.relay != relay && job.relay != "" {
+			continue
+		}
+
+		job.mu.Lock()
+		js := JobStatus{Name: job.name, Schedule: job.expr, Next: job.schedule.Next(s.now()), LastRun: job.lastRun, Running: job.running}
+		if job.lastErr != nil {
+			js.LastError = job.lastErr.Error()
+		}
+		job.mu.Unlock()
+		status = append(status, js)
+	}
+	return status
+}
+
+// start marks the job as running, unless it already is.
+func (j *scheduledJob) start() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return false
+	}
+	j.running = true
+	return true
+}
+
+// execute runs the job, which must have been started, and records its outcome.
+func (s *Scheduler) execute(ctx context.Context, job *scheduledJob) error {
+	start := s.now()
+	err := job.run(ctx)
+
+	job.mu.Lock()
+	job.running, job.lastRun, job.lastErr = false, start, err
+	job.mu.Unlock()
+
+	if err != nil {
+		relayLog.ErrorContext(ctx, "job failed", "job", job.name, "relay", job.relay, "error", err)
+	} else {
+		relayLog.DebugContext(ctx, "job done", "job", job.name, "relay", job.relay, "duration", time.Since(start).Round(time.Millisecond))
+	}
+	return err
+}
+
+// pruneJob deletes the events of the store past their retention.
+func pruneJob(r *Retention, store string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if deleted := r.Prune(ctx); deleted > 0 {
+			relayLog.InfoContext(ctx, "pruned old events", "store", store, "deleted", deleted, "max_age", r.MaxAge)
+		}
+		return ctx.Err()
+	}
+}
+
+// compactJob compacts the old reactions and zap receipts of the store.
+func compactJob(c *Compaction) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if deleted := c.Compact(ctx); deleted > 0 {
+			relayLog.InfoContext(ctx, "compacted old reactions and zap receipts", "deleted", deleted, "max_age", c.MaxAge)
+		}
+		return ctx.Err()
+	}
+}
+
+// rankBackfillJob queues a refresh of the ranks of the authors of the most recent
+// events of the store that aren't cached, so that they aren't treated as unknown
+// when they next publish, e.g. after a restart emptied the cache.
+func rankBackfillJob(cache *RankCache, db EventStore) func(context.Context) error {
+	return func(ctx context.Context) error {
+		missing := make(map[string]bool)
+		_, err := scanEvents(ctx, db, rankBackfillEvents, func(e *nostr.Event) error {
+			if _, ok := cache.Peek(e.PubKey); !ok {
+				missing[e.PubKey] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		queued := cache.Backfill(ctx, slices.Sorted(maps.Keys(missing)))
+		rankLog.InfoContext(ctx, "queued rank backfill", "pubkeys", queued)
+		return ctx.Err()
+	}
+}
+
+// statsReport is the body posted by the report job.
+type statsReport struct {
+	Time   time.Time    `json:"time"`
+	Relays []relayStats `json:"relays"`
+}
+
+// reportJob posts the stats of the relays to the webhook.
+func reportJob(webhook string, relays []*Deps) func(context.Context) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func(ctx context.Context) error {
+		report := statsReport{Time: time.Now().UTC()}
+		for _, d := range relays {
+			report.Relays = append(report.Relays, d.stats())
+		}
+		body, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to post report: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("failed to post report: %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSchedulerRunNow(t *testing.T) {
+	ctx := context.Background()
+	s := NewScheduler()
+
+	runs := make(map[string]int)
+	count := func(key string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			runs[key]++
+			return err
+		}
+	}
+	s.Add("a", jobPrune, "0 * * * *", count("a", nil))
+	s.Add("b", jobPrune, "0 * * * *", count("b", nil))
+	s.Add("", jobReport, "0 9 * * 1", count("report", errors.New("webhook down")))
+
+	if err := s.RunNow(ctx, "a", jobPrune); err != nil || runs["a"] != 1 || runs["b"] != 0 {
+		t.Errorf("expected only the job of relay a to run, got %v, %v", runs, err)
+	}
+	if err := s.RunNow(ctx, "b", jobReport); err == nil || runs["report"] != 1 {
+		t.Errorf("expected the process-wide job to run and fail, got %v, %v", runs, err)
+	}
+	if err := s.RunNow(ctx, "a", "vacuum"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("expected an unknown job error, got %v", err)
+	}
+
+	status := s.Status("b")
+	if len(status) != 2 || status[0].Name != jobPrune || !status[0].LastRun.IsZero() {
+		t.Fatalf("expected the never run prune job of b and the report job, got %+v", status)
+	}
+	if status[1].LastError != "webhook down" || status[1].Next.Weekday() != time.Monday {
+		t.Errorf("unexpected report job status %+v", status[1])
+	}
+
+	// A job isn't run twice at once
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Add("a", jobCompact, "0 * * * *", func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	done := make(chan error)
+	go func() { done <- s.RunNow(ctx, "a", jobCompact) }()
+	<-started
+	if err := s.RunNow(ctx, "a", jobCompact); !errors.Is(err, ErrJobRunning) {
+		t.Errorf("expected a running job error, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSchedulerRunNowShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler()
+
+	started, finished := make(chan struct{}), make(chan struct{})
+	s.Add("a", jobCompact, "0 * * * *", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		close(finished)
+		return ctx.Err()
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+	for {
+		s.mu.Lock()
+		running := s.ctx != nil
+		s.mu.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// As the admin API runs them, detached from the request
+	done := make(chan error, 1)
+	go func() { done <- s.RunNow(context.WithoutCancel(context.Background()), "a", jobCompact) }()
+	<-started
+	cancel()
+	<-stopped
+	select {
+	case <-finished:
+	default:
+		t.Error("expected Run to wait for the job run by RunNow")
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the job to be cancelled on shutdown, got %v", err)
+	}
+	if err := s.RunNow(context.Background(), "a", jobCompact); err == nil {
+		t.Error("expected no job to start after shutdown")
+	}
+}
+
+func TestRankBackfillJob(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	var pubkeys []string
+	for range 3 {
+		sk := nostr.GeneratePrivateKey()
+		e := signedEvent(t, sk, nostr.KindTextNote, nil)
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		pubkeys = append(pubkeys, e.PubKey)
+	}
+
+	ranks, _ := newShardedLRU[TimeRank](10, nil)
+	cache := &RankCache{lru: ranks, refresh: make(chan string, 10)}
+	cache.lru.Add(pubkeys[0], TimeRank{Rank: 0.5, Timestamp: time.Now()})
+
+	if err := rankBackfillJob(cache, db)(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.refresh) != 2 {
+		t.Fatalf("expected the 2 uncached authors to be queued, got %d", len(cache.refresh))
+	}
+	for range 2 {
+		if pubkey := <-cache.refresh; pubkey == pubkeys[0] {
+			t.Error("a cached author should not be queued")
+		}
+	}
+}
+
+func TestReportJob(t *testing.T) {
+	var report statsReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&report)
+	}))
+	defer server.Close()
+
+	d := &Deps{Name: "community", Obs: &Observability{}, Flags: NewFeatureFlags(Config{})}
+	d.Obs.bannedCount.Add(2)
+	if err := reportJob(server.URL, []*Deps{d})(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Relays) != 1 || report.Relays[0].Relay != "community" || report.Relays[0].Metrics["banned"] != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,77 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// countedKinds are the event kinds with their own accepted/rejected counters.
+// Every other kind is counted as "other", which keeps the set of metrics bounded.
+var countedKinds = [...]int{
+	0,     // profile metadata
+	1,     // text note
+	3,     // follow list
+	4,     // encrypted direct message (NIP-04)
+	5,     // deletion request
+	6,     // repost
+	7,     // reaction
+	16,    // generic repost
+	1059,  // gift wrap (NIP-17 direct messages)
+	1063,  // file metadata
+	1984,  // report
+	4550,  // community post approval
+	9735,  // zap receipt
+	10002, // relay list
+	30023, // long-form article
+}
+
+// kindIndex maps a counted kind to its counters.
+var kindIndex = func() map[int]int {
+	index := make(map[int]int, len(countedKinds))
+	for i, kind := range countedKinds {
+		index[kind] = i
+	}
+	return index
+}()
+
+// KindStats counts accepted and rejected events per kind, with a shared slot for other kinds.
+type KindStats struct {
+	accepted [len(countedKinds) + 1]atomic.Uint64
+	rejected [len(countedKinds) + 1]atomic.Uint64
+}
+
+// Record counts an event of the kind as accepted or rejected.
+func (k *KindStats) Record(kind int, accepted bool) {
+	i, ok := kindIndex[kind]
+	if !ok {
+		i = len(countedKinds)
+	}
+
+	if accepted {
+		k.accepted[i].Add(1)
+	} else {
+		k.rejected[i].Add(1)
+	}
+}
+
+// Metrics returns the counters as "kind_<kind>_accepted" and "kind_<kind>_rejected"
+// metrics, ending with "kind_other_accepted" and "kind_other_rejected", in the
+// "kind_accepted" and "kind_rejected" families labeled by kind.
+func (k *KindStats) Metrics() []LabeledMetric {
+	metrics := make([]LabeledMetric, 0, 2*len(k.accepted))
+	for i := range k.accepted {
+		kind := "other"
+		if i < len(countedKinds) {
+			kind = strconv.Itoa(countedKinds[i])
+		}
+		label := `kind="` + kind + `"`
+		metrics = append(metrics,
+			LabeledMetric{Metric{"kind_" + kind + "_accepted", k.accepted[i].Load()}, "kind_accepted", label},
+			LabeledMetric{Metric{"kind_" + kind + "_rejected", k.rejected[i].Load()}, "kind_rejected", label},
+		)
+	}
+	return metrics
+}
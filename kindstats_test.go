@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestKindStats(t *testing.T) {
+	var stats KindStats
+	stats.Record(1, true)
+	stats.Record(1, true)
+	stats.Record(1, false)
+	stats.Record(7, false)
+	stats.Record(12345, true)
+	stats.Record(22222, false)
+
+	metrics := make(map[string]uint64)
+	for _, m := range stats.Metrics() {
+		metrics[m.Name] = m.Value
+	}
+
+	expected := map[string]uint64{
+		"kind_1_accepted":     2,
+		"kind_1_rejected":     1,
+		"kind_7_accepted":     0,
+		"kind_7_rejected":     1,
+		"kind_other_accepted": 1,
+		"kind_other_rejected": 1,
+	}
+	for name, value := range expected {
+		if got, ok := metrics[name]; !ok || got != value {
+			t.Errorf("%s = %d (present: %v), want %d", name, got, ok, value)
+		}
+	}
+	if len(metrics) != 2*(len(countedKinds)+1) {
+		t.Errorf("expected %d metrics, got %d", 2*(len(countedKinds)+1), len(metrics))
+	}
+}
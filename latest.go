@@ -0,0 +1,158 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// maxLatestAuthors and maxLatestKinds bound the authors and kinds of a /latest request,
+// and maxLatestPairs the (pubkey, kind) pairs it looks up, each a query of the store
+// unless it is cached.
+const (
+	maxLatestAuthors = 100
+	maxLatestKinds   = 20
+	maxLatestPairs   = 200
+)
+
+type latestKey struct {
+	pubkey string
+	kind   int
+}
+
+// LatestSeen tracks the created_at of the newest stored event per (pubkey, kind),
+// so that reconnecting clients can tell which of their subscriptions have anything new
+// without replaying them. Pairs are loaded from the store on first use and kept
+// up to date as events are saved.
+type LatestSeen struct {
+	// times caches (pubkey, kind) -> newest created_at, 0 if there is no event
+	times *lru.Cache[latestKey, nostr.Timestamp]
+}
+
+func NewLatestSeen() *LatestSeen {
+	times, _ := lru.New[latestKey, nostr.Timestamp](100000)
+	return &LatestSeen{times: times}
+}
+
+// Saved records a stored event. Pairs that aren't cached are left to be loaded
+// from the store, which may hold newer events.
+func (l *LatestSeen) Saved(e *nostr.Event) {
+	key := latestKey{e.PubKey, e.Kind}
+	if latest, ok := l.times.Peek(key); ok && e.CreatedAt > latest {
+		l.times.Add(key, e.CreatedAt)
+	}
+}
+
+// Removed forgets the pair of an event removed from the store, to be loaded again.
+func (l *LatestSeen) Removed(e *nostr.Event) {
+	l.times.Remove(latestKey{e.PubKey, e.Kind})
+}
+
+// Latest returns the created_at of the pubkey's newest stored event of the kind, 0 if there is none.
+func (l *LatestSeen) Latest(ctx context.Context, db EventStore, pubkey string, kind int) nostr.Timestamp {
+	key := latestKey{pubkey, kind}
+	if latest, ok := l.times.Get(key); ok {
+		return latest
+	}
+
+	events, err := db.QueryEvents(ctx, nostr.Filter{Authors: []string{pubkey}, Kinds: []int{kind}, Limit: 1})
+	if err != nil {
+		return 0
+	}
+	var latest nostr.Timestamp
+	for e := range events {
+		latest = max(latest, e.CreatedAt)
+	}
+	l.times.Add(key, latest)
+	return latest
+}
+
+// latestHandler serves GET <root>/latest?authors=<hex,...>&kinds=<kind,...>[&since=<unix>],
+// and reports whether the request was for it. The response maps each pubkey to the
+// created_at of its newest event per kind, leaving out the pairs without events newer than since:
+//
+//	{"<pubkey>": {"1": 1700000000, "7": 1699990000}}
+//
+// A client resuming after a reconnect only needs to REQ the pairs listed. Events pruned
+// or deleted since may still be reported, so the timestamps are hints.
+// Requests are limited to LatestRatePerMinute per IP group.
+func latestHandler(w http.ResponseWriter, r *http.Request, root string, cfg Config, d *Deps) bool {
+	if r.URL.Path != path.Join(root, "latest") || r.Method != http.MethodGet {
+		return false
+	}
+	group := rely.GetIP(r).Group()
+	if !d.GlobalLimiter.Allow("latest:"+group, cfg.LatestRatePerMinute, cfg.LatestRatePerMinute/60) {
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return true
+	}
+
+	query := r.URL.Query()
+	authors := strings.Split(query.Get("authors"), ",")
+	if len(authors) > maxLatestAuthors {
+		http.Error(w, "too many authors, the maximum is "+strconv.Itoa(maxLatestAuthors), http.StatusBadRequest)
+		return true
+	}
+	for _, pubkey := range authors {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			http.Error(w, "authors must be comma-separated hex pubkeys", http.StatusBadRequest)
+			return true
+		}
+	}
+
+	var kinds []int
+	for _, item := range strings.Split(query.Get("kinds"), ",") {
+		kind, err := strconv.Atoi(item)
+		if err != nil || kind < 0 || kind > maxKind {
+			http.Error(w, "kinds must be comma-separated kinds", http.StatusBadRequest)
+			return true
+		}
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) > maxLatestKinds {
+		http.Error(w, "too many kinds, the maximum is "+strconv.Itoa(maxLatestKinds), http.StatusBadRequest)
+		return true
+	}
+	if len(authors)*len(kinds) > maxLatestPairs {
+		http.Error(w, "too many authors and kinds, the maximum is "+strconv.Itoa(maxLatestPairs)+" pairs", http.StatusBadRequest)
+		return true
+	}
+
+	var since nostr.Timestamp
+	if value := query.Get("since"); value != "" {
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || unix < 0 {
+			http.Error(w, "since must be a unix timestamp", http.StatusBadRequest)
+			return true
+		}
+		since = nostr.Timestamp(unix)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	latest := make(map[string]map[string]nostr.Timestamp)
+	for _, pubkey := range authors {
+		for _, kind := range kinds {
+			at := d.Latest.Latest(ctx, d.DB, pubkey, kind)
+			if at == 0 || at <= since {
+				continue
+			}
+			if latest[pubkey] == nil {
+				latest[pubkey] = make(map[string]nostr.Timestamp)
+			}
+			latest[pubkey][strconv.Itoa(kind)] = at
+		}
+	}
+	writeJSON(w, latest)
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestLatestHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	db := newTestDB(t)
+	d := &Deps{DB: db, GlobalLimiter: NewLimiter(ctx), Latest: NewLatestSeen()}
+	alice, bob := strings.Repeat("01", 32), strings.Repeat("02", 32)
+
+	save := func(pubkey string, kind int, createdAt nostr.Timestamp) *nostr.Event {
+		e := testEvent(int(createdAt), createdAt)
+		e.PubKey, e.Kind = pubkey, kind
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		d.Latest.Saved(e)
+		return e
+	}
+	latest := func(query string) (int, map[string]map[string]nostr.Timestamp) {
+		w := httptest.NewRecorder()
+		if !latestHandler(w, httptest.NewRequest(http.MethodGet, "/latest?"+query, nil), "/", cfg, d) {
+			t.Fatal("request not handled")
+		}
+		var result map[string]map[string]nostr.Timestamp
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	save(alice, 1, 100)
+	save(alice, 1, 200)
+	save(alice, 7, 150)
+
+	_, result := latest("authors=" + alice + "," + bob + "&kinds=1,7")
+	if result[alice]["1"] != 200 || result[alice]["7"] != 150 || result[bob] != nil {
+		t.Errorf("got %v", result)
+	}
+
+	// Cached pairs follow newer events, and since leaves out the pairs without them
+	save(alice, 1, 300)
+	save(bob, 1, 120)
+	if _, result := latest("authors=" + alice + "," + bob + "&kinds=1,7&since=160"); len(result) != 1 || len(result[alice]) != 1 || result[alice]["1"] != 300 {
+		t.Errorf("since: got %v", result)
+	}
+
+	// 100 authors may be asked for 2 kinds, not 3
+	many := strings.TrimSuffix(strings.Repeat(alice+",", maxLatestAuthors), ",")
+	if code, _ := latest("authors=" + many + "&kinds=1,7"); code != http.StatusOK {
+		t.Errorf("200 pairs: got status %d", code)
+	}
+	if code, _ := latest("authors=" + many + "&kinds=1,6,7"); code != http.StatusBadRequest {
+		t.Errorf("300 pairs: got status %d", code)
+	}
+
+	for _, query := range []string{"kinds=1", "authors=" + alice, "authors=nobody&kinds=1", "authors=" + alice + "&kinds=x", "authors=" + alice + "&kinds=1&since=-1"} {
+		if code, _ := latest(query); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", query, code)
+		}
+	}
+
+	if latestHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/latest", nil), "/", cfg, d) {
+		t.Error("expected POST not to be handled")
+	}
+}
@@ -0,0 +1,28 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// checkWriteWindow enforces the soft launch policy: the writes of a tier open
+// LaunchDays after LaunchAt, then only during the minutes of WriteWindow (in UTC).
+// Rejections tell when the tier's writes open next.
+func checkWriteWindow(tier Tier, now time.Time, cfg Config) error {
+	if !cfg.LaunchAt.IsZero() {
+		if opens := cfg.LaunchAt.AddDate(0, 0, cfg.LaunchDays[tier]); now.Before(opens) {
+			return fmt.Errorf("%w: writes open for your trust level at %s", ErrWritesClosed, opens.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if cfg.WriteWindow != nil && !cfg.WriteWindow.matches(now.UTC()) {
+		if opens := cfg.WriteWindow.Next(now.UTC()); !opens.IsZero() {
+			return fmt.Errorf("%w: writes open again at %s", ErrWritesClosed, opens.Format(time.RFC3339))
+		}
+		return ErrWritesClosed
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckWriteWindow(t *testing.T) {
+	launch := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	cfg := Config{LaunchAt: launch, LaunchDays: map[Tier]int{TierMid: 3, TierLow: 7}}
+
+	tests := []struct {
+		name  string
+		tier  Tier
+		now   time.Time
+		opens string // in the rejection, empty if open
+	}{
+		{name: "before launch", tier: TierHigh, now: launch.Add(-time.Minute), opens: "2025-06-01T18:00:00Z"},
+		{name: "high tier at launch", tier: TierHigh, now: launch},
+		{name: "mid tier before its phase-in", tier: TierMid, now: launch.AddDate(0, 0, 2), opens: "2025-06-04T18:00:00Z"},
+		{name: "mid tier after its phase-in", tier: TierMid, now: launch.AddDate(0, 0, 3)},
+		{name: "low tier before its phase-in", tier: TierLow, now: launch.AddDate(0, 0, 5), opens: "2025-06-08T18:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWriteWindow(tt.tier, tt.now, cfg)
+			if tt.opens == "" && err != nil {
+				t.Errorf("expected writes to be open, got %v", err)
+			}
+			if tt.opens != "" && (!errors.Is(err, ErrWritesClosed) || !strings.Contains(err.Error(), tt.opens)) {
+				t.Errorf("expected writes to open at %s, got %v", tt.opens, err)
+			}
+		})
+	}
+
+	// Writes open from 08:00 to 21:59 UTC
+	window, err := ParseSchedule("* 8-21 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = Config{WriteWindow: window}
+	if err := checkWriteWindow(TierLow, time.Date(2025, 6, 2, 12, 30, 0, 0, time.UTC), cfg); err != nil {
+		t.Errorf("expected writes to be open during the window, got %v", err)
+	}
+	err = checkWriteWindow(TierHigh, time.Date(2025, 6, 3, 1, 0, 0, 0, time.FixedZone("CEST", 2*3600)), cfg)
+	if !errors.Is(err, ErrWritesClosed) || !strings.Contains(err.Error(), "2025-06-03T08:00:00Z") {
+		t.Errorf("expected writes to open again the next morning, got %v", err)
+	}
+}
+
+func TestWriteWindowConfig(t *testing.T) {
+	for env, valid := range map[string]bool{
+		"WRITE_WINDOW=*_8-21_*_*_*":           true,
+		"WRITE_WINDOW=8-21":                   false,
+		"LAUNCH_AT=2025-06-01T18:00:00Z":      true,
+		"LAUNCH_AT=2025-06-01":                false,
+		"LAUNCH_DAYS_LOW=7 LAUNCH_DAYS_MID=3": true,
+		"LAUNCH_DAYS_LOW=-1":                  false,
+	} {
+		vars := make(map[string]string)
+		for _, kv := range strings.Fields(env) {
+			key, value, _ := strings.Cut(kv, "=")
+			vars[key] = strings.ReplaceAll(value, "_", " ")
+		}
+		if _, err := buildConfig(func(key string) string { return vars[key] }); (err == nil) != valid {
+			t.Errorf("%s: got error %v, want valid %v", env, err, valid)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ledgerDays is the number of days of storage history kept by a StorageLedger.
+const ledgerDays = 30
+
+// StorageLedger accounts for the bytes of the relay's event store per trust tier.
+// Every stored event credits the tier of its author with the event's size, and every
+// pruned event debits it, so that the balance of a tier is how much its events grew
+// (or shrank) the store since startup. A pubkey changing tier between the two entries
+// moves its bytes from one tier's balance to the other's.
+type StorageLedger struct {
+	credits [TierHigh + 1]atomic.Uint64
+	debits  [TierHigh + 1]atomic.Uint64
+
+	mu      sync.Mutex
+	history []LedgerDay // oldest first, at most ledgerDays
+
+	Tier  func(pubkey string) Tier // Tier of a pubkey's account
+	Clock func() time.Time         // Source of the current time, time.Now if nil
+}
+
+// LedgerAccount is the state of a tier's account, in bytes.
+type LedgerAccount struct {
+	Credits uint64 `json:"credits"`
+	Debits  uint64 `json:"debits"`
+	Balance int64  `json:"balance"`
+}
+
+// LedgerDay holds the bytes credited and debited to each tier on a day (UTC).
+type LedgerDay struct {
+	Date    string                   `json:"date"`
+	Credits [TierHigh + 1]uint64     `json:"-"`
+	Debits  [TierHigh + 1]uint64     `json:"-"`
+	Tiers   map[string]LedgerAccount `json:"tiers"`
+}
+
+// LedgerReport is the state of all accounts, with their daily history.
+type LedgerReport struct {
+	Tiers   map[string]LedgerAccount `json:"tiers"`
+	History []LedgerDay              `json:"history"`
+}
+
+func NewStorageLedger(tier func(pubkey string) Tier) *StorageLedger {
+	return &StorageLedger{Tier: tier}
+}
+
+func (l *StorageLedger) now() time.Time {
+	if l.Clock != nil {
+		return l.Clock()
+	}
+	return time.Now()
+}
+
+// Credit records that the event was added to the store.
+func (l *StorageLedger) Credit(e *nostr.Event) {
+	if l == nil {
+		return
+	}
+	tier, size := l.Tier(e.PubKey), uint64(len(e.String()))
+	l.credits[tier].Add(size)
+	l.record(func(day *LedgerDay) { day.Credits[tier] += size })
+}
+
+// Debit records that the event was removed from the store.
+func (l *StorageLedger) Debit(e *nostr.Event) {
+	if l == nil {
+		return
+	}
+	tier, size := l.Tier(e.PubKey), uint64(len(e.String()))
+	l.debits[tier].Add(size)
+	l.record(func(day *LedgerDay) { day.Debits[tier] += size })
+}
+
+// record applies the entry to today's history, starting a new day when needed.
+func (l *StorageLedger) record(entry func(day *LedgerDay)) {
+	date := l.now().UTC().Format(time.DateOnly)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.history) == 0 || l.history[len(l.history)-1].Date != date {
+		l.history = append(l.history, LedgerDay{Date: date})
+		if len(l.history) > ledgerDays {
+			l.history = l.history[len(l.history)-ledgerDays:]
+		}
+	}
+	entry(&l.history[len(l.history)-1])
+}
+
+// Report returns the accounts of each tier and their daily history.
+func (l *StorageLedger) Report() LedgerReport {
+	var credits, debits [TierHigh + 1]uint64
+	for tier := TierLow; tier <= TierHigh; tier++ {
+		credits[tier], debits[tier] = l.credits[tier].Load(), l.debits[tier].Load()
+	}
+	report := LedgerReport{Tiers: accounts(credits, debits)}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report.History = make([]LedgerDay, len(l.history))
+	for i, day := range l.history {
+		day.Tiers = accounts(day.Credits, day.Debits)
+		report.History[i] = day
+	}
+	return report
+}
+
+// accounts returns the account of each tier, keyed by tier name.
+func accounts(credits, debits [TierHigh + 1]uint64) map[string]LedgerAccount {
+	tiers := make(map[string]LedgerAccount, len(credits))
+	for tier := TierLow; tier <= TierHigh; tier++ {
+		tiers[tier.String()] = LedgerAccount{
+			Credits: credits[tier],
+			Debits:  debits[tier],
+			Balance: int64(credits[tier]) - int64(debits[tier]),
+		}
+	}
+	return tiers
+}
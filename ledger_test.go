@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestStorageLedger(t *testing.T) {
+	lowSK, highSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	highPK, _ := nostr.GetPublicKey(highSK)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ledger := NewStorageLedger(func(pubkey string) Tier {
+		if pubkey == highPK {
+			return TierHigh
+		}
+		return TierLow
+	})
+	ledger.Clock = func() time.Time { return now }
+
+	spam, note := signedEvent(t, lowSK, 1, nil), signedEvent(t, highSK, 1, nil)
+	ledger.Credit(spam)
+	ledger.Credit(note)
+	now = now.Add(24 * time.Hour)
+	ledger.Debit(spam)
+
+	report := ledger.Report()
+	size := uint64(len(spam.String()))
+	if low := report.Tiers["low"]; low.Credits != size || low.Debits != size || low.Balance != 0 {
+		t.Errorf("low tier: got %+v", low)
+	}
+	if high := report.Tiers["high"]; high.Balance != int64(len(note.String())) {
+		t.Errorf("high tier: got %+v", high)
+	}
+
+	if len(report.History) != 2 || report.History[0].Date != "2026-03-01" || report.History[1].Date != "2026-03-02" {
+		t.Fatalf("expected a day of history per day with entries, got %+v", report.History)
+	}
+	if day := report.History[1].Tiers["low"]; day.Credits != 0 || day.Debits != size {
+		t.Errorf("second day: got %+v", day)
+	}
+
+	for range ledgerDays + 5 {
+		now = now.Add(24 * time.Hour)
+		ledger.Credit(note)
+	}
+	if history := ledger.Report().History; len(history) != ledgerDays {
+		t.Errorf("expected history to be capped at %d days, got %d", ledgerDays, len(history))
+	}
+}
+
+func TestRetentionPruneDebitsLedger(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	old := signedEvent(t, nostr.GeneratePrivateKey(), 1, nil)
+	old.CreatedAt = nostr.Timestamp(time.Now().Add(-48 * time.Hour).Unix())
+	if err := db.SaveEvent(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	retention := NewRetention(ctx, db, 24*time.Hour, 0)
+	retention.Ledger = NewStorageLedger(func(string) Tier { return TierLow })
+	retention.Prune(ctx)
+
+	if low := retention.Ledger.Report().Tiers["low"]; low.Debits == 0 || low.Balance >= 0 {
+		t.Errorf("expected the pruned event to be debited, got %+v", low)
+	}
+}
@@ -0,0 +1,244 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IPLinkage tracks which IP groups published which pubkeys, so that banning a
+// pubkey can cast suspicion on the other pubkeys seen from the same IP groups.
+// Associations and suspicion marks expire after TimeToLive of inactivity.
+type IPLinkage struct {
+	mu sync.Mutex
+
+	// groups maps an IP group to the pubkeys seen from it (with last-seen time)
+	groups map[string]map[string]time.Time
+	// pubkeys maps a pubkey to the IP groups it was seen from
+	pubkeys map[string]map[string]struct{}
+
+	banned  map[string]struct{}
+	suspect map[string]time.Time // pubkey -> suspicion expiry
+
+	// Abusive pubkeys and IP groups reported by cooperating relays (see Reputation)
+	sharedPubkeys map[string]struct{}
+	sharedGroups  map[string]struct{}
+
+	TimeToLive      time.Duration // How long to remember IP group associations
+	SuspectDuration time.Duration // How long a linked pubkey stays under heightened scrutiny
+	CleanupInterval time.Duration // How often to scan for cleanup
+}
+
+func NewIPLinkage(ctx context.Context, bannedPubkeys []string) *IPLinkage {
+	l := &IPLinkage{
+		groups:          make(map[string]map[string]time.Time, 100),
+		pubkeys:         make(map[string]map[string]struct{}, 100),
+		banned:          make(map[string]struct{}, len(bannedPubkeys)),
+		suspect:         make(map[string]time.Time),
+		TimeToLive:      7 * 24 * time.Hour,
+		SuspectDuration: 7 * 24 * time.Hour,
+		CleanupInterval: time.Hour,
+	}
+
+	for _, pubkey := range bannedPubkeys {
+		l.banned[pubkey] = struct{}{}
+	}
+
+	go l.cleaner(ctx)
+	return l
+}
+
+// SetBanned replaces the banned pubkeys. Pubkeys already marked as suspect stay so
+// until their mark expires.
+func (l *IPLinkage) SetBanned(pubkeys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.banned = make(map[string]struct{}, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		l.banned[pubkey] = struct{}{}
+	}
+}
+
+// Record associates the pubkey with the IP group. If the pubkey is banned, every
+// other pubkey seen from the same IP group is marked as suspect.
+func (l *IPLinkage) Record(ipGroup, pubkey string) {
+	if ipGroup == "" {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	members, ok := l.groups[ipGroup]
+	if !ok {
+		members = make(map[string]time.Time, 4)
+		l.groups[ipGroup] = members
+	}
+	members[pubkey] = time.Now()
+
+	groups, ok := l.pubkeys[pubkey]
+	if !ok {
+		groups = make(map[string]struct{}, 1)
+		l.pubkeys[pubkey] = groups
+	}
+	groups[ipGroup] = struct{}{}
+
+	if _, banned := l.banned[pubkey]; banned {
+		l.markGroupLocked(ipGroup, pubkey)
+	} else if _, shared := l.sharedGroups[ipGroup]; shared || l.groupHasBannedLocked(ipGroup) {
+		// A fresh pubkey showing up next to a banned one, or from an IP group
+		// other relays reported, is suspect too.
+		l.suspect[pubkey] = time.Now().Add(l.SuspectDuration)
+	}
+}
+
+// Ban marks the pubkey as banned and puts all pubkeys linked to it through
+// shared IP groups under heightened scrutiny.
+func (l *IPLinkage) Ban(pubkey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.banned[pubkey] = struct{}{}
+	delete(l.suspect, pubkey)
+	for ipGroup := range l.pubkeys[pubkey] {
+		l.markGroupLocked(ipGroup, pubkey)
+	}
+}
+
+// Unban removes the pubkey from the banned set.
+// Suspicion already cast on linked pubkeys expires on its own.
+func (l *IPLinkage) Unban(pubkey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.banned, pubkey)
+}
+
+// IsBanned reports whether the pubkey is banned.
+func (l *IPLinkage) IsBanned(pubkey string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, banned := l.banned[pubkey]
+	return banned
+}
+
+// IsSuspect reports whether the pubkey shares an IP group with a banned pubkey,
+// or was reported by cooperating relays.
+func (l *IPLinkage) IsSuspect(pubkey string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, shared := l.sharedPubkeys[pubkey]; shared {
+		return true
+	}
+	expiry, ok := l.suspect[pubkey]
+	return ok && time.Now().Before(expiry)
+}
+
+// SetShared replaces the abusive pubkeys and IP groups reported by cooperating relays.
+// The pubkeys are suspect while reported, and so are the pubkeys seen from the IP
+// groups, like those linked to a banned pubkey. Banning is left to the relay's operator.
+func (l *IPLinkage) SetShared(pubkeys, groups []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sharedPubkeys = make(map[string]struct{}, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		l.sharedPubkeys[pubkey] = struct{}{}
+	}
+	l.sharedGroups = make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		l.sharedGroups[group] = struct{}{}
+		l.markGroupLocked(group, "")
+	}
+}
+
+// Abusive returns the banned pubkeys, and the IP groups they were seen from, sorted.
+// Those reported by other relays aren't included.
+func (l *IPLinkage) Abusive() (pubkeys, groups []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	abusiveGroups := make(map[string]struct{})
+	for pubkey := range l.banned {
+		for group := range l.pubkeys[pubkey] {
+			abusiveGroups[group] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(l.banned)), slices.Sorted(maps.Keys(abusiveGroups))
+}
+
+// markGroupLocked marks every pubkey of the IP group, except the banned one, as suspect.
+// Must be called with l.mu held.
+func (l *IPLinkage) markGroupLocked(ipGroup, bannedPubkey string) {
+	expiry := time.Now().Add(l.SuspectDuration)
+	for pubkey := range l.groups[ipGroup] {
+		if pubkey == bannedPubkey {
+			continue
+		}
+		if _, banned := l.banned[pubkey]; banned {
+			continue
+		}
+		l.suspect[pubkey] = expiry
+	}
+}
+
+// groupHasBannedLocked reports whether any pubkey of the IP group is banned.
+// Must be called with l.mu held.
+func (l *IPLinkage) groupHasBannedLocked(ipGroup string) bool {
+	for pubkey := range l.groups[ipGroup] {
+		if _, banned := l.banned[pubkey]; banned {
+			return true
+		}
+	}
+	return false
+}
+
+// Clean removes expired IP group associations and suspicion marks.
+func (l *IPLinkage) Clean() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for ipGroup, members := range l.groups {
+		for pubkey, lastSeen := range members {
+			if now.Sub(lastSeen) > l.TimeToLive {
+				delete(members, pubkey)
+				if groups, ok := l.pubkeys[pubkey]; ok {
+					delete(groups, ipGroup)
+					if len(groups) == 0 {
+						delete(l.pubkeys, pubkey)
+					}
+				}
+			}
+		}
+		if len(members) == 0 {
+			delete(l.groups, ipGroup)
+		}
+	}
+
+	for pubkey, expiry := range l.suspect {
+		if now.After(expiry) {
+			delete(l.suspect, pubkey)
+		}
+	}
+}
+
+func (l *IPLinkage) cleaner(ctx context.Context) {
+	timer := time.NewTicker(l.CleanupInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			l.Clean()
+		}
+	}
+}
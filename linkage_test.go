@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIPLinkageBanMarksLinkedPubkeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	linkage := NewIPLinkage(ctx, nil)
+	linkage.Record("1.2.3.4", "alice")
+	linkage.Record("1.2.3.4", "mallory")
+	linkage.Record("5.6.7.8", "bob")
+
+	linkage.Ban("mallory")
+
+	if !linkage.IsBanned("mallory") {
+		t.Error("mallory should be banned")
+	}
+	if !linkage.IsSuspect("alice") {
+		t.Error("alice shares an IP group with mallory and should be suspect")
+	}
+	if linkage.IsSuspect("bob") {
+		t.Error("bob is not linked to mallory and should not be suspect")
+	}
+	if linkage.IsSuspect("mallory") {
+		t.Error("banned pubkeys should not be reported as suspect")
+	}
+}
+
+func TestIPLinkageBannedPubkeyTaintsNewGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	linkage := NewIPLinkage(ctx, []string{"mallory"})
+	linkage.Record("1.2.3.4", "alice")
+
+	// The banned pubkey shows up on alice's IP group afterwards
+	linkage.Record("1.2.3.4", "mallory")
+	if !linkage.IsSuspect("alice") {
+		t.Error("alice should become suspect when a banned pubkey uses her IP group")
+	}
+
+	// Fresh pubkeys appearing on a tainted group are suspect right away
+	linkage.Record("1.2.3.4", "sybil")
+	if !linkage.IsSuspect("sybil") {
+		t.Error("new pubkeys on a group with a banned pubkey should be suspect")
+	}
+}
+
+func TestIPLinkageCleanExpires(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	linkage := NewIPLinkage(ctx, nil)
+	linkage.SuspectDuration = time.Millisecond
+	linkage.TimeToLive = time.Millisecond
+
+	linkage.Record("1.2.3.4", "alice")
+	linkage.Record("1.2.3.4", "mallory")
+	linkage.Ban("mallory")
+
+	time.Sleep(5 * time.Millisecond)
+	linkage.Clean()
+
+	if linkage.IsSuspect("alice") {
+		t.Error("suspicion should expire after SuspectDuration")
+	}
+	if len(linkage.groups) != 0 || len(linkage.pubkeys) != 0 {
+		t.Errorf("expected associations to be cleaned, got %d groups and %d pubkeys", len(linkage.groups), len(linkage.pubkeys))
+	}
+	if !linkage.IsBanned("mallory") {
+		t.Error("bans should not expire on Clean")
+	}
+}
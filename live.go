@@ -0,0 +1,47 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// LiveFeed streams events stored without going through an EVENT message to the open
+// subscriptions of the relay's clients, in real time.
+//
+// rely indexes the filters of open subscriptions and streams the events its clients
+// publish once On.Event accepts them, so events accepted by this node are already live.
+// The feed hands rely the events stored by other paths, such as the events accepted
+// by other nodes of the cluster, which would otherwise only reach new REQs.
+type LiveFeed struct {
+	relay atomic.Pointer[rely.Relay]
+	obs   *Observability
+}
+
+func NewLiveFeed(obs *Observability) *LiveFeed {
+	return &LiveFeed{obs: obs}
+}
+
+// Attach makes the feed stream to the subscriptions of the relay.
+// Events stored before a relay is attached are not streamed.
+func (f *LiveFeed) Attach(relay *rely.Relay) {
+	f.relay.Store(relay)
+}
+
+// Stream sends a stored event to every open subscription matching it.
+// It never blocks: when rely's broadcast queue is full, the event is dropped.
+func (f *LiveFeed) Stream(e *nostr.Event) {
+	relay := f.relay.Load()
+	if relay == nil {
+		return
+	}
+	if err := relay.Broadcast(e); err != nil {
+		f.obs.liveDroppedCount.Add(1)
+		return
+	}
+	f.obs.liveStreamedCount.Add(1)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+func TestLiveFeed(t *testing.T) {
+	obs := &Observability{}
+	feed := NewLiveFeed(obs)
+	e := &nostr.Event{ID: "abc", Kind: 1}
+
+	// Nothing to stream to yet
+	feed.Stream(e)
+	if streamed := obs.liveStreamedCount.Load(); streamed != 0 {
+		t.Errorf("expected no streamed events before a relay is attached, got %d", streamed)
+	}
+
+	feed.Attach(rely.NewRelay())
+	feed.Stream(e)
+	if streamed := obs.liveStreamedCount.Load(); streamed != 1 {
+		t.Errorf("expected 1 streamed event, got %d", streamed)
+	}
+	if dropped := obs.liveDroppedCount.Load(); dropped != 0 {
+		t.Errorf("expected no dropped events, got %d", dropped)
+	}
+}
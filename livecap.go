@@ -0,0 +1,232 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Overflow policies of the live event cap
+const (
+	liveOverflowDropOldest = "drop-oldest"
+	liveOverflowCoalesce   = "coalesce"
+)
+
+// maxInboundPeek bounds the bytes of a client frame kept to tell its subscription ID.
+const maxInboundPeek = 256
+
+// Message labels the live event cap looks for, with their opening bracket and quotes.
+var (
+	eventLabel  = []byte(`["EVENT",`)
+	eoseLabel   = []byte(`["EOSE",`)
+	closedLabel = []byte(`["CLOSED",`)
+	reqLabel    = []byte(`["REQ",`)
+	closeLabel  = []byte(`["CLOSE",`)
+)
+
+// liveCap caps the live events delivered to each subscription of a connection.
+// Events of a subscription are live once its EOSE was written; those over the rate
+// wait in a bounded queue, whose oldest events are dropped when it is full.
+// A queue of a single event coalesces a burst into its latest event.
+//
+// Client frames are peeked at to forget subscriptions replaced by a new REQ
+// under the same ID, so that their stored events aren't taken for live ones.
+type liveCap struct {
+	obs    *Observability
+	rate   float64 // live events per second per subscription
+	burst  float64 // live events delivered at once before the cap applies
+	buffer int     // live events queued per subscription
+
+	mu   sync.Mutex // guards subs, which the reader and writer goroutines both update
+	subs map[string]*liveSub
+
+	// Read by the reader goroutine only
+	in   []byte // start of the client frame being read
+	skip uint64 // bytes of the current client frame left to skip
+}
+
+// liveSub is a subscription whose stored events were all written.
+type liveSub struct {
+	bucket Bucket
+	queue  [][]byte // frames waiting for a token, oldest first
+}
+
+func newLiveCap(obs *Observability, rate, burst float64, buffer int) *liveCap {
+	return &liveCap{
+		obs:    obs,
+		rate:   rate,
+		burst:  burst,
+		buffer: max(buffer, 1),
+		subs:   make(map[string]*liveSub),
+	}
+}
+
+// hold reports whether the server frame must not be written now, because it's a live
+// event of a subscription over its rate. The frame is then queued, or dropped.
+func (l *liveCap) hold(frame []byte, now time.Time) bool {
+	if frame[0] != wsFinalBit|wsOpText {
+		return false
+	}
+
+	payload := frame[frameHeaderSize(frame):]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case bytes.HasPrefix(payload, eoseLabel):
+		if id, ok := subscriptionID(payload[len(eoseLabel):]); ok {
+			l.subs[id] = &liveSub{bucket: Bucket{tokens: l.burst, capacity: l.burst, refillRate: l.rate, lastActive: now}}
+		}
+		return false
+
+	case bytes.HasPrefix(payload, closedLabel):
+		if id, ok := subscriptionID(payload[len(closedLabel):]); ok {
+			delete(l.subs, id)
+		}
+		return false
+
+	case bytes.HasPrefix(payload, eventLabel):
+		id, ok := subscriptionID(payload[len(eventLabel):])
+		if !ok {
+			return false
+		}
+		sub, ok := l.subs[id]
+		if !ok {
+			return false
+		}
+
+		sub.bucket.refillLocked(now)
+		if len(sub.queue) == 0 && sub.bucket.tokens >= 1 {
+			sub.bucket.tokens--
+			return false
+		}
+
+		if len(sub.queue) >= l.buffer {
+			sub.queue = sub.queue[1:]
+			l.obs.liveOverflowCount.Add(1)
+		}
+		sub.queue = append(sub.queue, bytes.Clone(frame))
+		l.obs.liveDelayedCount.Add(1)
+		return true
+	}
+	return false
+}
+
+// due returns the queued frames that may be written now, and how long until the next
+// one may be, or 0 if no frame is left.
+func (l *liveCap) due(now time.Time) ([][]byte, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var frames [][]byte
+	var next time.Duration
+	for _, sub := range l.subs {
+		if len(sub.queue) == 0 {
+			continue
+		}
+
+		sub.bucket.refillLocked(now)
+		for len(sub.queue) > 0 && sub.bucket.tokens >= 1 {
+			frames = append(frames, sub.queue[0])
+			sub.queue[0] = nil
+			sub.queue = sub.queue[1:]
+			sub.bucket.tokens--
+		}
+
+		if len(sub.queue) > 0 {
+			wait := time.Duration((1 - sub.bucket.tokens) / l.rate * float64(time.Second))
+			if next == 0 || wait < next {
+				next = max(wait, time.Millisecond)
+			}
+		}
+	}
+	return frames, next
+}
+
+// inbound peeks at bytes read from the client, forgetting the subscriptions
+// a REQ replaces or a CLOSE ends. Fragmented frames aren't looked at.
+func (l *liveCap) inbound(b []byte) {
+	for len(b) > 0 {
+		if l.skip > 0 {
+			n := min(l.skip, uint64(len(b)))
+			l.skip -= n
+			b = b[n:]
+			continue
+		}
+
+		l.in = append(l.in, b...)
+		b = nil
+		for len(l.in) >= 2 {
+			header, length, ok := frameLength(l.in)
+			if !ok {
+				break
+			}
+			peek := header + int(min(length, maxInboundPeek))
+			if len(l.in) < peek {
+				break
+			}
+			l.clientFrame(l.in[:peek], header)
+
+			if size := uint64(header) + length; uint64(len(l.in)) >= size {
+				l.in = l.in[size:]
+			} else {
+				l.skip = size - uint64(len(l.in))
+				l.in = l.in[:0]
+			}
+		}
+		l.in = append([]byte(nil), l.in...)
+	}
+}
+
+// clientFrame forgets the subscription of a REQ or CLOSE frame, given the start of its
+// masked payload.
+func (l *liveCap) clientFrame(frame []byte, header int) {
+	if frame[0] != wsFinalBit|wsOpText || frame[1]&wsMaskBit == 0 {
+		return
+	}
+
+	mask := frame[header-4 : header]
+	payload := make([]byte, len(frame)-header)
+	for i := range payload {
+		payload[i] = frame[header+i] ^ mask[i%4]
+	}
+
+	var id string
+	var ok bool
+	switch {
+	case bytes.HasPrefix(payload, reqLabel):
+		id, ok = subscriptionID(payload[len(reqLabel):])
+	case bytes.HasPrefix(payload, closeLabel):
+		id, ok = subscriptionID(payload[len(closeLabel):])
+	}
+	if ok {
+		l.mu.Lock()
+		delete(l.subs, id)
+		l.mu.Unlock()
+	}
+}
+
+// subscriptionID decodes the JSON string starting b, the subscription ID of a message.
+func subscriptionID(b []byte) (string, bool) {
+	if len(b) == 0 || b[0] != '"' {
+		return "", false
+	}
+
+	for i := 1; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			var id string
+			if err := json.Unmarshal(b[:i+1], &id); err != nil {
+				return "", false
+			}
+			return id, true
+		}
+	}
+	return "", false
+}
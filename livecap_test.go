@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// clientFrame builds a masked, final websocket text frame.
+func clientFrame(payload string) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{wsFinalBit | wsOpText, wsMaskBit | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func newTestLiveConn(rate, burst float64, buffer int) (*limitedConn, *recordingConn) {
+	rec := &recordingConn{}
+	limiter := NewOutboundLimiter(&Observability{}, 0, 1, 0)
+	limiter.LiveEventRate, limiter.LiveEventBurst, limiter.LiveEventBuffer = rate, burst, buffer
+	conn := limiter.conn(rec)
+
+	// Upgrade response
+	conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+	return conn, rec
+}
+
+// written returns the frames written to rec, taking the lock of the flushes.
+func written(conn *limitedConn, rec *recordingConn) [][]byte {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return rec.writes[1:]
+}
+
+func liveEvent(sub string, n int) []byte {
+	return serverFrame(wsOpText, fmt.Sprintf(`["EVENT",%q,{"content":"%d"}]`, sub, n))
+}
+
+func TestLiveCapDropOldest(t *testing.T) {
+	conn, rec := newTestLiveConn(20, 2, 2)
+
+	// Stored events aren't capped
+	for n := range 5 {
+		conn.Write(liveEvent("sub", n))
+	}
+	conn.Write(serverFrame(wsOpText, `["EOSE","sub"]`))
+	if got := len(written(conn, rec)); got != 6 {
+		t.Fatalf("expected stored events and EOSE to be written, got %d frames", got)
+	}
+
+	// 2 live events of burst, then 3 over the cap for a buffer of 2
+	for n := 10; n < 15; n++ {
+		conn.Write(liveEvent("sub", n))
+	}
+	// Other subscriptions aren't held back
+	conn.Write(liveEvent("other", 20))
+
+	frames := written(conn, rec)
+	if len(frames) != 9 || !bytes.Equal(frames[8], liveEvent("other", 20)) {
+		t.Fatalf("expected the burst and the other subscription's event to be written, got %d frames", len(frames))
+	}
+	if got := conn.limiter.obs.liveDelayedCount.Load(); got != 3 {
+		t.Errorf("expected 3 delayed live events, got %d", got)
+	}
+	if got := conn.limiter.obs.liveOverflowCount.Load(); got != 1 {
+		t.Errorf("expected 1 dropped live event, got %d", got)
+	}
+
+	// The newest events are flushed as the subscription's bucket refills
+	time.Sleep(200 * time.Millisecond)
+	frames = written(conn, rec)
+	if len(frames) != 11 || !bytes.Equal(frames[9], liveEvent("sub", 13)) || !bytes.Equal(frames[10], liveEvent("sub", 14)) {
+		t.Fatalf("expected the 2 newest held back events to be flushed, got %d frames", len(frames))
+	}
+}
+
+func TestLiveCapCoalesce(t *testing.T) {
+	conn, rec := newTestLiveConn(20, 1, 1)
+
+	conn.Write(serverFrame(wsOpText, `["EOSE","sub"]`))
+	for n := range 10 {
+		conn.Write(liveEvent("sub", n))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// EOSE, the burst, then the latest event only
+	frames := written(conn, rec)
+	if len(frames) != 3 || !bytes.Equal(frames[2], liveEvent("sub", 9)) {
+		t.Fatalf("expected the burst to be coalesced into its latest event, got %d frames", len(frames))
+	}
+}
+
+func TestLiveCapReplacedSubscription(t *testing.T) {
+	conn, rec := newTestLiveConn(1, 1, 10)
+
+	conn.Write(serverFrame(wsOpText, `["EOSE","sub"]`))
+	conn.Write(liveEvent("sub", 1))
+	conn.Write(liveEvent("sub", 2))
+	if got := len(written(conn, rec)); got != 2 {
+		t.Fatalf("expected the second live event to be held back, got %d frames", got)
+	}
+
+	// A new REQ under the same ID: its stored events aren't live, even read in pieces
+	req := clientFrame(`["REQ","sub",{"kinds":[1]}]`)
+	conn.live.inbound(req[:3])
+	conn.live.inbound(req[3:])
+	for n := 3; n < 6; n++ {
+		conn.Write(liveEvent("sub", n))
+	}
+	if got := len(written(conn, rec)); got != 5 {
+		t.Fatalf("expected stored events of the new REQ to be written, got %d frames", got)
+	}
+
+	conn.Write(serverFrame(wsOpText, `["EOSE","sub"]`))
+	conn.live.inbound(clientFrame(`["CLOSE","sub"]`))
+	conn.Write(liveEvent("sub", 6))
+	conn.Write(liveEvent("sub", 7))
+	if got := len(written(conn, rec)); got != 8 {
+		t.Fatalf("expected events of a closed subscription to be written, got %d frames", got)
+	}
+}
+
+func TestSubscriptionID(t *testing.T) {
+	for input, want := range map[string]string{
+		`"sub",{}]`:        "sub",
+		`"a\"b",{}]`:       `a"b`,
+		`"A\\",{}]`:        `A\`,
+		`"unterminated`:    "",
+		`42,{}]`:           "",
+		`"bad\escape",{}]`: "",
+	} {
+		id, ok := subscriptionID([]byte(input))
+		if id != want || ok != (want != "") {
+			t.Errorf("subscriptionID(%s) = %q, %v, want %q", input, id, ok, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logModule is a part of the relay whose log level can be set on its own.
+type logModule int
+
+const (
+	moduleRelay   logModule = iota // startup, storage, cluster and everything else
+	moduleEvent                    // handling of EVENT messages
+	moduleRank                     // rank lookups and refreshes
+	moduleLimiter                  // rate limits and spam wave policy
+	moduleQuery                    // REQ and COUNT messages
+)
+
+var moduleNames = [...]string{"relay", "event", "rank", "limiter", "query"}
+
+func (m logModule) String() string {
+	return moduleNames[m]
+}
+
+// Log formats
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// The logger of each module, replaced by setupLogging once the configuration is loaded.
+var (
+	relayLog   = slog.Default().With("module", moduleRelay.String())
+	eventLog   = slog.Default().With("module", moduleEvent.String())
+	rankLog    = slog.Default().With("module", moduleRank.String())
+	limiterLog = slog.Default().With("module", moduleLimiter.String())
+	queryLog   = slog.Default().With("module", moduleQuery.String())
+)
+
+// setupLogging configures the module loggers, and makes the relay logger the default
+// one, so that lines logged with the log package share its format.
+func setupLogging(cfg Config) {
+	loggers := newLoggers(os.Stderr, cfg)
+	relayLog, eventLog, rankLog, limiterLog, queryLog = loggers[moduleRelay], loggers[moduleEvent], loggers[moduleRank], loggers[moduleLimiter], loggers[moduleQuery]
+	slog.SetDefault(relayLog)
+}
+
+// newLoggers returns the logger of each module, writing to w in the configured format
+// at the module's level.
+func newLoggers(w io.Writer, cfg Config) [len(moduleNames)]*slog.Logger {
+	var base slog.Handler
+	if cfg.LogFormat == logJSON {
+		base = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	} else {
+		base = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+
+	var loggers [len(moduleNames)]*slog.Logger
+	for m := range loggers {
+		module := logModule(m)
+		level, ok := cfg.LogLevels[module]
+		if !ok {
+			level = slog.LevelInfo
+		}
+		loggers[m] = slog.New(logHandler{Handler: base, level: level}).With("module", module.String())
+	}
+	return loggers
+}
+
+// logHandler filters records below its level, and adds the request-scoped fields
+// of the context: its trace ID and the fields set with withLogAttrs.
+type logHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := traceID(ctx); id != "" {
+		r.AddAttrs(slog.String("trace", id))
+	}
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return logHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h logHandler) WithGroup(name string) slog.Handler {
+	return logHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log lines carry the attributes, in addition
+// to those the context already carries.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if previous, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		attrs = append(previous[:len(previous):len(previous)], attrs...)
+	}
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
+}
+
+// parseLogLevels returns the level of each module: level for all of them, overridden by
+// the comma-separated module=level pairs of overrides, e.g. "rank=debug,query=warn".
+func parseLogLevels(level string, overrides []string) (map[logModule]slog.Level, error) {
+	var all slog.Level
+	if err := all.UnmarshalText([]byte(level)); err != nil {
+		return nil, errors.New("LOG_LEVEL must be one of: debug, info, warn, error")
+	}
+
+	levels := make(map[logModule]slog.Level, len(moduleNames))
+	for m := range moduleNames {
+		levels[logModule(m)] = all
+	}
+
+	for _, override := range overrides {
+		name, value, _ := strings.Cut(override, "=")
+		m := -1
+		for i, module := range moduleNames {
+			if strings.EqualFold(strings.TrimSpace(name), module) {
+				m = i
+			}
+		}
+		if m < 0 {
+			return nil, fmt.Errorf("LOG_LEVELS modules must be among: %s, got %q", strings.Join(moduleNames[:], ", "), name)
+		}
+
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+			return nil, fmt.Errorf("LOG_LEVELS levels must be one of: debug, info, warn, error, got %q", value)
+		}
+		levels[logModule(m)] = l
+	}
+	return levels, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"LOG_LEVEL": "warn", "LOG_LEVELS": "rank=debug, query=error"}[key]
+	})
+	var buf bytes.Buffer
+	loggers := newLoggers(&buf, cfg)
+
+	loggers[moduleRank].Debug("rank line")
+	loggers[moduleEvent].Info("dropped event line")
+	loggers[moduleEvent].Warn("event line")
+	loggers[moduleQuery].Warn("dropped query line")
+	got := buf.String()
+	for _, line := range []string{"rank line", "event line"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("expected %q to be logged, got %q", line, got)
+		}
+	}
+	if strings.Contains(got, "dropped") {
+		t.Errorf("lines below their module's level must be dropped, got %q", got)
+	}
+
+	// DEBUG lowers the default level
+	cfg = parseConfig(func(key string) string { return map[string]string{"DEBUG": "1"}[key] })
+	if cfg.LogLevels[moduleLimiter] != slog.LevelDebug {
+		t.Errorf("expected the debug level with DEBUG set, got %s", cfg.LogLevels[moduleLimiter])
+	}
+
+	for _, overrides := range [][]string{{"cache=debug"}, {"rank=loud"}, {"rank"}} {
+		if _, err := parseLogLevels("info", overrides); err == nil {
+			t.Errorf("%v: expected an error", overrides)
+		}
+	}
+	if _, err := parseLogLevels("verbose", nil); err == nil {
+		t.Error("expected an error for an unknown LOG_LEVEL")
+	}
+}
+
+func TestLogJSONWithRequestFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLoggers(&buf, Config{LogFormat: logJSON})[moduleEvent]
+
+	ctx := withLogAttrs(withTrace(context.Background()), slog.String("pubkey", "ab12"), slog.Int("kind", 1))
+	ctx = withLogAttrs(ctx, slog.String("ip_group", "203.0.113.0/24"))
+	log.WarnContext(ctx, "rejected event", "reason", "rate-limited")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level": "WARN", "msg": "rejected event", "module": "event", "trace": traceID(ctx),
+		"pubkey": "ab12", "kind": 1.0, "ip_group": "203.0.113.0/24", "reason": "rate-limited",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s: got %v, want %v", key, line[key], value)
+		}
+	}
+}
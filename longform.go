@@ -0,0 +1,39 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindLongform is the NIP-23 long-form article kind.
+const kindLongform = 30023
+
+// checkLongform enforces the long-form policy: a minimum trust tier,
+// a maximum content size, and a "d" tag identifying the article.
+func checkLongform(e *nostr.Event, tier Tier, cfg Config) error {
+	if tier < cfg.LongformMinTier {
+		return ErrLongformNotAllowed
+	}
+	if cfg.LongformMaxSize > 0 && len(e.Content) > cfg.LongformMaxSize {
+		return ErrLongformTooLarge
+	}
+	if e.Tags.Find("d") == nil {
+		return ErrLongformMissingD
+	}
+	return nil
+}
+
+// eventCost returns the number of tokens an event consumes from its author's bucket:
+// its flat cost, or the cost of its serialized size for the tier when that's higher.
+func eventCost(e *nostr.Event, tier Tier, cfg Config) float64 {
+	cost := 1.0
+	if e.Kind == kindLongform {
+		cost = cfg.LongformTokenCost
+	}
+	if multiplier := cfg.SizeCostMultipliers[tier]; multiplier > 0 && cfg.SizeCostBytes > 0 {
+		cost = max(cost, multiplier*float64(len(e.String()))/float64(cfg.SizeCostBytes))
+	}
+	return cost
+}
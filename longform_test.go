@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckLongform(t *testing.T) {
+	cfg := Config{MidThreshold: 0.5, LongformMinTier: TierMid, LongformMaxSize: 100, LongformTokenCost: 5}
+	article := func(content string, tags nostr.Tags) *nostr.Event {
+		return &nostr.Event{Kind: kindLongform, Content: content, Tags: tags}
+	}
+	withD := nostr.Tags{{"d", "my-article"}}
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		tier     Tier
+		expected error
+	}{
+		{name: "mid tier article", event: article("hello", withD), tier: TierMid, expected: nil},
+		{name: "high tier article", event: article("hello", withD), tier: TierHigh, expected: nil},
+		{name: "low tier article", event: article("hello", withD), tier: TierLow, expected: ErrLongformNotAllowed},
+		{name: "too large", event: article(strings.Repeat("a", 101), withD), tier: TierHigh, expected: ErrLongformTooLarge},
+		{name: "missing d tag", event: article("hello", nil), tier: TierHigh, expected: ErrLongformMissingD},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkLongform(tt.event, tt.tier, cfg); !errors.Is(err, tt.expected) {
+				t.Errorf("checkLongform() = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+
+	if cost := eventCost(article("hello", withD), TierHigh, cfg); cost != 5 {
+		t.Errorf("expected long-form cost 5, got %.0f", cost)
+	}
+	if cost := eventCost(&nostr.Event{Kind: 1}, TierLow, cfg); cost != 1 {
+		t.Errorf("expected kind 1 cost 1, got %.0f", cost)
+	}
+}
+
+func TestEventCostBySize(t *testing.T) {
+	cfg := Config{LongformTokenCost: 5, SizeCostBytes: 1000, SizeCostMultipliers: map[Tier]float64{TierLow: 1, TierMid: 0.5}}
+	note := &nostr.Event{Kind: 1, Content: strings.Repeat("a", 20000)}
+	size := float64(len(note.String()))
+
+	tests := []struct {
+		name     string
+		event    *nostr.Event
+		tier     Tier
+		expected float64
+	}{
+		{name: "small note", event: &nostr.Event{Kind: 1, Content: "hello"}, tier: TierLow, expected: 1},
+		{name: "large note, low tier", event: note, tier: TierLow, expected: size / 1000},
+		{name: "large note, mid tier", event: note, tier: TierMid, expected: size / 2000},
+		{name: "large note, flat cost tier", event: note, tier: TierHigh, expected: 1},
+		{name: "small article", event: &nostr.Event{Kind: kindLongform, Content: "hello"}, tier: TierLow, expected: 5},
+		{name: "large article", event: &nostr.Event{Kind: kindLongform, Content: note.Content}, tier: TierLow, expected: size / 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cost := eventCost(tt.event, tt.tier, cfg); math.Abs(cost-tt.expected) > 0.1 {
+				t.Errorf("eventCost() = %.2f, want %.2f", cost, tt.expected)
+			}
+		})
+	}
+}
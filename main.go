@@ -5,13 +5,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -20,7 +29,10 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/pippellia-btc/rely"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Build-time variables (set via -ldflags)
@@ -32,6 +44,9 @@ var (
 
 // Config holds application configuration parameters.
 type Config struct {
+	// Profile: named preset (strict, balanced or open) providing defaults for the other settings
+	Profile string
+
 	// MidThreshold: trust score above which all kinds are allowed
 	MidThreshold float64
 
@@ -39,15 +54,68 @@ type Config struct {
 	// If nil, there is no distinct high tier and high-threshold policies apply to all values exceeding midThreshold
 	HighThreshold *float64
 
+	// RateMultiplier: factor applied to the daily rates of all tiers
+	RateMultiplier float64
+
+	// LowTierKinds: kinds accepted from pubkeys below MidThreshold
+	LowTierKinds KindSet
+
+	// MidTierKinds: kinds accepted from pubkeys in the mid tier
+	MidTierKinds KindSet
+
+	// HighTierKinds: kinds accepted from pubkeys in the high tier
+	HighTierKinds KindSet
+
 	// URLPolicyEnabled: whether to enforce URL restriction for users below MidThreshold
 	URLPolicyEnabled bool
 
+	// URLReplyExemption: whether replies to events from high-trust pubkeys may contain URLs
+	URLReplyExemption bool
+
+	// URLTLDValidation: whether bare domains count as URLs only if their TLD is in the public suffix list
+	URLTLDValidation bool
+
+	// URLExtraTLDs: additional TLDs counted as valid for bare domains
+	URLExtraTLDs []string
+
 	// GlobalRankRefreshLimit: max rank refresh requests per second, relay-wide
 	GlobalRankRefreshLimit float64
 
-	// RankCacheSize: maximum number of entries in rank cache (default: 100000)
+	// RankCacheSize: maximum number of entries in the rank cache, least recently used evicted first
 	RankCacheSize int
 
+	// RankRefreshQueueSize: capacity of the channel of pubkeys waiting for a rank refresh
+	RankRefreshQueueSize int
+
+	// RankRefreshOverflow: what to do with pubkeys queued while the refresh channel is full,
+	// "drop", "drop-oldest" or "spill" (to the event store)
+	RankRefreshOverflow string
+
+	// RankRefreshPersist: whether the pubkeys waiting for a rank refresh are saved to the event store at shutdown
+	RankRefreshPersist bool
+
+	// RankPrefetchAuthors: max pubkeys from the authors of REQ filters queued for a rank refresh per connection (0 disables)
+	RankPrefetchAuthors int
+
+	// RankGraceMinutes: how long a pubkey evicted from the rank cache keeps its last rank while it's refreshed (0 disables)
+	RankGraceMinutes int
+
+	// RankProvider: where ranks come from, "relatr" (the Relatr service) or "local" (the follow graph)
+	RankProvider string
+
+	// WoTSeedRelays: relays from which the local rank provider crawls follow lists
+	WoTSeedRelays []string
+
+	// WoTSeedPubkeys: pubkeys trusted by the local rank provider, from which the follow graph is crawled
+	WoTSeedPubkeys []string
+
+	// WoTHopWeights: rank earned from a follow by a pubkey at each depth from the seeds,
+	// whose number sets the depth of the crawl
+	WoTHopWeights []float64
+
+	// WoTRecomputeHours: how often the local rank provider recomputes the follow graph
+	WoTRecomputeHours int
+
 	// RelatrRelay: ContextVM relay URL for rank lookups
 	RelatrRelay string
 
@@ -57,9 +125,499 @@ type Config struct {
 	// RelatrSecretKey: Secret key for signing rank requests (should be loaded from env)
 	RelatrSecretKey string
 
+	// RelatrKeepaliveSeconds: how often the Relatr relay connection is pinged and re-established if down (0 disables)
+	RelatrKeepaliveSeconds int
+
+	// HellthreadThreshold: max distinct p-tagged participants for events below MidThreshold (0 disables)
+	HellthreadThreshold int
+
+	// HellthreadAction: what to do with low-trust hellthread events, "reject" or "strip"
+	HellthreadAction string
+
+	// EntitySpamThreshold: max nostr entity references (npub, nevent, ...) in content for events below MidThreshold (0 disables)
+	EntitySpamThreshold int
+
+	// EntitySpamAction: what to do with low-trust events over the threshold, "reject" or "pow"
+	EntitySpamAction string
+
+	// EntitySpamPoWDifficulty: NIP-13 difficulty required when EntitySpamAction is "pow"
+	EntitySpamPoWDifficulty int
+
+	// DuplicateContentThreshold: max distinct pubkeys below MidThreshold that may publish identical content within the window (0 disables)
+	DuplicateContentThreshold int
+
+	// DuplicateContentWindowMinutes: how long identical content is remembered
+	DuplicateContentWindowMinutes int
+
+	// ContentQualityAction: what to do with events below MidThreshold flagged by the content
+	// quality heuristics (repeated characters, low entropy, all caps): "off", "cost" or "reject"
+	ContentQualityAction string
+
+	// ContentQualityTokenCost: tokens consumed by each flagged event when ContentQualityAction is "cost"
+	ContentQualityTokenCost float64
+
+	// MaxEventAgeHours: events older than this are rejected, for each trust tier (0 means no limit)
+	MaxEventAgeHours map[Tier]int
+
+	// MaxFutureSeconds: how far in the future an event's created_at may be
+	MaxFutureSeconds int
+
+	// ClockSkewSeconds: tolerance past MaxFutureSeconds within which events are accepted
+	// as if created at server time instead of rejected (0 disables)
+	ClockSkewSeconds int
+
+	// BackfillPolicies: what happens to events older than 24h, for each trust tier:
+	// "normal", "free", "discount" or "reject"
+	BackfillPolicies map[Tier]string
+
+	// BackfillDiscount: fraction of their cost old events pay under the "discount" policy
+	BackfillDiscount float64
+
+	// BackfillDailyCap: events a pubkey may backfill a day without rate limiting (0 means no cap)
+	BackfillDailyCap int
+
+	// WriteWindow: cron schedule of the minutes writes are open, in UTC (nil keeps them open at all times)
+	WriteWindow *Schedule
+
+	// LaunchAt: time of the relay's launch, before which writes are closed (zero disables the soft launch)
+	LaunchAt time.Time
+
+	// LaunchDays: days after LaunchAt the writes of each trust tier open
+	LaunchDays map[Tier]int
+
+	// SizeCostBytes: serialized event size worth one token when size-weighted costs apply
+	SizeCostBytes int
+
+	// SizeCostMultipliers: tokens per SizeCostBytes of event size, for each trust tier (0 means a flat cost)
+	SizeCostMultipliers map[Tier]float64
+
+	// OnboardingEnabled: whether pubkeys without a rank go through the onboarding stages
+	// (unknown, probation, member) instead of all getting the rate of rank 0
+	OnboardingEnabled bool
+
+	// OnboardingDailyRates: max events per day for each onboarding stage
+	OnboardingDailyRates map[OnboardingStage]float64
+
+	// OnboardingProbationHours: how long after it was first seen an unknown pubkey is put on probation
+	OnboardingProbationHours int
+
+	// OnboardingMemberHours: how long after it was first seen a pubkey on probation may become a member
+	OnboardingMemberHours int
+
+	// OnboardingMemberEvents: accepted events a pubkey on probation needs to become a member
+	OnboardingMemberEvents int
+
+	// RepostPolicyEnabled: whether to enforce target checks, dedup and daily caps on reposts (kinds 6 and 16)
+	RepostPolicyEnabled bool
+
+	// RepostDailyCaps: max reposts per day for each trust tier, even if its kinds don't include
+	// reposts (0 means no cap, for the tiers whose kinds do)
+	RepostDailyCaps map[Tier]float64
+
+	// ZapValidationEnabled: whether to verify kind 9735 zap receipts before storing them
+	ZapValidationEnabled bool
+
+	// ZapVerifyProvider: whether zap receipts must be signed by the recipient's LNURL provider
+	ZapVerifyProvider bool
+
+	// ZapTrustEnabled: whether verified zaps received from high-trust pubkeys raise a pubkey's rank
+	ZapTrustEnabled bool
+
+	// ZapTrustSats: sats zapped by high-trust pubkeys needed to earn the full bonus
+	ZapTrustSats float64
+
+	// ZapTrustMaxBonus: maximum rank bonus earned through zaps
+	ZapTrustMaxBonus float64
+
+	// LongformMinTier: minimum trust tier allowed to publish long-form articles (kind 30023)
+	LongformMinTier Tier
+
+	// LongformTokenCost: tokens consumed by each long-form article
+	LongformTokenCost float64
+
+	// LongformMaxSize: maximum content length of long-form articles in bytes (0 means no limit)
+	LongformMaxSize int
+
+	// FileMinTier: minimum trust tier allowed to publish file metadata (kind 1063)
+	FileMinTier Tier
+
+	// FileAllowedHosts: media hosts file metadata may point to (empty means any host)
+	FileAllowedHosts []string
+
+	// FileDailyCaps: max file metadata events per day for each trust tier (0 means no cap)
+	FileDailyCaps map[Tier]float64
+
+	// FileVerifyHash: whether to download referenced files and check their sha256 against the "x" tag
+	FileVerifyHash bool
+
+	// FileMaxSize: maximum file size in bytes downloaded for hash verification
+	FileMaxSize int64
+
+	// CommunityModerationEnabled: whether to validate NIP-72 approvals and only serve community posts approved by moderators
+	CommunityModerationEnabled bool
+
+	// BannedPubkeys: pubkeys whose events are rejected outright
+	BannedPubkeys []string
+
+	// AllowedPubkeys: pubkeys treated as high-trust (rank 1) without asking the rank provider
+	AllowedPubkeys []string
+
+	// ShadowBanTiers: tiers whose events are acknowledged but silently dropped (initial state of the shadow_ban flag)
+	ShadowBanTiers []Tier
+
+	// RankOverrides: ranks used instead of the provider's for some pubkeys
+	RankOverrides map[string]float64
+
+	// AdminToken: bearer token of the admin API and /stats (empty disables them)
+	AdminToken string
+
+	// AdminPubkeys: pubkeys allowed to use the NIP-86 management API (empty disables it)
+	AdminPubkeys []string
+
+	// TrustedPeers: relay keys of peer relays whose events, relayed over connections
+	// authenticated (NIP-42) with these keys, skip rate limiting and content policies
+	TrustedPeers []string
+
+	// RelayDomain: domain of the relay (e.g. "relay.example.com"), against which NIP-42 authentications are validated
+	RelayDomain string
+
+	// IPGroupDailyRate: max events per day accepted from an IP group below MidThreshold,
+	// across all its pubkeys (0 disables)
+	IPGroupDailyRate float64
+
+	// CheckRatePerMinute: max /check requests per minute from an IP group
+	CheckRatePerMinute float64
+
+	// LatestRatePerMinute: max /latest requests per minute from an IP group
+	LatestRatePerMinute float64
+
+	// HTTPRatePerMinute: max plain HTTP requests per minute from an IP group, to any endpoint (0 disables)
+	HTTPRatePerMinute float64
+
+	// BanEvasionEnabled: whether pubkeys sharing an IP group with a banned pubkey get heightened scrutiny
+	BanEvasionEnabled bool
+
+	// SuspectRateMultiplier: multiplier applied to the daily rate of pubkeys linked to a banned pubkey
+	SuspectRateMultiplier float64
+
+	// SuspectPoWDifficulty: NIP-13 difficulty required from pubkeys linked to a banned pubkey (0 disables)
+	SuspectPoWDifficulty int
+
+	// UnrankedPoWDifficulty: NIP-13 difficulty with which unranked pubkeys pay for events instead of tokens (0 disables)
+	UnrankedPoWDifficulty int
+
+	// ReputationSecretKey: key signing the relay's reputation list and its requests for the peers' lists (empty disables sharing)
+	ReputationSecretKey string
+
+	// ReputationPeers: keys of the cooperating relays whose lists are merged, with their trust weight,
+	// and which may fetch the relay's list
+	ReputationPeers map[string]float64
+
+	// ReputationSources: URLs of the peers' reputation lists
+	ReputationSources []string
+
+	// ReputationThreshold: summed weight of the peers listing a pubkey or IP group at which it becomes suspect
+	ReputationThreshold float64
+
+	// ReputationSchedule: cron schedule of the reputation-sync job, fetching the peers' lists
+	ReputationSchedule string
+
+	// BlocklistSources: URLs or files of the blocklists of event IDs and file hashes the relay subscribes to
+	BlocklistSources []string
+
+	// BlocklistSchedule: cron schedule of the blocklist-sync job, fetching the blocklists
+	BlocklistSchedule string
+
+	// PaymentsBackend: Lightning backend issuing the invoices of paid memberships, "lnbits" or "lnd" (empty disables payments)
+	PaymentsBackend string
+
+	// PaymentsBackendURL: base URL of the backend's REST API
+	PaymentsBackendURL string
+
+	// PaymentsBackendKey: LNbits invoice key, or LND invoice macaroon in hex
+	PaymentsBackendKey string
+
+	// PaymentsPriceMsats: price of a membership in msats
+	PaymentsPriceMsats int
+
+	// PaymentsDays: days of membership a payment buys
+	PaymentsDays int
+
+	// PaymentsRank: rank members get at least
+	PaymentsRank float64
+
+	// PaymentsSchedule: cron schedule of the payments job, checking the invoices waiting for their payment
+	PaymentsSchedule string
+
+	// FeedbackMode: how authors are told why their events were rejected: "off",
+	// "notice" (an ephemeral event) or "dm" (a NIP-17 direct message)
+	FeedbackMode string
+
+	// FeedbackSecretKey: key signing the feedback sent to authors
+	FeedbackSecretKey string
+
+	// FeedbackCooldownMinutes: minimum time between two messages to a pubkey about the same reason (0 means none)
+	FeedbackCooldownMinutes int
+
+	// DigestMode: how the operators get the digest of new community members: "off",
+	// "note" (a text note on the relay) or "dm" (NIP-17 direct messages to the AdminPubkeys)
+	DigestMode string
+
+	// DigestSecretKey: key signing the digest
+	DigestSecretKey string
+
+	// DigestSchedule: cron schedule of the digest job
+	DigestSchedule string
+
+	// AdaptiveEnabled: whether the low tier's policy is tightened automatically during spam waves
+	AdaptiveEnabled bool
+
+	// AdaptiveIntervalSeconds: length of the intervals over which spam waves are detected
+	AdaptiveIntervalSeconds int
+
+	// AdaptiveRejectRatio: share of events rejected as spam in an interval signaling a spam wave
+	AdaptiveRejectRatio float64
+
+	// AdaptiveStoreGrowth: accepted events in an interval signaling a spam wave (0 disables)
+	AdaptiveStoreGrowth int
+
+	// AdaptiveMinRateFactor: lower bound of the factor applied to the low tier's daily rate during spam waves
+	AdaptiveMinRateFactor float64
+
+	// AdaptiveMaxPoW: upper bound of the NIP-13 difficulty required from the low tier during spam waves
+	AdaptiveMaxPoW int
+
+	// OutboundFrameRate: max websocket data frames written per second to each connection (0 disables pacing)
+	OutboundFrameRate float64
+
+	// OutboundFrameBurst: data frames that can be written to a connection at once before pacing applies
+	OutboundFrameBurst float64
+
+	// NoticeCoalesceSeconds: identical NOTICEs sent to a connection within this window are dropped (0 disables)
+	NoticeCoalesceSeconds int
+
+	// LiveEventRate: max live events delivered per second to each subscription (0 disables the cap)
+	LiveEventRate float64
+
+	// LiveEventBurst: live events that can be delivered to a subscription at once before the cap applies
+	LiveEventBurst float64
+
+	// LiveEventOverflow: what happens to live events over the cap: drop-oldest or coalesce
+	LiveEventOverflow string
+
+	// LiveEventBuffer: live events over the cap queued per subscription with drop-oldest
+	LiveEventBuffer int
+
+	// MaxConnectionsPerIP: max simultaneous websocket connections per IP group, across virtual relays (0 means no limit)
+	MaxConnectionsPerIP int
+
+	// MaxConnections: max simultaneous websocket connections of the process (0 means no limit)
+	MaxConnections int
+
+	// ConnectionReserve: connections of MaxConnections held back for clients authenticating as
+	// a pubkey of the mid tier or above, or a trusted peer (0 disables)
+	ConnectionReserve int
+
+	// ReserveAuthSeconds: how long a connection admitted into the reserve has to authenticate
+	ReserveAuthSeconds int
+
+	// EventMaxSize: max serialized size of an event in bytes, advertised as limitation.max_message_length (0 means no limit)
+	EventMaxSize int
+
+	// EventMaxTags: max tags of an event, advertised as limitation.max_event_tags (0 means no limit)
+	EventMaxTags int
+
+	// EventMaxContentLength: max characters of an event's content, advertised as limitation.max_content_length (0 means no limit)
+	EventMaxContentLength int
+
+	// ReqMaxFilters: max filters in a REQ, advertised as limitation.max_filters (0 means no limit)
+	ReqMaxFilters int
+
+	// ReqMaxSubscriptions: max open subscriptions per connection, advertised as limitation.max_subscriptions (0 means no limit).
+	// Off by default, as a REQ updating an open subscription counts as a new one
+	ReqMaxSubscriptions int
+
+	// ReqFiltersPerMinute: filters per minute that an IP group can send in REQs without authenticating (0 means no limit)
+	ReqFiltersPerMinute float64
+
+	// ReqFiltersPerMinuteAuthed: filters per minute that an authenticated pubkey can send in REQs
+	// (0 means authenticated connections share the budget of their IP group)
+	ReqFiltersPerMinuteAuthed float64
+
+	// ReqMaxEvents: max stored events returned to a REQ before EOSE, across all its filters (0 means rely's default budget)
+	ReqMaxEvents int
+
+	// ReqKeepOpen: whether subscriptions stay open for live events after EOSE
+	ReqKeepOpen bool
+
+	// SubscriptionMaxAgeMinutes: subscriptions open for longer than this are closed (0 means no limit)
+	SubscriptionMaxAgeMinutes int
+
+	// ThreadFetchRelays: relays from which the unknown parent and root of stored replies are fetched (empty disables)
+	ThreadFetchRelays []string
+
+	// MirrorRelays: relays to which accepted events are republished
+	MirrorRelays []string
+
+	// MirrorOutbox: whether accepted events are also republished to the author's write relays and the relays hinted in their tags
+	MirrorOutbox bool
+
+	// MirrorMinTier: minimum trust tier of the pubkeys whose events are republished
+	MirrorMinTier Tier
+
+	// MirrorMaxRelays: max relays each event is republished to
+	MirrorMaxRelays int
+
+	// DecisionLogFile: JSON Lines file receiving per-event decision records (empty disables)
+	DecisionLogFile string
+
+	// DecisionLogSampleRate: fraction of events written to the decision log
+	DecisionLogSampleRate float64
+
+	// CandidateFile: env file of the variables a candidate config changes, evaluated
+	// in shadow mode against the active config (empty disables)
+	CandidateFile string
+
+	// CandidateSampleRate: fraction of the pubkeys whose events the candidate config decides about
+	CandidateSampleRate float64
+
+	// HoneypotStorePath: directory of the Badger store receiving spam samples (empty disables the honeypot)
+	HoneypotStorePath string
+
+	// HoneypotRetentionDays: spam samples older than this many days are pruned (0 keeps them forever)
+	HoneypotRetentionDays int
+
+	// HoneypotMaxEvents: maximum number of spam samples stored (0 means no quota)
+	HoneypotMaxEvents int
+
+	// StorePath: directory of the Badger store holding the events with the badger backend,
+	// and the relay's own records (deletion tombstones, onboarding, ...) with every backend
+	StorePath string
+
+	// EventStoreBackend: event store backend: badger, lmdb, sqlite3 or postgresql
+	EventStoreBackend string
+
+	// LMDBPath: directory of the LMDB event store
+	LMDBPath string
+
+	// SQLitePath: path of the SQLite event store
+	SQLitePath string
+
+	// PostgresURL: connection URL of the PostgreSQL event store
+	PostgresURL string
+
+	// IndexWarmup: whether to read the index keys of the event store at startup, loading them into the cache
+	IndexWarmup bool
+
+	// RetentionDays: events older than this many days are pruned, except exempt kinds (0 keeps events forever)
+	RetentionDays int
+
+	// RetentionKinds: days events of some kinds are kept instead of RetentionDays, exempt kinds included (0 keeps them forever)
+	RetentionKinds map[int]int
+
+	// StoreMaxGB: size of the event store on disk, in GB, beyond which its oldest events are pruned (0 means no cap)
+	StoreMaxGB float64
+
+	// CompactionAgeDays: reactions and zap receipts older than this many days are compacted into counts (0 disables)
+	CompactionAgeDays int
+
+	// StoreMaxEvents: maximum number of stored events, new events are rejected beyond it (0 means no quota)
+	StoreMaxEvents int
+
+	// StoreAlertWebhook: URL notified with a JSON POST when the store becomes unwritable or recovers (empty disables)
+	StoreAlertWebhook string
+
+	// PruneSchedule: cron schedule of the prune job, deleting events past RetentionDays (empty disables)
+	PruneSchedule string
+
+	// CompactSchedule: cron schedule of the compact job, compacting events past CompactionAgeDays (empty disables)
+	CompactSchedule string
+
+	// RankBackfillSchedule: cron schedule of the rank-backfill job, refreshing the ranks of recent authors missing from the cache (empty disables)
+	RankBackfillSchedule string
+
+	// ReportSchedule: cron schedule of the report job, posting the stats of every relay to ReportWebhook (empty disables)
+	ReportSchedule string
+
+	// ReportWebhook: URL the report job posts the stats to
+	ReportWebhook string
+
+	// ListenAddr: address the HTTP server listens on
+	ListenAddr string
+
+	// TLSCertFile, TLSKeyFile: certificate and key served over TLS, reloaded when they change (empty serves plain HTTP)
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ACMEDomains: domains to get Let's Encrypt certificates for, instead of TLSCertFile (empty disables)
+	ACMEDomains []string
+
+	// ACMEEmail: contact address for the Let's Encrypt account (optional)
+	ACMEEmail string
+
+	// ACMECacheDir: directory where Let's Encrypt certificates are cached
+	ACMECacheDir string
+
+	// SnapshotSchedule: cron schedule of event store snapshots, e.g. "0 3 * * *" (empty disables)
+	SnapshotSchedule string
+
+	// SnapshotDir: directory where snapshots are written, verified and, without S3, kept
+	SnapshotDir string
+
+	// SnapshotKeep: number of snapshots kept per relay
+	SnapshotKeep int
+
+	// SnapshotS3Endpoint, SnapshotS3Bucket, ...: S3-compatible bucket snapshots are uploaded to (empty bucket keeps them in SnapshotDir)
+	SnapshotS3Endpoint  string
+	SnapshotS3Bucket    string
+	SnapshotS3Region    string
+	SnapshotS3Prefix    string
+	SnapshotS3AccessKey string
+	SnapshotS3SecretKey string
+
+	// ClusterRedisURL: Redis server coordinating the nodes of a cluster (empty runs standalone)
+	ClusterRedisURL string
+
+	// ClusterNodeID: identifier of this node within the cluster (default: hostname)
+	ClusterNodeID string
+
+	// ConfigFile: .env file loaded at startup, receiving the changes made in the config editor
+	ConfigFile string
+
+	// TenantsFile: JSON file declaring virtual relays served alongside the default one (empty disables multi-tenancy)
+	TenantsFile string
+
 	// Debug: whether to enable verbose debug logging
 	Debug bool
 
+	// LogFormat: format of the log lines, "text" (logfmt) or "json"
+	LogFormat string
+
+	// LogLevels: minimum level of the log lines of each module (LOG_LEVEL, overridden per module by LOG_LEVELS)
+	LogLevels map[logModule]slog.Level
+
+	// MetricsToken: bearer token required to scrape /metrics (empty serves it openly)
+	MetricsToken string
+
+	// CORSOrigins: origins of browser-based clients allowed to read the HTTP endpoints ("*" for any)
+	CORSOrigins []string
+
+	// ObservabilityLog: whether to log the observability counters every 30 minutes
+	ObservabilityLog bool
+
+	// AccessLog: whether to log a line per HTTP request, WebSocket upgrades included
+	AccessLog bool
+
+	// AccessLogSampleRate: fraction of successful HTTP requests logged (errors always are)
+	AccessLogSampleRate float64
+
+	// TracingEndpoint: base URL of the OTLP/HTTP endpoint receiving OpenTelemetry spans at /v1/traces,
+	// e.g. http://localhost:4318 (empty disables tracing)
+	TracingEndpoint string
+
+	// TracingSampleRate: fraction of events and queries traced
+	TracingSampleRate float64
+
 	// NIP-11 Relay Information Document configuration
 	RelayName        string
 	RelayDescription string
@@ -67,10 +625,28 @@ type Config struct {
 	RelayContact     string
 	Software         string
 	Version          string
+	// RelayIcon, RelayBanner: images referenced by the NIP-11 document and the HTML page,
+	// each a URL, or a file path or "base64:" bytes for an image hosted by the relay
+	RelayIcon   string
+	RelayBanner string
+	// Favicon: file path or "base64:" bytes of a PNG/SVG/ICO favicon, replacing the generated one
+	Favicon string
+	// ThemeColor: #rrggbb accent color of the landing page and generated favicon
+	ThemeColor string
+	// PaymentsURL: page where users pay the relay's fees
+	PaymentsURL string
+	// PaymentRequired: advertise in NIP-11 that publishing requires a payment
+	PaymentRequired bool
+	// FeesAdmission: one-time fee in msats to publish to the relay (0 means none)
+	FeesAdmission int
+	// FeesSubscription: recurring fees in msats, per period in days
+	FeesSubscription map[int]int
+	// FeesPublication: fees in msats to publish an event, per kind
+	FeesPublication map[int]int
 }
 
-// Timestamp sanity window: reject events >24h in the future
-const timestampSanityWindow = 24 * time.Hour
+// maxMessageSize is the size limit of the websocket messages clients send, rely's default.
+const maxMessageSize = 500_000
 
 // Backfill age threshold: events older than this may be free for high-trust pubkeys
 const backfillAgeThreshold = 24 * time.Hour
@@ -81,12 +657,54 @@ const secondsPerDay = 86400
 // Sentinel errors for event rejection reasons.
 // Error strings should not be capitalized or end with punctuation.
 var (
-	ErrKindNotAllowed   = errors.New("kind-not-allowed: just kind 1 events")
+	ErrKindNotAllowed   = errors.New("kind-not-allowed: kind not allowed at your trust level")
 	ErrInvalidTimestamp = errors.New("invalid-timestamp: event timestamp is too far in the future")
+	ErrEventTooOld      = errors.New("invalid-timestamp: event is too old for your trust level")
 	ErrRateLimited      = errors.New("rate-limited: please try again later")
+	ErrIPRateLimited    = errors.New("rate-limited: too many events from your network")
 	ErrURLNotAllowed    = errors.New("url-not-allowed: only text notes without URLs")
+	ErrBanned           = errors.New("blocked: pubkey is banned")
+	ErrHellthread       = errors.New("hellthread: too many tagged participants")
+	ErrEntitySpam       = errors.New("blocked: too many embedded nostr references")
+	ErrDuplicateContent = errors.New("blocked: identical content posted by too many pubkeys")
+	ErrLowQuality       = errors.New("blocked: content looks like spam")
+	ErrRepostTarget     = errors.New("invalid: reposted event is unknown or from a banned author")
+	ErrRepostDuplicate  = errors.New("duplicate: event already reposted")
+	ErrRepostLimited    = errors.New("rate-limited: too many reposts today")
+	ErrInvalidZap       = errors.New("invalid: zap receipt")
+	ErrOutdated         = errors.New("duplicate: a newer version of this event is already stored")
+	ErrEventTooLarge    = errors.New("invalid: event is too large")
+	ErrTooManyTags      = errors.New("invalid: too many tags")
+	ErrContentTooLong   = errors.New("invalid: content is too long")
+	ErrWritesClosed     = errors.New("restricted: writes are closed for now")
+
+	ErrLongformNotAllowed = errors.New("kind-not-allowed: long-form articles require a higher trust tier")
+	ErrLongformTooLarge   = errors.New("invalid: long-form article is too large")
+	ErrLongformMissingD   = errors.New("invalid: long-form article must have a d tag")
+
+	ErrFileNotAllowed       = errors.New("kind-not-allowed: file metadata requires a higher trust tier")
+	ErrFileHostNotAllowed   = errors.New("url-not-allowed: file host is not allowlisted")
+	ErrFileLimited          = errors.New("rate-limited: too many files today")
+	ErrFileHashMismatch     = errors.New("invalid: file hash does not match")
+	ErrInvalidFile          = errors.New("invalid: file metadata")
+	ErrInvalidApproval      = errors.New("invalid: approval is not from a community moderator")
+	ErrPoWRequired          = errors.New("pow: insufficient proof of work")
+	ErrStoreFull            = errors.New("blocked: relay storage quota reached")
+	ErrStoreUnavailable     = errors.New("error: relay storage is unavailable, events are not accepted for now")
+	ErrStoreBusy            = errors.New("error: relay storage is busy, please retry")
+	ErrTooManyFilters       = errors.New("invalid: too many filters")
+	ErrTooManySubscriptions = errors.New("rate-limited: too many open subscriptions")
+	ErrReqRateLimited       = errors.New("rate-limited: too many queries, slow down")
+	ErrTooManyConnections   = errors.New("rate-limited: too many connections from your network")
+	ErrRelayFull            = errors.New("rate-limited: relay has too many connections, please try again later")
+	ErrAuthToStay           = errors.New("auth-required: relay is busy, authenticate with a trusted pubkey to stay connected")
+	ErrRelayBusy            = errors.New("rate-limited: relay is busy, only trusted pubkeys may connect for now")
 )
 
+// errShadowBanned is returned for the events of a shadow-banned tier. The relay tells the
+// author they were accepted, but they are never stored, mirrored nor digested.
+var errShadowBanned = errors.New("blocked: author is shadow-banned")
+
 // exemptKinds are event kinds that bypass rate limiting and kind gating.
 var exemptKinds = map[int]bool{
 	0:     true,
@@ -98,12 +716,186 @@ var exemptKinds = map[int]bool{
 
 // Observability tracks operational metrics for monitoring and debugging.
 type Observability struct {
-	rateLimitedCount      atomic.Uint64
-	kindNotAllowedCount   atomic.Uint64
-	invalidTimestampCount atomic.Uint64
-	urlNotAllowedCount    atomic.Uint64
-	rankCacheHits         atomic.Uint64
-	rankCacheMisses       atomic.Uint64
+	rateLimitedCount          atomic.Uint64
+	ipRateLimitedCount        atomic.Uint64
+	httpRateLimitedCount      atomic.Uint64
+	kindNotAllowedCount       atomic.Uint64
+	invalidTimestampCount     atomic.Uint64
+	tooOldCount               atomic.Uint64
+	clockSkewCount            atomic.Uint64
+	backfillCount             atomic.Uint64
+	backfillCappedCount       atomic.Uint64
+	backfillDiscountedCount   atomic.Uint64
+	honeypotCount             atomic.Uint64
+	urlNotAllowedCount        atomic.Uint64
+	rankCacheHits             atomic.Uint64
+	rankCacheMisses           atomic.Uint64
+	bannedCount               atomic.Uint64
+	oversizedCount            atomic.Uint64
+	writesClosedCount         atomic.Uint64
+	tooManyFiltersCount       atomic.Uint64
+	tooManySubscriptionsCount atomic.Uint64
+	mirrorPublishedCount      atomic.Uint64
+	mirrorFailedCount         atomic.Uint64
+	mirrorDroppedCount        atomic.Uint64
+	compactedCount            atomic.Uint64
+	threadFetchedCount        atomic.Uint64
+	threadMissedCount         atomic.Uint64
+	threadDroppedCount        atomic.Uint64
+	reqRateLimitedCount       atomic.Uint64
+	shadowBannedCount         atomic.Uint64
+	onboardingProbationCount  atomic.Uint64
+	onboardingMemberCount     atomic.Uint64
+	onboardingDemotedCount    atomic.Uint64
+	peerEventCount            atomic.Uint64
+	suspectCount              atomic.Uint64
+	powRequiredCount          atomic.Uint64
+	powPaidCount              atomic.Uint64
+	paymentsInvoicedCount     atomic.Uint64
+	paymentsSettledCount      atomic.Uint64
+	adaptiveTightenedCount    atomic.Uint64
+	hellthreadCount           atomic.Uint64
+	entitySpamCount           atomic.Uint64
+	duplicateContentCount     atomic.Uint64
+	lowQualityCount           atomic.Uint64
+	repostRejectedCount       atomic.Uint64
+	invalidZapCount           atomic.Uint64
+	longformRejectedCount     atomic.Uint64
+	fileRejectedCount         atomic.Uint64
+	invalidApprovalCount      atomic.Uint64
+	storeFullCount            atomic.Uint64
+	coalescedNoticeCount      atomic.Uint64
+	pacedFrameCount           atomic.Uint64
+	liveDelayedCount          atomic.Uint64
+	liveOverflowCount         atomic.Uint64
+	closedSubscriptionCount   atomic.Uint64
+	savedCount                atomic.Uint64
+	deletedEventCount         atomic.Uint64
+	deletedResubmitCount      atomic.Uint64
+	storeErrorCount           atomic.Uint64
+	storeDegraded             atomic.Uint64 // number of relays whose store is in read-only mode
+	activeConnections         atomic.Int64
+	connectionsRejectedCount  atomic.Uint64
+	connectionsReservedCount  atomic.Uint64
+	connectionsDeferredCount  atomic.Uint64
+	relatrConnected           atomic.Uint64 // 1 while connected to the Relatr relay
+	relatrConnects            atomic.Uint64
+	relatrConnectFailures     atomic.Uint64
+	relatrPingFailures        atomic.Uint64
+	rankCacheEvictions        atomic.Uint64
+	rankRefreshDropped        atomic.Uint64
+	rankPrefetched            atomic.Uint64
+	rankRefreshSpilled        atomic.Uint64
+	rankGraceHits             atomic.Uint64
+	decisionsDropped          atomic.Uint64
+	liveStreamedCount         atomic.Uint64
+	liveDroppedCount          atomic.Uint64
+	feedbackCount             atomic.Uint64
+	digestMembersCount        atomic.Uint64
+	candidateEvaluatedCount   atomic.Uint64
+	candidateDifferedCount    atomic.Uint64
+	blocklistBlockedCount     atomic.Uint64
+	blocklistWithheldCount    atomic.Uint64
+	blocklistPurgedCount      atomic.Uint64
+
+	// rateAllowed and rateLimited count token bucket decisions by the tier of the pubkey's rank
+	rateAllowed [TierHigh + 1]atomic.Uint64
+	rateLimited [TierHigh + 1]atomic.Uint64
+
+	// kinds counts accepted and rejected events per kind
+	kinds KindStats
+
+	// rankCache reports the cache gauges (size, stale entries, refresh queue depth)
+	rankCache atomic.Pointer[RankCache]
+
+	// limiters report their bucket counts
+	limitersMu sync.Mutex
+	limiters   []*Limiter
+}
+
+// TrackLimiter adds the limiter's buckets to the reported metrics.
+func (obs *Observability) TrackLimiter(l *Limiter) *Limiter {
+	obs.limitersMu.Lock()
+	defer obs.limitersMu.Unlock()
+	obs.limiters = append(obs.limiters, l)
+	return l
+}
+
+// limiterStats sums the stats of the tracked limiters.
+func (obs *Observability) limiterStats() LimiterStats {
+	obs.limitersMu.Lock()
+	defer obs.limitersMu.Unlock()
+
+	var total LimiterStats
+	for _, l := range obs.limiters {
+		stats := l.Stats()
+		total.Buckets += stats.Buckets
+		total.Created += stats.Created
+		total.Cleaned += stats.Cleaned
+	}
+	return total
+}
+
+// Deps bundles the long-lived components used by the event and query handlers.
+// Each virtual relay has its own Deps; the rank cache, the global limiter and
+// the observability counters are shared by all of them.
+type Deps struct {
+	Name          string // "default" or the name of the virtual relay
+	Shadow        bool   // deciding for a candidate config: events are never stored, nor counted toward onboarding
+	Cache         *RankCache
+	Limiter       RateLimiter
+	GlobalLimiter RateLimiter
+	Cluster       *Cluster // nil when running standalone
+	DB            EventStore
+	Meta          *badger.BadgerBackend // relay's own records, the same store as DB with the badger backend
+	Obs           *Observability
+	Retention     *Retention
+	StoreHealth   *StoreHealth // nil to pass store errors through as is
+	Linkage       *IPLinkage
+	Reputation    *Reputation // nil unless REPUTATION_PEERS is set
+	Blocklist     *Blocklist  // nil unless BLOCKLIST_SOURCES is set
+	Payments      *Payments   // nil unless PAYMENTS_BACKEND is set
+	Feedback      *Feedback   // nil unless FEEDBACK_MODE is set
+	Digest        *Digest     // nil unless DIGEST_MODE is set
+	Candidate     *Candidate  // nil unless CANDIDATE_FILE is set
+	Dedup         *ContentDedup
+	Zaps          *ZapValidator
+	ZapTrust      *ZapTrust
+	Latest        *LatestSeen // nil to skip tracking
+	Media         *MediaPolicy
+	Decisions     *DecisionLog // nil unless DECISION_LOG_FILE is set
+	Honeypot      *Honeypot    // nil unless HONEYPOT_STORE_PATH is set
+	Adaptive      *Adaptive    // nil unless ADAPTIVE_ENABLED is set
+	Flags         *FeatureFlags
+	Settings      *Settings        // live configuration, nil if it can't be edited
+	Management    *Management      // bans and rank overrides made through the NIP-86 API
+	Clock         func() time.Time // time source, time.Now if nil (used by replays)
+	URLs          *URLDetector
+	Live          *LiveFeed      // nil to skip streaming events stored by other nodes
+	Onboarding    *Onboarding    // nil unless ONBOARDING_ENABLED is set
+	Connections   *ConnLimiter   // shared by all virtual relays, nil for no limits
+	Threads       *ThreadFetcher // nil unless THREAD_FETCH_RELAYS is set
+	Mirror        *Mirror        // nil unless MIRROR_RELAYS or MIRROR_OUTBOX is set
+	Compaction    *Compaction    // nil unless COMPACTION_AGE_DAYS is set
+	Queries       *QueryStats    // nil to skip gathering query statistics
+	Backfill      *Backfill      // nil to skip accounting for backfill
+	Prefetch      *RankPrefetch  // nil to skip prefetching the ranks of REQ authors
+	Jobs          *Scheduler     // shared by all virtual relays, nil when jobs can't be run
+}
+
+func (d *Deps) now() time.Time {
+	if d.Clock != nil {
+		return d.Clock()
+	}
+	return time.Now()
+}
+
+// config returns the live configuration of the relay, or cfg if it has none.
+func (d *Deps) config(cfg Config) Config {
+	if d.Settings != nil {
+		return d.Settings.Config()
+	}
+	return cfg
 }
 
 // loadConfig loads configuration from environment variables with defaults and validation.
@@ -114,106 +906,918 @@ func loadConfig() Config {
 	//
 	// Note: ignore errors so production/container deployments that don't ship a .env
 	// file keep working.
-	_ = godotenv.Load()
+	_ = godotenv.Load(getEnvString(os.Getenv, "CONFIG_FILE", ".env"))
+
+	cfg := parseConfig(os.Getenv)
+
+	// Generate secret key if not provided
+	if cfg.RelatrSecretKey == "" {
+		cfg.RelatrSecretKey = nostr.GeneratePrivateKey()
+		relayLog.Warn("RELATR_SECRET_KEY not set, generated temporary key for this session")
+	}
+
+	return cfg
+}
+
+// parseConfig builds and validates a Config from the variables returned by getenv.
+// Virtual relays use it with their own settings layered over the process environment.
+func parseConfig(getenv func(string) string) Config {
+	cfg, err := buildConfig(getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// buildConfig builds a Config from the variables returned by getenv, and returns
+// an error if it is invalid.
+func buildConfig(getenv func(string) string) (Config, error) {
+	// Apply the profile's defaults to the variables that aren't set
+	profile := strings.ToLower(getenv("PROFILE"))
+	if profile != "" {
+		settings, ok := profiles[profile]
+		if !ok {
+			return Config{}, fmt.Errorf("PROFILE must be one of: %s", strings.Join(profileNames(), ", "))
+		}
+		getenv = profileEnv(settings, getenv)
+	}
 
 	// Get HighThreshold as optional parameter
 	var highThreshold *float64
-	if value := os.Getenv("HIGH_THRESHOLD"); value != "" {
+	if value := getenv("HIGH_THRESHOLD"); value != "" {
 		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
 			highThreshold = &parsed
 		} else {
-			log.Printf("Invalid value for HIGH_THRESHOLD: %s, treating as unset", value)
+			relayLog.Warn("invalid value, treating as unset", "key", "HIGH_THRESHOLD", "value", value)
 		}
 	}
 
 	cfg := Config{
-		MidThreshold:           getEnvFloat("MID_THRESHOLD", 0.5),
-		HighThreshold:          highThreshold,
-		URLPolicyEnabled:       getEnvBool("URL_POLICY_ENABLED", false),
-		GlobalRankRefreshLimit: getEnvFloat("GLOBAL_RANK_REFRESH_LIMIT", 500),
-		RankCacheSize:          getEnvInt("RANK_CACHE_SIZE", 100000),
-		RelatrRelay:            getEnvString("RELATR_RELAY", "wss://relay.contextvm.org"),
-		RelatrPubkey:           getEnvString("RELATR_PUBKEY", "750682303c9f0ddad75941b49edc9d46e3ed306b9ee3335338a21a3e404c5fa3"),
-		RelatrSecretKey:        os.Getenv("RELATR_SECRET_KEY"),
-		Debug:                  os.Getenv("DEBUG") != "",
+		Profile:                       profile,
+		MidThreshold:                  getEnvFloat(getenv, "MID_THRESHOLD", 0.5),
+		HighThreshold:                 highThreshold,
+		RateMultiplier:                getEnvFloat(getenv, "RATE_MULTIPLIER", 1),
+		LowTierKinds:                  getEnvKinds(getenv, "LOW_TIER_KINDS", "1"),
+		MidTierKinds:                  getEnvKinds(getenv, "MID_TIER_KINDS", "*"),
+		HighTierKinds:                 getEnvKinds(getenv, "HIGH_TIER_KINDS", "*"),
+		URLPolicyEnabled:              getEnvBool(getenv, "URL_POLICY_ENABLED", false),
+		URLReplyExemption:             getEnvBool(getenv, "URL_REPLY_EXEMPTION", false),
+		URLTLDValidation:              getEnvBool(getenv, "URL_TLD_VALIDATION", true),
+		URLExtraTLDs:                  getEnvList(getenv, "URL_EXTRA_TLDS"),
+		GlobalRankRefreshLimit:        getEnvFloat(getenv, "GLOBAL_RANK_REFRESH_LIMIT", 500),
+		RankCacheSize:                 getEnvInt(getenv, "RANK_CACHE_SIZE", 100000),
+		RankRefreshQueueSize:          getEnvInt(getenv, "RANK_REFRESH_QUEUE_SIZE", 100),
+		RankRefreshOverflow:           strings.ToLower(getEnvString(getenv, "RANK_REFRESH_OVERFLOW", refreshOverflowDrop)),
+		RankRefreshPersist:            getEnvBool(getenv, "RANK_REFRESH_PERSIST", true),
+		RankPrefetchAuthors:           getEnvInt(getenv, "RANK_PREFETCH_AUTHORS", 100),
+		RankGraceMinutes:              getEnvInt(getenv, "RANK_GRACE_MINUTES", 60),
+		RankProvider:                  strings.ToLower(getEnvString(getenv, "RANK_PROVIDER", rankProviderRelatr)),
+		WoTSeedRelays:                 getEnvList(getenv, "WOT_SEED_RELAYS"),
+		WoTSeedPubkeys:                getEnvList(getenv, "WOT_SEED_PUBKEYS"),
+		WoTRecomputeHours:             getEnvInt(getenv, "WOT_RECOMPUTE_HOURS", 24),
+		RelatrRelay:                   getEnvString(getenv, "RELATR_RELAY", "wss://relay.contextvm.org"),
+		RelatrPubkey:                  getEnvString(getenv, "RELATR_PUBKEY", "750682303c9f0ddad75941b49edc9d46e3ed306b9ee3335338a21a3e404c5fa3"),
+		RelatrSecretKey:               getenv("RELATR_SECRET_KEY"),
+		RelatrKeepaliveSeconds:        getEnvInt(getenv, "RELATR_KEEPALIVE_SECONDS", 30),
+		HellthreadThreshold:           getEnvInt(getenv, "HELLTHREAD_THRESHOLD", 0),
+		HellthreadAction:              strings.ToLower(getEnvString(getenv, "HELLTHREAD_ACTION", hellthreadReject)),
+		EntitySpamThreshold:           getEnvInt(getenv, "ENTITY_SPAM_THRESHOLD", 0),
+		EntitySpamAction:              strings.ToLower(getEnvString(getenv, "ENTITY_SPAM_ACTION", entitySpamReject)),
+		EntitySpamPoWDifficulty:       getEnvInt(getenv, "ENTITY_SPAM_POW_DIFFICULTY", 20),
+		DuplicateContentThreshold:     getEnvInt(getenv, "DUPLICATE_CONTENT_THRESHOLD", 0),
+		DuplicateContentWindowMinutes: getEnvInt(getenv, "DUPLICATE_CONTENT_WINDOW_MINUTES", 60),
+		ContentQualityAction:          strings.ToLower(getEnvString(getenv, "CONTENT_QUALITY_ACTION", qualityOff)),
+		ContentQualityTokenCost:       getEnvFloat(getenv, "CONTENT_QUALITY_TOKEN_COST", 10),
+		RepostPolicyEnabled:           getEnvBool(getenv, "REPOST_POLICY_ENABLED", false),
+		OnboardingEnabled:             getEnvBool(getenv, "ONBOARDING_ENABLED", false),
+		OnboardingDailyRates: map[OnboardingStage]float64{
+			StageUnknown:   getEnvFloat(getenv, "ONBOARDING_DAILY_RATE_UNKNOWN", 1),
+			StageProbation: getEnvFloat(getenv, "ONBOARDING_DAILY_RATE_PROBATION", 10),
+			StageMember:    getEnvFloat(getenv, "ONBOARDING_DAILY_RATE_MEMBER", 50),
+		},
+		OnboardingProbationHours: getEnvInt(getenv, "ONBOARDING_PROBATION_HOURS", 24),
+		OnboardingMemberHours:    getEnvInt(getenv, "ONBOARDING_MEMBER_HOURS", 168),
+		OnboardingMemberEvents:   getEnvInt(getenv, "ONBOARDING_MEMBER_EVENTS", 20),
+		BackfillDailyCap:         getEnvInt(getenv, "BACKFILL_DAILY_CAP", 0),
+		BackfillDiscount:         getEnvFloat(getenv, "BACKFILL_DISCOUNT", 0.1),
+		MaxFutureSeconds:         getEnvInt(getenv, "MAX_FUTURE_SECONDS", 86400),
+		ClockSkewSeconds:         getEnvInt(getenv, "CLOCK_SKEW_SECONDS", 0),
+		MaxEventAgeHours: map[Tier]int{
+			TierLow:  getEnvInt(getenv, "MAX_EVENT_AGE_HOURS_LOW", 0),
+			TierMid:  getEnvInt(getenv, "MAX_EVENT_AGE_HOURS_MID", 0),
+			TierHigh: getEnvInt(getenv, "MAX_EVENT_AGE_HOURS_HIGH", 0),
+		},
+		LaunchDays: map[Tier]int{
+			TierLow:  getEnvInt(getenv, "LAUNCH_DAYS_LOW", 0),
+			TierMid:  getEnvInt(getenv, "LAUNCH_DAYS_MID", 0),
+			TierHigh: getEnvInt(getenv, "LAUNCH_DAYS_HIGH", 0),
+		},
+		SizeCostBytes: getEnvInt(getenv, "SIZE_COST_BYTES", 2048),
+		SizeCostMultipliers: map[Tier]float64{
+			TierLow:  getEnvFloat(getenv, "SIZE_COST_MULTIPLIER_LOW", 1),
+			TierMid:  getEnvFloat(getenv, "SIZE_COST_MULTIPLIER_MID", 0.5),
+			TierHigh: getEnvFloat(getenv, "SIZE_COST_MULTIPLIER_HIGH", 0),
+		},
+		RepostDailyCaps: map[Tier]float64{
+			TierLow:  getEnvFloat(getenv, "REPOST_DAILY_CAP_LOW", 5),
+			TierMid:  getEnvFloat(getenv, "REPOST_DAILY_CAP_MID", 50),
+			TierHigh: getEnvFloat(getenv, "REPOST_DAILY_CAP_HIGH", 500),
+		},
+		ZapValidationEnabled: getEnvBool(getenv, "ZAP_VALIDATION_ENABLED", false),
+		ZapVerifyProvider:    getEnvBool(getenv, "ZAP_VERIFY_PROVIDER", true),
+		ZapTrustEnabled:      getEnvBool(getenv, "ZAP_TRUST_ENABLED", false),
+		ZapTrustSats:         getEnvFloat(getenv, "ZAP_TRUST_SATS", 10000),
+		ZapTrustMaxBonus:     getEnvFloat(getenv, "ZAP_TRUST_MAX_BONUS", 0.3),
+		LongformTokenCost:    getEnvFloat(getenv, "LONGFORM_TOKEN_COST", 5),
+		LongformMaxSize:      getEnvInt(getenv, "LONGFORM_MAX_SIZE", 100000),
+		FileAllowedHosts:     getEnvList(getenv, "FILE_ALLOWED_HOSTS"),
+		FileDailyCaps: map[Tier]float64{
+			TierLow:  getEnvFloat(getenv, "FILE_DAILY_CAP_LOW", 2),
+			TierMid:  getEnvFloat(getenv, "FILE_DAILY_CAP_MID", 20),
+			TierHigh: getEnvFloat(getenv, "FILE_DAILY_CAP_HIGH", 200),
+		},
+		FileVerifyHash:             getEnvBool(getenv, "FILE_VERIFY_HASH", false),
+		FileMaxSize:                int64(getEnvInt(getenv, "FILE_MAX_SIZE", 50*1024*1024)),
+		CommunityModerationEnabled: getEnvBool(getenv, "COMMUNITY_MODERATION_ENABLED", false),
+		BannedPubkeys:              getEnvList(getenv, "BANNED_PUBKEYS"),
+		AllowedPubkeys:             getEnvList(getenv, "ALLOWED_PUBKEYS"),
+		AdminToken:                 getenv("ADMIN_TOKEN"),
+		TrustedPeers:               getEnvList(getenv, "TRUSTED_PEERS"),
+		AdminPubkeys:               getEnvList(getenv, "ADMIN_PUBKEYS"),
+		RelayDomain:                getEnvString(getenv, "RELAY_DOMAIN", "relay.example.com"),
+		BanEvasionEnabled:          getEnvBool(getenv, "BAN_EVASION_ENABLED", false),
+		SuspectRateMultiplier:      getEnvFloat(getenv, "SUSPECT_RATE_MULTIPLIER", 0.1),
+		IPGroupDailyRate:           getEnvFloat(getenv, "IP_GROUP_DAILY_RATE", 0),
+		CheckRatePerMinute:         getEnvFloat(getenv, "CHECK_RATE_PER_MINUTE", 30),
+		LatestRatePerMinute:        getEnvFloat(getenv, "LATEST_RATE_PER_MINUTE", 60),
+		HTTPRatePerMinute:          getEnvFloat(getenv, "HTTP_RATE_PER_MINUTE", 120),
+		SuspectPoWDifficulty:       getEnvInt(getenv, "SUSPECT_POW_DIFFICULTY", 0),
+		UnrankedPoWDifficulty:      getEnvInt(getenv, "UNRANKED_POW_DIFFICULTY", 0),
+		ReputationSecretKey:        getEnvString(getenv, "REPUTATION_SECRET_KEY", ""),
+		ReputationSources:          getEnvList(getenv, "REPUTATION_SOURCES"),
+		ReputationThreshold:        getEnvFloat(getenv, "REPUTATION_THRESHOLD", 1),
+		ReputationSchedule:         getEnvString(getenv, "REPUTATION_SCHEDULE", "*/15 * * * *"),
+		BlocklistSources:           getEnvList(getenv, "BLOCKLIST_SOURCES"),
+		BlocklistSchedule:          getEnvString(getenv, "BLOCKLIST_SCHEDULE", "0 * * * *"),
+		PaymentsBackend:            getEnvString(getenv, "PAYMENTS_BACKEND", ""),
+		PaymentsBackendURL:         strings.TrimSuffix(getEnvString(getenv, "PAYMENTS_BACKEND_URL", ""), "/"),
+		PaymentsBackendKey:         getEnvString(getenv, "PAYMENTS_BACKEND_KEY", ""),
+		PaymentsPriceMsats:         getEnvInt(getenv, "PAYMENTS_PRICE_MSATS", 0),
+		PaymentsDays:               getEnvInt(getenv, "PAYMENTS_DAYS", 30),
+		PaymentsRank:               getEnvFloat(getenv, "PAYMENTS_RANK", 1),
+		PaymentsSchedule:           getEnvString(getenv, "PAYMENTS_SCHEDULE", "* * * * *"),
+		FeedbackMode:               strings.ToLower(getEnvString(getenv, "FEEDBACK_MODE", feedbackOff)),
+		FeedbackSecretKey:          getEnvString(getenv, "FEEDBACK_SECRET_KEY", ""),
+		FeedbackCooldownMinutes:    getEnvInt(getenv, "FEEDBACK_COOLDOWN_MINUTES", 60),
+		DigestMode:                 strings.ToLower(getEnvString(getenv, "DIGEST_MODE", digestOff)),
+		DigestSecretKey:            getEnvString(getenv, "DIGEST_SECRET_KEY", ""),
+		DigestSchedule:             getEnvString(getenv, "DIGEST_SCHEDULE", "0 9 * * 1"),
+		AdaptiveEnabled:            getEnvBool(getenv, "ADAPTIVE_ENABLED", false),
+		AdaptiveIntervalSeconds:    getEnvInt(getenv, "ADAPTIVE_INTERVAL_SECONDS", 60),
+		AdaptiveRejectRatio:        getEnvFloat(getenv, "ADAPTIVE_REJECT_RATIO", 0.5),
+		AdaptiveStoreGrowth:        getEnvInt(getenv, "ADAPTIVE_STORE_GROWTH", 0),
+		AdaptiveMinRateFactor:      getEnvFloat(getenv, "ADAPTIVE_MIN_RATE_FACTOR", 0.25),
+		AdaptiveMaxPoW:             getEnvInt(getenv, "ADAPTIVE_MAX_POW", 16),
+		OutboundFrameRate:          getEnvFloat(getenv, "OUTBOUND_FRAME_RATE", 0),
+		OutboundFrameBurst:         getEnvFloat(getenv, "OUTBOUND_FRAME_BURST", 1000),
+		NoticeCoalesceSeconds:      getEnvInt(getenv, "NOTICE_COALESCE_SECONDS", 10),
+		LiveEventRate:              getEnvFloat(getenv, "LIVE_EVENT_RATE", 0),
+		LiveEventBurst:             getEnvFloat(getenv, "LIVE_EVENT_BURST", 20),
+		LiveEventOverflow:          getEnvString(getenv, "LIVE_EVENT_OVERFLOW", liveOverflowDropOldest),
+		LiveEventBuffer:            getEnvInt(getenv, "LIVE_EVENT_BUFFER", 50),
+		MaxConnectionsPerIP:        getEnvInt(getenv, "MAX_CONNECTIONS_PER_IP", 50),
+		MaxConnections:             getEnvInt(getenv, "MAX_CONNECTIONS", 0),
+		ConnectionReserve:          getEnvInt(getenv, "CONNECTION_RESERVE", 0),
+		ReserveAuthSeconds:         getEnvInt(getenv, "CONNECTION_RESERVE_AUTH_SECONDS", 10),
+		EventMaxSize:               getEnvInt(getenv, "EVENT_MAX_SIZE", 400000),
+		EventMaxTags:               getEnvInt(getenv, "EVENT_MAX_TAGS", 5000),
+		EventMaxContentLength:      getEnvInt(getenv, "EVENT_MAX_CONTENT_LENGTH", 200000),
+		ReqMaxFilters:              getEnvInt(getenv, "REQ_MAX_FILTERS", 20),
+		ReqMaxSubscriptions:        getEnvInt(getenv, "REQ_MAX_SUBSCRIPTIONS", 0),
+		ReqFiltersPerMinute:        getEnvFloat(getenv, "REQ_FILTERS_PER_MINUTE", 120),
+		ReqFiltersPerMinuteAuthed:  getEnvFloat(getenv, "REQ_FILTERS_PER_MINUTE_AUTHED", 0),
+		ReqMaxEvents:               getEnvInt(getenv, "REQ_MAX_EVENTS", 0),
+		ReqKeepOpen:                getEnvBool(getenv, "REQ_KEEP_OPEN", true),
+		SubscriptionMaxAgeMinutes:  getEnvInt(getenv, "SUBSCRIPTION_MAX_AGE_MINUTES", 0),
+		ThreadFetchRelays:          getEnvList(getenv, "THREAD_FETCH_RELAYS"),
+		MirrorRelays:               getEnvList(getenv, "MIRROR_RELAYS"),
+		MirrorOutbox:               getEnvBool(getenv, "MIRROR_OUTBOX", false),
+		MirrorMaxRelays:            getEnvInt(getenv, "MIRROR_MAX_RELAYS", 10),
+		DecisionLogFile:            getEnvString(getenv, "DECISION_LOG_FILE", ""),
+		DecisionLogSampleRate:      getEnvFloat(getenv, "DECISION_LOG_SAMPLE_RATE", 1),
+		CandidateFile:              getEnvString(getenv, "CANDIDATE_FILE", ""),
+		CandidateSampleRate:        getEnvFloat(getenv, "CANDIDATE_SAMPLE_RATE", 0.1),
+		HoneypotStorePath:          getEnvString(getenv, "HONEYPOT_STORE_PATH", ""),
+		HoneypotRetentionDays:      getEnvInt(getenv, "HONEYPOT_RETENTION_DAYS", 30),
+		HoneypotMaxEvents:          getEnvInt(getenv, "HONEYPOT_MAX_EVENTS", 100000),
+		StorePath:                  getEnvString(getenv, "STORE_PATH", "./badger"),
+		EventStoreBackend:          getEnvString(getenv, "EVENTSTORE_BACKEND", backendBadger),
+		LMDBPath:                   getEnvString(getenv, "LMDB_PATH", "./lmdb"),
+		SQLitePath:                 getEnvString(getenv, "SQLITE_PATH", "./events.sqlite"),
+		PostgresURL:                getEnvString(getenv, "POSTGRES_URL", ""),
+		IndexWarmup:                getEnvBool(getenv, "INDEX_WARMUP", false),
+		RetentionDays:              getEnvInt(getenv, "RETENTION_DAYS", 0),
+		StoreMaxGB:                 getEnvFloat(getenv, "STORE_MAX_GB", 0),
+		CompactionAgeDays:          getEnvInt(getenv, "COMPACTION_AGE_DAYS", 0),
+		StoreMaxEvents:             getEnvInt(getenv, "STORE_MAX_EVENTS", 0),
+		StoreAlertWebhook:          getEnvString(getenv, "STORE_ALERT_WEBHOOK", ""),
+		PruneSchedule:              getEnvString(getenv, "PRUNE_SCHEDULE", "0 * * * *"),
+		CompactSchedule:            getEnvString(getenv, "COMPACT_SCHEDULE", "30 * * * *"),
+		RankBackfillSchedule:       getEnvString(getenv, "RANK_BACKFILL_SCHEDULE", ""),
+		ReportSchedule:             getEnvString(getenv, "REPORT_SCHEDULE", ""),
+		ReportWebhook:              getEnvString(getenv, "REPORT_WEBHOOK", ""),
+		ListenAddr:                 getEnvString(getenv, "LISTEN_ADDR", "0.0.0.0:3334"),
+		TLSCertFile:                getEnvString(getenv, "TLS_CERT_FILE", ""),
+		TLSKeyFile:                 getEnvString(getenv, "TLS_KEY_FILE", ""),
+		ACMEDomains:                getEnvList(getenv, "ACME_DOMAINS"),
+		ACMEEmail:                  getEnvString(getenv, "ACME_EMAIL", ""),
+		ACMECacheDir:               getEnvString(getenv, "ACME_CACHE_DIR", "./autocert"),
+		SnapshotSchedule:           getEnvString(getenv, "SNAPSHOT_SCHEDULE", ""),
+		SnapshotDir:                getEnvString(getenv, "SNAPSHOT_DIR", "./snapshots"),
+		SnapshotKeep:               getEnvInt(getenv, "SNAPSHOT_KEEP", 7),
+		SnapshotS3Endpoint:         getEnvString(getenv, "SNAPSHOT_S3_ENDPOINT", "https://s3.amazonaws.com"),
+		SnapshotS3Bucket:           getEnvString(getenv, "SNAPSHOT_S3_BUCKET", ""),
+		SnapshotS3Region:           getEnvString(getenv, "SNAPSHOT_S3_REGION", "us-east-1"),
+		SnapshotS3Prefix:           getEnvString(getenv, "SNAPSHOT_S3_PREFIX", ""),
+		SnapshotS3AccessKey:        getEnvString(getenv, "SNAPSHOT_S3_ACCESS_KEY", ""),
+		SnapshotS3SecretKey:        getEnvString(getenv, "SNAPSHOT_S3_SECRET_KEY", ""),
+		ClusterRedisURL:            getEnvString(getenv, "CLUSTER_REDIS_URL", ""),
+		ClusterNodeID:              getEnvString(getenv, "CLUSTER_NODE_ID", hostname()),
+		ConfigFile:                 getEnvString(getenv, "CONFIG_FILE", ".env"),
+		TenantsFile:                getEnvString(getenv, "TENANTS_FILE", ""),
+		Debug:                      getenv("DEBUG") != "",
+		LogFormat:                  strings.ToLower(getEnvString(getenv, "LOG_FORMAT", logText)),
+		MetricsToken:               getenv("METRICS_TOKEN"),
+		ObservabilityLog:           getEnvBool(getenv, "OBSERVABILITY_LOG", getenv("DEBUG") != ""),
+		AccessLog:                  getEnvBool(getenv, "ACCESS_LOG", false),
+		AccessLogSampleRate:        getEnvFloat(getenv, "ACCESS_LOG_SAMPLE_RATE", 1),
+		TracingEndpoint:            getEnvString(getenv, "OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		TracingSampleRate:          getEnvFloat(getenv, "TRACING_SAMPLE_RATE", 1),
 		// NIP-11 Relay Information Document configuration
-		RelayName:        getEnvString("RELAY_NAME", "wotrlay"),
-		RelayDescription: getEnvString("RELAY_DESCRIPTION", "A Web-of-Trust (WoT) based Nostr relay with reputation-driven rate limiting"),
-		RelayPubKey:      getEnvString("RELAY_PUBKEY", ""),
-		RelayContact:     getEnvString("RELAY_CONTACT", ""),
-		Software:         getEnvString("SOFTWARE", "https://github.com/contextvm/wotrlay"),
-		Version:          getEnvString("VERSION", "0.1.0"),
+		RelayName:        getEnvString(getenv, "RELAY_NAME", "wotrlay"),
+		RelayDescription: getEnvString(getenv, "RELAY_DESCRIPTION", "A Web-of-Trust (WoT) based Nostr relay with reputation-driven rate limiting"),
+		RelayPubKey:      getEnvString(getenv, "RELAY_PUBKEY", ""),
+		RelayContact:     getEnvString(getenv, "RELAY_CONTACT", ""),
+		Software:         getEnvString(getenv, "SOFTWARE", "https://github.com/contextvm/wotrlay"),
+		Version:          getEnvString(getenv, "VERSION", "0.1.0"),
+		RelayIcon:        getEnvString(getenv, "RELAY_ICON", ""),
+		RelayBanner:      getEnvString(getenv, "RELAY_BANNER", ""),
+		Favicon:          getEnvString(getenv, "FAVICON", ""),
+		ThemeColor:       getEnvString(getenv, "THEME_COLOR", defaultThemeColor),
+		PaymentsURL:      getEnvString(getenv, "PAYMENTS_URL", ""),
+		PaymentRequired:  getEnvBool(getenv, "PAYMENT_REQUIRED", false),
+		FeesAdmission:    getEnvInt(getenv, "FEES_ADMISSION", 0),
+	}
+
+	if tier, ok := parseTier(getEnvString(getenv, "LONGFORM_MIN_TIER", "mid")); ok {
+		cfg.LongformMinTier = tier
+	} else {
+		return cfg, errors.New("LONGFORM_MIN_TIER must be one of: low, mid, high")
+	}
+	if tier, ok := parseTier(getEnvString(getenv, "FILE_MIN_TIER", "mid")); ok {
+		cfg.FileMinTier = tier
+	} else {
+		return cfg, errors.New("FILE_MIN_TIER must be one of: low, mid, high")
+	}
+	if tier, ok := parseTier(getEnvString(getenv, "MIRROR_MIN_TIER", "high")); ok {
+		cfg.MirrorMinTier = tier
+	} else {
+		return cfg, errors.New("MIRROR_MIN_TIER must be one of: low, mid, high")
+	}
+
+	if cfg.LogFormat != logText && cfg.LogFormat != logJSON {
+		return cfg, errors.New("LOG_FORMAT must be one of: text, json")
+	}
+	defaultLevel := "info"
+	if cfg.Debug {
+		defaultLevel = "debug"
+	}
+	levels, err := parseLogLevels(getEnvString(getenv, "LOG_LEVEL", defaultLevel), getEnvList(getenv, "LOG_LEVELS"))
+	if err != nil {
+		return cfg, err
 	}
+	cfg.LogLevels = levels
 
 	// Validate thresholds
 	if cfg.MidThreshold < 0 || cfg.MidThreshold > 1 {
-		log.Fatal("MID_THRESHOLD must be between 0 and 1")
+		return cfg, errors.New("MID_THRESHOLD must be between 0 and 1")
 	}
 	if cfg.HighThreshold != nil {
 		if *cfg.HighThreshold < 0 || *cfg.HighThreshold > 1 {
-			log.Fatal("HIGH_THRESHOLD must be between 0 and 1")
+			return cfg, errors.New("HIGH_THRESHOLD must be between 0 and 1")
 		}
 		if *cfg.HighThreshold <= cfg.MidThreshold {
-			log.Fatal("HIGH_THRESHOLD must be greater than MID_THRESHOLD")
+			return cfg, errors.New("HIGH_THRESHOLD must be greater than MID_THRESHOLD")
 		}
 	}
 
-	// Generate secret key if not provided
-	if cfg.RelatrSecretKey == "" {
-		cfg.RelatrSecretKey = nostr.GeneratePrivateKey()
-		log.Printf("RELATR_SECRET_KEY not set, generated temporary key for this session")
+	if cfg.HellthreadThreshold < 0 {
+		return cfg, errors.New("HELLTHREAD_THRESHOLD must not be negative")
+	}
+	if cfg.HellthreadAction != hellthreadReject && cfg.HellthreadAction != hellthreadStrip {
+		return cfg, errors.New("HELLTHREAD_ACTION must be one of: reject, strip")
 	}
 
-	return cfg
-}
-
-// getEnvFloat reads a float64 from environment variable with a default value.
-func getEnvFloat(key string, defaultValue float64) float64 {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
-			return parsed
-		}
-		log.Printf("Invalid value for %s: %s, using default: %f", key, value, defaultValue)
+	if cfg.EntitySpamThreshold < 0 {
+		return cfg, errors.New("ENTITY_SPAM_THRESHOLD must not be negative")
+	}
+	if cfg.EntitySpamAction != entitySpamReject && cfg.EntitySpamAction != entitySpamPoW {
+		return cfg, errors.New("ENTITY_SPAM_ACTION must be one of: reject, pow")
+	}
+	if cfg.EntitySpamPoWDifficulty < 0 || cfg.EntitySpamPoWDifficulty > 256 {
+		return cfg, errors.New("ENTITY_SPAM_POW_DIFFICULTY must be between 0 and 256")
 	}
-	return defaultValue
-}
 
-// getEnvString reads a string from environment variable with a default value.
-func getEnvString(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	if cfg.DuplicateContentThreshold < 0 {
+		return cfg, errors.New("DUPLICATE_CONTENT_THRESHOLD must not be negative")
+	}
+	if cfg.DuplicateContentWindowMinutes <= 0 {
+		return cfg, errors.New("DUPLICATE_CONTENT_WINDOW_MINUTES must be positive")
 	}
-	return defaultValue
-}
 
-// getEnvBool reads a boolean from environment variable with a default value.
-// Accepted true values: "true", "1", "yes", "on" (case-insensitive).
-// Accepted false values: "false", "0", "no", "off" (case-insensitive).
-// Any other non-empty value falls back to defaultValue.
-func getEnvBool(key string, defaultValue bool) bool {
-	value := strings.TrimSpace(os.Getenv(key))
-	if value == "" {
-		return defaultValue
+	if cfg.ContentQualityAction != qualityOff && cfg.ContentQualityAction != qualityCost && cfg.ContentQualityAction != qualityReject {
+		return cfg, errors.New("CONTENT_QUALITY_ACTION must be one of: off, cost, reject")
+	}
+	if cfg.ContentQualityTokenCost < 1 {
+		return cfg, errors.New("CONTENT_QUALITY_TOKEN_COST must be at least 1")
 	}
 
-	switch strings.ToLower(value) {
-	case "true", "1", "yes", "on":
-		return true
-	case "false", "0", "no", "off":
-		return false
-	default:
-		log.Printf("Invalid value for %s: %s, using default: %t", key, value, defaultValue)
-		return defaultValue
+	if _, ok := parseHexColor(cfg.ThemeColor); !ok {
+		return cfg, fmt.Errorf("THEME_COLOR must be a #rrggbb color, got %q", cfg.ThemeColor)
 	}
-}
 
-// getEnvInt reads an int from environment variable with a default value.
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if parsed, err := strconv.Atoi(value); err == nil {
+	for tier, maxAge := range cfg.MaxEventAgeHours {
+		if maxAge < 0 {
+			return cfg, fmt.Errorf("MAX_EVENT_AGE_HOURS_%s must not be negative", strings.ToUpper(tier.String()))
+		}
+	}
+	if cfg.MaxFutureSeconds < 0 {
+		return cfg, errors.New("MAX_FUTURE_SECONDS must not be negative")
+	}
+	if cfg.ClockSkewSeconds < 0 {
+		return cfg, errors.New("CLOCK_SKEW_SECONDS must not be negative")
+	}
+	if cfg.BackfillDailyCap < 0 {
+		return cfg, errors.New("BACKFILL_DAILY_CAP must not be negative")
+	}
+	if cfg.BackfillDiscount < 0 || cfg.BackfillDiscount > 1 {
+		return cfg, errors.New("BACKFILL_DISCOUNT must be between 0 and 1")
+	}
+	// Backfill is free for the high tier by default, which only exists with HIGH_THRESHOLD
+	cfg.BackfillPolicies = make(map[Tier]string)
+	for tier := TierLow; tier <= TierHigh; tier++ {
+		policy := backfillNormal
+		if tier == TierHigh && cfg.HighThreshold != nil {
+			policy = backfillFree
+		}
+		key := "BACKFILL_POLICY_" + strings.ToUpper(tier.String())
+		switch policy = strings.ToLower(getEnvString(getenv, key, policy)); policy {
+		case backfillNormal, backfillFree, backfillDiscount, backfillReject:
+			cfg.BackfillPolicies[tier] = policy
+		default:
+			return cfg, fmt.Errorf("%s must be one of: normal, free, discount, reject", key)
+		}
+	}
+	if window := getEnvString(getenv, "WRITE_WINDOW", ""); window != "" {
+		schedule, err := ParseSchedule(window)
+		if err != nil {
+			return cfg, fmt.Errorf("WRITE_WINDOW: %w", err)
+		}
+		cfg.WriteWindow = schedule
+	}
+	if launchAt := getEnvString(getenv, "LAUNCH_AT", ""); launchAt != "" {
+		t, err := time.Parse(time.RFC3339, launchAt)
+		if err != nil {
+			return cfg, fmt.Errorf("LAUNCH_AT must be an RFC 3339 time, e.g. 2025-06-01T18:00:00Z, got %q", launchAt)
+		}
+		cfg.LaunchAt = t
+	}
+	for tier, days := range cfg.LaunchDays {
+		if days < 0 {
+			return cfg, fmt.Errorf("LAUNCH_DAYS_%s must not be negative", strings.ToUpper(tier.String()))
+		}
+	}
+	if cfg.SizeCostBytes < 1 {
+		return cfg, errors.New("SIZE_COST_BYTES must be at least 1")
+	}
+	for tier, multiplier := range cfg.SizeCostMultipliers {
+		if multiplier < 0 {
+			return cfg, fmt.Errorf("SIZE_COST_MULTIPLIER_%s must not be negative", strings.ToUpper(tier.String()))
+		}
+	}
+
+	for stage, rate := range cfg.OnboardingDailyRates {
+		if rate <= 0 {
+			return cfg, fmt.Errorf("ONBOARDING_DAILY_RATE_%s must be positive", strings.ToUpper(stage.String()))
+		}
+	}
+	if cfg.OnboardingProbationHours < 0 || cfg.OnboardingMemberHours < 0 || cfg.OnboardingMemberEvents < 0 {
+		return cfg, errors.New("ONBOARDING_PROBATION_HOURS, ONBOARDING_MEMBER_HOURS and ONBOARDING_MEMBER_EVENTS must not be negative")
+	}
+
+	for tier, dailyCap := range cfg.RepostDailyCaps {
+		if dailyCap < 0 {
+			return cfg, fmt.Errorf("REPOST_DAILY_CAP_%s must not be negative", strings.ToUpper(tier.String()))
+		}
+	}
+
+	if cfg.ZapTrustEnabled {
+		// Only receipts verified on the way in can be trusted as a rank input
+		if !cfg.ZapValidationEnabled {
+			return cfg, errors.New("ZAP_TRUST_ENABLED requires ZAP_VALIDATION_ENABLED")
+		}
+		if cfg.ZapTrustSats <= 0 {
+			return cfg, errors.New("ZAP_TRUST_SATS must be positive")
+		}
+		if cfg.ZapTrustMaxBonus < 0 || cfg.ZapTrustMaxBonus > 1 {
+			return cfg, errors.New("ZAP_TRUST_MAX_BONUS must be between 0 and 1")
+		}
+	}
+
+	if cfg.LongformTokenCost < 1 {
+		return cfg, errors.New("LONGFORM_TOKEN_COST must be at least 1")
+	}
+	if cfg.LongformMaxSize < 0 {
+		return cfg, errors.New("LONGFORM_MAX_SIZE must not be negative")
+	}
+
+	for tier, dailyCap := range cfg.FileDailyCaps {
+		if dailyCap < 0 {
+			return cfg, fmt.Errorf("FILE_DAILY_CAP_%s must not be negative", strings.ToUpper(tier.String()))
+		}
+	}
+	if cfg.FileMaxSize <= 0 {
+		return cfg, errors.New("FILE_MAX_SIZE must be positive")
+	}
+
+	for _, peer := range cfg.TrustedPeers {
+		if !nostr.IsValid32ByteHex(peer) {
+			return cfg, fmt.Errorf("TRUSTED_PEERS must only contain hex pubkeys, got %q", peer)
+		}
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && len(cfg.ACMEDomains) > 0 {
+		return cfg, errors.New("TLS_CERT_FILE and ACME_DOMAINS are mutually exclusive")
+	}
+	if !slices.Contains([]string{backendBadger, backendLMDB, backendSQLite, backendPostgres}, cfg.EventStoreBackend) {
+		return cfg, errors.New("EVENTSTORE_BACKEND must be one of: badger, lmdb, sqlite3, postgresql")
+	}
+	if cfg.EventStoreBackend == backendPostgres && cfg.PostgresURL == "" {
+		return cfg, errors.New("EVENTSTORE_BACKEND=postgresql requires POSTGRES_URL")
+	}
+	// Index warmup and snapshots work on Badger's files
+	if cfg.EventStoreBackend != backendBadger && cfg.IndexWarmup {
+		return cfg, errors.New("INDEX_WARMUP requires EVENTSTORE_BACKEND=badger")
+	}
+	if cfg.EventStoreBackend != backendBadger && cfg.SnapshotSchedule != "" {
+		return cfg, errors.New("SNAPSHOT_SCHEDULE requires EVENTSTORE_BACKEND=badger")
+	}
+	if cfg.SnapshotSchedule != "" {
+		if _, err := ParseSchedule(cfg.SnapshotSchedule); err != nil {
+			return cfg, fmt.Errorf("SNAPSHOT_SCHEDULE: %w", err)
+		}
+	}
+	if cfg.SnapshotKeep < 1 {
+		return cfg, errors.New("SNAPSHOT_KEEP must be at least 1")
+	}
+	if cfg.SnapshotS3Bucket != "" && (cfg.SnapshotS3AccessKey == "" || cfg.SnapshotS3SecretKey == "") {
+		return cfg, errors.New("SNAPSHOT_S3_BUCKET requires SNAPSHOT_S3_ACCESS_KEY and SNAPSHOT_S3_SECRET_KEY")
+	}
+	if len(cfg.TrustedPeers) > 0 && getenv("RELAY_DOMAIN") == "" {
+		return cfg, errors.New("TRUSTED_PEERS requires RELAY_DOMAIN, against which peers authenticate")
+	}
+
+	for _, admin := range cfg.AdminPubkeys {
+		if !nostr.IsValid32ByteHex(admin) {
+			return cfg, fmt.Errorf("ADMIN_PUBKEYS must only contain hex pubkeys, got %q", admin)
+		}
+	}
+	for _, pubkey := range cfg.AllowedPubkeys {
+		if !nostr.IsValid32ByteHex(pubkey) {
+			return cfg, fmt.Errorf("ALLOWED_PUBKEYS must only contain hex pubkeys, got %q", pubkey)
+		}
+	}
+	corsOrigins, err := parseCORSOrigins(getEnvList(getenv, "CORS_ORIGINS"))
+	if err != nil {
+		return cfg, fmt.Errorf("CORS_ORIGINS: %w", err)
+	}
+	if cfg.CORSOrigins = corsOrigins; len(corsOrigins) == 0 {
+		cfg.CORSOrigins = []string{"*"}
+	}
+
+	retentionKinds, err := parseIntPairs(getEnvList(getenv, "RETENTION_KINDS"), "kind:days")
+	if err != nil {
+		return cfg, fmt.Errorf("RETENTION_KINDS: %w", err)
+	}
+	cfg.RetentionKinds = retentionKinds
+
+	if cfg.FeesAdmission < 0 {
+		return cfg, errors.New("FEES_ADMISSION must not be negative")
+	}
+	if cfg.FeesSubscription, err = parseIntPairs(getEnvList(getenv, "FEES_SUBSCRIPTION"), "days:amount"); err != nil {
+		return cfg, fmt.Errorf("FEES_SUBSCRIPTION: %w", err)
+	}
+	if cfg.FeesPublication, err = parseIntPairs(getEnvList(getenv, "FEES_PUBLICATION"), "kind:amount"); err != nil {
+		return cfg, fmt.Errorf("FEES_PUBLICATION: %w", err)
+	}
+	if cfg.PaymentsURL != "" && !isURL(cfg.PaymentsURL) {
+		return cfg, fmt.Errorf("PAYMENTS_URL must be an http(s) URL, got %q", cfg.PaymentsURL)
+	}
+
+	overrides, err := parseRankOverrides(getEnvList(getenv, "RANK_OVERRIDES"))
+	if err != nil {
+		return cfg, fmt.Errorf("RANK_OVERRIDES: %w", err)
+	}
+	cfg.RankOverrides = overrides
+
+	for _, name := range getEnvList(getenv, "SHADOW_BAN_TIERS") {
+		tier, ok := parseTier(name)
+		if !ok {
+			return cfg, errors.New("SHADOW_BAN_TIERS must only contain: low, mid, high")
+		}
+		cfg.ShadowBanTiers = append(cfg.ShadowBanTiers, tier)
+	}
+
+	if cfg.RateMultiplier <= 0 {
+		return cfg, errors.New("RATE_MULTIPLIER must be positive")
+	}
+
+	if cfg.RankProvider != rankProviderRelatr && cfg.RankProvider != rankProviderLocal {
+		return cfg, errors.New("RANK_PROVIDER must be one of: relatr, local")
+	}
+	weights, ok := parseWeights(getEnvList(getenv, "WOT_HOP_WEIGHTS"))
+	if getenv("WOT_HOP_WEIGHTS") == "" {
+		weights, ok = []float64{1, 0.25}, true
+	}
+	if !ok {
+		return cfg, errors.New("WOT_HOP_WEIGHTS must be a comma-separated list of weights between 0 and 1")
+	}
+	cfg.WoTHopWeights = weights
+	if cfg.RankProvider == rankProviderLocal {
+		if len(cfg.WoTSeedRelays) == 0 || len(cfg.WoTSeedPubkeys) == 0 {
+			return cfg, errors.New("RANK_PROVIDER=local requires WOT_SEED_RELAYS and WOT_SEED_PUBKEYS")
+		}
+		for _, seed := range cfg.WoTSeedPubkeys {
+			if !nostr.IsValid32ByteHex(seed) {
+				return cfg, fmt.Errorf("WOT_SEED_PUBKEYS must only contain hex pubkeys, got %q", seed)
+			}
+		}
+		if cfg.WoTRecomputeHours <= 0 {
+			return cfg, errors.New("WOT_RECOMPUTE_HOURS must be positive")
+		}
+	}
+
+	if cfg.DecisionLogSampleRate <= 0 || cfg.DecisionLogSampleRate > 1 {
+		return cfg, errors.New("DECISION_LOG_SAMPLE_RATE must be greater than 0 and at most 1")
+	}
+	if cfg.CandidateSampleRate <= 0 || cfg.CandidateSampleRate > 1 {
+		return cfg, errors.New("CANDIDATE_SAMPLE_RATE must be greater than 0 and at most 1")
+	}
+	if cfg.AccessLogSampleRate <= 0 || cfg.AccessLogSampleRate > 1 {
+		return cfg, errors.New("ACCESS_LOG_SAMPLE_RATE must be greater than 0 and at most 1")
+	}
+	if cfg.TracingSampleRate <= 0 || cfg.TracingSampleRate > 1 {
+		return cfg, errors.New("TRACING_SAMPLE_RATE must be greater than 0 and at most 1")
+	}
+
+	if cfg.HoneypotRetentionDays < 0 {
+		return cfg, errors.New("HONEYPOT_RETENTION_DAYS must not be negative")
+	}
+	if cfg.HoneypotMaxEvents < 0 {
+		return cfg, errors.New("HONEYPOT_MAX_EVENTS must not be negative")
+	}
+
+	if cfg.RetentionDays < 0 {
+		return cfg, errors.New("RETENTION_DAYS must not be negative")
+	}
+	if cfg.StoreMaxGB < 0 {
+		return cfg, errors.New("STORE_MAX_GB must not be negative")
+	}
+	if cfg.CompactionAgeDays < 0 {
+		return cfg, errors.New("COMPACTION_AGE_DAYS must not be negative")
+	}
+	for key, schedule := range map[string]string{
+		"PRUNE_SCHEDULE":         cfg.PruneSchedule,
+		"COMPACT_SCHEDULE":       cfg.CompactSchedule,
+		"RANK_BACKFILL_SCHEDULE": cfg.RankBackfillSchedule,
+		"REPORT_SCHEDULE":        cfg.ReportSchedule,
+	} {
+		if schedule == "" {
+			continue
+		}
+		if _, err := ParseSchedule(schedule); err != nil {
+			return cfg, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if cfg.ReportSchedule != "" && cfg.ReportWebhook == "" {
+		return cfg, errors.New("REPORT_SCHEDULE requires REPORT_WEBHOOK")
+	}
+	if cfg.StoreMaxEvents < 0 {
+		return cfg, errors.New("STORE_MAX_EVENTS must not be negative")
+	}
+
+	if cfg.SuspectRateMultiplier < 0 || cfg.SuspectRateMultiplier > 1 {
+		return cfg, errors.New("SUSPECT_RATE_MULTIPLIER must be between 0 and 1")
+	}
+	if cfg.IPGroupDailyRate < 0 {
+		return cfg, errors.New("IP_GROUP_DAILY_RATE must not be negative")
+	}
+	if cfg.CheckRatePerMinute <= 0 {
+		return cfg, errors.New("CHECK_RATE_PER_MINUTE must be positive")
+	}
+	if cfg.LatestRatePerMinute <= 0 {
+		return cfg, errors.New("LATEST_RATE_PER_MINUTE must be positive")
+	}
+	if cfg.HTTPRatePerMinute < 0 {
+		return cfg, errors.New("HTTP_RATE_PER_MINUTE must not be negative")
+	}
+	if cfg.SuspectPoWDifficulty < 0 || cfg.SuspectPoWDifficulty > 256 {
+		return cfg, errors.New("SUSPECT_POW_DIFFICULTY must be between 0 and 256")
+	}
+	if cfg.UnrankedPoWDifficulty < 0 || cfg.UnrankedPoWDifficulty > 256 {
+		return cfg, errors.New("UNRANKED_POW_DIFFICULTY must be between 0 and 256")
+	}
+
+	peers, err := parseReputationPeers(getEnvList(getenv, "REPUTATION_PEERS"))
+	if err != nil {
+		return cfg, fmt.Errorf("REPUTATION_PEERS: %w", err)
+	}
+	cfg.ReputationPeers = peers
+	if cfg.ReputationSecretKey != "" && !nostr.IsValid32ByteHex(cfg.ReputationSecretKey) {
+		return cfg, errors.New("REPUTATION_SECRET_KEY must be a hex secret key")
+	}
+	if len(cfg.ReputationPeers) > 0 && (cfg.ReputationSecretKey == "" || !cfg.BanEvasionEnabled) {
+		return cfg, errors.New("REPUTATION_PEERS requires REPUTATION_SECRET_KEY and BAN_EVASION_ENABLED=true")
+	}
+	if len(cfg.ReputationSources) > 0 && len(cfg.ReputationPeers) == 0 {
+		return cfg, errors.New("REPUTATION_SOURCES requires REPUTATION_PEERS")
+	}
+	if cfg.ReputationThreshold <= 0 {
+		return cfg, errors.New("REPUTATION_THRESHOLD must be positive")
+	}
+	if cfg.ReputationSchedule != "" {
+		if _, err := ParseSchedule(cfg.ReputationSchedule); err != nil {
+			return cfg, fmt.Errorf("REPUTATION_SCHEDULE: %w", err)
+		}
+	}
+	if cfg.BlocklistSchedule != "" {
+		if _, err := ParseSchedule(cfg.BlocklistSchedule); err != nil {
+			return cfg, fmt.Errorf("BLOCKLIST_SCHEDULE: %w", err)
+		}
+	}
+	if cfg.PaymentsBackend != "" {
+		if cfg.PaymentsBackend != paymentsLNbits && cfg.PaymentsBackend != paymentsLND {
+			return cfg, errors.New("PAYMENTS_BACKEND must be one of: lnbits, lnd")
+		}
+		if !isURL(cfg.PaymentsBackendURL) || cfg.PaymentsBackendKey == "" {
+			return cfg, errors.New("PAYMENTS_BACKEND requires an http(s) PAYMENTS_BACKEND_URL and a PAYMENTS_BACKEND_KEY")
+		}
+		if cfg.PaymentsPriceMsats <= 0 || cfg.PaymentsDays <= 0 {
+			return cfg, errors.New("PAYMENTS_PRICE_MSATS and PAYMENTS_DAYS must be positive")
+		}
+		if cfg.PaymentsRank <= 0 || cfg.PaymentsRank > 1 {
+			return cfg, errors.New("PAYMENTS_RANK must be greater than 0 and at most 1")
+		}
+		if cfg.PaymentsSchedule != "" {
+			if _, err := ParseSchedule(cfg.PaymentsSchedule); err != nil {
+				return cfg, fmt.Errorf("PAYMENTS_SCHEDULE: %w", err)
+			}
+		}
+		// The membership is the relay's subscription fee, unless other fees are advertised
+		if len(cfg.FeesSubscription) == 0 {
+			cfg.FeesSubscription = map[int]int{cfg.PaymentsDays: cfg.PaymentsPriceMsats}
+		}
+	}
+	if !slices.Contains([]string{feedbackOff, feedbackNotice, feedbackDM}, cfg.FeedbackMode) {
+		return cfg, errors.New("FEEDBACK_MODE must be one of: off, notice, dm")
+	}
+	if cfg.FeedbackMode != feedbackOff {
+		if _, err := nostr.GetPublicKey(cfg.FeedbackSecretKey); err != nil || !nostr.IsValid32ByteHex(cfg.FeedbackSecretKey) {
+			return cfg, errors.New("FEEDBACK_MODE requires FEEDBACK_SECRET_KEY, a hex secret key")
+		}
+	}
+	if cfg.FeedbackCooldownMinutes < 0 {
+		return cfg, errors.New("FEEDBACK_COOLDOWN_MINUTES must not be negative")
+	}
+	if !slices.Contains([]string{digestOff, digestNote, digestDM}, cfg.DigestMode) {
+		return cfg, errors.New("DIGEST_MODE must be one of: off, note, dm")
+	}
+	if cfg.DigestMode != digestOff {
+		if _, err := nostr.GetPublicKey(cfg.DigestSecretKey); err != nil || !nostr.IsValid32ByteHex(cfg.DigestSecretKey) {
+			return cfg, errors.New("DIGEST_MODE requires DIGEST_SECRET_KEY, a hex secret key")
+		}
+		if cfg.DigestMode == digestDM && len(cfg.AdminPubkeys) == 0 {
+			return cfg, errors.New("DIGEST_MODE=dm requires ADMIN_PUBKEYS")
+		}
+		if _, err := ParseSchedule(cfg.DigestSchedule); err != nil {
+			return cfg, fmt.Errorf("DIGEST_SCHEDULE: %w", err)
+		}
+	}
+	if cfg.AdaptiveIntervalSeconds <= 0 {
+		return cfg, errors.New("ADAPTIVE_INTERVAL_SECONDS must be positive")
+	}
+	if cfg.AdaptiveRejectRatio <= 0 || cfg.AdaptiveRejectRatio > 1 {
+		return cfg, errors.New("ADAPTIVE_REJECT_RATIO must be greater than 0 and at most 1")
+	}
+	if cfg.AdaptiveStoreGrowth < 0 {
+		return cfg, errors.New("ADAPTIVE_STORE_GROWTH must not be negative")
+	}
+	if cfg.AdaptiveMinRateFactor <= 0 || cfg.AdaptiveMinRateFactor > 1 {
+		return cfg, errors.New("ADAPTIVE_MIN_RATE_FACTOR must be greater than 0 and at most 1")
+	}
+	if cfg.AdaptiveMaxPoW < 0 || cfg.AdaptiveMaxPoW > 256 {
+		return cfg, errors.New("ADAPTIVE_MAX_POW must be between 0 and 256")
+	}
+
+	if cfg.OutboundFrameRate < 0 {
+		return cfg, errors.New("OUTBOUND_FRAME_RATE must not be negative")
+	}
+	if cfg.OutboundFrameBurst < 1 {
+		return cfg, errors.New("OUTBOUND_FRAME_BURST must be at least 1")
+	}
+	if cfg.RelatrKeepaliveSeconds < 0 {
+		return cfg, errors.New("RELATR_KEEPALIVE_SECONDS must not be negative")
+	}
+	if cfg.RankCacheSize < 1 {
+		return cfg, errors.New("RANK_CACHE_SIZE must be at least 1")
+	}
+	if cfg.RankRefreshQueueSize < 1 {
+		return cfg, errors.New("RANK_REFRESH_QUEUE_SIZE must be at least 1")
+	}
+	if cfg.RankPrefetchAuthors < 0 {
+		return cfg, errors.New("RANK_PREFETCH_AUTHORS must not be negative")
+	}
+	if !slices.Contains([]string{refreshOverflowDrop, refreshOverflowDropOldest, refreshOverflowSpill}, cfg.RankRefreshOverflow) {
+		return cfg, errors.New("RANK_REFRESH_OVERFLOW must be one of: drop, drop-oldest, spill")
+	}
+	if cfg.RankGraceMinutes < 0 {
+		return cfg, errors.New("RANK_GRACE_MINUTES must not be negative")
+	}
+
+	if cfg.NoticeCoalesceSeconds < 0 {
+		return cfg, errors.New("NOTICE_COALESCE_SECONDS must not be negative")
+	}
+	if cfg.LiveEventRate < 0 {
+		return cfg, errors.New("LIVE_EVENT_RATE must not be negative")
+	}
+	if cfg.LiveEventBurst < 1 {
+		return cfg, errors.New("LIVE_EVENT_BURST must be at least 1")
+	}
+	if !slices.Contains([]string{liveOverflowDropOldest, liveOverflowCoalesce}, cfg.LiveEventOverflow) {
+		return cfg, errors.New("LIVE_EVENT_OVERFLOW must be one of: drop-oldest, coalesce")
+	}
+	if cfg.LiveEventBuffer < 1 {
+		return cfg, errors.New("LIVE_EVENT_BUFFER must be at least 1")
+	}
+
+	if cfg.MaxConnectionsPerIP < 0 {
+		return cfg, errors.New("MAX_CONNECTIONS_PER_IP must not be negative")
+	}
+	if cfg.MaxConnections < 0 {
+		return cfg, errors.New("MAX_CONNECTIONS must not be negative")
+	}
+	if cfg.ConnectionReserve < 0 || (cfg.ConnectionReserve > 0 && cfg.ConnectionReserve >= cfg.MaxConnections) {
+		return cfg, errors.New("CONNECTION_RESERVE must not be negative, and requires a larger MAX_CONNECTIONS")
+	}
+	if cfg.ReserveAuthSeconds <= 0 {
+		return cfg, errors.New("CONNECTION_RESERVE_AUTH_SECONDS must be positive")
+	}
+	if cfg.EventMaxSize < 0 {
+		return cfg, errors.New("EVENT_MAX_SIZE must not be negative")
+	}
+	if cfg.EventMaxTags < 0 {
+		return cfg, errors.New("EVENT_MAX_TAGS must not be negative")
+	}
+	if cfg.EventMaxContentLength < 0 {
+		return cfg, errors.New("EVENT_MAX_CONTENT_LENGTH must not be negative")
+	}
+	if cfg.ReqMaxFilters < 0 {
+		return cfg, errors.New("REQ_MAX_FILTERS must not be negative")
+	}
+	if cfg.ReqMaxSubscriptions < 0 {
+		return cfg, errors.New("REQ_MAX_SUBSCRIPTIONS must not be negative")
+	}
+	if cfg.ReqFiltersPerMinute < 0 {
+		return cfg, errors.New("REQ_FILTERS_PER_MINUTE must not be negative")
+	}
+	if cfg.ReqFiltersPerMinuteAuthed < 0 {
+		return cfg, errors.New("REQ_FILTERS_PER_MINUTE_AUTHED must not be negative")
+	}
+	if cfg.ReqMaxEvents < 0 {
+		return cfg, errors.New("REQ_MAX_EVENTS must not be negative")
+	}
+	if cfg.SubscriptionMaxAgeMinutes < 0 {
+		return cfg, errors.New("SUBSCRIPTION_MAX_AGE_MINUTES must not be negative")
+	}
+	if cfg.MirrorMaxRelays < 1 {
+		return cfg, errors.New("MIRROR_MAX_RELAYS must be at least 1")
+	}
+
+	return cfg, nil
+}
+
+// hostname returns the host name of the machine, or "wotrlay" if unknown.
+func hostname() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "wotrlay"
+}
+
+// getEnvFloat reads a float64 from environment variable with a default value.
+func getEnvFloat(getenv func(string) string, key string, defaultValue float64) float64 {
+	if value := getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
 			return parsed
 		}
-		log.Printf("Invalid value for %s: %s, using default: %d", key, value, defaultValue)
+		relayLog.Warn("invalid value, using default", "key", key, "value", value, "default", defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvString reads a string from environment variable with a default value.
+func getEnvString(getenv func(string) string, key, defaultValue string) string {
+	if value := getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list from environment variable.
+// Empty items are skipped; an unset variable yields nil.
+func getEnvList(getenv func(string) string, key string) []string {
+	value := getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIntPairs parses pairs of non-negative integers, in the format like "kind:days".
+func parseIntPairs(items []string, format string) (map[int]int, error) {
+	pairs := make(map[int]int, len(items))
+	for _, item := range items {
+		key, value, _ := strings.Cut(item, ":")
+		k, err := strconv.Atoi(key)
+		v, err2 := strconv.Atoi(value)
+		if err != nil || err2 != nil || k < 0 || v < 0 {
+			return nil, fmt.Errorf("expected %s pairs of non-negative integers, got %q", format, item)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
+
+// getEnvKinds reads a set of event kinds (see ParseKindSet) from environment variable with a default value.
+func getEnvKinds(getenv func(string) string, key string, defaultValue string) KindSet {
+	if value := getenv(key); value != "" {
+		kinds, err := ParseKindSet(value)
+		if err == nil {
+			return kinds
+		}
+		relayLog.Warn("invalid value, using default", "key", key, "value", value, "default", defaultValue)
+	}
+	kinds, _ := ParseKindSet(defaultValue)
+	return kinds
+}
+
+// getEnvBool reads a boolean from environment variable with a default value.
+// Accepted true values: "true", "1", "yes", "on" (case-insensitive).
+// Accepted false values: "false", "0", "no", "off" (case-insensitive).
+// Any other non-empty value falls back to defaultValue.
+func getEnvBool(getenv func(string) string, key string, defaultValue bool) bool {
+	value := strings.TrimSpace(getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "1", "yes", "on":
+		return true
+	case "false", "0", "no", "off":
+		return false
+	default:
+		relayLog.Warn("invalid value, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+}
+
+// getEnvInt reads an int from environment variable with a default value.
+func getEnvInt(getenv func(string) string, key string, defaultValue int) int {
+	if value := getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+		relayLog.Warn("invalid value, using default", "key", key, "value", value, "default", defaultValue)
 	}
 	return defaultValue
 }
@@ -223,6 +1827,12 @@ func getEnvInt(key string, defaultValue int) int {
 func createRelayInfoDocument(cfg Config) nip11.RelayInformationDocument {
 	// Build supported NIPs list
 	supportedNIPs := []any{1, 11} // Always support NIP-01 and NIP-11
+	if cfg.CompactionAgeDays > 0 {
+		supportedNIPs = append(supportedNIPs, 45)
+	}
+	if len(cfg.AdminPubkeys) > 0 {
+		supportedNIPs = append(supportedNIPs, 86)
+	}
 
 	// Create the relay information document
 	info := nip11.RelayInformationDocument{
@@ -233,17 +1843,238 @@ func createRelayInfoDocument(cfg Config) nip11.RelayInformationDocument {
 		SupportedNIPs: supportedNIPs,
 		Software:      cfg.Software,
 		Version:       cfg.Version,
+		PaymentsURL:   cfg.PaymentsURL,
+	}
+
+	// Hosted images get their URL from the request the document is served to
+	if isURL(cfg.RelayIcon) {
+		info.Icon = cfg.RelayIcon
+	}
+	if isURL(cfg.RelayBanner) {
+		info.Banner = cfg.RelayBanner
 	}
 
 	return info
 }
 
+// relayLimitation holds the NIP-11 limitations missing from nip11.RelayLimitationDocument.
+type relayLimitation struct {
+	MaxMessageLength    int   `json:"max_message_length"`
+	MaxFilters          int   `json:"max_filters,omitempty"`
+	MaxSubscriptions    int   `json:"max_subscriptions,omitempty"`
+	MaxEventTags        int   `json:"max_event_tags,omitempty"`
+	MaxContentLength    int   `json:"max_content_length,omitempty"`
+	CreatedAtLowerLimit int64 `json:"created_at_lower_limit,omitempty"`
+	CreatedAtUpperLimit int64 `json:"created_at_upper_limit"`
+	AuthRequired        bool  `json:"auth_required"`
+	PaymentRequired     bool  `json:"payment_required"`
+	RestrictedWrites    bool  `json:"restricted_writes"`
+}
+
+// relayFee is a NIP-11 fee, in msats.
+type relayFee struct {
+	Kinds  []int  `json:"kinds,omitempty"`
+	Amount int    `json:"amount"`
+	Unit   string `json:"unit"`
+	Period int    `json:"period,omitempty"` // seconds between subscription payments
+}
+
+// relayFees holds the NIP-11 fees, which nip11.RelayFeesDocument can't build from config.
+type relayFees struct {
+	Admission    []relayFee `json:"admission,omitempty"`
+	Subscription []relayFee `json:"subscription,omitempty"`
+	Publication  []relayFee `json:"publication,omitempty"`
+}
+
+// relayRetention is a NIP-11 retention entry. Unlike nip11.RelayRetentionDocument,
+// it can tell that events are kept forever, with a null Time.
+type relayRetention struct {
+	Kinds []int  `json:"kinds,omitempty"`
+	Time  *int64 `json:"time"`
+	Count int    `json:"count,omitempty"`
+}
+
+// relayInformation is a NIP-11 document extended with relayLimitation, relayFees and relayRetention.
+type relayInformation struct {
+	nip11.RelayInformationDocument
+	Limitation *relayLimitation `json:"limitation,omitempty"`
+	Fees       *relayFees       `json:"fees,omitempty"`
+	Retention  []relayRetention `json:"retention,omitempty"`
+}
+
+// marshalRelayInfo returns the NIP-11 document of the relay, with its limitations,
+// fees and retention.
+func marshalRelayInfo(cfg Config, info nip11.RelayInformationDocument) []byte {
+	// Writes are always restricted, as they depend on the author's trust score.
+	// Authentication is only ever requested from peer relays.
+	fees := relayFeesOf(cfg)
+	doc := relayInformation{
+		RelayInformationDocument: info,
+		Limitation: &relayLimitation{
+			MaxMessageLength:    maxMessageSize,
+			MaxFilters:          cfg.ReqMaxFilters,
+			MaxSubscriptions:    cfg.ReqMaxSubscriptions,
+			MaxEventTags:        cfg.EventMaxTags,
+			MaxContentLength:    cfg.EventMaxContentLength,
+			CreatedAtUpperLimit: int64(cfg.MaxFutureSeconds + cfg.ClockSkewSeconds),
+			PaymentRequired:     cfg.PaymentRequired,
+			RestrictedWrites:    true,
+		},
+		Fees:      fees,
+		Retention: relayRetentionOf(cfg),
+	}
+	// An EVENT message with a larger event is rejected anyway
+	if cfg.EventMaxSize > 0 {
+		doc.Limitation.MaxMessageLength = min(cfg.EventMaxSize+eventEnvelopeSize, maxMessageSize)
+	}
+	// Only an age limit every tier has applies to all events
+	for _, hours := range cfg.MaxEventAgeHours {
+		if hours <= 0 {
+			doc.Limitation.CreatedAtLowerLimit = 0
+			break
+		}
+		doc.Limitation.CreatedAtLowerLimit = max(doc.Limitation.CreatedAtLowerLimit, int64(hours)*3600)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		log.Fatalf("failed to marshal NIP-11 document: %v", err)
+	}
+	return data
+}
+
+// relayFeesOf returns the fees of the config, or nil if the relay is free. Fees alone
+// don't make payments required: that is advertised with PaymentRequired.
+func relayFeesOf(cfg Config) *relayFees {
+	if cfg.FeesAdmission == 0 && len(cfg.FeesSubscription) == 0 && len(cfg.FeesPublication) == 0 {
+		return nil
+	}
+
+	fees := &relayFees{}
+	if cfg.FeesAdmission > 0 {
+		fees.Admission = []relayFee{{Amount: cfg.FeesAdmission, Unit: "msats"}}
+	}
+	for _, days := range slices.Sorted(maps.Keys(cfg.FeesSubscription)) {
+		fees.Subscription = append(fees.Subscription, relayFee{Amount: cfg.FeesSubscription[days], Unit: "msats", Period: days * 86400})
+	}
+
+	// Kinds with the same fee share an entry
+	byAmount := make(map[int][]int)
+	for kind, amount := range cfg.FeesPublication {
+		byAmount[amount] = append(byAmount[amount], kind)
+	}
+	for _, amount := range slices.Sorted(maps.Keys(byAmount)) {
+		fees.Publication = append(fees.Publication, relayFee{Kinds: slices.Sorted(slices.Values(byAmount[amount])), Amount: amount, Unit: "msats"})
+	}
+	return fees
+}
+
+// relayRetentionOf returns the retention of the config: an entry for the kinds with
+// their own retention, exempt kinds included, then one for all other events.
+// It's nil if events are kept forever and there's no quota.
+func relayRetentionOf(cfg Config) []relayRetention {
+	if cfg.RetentionDays == 0 && len(cfg.RetentionKinds) == 0 && cfg.StoreMaxEvents == 0 {
+		return nil
+	}
+
+	// Kinds with the same retention share an entry, 0 days being forever
+	byDays := make(map[int][]int)
+	for kind, days := range cfg.RetentionKinds {
+		byDays[days] = append(byDays[days], kind)
+	}
+	if cfg.RetentionDays > 0 {
+		for kind := range exemptKinds {
+			if _, ok := cfg.RetentionKinds[kind]; !ok {
+				byDays[0] = append(byDays[0], kind)
+			}
+		}
+	}
+
+	var retention []relayRetention
+	for _, days := range slices.Sorted(maps.Keys(byDays)) {
+		retention = append(retention, relayRetention{Kinds: slices.Sorted(slices.Values(byDays[days])), Time: retentionTime(days)})
+	}
+	return append(retention, relayRetention{Time: retentionTime(cfg.RetentionDays), Count: cfg.StoreMaxEvents})
+}
+
+// retentionTime returns the NIP-11 retention time of events kept for days, nil for forever.
+func retentionTime(days int) *int64 {
+	if days <= 0 {
+		return nil
+	}
+	seconds := int64(days) * 86400
+	return &seconds
+}
+
+// relayInfoPath is where each relay also serves its NIP-11 document, under its root path,
+// for clients and tools that can't set an Accept header.
+const relayInfoPath = ".well-known/nostr-relay.json"
+
+// wantsRelayInfo reports whether the Accept header prefers the NIP-11 document to the HTML page.
+// application/nostr+json must be listed with a non-zero quality, at least as high as the one
+// text/html gets (from text/html, text/* or */*), e.g. "application/nostr+json, */*;q=0.8".
+func wantsRelayInfo(accept string) bool {
+	nostrQ, htmlQ := 0.0, 0.0
+	htmlSpecificity := -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v >= 0 && v <= 1 {
+					q = v
+				}
+			}
+		}
+
+		// The most specific range matching text/html sets its quality
+		specificity := -1
+		switch mediaType {
+		case "application/nostr+json":
+			nostrQ = max(nostrQ, q)
+		case "text/html":
+			specificity = 2
+		case "text/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		}
+		if specificity > htmlSpecificity {
+			htmlQ, htmlSpecificity = q, specificity
+		}
+	}
+	return nostrQ > 0 && nostrQ >= htmlQ
+}
+
 func main() {
-	// Log version information
-	log.Printf("Starting wotrlay relay v%s (commit: %s, built: %s)", Version, Commit, BuildTime)
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve implements `wotrlay serve`, running the relay until it's interrupted, and returns
+// the process exit code.
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay [serve]")
+		fmt.Fprintln(flags.Output(), "\nRuns the relay, configured by the environment and CONFIG_FILE, until SIGINT or SIGTERM.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
 
 	// Load configuration
 	cfg := loadConfig()
+	setupLogging(cfg)
+
+	// Log version information
+	relayLog.Info("starting wotrlay relay", "version", Version, "commit", Commit, "built", BuildTime)
 
 	// Initialize observability metrics
 	obs := &Observability{}
@@ -252,19 +2083,230 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	// Initialize dependencies with configuration
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Initialize dependencies shared by all virtual relays
 	cache := NewRankCache(ctx, cfg, obs)
-	limiter := NewLimiter(ctx)
+	media := NewMediaPolicy(ctx)
+	connections := NewConnLimiter(obs, cfg.MaxConnectionsPerIP, cfg.MaxConnections)
+	connections.Reserve, connections.AuthGrace = cfg.ConnectionReserve, time.Duration(cfg.ReserveAuthSeconds)*time.Second
 
-	// Initialize Badger event store backend
-	db := badger.BadgerBackend{Path: "./badger"}
-	if err := db.Init(); err != nil {
-		log.Fatalf("failed to initialize badger backend: %v", err)
+	// The local rank provider replaces Relatr with ranks computed from the follow graph
+	if cfg.RankProvider == rankProviderLocal {
+		cache.UseFollowGraph(ctx, NewFollowGraph(ctx, cfg))
 	}
-	defer db.Close()
 
-	// Start periodic observability logging if debug is enabled
-	if cfg.Debug {
+	// In cluster mode, token buckets and ranks are shared with the other nodes through Redis
+	var cluster *Cluster
+	var globalLimiter RateLimiter = obs.TrackLimiter(NewLimiter(ctx))
+	newLimiter := func(string) RateLimiter { return obs.TrackLimiter(NewLimiter(ctx)) }
+	if cfg.ClusterRedisURL != "" {
+		var err error
+		if cluster, err = NewCluster(ctx, cfg.ClusterRedisURL, cfg.ClusterNodeID); err != nil {
+			log.Fatalf("failed to join cluster: %v", err)
+		}
+		defer cluster.Close()
+		relayLog.Info("running in cluster mode", "node", cfg.ClusterNodeID)
+
+		go cluster.Campaign(ctx)
+		go cluster.PushMetrics(ctx, obs)
+		cache.Share(cluster)
+		go cluster.SyncRanks(ctx, cache)
+		globalLimiter = cluster.Limiter(ctx, "global")
+		newLimiter = func(name string) RateLimiter { return cluster.Limiter(ctx, name) }
+	}
+
+	// Decision records of all (virtual) relays go to the same file
+	var decisions *DecisionLog
+	if cfg.DecisionLogFile != "" {
+		var err error
+		if decisions, err = NewDecisionLog(cfg.DecisionLogFile, cfg.DecisionLogSampleRate, obs); err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer decisions.Close()
+	}
+
+	// Snapshots of all (virtual) relays follow the schedule of the default relay
+	snapshotter, err := NewSnapshotter(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Maintenance jobs run on their schedules, relay jobs following the schedules of their relay
+	jobs := NewScheduler()
+	addJob := func(relay, name, schedule string, run func(context.Context) error) {
+		if schedule == "" {
+			return
+		}
+		if err := jobs.Add(relay, name, schedule, run); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var allDeps []*Deps
+
+	// Each (virtual) relay has its own event store, and Badger store for its own records
+	var dbs []EventStore
+	defer func() {
+		// The refresher persists its queue to the default store when it stops
+		cancel()
+		cache.Wait()
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+
+	// newDeps initializes the per-relay dependencies of a (virtual) relay
+	newDeps := func(name string, cfg Config) *Deps {
+		db, meta, err := openStores(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbs = append(dbs, db)
+		if db != EventStore(meta) {
+			dbs = append(dbs, meta)
+		}
+		if err := migrateStore(meta, eventStoreMigrations); err != nil {
+			log.Fatal(err)
+		}
+		// Snapshots and index warmup are limited to the badger backend by the config validation
+		if snapshotter != nil {
+			snapshotter.Add(name, meta)
+		}
+		if cfg.IndexWarmup {
+			go warmIndexes(ctx, meta)
+		}
+
+		// Spam samples are kept in their own store, which is never queried
+		var honeypot *Honeypot
+		if cfg.HoneypotStorePath != "" {
+			honeypotDB := &badger.BadgerBackend{Path: cfg.HoneypotStorePath}
+			if err := honeypotDB.Init(); err != nil {
+				log.Fatalf("failed to initialize badger backend at %s: %v", cfg.HoneypotStorePath, err)
+			}
+			dbs = append(dbs, honeypotDB)
+			if err := migrateStore(honeypotDB, honeypotMigrations); err != nil {
+				log.Fatal(err)
+			}
+			honeypot = NewHoneypot(ctx, honeypotDB, time.Duration(cfg.HoneypotRetentionDays)*24*time.Hour, int64(cfg.HoneypotMaxEvents), obs)
+		}
+
+		var adaptive *Adaptive
+		if cfg.AdaptiveEnabled {
+			adaptive = NewAdaptive(ctx, name, cfg, obs)
+		}
+
+		var onboarding *Onboarding
+		if cfg.OnboardingEnabled {
+			onboarding = NewOnboarding(meta, cfg, obs)
+		}
+
+		d := &Deps{
+			Name:          name,
+			Cache:         cache,
+			Limiter:       newLimiter(name),
+			GlobalLimiter: globalLimiter,
+			Cluster:       cluster,
+			DB:            db,
+			Meta:          meta,
+			Obs:           obs,
+			Retention:     NewRetention(ctx, db, time.Duration(cfg.RetentionDays)*24*time.Hour, int64(cfg.StoreMaxEvents)),
+			StoreHealth:   NewStoreHealth(name, cfg.StoreAlertWebhook, obs),
+			Linkage:       NewIPLinkage(ctx, cfg.BannedPubkeys),
+			Dedup:         NewContentDedup(ctx, time.Duration(cfg.DuplicateContentWindowMinutes)*time.Minute),
+			Zaps:          NewZapValidator(ctx, cfg.ZapVerifyProvider),
+			ZapTrust:      NewZapTrust(),
+			Latest:        NewLatestSeen(),
+			Media:         media,
+			Decisions:     decisions,
+			Honeypot:      honeypot,
+			Adaptive:      adaptive,
+			Flags:         NewFeatureFlags(cfg),
+			URLs:          NewURLDetector(cfg.URLTLDValidation, cfg.URLExtraTLDs),
+			Management:    NewManagement(),
+			Live:          NewLiveFeed(obs),
+			Onboarding:    onboarding,
+			Connections:   connections,
+			Queries:       NewQueryStats(),
+			Backfill:      NewBackfill(cfg.BackfillDailyCap),
+			Prefetch:      NewRankPrefetch(cache, cfg.RankPrefetchAuthors),
+			Feedback:      NewFeedback(cfg),
+			Jobs:          jobs,
+		}
+		d.Retention.Ledger = NewStorageLedger(func(pubkey string) Tier {
+			rank, _ := cache.Peek(pubkey)
+			return tierFor(rank, d.config(cfg))
+		})
+		for kind, days := range cfg.RetentionKinds {
+			if d.Retention.KindMaxAge == nil {
+				d.Retention.KindMaxAge = make(map[int]time.Duration)
+			}
+			d.Retention.KindMaxAge[kind] = time.Duration(days) * 24 * time.Hour
+		}
+		if cfg.StoreMaxGB > 0 {
+			d.Retention.MaxBytes = int64(cfg.StoreMaxGB * 1e9)
+			d.Retention.Size = func() (int64, error) { return storeSize(cfg, db) }
+		}
+		if len(cfg.ThreadFetchRelays) > 0 {
+			d.Threads = NewThreadFetcher(ctx, cfg, d)
+		}
+		if len(cfg.MirrorRelays) > 0 || cfg.MirrorOutbox {
+			d.Mirror = NewMirror(ctx, d)
+		}
+		if cfg.CompactionAgeDays > 0 {
+			d.Compaction = NewCompaction(db, meta, d.Retention, obs, time.Duration(cfg.CompactionAgeDays)*24*time.Hour)
+			addJob(name, jobCompact, cfg.CompactSchedule, compactJob(d.Compaction))
+		}
+		if cfg.RetentionDays > 0 || len(cfg.RetentionKinds) > 0 || cfg.StoreMaxGB > 0 {
+			addJob(name, jobPrune, cfg.PruneSchedule, pruneJob(d.Retention, "events"))
+		}
+		if honeypot != nil && cfg.HoneypotRetentionDays > 0 {
+			addJob(name, jobPrune, cfg.PruneSchedule, pruneJob(honeypot.retention, "honeypot"))
+		}
+		addJob(name, jobRankBackfill, cfg.RankBackfillSchedule, rankBackfillJob(cache, db))
+		if cfg.PaymentsBackend != "" {
+			d.Payments = NewPayments(meta, cfg, obs)
+			addJob(name, jobPayments, cfg.PaymentsSchedule, func(ctx context.Context) error {
+				return d.Payments.VerifyPending(ctx, d.now())
+			})
+		}
+		if cfg.DigestMode != digestOff {
+			d.Digest = NewDigest(meta, cfg)
+			addJob(name, jobDigest, cfg.DigestSchedule, func(ctx context.Context) error {
+				return d.Digest.Send(ctx, d.now(), d.config(cfg), d)
+			})
+		}
+		if len(cfg.ReputationPeers) > 0 {
+			d.Reputation = NewReputation(d.Linkage, cfg)
+			if len(cfg.ReputationSources) > 0 {
+				addJob(name, jobReputation, cfg.ReputationSchedule, d.Reputation.Sync)
+				go jobs.RunNow(ctx, name, jobReputation)
+			}
+		}
+		if len(cfg.BlocklistSources) > 0 {
+			d.Blocklist = NewBlocklist(cfg)
+			addJob(name, jobBlocklist, cfg.BlocklistSchedule, func(ctx context.Context) error {
+				return d.Blocklist.Sync(ctx, d)
+			})
+			go jobs.RunNow(ctx, name, jobBlocklist)
+		}
+		allDeps = append(allDeps, d)
+
+		// Store the events other nodes accepted for this relay, and stream them to open subscriptions
+		if cluster != nil {
+			go cluster.SyncEvents(ctx, name, func(e *nostr.Event) {
+				if err := store(ctx, e, d); err == nil {
+					d.Live.Stream(e)
+				}
+			})
+		}
+		return d
+	}
+
+	// Start periodic observability logging if enabled
+	if cfg.ObservabilityLog {
 		go func() {
 			ticker := time.NewTicker(30 * time.Minute)
 			defer ticker.Stop()
@@ -273,164 +2315,850 @@ func main() {
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					logObservability(obs)
+					logObservability(ctx, obs, cluster)
 				}
 			}
 		}()
 	}
 
-	// Create NIP-11 relay information document
-	relayInfo := createRelayInfoDocument(cfg)
+	// The default relay serves every request not routed to a virtual relay
+	deps := newDeps(defaultRelayName, cfg)
+	deps.Settings = NewSettings(cfg, nil, os.Getenv, func(changes map[string]string) error {
+		return persistEnvFile(cfg.ConfigFile, changes)
+	})
+	if deps.Candidate, err = NewCandidate(ctx, cfg, os.Getenv, deps); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.RankRefreshOverflow == refreshOverflowSpill || cfg.RankRefreshPersist {
+		cache.Spill(NewRefreshSpill(deps.Meta))
+	}
+	relay, handler := newRelay(ctx, cfg, deps, "/")
+	relays := []*rely.Relay{relay}
+
+	// Create a custom handler that routes requests appropriately
+	router := http.NewServeMux()
+
+	// Serve favicon
+	router.HandleFunc("/favicon.ico", serveFavicon(cfg))
+
+	// Serve the observability counters to Prometheus
+	router.Handle("/metrics", withCORS(cfg.CORSOrigins, metricsHandler(obs, cfg.MetricsToken)))
+
+	if cfg.TenantsFile == "" {
+		router.Handle("/", handler)
+	} else {
+		specs, err := loadTenantSpecs(cfg.TenantsFile)
+		if err != nil {
+			log.Fatalf("failed to load tenants: %v", err)
+		}
+
+		tenants := NewTenantRouter(handler)
+		storePaths := map[string]bool{filepath.Clean(cfg.StorePath): true, eventStoreLocation(cfg): true}
+		if cfg.HoneypotStorePath != "" {
+			storePaths[filepath.Clean(cfg.HoneypotStorePath)] = true
+		}
+		for _, spec := range specs {
+			relayLog.Info("loading virtual relay", "relay", spec.Name)
+			tenantCfg := parseConfig(tenantEnv(spec.Env, os.Getenv))
+			// The rank provider connection is shared, so its settings come from the default relay
+			tenantCfg.RelatrRelay, tenantCfg.RelatrPubkey, tenantCfg.RelatrSecretKey = cfg.RelatrRelay, cfg.RelatrPubkey, cfg.RelatrSecretKey
+			// Virtual relays never share a store: without their own STORE_PATH they get one under ./tenants
+			if _, ok := spec.Env["STORE_PATH"]; !ok {
+				tenantCfg.StorePath = filepath.Join("tenants", spec.Name)
+			}
+			if storePaths[filepath.Clean(tenantCfg.StorePath)] {
+				log.Fatalf("virtual relay %q shares its STORE_PATH with another relay", spec.Name)
+			}
+			storePaths[filepath.Clean(tenantCfg.StorePath)] = true
+
+			// And with the other backends, their event stores are under ./tenants too
+			if tenantCfg.EventStoreBackend != backendBadger {
+				if tenantCfg, err = tenantEventStore(tenantCfg, spec.Name, spec.Env); err != nil {
+					log.Fatal(err)
+				}
+				if storePaths[eventStoreLocation(tenantCfg)] {
+					log.Fatalf("virtual relay %q shares its event store with another relay", spec.Name)
+				}
+				storePaths[eventStoreLocation(tenantCfg)] = true
+			}
+
+			// The same goes for the honeypot, which virtual relays get under ./tenants too
+			if _, ok := spec.Env["HONEYPOT_STORE_PATH"]; !ok && tenantCfg.HoneypotStorePath != "" {
+				tenantCfg.HoneypotStorePath = filepath.Join("tenants", spec.Name+"-honeypot")
+			}
+			if tenantCfg.HoneypotStorePath != "" {
+				if storePaths[filepath.Clean(tenantCfg.HoneypotStorePath)] {
+					log.Fatalf("virtual relay %q shares its HONEYPOT_STORE_PATH with another store", spec.Name)
+				}
+				storePaths[filepath.Clean(tenantCfg.HoneypotStorePath)] = true
+			}
+
+			root := spec.Path
+			if root == "" {
+				root = "/"
+			}
+			tenantDeps := newDeps(spec.Name, tenantCfg)
+			tenantDeps.Settings = NewSettings(tenantCfg, spec.Env, os.Getenv, func(changes map[string]string) error {
+				return persistTenantEnv(cfg.TenantsFile, spec.Name, changes)
+			})
+			if tenantDeps.Candidate, err = NewCandidate(ctx, tenantCfg, tenantEnv(spec.Env, os.Getenv), tenantDeps); err != nil {
+				log.Fatalf("virtual relay %q: %v", spec.Name, err)
+			}
+			tenantRelay, tenantHandler := newRelay(ctx, tenantCfg, tenantDeps, root)
+			relays = append(relays, tenantRelay)
+			tenants.Add(spec, tenantHandler)
+		}
+		router.Handle("/", tenants)
+	}
+
+	// Jobs run until shutdown, which waits for those in progress before closing the stores
+	if snapshotter != nil {
+		addJob("", jobBackup, cfg.SnapshotSchedule, snapshotter.SnapshotAll)
+	}
+	addJob("", jobReport, cfg.ReportSchedule, reportJob(cfg.ReportWebhook, allDeps))
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		jobs.Run(ctx)
+	}()
+
+	// Create HTTP server with custom router and proper timeouts.
+	// Timeouts prevent resource exhaustion from slow clients.
+	tlsConfig, err := serverTLS(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rootHandler http.Handler = router
+	if cfg.HTTPRatePerMinute > 0 {
+		rootHandler = withHTTPRateLimit(globalLimiter, cfg.HTTPRatePerMinute, obs, rootHandler)
+	}
+	if cfg.AccessLog {
+		rootHandler = withAccessLog(cfg.AccessLogSampleRate, rootHandler)
+	}
+	server := &http.Server{
+		Addr:         cfg.ListenAddr,
+		Handler:      rootHandler,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	exitErr := make(chan error, 1)
+
+	// Start the server, over TLS if configured
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			relayLog.Info("listening", "addr", server.Addr, "tls", true)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			relayLog.Info("listening", "addr", server.Addr, "tls", false)
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			exitErr <- err
+		}
+	}()
+
+	// Wait for shutdown signal or server error
+	select {
+	case <-ctx.Done():
+		// Graceful shutdown
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+
+		err := server.Shutdown(shutdownCtx)
+		for _, relay := range relays {
+			relay.Wait() // Wait for relay to close all connections
+		}
+		<-jobsDone
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			relayLog.Error("failed to flush the remaining spans", "error", err)
+		}
+		if err != nil {
+			relayLog.Error("server shutdown failed", "error", err)
+		} else {
+			relayLog.Info("server shutdown complete")
+		}
+
+	case err := <-exitErr:
+		log.Fatalf("Server error: %v", err)
+	}
+	return 0
+}
+
+// newRelay creates and starts a relay serving cfg, and returns it with its HTTP handler.
+// root is the path at which the relay's HTML page is served.
+func newRelay(ctx context.Context, cfg Config, d *Deps, root string) (*rely.Relay, http.Handler) {
+	// Create NIP-11 relay information document
+	relayInfo := createRelayInfoDocument(cfg)
+
+	relay := rely.NewRelay(
+		rely.WithDomain(cfg.RelayDomain),
+		rely.WithInfo(relayInfo),
+		rely.WithMaxMessageSize(maxMessageSize),
+	)
+	if d.Live != nil {
+		d.Live.Attach(relay)
+	}
+
+	// Icon and banner given as images rather than URLs are hosted by the relay itself
+	icon, err := loadAsset("icon", cfg.RelayIcon)
+	if err != nil {
+		log.Fatalf("failed to load RELAY_ICON: %v", err)
+	}
+	banner, err := loadAsset("banner", cfg.RelayBanner)
+	if err != nil {
+		log.Fatalf("failed to load RELAY_BANNER: %v", err)
+	}
+	nip11Doc := NewRelayInfo(relayInfo, root, icon, banner, d.now)
+	pageInfo := relayInfo
+	if icon != nil {
+		pageInfo.Icon = icon.Path(root)
+	}
+	if banner != nil {
+		pageInfo.Banner = banner.Path(root)
+	}
+
+	// No NIP-42 auth requirement - rate limiting is based on event.PubKey
+	relay.On.Event = func(c rely.Client, e *nostr.Event) error {
+		start := time.Now()
+		ctx := withLogAttrs(withTrace(ctx), slog.String("id", e.ID), slog.String("pubkey", e.PubKey), slog.Int("kind", e.Kind), slog.String("ip_group", c.IP().Group()))
+		eventLog.DebugContext(ctx, "received event")
+
+		// The candidate config decides first, seeing the store as the active config does
+		sampled, candidate := d.Candidate.Decide(ctx, c, e)
+		err := handleEvent(ctx, c, e, d.config(cfg), d)
+		if sampled {
+			d.Candidate.Compare(e, err, candidate, d.now())
+		}
+		// Shadow-banned events are acknowledged as accepted from here on
+		shadowBanned := errors.Is(err, errShadowBanned)
+		if shadowBanned {
+			err = nil
+		}
+		d.Obs.kinds.Record(e.Kind, err == nil)
+		if err != nil {
+			eventLog.DebugContext(ctx, "rejected event", "reason", err)
+		}
+
+		var rank *float64
+		if r, ok := d.Cache.Peek(e.PubKey); ok {
+			rank = &r
+		}
+		if d.Decisions != nil {
+			d.Decisions.Record(ctx, d.Name, e, rank, err, time.Since(start))
+		}
+		if d.Adaptive != nil {
+			d.Adaptive.Record(err)
+		}
+		if d.Onboarding != nil && isSpam(err) {
+			d.Onboarding.Flagged(e.PubKey, d.now())
+		}
+		if err == nil && !shadowBanned && e.Kind == 0 && d.config(cfg).ZapValidationEnabled {
+			d.Zaps.Prefetch(e)
+		}
+		if d.Mirror != nil && err == nil && !shadowBanned {
+			d.Mirror.Enqueue(e, d.config(cfg))
+		}
+		if d.Digest != nil && err == nil && !shadowBanned && rank != nil {
+			d.Digest.Accepted(e.PubKey, *rank, d.now(), d.config(cfg))
+		}
+
+		// Spam goes to the honeypot, and the spammer is told it was accepted
+		if err != nil && d.Honeypot != nil && d.Honeypot.Catch(ctx, e, rank, err) {
+			return nil
+		}
+		// Authors rejected by the trust policy are told why, in the background
+		if err != nil && d.Feedback != nil && !isTrustedPeer(c, cfg) {
+			go d.Feedback.Send(context.WithoutCancel(ctx), e, err, d.config(cfg), d)
+		}
+		return err
+	}
+
+	// Close subscriptions after EOSE or after their maximum lifetime, if configured
+	subs := NewSubscriptionReaper(ctx, d.Obs, cfg.ReqKeepOpen, time.Duration(cfg.SubscriptionMaxAgeMinutes)*time.Minute, time.Second)
+	relay.On.Connect = func(c rely.Client) {
+		d.Obs.activeConnections.Add(1)
+		if d.Connections != nil {
+			if err := d.Connections.Admit(c); err != nil {
+				d.Connections.Reject(c, err)
+				return
+			}
+		}
+		subs.Connect(c)
+		// Peer relays authenticate with their relay key in response to the challenge,
+		// clients authenticate to get the REQ budget of their pubkey, and those admitted
+		// into the connection reserve to stay connected
+		probation := d.Connections.OnProbation(c)
+		if probation {
+			c.SendNotice(ErrAuthToStay.Error())
+		}
+		if len(cfg.TrustedPeers) > 0 || cfg.ReqFiltersPerMinuteAuthed > 0 || probation {
+			c.SendAuth()
+		}
+	}
+	// Connections of the reserve stay once authenticated as a pubkey of the mid tier or above.
+	// Only ranks the relay already knows count: refreshing them would let a flood of fresh
+	// keys drain the global refresh budget, and outlast the grace of the members' connections.
+	relay.On.Auth = func(c rely.Client) {
+		if !d.Connections.OnProbation(c) {
+			return
+		}
+		live := d.config(cfg)
+		if isTrustedPeer(c, live) {
+			d.Connections.Trusted(c)
+			return
+		}
+		for _, pubkey := range c.Pubkeys() {
+			if rank, ok := knownRank(pubkey, live, d); ok && rank >= live.MidThreshold {
+				d.Connections.Trusted(c)
+				return
+			}
+		}
+	}
+	relay.On.Disconnect = func(c rely.Client) {
+		d.Obs.activeConnections.Add(-1)
+		subs.Disconnect(c)
+		d.Prefetch.Disconnect(c)
+		if d.Connections != nil {
+			d.Connections.Release(c)
+		}
+	}
+	// Each filter is a separate store query, so their number is bounded.
+	// This must run before any other REQ hook, as rejected REQs never reach On.Req.
+	relay.Reject.Req.Append(func(_ rely.Client, f nostr.Filters) error {
+		if cfg.ReqMaxFilters > 0 && len(f) > cfg.ReqMaxFilters {
+			d.Obs.tooManyFiltersCount.Add(1)
+			return fmt.Errorf("%w: max %d per REQ", ErrTooManyFilters, cfg.ReqMaxFilters)
+		}
+		return nil
+	})
+	// Open subscriptions and queried filters are bounded per connection, IP group and pubkey
+	relay.Reject.Req.Append(func(c rely.Client, f nostr.Filters) error {
+		return reqRateLimit(c, f, cfg, d)
+	})
+	relay.Reject.Req.Append(func(c rely.Client, _ nostr.Filters) error {
+		subs.QueryStarted(c)
+		return nil
+	})
+
+	// Query hook for REQ messages
+	relay.On.Req = func(ctx context.Context, c rely.Client, f nostr.Filters) ([]nostr.Event, error) {
+		defer subs.QueryDone(c)
+		if !isTrustedPeer(c, cfg) {
+			d.Prefetch.Authors(c, f)
+		}
+		return Query(ctx, c, f, d.config(cfg), d)
+	}
+
+	// COUNT requests are answered once reactions and zap receipts are compacted,
+	// as their counts are all that is left of them
+	if d.Compaction != nil {
+		relay.Reject.Count.Append(func(_ rely.Client, f nostr.Filters) error {
+			if cfg.ReqMaxFilters > 0 && len(f) > cfg.ReqMaxFilters {
+				d.Obs.tooManyFiltersCount.Add(1)
+				return fmt.Errorf("%w: max %d per COUNT", ErrTooManyFilters, cfg.ReqMaxFilters)
+			}
+			return nil
+		})
+		relay.On.Count = func(c rely.Client, f nostr.Filters) (int64, bool, error) {
+			return Count(ctx, f, d)
+		}
+	}
+
+	// Start the relay (non-blocking)
+	relay.Start(ctx)
+
+	// Pace websocket writes and coalesce repeated notices per connection
+	outbound := NewOutboundLimiter(d.Obs, cfg.OutboundFrameRate, cfg.OutboundFrameBurst, time.Duration(cfg.NoticeCoalesceSeconds)*time.Second)
+	outbound.LiveEventRate, outbound.LiveEventBurst, outbound.LiveEventBuffer = cfg.LiveEventRate, cfg.LiveEventBurst, cfg.LiveEventBuffer
+	if cfg.LiveEventOverflow == liveOverflowCoalesce {
+		outbound.LiveEventBuffer = 1
+	}
+	relayHandler := outbound.Wrap(relay)
+
+	// Custom root handler that delegates to HTML or relay based on request type
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Serve the NIP-11 document with the limitations nip11.RelayInformationDocument can't express,
+		// at the root to clients preferring it and at relayInfoPath to anyone
+		isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+		isRoot := r.URL.Path == root && r.Header.Get("Upgrade") != "websocket"
+		if isRoot {
+			w.Header().Add("Vary", "Accept")
+		}
+		atInfoPath := r.URL.Path == path.Join(root, relayInfoPath) && isRead
+		if (isRoot && isRead && wantsRelayInfo(r.Header.Get("Accept"))) || atInfoPath {
+			contentType := "application/nostr+json"
+			if atInfoPath {
+				contentType = "application/json"
+			}
+			live := &cfg
+			if d.Settings != nil {
+				live = d.Settings.live()
+			}
+			nip11Doc.Serve(w, r, live, contentType)
+			return
+		}
+
+		// Route WebSocket requests to the relay
+		if r.Header.Get("Upgrade") == "websocket" {
+			relayHandler.ServeHTTP(w, r)
+			return
+		}
 
-	relay := rely.NewRelay(
-		rely.WithDomain("relay.example.com"),
-		rely.WithInfo(relayInfo),
-	)
+		// For all other requests to the relay's root path, serve HTML
+		if r.URL.Path == root && r.Method == http.MethodGet {
+			serveHTMLPage(cfg, pageInfo)(w, r)
+			return
+		}
 
-	// No NIP-42 auth requirement - rate limiting is based on event.PubKey
-	relay.On.Event = func(c rely.Client, e *nostr.Event) error {
-		return handleEvent(ctx, c, e, cfg, cache, limiter, &db, obs)
-	}
+		// Hosted icon and banner
+		for _, asset := range []*Asset{icon, banner} {
+			if asset != nil && r.URL.Path == asset.Path(root) && r.Method == http.MethodGet {
+				asset.ServeHTTP(w, r)
+				return
+			}
+		}
 
-	// Query hook for REQ messages
-	relay.On.Req = func(ctx context.Context, c rely.Client, f nostr.Filters) ([]nostr.Event, error) {
-		return Query(ctx, c, f, &db, cfg.Debug)
-	}
+		// Admin API and stats, when ADMIN_TOKEN is set
+		if adminHandler(w, r, root, cfg, d) {
+			return
+		}
 
-	// Start the relay (non-blocking)
-	relay.Start(ctx)
+		// Write pre-check for clients
+		if checkHandler(w, r, root, d.config(cfg), d) {
+			return
+		}
 
-	// Create a custom handler that routes requests appropriately
-	router := http.NewServeMux()
+		// Reputation list, for cooperating relays
+		if reputationHandler(w, r, root, d) {
+			return
+		}
 
-	// Serve favicon
-	router.HandleFunc("/favicon.ico", serveFavicon())
+		// Paid memberships, when PAYMENTS_BACKEND is set
+		if paymentsHandler(w, r, root, d) {
+			return
+		}
 
-	// Custom root handler that delegates to HTML or relay based on request type
-	router.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Route WebSocket and NIP-11 requests to the relay
-		if r.Header.Get("Upgrade") == "websocket" || r.Header.Get("Accept") == "application/nostr+json" {
-			relay.ServeHTTP(w, r)
+		// Latest event timestamps, for clients resuming subscriptions
+		if latestHandler(w, r, root, d.config(cfg), d) {
 			return
 		}
 
-		// For all other requests to root path, serve HTML
-		if r.URL.Path == "/" && r.Method == http.MethodGet {
-			serveHTMLPage(cfg, relayInfo)(w, r)
+		// NIP-86 management API, when ADMIN_PUBKEYS is set
+		if managementHandler(w, r, root, d.config(cfg), d) {
 			return
 		}
 
 		// Let relay handle everything else
-		relay.ServeHTTP(w, r)
-	}))
+		relayHandler.ServeHTTP(w, r)
+	})
 
-	// Create HTTP server with custom router and proper timeouts.
-	// Timeouts prevent resource exhaustion from slow clients.
-	server := &http.Server{
-		Addr:         "0.0.0.0:3334",
-		Handler:      router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-	exitErr := make(chan error, 1)
+	// Browser-based clients read the HTTP endpoints cross-origin
+	return relay, withCORS(cfg.CORSOrigins, handler)
+}
 
-	// Start the server
-	go func() {
-		log.Printf("Starting wotrlay relay on %s", server.Addr)
-		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			exitErr <- err
+// handleEvent implements the v2 event handling flow.
+func handleEvent(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config, d *Deps) (err error) {
+	ctx, span := tracer.Start(ctx, "handleEvent", trace.WithAttributes(
+		attribute.String("nostr.event.id", e.ID),
+		attribute.Int("nostr.event.kind", e.Kind),
+	))
+	defer func() {
+		// Rejections are the relay doing its job, not failures
+		if err != nil {
+			span.SetAttributes(attribute.String("wotrlay.rejection", err.Error()))
 		}
+		span.End()
 	}()
 
-	// Wait for shutdown signal or server error
-	select {
-	case <-ctx.Done():
-		// Graceful shutdown
-		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer shutdownCancel()
+	now := d.now()
 
-		err := server.Shutdown(shutdownCtx)
-		relay.Wait() // Wait for relay to close all connections
-		if err != nil {
-			log.Printf("Server shutdown error: %v", err)
-		} else {
-			log.Printf("Server shutdown complete")
-		}
+	// 0. Oversized events are rejected before any other work
+	if err := checkEventLimits(e, cfg); err != nil {
+		d.Obs.oversizedCount.Add(1)
+		return err
+	}
 
-	case err := <-exitErr:
-		log.Fatalf("Server error: %v", err)
+	// 0.1. Banned pubkeys are rejected outright. The attempt is still linked to the
+	// client's IP group so that other pubkeys from it come under scrutiny.
+	if cfg.BanEvasionEnabled {
+		d.Linkage.Record(c.IP().Group(), e.PubKey)
+	}
+	if d.Linkage.IsBanned(e.PubKey) {
+		d.Obs.bannedCount.Add(1)
+		return ErrBanned
 	}
-}
 
-// handleEvent implements the v2 event handling flow.
-func handleEvent(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config, cache *RankCache, limiter *Limiter, db *badger.BadgerBackend, obs *Observability) error {
-	now := time.Now()
+	// 0.2. Events deleted by their author (NIP-09) can't be submitted again
+	if isDeleted(d.Meta, e) {
+		d.Obs.deletedResubmitCount.Add(1)
+		return ErrDeleted
+	}
+
+	// 0.3. Events listed by a blocklist are never stored, whoever sends them
+	if entry, source, ok := d.Blocklist.Blocked(e); ok {
+		d.Obs.blocklistBlockedCount.Add(1)
+		if !d.Shadow {
+			eventLog.WarnContext(ctx, "refused blocklisted event", "entry", entry, "source", source)
+		}
+		return ErrBlocklisted
+	}
 
-	// 0. Exempt kinds bypass all rate limiting and kind gating
-	if exemptKinds[e.Kind] {
+	// 0.5. Exempt kinds bypass all rate limiting and kind gating, and so do events
+	// relayed by trusted peer relays, which were vetted by the peer's own policy
+	peer := isTrustedPeer(c, cfg)
+	if peer {
+		d.Obs.peerEventCount.Add(1)
+	}
+	if exemptKinds[e.Kind] || peer {
 		// Only timestamp sanity check applies to exempt kinds
-		eventTime := time.Unix(int64(e.CreatedAt), 0)
-		if eventTime.Sub(now) > timestampSanityWindow {
-			obs.invalidTimestampCount.Add(1)
-			return ErrInvalidTimestamp
+		if _, err := checkCreatedAt(e, now, cfg, d.Obs); err != nil {
+			return err
 		}
 		// Save exempt kind events directly
-		return Save(ctx, e, db, cfg.Debug)
+		return Save(ctx, e, d)
 	}
 
 	// 1. Extract pubkey
 	pubkey := e.PubKey
 
 	// 2. Get rank from cache, with best-effort refresh on miss
-	rank := lookupRank(ctx, c, e, cfg, cache, limiter, obs)
+	rank := lookupRank(ctx, pubkey, cfg, d)
+	rankLog.DebugContext(ctx, "looked up rank", "rank", rank, "tier", tierFor(rank, cfg))
+
+	// 2.5. Zap history: verified zaps from high-trust pubkeys raise the rank
+	if cfg.ZapTrustEnabled {
+		rank = d.ZapTrust.Boost(ctx, pubkey, rank, cfg, d)
+	}
+	tier := tierFor(rank, cfg)
+
+	// 2.55. Onboarding: pubkeys without a rank earn a bigger budget over time
+	onboarding := isOnboarding(pubkey, rank, cfg, d)
+	var stage OnboardingStage
+	if onboarding {
+		stage = d.Onboarding.Peek(pubkey, now)
+		limiterLog.DebugContext(ctx, "onboarding", "stage", stage)
+	}
+
+	// 2.6. Shadow ban: events from the tier are acknowledged but never stored
+	if d.Flags.Enabled(FlagShadowBan, tier) {
+		d.Obs.shadowBannedCount.Add(1)
+		eventLog.DebugContext(ctx, "shadow-banned event", "tier", tier)
+		return errShadowBanned
+	}
 
-	// 3. Kind gating: only Kind 1 allowed below midThreshold
-	if rank < cfg.MidThreshold && e.Kind != 1 {
-		obs.kindNotAllowedCount.Add(1)
+	// 2.7. Soft launch: the tier's writes may not be open yet, or outside the write window
+	if err := checkWriteWindow(tier, now, cfg); err != nil {
+		d.Obs.writesClosedCount.Add(1)
+		return err
+	}
+
+	// 3. Kind gating: each tier may only publish its allowed kinds (by default, the low tier kind 1 only).
+	// Long-form articles and file metadata have their own tier policies, and every
+	// tier may delete its own events. With the repost policy, tiers with a daily repost
+	// cap may repost up to it (step 3.7).
+	switch {
+	case e.Kind == kindDeletion:
+	case cfg.RepostPolicyEnabled && isRepost(e) && cfg.RepostDailyCaps[tier] > 0:
+	case e.Kind == kindLongform:
+		if err := checkLongform(e, tier, cfg); err != nil {
+			d.Obs.longformRejectedCount.Add(1)
+			return err
+		}
+	case e.Kind == kindFileMetadata:
+		if err := d.Media.Check(ctx, e, tier, cfg, d); err != nil {
+			d.Obs.fileRejectedCount.Add(1)
+			return err
+		}
+	case !allowedKinds(tier, cfg).Contains(e.Kind):
+		d.Obs.kindNotAllowedCount.Add(1)
 		return ErrKindNotAllowed
 	}
 
-	// 3.5. URL policy: no URLs allowed for users below mid threshold
-	if cfg.URLPolicyEnabled && rank < cfg.MidThreshold && e.Kind == 1 && ContainsURL(e.Content) {
-		obs.urlNotAllowedCount.Add(1)
-		return ErrURLNotAllowed
+	// 3.5. URL policy: no URLs allowed for tiers with the url_policy flag (by default
+	// the low tier, if enabled), except (optionally) in replies to high-trust authors
+	if d.Flags.Enabled(FlagURLPolicy, tier) && e.Kind == 1 && d.URLs.Contains(e.Content) {
+		if !cfg.URLReplyExemption || !isReplyToHighTrust(ctx, e, cfg, d) {
+			d.Obs.urlNotAllowedCount.Add(1)
+			return ErrURLNotAllowed
+		}
 	}
 
-	// 4. Timestamp sanity: reject events too far in the future
-	eventTime := time.Unix(int64(e.CreatedAt), 0)
-	if eventTime.Sub(now) > timestampSanityWindow {
-		obs.invalidTimestampCount.Add(1)
-		return ErrInvalidTimestamp
+	// 3.6. Hellthread policy: low-trust events can't notify an excessive number of participants
+	if cfg.HellthreadAction == hellthreadReject && rank < cfg.MidThreshold && isHellthread(e, cfg.HellthreadThreshold) {
+		d.Obs.hellthreadCount.Add(1)
+		return ErrHellthread
+	}
+
+	// 3.65. Entity spam: low-trust events can't embed an excessive number of nostr references,
+	// unless they carry enough proof of work when the pow_fallback flag is on (action "pow")
+	if rank < cfg.MidThreshold && isEntitySpam(e.Content, cfg.EntitySpamThreshold) {
+		if !d.Flags.Enabled(FlagPoWFallback, tier) {
+			d.Obs.entitySpamCount.Add(1)
+			return ErrEntitySpam
+		}
+		if nip13.Difficulty(e.ID) < cfg.EntitySpamPoWDifficulty {
+			d.Obs.entitySpamCount.Add(1)
+			d.Obs.powRequiredCount.Add(1)
+			return ErrPoWRequired
+		}
+	}
+
+	// 3.66. Copypasta: low-trust pubkeys can't flood the relay with identical content
+	copypasta := cfg.DuplicateContentThreshold > 0 && rank < cfg.MidThreshold
+	if copypasta && d.Dedup.Count(e.Content, pubkey) > cfg.DuplicateContentThreshold {
+		d.Obs.duplicateContentCount.Add(1)
+		return ErrDuplicateContent
+	}
+
+	// 3.67. Content quality: low-trust events that look like low-effort spam
+	// are rejected or cost more tokens
+	lowQuality := false
+	if cfg.ContentQualityAction != qualityOff && rank < cfg.MidThreshold {
+		if reason := lowQualityReason(e.Content); reason != "" {
+			d.Obs.lowQualityCount.Add(1)
+			eventLog.DebugContext(ctx, "low quality content", "reason", reason)
+			if cfg.ContentQualityAction == qualityReject {
+				return ErrLowQuality
+			}
+			lowQuality = true
+		}
+	}
+
+	// 3.7. Repost policy: known targets only, one repost per target, daily caps per tier
+	repost := cfg.RepostPolicyEnabled && isRepost(e)
+	if repost {
+		if err := checkRepost(ctx, e, rank, cfg, d); err != nil {
+			d.Obs.repostRejectedCount.Add(1)
+			return err
+		}
+	}
+
+	// 3.8. Zap receipts must match their zap request, invoice and the recipient's LNURL provider.
+	// Providers publish a receipt once: one whose provider isn't known yet is checked once stored,
+	// and one whose recipient's profile isn't stored is accepted without the provider check.
+	zapPending := false
+	if cfg.ZapValidationEnabled && e.Kind == 9735 {
+		err := d.Zaps.Validate(ctx, e, d.DB)
+		zapPending = errors.Is(err, ErrZapPending)
+		if err != nil && !zapPending && !errors.Is(err, errZapRecipientUnknown) {
+			d.Obs.invalidZapCount.Add(1)
+			return err
+		}
+	}
+
+	// 3.9. Community approvals must come from the community's moderators
+	if cfg.CommunityModerationEnabled && e.Kind == kindCommunityApproval {
+		if err := checkApproval(ctx, e, d.DB); err != nil {
+			d.Obs.invalidApprovalCount.Add(1)
+			return err
+		}
+	}
+
+	// 4. Timestamp sanity: reject events too far in the future, tolerating clock skew
+	eventTime, err := checkCreatedAt(e, now, cfg, d.Obs)
+	if err != nil {
+		return err
 	}
 
-	// 5. Backfill rule: free for very high trust if event is old
-	if cfg.HighThreshold != nil && rank >= *cfg.HighThreshold && now.Sub(eventTime) > backfillAgeThreshold {
-		// Backfill is free - skip rate limiting
-		return Save(ctx, e, db, cfg.Debug)
+	// 4.1. Minimum created_at: tiers with a max age (typically the low tier) can't
+	// flood the relay with backdated history
+	if isTooOld(eventTime, now, tier, cfg) {
+		d.Obs.tooOldCount.Add(1)
+		return ErrEventTooOld
+	}
+
+	// 4.5. Ban evasion: pubkeys linked to a banned pubkey through a shared IP group
+	// must attach proof of work and get a reduced budget. Suspects don't get free backfill.
+	suspect := cfg.BanEvasionEnabled && d.Linkage.IsSuspect(pubkey)
+	if suspect {
+		d.Obs.suspectCount.Add(1)
+		if cfg.SuspectPoWDifficulty > 0 && nip13.Difficulty(e.ID) < cfg.SuspectPoWDifficulty {
+			d.Obs.powRequiredCount.Add(1)
+			return ErrPoWRequired
+		}
+	}
+
+	// 4.6. Spam waves: the adaptive controller may require proof of work from the low tier
+	if d.Adaptive != nil && tier == TierLow && nip13.Difficulty(e.ID) < d.Adaptive.PoW() {
+		d.Obs.powRequiredCount.Add(1)
+		return ErrPoWRequired
+	}
+
+	// 5. Backfill rule: old events follow the backfill policy of the tier (by default free for
+	// very high trust), unless its backfill flag is off. Suspects don't get free or discounted backfill.
+	backfill := backfillNormal
+	if now.Sub(eventTime) > backfillAgeThreshold {
+		backfill = backfillPolicy(tier, cfg, d.Flags)
+	}
+	if backfill == backfillReject {
+		d.Obs.tooOldCount.Add(1)
+		return ErrEventTooOld
+	}
+	if suspect {
+		backfill = backfillNormal
+	}
+	if backfill == backfillFree {
+		// Free backfill skips rate limiting, up to the pubkey's daily backfill cap
+		if d.Backfill.Use(pubkey, now) {
+			d.Obs.backfillCount.Add(1)
+			return Save(ctx, e, d)
+		}
+		// Over the cap, the event is rate limited like any other
+		d.Obs.backfillCappedCount.Add(1)
 	}
 
 	// 6. Apply pubkey token bucket
 	dailyRate := calculateDailyRate(rank, cfg)
+	if onboarding {
+		dailyRate = onboardingDailyRate(stage, cfg)
+	}
+	if suspect {
+		dailyRate *= cfg.SuspectRateMultiplier
+	}
+	if d.Adaptive != nil && tier == TierLow {
+		dailyRate *= d.Adaptive.RateFactor()
+	}
 	refillRate := dailyRate / secondsPerDay // tokens per second
 	capacity := dailyRate / 24.0            // 1 hour worth of tokens
-	// Each event costs 1 token. If capacity < 1, the bucket can never reach 1 token,
+	// If capacity < cost, the bucket can never hold enough tokens,
 	// which would permanently rate-limit that pubkey.
-	if capacity < 1 {
-		capacity = 1
+	cost := eventCost(e, tier, cfg)
+	if lowQuality {
+		cost = max(cost, cfg.ContentQualityTokenCost)
+	}
+	if backfill == backfillDiscount {
+		cost *= cfg.BackfillDiscount
+		d.Obs.backfillDiscountedCount.Add(1)
+	}
+	if capacity < cost {
+		capacity = cost
 	}
 
-	if !limiter.Allow(pubkey, capacity, refillRate) {
-		obs.rateLimitedCount.Add(1)
+	// 6.1. Proof of work: unranked pubkeys may pay for the event with NIP-13 work instead
+	// of the single token a day their bucket holds. Suspects must pay with tokens.
+	var bonus float64
+	if !suspect {
+		bonus = powBonus(e, rank, cfg)
+	}
+	if bonus > 0 {
+		d.Obs.powPaidCount.Add(1)
+	}
+
+	// 6.5. The IP group token bucket: a host rotating through fresh pubkeys still faces
+	// an aggregate ceiling. It is checked first, so that an event it refuses doesn't
+	// cost its author tokens, and charged once the pubkey's bucket allowed the event.
+	var group string
+	var groupCapacity, groupRefill float64
+	if cfg.IPGroupDailyRate > 0 && rank < cfg.MidThreshold {
+		group = c.IP().Group()
+		groupCapacity, groupRefill = max(cfg.IPGroupDailyRate/24.0, cost), cfg.IPGroupDailyRate/secondsPerDay
+		if group != "" && d.Limiter.Peek("ip:"+group, groupCapacity, groupRefill) < cost {
+			d.Obs.ipRateLimitedCount.Add(1)
+			return ErrIPRateLimited
+		}
+	}
+
+	if cost > bonus && !d.Limiter.Consume(pubkey, cost-bonus, capacity, refillRate) {
+		d.Obs.rateLimitedCount.Add(1)
+		d.Obs.rateLimited[tier].Add(1)
 		return ErrRateLimited
 	}
 
+	if group != "" && !d.Limiter.Consume("ip:"+group, cost, groupCapacity, groupRefill) {
+		d.Obs.ipRateLimitedCount.Add(1)
+		return ErrIPRateLimited
+	}
+	// The repost cap peeked at step 3.7 is spent once the event is allowed
+	if repost {
+		if err := chargeRepost(e, rank, cfg, d); err != nil {
+			d.Obs.repostRejectedCount.Add(1)
+			return err
+		}
+	}
+	d.Obs.rateAllowed[tier].Add(1)
+
 	// 7. Save event
-	return Save(ctx, e, db, cfg.Debug)
+	if err := Save(ctx, e, d); err != nil {
+		return err
+	}
+	if onboarding && !d.Shadow {
+		d.Onboarding.Accepted(pubkey, now)
+	}
+	if copypasta {
+		d.Dedup.Record(e.Content, pubkey)
+	}
+	if zapPending {
+		d.Zaps.Recheck(ctx, e, d.DB, func(ctx context.Context, receipt *nostr.Event) {
+			d.Obs.invalidZapCount.Add(1)
+			if err := d.DB.DeleteEvent(ctx, receipt); err != nil {
+				eventLog.ErrorContext(ctx, "failed to delete event", "event", receipt.ID, "error", err)
+				return
+			}
+			d.Retention.Deleted(receipt)
+			if d.Latest != nil {
+				d.Latest.Removed(receipt)
+			}
+			eventLog.InfoContext(ctx, "removed zap receipt not signed by the recipient's lnurl provider", "event", receipt.ID)
+		})
+	}
+
+	// 8. Complete the thread of replies whose parent or root isn't stored
+	if d.Threads != nil && e.Kind == nostr.KindTextNote {
+		d.Threads.Enqueue(e)
+	}
+	return nil
+}
+
+// isTrustedPeer returns whether the client authenticated as one of the TrustedPeers.
+func isTrustedPeer(c rely.Client, cfg Config) bool {
+	if len(cfg.TrustedPeers) == 0 {
+		return false
+	}
+	for _, pubkey := range c.Pubkeys() {
+		if slices.Contains(cfg.TrustedPeers, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCreatedAt rejects events created more than MaxFutureSeconds in the future, and returns the
+// time the relay's policies take the event to be created at: its created_at, or the server time
+// for an event within ClockSkewSeconds past the limit, as sent by a client whose clock runs ahead.
+// That event is stored as signed: rewriting its created_at would invalidate the signature.
+func checkCreatedAt(e *nostr.Event, now time.Time, cfg Config, obs *Observability) (time.Time, error) {
+	eventTime := time.Unix(int64(e.CreatedAt), 0)
+	ahead := eventTime.Sub(now)
+	maxFuture := time.Duration(cfg.MaxFutureSeconds) * time.Second
+	switch {
+	case ahead <= maxFuture:
+		return eventTime, nil
+	case ahead <= maxFuture+time.Duration(cfg.ClockSkewSeconds)*time.Second:
+		obs.clockSkewCount.Add(1)
+		return now, nil
+	default:
+		obs.invalidTimestampCount.Add(1)
+		return eventTime, ErrInvalidTimestamp
+	}
+}
+
+// isTooOld returns whether an event created at eventTime is older than the max age of the tier.
+func isTooOld(eventTime, now time.Time, tier Tier, cfg Config) bool {
+	maxAge := cfg.MaxEventAgeHours[tier]
+	return maxAge > 0 && now.Sub(eventTime) > time.Duration(maxAge)*time.Hour
 }
 
-// calculateDailyRate returns the target allowed events per day based on trust score.
+// calculateDailyRate returns the target allowed events per day based on trust score,
+// scaled by the RateMultiplier.
 func calculateDailyRate(r float64, cfg Config) float64 {
+	return cfg.RateMultiplier * baseDailyRate(r, cfg)
+}
+
+// baseDailyRate returns the allowed events per day of the rate curve, before scaling.
+func baseDailyRate(r float64, cfg Config) float64 {
 	switch {
 	case r <= 0:
 		return 1
@@ -447,11 +3175,50 @@ func calculateDailyRate(r float64, cfg Config) float64 {
 	}
 }
 
+// operatorRank returns the rank the operator set for the pubkey: 1 if it is allowlisted,
+// its override otherwise. ok is false if the rank is up to the rank provider.
+func operatorRank(pubkey string, cfg Config) (rank float64, ok bool) {
+	if slices.Contains(cfg.AllowedPubkeys, pubkey) {
+		return 1, true
+	}
+	rank, ok = cfg.RankOverrides[pubkey]
+	return rank, ok
+}
+
+// knownRank returns the rank the relay knows for the pubkey without asking the rank provider:
+// the operator's, the cached one, or the last one of a recently evicted pubkey.
+func knownRank(pubkey string, cfg Config, d *Deps) (float64, bool) {
+	if rank, ok := operatorRank(pubkey, cfg); ok {
+		return rank, true
+	}
+	if rank, ok := d.Cache.Rank(pubkey); ok {
+		return rank, true
+	}
+	return d.Cache.Remembered(pubkey)
+}
+
 // lookupRank returns the rank for a pubkey, performing a best-effort refresh on cache miss.
 // Uses a global relay-wide limiter to protect rank provider from abuse.
 // Preserves stale cache data when refresh fails or global limit is hit.
-func lookupRank(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config, cache *RankCache, limiter *Limiter, obs *Observability) float64 {
-	pubkey := e.PubKey
+func lookupRank(ctx context.Context, pubkey string, cfg Config, d *Deps) float64 {
+	ctx, span := tracer.Start(ctx, "lookupRank")
+	defer span.End()
+	cache, limiter := d.Cache, d.GlobalLimiter
+
+	// Operators have the last word
+	if rank, ok := operatorRank(pubkey, cfg); ok {
+		return rank
+	}
+
+	// Paid members get at least the rank of their membership
+	if d.Payments != nil {
+		if _, ok := d.Payments.Member(pubkey, d.now()); ok {
+			if rank, exists := cache.Rank(pubkey); exists && rank > d.Payments.Rank {
+				return rank
+			}
+			return d.Payments.Rank
+		}
+	}
 
 	// Try cache first
 	rank, exists := cache.Rank(pubkey)
@@ -459,6 +3226,13 @@ func lookupRank(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config,
 		return rank
 	}
 
+	// Recently evicted: keep the last rank instead of waiting for the provider
+	if rank, ok := cache.Remembered(pubkey); ok {
+		d.Obs.rankGraceHits.Add(1)
+		rankLog.DebugContext(ctx, "using last known rank of evicted pubkey, refreshing", "rank", rank)
+		return rank
+	}
+
 	// Gate refresh attempts by global relay-wide limiter to protect rank provider from abuse
 	if limiter.Allow("global-rank-refresh", cfg.GlobalRankRefreshLimit, cfg.GlobalRankRefreshLimit) {
 		refreshCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -468,9 +3242,7 @@ func lookupRank(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config,
 		}
 		// Refresh failed - check if we have stale data preserved
 		if rank, exists := cache.Rank(pubkey); exists {
-			if cfg.Debug {
-				log.Printf("using stale rank %f for %s (refresh failed)", rank, pubkey)
-			}
+			rankLog.DebugContext(ctx, "using stale rank, refresh failed", "rank", rank)
 			return rank
 		}
 		// No stale data, enqueue for async refresh and proceed with rank=0
@@ -478,73 +3250,386 @@ func lookupRank(ctx context.Context, c rely.Client, e *nostr.Event, cfg Config,
 	} else {
 		// Global rate-limited - check if we have stale data preserved
 		if rank, exists := cache.Rank(pubkey); exists {
-			if cfg.Debug {
-				log.Printf("global rank refresh rate-limited, using stale rank %f for %s", rank, pubkey)
-			}
+			rankLog.DebugContext(ctx, "global rank refresh rate-limited, using stale rank", "rank", rank)
 			return rank
 		}
-		if cfg.Debug {
-			log.Printf("global rank refresh rate-limited, no stale data available for %s", pubkey)
-		}
+		rankLog.DebugContext(ctx, "global rank refresh rate-limited, no stale rank available")
 	}
 	return 0
 }
 
-func Save(ctx context.Context, e *nostr.Event, db *badger.BadgerBackend, debug bool) error {
-	// Save event to Badger backend
-	err := db.SaveEvent(ctx, e)
+func Save(ctx context.Context, e *nostr.Event, d *Deps) error {
+	// Enforce the relay's storage quota
+	if d.Retention.Full() {
+		d.Obs.storeFullCount.Add(1)
+		return ErrStoreFull
+	}
+	if d.Shadow {
+		return nil
+	}
+
+	if err := store(ctx, e, d); err != nil {
+		return err
+	}
+
+	// Gossip the event to the other nodes of the cluster
+	if d.Cluster != nil {
+		d.Cluster.PublishEvent(ctx, d.Name, e)
+	}
+	return nil
+}
+
+// store writes the event to the relay's event store.
+// While the store is unwritable, the event is rejected without a write attempt.
+func store(ctx context.Context, e *nostr.Event, d *Deps) (err error) {
+	ctx, span := tracer.Start(ctx, "store")
+	defer func() { endSpan(span, err) }()
+
+	if !d.StoreHealth.Writable() {
+		return ErrStoreUnavailable
+	}
+
+	// Save event to Badger backend. Replaceable and addressable events
+	// replace previous versions with the same pubkey, kind and "d" tag.
+	if isReplaceable(e.Kind) {
+		err = saveReplaceable(ctx, e, d)
+	} else {
+		err = d.DB.SaveEvent(ctx, e)
+	}
 	if err != nil {
-		log.Printf("failed to save event %s: %v", e.ID, err)
+		eventLog.ErrorContext(ctx, "failed to save event", "event", e.ID, "error", err)
+	}
+	if err = d.StoreHealth.Record(err); err != nil {
 		return err
 	}
+	d.Retention.Stored()
+	d.Obs.savedCount.Add(1)
+	d.Retention.Ledger.Credit(e)
+	if d.Latest != nil {
+		d.Latest.Saved(e)
+	}
 
-	// Only log if DEBUG is enabled to reduce production noise
-	if debug {
-		log.Printf("saved event id=%s kind=%d pubkey=%s", e.ID, e.Kind, e.PubKey)
+	// Deletion requests remove the author's events they refer to
+	if e.Kind == kindDeletion {
+		removed := applyDeletion(ctx, e, d)
+		eventLog.DebugContext(ctx, "deletion applied", "deletion", e.ID, "removed", removed)
 	}
+
+	eventLog.DebugContext(ctx, "saved event", "event", e.ID)
 	return nil
 }
 
 // Query handles REQ messages by querying the event store.
-func Query(ctx context.Context, c rely.Client, f nostr.Filters, db *badger.BadgerBackend, debug bool) ([]nostr.Event, error) {
-	if debug {
-		log.Printf("received filters %v", f)
+func Query(ctx context.Context, c rely.Client, f nostr.Filters, cfg Config, d *Deps) ([]nostr.Event, error) {
+	ctx, span := tracer.Start(ctx, "Query", trace.WithAttributes(attribute.Int("nostr.filters", len(f))))
+	defer span.End()
+	queryLog.DebugContext(ctx, "received filters", "filters", f)
+
+	// Cap the number of events returned before EOSE across all filters
+	if cfg.ReqMaxEvents > 0 {
+		rely.ApplyBudget(cfg.ReqMaxEvents, f...)
 	}
 
 	// Preallocate slice to reduce growth churn (128 is a reasonable default for most queries)
 	events := make([]nostr.Event, 0, 128)
 
+	var approvals *approvalChecker
+	if cfg.CommunityModerationEnabled {
+		approvals = newApprovalChecker(d.DB)
+	}
+
 	// Query events from the Badger backend for each filter
 	// The eventstore QueryEvents takes a single filter and returns a channel
 
 	for _, filter := range f {
-		eventChan, err := db.QueryEvents(ctx, filter)
+		start := time.Now()
+		_, filterSpan := tracer.Start(ctx, "QueryEvents", trace.WithAttributes(
+			attribute.String("wotrlay.filter.shape", filterShape(filter)),
+			attribute.String("wotrlay.filter.index", queryIndex(filter)),
+		))
+
+		// Notification queries ("#p") skip stripped low-trust hellthreads
+		stripHellthreads := cfg.HellthreadAction == hellthreadStrip && len(filter.Tags["p"]) > 0
+
+		kept, read, err := queryFilled(ctx, d.DB, filter, func(event *nostr.Event) bool {
+			if stripHellthreads && isHellthread(event, cfg.HellthreadThreshold) && !trustedAuthor(event.PubKey, cfg, d) {
+				return false
+			}
+			// Community posts are only served once approved by a moderator
+			if approvals != nil && !approvals.Approved(ctx, event) {
+				return false
+			}
+			// Events stored before a blocklist listed them are withheld until purged
+			if entry, source, ok := d.Blocklist.Blocked(event); ok {
+				d.Obs.blocklistWithheldCount.Add(1)
+				queryLog.WarnContext(ctx, "withheld blocklisted event", "event", event.ID, "entry", entry, "source", source)
+				return false
+			}
+			return true
+		})
+		events = append(events, kept...)
 		if err != nil {
-			log.Printf("failed to query events with filter %v: %v", filter, err)
+			queryLog.ErrorContext(ctx, "failed to query events", "filter", filter, "error", err)
+			endSpan(filterSpan, err)
 			continue
 		}
 
+		returned, took := len(kept), time.Since(start)
+		filterSpan.SetAttributes(attribute.Int("wotrlay.filter.read", read), attribute.Int("wotrlay.filter.returned", returned))
+		filterSpan.End()
+		if d.Queries != nil {
+			d.Queries.Record(filter, read, returned, took)
+		}
+		queryLog.DebugContext(ctx, "queried filter", "shape", filterShape(filter), "index", queryIndex(filter), "read", read, "returned", returned, "duration", took)
+	}
+
+	queryLog.DebugContext(ctx, "query returned events", "events", len(events))
+	return events, nil
+}
+
+// maxQueryPages bounds the queries of a filter made up for the events withheld from it.
+const maxQueryPages = 5
+
+// queryFilled queries the events matching the filter that keep accepts. Events withheld by
+// keep are made up for by querying the older ones, up to maxQueryPages queries, so that
+// the filter still gets up to its limit. It returns the events kept and how many were read.
+func queryFilled(ctx context.Context, db EventStore, filter nostr.Filter, keep func(*nostr.Event) bool) ([]nostr.Event, int, error) {
+	var kept []nostr.Event
+	read := 0
+	page := filter
+	boundary := make(map[string]bool) // events already read at the until timestamp of the page
+
+	for pages := 1; ; pages++ {
+		eventChan, err := db.QueryEvents(ctx, page)
+		if err != nil {
+			return kept, read, err
+		}
+
+		var batch []*nostr.Event
 		for event := range eventChan {
-			events = append(events, *event)
+			batch = append(batch, event)
+		}
+
+		oldest := nostr.Now()
+		for _, event := range batch {
+			oldest = min(oldest, event.CreatedAt)
+			if boundary[event.ID] {
+				continue
+			}
+			read++
+			if keep(event) {
+				kept = append(kept, *event)
+			}
+		}
+
+		if filter.Limit <= 0 || len(kept) >= filter.Limit || len(batch) < page.Limit || pages >= maxQueryPages || ctx.Err() != nil {
+			return kept, read, nil
+		}
+
+		// Continue from the oldest timestamp, skipping the events already read at it. The
+		// limit counts them, so that the page still holds as many new events as are missing.
+		if page.Until == nil || *page.Until != oldest {
+			clear(boundary)
+		}
+		for _, event := range batch {
+			if event.CreatedAt == oldest {
+				boundary[event.ID] = true
+			}
+		}
+		page.Until = &oldest
+		page.Limit = filter.Limit - len(kept) + len(boundary)
+	}
+}
+
+// Count handles COUNT requests by counting stored events, adding the compacted ones.
+// Counts including compacted events are approximate, as deleting a compacted event
+// doesn't decrease them.
+func Count(ctx context.Context, f nostr.Filters, d *Deps) (int64, bool, error) {
+	var total int64
+	approx := false
+	for _, filter := range f {
+		count, err := d.DB.CountEvents(ctx, filter)
+		if err != nil {
+			return 0, false, err
+		}
+		total += count
+
+		if compacted, ok := d.Compaction.Count(filter); ok {
+			total += compacted
+			approx = true
 		}
 	}
+	return total, approx, nil
+}
 
-	if debug {
-		log.Printf("query returned %d events", len(events))
+// trustedAuthor reports whether the pubkey's cached rank is at least MidThreshold.
+// It never blocks on the rank provider, so it is safe to call on the query path.
+func trustedAuthor(pubkey string, cfg Config, d *Deps) bool {
+	if rank, ok := operatorRank(pubkey, cfg); ok {
+		return rank >= cfg.MidThreshold
 	}
-	return events, nil
+	rank, _ := d.Cache.Rank(pubkey)
+	return rank >= cfg.MidThreshold
 }
 
-// logObservability prints current counter values for debugging/monitoring.
-func logObservability(obs *Observability) {
+// Metric is the value of a named observability counter.
+type Metric struct {
+	Name  string
+	Value uint64
+}
+
+// LabeledMetric is a counter of a family split by a dimension, such as the trust tier.
+// Prometheus gets the family with the label, e.g. rate_allowed{tier="low"}, while the
+// Snapshot has the counter under its own name, e.g. rate_allowed_low.
+type LabeledMetric struct {
+	Metric
+	Family string
+	Label  string // name="value"
+}
+
+// Snapshot returns the current counter values, in a stable order, the labeled ones last.
+func (obs *Observability) Snapshot() []Metric {
+	metrics := obs.counters()
+	for _, m := range obs.Labeled() {
+		metrics = append(metrics, m.Metric)
+	}
+	return metrics
+}
+
+// Labeled returns the current values of the counters split by a dimension: the token
+// bucket decisions by tier, and the events by kind.
+func (obs *Observability) Labeled() []LabeledMetric {
+	var metrics []LabeledMetric
+	for tier := TierLow; tier <= TierHigh; tier++ {
+		label := `tier="` + tier.String() + `"`
+		metrics = append(metrics,
+			LabeledMetric{Metric{"rate_allowed_" + tier.String(), obs.rateAllowed[tier].Load()}, "rate_allowed", label},
+			LabeledMetric{Metric{"rate_limited_" + tier.String(), obs.rateLimited[tier].Load()}, "rate_limited", label},
+		)
+	}
+	return append(metrics, obs.kinds.Metrics()...)
+}
+
+// counters returns the current values of the counters on their own, in a stable order.
+func (obs *Observability) counters() []Metric {
+	var cache RankCacheStats
+	if c := obs.rankCache.Load(); c != nil {
+		cache = c.Stats()
+	}
+	limiters := obs.limiterStats()
+
 	// Load atomically to avoid race conditions
-	rateLimited := obs.rateLimitedCount.Load()
-	kindNotAllowed := obs.kindNotAllowedCount.Load()
-	invalidTimestamp := obs.invalidTimestampCount.Load()
-	urlNotAllowed := obs.urlNotAllowedCount.Load()
-	cacheHits := obs.rankCacheHits.Load()
-	cacheMisses := obs.rankCacheMisses.Load()
-
-	log.Printf("observability: rate_limited=%d kind_not_allowed=%d invalid_timestamp=%d url_not_allowed=%d cache_hits=%d cache_misses=%d",
-		rateLimited, kindNotAllowed, invalidTimestamp, urlNotAllowed, cacheHits, cacheMisses)
+	return []Metric{
+		{"rate_limited", obs.rateLimitedCount.Load()},
+		{"ip_rate_limited", obs.ipRateLimitedCount.Load()},
+		{"http_rate_limited", obs.httpRateLimitedCount.Load()},
+		{"kind_not_allowed", obs.kindNotAllowedCount.Load()},
+		{"invalid_timestamp", obs.invalidTimestampCount.Load()},
+		{"too_old", obs.tooOldCount.Load()},
+		{"clock_skew", obs.clockSkewCount.Load()},
+		{"backfill", obs.backfillCount.Load()},
+		{"backfill_capped", obs.backfillCappedCount.Load()},
+		{"backfill_discounted", obs.backfillDiscountedCount.Load()},
+		{"honeypot", obs.honeypotCount.Load()},
+		{"url_not_allowed", obs.urlNotAllowedCount.Load()},
+		{"cache_hits", obs.rankCacheHits.Load()},
+		{"cache_misses", obs.rankCacheMisses.Load()},
+		{"banned", obs.bannedCount.Load()},
+		{"oversized", obs.oversizedCount.Load()},
+		{"writes_closed", obs.writesClosedCount.Load()},
+		{"too_many_filters", obs.tooManyFiltersCount.Load()},
+		{"too_many_subscriptions", obs.tooManySubscriptionsCount.Load()},
+		{"req_rate_limited", obs.reqRateLimitedCount.Load()},
+		{"thread_fetched", obs.threadFetchedCount.Load()},
+		{"thread_missed", obs.threadMissedCount.Load()},
+		{"thread_dropped", obs.threadDroppedCount.Load()},
+		{"mirror_published", obs.mirrorPublishedCount.Load()},
+		{"mirror_failed", obs.mirrorFailedCount.Load()},
+		{"mirror_dropped", obs.mirrorDroppedCount.Load()},
+		{"compacted_events", obs.compactedCount.Load()},
+		{"shadow_banned", obs.shadowBannedCount.Load()},
+		{"onboarding_probation", obs.onboardingProbationCount.Load()},
+		{"onboarding_members", obs.onboardingMemberCount.Load()},
+		{"onboarding_demoted", obs.onboardingDemotedCount.Load()},
+		{"peer_events", obs.peerEventCount.Load()},
+		{"suspect", obs.suspectCount.Load()},
+		{"pow_required", obs.powRequiredCount.Load()},
+		{"pow_paid", obs.powPaidCount.Load()},
+		{"payments_invoiced", obs.paymentsInvoicedCount.Load()},
+		{"payments_settled", obs.paymentsSettledCount.Load()},
+		{"adaptive_tightened", obs.adaptiveTightenedCount.Load()},
+		{"hellthread", obs.hellthreadCount.Load()},
+		{"entity_spam", obs.entitySpamCount.Load()},
+		{"duplicate_content", obs.duplicateContentCount.Load()},
+		{"low_quality", obs.lowQualityCount.Load()},
+		{"repost_rejected", obs.repostRejectedCount.Load()},
+		{"invalid_zap", obs.invalidZapCount.Load()},
+		{"longform_rejected", obs.longformRejectedCount.Load()},
+		{"file_rejected", obs.fileRejectedCount.Load()},
+		{"invalid_approval", obs.invalidApprovalCount.Load()},
+		{"store_full", obs.storeFullCount.Load()},
+		{"coalesced_notices", obs.coalescedNoticeCount.Load()},
+		{"paced_frames", obs.pacedFrameCount.Load()},
+		{"live_delayed", obs.liveDelayedCount.Load()},
+		{"live_overflow", obs.liveOverflowCount.Load()},
+		{"closed_subscriptions", obs.closedSubscriptionCount.Load()},
+		{"events_saved", obs.savedCount.Load()},
+		{"events_deleted", obs.deletedEventCount.Load()},
+		{"deleted_resubmitted", obs.deletedResubmitCount.Load()},
+		{"store_errors", obs.storeErrorCount.Load()},
+		{"store_degraded", obs.storeDegraded.Load()},
+		{"active_connections", uint64(max(obs.activeConnections.Load(), 0))},
+		{"connections_rejected", obs.connectionsRejectedCount.Load()},
+		{"connections_reserved", obs.connectionsReservedCount.Load()},
+		{"connections_deferred", obs.connectionsDeferredCount.Load()},
+		{"relatr_connected", obs.relatrConnected.Load()},
+		{"relatr_connects", obs.relatrConnects.Load()},
+		{"relatr_connect_failures", obs.relatrConnectFailures.Load()},
+		{"relatr_ping_failures", obs.relatrPingFailures.Load()},
+		{"rank_cache_size", uint64(cache.Size)},
+		{"rank_cache_stale", uint64(cache.Stale)},
+		{"rank_cache_evictions", obs.rankCacheEvictions.Load()},
+		{"rank_refresh_queue", uint64(cache.Queued)},
+		{"rank_refresh_dropped", obs.rankRefreshDropped.Load()},
+		{"rank_prefetched", obs.rankPrefetched.Load()},
+		{"rank_refresh_spilled", obs.rankRefreshSpilled.Load()},
+		{"rank_refresh_spill_queue", uint64(cache.Spilled)},
+		{"rank_grace_hits", obs.rankGraceHits.Load()},
+		{"decisions_dropped", obs.decisionsDropped.Load()},
+		{"live_streamed", obs.liveStreamedCount.Load()},
+		{"live_dropped", obs.liveDroppedCount.Load()},
+		{"feedback", obs.feedbackCount.Load()},
+		{"digest_members", obs.digestMembersCount.Load()},
+		{"candidate_evaluated", obs.candidateEvaluatedCount.Load()},
+		{"candidate_differed", obs.candidateDifferedCount.Load()},
+		{"blocklist_blocked", obs.blocklistBlockedCount.Load()},
+		{"blocklist_withheld", obs.blocklistWithheldCount.Load()},
+		{"blocklist_purged", obs.blocklistPurgedCount.Load()},
+		{"limiter_buckets", uint64(limiters.Buckets)},
+		{"limiter_buckets_created", limiters.Created},
+		{"limiter_buckets_cleaned", limiters.Cleaned},
+	}
+}
+
+// metricAttrs returns metrics as log attributes, one per metric.
+func metricAttrs(metrics []Metric) []any {
+	attrs := make([]any, 0, len(metrics))
+	for _, m := range metrics {
+		attrs = append(attrs, slog.Uint64(m.Name, m.Value))
+	}
+	return attrs
+}
+
+// logObservability prints current counter values for debugging/monitoring.
+// In cluster mode it also prints the counters summed over all nodes.
+func logObservability(ctx context.Context, obs *Observability, cluster *Cluster) {
+	relayLog.Info("observability", metricAttrs(obs.Snapshot())...)
+
+	if cluster != nil {
+		if totals, nodes, err := cluster.Metrics(ctx); err == nil {
+			relayLog.Info("cluster observability", append([]any{"nodes", nodes}, metricAttrs(totals)...)...)
+		} else {
+			relayLog.Error("cluster: failed to aggregate metrics", "error", err)
+		}
+	}
 }
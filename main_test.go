@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+func TestMarshalRelayInfo(t *testing.T) {
+	cfg := Config{RelayName: "wotrlay", ReqMaxFilters: 10, EventMaxSize: 1000, EventMaxTags: 50, EventMaxContentLength: 500, MaxFutureSeconds: 86400}
+
+	var doc map[string]any
+	if err := json.Unmarshal(marshalRelayInfo(cfg, createRelayInfoDocument(cfg)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["name"] != "wotrlay" {
+		t.Errorf("name: got %v", doc["name"])
+	}
+	limitation, ok := doc["limitation"].(map[string]any)
+	if !ok || limitation["max_filters"] != float64(10) || limitation["restricted_writes"] != true {
+		t.Errorf("limitation: got %v", doc["limitation"])
+	}
+	if limitation["max_message_length"] != float64(1010) || limitation["max_event_tags"] != float64(50) || limitation["max_content_length"] != float64(500) {
+		t.Errorf("event limits: got %v", doc["limitation"])
+	}
+
+	cfg.ReqMaxFilters, cfg.EventMaxSize, cfg.EventMaxTags, cfg.EventMaxContentLength = 0, 0, 0, 0
+	doc = nil
+	if err := json.Unmarshal(marshalRelayInfo(cfg, createRelayInfoDocument(cfg)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	limitation = doc["limitation"].(map[string]any)
+	if len(limitation) != 5 || limitation["max_message_length"] != float64(maxMessageSize) || limitation["created_at_upper_limit"] != float64(86400) ||
+		limitation["auth_required"] != false || limitation["payment_required"] != false || limitation["restricted_writes"] != true {
+		t.Errorf("only the websocket limits expected without limits, got %v", doc["limitation"])
+	}
+	if _, ok := doc["fees"]; ok {
+		t.Errorf("fees: got %v", doc["fees"])
+	}
+	if _, ok := doc["retention"]; ok {
+		t.Errorf("retention: got %v", doc["retention"])
+	}
+}
+
+func TestMarshalRelayInfoFees(t *testing.T) {
+	cfg, err := buildConfig(func(key string) string {
+		return map[string]string{
+			"PAYMENTS_URL":      "https://example.com/pay",
+			"PAYMENT_REQUIRED":  "true",
+			"FEES_ADMISSION":    "1000",
+			"FEES_SUBSCRIPTION": "30:5000",
+			"FEES_PUBLICATION":  "4:100,1059:100,1:10",
+		}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc relayInformation
+	if err := json.Unmarshal(marshalRelayInfo(cfg, createRelayInfoDocument(cfg)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.PaymentsURL != "https://example.com/pay" || !doc.Limitation.PaymentRequired {
+		t.Errorf("expected payments to be required at PAYMENTS_URL, got %q, %v", doc.PaymentsURL, doc.Limitation.PaymentRequired)
+	}
+	want := relayFees{
+		Admission:    []relayFee{{Amount: 1000, Unit: "msats"}},
+		Subscription: []relayFee{{Amount: 5000, Unit: "msats", Period: 30 * 86400}},
+		Publication:  []relayFee{{Kinds: []int{1}, Amount: 10, Unit: "msats"}, {Kinds: []int{4, 1059}, Amount: 100, Unit: "msats"}},
+	}
+	if !reflect.DeepEqual(*doc.Fees, want) {
+		t.Errorf("fees: expected %+v, got %+v", want, *doc.Fees)
+	}
+
+	for _, value := range []string{"30", "a:1", "30:-1"} {
+		if _, err := buildConfig(func(key string) string {
+			if key == "FEES_SUBSCRIPTION" {
+				return value
+			}
+			return ""
+		}); err == nil {
+			t.Errorf("FEES_SUBSCRIPTION=%s: expected an error", value)
+		}
+	}
+}
+
+func TestMarshalRelayInfoRetention(t *testing.T) {
+	cfg := Config{
+		RetentionDays:    30,
+		RetentionKinds:   map[int]int{0: 365, 1: 90, 7: 90, 4: 0},
+		StoreMaxEvents:   1000000,
+		MaxEventAgeHours: map[Tier]int{TierLow: 24, TierMid: 48, TierHigh: 72},
+	}
+
+	var doc relayInformation
+	if err := json.Unmarshal(marshalRelayInfo(cfg, createRelayInfoDocument(cfg)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	days := func(n int64) *int64 { n *= 86400; return &n }
+	want := []relayRetention{
+		{Kinds: []int{3, 4, 10002, 10040, 30382}},
+		{Kinds: []int{1, 7}, Time: days(90)},
+		{Kinds: []int{0}, Time: days(365)},
+		{Time: days(30), Count: 1000000},
+	}
+	if !reflect.DeepEqual(doc.Retention, want) {
+		t.Errorf("retention: expected %+v, got %+v", want, doc.Retention)
+	}
+	if doc.Limitation.CreatedAtLowerLimit != 72*3600 {
+		t.Errorf("created_at_lower_limit: expected the largest age limit, got %d", doc.Limitation.CreatedAtLowerLimit)
+	}
+
+	// A tier without an age limit accepts events of any age
+	cfg.MaxEventAgeHours[TierHigh] = 0
+	cfg.RetentionDays = 0
+	cfg.RetentionKinds = nil
+	doc = relayInformation{}
+	if err := json.Unmarshal(marshalRelayInfo(cfg, createRelayInfoDocument(cfg)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Limitation.CreatedAtLowerLimit != 0 {
+		t.Errorf("created_at_lower_limit: expected none, got %d", doc.Limitation.CreatedAtLowerLimit)
+	}
+	if want := []relayRetention{{Count: 1000000}}; !reflect.DeepEqual(doc.Retention, want) {
+		t.Errorf("retention: expected %+v, got %+v", want, doc.Retention)
+	}
+}
+
+func TestWantsRelayInfo(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"application/nostr+json":                          true,
+		"Application/Nostr+JSON":                          true,
+		"application/nostr+json, */*;q=0.8":               true,
+		"application/nostr+json;q=0.9, application/json":  true,
+		"text/html;q=0.5, application/nostr+json;q=0.5":   true,
+		"text/html, application/nostr+json;q=0.9":         false,
+		"text/*;q=0.3, */*, application/nostr+json;q=0.5": true,
+		"application/nostr+json;q=0":                      false,
+		"text/html,application/xhtml+xml,*/*;q=0.8":       false,
+		"*/*": false,
+		"":    false,
+	} {
+		if got := wantsRelayInfo(accept); got != want {
+			t.Errorf("%q: expected %v, got %v", accept, want, got)
+		}
+	}
+}
+
+func TestIsTooOld(t *testing.T) {
+	cfg := Config{MaxEventAgeHours: map[Tier]int{TierLow: 48}}
+	now := time.Now()
+
+	tests := []struct {
+		age  time.Duration
+		tier Tier
+		want bool
+	}{
+		{time.Hour, TierLow, false},
+		{47 * time.Hour, TierLow, false},
+		{49 * time.Hour, TierLow, true},
+		{365 * 24 * time.Hour, TierMid, false},
+		{365 * 24 * time.Hour, TierHigh, false},
+	}
+	for _, tt := range tests {
+		if got := isTooOld(now.Add(-tt.age), now, tt.tier, cfg); got != tt.want {
+			t.Errorf("%v old, %s tier: got %t, want %t", tt.age, tt.tier, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCreatedAt(t *testing.T) {
+	cfg := Config{MaxFutureSeconds: 60, ClockSkewSeconds: 600}
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		ahead   time.Duration
+		want    time.Time
+		wantErr error
+	}{
+		{-time.Hour, now.Add(-time.Hour), nil},
+		{time.Minute, now.Add(time.Minute), nil},
+		{5 * time.Minute, now, nil},
+		{11 * time.Minute, now, nil},
+		{12 * time.Minute, now.Add(12 * time.Minute), ErrInvalidTimestamp},
+	}
+	for _, tt := range tests {
+		obs := &Observability{}
+		e := &nostr.Event{CreatedAt: nostr.Timestamp(now.Add(tt.ahead).Unix())}
+		got, err := checkCreatedAt(e, now, cfg, obs)
+		if !got.Equal(tt.want) || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v ahead: got %v, %v, want %v, %v", tt.ahead, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// Without the tolerance, anything past the limit is rejected
+	obs := &Observability{}
+	e := &nostr.Event{CreatedAt: nostr.Timestamp(now.Add(5 * time.Minute).Unix())}
+	if _, err := checkCreatedAt(e, now, Config{MaxFutureSeconds: 60}, obs); !errors.Is(err, ErrInvalidTimestamp) || obs.invalidTimestampCount.Load() != 1 {
+		t.Errorf("expected the event to be rejected, got %v", err)
+	}
+}
+
+// authedClient is a client authenticated (NIP-42) with pubkeys.
+type authedClient struct {
+	rely.Client
+	pubkeys []string
+}
+
+func (c authedClient) Pubkeys() []string { return c.pubkeys }
+
+func TestIsTrustedPeer(t *testing.T) {
+	peer := strings.Repeat("ab", 32)
+	cfg := Config{TrustedPeers: []string{peer}}
+
+	if !isTrustedPeer(authedClient{pubkeys: []string{strings.Repeat("cd", 32), peer}}, cfg) {
+		t.Error("a client authenticated with a peer key should be trusted")
+	}
+	if isTrustedPeer(authedClient{}, cfg) || isTrustedPeer(authedClient{pubkeys: []string{strings.Repeat("cd", 32)}}, cfg) {
+		t.Error("other clients should not be trusted")
+	}
+	if isTrustedPeer(authedClient{pubkeys: []string{peer}}, Config{}) {
+		t.Error("no client should be trusted without TRUSTED_PEERS")
+	}
+}
+
+func TestTrustedPeersConfig(t *testing.T) {
+	peer := strings.Repeat("ab", 32)
+	tests := []struct {
+		env   map[string]string
+		valid bool
+	}{
+		{map[string]string{"TRUSTED_PEERS": peer, "RELAY_DOMAIN": "relay.example.com"}, true},
+		{map[string]string{"TRUSTED_PEERS": peer}, false},
+		{map[string]string{"TRUSTED_PEERS": "npub1xyz", "RELAY_DOMAIN": "relay.example.com"}, false},
+	}
+
+	for _, test := range tests {
+		_, err := buildConfig(func(key string) string { return test.env[key] })
+		if (err == nil) != test.valid {
+			t.Errorf("%v: got error %v", test.env, err)
+		}
+	}
+}
+
+func TestOperatorRank(t *testing.T) {
+	allowed, overridden := strings.Repeat("ab", 32), strings.Repeat("cd", 32)
+	cfg, err := buildConfig(func(key string) string {
+		return map[string]string{
+			"ALLOWED_PUBKEYS": allowed,
+			"RANK_OVERRIDES":  allowed + ":0.1," + overridden + ":0.3",
+		}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The allowlist takes precedence over overrides
+	if rank, ok := operatorRank(allowed, cfg); !ok || rank != 1 {
+		t.Errorf("allowed pubkey: got %v, %v", rank, ok)
+	}
+	if rank, ok := operatorRank(overridden, cfg); !ok || rank != 0.3 {
+		t.Errorf("overridden pubkey: got %v, %v", rank, ok)
+	}
+	if _, ok := operatorRank(strings.Repeat("ef", 32), cfg); ok {
+		t.Error("other pubkeys should be left to the rank provider")
+	}
+
+	if _, err := buildConfig(func(key string) string {
+		return map[string]string{"ALLOWED_PUBKEYS": "npub1xyz"}[key]
+	}); err == nil {
+		t.Error("expected ALLOWED_PUBKEYS to only accept hex pubkeys")
+	}
+}
+
+// ipClient is a client connected from an IP address.
+type ipClient struct {
+	rely.Client
+	ip string
+}
+
+func (c ipClient) IP() rely.IP { return rely.IP{Raw: net.ParseIP(c.ip)} }
+
+func TestIPGroupBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"IP_GROUP_DAILY_RATE": "72"}[key] // 3 events of capacity
+	})
+	obs := &Observability{}
+	db := &badger.BadgerBackend{Path: t.TempDir()}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+
+	publishAs := func(sk, ip string, trusted bool) error {
+		pubkey, _ := nostr.GetPublicKey(sk)
+		if trusted {
+			cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0.9})
+		} else {
+			cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0})
+		}
+		e := nostr.Event{CreatedAt: nostr.Now(), Kind: 1, Content: "hello from " + pubkey}
+		e.Sign(sk)
+		return handleEvent(ctx, ipClient{ip: ip}, &e, cfg, d)
+	}
+	publish := func(ip string, trusted bool) error {
+		return publishAs(nostr.GeneratePrivateKey(), ip, trusted)
+	}
+
+	for i := range 3 {
+		if err := publish("2001:db8::1", false); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	if err := publish("2001:db8::2", false); !errors.Is(err, ErrIPRateLimited) {
+		t.Errorf("a fresh pubkey from the same /64: got %v, want %v", err, ErrIPRateLimited)
+	}
+	if err := publish("2001:db8::1", true); err != nil {
+		t.Errorf("trusted pubkeys are not subject to the IP group budget: %v", err)
+	}
+	if err := publish("2001:db8:1::1", false); err != nil {
+		t.Errorf("other IP groups have their own budget: %v", err)
+	}
+
+	// An event refused by the IP group budget doesn't cost its author tokens
+	sk := nostr.GeneratePrivateKey()
+	if err := publishAs(sk, "2001:db8::3", false); !errors.Is(err, ErrIPRateLimited) {
+		t.Fatalf("a fresh pubkey from the same /64: got %v, want %v", err, ErrIPRateLimited)
+	}
+	if err := publishAs(sk, "2001:db8:2::1", false); err != nil {
+		t.Errorf("the pubkey's budget should be untouched by the refused event: %v", err)
+	}
+}
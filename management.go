@@ -0,0 +1,362 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// managementContentType is the content type of NIP-86 requests.
+	managementContentType = "application/nostr+json+rpc"
+
+	// kindHTTPAuth is the kind of NIP-98 HTTP authorization events.
+	kindHTTPAuth = 27235
+
+	// httpAuthWindow is how far the timestamp of a NIP-98 event may be from now.
+	httpAuthWindow = time.Minute
+)
+
+var (
+	ErrUnsupportedMethod = errors.New("unsupported method")
+	ErrInvalidParams     = errors.New("invalid params")
+)
+
+// managementMethods are the NIP-86 methods served by the relay. Rank overrides and
+// stats are specific to wotrlay.
+var managementMethods = []string{
+	"supportedmethods",
+	"banpubkey",
+	"allowpubkey",
+	"listbannedpubkeys",
+	"listallowedpubkeys",
+	"setrankoverride",
+	"listrankoverrides",
+	"stats",
+}
+
+// managementRequest is the body of a NIP-86 request.
+type managementRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// managementResponse is the body of a NIP-86 response.
+type managementResponse struct {
+	Result any    `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pubkeyReason is an entry of the listbannedpubkeys and listallowedpubkeys results.
+type pubkeyReason struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// pubkeyRank is an entry of the listrankoverrides result.
+type pubkeyRank struct {
+	Pubkey string  `json:"pubkey"`
+	Rank   float64 `json:"rank"`
+}
+
+// Management applies the changes made through the NIP-86 management API. Bans and rank
+// overrides go through the relay's Settings, so they are persisted like the changes of
+// the config editor; the reasons given for them are only kept in memory.
+type Management struct {
+	mu      sync.Mutex
+	reasons map[string]string // by pubkey
+}
+
+func NewManagement() *Management {
+	return &Management{reasons: make(map[string]string)}
+}
+
+// managementHandler serves NIP-86 requests POSTed to the root of the relay, and reports
+// whether the request was for it. Requests must carry a NIP-98 authorization from one
+// of the AdminPubkeys.
+func managementHandler(w http.ResponseWriter, r *http.Request, root string, cfg Config, d *Deps) bool {
+	if len(cfg.AdminPubkeys) == 0 || r.URL.Path != root || r.Method != http.MethodPost ||
+		r.Header.Get("Content-Type") != managementContentType {
+		return false
+	}
+	w.Header().Set("Content-Type", managementContentType)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return true
+	}
+
+	admin, err := verifyHTTPAuth(r, body, d.now())
+	if err == nil && !slices.Contains(cfg.AdminPubkeys, admin) {
+		err = errors.New("pubkey is not an admin of this relay")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(managementResponse{Error: "unauthorized: " + err.Error()})
+		return true
+	}
+
+	var req managementRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(managementResponse{Error: "invalid request: " + err.Error()})
+		return true
+	}
+
+	result, err := d.Management.Call(req, cfg, d)
+	if err != nil {
+		json.NewEncoder(w).Encode(managementResponse{Error: err.Error()})
+		return true
+	}
+	json.NewEncoder(w).Encode(managementResponse{Result: result})
+	return true
+}
+
+// verifyHTTPAuth checks the NIP-98 authorization of the request and returns its pubkey.
+// The event must be signed, recent, and bound to the request's URL, method and body.
+func verifyHTTPAuth(r *http.Request, body []byte, now time.Time) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Nostr ")
+	if !ok {
+		return "", errors.New("missing Nostr authorization")
+	}
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", errors.New("authorization is not base64")
+	}
+
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", errors.New("authorization is not an event")
+	}
+	if event.Kind != kindHTTPAuth {
+		return "", fmt.Errorf("authorization event must be of kind %d", kindHTTPAuth)
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		return "", errors.New("invalid signature")
+	}
+	if delta := now.Sub(event.CreatedAt.Time()); delta > httpAuthWindow || delta < -httpAuthWindow {
+		return "", errors.New("authorization event is too old or in the future")
+	}
+
+	// Behind proxies the scheme seen by the relay may differ, so only host and path are compared
+	u, err := url.Parse(event.Tags.GetFirst([]string{"u", ""}).Value())
+	if err != nil || u.Host != r.Host || strings.TrimSuffix(u.Path, "/") != strings.TrimSuffix(r.URL.Path, "/") {
+		return "", errors.New("authorization is for another URL")
+	}
+	if method := event.Tags.GetFirst([]string{"method", ""}); method == nil || method.Value() != r.Method {
+		return "", errors.New("authorization is for another method")
+	}
+	hash := sha256.Sum256(body)
+	if payload := event.Tags.GetFirst([]string{"payload", ""}); payload == nil || payload.Value() != hex.EncodeToString(hash[:]) {
+		return "", errors.New("authorization is for another payload")
+	}
+	return event.PubKey, nil
+}
+
+// Call runs the management method and returns its result.
+func (m *Management) Call(req managementRequest, cfg Config, d *Deps) (any, error) {
+	switch req.Method {
+	case "supportedmethods":
+		return managementMethods, nil
+
+	case "banpubkey":
+		pubkey, reason, err := pubkeyParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return true, m.update(d, pubkey, reason, func(lists *pubkeyLists) {
+			lists.Allowed = slices.DeleteFunc(lists.Allowed, func(p string) bool { return p == pubkey })
+			delete(lists.Overrides, pubkey)
+			if !slices.Contains(lists.Banned, pubkey) {
+				lists.Banned = append(lists.Banned, pubkey)
+			}
+		})
+
+	case "allowpubkey":
+		// Allowed pubkeys are unbanned and treated as high-trust
+		pubkey, reason, err := pubkeyParams(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return true, m.update(d, pubkey, reason, func(lists *pubkeyLists) {
+			lists.Banned = slices.DeleteFunc(lists.Banned, func(p string) bool { return p == pubkey })
+			if !slices.Contains(lists.Allowed, pubkey) {
+				lists.Allowed = append(lists.Allowed, pubkey)
+			}
+		})
+
+	case "listbannedpubkeys":
+		return m.list(cfg.BannedPubkeys), nil
+
+	case "listallowedpubkeys":
+		return m.list(cfg.AllowedPubkeys), nil
+
+	case "setrankoverride":
+		// params: [pubkey, rank], a null rank removes the override
+		if len(req.Params) != 2 {
+			return nil, fmt.Errorf("%w: expected [pubkey, rank]", ErrInvalidParams)
+		}
+		pubkey, _, err := pubkeyParams(req.Params[:1])
+		if err != nil {
+			return nil, err
+		}
+		var rank *float64
+		if err := json.Unmarshal(req.Params[1], &rank); err != nil || (rank != nil && (*rank < 0 || *rank > 1)) {
+			return nil, fmt.Errorf("%w: rank must be a number between 0 and 1, or null", ErrInvalidParams)
+		}
+		return true, m.SetRankOverride(d, pubkey, rank)
+
+	case "listrankoverrides":
+		return listRankOverrides(cfg), nil
+
+	case "stats":
+		metrics := make(map[string]uint64)
+		for _, metric := range d.Obs.Snapshot() {
+			metrics[metric.Name] = metric.Value
+		}
+		return metrics, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedMethod, req.Method)
+	}
+}
+
+// pubkeyLists are the operator-managed pubkey lists of a relay.
+type pubkeyLists struct {
+	Banned    []string
+	Allowed   []string
+	Overrides map[string]float64
+}
+
+// update applies the change to the pubkey lists of the relay, and records the reason given for it.
+func (m *Management) update(d *Deps, pubkey, reason string, change func(*pubkeyLists)) error {
+	if d.Settings == nil {
+		return errors.New("this relay's settings can't be edited")
+	}
+
+	// Changes are read-modify-write, so they are serialized
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg := d.Settings.Config()
+	lists := &pubkeyLists{
+		Banned:    slices.Clone(cfg.BannedPubkeys),
+		Allowed:   slices.Clone(cfg.AllowedPubkeys),
+		Overrides: maps.Clone(cfg.RankOverrides),
+	}
+	if lists.Overrides == nil {
+		lists.Overrides = make(map[string]float64)
+	}
+	change(lists)
+
+	updated, err := d.Settings.Update(map[string]string{
+		"BANNED_PUBKEYS":  strings.Join(lists.Banned, ","),
+		"ALLOWED_PUBKEYS": strings.Join(lists.Allowed, ","),
+		"RANK_OVERRIDES":  formatRankOverrides(lists.Overrides),
+	})
+	if err != nil {
+		return err
+	}
+	d.Linkage.SetBanned(updated.BannedPubkeys)
+
+	if reason != "" {
+		m.reasons[pubkey] = reason
+	} else {
+		delete(m.reasons, pubkey)
+	}
+	return nil
+}
+
+// SetRankOverride pins the rank of the pubkey, or removes its override if rank is nil.
+func (m *Management) SetRankOverride(d *Deps, pubkey string, rank *float64) error {
+	return m.update(d, pubkey, "", func(lists *pubkeyLists) {
+		if rank == nil {
+			delete(lists.Overrides, pubkey)
+		} else {
+			lists.Overrides[pubkey] = *rank
+		}
+	})
+}
+
+// listRankOverrides returns the rank overrides of cfg, sorted by pubkey.
+func listRankOverrides(cfg Config) []pubkeyRank {
+	overrides := make([]pubkeyRank, 0, len(cfg.RankOverrides))
+	for _, pubkey := range slices.Sorted(maps.Keys(cfg.RankOverrides)) {
+		overrides = append(overrides, pubkeyRank{Pubkey: pubkey, Rank: cfg.RankOverrides[pubkey]})
+	}
+	return overrides
+}
+
+// list returns the pubkeys with the reasons recorded for them.
+func (m *Management) list(pubkeys []string) []pubkeyReason {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]pubkeyReason, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		list[i] = pubkeyReason{Pubkey: pubkey, Reason: m.reasons[pubkey]}
+	}
+	return list
+}
+
+// pubkeyParams parses [pubkey] or [pubkey, reason].
+func pubkeyParams(params []json.RawMessage) (pubkey, reason string, err error) {
+	if len(params) == 0 || len(params) > 2 {
+		return "", "", fmt.Errorf("%w: expected [pubkey, reason]", ErrInvalidParams)
+	}
+	if err := json.Unmarshal(params[0], &pubkey); err != nil || !nostr.IsValid32ByteHex(pubkey) {
+		return "", "", fmt.Errorf("%w: pubkey must be hex", ErrInvalidParams)
+	}
+	if len(params) == 2 {
+		if err := json.Unmarshal(params[1], &reason); err != nil {
+			return "", "", fmt.Errorf("%w: reason must be a string", ErrInvalidParams)
+		}
+	}
+	return pubkey, reason, nil
+}
+
+// parseRankOverrides parses pubkey:rank pairs.
+func parseRankOverrides(items []string) (map[string]float64, error) {
+	overrides := make(map[string]float64, len(items))
+	for _, item := range items {
+		pubkey, value, _ := strings.Cut(item, ":")
+		rank, err := strconv.ParseFloat(value, 64)
+		if !nostr.IsValid32ByteHex(pubkey) || err != nil || math.IsNaN(rank) || rank < 0 || rank > 1 {
+			return nil, fmt.Errorf("expected pubkey:rank pairs with a hex pubkey and a rank between 0 and 1, got %q", item)
+		}
+		overrides[pubkey] = rank
+	}
+	return overrides, nil
+}
+
+// formatRankOverrides formats the overrides as sorted pubkey:rank pairs.
+func formatRankOverrides(overrides map[string]float64) string {
+	var b bytes.Buffer
+	for i, pubkey := range slices.Sorted(maps.Keys(overrides)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pubkey + ":" + strconv.FormatFloat(overrides[pubkey], 'g', -1, 64))
+	}
+	return b.String()
+}
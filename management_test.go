@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// httpAuth returns a NIP-98 Authorization header for a POST of the body to the URL.
+func httpAuth(t *testing.T, sk, url string, body []byte) string {
+	t.Helper()
+	hash := sha256.Sum256(body)
+	e := signedEvent(t, sk, kindHTTPAuth, nostr.Tags{{"u", url}, {"method", "POST"}, {"payload", hex.EncodeToString(hash[:])}})
+	data, _ := json.Marshal(e)
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestManagementHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	adminSK, strangerSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	admin, _ := nostr.GetPublicKey(adminSK)
+	spammer, friend := strings.Repeat("01", 32), strings.Repeat("02", 32)
+
+	cfg := parseConfig(func(string) string { return "" })
+	cfg.AdminPubkeys = []string{admin}
+	obs := &Observability{}
+	d := &Deps{
+		Cache:         NewRankCache(ctx, cfg, obs),
+		GlobalLimiter: NewLimiter(ctx),
+		Obs:           obs,
+		Linkage:       NewIPLinkage(ctx, nil),
+		Settings:      NewSettings(cfg, nil, func(string) string { return "" }, nil),
+		Management:    NewManagement(),
+	}
+
+	call := func(sk, method string, params ...any) (int, managementResponse) {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"method": method, "params": params})
+		r := httptest.NewRequest(http.MethodPost, "http://relay.example.com/", strings.NewReader(string(body)))
+		r.Header.Set("Content-Type", managementContentType)
+		if sk != "" {
+			r.Header.Set("Authorization", httpAuth(t, sk, "wss://relay.example.com", body))
+		}
+
+		w := httptest.NewRecorder()
+		if !managementHandler(w, r, "/", d.config(cfg), d) {
+			t.Fatal("request not handled")
+		}
+		var resp managementResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	if code, _ := call("", "supportedmethods"); code != http.StatusUnauthorized {
+		t.Errorf("request without authorization: got status %d", code)
+	}
+	if code, resp := call(strangerSK, "supportedmethods"); code != http.StatusUnauthorized || !strings.Contains(resp.Error, "not an admin") {
+		t.Errorf("request from a non-admin: got status %d, %+v", code, resp)
+	}
+	if _, resp := call(adminSK, "supportedmethods"); len(resp.Result.([]any)) != len(managementMethods) {
+		t.Errorf("supportedmethods: got %+v", resp)
+	}
+	if _, resp := call(adminSK, "deleteeverything"); !strings.Contains(resp.Error, ErrUnsupportedMethod.Error()) {
+		t.Errorf("unknown method: got %+v", resp)
+	}
+	if _, resp := call(adminSK, "banpubkey", "nobody"); !strings.Contains(resp.Error, ErrInvalidParams.Error()) {
+		t.Errorf("invalid pubkey: got %+v", resp)
+	}
+
+	if _, resp := call(adminSK, "banpubkey", spammer, "spam"); resp.Result != true || resp.Error != "" {
+		t.Fatalf("banpubkey: got %+v", resp)
+	}
+	if !d.Linkage.IsBanned(spammer) {
+		t.Error("banned pubkey should be rejected")
+	}
+	if _, resp := call(adminSK, "listbannedpubkeys"); !strings.Contains(toJSON(resp.Result), `{"pubkey":"`+spammer+`","reason":"spam"}`) {
+		t.Errorf("listbannedpubkeys: got %+v", resp.Result)
+	}
+
+	// Allowing a pubkey unbans it and treats it as high-trust
+	if _, resp := call(adminSK, "allowpubkey", spammer, "appeal accepted"); resp.Result != true {
+		t.Fatalf("allowpubkey: got %+v", resp)
+	}
+	if d.Linkage.IsBanned(spammer) || lookupRank(ctx, spammer, d.config(cfg), d) != 1 {
+		t.Error("allowed pubkey should be unbanned with rank 1")
+	}
+	if _, resp := call(adminSK, "listallowedpubkeys"); !strings.Contains(toJSON(resp.Result), spammer) {
+		t.Errorf("listallowedpubkeys: got %+v", resp.Result)
+	}
+
+	if _, resp := call(adminSK, "setrankoverride", friend, 0.7); resp.Result != true {
+		t.Fatalf("setrankoverride: got %+v", resp)
+	}
+	if rank := lookupRank(ctx, friend, d.config(cfg), d); rank != 0.7 {
+		t.Errorf("expected the overridden rank, got %v", rank)
+	}
+	if _, resp := call(adminSK, "setrankoverride", friend, 2); !strings.Contains(resp.Error, ErrInvalidParams.Error()) {
+		t.Errorf("out of range rank: got %+v", resp)
+	}
+	if _, resp := call(adminSK, "setrankoverride", friend, nil); resp.Result != true {
+		t.Fatalf("removing an override: got %+v", resp)
+	}
+	if _, resp := call(adminSK, "listrankoverrides"); toJSON(resp.Result) != `[]` {
+		t.Errorf("listrankoverrides: got %s", toJSON(resp.Result))
+	}
+	if d.Settings.Config().AllowedPubkeys[0] != spammer {
+		t.Error("allowed pubkeys should be saved as a setting")
+	}
+
+	// Banning an allowed pubkey takes it off the allowlist
+	if _, resp := call(adminSK, "banpubkey", spammer); resp.Result != true {
+		t.Fatalf("banpubkey: got %+v", resp)
+	}
+	if cfg := d.Settings.Config(); len(cfg.AllowedPubkeys) != 0 || !d.Linkage.IsBanned(spammer) {
+		t.Errorf("banned pubkey should no longer be allowed, got %v", cfg.AllowedPubkeys)
+	}
+
+	// Requests not for the management API are left to the relay
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if managementHandler(httptest.NewRecorder(), r, "/", cfg, d) {
+		t.Error("requests without the NIP-86 content type should not be handled")
+	}
+}
+
+func TestVerifyHTTPAuth(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	body := []byte(`{"method":"stats","params":[]}`)
+	request := func(auth string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "http://relay.example.com/community", nil)
+		r.Header.Set("Authorization", auth)
+		return r
+	}
+
+	if _, err := verifyHTTPAuth(request(httpAuth(t, sk, "https://relay.example.com/community", body)), body, time.Now()); err != nil {
+		t.Errorf("valid authorization: %v", err)
+	}
+	if _, err := verifyHTTPAuth(request(httpAuth(t, sk, "https://relay.example.com/other", body)), body, time.Now()); err == nil {
+		t.Error("expected an error for another URL")
+	}
+	if _, err := verifyHTTPAuth(request(httpAuth(t, sk, "https://relay.example.com/community", []byte("{}"))), body, time.Now()); err == nil {
+		t.Error("expected an error for another payload")
+	}
+	if _, err := verifyHTTPAuth(request(httpAuth(t, sk, "https://relay.example.com/community", body)), body, time.Now().Add(2*time.Minute)); err == nil {
+		t.Error("expected an error for a stale authorization")
+	}
+}
+
+func TestParseRankOverrides(t *testing.T) {
+	pubkey := strings.Repeat("ab", 32)
+	overrides, err := parseRankOverrides([]string{pubkey + ":0.5"})
+	if err != nil || overrides[pubkey] != 0.5 {
+		t.Errorf("got %v, %v", overrides, err)
+	}
+	if formatRankOverrides(overrides) != pubkey+":0.5" {
+		t.Errorf("unexpected format %q", formatRankOverrides(overrides))
+	}
+	for _, invalid := range []string{pubkey, pubkey + ":2", "nobody:0.5", pubkey + ":NaN"} {
+		if _, err := parseRankOverrides([]string{invalid}); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+}
+
+func toJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
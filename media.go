@@ -0,0 +1,206 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// kindFileMetadata is the NIP-94 file metadata kind (also used by NIP-96 servers).
+const kindFileMetadata = 1063
+
+// mediaDownloaders is how many files are downloaded concurrently for hash verification.
+const mediaDownloaders = 2
+
+// ErrFilePending is returned for file metadata whose file hasn't been verified yet. It is
+// downloaded in the background, so a retry shortly after is checked without waiting on it.
+var ErrFilePending = errors.New("error: verifying the file, please retry shortly")
+
+// MediaPolicy enforces the file metadata policy and verifies file hashes
+// against the referenced hosts.
+//
+// File URLs come from events anyone can publish, so files are only downloaded over HTTPS
+// from public addresses, and never while an event waits: file metadata for a file that
+// isn't verified yet is refused with ErrFilePending while it is downloaded in the background.
+type MediaPolicy struct {
+	client *http.Client
+
+	// verified caches file URL -> sha256 hex of its content
+	verified *expirable.LRU[string, string]
+	// failed caches the file URLs that couldn't be downloaded, so they aren't downloaded again for every event
+	failed *expirable.LRU[string, error]
+
+	mu      sync.Mutex
+	pending map[string]bool // file URLs queued or being downloaded
+	queue   chan mediaDownload
+}
+
+// mediaDownload is a file queued for hash verification.
+type mediaDownload struct {
+	url     string
+	maxSize int64
+}
+
+func NewMediaPolicy(ctx context.Context) *MediaPolicy {
+	m := &MediaPolicy{
+		client:   newPublicClient(30 * time.Second),
+		verified: expirable.NewLRU[string, string](10000, nil, 24*time.Hour),
+		failed:   expirable.NewLRU[string, error](10000, nil, 10*time.Minute),
+		pending:  make(map[string]bool),
+		queue:    make(chan mediaDownload, 1000),
+	}
+	for range mediaDownloaders {
+		go m.downloader(ctx)
+	}
+	return m
+}
+
+// Check enforces the file metadata policy: a minimum trust tier, an allowlist of
+// media hosts, per-tier daily quotas and (optionally) hash verification. The quota is
+// only charged for accepted files.
+func (m *MediaPolicy) Check(ctx context.Context, e *nostr.Event, tier Tier, cfg Config, d *Deps) error {
+	if tier < cfg.FileMinTier {
+		return ErrFileNotAllowed
+	}
+
+	fileURL := e.Tags.Find("url")
+	hash := e.Tags.Find("x")
+	if fileURL == nil || hash == nil {
+		return fmt.Errorf("%w: missing url or x tag", ErrInvalidFile)
+	}
+
+	if len(cfg.FileAllowedHosts) > 0 && !isAllowedMediaHost(fileURL[1], cfg.FileAllowedHosts) {
+		return ErrFileHostNotAllowed
+	}
+
+	quota := "file:" + e.PubKey
+	dailyCap := cfg.FileDailyCaps[tier]
+	if dailyCap > 0 && d.Limiter.Peek(quota, dailyCap, dailyCap/secondsPerDay) < 1 {
+		return ErrFileLimited
+	}
+
+	if cfg.FileVerifyHash {
+		if err := m.verifyHash(fileURL[1], hash[1], cfg.FileMaxSize); err != nil {
+			return err
+		}
+	}
+
+	if !d.Limiter.AllowDaily(quota, dailyCap) {
+		return ErrFileLimited
+	}
+	return nil
+}
+
+// verifyHash compares the sha256 of the file with the expected hash, or returns
+// ErrFilePending and queues the file for download if it isn't known yet.
+func (m *MediaPolicy) verifyHash(fileURL, expected string, maxSize int64) error {
+	expected = strings.ToLower(expected)
+	if actual, ok := m.verified.Get(fileURL); ok {
+		if actual != expected {
+			return ErrFileHashMismatch
+		}
+		return nil
+	}
+	if err, ok := m.failed.Get(fileURL); ok {
+		return err
+	}
+
+	u, err := url.Parse(fileURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: file url must be https to be verified", ErrInvalidFile)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.pending[fileURL] {
+		select {
+		case m.queue <- mediaDownload{url: fileURL, maxSize: maxSize}:
+			m.pending[fileURL] = true
+		default:
+		}
+	}
+	return ErrFilePending
+}
+
+// downloader downloads the queued files, caching their hash or failure.
+func (m *MediaPolicy) downloader(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case file := <-m.queue:
+			actual, err := m.download(ctx, file.url, file.maxSize)
+			if err != nil {
+				eventLog.DebugContext(ctx, "failed to download file", "url", file.url, "error", err)
+				m.failed.Add(file.url, err)
+			} else {
+				m.verified.Add(file.url, actual)
+			}
+
+			m.mu.Lock()
+			delete(m.pending, file.url)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// download fetches the file (up to maxSize bytes) and returns the sha256 hex of its content.
+func (m *MediaPolicy) download(ctx context.Context, fileURL string, maxSize int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad url", ErrInvalidFile)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to fetch file", ErrInvalidFile)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: file host returned status %d", ErrInvalidFile, resp.StatusCode)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to read file", ErrInvalidFile)
+	}
+	if n > maxSize {
+		return "", fmt.Errorf("%w: file is too large to verify", ErrInvalidFile)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isAllowedMediaHost reports whether the URL uses https (or http) and its host is
+// one of the allowed hosts or a subdomain of one.
+func isAllowedMediaHost(rawURL string, allowed []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if host == a || strings.HasSuffix(host, "."+a) {
+			return true
+		}
+	}
+	return false
+}
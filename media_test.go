@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsAllowedMediaHost(t *testing.T) {
+	allowed := []string{"nostr.build", "void.cat"}
+
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{url: "https://nostr.build/i/abc.jpg", expected: true},
+		{url: "https://image.nostr.build/abc.jpg", expected: true},
+		{url: "https://VOID.CAT/d/abc", expected: true},
+		{url: "https://evilnostr.build/abc.jpg", expected: false},
+		{url: "https://example.com/abc.jpg", expected: false},
+		{url: "ftp://nostr.build/abc.jpg", expected: false},
+		{url: "not a url", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := isAllowedMediaHost(tt.url, allowed); got != tt.expected {
+				t.Errorf("isAllowedMediaHost(%q) = %v, want %v", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMediaPolicyVerifyHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	content := []byte("hello file")
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	m := NewMediaPolicy(ctx)
+	m.client = server.Client() // the test server listens on loopback
+	verify := func(fileURL, expected string, maxSize int64) error {
+		t.Helper()
+		if err := m.verifyHash(fileURL, expected, maxSize); !errors.Is(err, ErrFilePending) {
+			t.Fatalf("expected %s to be downloaded in the background, got %v", fileURL, err)
+		}
+		for {
+			if err := m.verifyHash(fileURL, expected, maxSize); !errors.Is(err, ErrFilePending) {
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := verify(server.URL+"/file", hash, 1024); err != nil {
+		t.Errorf("expected matching hash to verify, got %v", err)
+	}
+	if err := m.verifyHash(server.URL+"/file", "00"+hash[2:], 1024); !errors.Is(err, ErrFileHashMismatch) {
+		t.Errorf("expected hash mismatch, got %v", err)
+	}
+	if err := verify(server.URL+"/other", hash, 4); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("expected oversized file to fail verification, got %v", err)
+	}
+	if err := m.verifyHash("http://example.com/file", hash, 1024); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("expected a plain http file to be refused, got %v", err)
+	}
+}
+
+func TestMediaPolicyQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"FILE_VERIFY_HASH": "true", "FILE_DAILY_CAP_MID": "1"}[key]
+	})
+	d := &Deps{Limiter: NewLimiter(ctx)}
+	m := NewMediaPolicy(ctx)
+	m.verified.Add("https://files.example.com/good", strings.Repeat("ab", 32))
+	m.failed.Add("https://files.example.com/gone", fmt.Errorf("%w: failed to fetch file", ErrInvalidFile))
+
+	file := func(url string) *nostr.Event {
+		return &nostr.Event{Kind: kindFileMetadata, PubKey: "author", Tags: nostr.Tags{{"url", url}, {"x", strings.Repeat("ab", 32)}}}
+	}
+
+	// Files that fail verification don't use up the quota
+	if err := m.Check(ctx, file("https://files.example.com/gone"), TierMid, cfg, d); !errors.Is(err, ErrInvalidFile) {
+		t.Fatalf("expected the unreachable file to be refused, got %v", err)
+	}
+	if err := m.Check(ctx, file("https://files.example.com/good"), TierMid, cfg, d); err != nil {
+		t.Fatalf("expected the verified file to be accepted, got %v", err)
+	}
+	if err := m.Check(ctx, file("https://files.example.com/good"), TierMid, cfg, d); !errors.Is(err, ErrFileLimited) {
+		t.Errorf("expected the quota to be used up, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metricsPrefix namespaces the metrics exposed to Prometheus.
+const metricsPrefix = "wotrlay_"
+
+// gaugeMetrics are the observability metrics that measure a current level.
+// Every other metric is a counter, exposed with a "_total" suffix.
+var gaugeMetrics = map[string]bool{
+	"active_connections":       true,
+	"relatr_connected":         true,
+	"rank_cache_size":          true,
+	"rank_cache_stale":         true,
+	"rank_refresh_queue":       true,
+	"rank_refresh_spill_queue": true,
+	"limiter_buckets":          true,
+	"store_degraded":           true,
+}
+
+// metricsHandler serves the observability counters in the Prometheus text format.
+// If token is set, requests must carry it as a bearer token.
+func metricsHandler(obs *Observability, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" {
+			bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writePrometheus(w, obs.counters(), obs.Labeled())
+	}
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition format, the labeled
+// ones as one family each. A family replaces the metric of the same name, which it splits:
+// rate_limited is the sum of the rate_limited of each tier.
+func writePrometheus(w io.Writer, metrics []Metric, labeled []LabeledMetric) {
+	var families []string
+	series := make(map[string][]LabeledMetric)
+	for _, m := range labeled {
+		if _, ok := series[m.Family]; !ok {
+			families = append(families, m.Family)
+		}
+		series[m.Family] = append(series[m.Family], m)
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		if _, ok := series[m.Name]; ok {
+			continue
+		}
+		name := writeMetricType(&b, m.Name)
+		b.WriteString(name + " " + strconv.FormatUint(m.Value, 10) + "\n")
+	}
+	for _, family := range families {
+		name := writeMetricType(&b, family)
+		for _, m := range series[family] {
+			b.WriteString(name + "{" + m.Label + "} " + strconv.FormatUint(m.Value, 10) + "\n")
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+// writeMetricType writes the TYPE line of the metric, and returns its exposed name.
+func writeMetricType(b *strings.Builder, metric string) string {
+	name, kind := metricsPrefix+metric, "gauge"
+	if !gaugeMetrics[metric] {
+		name, kind = name+"_total", "counter"
+	}
+	b.WriteString("# TYPE " + name + " " + kind + "\n")
+	return name
+}
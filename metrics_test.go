@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	obs := &Observability{}
+	obs.rateLimitedCount.Add(3)
+	obs.rateLimited[TierLow].Add(3)
+	obs.rateAllowed[TierMid].Add(4)
+	obs.kinds.Record(1, true)
+	obs.savedCount.Add(2)
+	obs.activeConnections.Add(5)
+
+	w := httptest.NewRecorder()
+	metricsHandler(obs, "")(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	for _, line := range []string{
+		"# TYPE wotrlay_rate_limited_total counter\nwotrlay_rate_limited_total{tier=\"low\"} 3\nwotrlay_rate_limited_total{tier=\"mid\"} 0\n",
+		"# TYPE wotrlay_rate_allowed_total counter\nwotrlay_rate_allowed_total{tier=\"low\"} 0\nwotrlay_rate_allowed_total{tier=\"mid\"} 4\n",
+		"# TYPE wotrlay_kind_accepted_total counter\nwotrlay_kind_accepted_total{kind=\"0\"} 0\nwotrlay_kind_accepted_total{kind=\"1\"} 1\n",
+		"wotrlay_kind_rejected_total{kind=\"other\"} 0\n",
+		"# TYPE wotrlay_cache_hits_total counter\nwotrlay_cache_hits_total 0\n",
+		"wotrlay_events_saved_total 2\n",
+		"# TYPE wotrlay_active_connections gauge\nwotrlay_active_connections 5\n",
+		"# TYPE wotrlay_rank_cache_size gauge\n",
+		"# TYPE wotrlay_limiter_buckets gauge\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("expected %q in the metrics, got:\n%s", line, body)
+		}
+	}
+	// Labeled counters are one family each, which replaces the metric it splits
+	if n := strings.Count(body, "# TYPE wotrlay_rate_limited_total "); n != 1 {
+		t.Errorf("expected one rate_limited family, got %d", n)
+	}
+	if strings.Contains(body, "wotrlay_rate_limited_total 3") || strings.Contains(body, "rate_allowed_low") || strings.Contains(body, "kind_1_accepted") {
+		t.Errorf("expected the tiers and kinds as labels only, got:\n%s", body)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestMetricsHandlerToken(t *testing.T) {
+	handler := metricsHandler(&Observability{}, "secret")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("scrape without token: got status %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("scrape with token: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,191 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+)
+
+// metaPrefix is the key prefix under which wotrlay keeps metadata about a store,
+// such as its schema version. The event store only uses prefixes 0-8 and 255,
+// the honeypot labels 128 and the deletion tombstones 130.
+const metaPrefix byte = 129
+
+// schemaVersionKey holds the version of the last migration applied to a store.
+var schemaVersionKey = append([]byte{metaPrefix}, "schema_version"...)
+
+// ErrStoreTooNew is returned when a store was migrated by a newer version of wotrlay.
+var ErrStoreTooNew = errors.New("store was written by a newer version of wotrlay")
+
+// Migration changes the format of the data stored by wotrlay in a Badger store.
+// Migrations of a store are applied in order of version, each exactly once.
+type Migration struct {
+	Version     int
+	Description string
+	Backup      bool // whether to back up the store before applying it, for migrations rewriting data
+	Apply       func(db *badger.BadgerBackend) error
+}
+
+// eventStoreMigrations are the migrations of the relays' event stores.
+// New migrations are appended with the next version, and never changed once released.
+var eventStoreMigrations = []Migration{
+	{Version: 1, Description: "record the schema version", Apply: func(*badger.BadgerBackend) error { return nil }},
+	{Version: 2, Description: "key the event tombstones by deletion author", Backup: true, Apply: rekeyEventTombstones},
+}
+
+// honeypotMigrations are the migrations of the honeypot stores.
+var honeypotMigrations = []Migration{
+	{Version: 1, Description: "record the schema version", Apply: func(*badger.BadgerBackend) error { return nil }},
+}
+
+// rekeyEventTombstones moves the tombstones of deleted event IDs, which held the pubkey of
+// the deletion's author as their value, to keys ending with that pubkey.
+func rekeyEventTombstones(db *badger.BadgerBackend) error {
+	prefix := []byte{deletedPrefix, 'e'}
+	tombstones := make(map[string]string) // event ID -> deletion author
+	err := db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: prefix})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) != len(prefix)+64 {
+				continue
+			}
+			if err := it.Item().Value(func(pubkey []byte) error {
+				tombstones[string(key[len(prefix):])] = string(pubkey)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	for id, pubkey := range tombstones {
+		if err := batch.Set(deletedEventKey(id, pubkey), nil); err != nil {
+			return err
+		}
+		if err := batch.Delete(append(slices.Clone(prefix), id...)); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// storeVersion returns the schema version of the store, 0 if it has none.
+func storeVersion(db *badger.BadgerBackend) (int, error) {
+	var version int
+	err := db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(schemaVersionKey)
+		if errors.Is(err, badgerdb.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(value []byte) error {
+			if len(value) != 8 {
+				return fmt.Errorf("invalid schema version %x", value)
+			}
+			version = int(binary.BigEndian.Uint64(value))
+			return nil
+		})
+	})
+	return version, err
+}
+
+func setStoreVersion(db *badger.BadgerBackend, version int) error {
+	return db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set(schemaVersionKey, binary.BigEndian.AppendUint64(nil, uint64(version)))
+	})
+}
+
+// latestVersion returns the version the migrations bring a store to.
+func latestVersion(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// checkStoreVersion returns ErrStoreTooNew if the store has a schema this version of wotrlay doesn't know.
+func checkStoreVersion(db *badger.BadgerBackend, migrations []Migration) error {
+	version, err := storeVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema version of %s: %w", db.Path, err)
+	}
+	if latest := latestVersion(migrations); version > latest {
+		return fmt.Errorf("%w: %s is at schema version %d, this version supports up to %d", ErrStoreTooNew, db.Path, version, latest)
+	}
+	return nil
+}
+
+// migrateStore applies the migrations the store hasn't had yet, recording the version after
+// each one so that an interrupted upgrade resumes where it stopped. Stores written by a
+// newer version of wotrlay are refused rather than risk misreading them. A store is backed
+// up to <path>.v<version>.bak before the first migration that asks for it.
+func migrateStore(db *badger.BadgerBackend, migrations []Migration) error {
+	if err := checkStoreVersion(db, migrations); err != nil {
+		return err
+	}
+	version, err := storeVersion(db)
+	if err != nil {
+		return err
+	}
+
+	backedUp := false
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		if m.Backup && !backedUp {
+			if err := backupStore(db, fmt.Sprintf("%s.v%d.bak", db.Path, version)); err != nil {
+				return fmt.Errorf("failed to back up %s before migrating it: %w", db.Path, err)
+			}
+			backedUp = true
+		}
+
+		relayLog.Info("migrating store", "path", db.Path, "version", m.Version, "description", m.Description)
+		if err := m.Apply(db); err != nil {
+			return fmt.Errorf("failed to migrate %s to schema version %d: %w", db.Path, m.Version, err)
+		}
+		if err := setStoreVersion(db, m.Version); err != nil {
+			return fmt.Errorf("failed to record schema version %d of %s: %w", m.Version, db.Path, err)
+		}
+		version = m.Version
+	}
+	return nil
+}
+
+// backupStore writes a full Badger backup of the store to the file, which must not exist.
+// It can be restored with `badger restore`.
+func backupStore(db *badger.BadgerBackend, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Backup(file, 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	relayLog.Info("backed up store", "path", db.Path, "backup", path)
+	return file.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMigrateStore(t *testing.T) {
+	db := newTestDB(t)
+
+	var applied []int
+	step := func(version int, backup bool) Migration {
+		return Migration{Version: version, Description: "test", Backup: backup, Apply: func(*badger.BadgerBackend) error {
+			applied = append(applied, version)
+			return nil
+		}}
+	}
+
+	migrations := []Migration{step(1, false), step(2, false)}
+	if err := migrateStore(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := storeVersion(db); version != 2 || len(applied) != 2 {
+		t.Fatalf("expected both migrations to be applied, got version %d and %v", version, applied)
+	}
+
+	// Only new migrations are applied on upgrade, after a backup if they ask for it
+	applied = nil
+	migrations = append(migrations, step(3, true))
+	if err := migrateStore(db, migrations); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0] != 3 {
+		t.Errorf("expected only migration 3 to be applied, got %v", applied)
+	}
+	if _, err := os.Stat(db.Path + ".v2.bak"); err != nil {
+		t.Errorf("expected a backup before migration 3: %v", err)
+	}
+
+	// Stores written by a newer version are refused
+	if err := migrateStore(db, migrations[:2]); !errors.Is(err, ErrStoreTooNew) {
+		t.Errorf("expected ErrStoreTooNew on downgrade, got %v", err)
+	}
+}
+
+func TestMigrateStoreInterrupted(t *testing.T) {
+	db := newTestDB(t)
+	failing := errors.New("disk unplugged")
+
+	migrations := []Migration{
+		{Version: 1, Apply: func(*badger.BadgerBackend) error { return nil }},
+		{Version: 2, Apply: func(*badger.BadgerBackend) error { return failing }},
+	}
+	if err := migrateStore(db, migrations); !errors.Is(err, failing) {
+		t.Fatalf("expected the migration error, got %v", err)
+	}
+	if version, _ := storeVersion(db); version != 1 {
+		t.Errorf("expected the store to stay at the last successful version, got %d", version)
+	}
+}
+
+func TestMigrateStoreKeepsEvents(t *testing.T) {
+	db := newTestDB(t)
+	e := signedEvent(t, nostr.GeneratePrivateKey(), 1, nil)
+	if err := db.SaveEvent(t.Context(), e); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateStore(db, eventStoreMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if getEventByID(t.Context(), db, e.ID) == nil {
+		t.Error("events should survive the migrations")
+	}
+	if count, _ := db.CountEvents(t.Context(), nostr.Filter{}); count != 1 {
+		t.Errorf("the schema version should not be counted as an event, got %d events", count)
+	}
+}
+
+func TestMigrateEventTombstones(t *testing.T) {
+	db := newTestDB(t)
+	e := signedEvent(t, nostr.GeneratePrivateKey(), 1, nil)
+
+	// A tombstone of the first schema, holding the deletion's author as its value
+	if err := db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set(append([]byte{deletedPrefix, 'e'}, e.ID...), []byte(e.PubKey))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setStoreVersion(db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateStore(db, eventStoreMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if !isDeleted(db, e) {
+		t.Error("expected the tombstone to survive the migration")
+	}
+	err := db.View(func(txn *badgerdb.Txn) error {
+		_, err := txn.Get(append([]byte{deletedPrefix, 'e'}, e.ID...))
+		return err
+	})
+	if !errors.Is(err, badgerdb.ErrKeyNotFound) {
+		t.Errorf("expected the old tombstone to be removed, got %v", err)
+	}
+}
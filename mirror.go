@@ -0,0 +1,217 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	mirrorQueueSize = 1000 // accepted events waiting to be mirrored, beyond which new ones are dropped
+	mirrorWorkers   = 4
+	mirrorTimeout   = 10 * time.Second
+)
+
+// Mirror republishes the events accepted by the relay to other relays, following the
+// outbox model: to MirrorRelays and, with MirrorOutbox, to the write relays the author
+// declared in their NIP-65 relay list stored on this relay, and to the relays hinted
+// in the event's tags, where the referenced events and people are read.
+// Only the events of pubkeys at or above MirrorMinTier are mirrored, so the relay
+// doesn't amplify the content it merely tolerates.
+type Mirror struct {
+	d       *Deps
+	queue   chan mirrored
+	lookup  func(ctx context.Context, host string) ([]net.IPAddr, error)
+	publish func(ctx context.Context, urls []string, e *nostr.Event) (published int)
+}
+
+// mirrored is an accepted event with the settings it was accepted under.
+type mirrored struct {
+	event *nostr.Event
+	cfg   Config
+}
+
+func NewMirror(ctx context.Context, d *Deps) *Mirror {
+	pool := nostr.NewSimplePool(ctx)
+	m := &Mirror{
+		d:      d,
+		queue:  make(chan mirrored, mirrorQueueSize),
+		lookup: net.DefaultResolver.LookupIPAddr,
+	}
+
+	m.publish = func(ctx context.Context, urls []string, e *nostr.Event) int {
+		ctx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+		defer cancel()
+
+		published := 0
+		for result := range pool.PublishMany(ctx, urls, *e) {
+			if result.Error == nil {
+				published++
+			}
+		}
+		return published
+	}
+
+	for range mirrorWorkers {
+		go m.worker(ctx)
+	}
+	return m
+}
+
+// Enqueue queues the accepted event to be mirrored, without blocking: its relays are
+// looked up by the workers.
+func (m *Mirror) Enqueue(e *nostr.Event, cfg Config) {
+	rank, ok := operatorRank(e.PubKey, cfg)
+	if !ok {
+		rank, _ = m.d.Cache.Peek(e.PubKey)
+	}
+	if tierFor(rank, cfg) < cfg.MirrorMinTier {
+		return
+	}
+
+	select {
+	case m.queue <- mirrored{event: e, cfg: cfg}:
+	default:
+		m.d.Obs.mirrorDroppedCount.Add(1)
+	}
+}
+
+// Relays returns the relays the event is mirrored to, in order of preference:
+// MirrorRelays, then the author's write relays, then the relays hinted in its tags,
+// up to MirrorMaxRelays. The relay itself is never one of them, nor are the relays of
+// the event whose host doesn't resolve to public addresses only.
+func (m *Mirror) Relays(ctx context.Context, e *nostr.Event, cfg Config) []string {
+	candidates := slices.Clone(cfg.MirrorRelays)
+	if cfg.MirrorOutbox {
+		candidates = append(candidates, writeRelays(ctx, m.d, e.PubKey)...)
+		candidates = append(candidates, relayHints(e)...)
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	relays := make([]string, 0, min(len(candidates), cfg.MirrorMaxRelays))
+	for i, candidate := range candidates {
+		if len(relays) == cfg.MirrorMaxRelays {
+			break
+		}
+
+		relay := nostr.NormalizeURL(candidate)
+		// Operators may mirror to any relay, but the events' own relays must be public
+		operator := i < len(cfg.MirrorRelays)
+		if relay == "" || seen[relay] || isOwnRelay(relay, cfg) {
+			continue
+		}
+		if !operator && (!isPublicRelay(relay) || !m.resolvesPublic(ctx, relay)) {
+			continue
+		}
+		seen[relay] = true
+		relays = append(relays, relay)
+	}
+	return relays
+}
+
+// writeRelays returns the write relays of the NIP-65 relay list of the pubkey stored on the relay.
+func writeRelays(ctx context.Context, d *Deps, pubkey string) []string {
+	list := queryOne(ctx, d.DB, nostr.Filter{Kinds: []int{nostr.KindRelayListMetadata}, Authors: []string{pubkey}})
+	if list == nil {
+		return nil
+	}
+
+	var relays []string
+	for _, tag := range list.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		if len(tag) >= 3 && tag[2] != "" && tag[2] != "write" {
+			continue
+		}
+		relays = append(relays, tag[1])
+	}
+	return relays
+}
+
+// relayHints returns the relays hinted in the "e", "a", "q" and "p" tags of the event.
+func relayHints(e *nostr.Event) []string {
+	var hints []string
+	for _, tag := range e.Tags {
+		if len(tag) < 3 || tag[2] == "" {
+			continue
+		}
+		switch tag[0] {
+		case "e", "a", "q", "p":
+			hints = append(hints, tag[2])
+		}
+	}
+	return hints
+}
+
+// isPublicRelay reports whether the relay URL is a wss:// URL of a public host.
+func isPublicRelay(relay string) bool {
+	u, err := url.Parse(relay)
+	if err != nil || u.Scheme != "wss" || u.User != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".local") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return isPublicIP(ip)
+	}
+	return strings.Contains(host, ".")
+}
+
+// resolvesPublic reports whether the host of the relay URL resolves, and only to public
+// addresses, so that a public hostname can't point the mirror at an internal service.
+func (m *Mirror) resolvesPublic(ctx context.Context, relay string) bool {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return false
+	}
+
+	addrs, err := m.lookup(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOwnRelay reports whether the relay URL points to this relay.
+func isOwnRelay(relay string, cfg Config) bool {
+	u, err := url.Parse(relay)
+	return err == nil && cfg.RelayDomain != "" && strings.EqualFold(u.Host, cfg.RelayDomain)
+}
+
+func (m *Mirror) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case job := <-m.queue:
+			lookupCtx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+			relays := m.Relays(lookupCtx, job.event, job.cfg)
+			cancel()
+			if len(relays) == 0 {
+				continue
+			}
+
+			published := m.publish(ctx, relays, job.event)
+			m.d.Obs.mirrorPublishedCount.Add(uint64(published))
+			m.d.Obs.mirrorFailedCount.Add(uint64(len(relays) - published))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMirrorRelays(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{
+			"MIRROR_RELAYS":     "ws://localhost:7777",
+			"MIRROR_OUTBOX":     "true",
+			"MIRROR_MAX_RELAYS": "4",
+			"RELAY_DOMAIN":      "relay.example.com",
+		}[key]
+	})
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	cache := NewRankCache(ctx, cfg, obs)
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+	m := &Mirror{d: d, queue: make(chan mirrored, 1)}
+	m.lookup = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "internal.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("203.0.113.1")}, {IP: net.ParseIP("10.0.0.1")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.1")}}, nil
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	list := signedEvent(t, sk, nostr.KindRelayListMetadata, nostr.Tags{
+		{"r", "wss://write.example.com"},
+		{"r", "wss://read.example.com", "read"},
+		{"r", "wss://relay.example.com"},
+		{"r", "ws://10.0.0.1"},
+	})
+	if err := d.DB.SaveEvent(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	note := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{
+		{"e", list.ID, "wss://write.example.com/"},
+		{"p", list.PubKey, "wss://192.168.1.1"},
+		{"p", list.PubKey, "wss://internal.example.com"},
+		{"e", list.ID, "wss://hint.example.com"},
+		{"p", list.PubKey, "wss://other.example.com"},
+	})
+	want := []string{"ws://localhost:7777", "wss://write.example.com", "wss://hint.example.com", "wss://other.example.com"}
+	if got := m.Relays(ctx, note, cfg); !slices.Equal(got, want) {
+		t.Errorf("relays: got %v, want %v", got, want)
+	}
+
+	// Only the events of pubkeys at or above MIRROR_MIN_TIER are mirrored
+	m.Enqueue(note, cfg)
+	if len(m.queue) != 0 {
+		t.Fatal("events of low-trust pubkeys must not be mirrored")
+	}
+	cache.Update(time.Now(), PubRank{Pubkey: note.PubKey, Rank: 1})
+	m.Enqueue(note, cfg)
+	m.Enqueue(note, cfg)
+	if len(m.queue) != 1 || obs.mirrorDroppedCount.Load() != 1 {
+		t.Errorf("expected 1 queued and 1 dropped event, got %d and %d", len(m.queue), obs.mirrorDroppedCount.Load())
+	}
+}
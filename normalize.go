@@ -0,0 +1,80 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// bracketedDotRegex matches separator obfuscations such as "example[.]com" or "example (dot) com".
+var bracketedDotRegex = regexp.MustCompile(`(?i)\s*[\[({]\s*(?:\.|dot)\s*[\])}]\s*`)
+
+// dotLikeRunes are characters rendered like a dot that spammers use in place of one.
+var dotLikeRunes = map[rune]bool{
+	'。': true, // ideographic full stop
+	'﹒': true, // small full stop
+	'․': true, // one dot leader
+	'·': true, // middle dot
+	'∙': true, // bullet operator
+	'܁': true, // syriac supralinear full stop
+	'܂': true, // syriac sublinear full stop
+}
+
+// confusables maps Cyrillic and Greek letters to the Latin letters they look like.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j', 'һ': 'h',
+	'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l', 'ү': 'y',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J',
+	// Greek
+	'α': 'a', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// normalizeURLText undoes common tricks used to hide links from URL detection:
+// invisible characters are removed, fullwidth characters are folded to ASCII,
+// and dot look-alikes and bracketed dots ("[.]", "(dot)") become plain dots.
+func normalizeURLText(content string) string {
+	if isASCII(content) {
+		if strings.ContainsAny(content, "[({") {
+			return bracketedDotRegex.ReplaceAllString(content, ".")
+		}
+		return content
+	}
+
+	content = width.Fold.String(content)
+	content = strings.Map(func(r rune) rune {
+		switch {
+		case dotLikeRunes[r]:
+			return '.'
+		case unicode.Is(unicode.Cf, r):
+			// Format characters: zero-width spaces and joiners, soft hyphens, BOMs, ...
+			return -1
+		default:
+			return r
+		}
+	}, content)
+
+	if strings.ContainsAny(content, "[({") {
+		content = bracketedDotRegex.ReplaceAllString(content, ".")
+	}
+	return content
+}
+
+// foldConfusables replaces Cyrillic and Greek look-alikes with the Latin letters they imitate.
+func foldConfusables(content string) string {
+	return strings.Map(func(r rune) rune {
+		if latin, ok := confusables[r]; ok {
+			return latin
+		}
+		return r
+	}, content)
+}
@@ -0,0 +1,220 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+)
+
+// OnboardingStage is the stage a pubkey without a rank has reached on the relay.
+// Pubkeys are unknown when first seen, on probation once they have been around for
+// OnboardingProbationHours, and members once they have also been around for
+// OnboardingMemberHours and published OnboardingMemberEvents events. Each stage has
+// its own daily rate, in place of the rate of rank 0. A spam rejection sends the
+// pubkey back to unknown, and a rank above 0 takes it out of onboarding.
+type OnboardingStage int
+
+const (
+	StageUnknown OnboardingStage = iota
+	StageProbation
+	StageMember
+)
+
+func (s OnboardingStage) String() string {
+	switch s {
+	case StageUnknown:
+		return "unknown"
+	case StageProbation:
+		return "probation"
+	default:
+		return "member"
+	}
+}
+
+// onboardingPrefix is the key prefix under which the onboarding records of pubkeys are
+// kept in the event store. The event store only uses prefixes 0-8 and 255, the honeypot
+// labels 128, the store metadata 129, the tombstones 130 and the refresh queue 131.
+//   - onboardingPrefix <pubkey> holds the JSON onboardingRecord of the pubkey
+const onboardingPrefix byte = 132
+
+// onboardingRecordTTL is how long the record of a pubkey that stopped publishing is kept.
+const onboardingRecordTTL = 30 * 24 * time.Hour
+
+// onboardingUpdateAttempts is how many times a change to a record is tried when concurrent
+// events of the pubkey change it at the same time.
+const onboardingUpdateAttempts = 3
+
+// onboardingRecord is the progress of a pubkey through onboarding.
+type onboardingRecord struct {
+	Stage     OnboardingStage `json:"stage"`
+	FirstSeen time.Time       `json:"first_seen"` // when an event of the pubkey was first accepted, or last flagged as spam
+	Accepted  int             `json:"accepted"`   // events accepted since FirstSeen
+}
+
+// Onboarding tracks the pubkeys without a rank through the onboarding stages.
+// Records live in the relay's event store, so progress survives restarts.
+type Onboarding struct {
+	db  *badger.BadgerBackend
+	obs *Observability
+
+	ProbationAfter time.Duration // age at which unknown pubkeys are put on probation
+	MemberAfter    time.Duration // age at which pubkeys on probation may become members
+	MemberEvents   int           // accepted events needed to become a member
+}
+
+func NewOnboarding(db *badger.BadgerBackend, cfg Config, obs *Observability) *Onboarding {
+	return &Onboarding{
+		db:             db,
+		obs:            obs,
+		ProbationAfter: time.Duration(cfg.OnboardingProbationHours) * time.Hour,
+		MemberAfter:    time.Duration(cfg.OnboardingMemberHours) * time.Hour,
+		MemberEvents:   cfg.OnboardingMemberEvents,
+	}
+}
+
+// isOnboarding reports whether the pubkey goes through onboarding: it has no rank,
+// and the operator didn't set one.
+func isOnboarding(pubkey string, rank float64, cfg Config, d *Deps) bool {
+	if d.Onboarding == nil || rank > 0 {
+		return false
+	}
+	_, set := operatorRank(pubkey, cfg)
+	return !set
+}
+
+// onboardingDailyRate returns the daily rate of the stage, scaled like the rate curve.
+func onboardingDailyRate(stage OnboardingStage, cfg Config) float64 {
+	return cfg.RateMultiplier * cfg.OnboardingDailyRates[stage]
+}
+
+// Peek returns the stage of the pubkey, without recording anything: events are
+// rate-limited at the stage the pubkey has reached, even if it isn't recorded yet.
+func (o *Onboarding) Peek(pubkey string, now time.Time) OnboardingStage {
+	record, found := o.load(pubkey)
+	if !found {
+		return StageUnknown
+	}
+	return o.advance(record, now)
+}
+
+// Accepted counts an accepted event of the pubkey toward its membership, starting its
+// onboarding if it is its first, and records the promotions it earned since its last one.
+func (o *Onboarding) Accepted(pubkey string, now time.Time) {
+	var promoted bool
+	var stage OnboardingStage
+	saved := o.update(pubkey, func(record *onboardingRecord, found bool) bool {
+		if !found {
+			*record = onboardingRecord{Stage: StageUnknown, FirstSeen: now}
+		}
+		stage = o.advance(*record, now)
+		promoted = stage != record.Stage
+		record.Stage = stage
+		record.Accepted++
+		return true
+	})
+	if !saved || !promoted {
+		return
+	}
+	switch stage {
+	case StageProbation:
+		o.obs.onboardingProbationCount.Add(1)
+	case StageMember:
+		o.obs.onboardingMemberCount.Add(1)
+	}
+}
+
+// Flagged sends the pubkey back to unknown after one of its events was rejected as spam.
+func (o *Onboarding) Flagged(pubkey string, now time.Time) {
+	var demoted bool
+	saved := o.update(pubkey, func(record *onboardingRecord, found bool) bool {
+		if !found {
+			return false
+		}
+		demoted = record.Stage != StageUnknown
+		*record = onboardingRecord{Stage: StageUnknown, FirstSeen: now}
+		return true
+	})
+	if saved && demoted {
+		o.obs.onboardingDemotedCount.Add(1)
+	}
+}
+
+// advance returns the stage the record has reached at now. Stages are never skipped.
+func (o *Onboarding) advance(record onboardingRecord, now time.Time) OnboardingStage {
+	age := now.Sub(record.FirstSeen)
+	switch record.Stage {
+	case StageUnknown:
+		if age >= o.ProbationAfter {
+			return StageProbation
+		}
+	case StageProbation:
+		if age >= o.MemberAfter && record.Accepted >= o.MemberEvents {
+			return StageMember
+		}
+	}
+	return record.Stage
+}
+
+func (o *Onboarding) load(pubkey string) (onboardingRecord, bool) {
+	var record onboardingRecord
+	err := o.db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(onboardingKey(pubkey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(value []byte) error {
+			return json.Unmarshal(value, &record)
+		})
+	})
+	if err != nil && !errors.Is(err, badgerdb.ErrKeyNotFound) {
+		limiterLog.Error("failed to load the onboarding record", "pubkey", pubkey, "error", err)
+	}
+	return record, err == nil
+}
+
+// update reads, changes and writes the record of the pubkey in one transaction, so that
+// concurrent events of the pubkey don't overwrite each other's changes. change returns
+// false to leave the record as it is. update reports whether the change was saved.
+func (o *Onboarding) update(pubkey string, change func(record *onboardingRecord, found bool) bool) bool {
+	var saved bool
+	err := badgerdb.ErrConflict
+	for attempt := 0; attempt < onboardingUpdateAttempts && errors.Is(err, badgerdb.ErrConflict); attempt++ {
+		saved = false
+		err = o.db.Update(func(txn *badgerdb.Txn) error {
+			var record onboardingRecord
+			item, err := txn.Get(onboardingKey(pubkey))
+			found := err == nil
+			if found {
+				err = item.Value(func(value []byte) error { return json.Unmarshal(value, &record) })
+			}
+			if err != nil && !errors.Is(err, badgerdb.ErrKeyNotFound) {
+				return err
+			}
+			if !change(&record, found) {
+				return nil
+			}
+
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			saved = true
+			return txn.SetEntry(badgerdb.NewEntry(onboardingKey(pubkey), value).WithTTL(onboardingRecordTTL))
+		})
+	}
+	if err != nil {
+		limiterLog.Error("failed to save the onboarding record", "pubkey", pubkey, "error", err)
+		return false
+	}
+	return saved
+}
+
+func onboardingKey(pubkey string) []byte {
+	return append([]byte{onboardingPrefix}, pubkey...)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestOnboardingStages(t *testing.T) {
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	o := NewOnboarding(newTestDB(t), cfg, obs)
+	start := time.Now()
+
+	if stage := o.Peek("alice", start); stage != StageUnknown {
+		t.Errorf("never seen: got %s, want unknown", stage)
+	}
+	if _, found := o.load("alice"); found {
+		t.Error("peeking at a pubkey never seen should not start its onboarding")
+	}
+	o.Accepted("alice", start)
+
+	steps := []struct {
+		after time.Duration
+		want  OnboardingStage
+	}{
+		{0, StageUnknown},
+		{23 * time.Hour, StageUnknown},
+		{25 * time.Hour, StageProbation},
+		{200 * time.Hour, StageProbation}, // not enough events yet
+	}
+	for _, step := range steps {
+		if stage := o.Peek("alice", start.Add(step.after)); stage != step.want {
+			t.Errorf("after %s: got %s, want %s", step.after, stage, step.want)
+		}
+	}
+	if record, _ := o.load("alice"); record.Stage != StageUnknown {
+		t.Errorf("expected the promotion to be recorded with the next accepted event, got %s", record.Stage)
+	}
+
+	for range cfg.OnboardingMemberEvents {
+		o.Accepted("alice", start.Add(100*time.Hour))
+	}
+	if stage := o.Peek("alice", start.Add(200*time.Hour)); stage != StageMember {
+		t.Errorf("with enough events: got %s, want member", stage)
+	}
+	o.Accepted("alice", start.Add(200*time.Hour))
+	if obs.onboardingProbationCount.Load() != 1 || obs.onboardingMemberCount.Load() != 1 {
+		t.Errorf("expected 1 promotion to each stage, got %d and %d", obs.onboardingProbationCount.Load(), obs.onboardingMemberCount.Load())
+	}
+
+	// Spam sends the pubkey back to the start
+	o.Flagged("alice", start.Add(201*time.Hour))
+	if stage := o.Peek("alice", start.Add(202*time.Hour)); stage != StageUnknown {
+		t.Errorf("after spam: got %s, want unknown", stage)
+	}
+	if obs.onboardingDemotedCount.Load() != 1 {
+		t.Errorf("expected 1 demotion, got %d", obs.onboardingDemotedCount.Load())
+	}
+}
+
+func TestOnboardingEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"ONBOARDING_ENABLED": "true"}[key]
+	})
+	obs := &Observability{}
+	db := newTestDB(t)
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+	d.Onboarding = NewOnboarding(db, cfg, obs)
+
+	newcomerSK, trustedSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	newcomer, _ := nostr.GetPublicKey(newcomerSK)
+	trusted, _ := nostr.GetPublicKey(trustedSK)
+	cache.Update(time.Now(), PubRank{Pubkey: newcomer, Rank: 0}, PubRank{Pubkey: trusted, Rank: 0.9})
+
+	publish := func(sk string) error {
+		e := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello " + sk[:8]}
+		e.Sign(sk)
+		return handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+	}
+
+	if err := publish(newcomerSK); err != nil {
+		t.Fatalf("first event of a newcomer: %v", err)
+	}
+	if record, found := d.Onboarding.load(newcomer); !found || record.Stage != StageUnknown || record.Accepted != 1 {
+		t.Errorf("expected an unknown newcomer with 1 accepted event, got %+v (found=%v)", record, found)
+	}
+	if err := publish(newcomerSK); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second event of an unknown newcomer: got %v, want %v", err, ErrRateLimited)
+	}
+	if record, _ := d.Onboarding.load(newcomer); record.Accepted != 1 {
+		t.Errorf("expected rejected events not to count, got %+v", record)
+	}
+
+	if err := publish(trustedSK); err != nil {
+		t.Fatalf("event of a ranked pubkey: %v", err)
+	}
+	if _, found := d.Onboarding.load(trusted); found {
+		t.Error("ranked pubkeys should not go through onboarding")
+	}
+}
+
+func TestOnboardingConcurrentEvents(t *testing.T) {
+	cfg := parseConfig(func(string) string { return "" })
+	o := NewOnboarding(newTestDB(t), cfg, &Observability{})
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	for range onboardingUpdateAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.Accepted("alice", now)
+		}()
+	}
+	wg.Wait()
+	if record, _ := o.load("alice"); record.Accepted != onboardingUpdateAttempts {
+		t.Errorf("expected every concurrent event to count, got %d", record.Accepted)
+	}
+}
@@ -0,0 +1,289 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Websocket frame bits, see RFC 6455 section 5.2.
+const (
+	wsFinalBit  = 0x80
+	wsOpcode    = 0x0f
+	wsOpText    = 0x1
+	wsOpControl = 0x8 // opcodes from 0x8 are control frames (close, ping, pong)
+	wsMaskBit   = 0x80
+)
+
+// noticePrefix starts the payload of every NOTICE frame.
+var noticePrefix = []byte(`["NOTICE"`)
+
+// OutboundLimiter paces the frames the relay writes to each websocket connection
+// and drops NOTICEs identical to the previous one, so that a client triggering
+// thousands of rejections can't make the relay amplify them into a write flood.
+// Once a connection is paced, responses pile up in rely's per-client buffer and
+// are dropped when it is full, like for any slow client.
+//
+// Live events are also capped per subscription, see liveCap.
+type OutboundLimiter struct {
+	obs *Observability
+
+	FrameRate    float64       // Data frames per second per connection (0 disables pacing)
+	FrameBurst   float64       // Data frames that can be written at once before pacing applies
+	NoticeWindow time.Duration // Identical NOTICEs within this window are dropped (0 disables coalescing)
+
+	LiveEventRate   float64 // Live events per second per subscription (0 disables the cap)
+	LiveEventBurst  float64 // Live events delivered at once to a subscription before the cap applies
+	LiveEventBuffer int     // Live events queued per subscription over the cap, the oldest being dropped
+}
+
+func NewOutboundLimiter(obs *Observability, frameRate, frameBurst float64, noticeWindow time.Duration) *OutboundLimiter {
+	return &OutboundLimiter{
+		obs:          obs,
+		FrameRate:    frameRate,
+		FrameBurst:   frameBurst,
+		NoticeWindow: noticeWindow,
+	}
+}
+
+// Wrap returns a handler whose hijacked (websocket) connections are limited.
+func (o *OutboundLimiter) Wrap(next http.Handler) http.Handler {
+	if o.FrameRate <= 0 && o.NoticeWindow <= 0 && o.LiveEventRate <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&hijackWriter{ResponseWriter: w, limiter: o}, r)
+	})
+}
+
+// hijackWriter wraps the connection handed to the websocket upgrader.
+type hijackWriter struct {
+	http.ResponseWriter
+	limiter *OutboundLimiter
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.limiter.conn(conn), rw, nil
+}
+
+// conn returns a connection limited by o. The first write (the upgrade response)
+// is passed through, after which writes are parsed as websocket frames.
+func (o *OutboundLimiter) conn(c net.Conn) *limitedConn {
+	conn := &limitedConn{
+		Conn:    c,
+		limiter: o,
+		bucket: Bucket{
+			tokens:     o.FrameBurst,
+			capacity:   o.FrameBurst,
+			refillRate: o.FrameRate,
+			lastActive: time.Now(),
+		},
+	}
+	if o.LiveEventRate > 0 {
+		conn.live = newLiveCap(o.obs, o.LiveEventRate, o.LiveEventBurst, o.LiveEventBuffer)
+	}
+	return conn
+}
+
+// limitedConn is a server-side websocket connection whose writes are limited frame
+// by frame. Writes are done by the websocket writer goroutine, and by the flushes
+// of the live events held back.
+type limitedConn struct {
+	net.Conn
+	limiter *OutboundLimiter
+	live    *liveCap // nil unless live events are capped
+
+	mu       sync.Mutex
+	flushing bool // a flush of the held live events is scheduled
+	upgraded bool
+	pending  []byte // bytes of an incomplete frame
+	bucket   Bucket
+
+	lastNotice   []byte
+	lastNoticeAt time.Time
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.live != nil && n > 0 {
+		c.live.inbound(b[:n])
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.upgraded {
+		c.upgraded = true
+		return c.Conn.Write(b)
+	}
+
+	c.pending = append(c.pending, b...)
+	offset := 0
+	for {
+		size, ok := frameSize(c.pending[offset:])
+		if !ok {
+			break
+		}
+
+		frame := c.pending[offset : offset+size]
+		switch {
+		case c.repeatedNotice(frame):
+			c.limiter.obs.coalescedNoticeCount.Add(1)
+		case c.live != nil && c.live.hold(frame, time.Now()):
+			c.scheduleFlush(time.Duration(float64(time.Second) / c.live.rate))
+		default:
+			if err := c.writeFrame(frame); err != nil {
+				c.pending = c.pending[:0]
+				return 0, err
+			}
+		}
+		offset += size
+	}
+
+	// Keep the incomplete frame, if any, for the next write
+	c.pending = append(c.pending[:0], c.pending[offset:]...)
+	return len(b), nil
+}
+
+// writeFrame writes a complete frame, pacing data frames.
+func (c *limitedConn) writeFrame(frame []byte) error {
+	if frame[0]&wsOpcode < wsOpControl {
+		c.wait()
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// scheduleFlush writes the live events held back after the delay, unless a flush
+// is already scheduled. It must be called with c.mu held.
+func (c *limitedConn) scheduleFlush(delay time.Duration) {
+	if !c.flushing {
+		c.flushing = true
+		time.AfterFunc(delay, c.flush)
+	}
+}
+
+// flush writes the live events whose subscriptions may receive them, and schedules
+// the next flush while some are left. Once a write fails, the connection is
+// closing and the events left are abandoned.
+func (c *limitedConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushing = false
+	frames, next := c.live.due(time.Now())
+	for _, frame := range frames {
+		if err := c.writeFrame(frame); err != nil {
+			return
+		}
+	}
+	if next > 0 {
+		c.scheduleFlush(next)
+	}
+}
+
+// wait blocks until the connection may write another data frame.
+func (c *limitedConn) wait() {
+	if c.limiter.FrameRate <= 0 {
+		return
+	}
+
+	c.bucket.refillLocked(time.Now())
+	if c.bucket.tokens < 1 {
+		delay := time.Duration((1 - c.bucket.tokens) / c.bucket.refillRate * float64(time.Second))
+		c.limiter.obs.pacedFrameCount.Add(1)
+		time.Sleep(delay)
+		c.bucket.refillLocked(time.Now())
+	}
+	c.bucket.tokens--
+}
+
+// repeatedNotice reports whether the frame is a NOTICE identical to the previous one,
+// written within the window. Compressed and fragmented frames are never coalesced.
+func (c *limitedConn) repeatedNotice(frame []byte) bool {
+	if c.limiter.NoticeWindow <= 0 || frame[0] != wsFinalBit|wsOpText {
+		return false
+	}
+
+	payload := frame[frameHeaderSize(frame):]
+	if !bytes.HasPrefix(payload, noticePrefix) {
+		return false
+	}
+
+	now := time.Now()
+	if bytes.Equal(payload, c.lastNotice) && now.Sub(c.lastNoticeAt) < c.limiter.NoticeWindow {
+		return true
+	}
+	c.lastNotice = append(c.lastNotice[:0], payload...)
+	c.lastNoticeAt = now
+	return false
+}
+
+// frameHeaderSize returns the header size of the frame, which must have at least 2 bytes.
+func frameHeaderSize(frame []byte) int {
+	size := 2
+	switch frame[1] &^ wsMaskBit {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if frame[1]&wsMaskBit != 0 {
+		size += 4
+	}
+	return size
+}
+
+// frameSize returns the size of the websocket frame at the start of buf,
+// or false if buf doesn't hold a complete frame yet.
+func frameSize(buf []byte) (int, bool) {
+	header, length, ok := frameLength(buf)
+	if !ok || uint64(len(buf)-header) < length {
+		return 0, false
+	}
+	return header + int(length), true
+}
+
+// frameLength returns the header size and payload length of the websocket frame
+// at the start of buf, or false if buf doesn't hold its header yet.
+func frameLength(buf []byte) (int, uint64, bool) {
+	if len(buf) < 2 {
+		return 0, 0, false
+	}
+
+	header := frameHeaderSize(buf)
+	if len(buf) < header {
+		return 0, 0, false
+	}
+
+	var length uint64
+	switch buf[1] &^ wsMaskBit {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(buf[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(buf[2:10])
+	default:
+		length = uint64(buf[1] &^ wsMaskBit)
+	}
+	return header, length, true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// recordingConn records the writes made to it.
+type recordingConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, bytes.Clone(b))
+	return len(b), nil
+}
+
+// serverFrame builds an unmasked, final websocket frame.
+func serverFrame(opcode byte, payload string) []byte {
+	frame := []byte{wsFinalBit | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	default:
+		frame = append(frame, 126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	return append(frame, payload...)
+}
+
+func newTestLimitedConn(frameRate, frameBurst float64, noticeWindow time.Duration) (*limitedConn, *recordingConn) {
+	rec := &recordingConn{}
+	conn := NewOutboundLimiter(&Observability{}, frameRate, frameBurst, noticeWindow).conn(rec)
+
+	// Upgrade response
+	conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+	return conn, rec
+}
+
+func TestLimitedConnCoalescesNotices(t *testing.T) {
+	conn, rec := newTestLimitedConn(0, 1, time.Minute)
+
+	notice := serverFrame(wsOpText, `["NOTICE","error: invalid message"]`)
+	other := serverFrame(wsOpText, `["NOTICE","error: something else"]`)
+	ok := serverFrame(wsOpText, `["OK","abc",false,"rate-limited"]`)
+
+	for _, frame := range [][]byte{notice, notice, ok, ok, notice, other, notice} {
+		if n, err := conn.Write(frame); n != len(frame) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+
+	// handshake, notice, ok, ok, other, notice
+	if len(rec.writes) != 6 {
+		t.Fatalf("expected 6 writes, got %d", len(rec.writes))
+	}
+	if !bytes.Equal(rec.writes[4], other) || !bytes.Equal(rec.writes[5], notice) {
+		t.Error("a notice different from the previous one should be written")
+	}
+	if got := conn.limiter.obs.coalescedNoticeCount.Load(); got != 2 {
+		t.Errorf("expected 2 coalesced notices, got %d", got)
+	}
+}
+
+func TestLimitedConnSplitFrames(t *testing.T) {
+	conn, rec := newTestLimitedConn(0, 1, time.Minute)
+
+	event := serverFrame(wsOpText, `["EVENT","sub",{"content":"`+string(bytes.Repeat([]byte("x"), 200))+`"}]`)
+	conn.Write(event[:4])
+	if len(rec.writes) != 1 {
+		t.Fatal("an incomplete frame should not be written")
+	}
+	conn.Write(event[4:])
+	if len(rec.writes) != 2 || !bytes.Equal(rec.writes[1], event) {
+		t.Fatal("the frame should be written once complete")
+	}
+
+	// Two frames in a single write
+	ping := serverFrame(0x9, "")
+	conn.Write(append(bytes.Clone(ping), event...))
+	if len(rec.writes) != 4 || !bytes.Equal(rec.writes[2], ping) || !bytes.Equal(rec.writes[3], event) {
+		t.Fatal("frames of a single write should be written one by one")
+	}
+	if len(conn.pending) != 0 {
+		t.Errorf("expected no pending bytes, got %d", len(conn.pending))
+	}
+}
+
+func TestLimitedConnPacing(t *testing.T) {
+	conn, _ := newTestLimitedConn(50, 2, 0)
+	frame := serverFrame(wsOpText, `["EOSE","sub"]`)
+
+	start := time.Now()
+	for range 4 {
+		conn.Write(frame)
+	}
+
+	// 2 frames of burst, then 2 frames at 50 per second
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("expected frames to be paced, took %s", elapsed)
+	}
+	if got := conn.limiter.obs.pacedFrameCount.Load(); got != 2 {
+		t.Errorf("expected 2 paced frames, got %d", got)
+	}
+
+	// Control frames are never delayed
+	start = time.Now()
+	conn.Write(serverFrame(0x9, ""))
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("control frames should not be paced, took %s", elapsed)
+	}
+}
@@ -0,0 +1,185 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// rankCommand implements `wotrlay rank [flags] <pubkey>|list|set|remove`, and returns the process exit code.
+func rankCommand(args []string) int {
+	cfg := loadConfig()
+
+	flags := flag.NewFlagSet("rank", flag.ContinueOnError)
+	configFile := flags.String("config", cfg.ConfigFile, "file the overrides are saved to")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for the rank provider")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay rank [flags] <pubkey>")
+		fmt.Fprintln(flags.Output(), "       wotrlay rank [flags] list")
+		fmt.Fprintln(flags.Output(), "       wotrlay rank [flags] set <pubkey> <rank>")
+		fmt.Fprintln(flags.Output(), "       wotrlay rank [flags] remove <pubkey>")
+		fmt.Fprintln(flags.Output(), "\nPrints the rank and tier of a pubkey (hex or npub), from the RANK_PROVIDER unless")
+		fmt.Fprintln(flags.Output(), "the operator set it, or lists or edits the RANK_OVERRIDES pinning the rank of pubkeys.")
+		fmt.Fprintln(flags.Output(), "Changes apply when the relay restarts; use the admin API to change a running relay.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 1 && flags.Arg(0) != "list" {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := queryRank(ctx, cfg, flags.Arg(0), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		return 0
+	}
+	// The overrides of the file being edited are the ones edited, even if the
+	// environment of the command sets others
+	fallback := cfg.RankOverrides
+	if *configFile != cfg.ConfigFile {
+		fallback = nil
+	}
+	overrides, err := fileRankOverrides(*configFile, fallback)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	err = editRankOverrides(overrides, flags.Args(), os.Stdout, func(overrides map[string]float64) error {
+		return persistEnvFile(*configFile, map[string]string{"RANK_OVERRIDES": formatRankOverrides(overrides)})
+	})
+	if errors.Is(err, flag.ErrHelp) {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// queryRank prints the rank of the pubkey as the relay looks it up, with its tier and where
+// it comes from: "operator" for ALLOWED_PUBKEYS and RANK_OVERRIDES, the RANK_PROVIDER otherwise.
+// The local provider crawls the follow graph from its seeds first, which takes a while.
+func queryRank(ctx context.Context, cfg Config, arg string, w io.Writer) error {
+	pubkey, err := parsePubkeyArg(arg)
+	if err != nil {
+		return err
+	}
+
+	rank, source := 0.0, cfg.RankProvider
+	if operator, ok := operatorRank(pubkey, cfg); ok {
+		rank, source = operator, "operator"
+	} else if cfg.RankProvider == rankProviderLocal {
+		ranks, err := NewFollowGraph(ctx, cfg).Compute(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to compute the follow graph: %w", err)
+		}
+		rank = ranks[pubkey]
+	} else {
+		cache := NewRankCache(ctx, cfg, &Observability{})
+		if rank, err = cache.GetRank(ctx, pubkey); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "%s %s %s %s\n", pubkey, strconv.FormatFloat(rank, 'g', -1, 64), tierFor(rank, cfg), source)
+	return nil
+}
+
+// fileRankOverrides returns the RANK_OVERRIDES set in the .env file at path, or fallback
+// if the file doesn't exist or doesn't set them.
+func fileRankOverrides(path string, fallback map[string]float64) (map[string]float64, error) {
+	env, err := godotenv.Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fallback, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	value, ok := env["RANK_OVERRIDES"]
+	if !ok {
+		return fallback, nil
+	}
+	overrides, err := parseRankOverrides(getEnvList(func(string) string { return value }, "RANK_OVERRIDES"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: RANK_OVERRIDES: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// editRankOverrides runs the list, set or remove command of args on the overrides,
+// printing them to w, and passes the edited overrides to save.
+// It returns flag.ErrHelp if args aren't a command.
+func editRankOverrides(overrides map[string]float64, args []string, w io.Writer, save func(map[string]float64) error) error {
+	if len(args) == 0 {
+		return flag.ErrHelp
+	}
+	overrides = maps.Clone(overrides)
+	if overrides == nil {
+		overrides = make(map[string]float64)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		for _, override := range listRankOverrides(Config{RankOverrides: overrides}) {
+			fmt.Fprintf(w, "%s %s\n", override.Pubkey, strconv.FormatFloat(override.Rank, 'g', -1, 64))
+		}
+		return nil
+
+	case args[0] == "set" && len(args) == 3:
+		pubkey, err := parsePubkeyArg(args[1])
+		if err != nil {
+			return err
+		}
+		rank, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || math.IsNaN(rank) || rank < 0 || rank > 1 {
+			return fmt.Errorf("rank must be a number between 0 and 1, got %q", args[2])
+		}
+		overrides[pubkey] = rank
+
+	case args[0] == "remove" && len(args) == 2:
+		pubkey, err := parsePubkeyArg(args[1])
+		if err != nil {
+			return err
+		}
+		if _, ok := overrides[pubkey]; !ok {
+			return fmt.Errorf("%s has no rank override", pubkey)
+		}
+		delete(overrides, pubkey)
+
+	default:
+		return flag.ErrHelp
+	}
+	return save(overrides)
+}
+
+// parsePubkeyArg returns the hex pubkey of a hex pubkey or npub.
+func parsePubkeyArg(arg string) (string, error) {
+	if prefix, value, err := nip19.Decode(arg); err == nil && prefix == "npub" {
+		return value.(string), nil
+	}
+	if !nostr.IsValid32ByteHex(arg) {
+		return "", fmt.Errorf("pubkey must be a hex pubkey or an npub, got %q", arg)
+	}
+	return arg, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestEditRankOverrides(t *testing.T) {
+	alice, bob := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	npub, _ := nip19.EncodePublicKey(bob)
+	overrides := map[string]float64{alice: 0.5}
+
+	var saved map[string]float64
+	save := func(o map[string]float64) error { saved = o; return nil }
+
+	if err := editRankOverrides(overrides, []string{"set", npub, "0.9"}, nil, save); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[bob] != 0.9 || saved[alice] != 0.5 {
+		t.Errorf("set: got %v", saved)
+	}
+	if len(overrides) != 1 {
+		t.Error("the overrides passed in must not be modified")
+	}
+
+	if err := editRankOverrides(saved, []string{"remove", alice}, nil, save); err != nil || len(saved) != 1 {
+		t.Errorf("remove: got %v, %v", saved, err)
+	}
+	if err := editRankOverrides(saved, []string{"remove", alice}, nil, save); err == nil {
+		t.Error("expected an error removing a missing override")
+	}
+
+	var out bytes.Buffer
+	if err := editRankOverrides(saved, []string{"list"}, &out, save); err != nil || out.String() != bob+" 0.9\n" {
+		t.Errorf("list: got %q, %v", out.String(), err)
+	}
+
+	for _, args := range [][]string{{"set", alice, "2"}, {"set", "nobody", "0.5"}} {
+		if err := editRankOverrides(nil, args, nil, save); err == nil || errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%v: expected an error, got %v", args, err)
+		}
+	}
+	for _, args := range [][]string{nil, {"set", alice}, {"promote", alice}} {
+		if err := editRankOverrides(nil, args, nil, save); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%v: expected the usage, got %v", args, err)
+		}
+	}
+}
+
+func TestRankOverridesFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".env")
+	os.WriteFile(file, []byte("MID_THRESHOLD=0.6\n"), 0o600)
+
+	pubkey := strings.Repeat("a", 64)
+	err := editRankOverrides(nil, []string{"set", pubkey, "0.75"}, nil, func(overrides map[string]float64) error {
+		return persistEnvFile(file, map[string]string{"RANK_OVERRIDES": formatRankOverrides(overrides)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if want := "MID_THRESHOLD=0.6\nRANK_OVERRIDES='" + pubkey + ":0.75'\n"; string(data) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+
+	// The overrides of the file are edited, not those of the environment
+	fallback := map[string]float64{strings.Repeat("b", 64): 0.5}
+	if overrides, err := fileRankOverrides(file, fallback); err != nil || len(overrides) != 1 || overrides[pubkey] != 0.75 {
+		t.Errorf("expected the overrides of the file, got %v, %v", overrides, err)
+	}
+	missing := filepath.Join(t.TempDir(), ".env")
+	if overrides, err := fileRankOverrides(missing, fallback); err != nil || len(overrides) != 1 || overrides[pubkey] != 0 {
+		t.Errorf("expected the fallback overrides without a file, got %v, %v", overrides, err)
+	}
+}
+
+func TestQueryRank(t *testing.T) {
+	alice := strings.Repeat("a", 64)
+	npub, _ := nip19.EncodePublicKey(alice)
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"RANK_OVERRIDES": alice + ":0.75", "MID_THRESHOLD": "0.5"}[key]
+	})
+
+	// Operators' ranks are printed without asking the provider
+	var out bytes.Buffer
+	if err := queryRank(context.Background(), cfg, npub, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := alice + " 0.75 high operator\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+	if err := queryRank(context.Background(), cfg, "nobody", &out); err == nil {
+		t.Error("expected an error for an invalid pubkey")
+	}
+}
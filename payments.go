@@ -0,0 +1,470 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/pippellia-btc/rely"
+)
+
+// Lightning backends issuing the invoices of paid memberships
+const (
+	paymentsLNbits = "lnbits"
+	paymentsLND    = "lnd"
+)
+
+// Key prefixes of the payment records in the event store. The event store only uses
+// prefixes 0-8 and 255, the honeypot labels 128, the store metadata 129, the tombstones 130,
+// the refresh queue 131, the onboarding records 132 and the compaction counts 133.
+//   - membershipPrefix <pubkey> holds the JSON membership of the pubkey, until it expires
+//   - invoicePrefix <payment hash> holds the JSON pendingInvoice, until it's paid or expires
+const (
+	membershipPrefix byte = 134
+	invoicePrefix    byte = 135
+)
+
+const (
+	// invoiceExpiry is how long an invoice can be paid.
+	invoiceExpiry = time.Hour
+
+	// invoicesPerHour bounds the invoices an IP group may request.
+	invoicesPerHour = 10
+
+	// invoiceChecksPerMinute bounds the invoice checks an IP group may request, each a
+	// request to the backend.
+	invoiceChecksPerMinute = 30
+
+	// pendingVerifiers bounds the invoices VerifyPending checks at the same time.
+	pendingVerifiers = 4
+)
+
+var ErrUnknownInvoice = errors.New("unknown or expired invoice")
+
+// Invoice is a Lightning invoice issued by a backend.
+type Invoice struct {
+	PaymentHash    string // hex
+	PaymentRequest string // bolt11
+}
+
+// LightningBackend issues Lightning invoices and tells whether they were paid.
+type LightningBackend interface {
+	CreateInvoice(ctx context.Context, amountMsats int64, memo string, expiry time.Duration) (Invoice, error)
+	Paid(ctx context.Context, paymentHash string) (bool, error)
+}
+
+// membership is the paid membership of a pubkey.
+type membership struct {
+	Expires time.Time `json:"expires"`
+}
+
+// pendingInvoice is an invoice issued to a pubkey, waiting for its payment.
+type pendingInvoice struct {
+	Pubkey  string    `json:"pubkey"`
+	Expires time.Time `json:"expires"`
+}
+
+// PaymentInvoice is the body of a response issuing an invoice.
+type PaymentInvoice struct {
+	PaymentHash string    `json:"payment_hash"`
+	Invoice     string    `json:"invoice"`
+	AmountMsats int64     `json:"amount_msats"`
+	Days        int       `json:"days"`    // days of membership the payment buys
+	Expires     time.Time `json:"expires"` // when the invoice expires
+}
+
+// PaymentStatus is the body of a response checking an invoice.
+type PaymentStatus struct {
+	Pubkey      string    `json:"pubkey"`
+	Paid        bool      `json:"paid"`
+	MemberUntil time.Time `json:"member_until,omitzero"`
+}
+
+// Payments sells memberships: a pubkey paying a Lightning invoice gets at least Rank
+// for Days, extended by each further payment. Invoices and memberships live in the
+// relay's event store, so they survive restarts.
+type Payments struct {
+	db      *badger.BadgerBackend
+	backend LightningBackend
+	obs     *Observability
+	mu      sync.Mutex // serializes settlements, so that a payment is only credited once, but not the backend requests
+
+	PriceMsats int64
+	Days       int
+	Rank       float64
+}
+
+func NewPayments(db *badger.BadgerBackend, cfg Config, obs *Observability) *Payments {
+	client := &http.Client{Timeout: 30 * time.Second}
+	var backend LightningBackend
+	switch cfg.PaymentsBackend {
+	case paymentsLND:
+		backend = &lndBackend{url: cfg.PaymentsBackendURL, macaroon: cfg.PaymentsBackendKey, client: client}
+	default:
+		backend = &lnbitsBackend{url: cfg.PaymentsBackendURL, key: cfg.PaymentsBackendKey, client: client}
+	}
+	return &Payments{
+		db:         db,
+		backend:    backend,
+		obs:        obs,
+		PriceMsats: int64(cfg.PaymentsPriceMsats),
+		Days:       cfg.PaymentsDays,
+		Rank:       cfg.PaymentsRank,
+	}
+}
+
+// Issue returns a new invoice buying a membership for the pubkey.
+func (p *Payments) Issue(ctx context.Context, pubkey string, now time.Time) (PaymentInvoice, error) {
+	invoice, err := p.backend.CreateInvoice(ctx, p.PriceMsats, fmt.Sprintf("%d days of relay membership for %s", p.Days, pubkey), invoiceExpiry)
+	if err != nil {
+		return PaymentInvoice{}, fmt.Errorf("failed to create invoice: %w", err)
+	}
+
+	expires := now.Add(invoiceExpiry)
+	if err := p.save(invoiceKey(invoice.PaymentHash), pendingInvoice{Pubkey: pubkey, Expires: expires}, invoiceExpiry); err != nil {
+		return PaymentInvoice{}, err
+	}
+	p.obs.paymentsInvoicedCount.Add(1)
+	return PaymentInvoice{
+		PaymentHash: invoice.PaymentHash,
+		Invoice:     invoice.PaymentRequest,
+		AmountMsats: p.PriceMsats,
+		Days:        p.Days,
+		Expires:     expires,
+	}, nil
+}
+
+// Verify asks the backend whether the invoice was paid, and extends the membership of its
+// pubkey by Days once it is.
+func (p *Payments) Verify(ctx context.Context, paymentHash string, now time.Time) (PaymentStatus, error) {
+	var pending pendingInvoice
+	found, err := p.load(invoiceKey(paymentHash), &pending)
+	if err != nil {
+		return PaymentStatus{}, err
+	}
+	if !found {
+		return PaymentStatus{}, ErrUnknownInvoice
+	}
+
+	status := PaymentStatus{Pubkey: pending.Pubkey}
+	paid, err := p.backend.Paid(ctx, paymentHash)
+	if err != nil {
+		return status, fmt.Errorf("failed to check invoice: %w", err)
+	}
+	if !paid {
+		return status, nil
+	}
+
+	expires, err := p.settle(paymentHash, pending.Pubkey, now)
+	if err != nil {
+		return status, err
+	}
+
+	p.obs.paymentsSettledCount.Add(1)
+	relayLog.InfoContext(ctx, "membership paid", "pubkey", pending.Pubkey, "until", expires)
+	status.Paid, status.MemberUntil = true, expires
+	return status, nil
+}
+
+// settle extends the membership of the pubkey by Days, and deletes the paid invoice.
+// It returns ErrUnknownInvoice if the invoice was settled meanwhile.
+func (p *Payments) settle(paymentHash, pubkey string, now time.Time) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pending pendingInvoice
+	if found, err := p.load(invoiceKey(paymentHash), &pending); err != nil || !found {
+		return time.Time{}, cmp.Or(err, ErrUnknownInvoice)
+	}
+
+	// Renewals extend the current membership
+	start := now
+	if expires, ok := p.Member(pubkey, now); ok {
+		start = expires
+	}
+	expires := start.Add(time.Duration(p.Days) * 24 * time.Hour)
+	value, err := json.Marshal(membership{Expires: expires})
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = p.db.Update(func(txn *badgerdb.Txn) error {
+		if err := txn.SetEntry(badgerdb.NewEntry(membershipKey(pubkey), value).WithTTL(expires.Sub(now))); err != nil {
+			return err
+		}
+		return txn.Delete(invoiceKey(paymentHash))
+	})
+	return expires, err
+}
+
+// VerifyPending verifies the invoices waiting for their payment, pendingVerifiers at a
+// time, so that memberships are granted even if the payer never checks back.
+func (p *Payments) VerifyPending(ctx context.Context, now time.Time) error {
+	var hashes []string
+	err := p.db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{invoicePrefix}})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			hashes = append(hashes, string(it.Item().Key()[1:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	verifiers := make(chan struct{}, pendingVerifiers)
+	for _, hash := range hashes {
+		select {
+		case verifiers <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-verifiers; wg.Done() }()
+			if _, err := p.Verify(ctx, hash, now); err != nil && !errors.Is(err, ErrUnknownInvoice) {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", hash, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Member returns when the membership of the pubkey expires, if it has one.
+func (p *Payments) Member(pubkey string, now time.Time) (time.Time, bool) {
+	var m membership
+	found, err := p.load(membershipKey(pubkey), &m)
+	if err != nil {
+		limiterLog.Error("failed to load the membership", "pubkey", pubkey, "error", err)
+	}
+	if !found || !m.Expires.After(now) {
+		return time.Time{}, false
+	}
+	return m.Expires, true
+}
+
+func (p *Payments) load(key []byte, v any) (bool, error) {
+	err := p.db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		return item.Value(func(value []byte) error {
+			return json.Unmarshal(value, v)
+		})
+	})
+	if errors.Is(err, badgerdb.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *Payments) save(key []byte, v any, ttl time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.db.Update(func(txn *badgerdb.Txn) error {
+		return txn.SetEntry(badgerdb.NewEntry(key, value).WithTTL(ttl))
+	})
+}
+
+func membershipKey(pubkey string) []byte {
+	return append([]byte{membershipPrefix}, pubkey...)
+}
+
+func invoiceKey(paymentHash string) []byte {
+	return append([]byte{invoicePrefix}, paymentHash...)
+}
+
+// paymentsHandler serves the memberships of the relay under root, and reports whether
+// the request was for it:
+//   - POST <root>/pay                  issue an invoice, e.g. {"pubkey":"npub1..."}
+//   - GET  <root>/pay?payment_hash=<hex>  check the invoice, granting the membership once paid
+//
+// Each IP group may request invoicesPerHour invoices an hour, and invoiceChecksPerMinute
+// checks a minute.
+func paymentsHandler(w http.ResponseWriter, r *http.Request, root string, d *Deps) bool {
+	if d.Payments == nil || r.URL.Path != path.Join(root, "pay") {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodPost:
+		group := rely.GetIP(r).Group()
+		if !d.GlobalLimiter.Allow("pay:"+group, invoicesPerHour, invoicesPerHour/3600.0) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return true
+		}
+
+		var body struct {
+			Pubkey string `json:"pubkey"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1024)).Decode(&body); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+		pubkey := body.Pubkey
+		if prefix, value, err := nip19.Decode(pubkey); err == nil && prefix == "npub" {
+			pubkey = value.(string)
+		}
+		if !nostr.IsValid32ByteHex(pubkey) {
+			http.Error(w, "pubkey must be a hex pubkey or an npub", http.StatusBadRequest)
+			return true
+		}
+
+		invoice, err := d.Payments.Issue(ctx, pubkey, d.now())
+		if err != nil {
+			relayLog.ErrorContext(ctx, "failed to issue invoice", "error", err)
+			http.Error(w, "failed to issue invoice", http.StatusBadGateway)
+			return true
+		}
+		writeJSON(w, invoice)
+
+	case http.MethodGet:
+		group := rely.GetIP(r).Group()
+		if !d.GlobalLimiter.Allow("pay-check:"+group, invoiceChecksPerMinute, invoiceChecksPerMinute/60.0) {
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return true
+		}
+
+		status, err := d.Payments.Verify(ctx, r.URL.Query().Get("payment_hash"), d.now())
+		switch {
+		case errors.Is(err, ErrUnknownInvoice):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			relayLog.ErrorContext(ctx, "failed to verify invoice", "error", err)
+			http.Error(w, "failed to verify invoice", http.StatusBadGateway)
+		default:
+			writeJSON(w, status)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+	return true
+}
+
+// lnbitsBackend issues invoices through the REST API of an LNbits wallet, with its invoice key.
+type lnbitsBackend struct {
+	url, key string
+	client   *http.Client
+}
+
+func (b *lnbitsBackend) CreateInvoice(ctx context.Context, amountMsats int64, memo string, expiry time.Duration) (Invoice, error) {
+	request := map[string]any{
+		"out":    false,
+		"amount": (amountMsats + 999) / 1000, // LNbits takes sats
+		"memo":   memo,
+		"expiry": int(expiry.Seconds()),
+	}
+	var response struct {
+		PaymentHash    string `json:"payment_hash"`
+		PaymentRequest string `json:"payment_request"`
+	}
+	err := doJSON(ctx, b.client, http.MethodPost, b.url+"/api/v1/payments", map[string]string{"X-Api-Key": b.key}, request, &response)
+	return Invoice{PaymentHash: response.PaymentHash, PaymentRequest: response.PaymentRequest}, err
+}
+
+func (b *lnbitsBackend) Paid(ctx context.Context, paymentHash string) (bool, error) {
+	var response struct {
+		Paid bool `json:"paid"`
+	}
+	err := doJSON(ctx, b.client, http.MethodGet, b.url+"/api/v1/payments/"+paymentHash, map[string]string{"X-Api-Key": b.key}, nil, &response)
+	return response.Paid, err
+}
+
+// lndBackend issues invoices through the REST API of an LND node, with an invoice macaroon in hex.
+type lndBackend struct {
+	url, macaroon string
+	client        *http.Client
+}
+
+func (b *lndBackend) CreateInvoice(ctx context.Context, amountMsats int64, memo string, expiry time.Duration) (Invoice, error) {
+	request := map[string]any{
+		"value_msat": fmt.Sprint(amountMsats),
+		"memo":       memo,
+		"expiry":     fmt.Sprint(int(expiry.Seconds())),
+	}
+	var response struct {
+		RHash          string `json:"r_hash"` // base64
+		PaymentRequest string `json:"payment_request"`
+	}
+	if err := doJSON(ctx, b.client, http.MethodPost, b.url+"/v1/invoices", map[string]string{"Grpc-Metadata-macaroon": b.macaroon}, request, &response); err != nil {
+		return Invoice{}, err
+	}
+	hash, err := base64.StdEncoding.DecodeString(response.RHash)
+	if err != nil {
+		return Invoice{}, fmt.Errorf("invalid r_hash: %w", err)
+	}
+	return Invoice{PaymentHash: hex.EncodeToString(hash), PaymentRequest: response.PaymentRequest}, nil
+}
+
+func (b *lndBackend) Paid(ctx context.Context, paymentHash string) (bool, error) {
+	var response struct {
+		State string `json:"state"`
+	}
+	err := doJSON(ctx, b.client, http.MethodGet, b.url+"/v1/invoice/"+paymentHash, map[string]string{"Grpc-Metadata-macaroon": b.macaroon}, nil, &response)
+	return response.State == "SETTLED", err
+}
+
+// doJSON sends the request, with the JSON body if not nil, and decodes the JSON response into out.
+func doJSON(ctx context.Context, client *http.Client, method, url string, headers map[string]string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeLNbits is an LNbits wallet whose invoices are paid by setting paid.
+type fakeLNbits struct {
+	mu       sync.Mutex
+	invoices int
+	paid     map[string]bool
+}
+
+func (f *fakeLNbits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Api-Key") != "invoice-key" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/payments":
+		var request struct {
+			Amount int64 `json:"amount"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		f.invoices++
+		hash := strings.Repeat(string(rune('0'+f.invoices)), 64)
+		writeJSON(w, map[string]any{"payment_hash": hash, "payment_request": "lnbc" + string(rune('0'+request.Amount))})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/payments/"):
+		writeJSON(w, map[string]bool{"paid": f.paid[strings.TrimPrefix(r.URL.Path, "/api/v1/payments/")]})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeLNbits) pay(hash string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paid[hash] = true
+}
+
+func newTestPayments(t *testing.T) (*Payments, *fakeLNbits) {
+	t.Helper()
+	wallet := &fakeLNbits{paid: make(map[string]bool)}
+	server := httptest.NewServer(wallet)
+	t.Cleanup(server.Close)
+
+	cfg := Config{
+		PaymentsBackend:    paymentsLNbits,
+		PaymentsBackendURL: server.URL,
+		PaymentsBackendKey: "invoice-key",
+		PaymentsPriceMsats: 5000,
+		PaymentsDays:       30,
+		PaymentsRank:       0.8,
+	}
+	return NewPayments(newTestDB(t), cfg, &Observability{}), wallet
+}
+
+func TestPayments(t *testing.T) {
+	ctx := context.Background()
+	payments, wallet := newTestPayments(t)
+	pubkey := strings.Repeat("ab", 32)
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	invoice, err := payments.Issue(ctx, pubkey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Invoice != "lnbc5" || invoice.AmountMsats != 5000 || invoice.Days != 30 {
+		t.Errorf("unexpected invoice %+v", invoice)
+	}
+
+	status, err := payments.Verify(ctx, invoice.PaymentHash, now)
+	if err != nil || status.Paid || status.Pubkey != pubkey {
+		t.Fatalf("unpaid invoice: got %+v, %v", status, err)
+	}
+	if _, ok := payments.Member(pubkey, now); ok {
+		t.Fatal("no membership expected before the payment")
+	}
+
+	wallet.pay(invoice.PaymentHash)
+	status, err = payments.Verify(ctx, invoice.PaymentHash, now)
+	if want := now.Add(30 * 24 * time.Hour); err != nil || !status.Paid || !status.MemberUntil.Equal(want) {
+		t.Fatalf("paid invoice: got %+v, %v, want membership until %v", status, err, want)
+	}
+	if _, err := payments.Verify(ctx, invoice.PaymentHash, now); !errors.Is(err, ErrUnknownInvoice) {
+		t.Errorf("a settled invoice is only credited once, got %v", err)
+	}
+
+	// A renewal extends the membership
+	renewal, err := payments.Issue(ctx, pubkey, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet.pay(renewal.PaymentHash)
+	if err := payments.VerifyPending(ctx, now.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	expires, ok := payments.Member(pubkey, now.Add(24*time.Hour))
+	if want := now.Add(60 * 24 * time.Hour); !ok || !expires.Equal(want) {
+		t.Errorf("renewal: expected membership until %v, got %v, %v", want, expires, ok)
+	}
+	if _, ok := payments.Member(pubkey, now.Add(61*24*time.Hour)); ok {
+		t.Error("expected the membership to expire")
+	}
+	if got := payments.obs.paymentsSettledCount.Load(); got != 2 {
+		t.Errorf("expected 2 settled payments, got %d", got)
+	}
+}
+
+// slowBackend is a LightningBackend whose payment checks wait for release.
+type slowBackend struct {
+	LightningBackend
+	checking chan struct{}
+	release  chan struct{}
+}
+
+func (b *slowBackend) Paid(ctx context.Context, paymentHash string) (bool, error) {
+	b.checking <- struct{}{}
+	<-b.release
+	return b.LightningBackend.Paid(ctx, paymentHash)
+}
+
+func TestPaymentsVerifyConcurrent(t *testing.T) {
+	ctx := context.Background()
+	payments, wallet := newTestPayments(t)
+	pubkey := strings.Repeat("ab", 32)
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	invoice, err := payments.Issue(ctx, pubkey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet.pay(invoice.PaymentHash)
+
+	const checks = 8
+	backend := &slowBackend{LightningBackend: payments.backend, checking: make(chan struct{}), release: make(chan struct{})}
+	payments.backend = backend
+
+	var wg sync.WaitGroup
+	for range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payments.Verify(ctx, invoice.PaymentHash, now)
+		}()
+	}
+
+	// The settlement lock is not held while the backend is asked
+	for range checks {
+		<-backend.checking
+	}
+	if !payments.mu.TryLock() {
+		t.Fatal("expected the lock to be released during the backend requests")
+	}
+	payments.mu.Unlock()
+	close(backend.release)
+	wg.Wait()
+
+	expires, ok := payments.Member(pubkey, now)
+	if want := now.Add(30 * 24 * time.Hour); !ok || !expires.Equal(want) {
+		t.Errorf("expected membership until %v, got %v, %v", want, expires, ok)
+	}
+	if got := payments.obs.paymentsSettledCount.Load(); got != 1 {
+		t.Errorf("expected the payment to be credited once, got %d", got)
+	}
+}
+
+func TestPaymentsHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payments, wallet := newTestPayments(t)
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	d := &Deps{Cache: NewRankCache(ctx, cfg, obs), GlobalLimiter: NewLimiter(ctx), Obs: obs, Payments: payments}
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if !paymentsHandler(w, r, "/", d) {
+			t.Fatalf("%s %s not handled", r.Method, r.URL)
+		}
+		return w
+	}
+
+	w := serve(httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"pubkey":"`+pubkey+`"}`)))
+	var invoice PaymentInvoice
+	if err := json.NewDecoder(w.Body).Decode(&invoice); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("issue: got %d, %v", w.Code, err)
+	}
+	if w := serve(httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(`{"pubkey":"nope"}`))); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid pubkey: got %d", w.Code)
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/pay?payment_hash=unknown", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("unknown invoice: got %d", w.Code)
+	}
+
+	if rank := lookupRank(ctx, pubkey, cfg, d); rank != 0 {
+		t.Fatalf("expected rank 0 before the payment, got %v", rank)
+	}
+	wallet.pay(invoice.PaymentHash)
+	w = serve(httptest.NewRequest(http.MethodGet, "/pay?payment_hash="+invoice.PaymentHash, nil))
+	var status PaymentStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil || !status.Paid {
+		t.Fatalf("check: got %d %+v, %v", w.Code, status, err)
+	}
+	if rank := lookupRank(ctx, pubkey, cfg, d); rank != 0.8 {
+		t.Errorf("expected members to get PAYMENTS_RANK, got %v", rank)
+	}
+
+	for range invoiceChecksPerMinute {
+		serve(httptest.NewRequest(http.MethodGet, "/pay?payment_hash="+invoice.PaymentHash, nil))
+	}
+	if w := serve(httptest.NewRequest(http.MethodGet, "/pay?payment_hash="+invoice.PaymentHash, nil)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the checks to be rate limited, got %d", w.Code)
+	}
+
+	if r := httptest.NewRequest(http.MethodGet, "/check", nil); paymentsHandler(httptest.NewRecorder(), r, "/", d) {
+		t.Error("other paths must not be handled")
+	}
+}
+
+func TestLNDBackend(t *testing.T) {
+	hash := []byte(strings.Repeat("\x01", 32))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Grpc-Metadata-macaroon") != "cafe" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/invoices":
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			writeJSON(w, map[string]string{"r_hash": base64.StdEncoding.EncodeToString(hash), "payment_request": "lnbc" + request["value_msat"]})
+		case "/v1/invoice/" + strings.Repeat("01", 32):
+			writeJSON(w, map[string]string{"state": "SETTLED"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	backend := &lndBackend{url: server.URL, macaroon: "cafe", client: server.Client()}
+	invoice, err := backend.CreateInvoice(ctx, 5000, "membership", time.Hour)
+	if err != nil || invoice.PaymentHash != strings.Repeat("01", 32) || invoice.PaymentRequest != "lnbc5000" {
+		t.Fatalf("got %+v, %v", invoice, err)
+	}
+	if paid, err := backend.Paid(ctx, invoice.PaymentHash); err != nil || !paid {
+		t.Errorf("expected a settled invoice, got %v, %v", paid, err)
+	}
+
+	backend.macaroon = "wrong"
+	if _, err := backend.Paid(ctx, invoice.PaymentHash); err == nil {
+		t.Error("expected an error from a rejected request")
+	}
+}
+
+func TestPaymentsConfig(t *testing.T) {
+	base := map[string]string{
+		"PAYMENTS_BACKEND":     "lnbits",
+		"PAYMENTS_BACKEND_URL": "https://lnbits.example.com/",
+		"PAYMENTS_BACKEND_KEY": "key",
+		"PAYMENTS_PRICE_MSATS": "21000",
+	}
+	cfg, err := buildConfig(func(key string) string { return base[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PaymentsBackendURL != "https://lnbits.example.com" || cfg.FeesSubscription[30] != 21000 {
+		t.Errorf("expected the membership to be advertised as the subscription fee, got %v", cfg.FeesSubscription)
+	}
+
+	for key, value := range map[string]string{
+		"PAYMENTS_BACKEND":     "paypal",
+		"PAYMENTS_BACKEND_URL": "",
+		"PAYMENTS_PRICE_MSATS": "0",
+		"PAYMENTS_RANK":        "2",
+	} {
+		env := map[string]string{key: value}
+		if _, err := buildConfig(func(k string) string {
+			if v, ok := env[k]; ok {
+				return v
+			}
+			return base[k]
+		}); err == nil {
+			t.Errorf("%s=%s: expected an error", key, value)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"math"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// maxPoWBonusBits bounds the bits over the difficulty that earn bonus tokens.
+const maxPoWBonusBits = 16
+
+// powBonus returns the tokens the NIP-13 proof of work of an unranked pubkey's event is
+// worth: 1 at UnrankedPoWDifficulty, doubling with each bit over it, or 0 if the event
+// doesn't commit to at least that difficulty in its nonce tag. Committing to a target
+// keeps lucky IDs from counting as work.
+func powBonus(e *nostr.Event, rank float64, cfg Config) float64 {
+	if cfg.UnrankedPoWDifficulty <= 0 || rank > 0 {
+		return 0
+	}
+	bits := nip13.CommittedDifficulty(e)
+	if bits < cfg.UnrankedPoWDifficulty {
+		return 0
+	}
+	return math.Ldexp(1, min(bits-cfg.UnrankedPoWDifficulty, maxPoWBonusBits))
+}
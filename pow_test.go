@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// workedEvent returns a note of the key with NIP-13 proof of work committing to the difficulty.
+func workedEvent(t *testing.T, sk, content string, difficulty int) *nostr.Event {
+	t.Helper()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	e := &nostr.Event{PubKey: pubkey, Kind: 1, CreatedAt: nostr.Now(), Content: content}
+	tag, err := nip13.DoWork(context.Background(), *e, difficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Tags = append(e.Tags, tag)
+	if err := e.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestPoWBonus(t *testing.T) {
+	cfg := Config{UnrankedPoWDifficulty: 4}
+	sk := nostr.GeneratePrivateKey()
+
+	e := workedEvent(t, sk, "worked for it", 6)
+	if got := powBonus(e, 0, cfg); got != 4 {
+		t.Errorf("2 bits over the difficulty: expected 4 tokens, got %v", got)
+	}
+	if got := powBonus(e, 0.1, cfg); got != 0 {
+		t.Errorf("ranked pubkeys earn no bonus, got %v", got)
+	}
+	if got := powBonus(workedEvent(t, sk, "worked for it", 2), 0, cfg); got != 0 {
+		t.Errorf("work under the difficulty earns no bonus, got %v", got)
+	}
+
+	// An ID with leading zeros but no commitment isn't work
+	e.Tags = nil
+	if got := powBonus(e, 0, cfg); got != 0 {
+		t.Errorf("uncommitted work earns no bonus, got %v", got)
+	}
+	if got := powBonus(workedEvent(t, sk, "worked for it", 6), 0, Config{}); got != 0 {
+		t.Errorf("no bonus when disabled, got %v", got)
+	}
+}
+
+func TestUnrankedPoW(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"UNRANKED_POW_DIFFICULTY": "8"}[key]
+	})
+	obs := &Observability{}
+	db := newTestDB(t)
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0})
+
+	publish := func(e *nostr.Event) error {
+		return handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d)
+	}
+	note := func(content string) *nostr.Event {
+		e := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: content}
+		e.Sign(sk)
+		return e
+	}
+
+	if err := publish(note("first")); err != nil {
+		t.Fatalf("first event of the day: %v", err)
+	}
+	if err := publish(note("second")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second event without work: got %v, want %v", err, ErrRateLimited)
+	}
+	for i := range 3 {
+		if err := publish(workedEvent(t, sk, fmt.Sprintf("worked for it %d", i), 8)); err != nil {
+			t.Fatalf("event %d with proof of work: %v", i, err)
+		}
+	}
+	if got := obs.powPaidCount.Load(); got != 3 {
+		t.Errorf("expected 3 events paid with work, got %d", got)
+	}
+
+	if check := checkWrite(ctx, pubkey, cfg, d); check.PoW != 8 {
+		t.Errorf("expected /check to tell the difficulty, got %d", check.PoW)
+	}
+}
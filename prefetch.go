@@ -0,0 +1,76 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// RankPrefetch warms the rank cache with the authors clients subscribe to: a pubkey read
+// through the relay is likely to publish to it next, and its first event shouldn't wait
+// for the rank provider or fall back to rank 0. Each connection gets PerConnection pubkeys
+// queued at most, so a REQ listing thousands of authors can't take over the refresh queue.
+type RankPrefetch struct {
+	cache         *RankCache
+	PerConnection int
+
+	mu      sync.Mutex
+	clients map[string]map[string]struct{} // pubkeys queued, by client UID
+}
+
+// NewRankPrefetch returns a RankPrefetch queueing up to perConnection pubkeys per connection,
+// or nil if perConnection is 0.
+func NewRankPrefetch(cache *RankCache, perConnection int) *RankPrefetch {
+	if perConnection <= 0 {
+		return nil
+	}
+	return &RankPrefetch{cache: cache, PerConnection: perConnection, clients: make(map[string]map[string]struct{})}
+}
+
+// Authors queues a rank refresh of the authors of the filters that aren't cached,
+// and returns how many were queued. A nil RankPrefetch queues nothing.
+func (p *RankPrefetch) Authors(c rely.Client, filters nostr.Filters) int {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queued := p.clients[c.UID()]
+	if queued == nil {
+		queued = make(map[string]struct{})
+		p.clients[c.UID()] = queued
+	}
+
+	n := 0
+	for _, f := range filters {
+		for _, pubkey := range f.Authors {
+			if len(queued) >= p.PerConnection {
+				return n
+			}
+			if _, ok := queued[pubkey]; ok || !nostr.IsValid32ByteHex(pubkey) {
+				continue
+			}
+			if p.cache.Prefetch(pubkey) {
+				queued[pubkey] = struct{}{}
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Disconnect forgets the pubkeys queued for the client.
+func (p *RankPrefetch) Disconnect(c rely.Client) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.clients, c.UID())
+}
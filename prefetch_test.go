@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// idleRankCache returns a cache whose refresh channel isn't drained, to inspect what is queued.
+func idleRankCache(t *testing.T, queueSize int) *RankCache {
+	t.Helper()
+	cache, err := newShardedLRU[TimeRank](100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &RankCache{lru: cache, refresh: make(chan string, queueSize), obs: &Observability{}}
+}
+
+func TestRankPrefetch(t *testing.T) {
+	cache := idleRankCache(t, 20)
+	cached := strings.Repeat("0", 64)
+	cache.lru.Add(cached, TimeRank{Rank: 0.5, Timestamp: time.Now()})
+
+	pubkeys := make([]string, 5)
+	for i := range pubkeys {
+		pubkeys[i] = strings.Repeat(string(rune('a'+i)), 64)
+	}
+	prefetch := NewRankPrefetch(cache, 3)
+	alice, bob := &fakeClient{uid: "alice"}, &fakeClient{uid: "bob"}
+
+	filters := nostr.Filters{{Authors: []string{cached, pubkeys[0], "not a pubkey", pubkeys[0]}}, {Authors: pubkeys[1:]}}
+	if n := prefetch.Authors(alice, filters); n != 3 {
+		t.Errorf("expected the connection's 3 pubkeys to be queued, got %d", n)
+	}
+	if n := prefetch.Authors(alice, nostr.Filters{{Authors: pubkeys[3:]}}); n != 0 {
+		t.Errorf("expected nothing queued past the cap, got %d", n)
+	}
+	// Pubkeys queued for another connection are queued again, the refresher dedups them
+	if n := prefetch.Authors(bob, nostr.Filters{{Authors: pubkeys[:2]}}); n != 2 {
+		t.Errorf("expected 2 pubkeys queued for another connection, got %d", n)
+	}
+	if len(cache.refresh) != 5 || cache.obs.rankPrefetched.Load() != 5 {
+		t.Errorf("expected 5 pubkeys in the refresh queue, got %d", len(cache.refresh))
+	}
+
+	prefetch.Disconnect(alice)
+	if n := prefetch.Authors(alice, nostr.Filters{{Authors: pubkeys[3:]}}); n != 2 {
+		t.Errorf("expected a new connection to get its own cap, got %d", n)
+	}
+
+	disabled := NewRankPrefetch(cache, 0)
+	if n := disabled.Authors(alice, filters); n != 0 {
+		t.Errorf("expected nothing queued when disabled, got %d", n)
+	}
+}
+
+func TestRankCachePrefetch(t *testing.T) {
+	cache := idleRankCache(t, 4)
+	for i, want := range []bool{true, true, false} {
+		if got := cache.Prefetch(strings.Repeat(string(rune('a'+i)), 64)); got != want {
+			t.Errorf("pubkey %d: expected %v, got %v", i, want, got)
+		}
+	}
+	// The other half of the queue is left to the pubkeys of published events
+	cache.tryEnqueue("publisher")
+	cache.tryEnqueue("another publisher")
+	if len(cache.refresh) != 4 || cache.obs.rankRefreshDropped.Load() != 0 {
+		t.Errorf("expected room for publishers, got %d queued and %d dropped", len(cache.refresh), cache.obs.rankRefreshDropped.Load())
+	}
+}
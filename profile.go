@@ -0,0 +1,88 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import "slices"
+
+// profiles are named presets of coherent settings, selected with PROFILE.
+// They only provide defaults: any variable set explicitly takes precedence.
+var profiles = map[string]map[string]string{
+	// strict suits relays under spam pressure: trust is expensive and low-trust
+	// pubkeys get plain text notes at half the usual rates
+	"strict": {
+		"MID_THRESHOLD":               "0.6",
+		"HIGH_THRESHOLD":              "0.9",
+		"RATE_MULTIPLIER":             "0.5",
+		"LOW_TIER_KINDS":              "1",
+		"MAX_EVENT_AGE_HOURS_LOW":     "48",
+		"URL_POLICY_ENABLED":          "true",
+		"URL_REPLY_EXEMPTION":         "false",
+		"HELLTHREAD_THRESHOLD":        "10",
+		"ENTITY_SPAM_THRESHOLD":       "3",
+		"ENTITY_SPAM_ACTION":          "reject",
+		"DUPLICATE_CONTENT_THRESHOLD": "2",
+		"CONTENT_QUALITY_ACTION":      "reject",
+		"REPOST_POLICY_ENABLED":       "true",
+		"LONGFORM_MIN_TIER":           "high",
+		"FILE_MIN_TIER":               "high",
+	},
+
+	// balanced is a general-purpose public relay: low-trust pubkeys can post and
+	// react, links are allowed when replying to trusted authors
+	"balanced": {
+		"MID_THRESHOLD":               "0.5",
+		"HIGH_THRESHOLD":              "0.8",
+		"RATE_MULTIPLIER":             "1",
+		"LOW_TIER_KINDS":              "1,7",
+		"MAX_EVENT_AGE_HOURS_LOW":     "168",
+		"URL_POLICY_ENABLED":          "true",
+		"URL_REPLY_EXEMPTION":         "true",
+		"HELLTHREAD_THRESHOLD":        "20",
+		"ENTITY_SPAM_THRESHOLD":       "10",
+		"ENTITY_SPAM_ACTION":          "pow",
+		"DUPLICATE_CONTENT_THRESHOLD": "5",
+		"CONTENT_QUALITY_ACTION":      "cost",
+		"REPOST_POLICY_ENABLED":       "true",
+		"LONGFORM_MIN_TIER":           "mid",
+		"FILE_MIN_TIER":               "mid",
+	},
+
+	// open welcomes newcomers: trust comes cheap, rates are doubled and
+	// low-trust pubkeys can use the common social kinds, links included
+	"open": {
+		"MID_THRESHOLD":               "0.2",
+		"HIGH_THRESHOLD":              "0.6",
+		"RATE_MULTIPLIER":             "2",
+		"LOW_TIER_KINDS":              "1,6,7,16,9735",
+		"URL_POLICY_ENABLED":          "false",
+		"HELLTHREAD_THRESHOLD":        "50",
+		"ENTITY_SPAM_THRESHOLD":       "0",
+		"DUPLICATE_CONTENT_THRESHOLD": "10",
+		"CONTENT_QUALITY_ACTION":      "off",
+		"REPOST_POLICY_ENABLED":       "false",
+		"LONGFORM_MIN_TIER":           "low",
+		"FILE_MIN_TIER":               "low",
+	},
+}
+
+// profileNames returns the names of the profiles in alphabetical order.
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// profileEnv returns a lookup function that resolves variables from the base lookup
+// first, falling back to the profile's settings for variables that aren't set.
+func profileEnv(profile map[string]string, base func(string) string) func(string) string {
+	return func(key string) string {
+		if value := base(key); value != "" {
+			return value
+		}
+		return profile[key]
+	}
+}
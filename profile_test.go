@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestParseConfigProfile(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	cfg := parseConfig(env(nil))
+	if cfg.Profile != "" || cfg.MidThreshold != 0.5 || cfg.RateMultiplier != 1 || cfg.LowTierKinds.String() != "1" {
+		t.Errorf("without profile: got profile=%q mid=%v multiplier=%v kinds=%v", cfg.Profile, cfg.MidThreshold, cfg.RateMultiplier, cfg.LowTierKinds)
+	}
+
+	cfg = parseConfig(env(map[string]string{"PROFILE": "Strict"}))
+	if cfg.Profile != "strict" || cfg.MidThreshold != 0.6 || cfg.HighThreshold == nil || *cfg.HighThreshold != 0.9 {
+		t.Errorf("strict thresholds: got mid=%v high=%v", cfg.MidThreshold, cfg.HighThreshold)
+	}
+	if !cfg.URLPolicyEnabled || cfg.ContentQualityAction != qualityReject || cfg.RateMultiplier != 0.5 {
+		t.Errorf("strict policies: got url=%t quality=%q multiplier=%v", cfg.URLPolicyEnabled, cfg.ContentQualityAction, cfg.RateMultiplier)
+	}
+
+	cfg = parseConfig(env(map[string]string{"PROFILE": "open", "MID_THRESHOLD": "0.3", "URL_POLICY_ENABLED": "true"}))
+	if cfg.MidThreshold != 0.3 || !cfg.URLPolicyEnabled {
+		t.Errorf("explicit settings should override the profile: got mid=%v url=%t", cfg.MidThreshold, cfg.URLPolicyEnabled)
+	}
+	if !cfg.LowTierKinds.Contains(7) || !cfg.LowTierKinds.Contains(9735) || cfg.LowTierKinds.Contains(3) {
+		t.Errorf("open kinds: got %v", cfg.LowTierKinds)
+	}
+	if rate := calculateDailyRate(1, cfg); rate != 20000 {
+		t.Errorf("open max rate: got %v, want 20000", rate)
+	}
+}
+
+func TestGetEnvKinds(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", "1"},
+		{"1, 7,30023", "1,7,30023"},
+		{"1,seven", "1"},
+		{"-1", "1"},
+		{"30000-39999,7,5-6,1", "1,5-7,30000-39999"},
+		{"1,*", "*"},
+		{"10-5", "1"},
+		{"70000", "1"},
+	}
+
+	for _, tt := range tests {
+		kinds := getEnvKinds(func(string) string { return tt.value }, "LOW_TIER_KINDS", "1")
+		if kinds.String() != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.value, kinds, tt.want)
+		}
+	}
+}
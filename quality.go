@@ -0,0 +1,89 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"math"
+	"unicode"
+)
+
+// Content quality actions for low-trust events flagged by the heuristics.
+const (
+	// qualityOff disables the content quality heuristics.
+	qualityOff = "off"
+	// qualityCost charges ContentQualityTokenCost tokens for the event.
+	qualityCost = "cost"
+	// qualityReject rejects the event at publish time.
+	qualityReject = "reject"
+)
+
+const (
+	// qualityMaxRepeat is the longest run of the same character (or emoji) allowed.
+	qualityMaxRepeat = 10
+	// qualityMinEntropy is the minimum Shannon entropy, in bits per character,
+	// of content at least qualityEntropyLength characters long.
+	qualityMinEntropy    = 2.5
+	qualityEntropyLength = 40
+	// qualityCapsLetters is the minimum number of letters for all-caps detection,
+	// and qualityCapsRatio the share of upper-case letters making a wall of caps.
+	qualityCapsLetters = 30
+	qualityCapsRatio   = 0.9
+)
+
+// lowQualityReason returns why the content looks like low-effort spam (excessive
+// repeated characters, very low entropy or a wall of caps), or "" if it doesn't.
+func lowQualityReason(content string) string {
+	var (
+		runes       int
+		run, maxRun int
+		prev        rune
+		upper       int
+		letters     int
+		frequencies = make(map[rune]int, 32)
+	)
+
+	for _, r := range content {
+		runes++
+		frequencies[r]++
+
+		// Long runs of whitespace are layout, not spam
+		if r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		maxRun = max(maxRun, run)
+		prev = r
+
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+			letters++
+		case unicode.IsLower(r):
+			letters++
+		}
+	}
+
+	if maxRun > qualityMaxRepeat {
+		return "repeated characters"
+	}
+	if runes >= qualityEntropyLength && entropy(frequencies, runes) < qualityMinEntropy {
+		return "low entropy"
+	}
+	if letters >= qualityCapsLetters && float64(upper) >= qualityCapsRatio*float64(letters) {
+		return "all caps"
+	}
+	return ""
+}
+
+// entropy returns the Shannon entropy, in bits per character, of a text of
+// the given length with the given character frequencies.
+func entropy(frequencies map[rune]int, length int) float64 {
+	var bits float64
+	for _, count := range frequencies {
+		p := float64(count) / float64(length)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
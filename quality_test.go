@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLowQualityReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "empty", content: "", expected: ""},
+		{name: "normal note", content: "Just finished reading a great book about the history of cryptography. Highly recommended!", expected: ""},
+		{name: "short shout", content: "GM NOSTR!", expected: ""},
+		{name: "some emphasis", content: "This is SO good, everyone should try it!!!", expected: ""},
+		{name: "indented code", content: "func main() {\n" + strings.Repeat(" ", 16) + "fmt.Println(\"hello, world\")\n}", expected: ""},
+		{name: "repeated letters", content: "hellooooooooooooooo", expected: "repeated characters"},
+		{name: "repeated emoji", content: "wow " + strings.Repeat("🔥", 20), expected: "repeated characters"},
+		{name: "repeated pattern", content: strings.Repeat("ha", 30), expected: "low entropy"},
+		{name: "repeated words", content: strings.Repeat("buy buy ", 10), expected: "low entropy"},
+		{name: "caps wall", content: "FREE BITCOIN GIVEAWAY CLICK NOW TO CLAIM YOUR REWARD BEFORE IT ENDS", expected: "all caps"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lowQualityReason(tt.content); got != tt.expected {
+				t.Errorf("lowQualityReason(%q) = %q, want %q", tt.content, got, tt.expected)
+			}
+		})
+	}
+}
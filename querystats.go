@@ -0,0 +1,210 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// maxQueryShapes bounds the filter shapes tracked, so odd filters can't grow the stats forever.
+const maxQueryShapes = 256
+
+// ShapeStats are the statistics of the queries of a filter shape.
+type ShapeStats struct {
+	Shape    string        `json:"shape"`    // fields of the filter, e.g. "authors,kinds,limit"
+	Index    string        `json:"index"`    // index the event store scans for it
+	Queries  uint64        `json:"queries"`  // queries run
+	Read     uint64        `json:"read"`     // events read from the store
+	Returned uint64        `json:"returned"` // events returned to clients
+	Duration time.Duration `json:"duration"` // total time spent querying, in nanoseconds
+	Slowest  time.Duration `json:"slowest"`  // longest query, in nanoseconds
+}
+
+// QueryStats gathers statistics per filter shape, to tell which filter patterns
+// are expensive and would need new indexes in the event store.
+type QueryStats struct {
+	mu     sync.Mutex
+	shapes map[string]*ShapeStats
+}
+
+func NewQueryStats() *QueryStats {
+	return &QueryStats{shapes: make(map[string]*ShapeStats)}
+}
+
+// Record adds a query of the filter, which read events from the store,
+// returned some of them and took the duration.
+func (s *QueryStats) Record(filter nostr.Filter, read, returned int, took time.Duration) {
+	shape := filterShape(filter)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.shapes[shape]
+	if !ok {
+		if len(s.shapes) >= maxQueryShapes {
+			return
+		}
+		stats = &ShapeStats{Shape: shape, Index: queryIndex(filter)}
+		s.shapes[shape] = stats
+	}
+
+	stats.Queries++
+	stats.Read += uint64(read)
+	stats.Returned += uint64(returned)
+	stats.Duration += took
+	stats.Slowest = max(stats.Slowest, took)
+}
+
+// Report returns the statistics of every filter shape, the most time-consuming first.
+func (s *QueryStats) Report() []ShapeStats {
+	s.mu.Lock()
+	report := make([]ShapeStats, 0, len(s.shapes))
+	for _, stats := range s.shapes {
+		report = append(report, *stats)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(report, func(a, b ShapeStats) int {
+		return cmp.Or(cmp.Compare(b.Duration, a.Duration), strings.Compare(a.Shape, b.Shape))
+	})
+	return report
+}
+
+// filterShape returns the fields set in the filter, tag names included, but not their values.
+func filterShape(filter nostr.Filter) string {
+	var fields []string
+	if len(filter.IDs) > 0 {
+		fields = append(fields, "ids")
+	}
+	if len(filter.Authors) > 0 {
+		fields = append(fields, "authors")
+	}
+	if len(filter.Kinds) > 0 {
+		fields = append(fields, "kinds")
+	}
+
+	tags := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		tags = append(tags, "#"+name)
+	}
+	slices.Sort(tags)
+	fields = append(fields, tags...)
+
+	if filter.Since != nil {
+		fields = append(fields, "since")
+	}
+	if filter.Until != nil {
+		fields = append(fields, "until")
+	}
+	if filter.Limit > 0 || filter.LimitZero {
+		fields = append(fields, "limit")
+	}
+	if filter.Search != "" {
+		fields = append(fields, "search")
+	}
+
+	if len(fields) == 0 {
+		return "all"
+	}
+	return strings.Join(fields, ",")
+}
+
+// queryIndex returns the index the Badger event store scans for the filter,
+// following its query planner: IDs first, then the narrowest tag unless it's
+// a broad one and authors or kinds are set, then authors and kinds, then created_at.
+func queryIndex(filter nostr.Filter) string {
+	switch {
+	case filter.Search != "":
+		return "none"
+	case len(filter.IDs) > 0:
+		return "id"
+	}
+
+	if tag, goodness := narrowestTag(filter); tag != "" && (goodness >= 3 || len(filter.Authors) == 0 && len(filter.Kinds) == 0) {
+		return "tag:#" + tag
+	}
+
+	switch {
+	case len(filter.Authors) > 0 && len(filter.Kinds) > 0:
+		return "pubkey+kind"
+	case len(filter.Authors) > 0:
+		return "pubkey"
+	case len(filter.Kinds) > 0:
+		return "kind"
+	default:
+		return "created_at"
+	}
+}
+
+// narrowestTag returns the tag the event store picks to query the filter with, and how
+// narrow it considers it. It mirrors the event store's choice, which isn't exported.
+func narrowestTag(filter nostr.Filter) (string, int) {
+	tag, goodness := "", 0
+	// The store ranges over the tags map, so ties are broken in the same arbitrary order:
+	// sort them, for the same filter to always report the same index
+	names := make([]string, 0, len(filter.Tags))
+	for name := range filter.Tags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		switch name {
+		case "e", "E", "q":
+			return name, 9
+		case "a", "A", "i", "I", "g", "r":
+			tag, goodness = name, 8
+		case "d":
+			if len(filter.Authors) > 0 && goodness < 7 {
+				tag, goodness = name, 7
+			} else if goodness < 4 {
+				tag, goodness = name, 4
+			}
+		case "h", "t", "l", "k", "K":
+			if goodness < 6 {
+				tag, goodness = name, 6
+			}
+		case "p":
+			if goodness < 2 {
+				tag, goodness = name, 2
+			}
+		default:
+			if goodness == 0 {
+				tag = name
+			}
+		}
+	}
+	return tag, goodness
+}
+
+// warmIndexes reads every index key of the event store once, loading the index blocks
+// into Badger's cache so that the first queries after a restart aren't served from disk.
+func warmIndexes(ctx context.Context, db *badger.BadgerBackend) {
+	start := time.Now()
+	keys := 0
+	err := db.View(func(txn *badgerdb.Txn) error {
+		for prefix := rawEventPrefix + 1; prefix <= lastIndexPrefix; prefix++ {
+			it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{prefix}})
+			for it.Rewind(); it.Valid() && ctx.Err() == nil; it.Next() {
+				keys++
+			}
+			it.Close()
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		queryLog.Error("failed to warm up the indexes", "path", db.Path, "error", err)
+		return
+	}
+	queryLog.Info("warmed up the indexes", "path", db.Path, "keys", keys, "duration", time.Since(start).Round(time.Millisecond))
+}
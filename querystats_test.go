@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQueryIndex(t *testing.T) {
+	pubkey := nostr.GeneratePrivateKey()
+	since := nostr.Timestamp(1)
+	tests := []struct {
+		filter nostr.Filter
+		shape  string
+		index  string
+	}{
+		{nostr.Filter{}, "all", "created_at"},
+		{nostr.Filter{IDs: []string{pubkey}, Kinds: []int{1}}, "ids,kinds", "id"},
+		{nostr.Filter{Authors: []string{pubkey}, Kinds: []int{1}, Limit: 10}, "authors,kinds,limit", "pubkey+kind"},
+		{nostr.Filter{Authors: []string{pubkey}, Since: &since}, "authors,since", "pubkey"},
+		{nostr.Filter{Kinds: []int{1}, Tags: nostr.TagMap{"p": {pubkey}}}, "kinds,#p", "kind"},
+		{nostr.Filter{Tags: nostr.TagMap{"p": {pubkey}}}, "#p", "tag:#p"},
+		{nostr.Filter{Kinds: []int{7}, Tags: nostr.TagMap{"t": {"nostr"}, "e": {pubkey}}}, "kinds,#e,#t", "tag:#e"},
+		{nostr.Filter{Authors: []string{pubkey}, Tags: nostr.TagMap{"d": {"x"}}}, "authors,#d", "tag:#d"},
+	}
+	for _, tt := range tests {
+		if shape, index := filterShape(tt.filter), queryIndex(tt.filter); shape != tt.shape || index != tt.index {
+			t.Errorf("%v: got shape %q and index %q, want %q and %q", tt.filter, shape, index, tt.shape, tt.index)
+		}
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	sk := nostr.GeneratePrivateKey()
+	for range 3 {
+		if err := db.SaveEvent(ctx, signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"t", nostr.GeneratePrivateKey()}})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &Deps{DB: db, Queries: NewQueryStats()}
+	notes := nostr.Filter{Kinds: []int{nostr.KindTextNote}, Limit: 2}
+	for range 2 {
+		if _, err := Query(ctx, nil, nostr.Filters{notes, {Kinds: []int{nostr.KindReaction}}}, Config{}, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := d.Queries.Report()
+	if len(report) != 2 {
+		t.Fatalf("expected 2 shapes, got %+v", report)
+	}
+	for _, stats := range report {
+		if stats.Shape == "kinds,limit" && (stats.Queries != 2 || stats.Read != 4 || stats.Returned != 4 || stats.Index != "kind") {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+		if stats.Shape == "kinds" && (stats.Queries != 2 || stats.Read != 0) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+		if stats.Slowest > stats.Duration {
+			t.Errorf("slowest query of %s is longer than all of them", stats.Shape)
+		}
+	}
+
+	// New shapes are dropped beyond the bound
+	s := NewQueryStats()
+	for i := range maxQueryShapes + 10 {
+		s.Record(nostr.Filter{Tags: nostr.TagMap{string(rune('A' + i)): {"x"}}}, 1, 1, time.Millisecond)
+	}
+	if len(s.Report()) != maxQueryShapes {
+		t.Errorf("expected %d shapes, got %d", maxQueryShapes, len(s.Report()))
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -24,28 +26,58 @@ var jsonRequestPool = sync.Pool{
 }
 
 type RankCache struct {
-	// LRU cache (thread-safe, no external mutex needed)
-	lru *lru.Cache[string, TimeRank]
+	// LRU cache, sharded by pubkey (thread-safe, no external mutex needed)
+	lru *shardedLRU[TimeRank]
+
+	// Last known ranks of evicted pubkeys, timestamped with their eviction
+	grace *shardedLRU[TimeRank]
 
 	refresh chan string
+	// overflow: what to do with pubkeys queued while the refresh channel is full
+	overflow string
+	// Persistent overflow of the refresh channel, which also keeps the pending pubkeys
+	// across restarts (nil when neither is enabled)
+	spill atomic.Pointer[RefreshSpill]
+	// done is closed once the refresher has stopped
+	done chan struct{}
 
 	StaleThreshold     time.Duration
 	MaxRefreshInterval time.Duration
+	// ClusterRefreshInterval: how often the cluster leader refreshes the pubkeys queued by other nodes
+	ClusterRefreshInterval time.Duration
+	// SpillInterval: how often spilled pubkeys are moved back to the refresher
+	SpillInterval time.Duration
+	// GracePeriod: how long the last rank of an evicted pubkey is kept while it's being refreshed (0 disables)
+	GracePeriod time.Duration
 
 	// Configuration for rank lookups
 	relatrRelay     string
 	relatrPubkey    string
 	relatrSecretKey string
 
-	// Relay connection for reuse (reconnects on failure)
-	relayMu sync.Mutex
-	relay   *nostr.Relay
+	// Relay connection for reuse (reconnects on failure, with exponential backoff)
+	relayMu  sync.Mutex
+	relay    *nostr.Relay
+	backoff  time.Duration // delay before the next reconnection attempt, 0 after a success
+	nextDial time.Time     // no reconnection attempt before this time
+
+	// KeepaliveInterval: how often the connection to the Relatr relay is pinged (and re-established if down)
+	KeepaliveInterval time.Duration
+	// ReconnectMinBackoff and ReconnectMaxBackoff bound the delay between failed reconnection attempts
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
 
 	// Single-flight group to prevent duplicate network requests
 	flight singleflight.Group
 
 	// Observability metrics
 	obs *Observability
+
+	// Cluster sharing ranks with other nodes (nil when running standalone)
+	cluster atomic.Pointer[Cluster]
+
+	// Local rank provider replacing Relatr (nil when ranks come from Relatr)
+	graph atomic.Pointer[FollowGraph]
 }
 
 type TimeRank struct {
@@ -104,29 +136,59 @@ func NewRankCache(ctx context.Context, cfg Config, obs *Observability) *RankCach
 	if cfg.RankCacheSize > 0 {
 		cacheSize = cfg.RankCacheSize
 	}
+	queueSize := 100
+	if cfg.RankRefreshQueueSize > 0 {
+		queueSize = cfg.RankRefreshQueueSize
+	}
 
-	lruCache, err := lru.New[string, TimeRank](cacheSize)
+	graceCache, err := newShardedLRU[TimeRank](cacheSize, nil)
 	if err != nil {
 		log.Fatalf("failed to create LRU cache: %v", err)
 	}
 
-	cache := &RankCache{
-		lru:                lruCache,
-		refresh:            make(chan string, 100),
-		StaleThreshold:     24 * time.Hour,
-		MaxRefreshInterval: 7 * 24 * time.Hour,
-		relatrRelay:        cfg.RelatrRelay,
-		relatrPubkey:       cfg.RelatrPubkey,
-		relatrSecretKey:    cfg.RelatrSecretKey,
-		obs:                obs,
+	gracePeriod := time.Duration(cfg.RankGraceMinutes) * time.Minute
+	lruCache, err := newShardedLRU(cacheSize, func(pubkey string, rank TimeRank) {
+		obs.rankCacheEvictions.Add(1)
+		// Only ranks above 0 are worth remembering
+		if gracePeriod > 0 && rank.Rank > 0 {
+			graceCache.Add(pubkey, TimeRank{Rank: rank.Rank, Timestamp: time.Now()})
+		}
+	})
+	if err != nil {
+		log.Fatalf("failed to create LRU cache: %v", err)
 	}
 
+	cache := &RankCache{
+		lru:                    lruCache,
+		grace:                  graceCache,
+		refresh:                make(chan string, queueSize),
+		overflow:               cfg.RankRefreshOverflow,
+		done:                   make(chan struct{}),
+		StaleThreshold:         24 * time.Hour,
+		MaxRefreshInterval:     7 * 24 * time.Hour,
+		ClusterRefreshInterval: time.Minute,
+		SpillInterval:          time.Minute,
+		GracePeriod:            gracePeriod,
+		KeepaliveInterval:      time.Duration(cfg.RelatrKeepaliveSeconds) * time.Second,
+		ReconnectMinBackoff:    time.Second,
+		ReconnectMaxBackoff:    5 * time.Minute,
+		relatrRelay:            cfg.RelatrRelay,
+		relatrPubkey:           cfg.RelatrPubkey,
+		relatrSecretKey:        cfg.RelatrSecretKey,
+		obs:                    obs,
+	}
+
+	obs.rankCache.Store(cache)
 	go cache.refresher(ctx)
+	if cache.KeepaliveInterval > 0 && cfg.RankProvider != rankProviderLocal {
+		go cache.keeper(ctx)
+	}
 	return cache
 }
 
 // getRelay returns the cached relay connection, establishing one if needed.
 // The connection is reused across requests and reconnected on failure.
+// After a failed attempt, reconnections are delayed with exponential backoff.
 func (c *RankCache) getRelay(ctx context.Context) (*nostr.Relay, error) {
 	c.relayMu.Lock()
 	defer c.relayMu.Unlock()
@@ -138,24 +200,98 @@ func (c *RankCache) getRelay(ctx context.Context) (*nostr.Relay, error) {
 	// Close old connection if exists
 	if c.relay != nil {
 		c.relay.Close()
+		c.relay = nil
+		c.obs.relatrConnected.Store(0)
+	}
+
+	if wait := time.Until(c.nextDial); wait > 0 {
+		return nil, fmt.Errorf("not connected to %s, retrying in %s", c.relatrRelay, wait.Round(time.Second))
 	}
 
 	// Establish new connection
 	newRelay, err := nostr.RelayConnect(ctx, c.relatrRelay)
 	if err != nil {
+		c.obs.relatrConnectFailures.Add(1)
+		c.backoffLocked()
 		return nil, fmt.Errorf("failed to connect to %s: %w", c.relatrRelay, err)
 	}
 
+	c.obs.relatrConnects.Add(1)
+	c.obs.relatrConnected.Store(1)
+	c.backoff = 0
 	c.relay = newRelay
 	return newRelay, nil
 }
 
+// backoffLocked delays the next reconnection attempt, doubling the delay after
+// each consecutive failure. Must be called with c.relayMu held.
+func (c *RankCache) backoffLocked() {
+	c.backoff = min(max(2*c.backoff, c.ReconnectMinBackoff), c.ReconnectMaxBackoff)
+	c.nextDial = time.Now().Add(c.backoff)
+}
+
 func (c *RankCache) dropRelay() {
 	c.relayMu.Lock()
 	defer c.relayMu.Unlock()
 	if c.relay != nil {
 		c.relay.Close()
 		c.relay = nil
+		c.obs.relatrConnected.Store(0)
+	}
+}
+
+// keepalive pings the relay connection, establishing it first if it's down.
+// A connection that doesn't answer the ping is dropped, and re-established after the backoff.
+func (c *RankCache) keepalive(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	relay, err := c.getRelay(dialCtx)
+	if err != nil {
+		return err
+	}
+
+	if err := relay.Connection.Ping(ctx); err != nil {
+		c.obs.relatrPingFailures.Add(1)
+		c.relayMu.Lock()
+		c.backoffLocked()
+		c.relayMu.Unlock()
+		c.dropRelay()
+		return fmt.Errorf("ping to %s failed: %w", c.relatrRelay, err)
+	}
+	return nil
+}
+
+// retryIn returns how long until the connection may be re-established.
+func (c *RankCache) retryIn() time.Duration {
+	c.relayMu.Lock()
+	defer c.relayMu.Unlock()
+	return time.Until(c.nextDial)
+}
+
+// keeper keeps the relay connection alive: it is pinged every KeepaliveInterval,
+// and re-established in the background as soon as the backoff allows when down,
+// so that rank lookups don't pay for reconnecting.
+func (c *RankCache) keeper(ctx context.Context) {
+	defer c.dropRelay()
+
+	for {
+		wait := c.KeepaliveInterval
+		if err := c.keepalive(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			rankLog.Warn("relatr connection failed", "error", err)
+			wait = min(wait, max(c.retryIn(), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 	}
 }
 
@@ -179,13 +315,145 @@ func (c *RankCache) Rank(pubkey string) (float64, bool) {
 	return rank.Rank, true
 }
 
+// Peek returns the cached rank of the pubkey, without refreshing it or counting a hit or miss.
+func (c *RankCache) Peek(pubkey string) (float64, bool) {
+	rank, exists := c.lru.Peek(pubkey)
+	return rank.Rank, exists
+}
+
+// Remembered returns the last rank of a pubkey evicted from the cache less than
+// GracePeriod ago, so that a previously trusted pubkey keeps its tier while its
+// rank is being refreshed.
+func (c *RankCache) Remembered(pubkey string) (float64, bool) {
+	rank, exists := c.grace.Peek(pubkey)
+	if !exists {
+		return 0, false
+	}
+	if time.Since(rank.Timestamp) > c.GracePeriod {
+		c.grace.Remove(pubkey)
+		return 0, false
+	}
+	return rank.Rank, true
+}
+
+// Share makes the cache read ranks fetched by other nodes of the cluster before
+// asking the provider, and publish the ranks it fetches itself.
+func (c *RankCache) Share(cluster *Cluster) {
+	c.cluster.Store(cluster)
+}
+
+// Spill makes the cache spill the pubkeys queued while the refresh channel is full to
+// the store, when the overflow strategy is spill, and the pubkeys still waiting for a
+// refresh when it stops. Spilled pubkeys are refreshed once there's room.
+func (c *RankCache) Spill(spill *RefreshSpill) {
+	c.spill.Store(spill)
+}
+
+// Wait blocks until the refresher has stopped, after its context is done.
+// The pending pubkeys have then been spilled, so the store can be closed.
+func (c *RankCache) Wait() {
+	<-c.done
+}
+
+// UseFollowGraph makes the cache get ranks from the follow graph instead of Relatr,
+// and starts computing the graph. Cached ranks are updated after each computation.
+func (c *RankCache) UseFollowGraph(ctx context.Context, graph *FollowGraph) {
+	c.graph.Store(graph)
+	go graph.Run(ctx, func() {
+		now := time.Now()
+		for _, pubkey := range c.lru.Keys() {
+			c.lru.Add(pubkey, TimeRank{Rank: graph.Rank(pubkey), Timestamp: now})
+		}
+	})
+}
+
 // tryEnqueue attempts to enqueue a pubkey for refresh without blocking.
+// When the refresh channel is full, the overflow strategy decides what is dropped.
 func (c *RankCache) tryEnqueue(pubkey string) {
 	select {
 	case c.refresh <- pubkey:
+		return
 	default:
-		// If refresh channel is full, skip to avoid blocking
 	}
+
+	switch c.overflow {
+	case refreshOverflowDropOldest:
+		select {
+		case <-c.refresh:
+		default:
+		}
+		c.obs.rankRefreshDropped.Add(1)
+		select {
+		case c.refresh <- pubkey:
+			return
+		default:
+		}
+
+	case refreshOverflowSpill:
+		if spill := c.spill.Load(); spill != nil {
+			if err := spill.Push(pubkey); err == nil {
+				c.obs.rankRefreshSpilled.Add(1)
+				return
+			}
+		}
+	}
+
+	// Skip to avoid blocking
+	c.obs.rankRefreshDropped.Add(1)
+}
+
+// Backfill queues a refresh of the pubkeys, waiting for room in the refresh channel
+// rather than applying the overflow strategy, and returns how many were queued
+// before the context was done.
+func (c *RankCache) Backfill(ctx context.Context, pubkeys []string) int {
+	for i, pubkey := range pubkeys {
+		select {
+		case c.refresh <- pubkey:
+		case <-ctx.Done():
+			return i
+		}
+	}
+	return len(pubkeys)
+}
+
+// Prefetch queues a refresh of the pubkey if it isn't cached, and reports whether it was queued.
+// Prefetching is opportunistic: it only uses the first half of the refresh channel, and never
+// applies the overflow strategy, so it can't crowd out the pubkeys of events being published.
+func (c *RankCache) Prefetch(pubkey string) bool {
+	if c.lru.Contains(pubkey) || len(c.refresh) >= cap(c.refresh)/2 {
+		return false
+	}
+	select {
+	case c.refresh <- pubkey:
+		c.obs.rankPrefetched.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// RankCacheStats is a snapshot of the cache's occupancy.
+type RankCacheStats struct {
+	Size    int // entries in the cache
+	Stale   int // entries older than StaleThreshold
+	Queued  int // pubkeys waiting in the refresh channel
+	Spilled int // pubkeys spilled to the store
+}
+
+// Stats returns the current occupancy of the cache. It scans every entry,
+// so it's meant for periodic reporting rather than hot paths.
+func (c *RankCache) Stats() RankCacheStats {
+	stats := RankCacheStats{Queued: len(c.refresh)}
+	if spill := c.spill.Load(); spill != nil {
+		stats.Spilled = spill.Len()
+	}
+	for _, rank := range c.lru.Values() {
+		stats.Size++
+		if time.Since(rank.Timestamp) > c.StaleThreshold {
+			stats.Stale++
+		}
+	}
+	return stats
 }
 
 // GetRank returns the rank for a pubkey, blocking until the rank is available.
@@ -273,51 +541,124 @@ const MaxPubkeysToRank = 1000
 // old ranks. It fires when one of the following condition is met:
 // - enough unique pubkeys need updated ranks
 // - enough time has passed since the last refresh (based on StaleThreshold)
+// In cluster mode only the leader queries the provider; other nodes hand their
+// batches over to it, and it refreshes them every ClusterRefreshInterval.
 func (c *RankCache) refresher(ctx context.Context) {
+	defer close(c.done)
 	batch := make([]string, 0, MaxPubkeysToRank)
 	seen := make(map[string]struct{}, MaxPubkeysToRank)
 	ticker := time.NewTicker(c.StaleThreshold)
 	defer ticker.Stop()
+	clusterTicker := time.NewTicker(c.ClusterRefreshInterval)
+	defer clusterTicker.Stop()
+	spillTicker := time.NewTicker(c.SpillInterval)
+	defer spillTicker.Stop()
+
+	add := func(pubkey string) {
+		// Skip if already in current batch (O(1) lookup)
+		if _, exists := seen[pubkey]; exists {
+			return
+		}
+
+		// Add to batch and mark as seen
+		batch = append(batch, pubkey)
+		seen[pubkey] = struct{}{}
+
+		// Flush when batch is full
+		if len(batch) >= MaxPubkeysToRank {
+			c.flush(ctx, batch)
+			c.resetBatch(&batch, seen)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			c.persist(batch)
 			return
 
 		case pubkey, ok := <-c.refresh:
 			if !ok {
 				return
 			}
+			add(pubkey)
 
-			// Skip if already in current batch (O(1) lookup)
-			if _, exists := seen[pubkey]; exists {
-				continue
+		case <-ticker.C:
+			// Periodic flush based on StaleThreshold
+			if len(batch) > 0 {
+				c.flush(ctx, batch)
+				c.resetBatch(&batch, seen)
 			}
 
-			// Add to batch and mark as seen
-			batch = append(batch, pubkey)
-			seen[pubkey] = struct{}{}
-
-			// Flush when batch is full
-			if len(batch) >= MaxPubkeysToRank {
-				if err := c.refreshBatch(ctx, batch); err != nil {
-					log.Printf("failed to refresh cache: %v", err)
-				}
-				c.resetBatch(&batch, seen)
+		case <-spillTicker.C:
+			// Move spilled pubkeys back once the channel has drained
+			spill := c.spill.Load()
+			if spill == nil || len(c.refresh) > 0 {
+				continue
+			}
+			spilled, err := spill.Pop(MaxPubkeysToRank)
+			if err != nil {
+				rankLog.Error("failed to read the spilled refresh queue", "error", err)
+				continue
+			}
+			for _, pubkey := range spilled {
+				add(pubkey)
 			}
 
-		case <-ticker.C:
-			// Periodic flush based on StaleThreshold
-			if len(batch) > 0 {
-				if err := c.refreshBatch(ctx, batch); err != nil {
-					log.Printf("failed to refresh cache: %v", err)
+		case <-clusterTicker.C:
+			// The leader refreshes what the other nodes queued
+			if cluster := c.cluster.Load(); cluster != nil && cluster.IsLeader() {
+				if queued := cluster.DrainRefresh(ctx, MaxPubkeysToRank); len(queued) > 0 {
+					if err := c.refreshBatch(ctx, queued); err != nil {
+						rankLog.Error("failed to refresh cache", "error", err)
+					}
 				}
-				c.resetBatch(&batch, seen)
 			}
 		}
 	}
 }
 
+// persist spills the batch and the pubkeys left in the refresh channel, so that
+// they are refreshed after a restart instead of waiting to be looked up again.
+func (c *RankCache) persist(batch []string) {
+	spill := c.spill.Load()
+	if spill == nil {
+		return
+	}
+
+	pending := batch
+drain:
+	for {
+		select {
+		case pubkey := <-c.refresh:
+			pending = append(pending, pubkey)
+		default:
+			break drain
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := spill.Push(pending...); err != nil {
+		rankLog.Error("failed to persist the pubkeys waiting for a rank refresh", "pubkeys", len(pending), "error", err)
+		return
+	}
+	rankLog.Info("persisted the pubkeys waiting for a rank refresh", "pubkeys", len(pending))
+}
+
+// flush refreshes the batch from the provider, or hands it over to the
+// cluster leader when this node isn't the leader.
+func (c *RankCache) flush(ctx context.Context, batch []string) {
+	if cluster := c.cluster.Load(); cluster != nil && !cluster.IsLeader() {
+		cluster.QueueRefresh(ctx, batch)
+		return
+	}
+	if err := c.refreshBatch(ctx, batch); err != nil {
+		rankLog.Error("failed to refresh cache", "error", err)
+	}
+}
+
 // resetBatch clears the batch slice and seen map without reallocating.
 func (c *RankCache) resetBatch(batch *[]string, seen map[string]struct{}) {
 	*batch = (*batch)[:0]
@@ -326,7 +667,25 @@ func (c *RankCache) resetBatch(batch *[]string, seen map[string]struct{}) {
 	}
 }
 
-func (c *RankCache) refreshBatch(ctx context.Context, batch []string) error {
+func (c *RankCache) refreshBatch(ctx context.Context, batch []string) (err error) {
+	ctx, span := tracer.Start(ctx, "refreshBatch", trace.WithAttributes(attribute.Int("wotrlay.pubkeys", len(batch))))
+	defer func() { endSpan(span, err) }()
+
+	// The follow graph is computed by every node, so there's nothing to share
+	if graph := c.graph.Load(); graph != nil {
+		ranks := make([]PubRank, len(batch))
+		for i, pubkey := range batch {
+			ranks[i] = PubRank{Pubkey: pubkey, Rank: graph.Rank(pubkey)}
+		}
+		c.updateAndClean(time.Now(), ranks)
+		return nil
+	}
+
+	cluster := c.cluster.Load()
+	if cluster != nil {
+		batch = c.loadShared(ctx, cluster, batch)
+	}
+
 	if len(batch) < 1 {
 		return nil
 	}
@@ -394,9 +753,32 @@ func (c *RankCache) refreshBatch(ctx context.Context, batch []string) error {
 	}
 
 	c.updateAndClean(response.CreatedAt.Time(), ranks)
+	if cluster != nil {
+		cluster.PublishRanks(ctx, response.CreatedAt.Time(), ranks)
+	}
 	return nil
 }
 
+// loadShared adds the fresh ranks other nodes fetched to the cache, and returns
+// the pubkeys that still need to be refreshed from the provider.
+func (c *RankCache) loadShared(ctx context.Context, cluster *Cluster, batch []string) []string {
+	shared := cluster.LoadRanks(ctx, batch)
+	if len(shared) == 0 {
+		return batch
+	}
+
+	missing := make([]string, 0, len(batch))
+	for _, pubkey := range batch {
+		rank, ok := shared[pubkey]
+		if !ok || time.Since(rank.Timestamp) > c.StaleThreshold {
+			missing = append(missing, pubkey)
+			continue
+		}
+		c.lru.Add(pubkey, rank)
+	}
+	return missing
+}
+
 // contextVMResponse sends the request and fetches the response using the request ID.
 // It reuses the cached relay connection for efficiency.
 func (c *RankCache) contextVMResponse(ctx context.Context, request *nostr.Event) (*nostr.Event, error) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -247,3 +248,132 @@ func TestSingleflightDeduplication(t *testing.T) {
 		}
 	}
 }
+
+// TestRankCacheReconnectBackoff tests that failed connections to the Relatr relay
+// are retried with exponential backoff.
+func TestRankCacheReconnectBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	obs := &Observability{}
+	cache := NewRankCache(ctx, Config{RelatrRelay: "ws://127.0.0.1:1"}, obs)
+	cache.ReconnectMaxBackoff = 3 * time.Second
+
+	if _, err := cache.getRelay(ctx); err == nil {
+		t.Fatal("expected connection to fail")
+	}
+	if cache.backoff != time.Second {
+		t.Errorf("expected 1s backoff after the first failure, got %s", cache.backoff)
+	}
+
+	// Attempts during the backoff don't dial
+	if _, err := cache.getRelay(ctx); err == nil {
+		t.Fatal("expected connection to fail during backoff")
+	}
+	if failures := obs.relatrConnectFailures.Load(); failures != 1 {
+		t.Errorf("expected 1 connection attempt during backoff, got %d", failures)
+	}
+
+	for _, expected := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		cache.nextDial = time.Time{}
+		cache.getRelay(ctx)
+		if cache.backoff != expected {
+			t.Errorf("expected %s backoff, got %s", expected, cache.backoff)
+		}
+	}
+
+	if obs.relatrConnected.Load() != 0 {
+		t.Error("relatr_connected should be 0 while disconnected")
+	}
+}
+
+// TestRankCacheStats tests the cache occupancy metrics.
+func TestRankCacheStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obs := &Observability{}
+	cache := NewRankCache(ctx, Config{RankCacheSize: 2}, obs)
+
+	now := time.Now()
+	cache.Update(now.Add(-48*time.Hour), PubRank{Pubkey: "alice", Rank: 0.5})
+	cache.Update(now, PubRank{Pubkey: "bob", Rank: 0.5}, PubRank{Pubkey: "carol", Rank: 0.5})
+	cache.Update(now.Add(-48*time.Hour), PubRank{Pubkey: "dave", Rank: 0.5})
+
+	stats := cache.Stats()
+	if stats.Size != 2 || stats.Stale != 1 {
+		t.Errorf("expected 2 entries with 1 stale, got %+v", stats)
+	}
+	if evictions := obs.rankCacheEvictions.Load(); evictions != 2 {
+		t.Errorf("expected 2 evictions, got %d", evictions)
+	}
+
+	metrics := make(map[string]uint64)
+	for _, m := range obs.Snapshot() {
+		metrics[m.Name] = m.Value
+	}
+	if metrics["rank_cache_size"] != 2 || metrics["rank_cache_stale"] != 1 || metrics["rank_cache_evictions"] != 2 {
+		t.Errorf("unexpected cache metrics in snapshot: %v", metrics)
+	}
+}
+
+// TestRankCacheBounded tests that a flood of unique pubkeys can't grow the cache past its size.
+func TestRankCacheBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obs := &Observability{}
+	cache := NewRankCache(ctx, Config{RankCacheSize: 100, RankGraceMinutes: 60}, obs)
+
+	now := time.Now()
+	for i := range 10000 {
+		cache.Update(now, PubRank{Pubkey: fmt.Sprintf("pubkey-%d", i), Rank: 0.5})
+	}
+	if size := cache.lru.Len(); size != 100 {
+		t.Errorf("expected 100 ranks, got %d", size)
+	}
+	if size := cache.grace.Len(); size > 100 {
+		t.Errorf("expected at most 100 remembered ranks, got %d", size)
+	}
+	if evictions := obs.rankCacheEvictions.Load(); evictions != 9900 {
+		t.Errorf("expected 9900 evictions, got %d", evictions)
+	}
+	// The most recent ranks are kept
+	if _, ok := cache.Peek("pubkey-9999"); !ok {
+		t.Error("expected the last rank to be cached")
+	}
+}
+
+// TestRankCacheGrace tests that evicted ranks are remembered for the grace period.
+func TestRankCacheGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache := NewRankCache(ctx, Config{RankCacheSize: 1, RankGraceMinutes: 60}, &Observability{})
+
+	now := time.Now()
+	cache.Update(now, PubRank{Pubkey: "alice", Rank: 0.9})
+	cache.Update(now, PubRank{Pubkey: "bob", Rank: 0})
+	cache.Update(now, PubRank{Pubkey: "carol", Rank: 0.5})
+
+	if rank, ok := cache.Remembered("alice"); !ok || rank != 0.9 {
+		t.Errorf("expected alice to keep rank 0.9, got %f (ok=%v)", rank, ok)
+	}
+	if _, ok := cache.Remembered("bob"); ok {
+		t.Error("rank 0 should not be remembered")
+	}
+	if _, ok := cache.Remembered("carol"); ok {
+		t.Error("cached pubkey should not be remembered")
+	}
+
+	cache.grace.Add("alice", TimeRank{Rank: 0.9, Timestamp: now.Add(-2 * time.Hour)})
+	if _, ok := cache.Remembered("alice"); ok {
+		t.Error("rank should be forgotten after the grace period")
+	}
+
+	disabled := NewRankCache(ctx, Config{RankCacheSize: 1}, &Observability{})
+	disabled.Update(now, PubRank{Pubkey: "alice", Rank: 0.9}, PubRank{Pubkey: "carol", Rank: 0.5})
+	if _, ok := disabled.Remembered("alice"); ok {
+		t.Error("ranks should not be remembered when the grace period is disabled")
+	}
+}
@@ -5,18 +5,50 @@ package main
 
 import (
 	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/pippellia-btc/rely"
 )
 
-// Limiter manages token buckets for rate limiting.
+// RateLimiter is implemented by the node-local Limiter and by the ClusterLimiter
+// shared by all nodes of a cluster.
+type RateLimiter interface {
+	Allow(id string, capacity, refillRate float64) bool
+	Consume(id string, cost float64, capacity, refillRate float64) bool
+	AllowDaily(id string, dailyCap float64) bool
+	Peek(id string, capacity, refillRate float64) float64
+}
+
+// Limiter manages token buckets for rate limiting, sharded by ID.
 // Buckets are automatically cleaned up based on TimeToLive.
 type Limiter struct {
+	shards [shardCount]limiterShard
+
+	TimeToLive      time.Duration    // How long to keep inactive buckets
+	CleanupInterval time.Duration    // How often to scan for cleanup
+	Clock           func() time.Time // Source of the current time, time.Now if nil
+
+	created atomic.Uint64 // buckets created since startup
+	cleaned atomic.Uint64 // buckets removed by Clean since startup
+}
+
+// limiterShard holds the buckets of the IDs of one shard.
+type limiterShard struct {
 	mu      sync.RWMutex
 	buckets map[string]*Bucket
+}
 
-	TimeToLive      time.Duration // How long to keep inactive buckets
-	CleanupInterval time.Duration // How often to scan for cleanup
+// LimiterStats is a snapshot of a Limiter's buckets.
+type LimiterStats struct {
+	Buckets int
+	Created uint64
+	Cleaned uint64
 }
 
 // Bucket represents a token bucket with continuous refill.
@@ -31,37 +63,57 @@ type Bucket struct {
 
 func NewLimiter(ctx context.Context) *Limiter {
 	limiter := &Limiter{
-		buckets:         make(map[string]*Bucket, 100),
 		TimeToLive:      time.Hour,
 		CleanupInterval: time.Hour,
 	}
+	for i := range limiter.shards {
+		limiter.shards[i].buckets = make(map[string]*Bucket)
+	}
 
 	go limiter.cleaner(ctx)
 	return limiter
 }
 
+func (l *Limiter) now() time.Time {
+	if l.Clock != nil {
+		return l.Clock()
+	}
+	return time.Now()
+}
+
+func (l *Limiter) shard(id string) *limiterShard {
+	return &l.shards[shardOf(id, shardCount)]
+}
+
+// bucket returns the bucket of the ID, or nil if it doesn't exist.
+func (l *Limiter) bucket(id string) *Bucket {
+	s := l.shard(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.buckets[id]
+}
+
 // getOrCreateBucket returns an existing bucket or creates a new one with the specified parameters.
 func (l *Limiter) getOrCreateBucket(id string, capacity, refillRate float64) *Bucket {
-	l.mu.RLock()
-	b, exists := l.buckets[id]
-	l.mu.RUnlock()
-
-	if exists {
+	if b := l.bucket(id); b != nil {
 		return b
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	s := l.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	if b, exists = l.buckets[id]; !exists {
+	b, exists := s.buckets[id]
+	if !exists {
 		b = &Bucket{
 			tokens:     capacity, // Start full
 			capacity:   capacity,
 			refillRate: refillRate,
-			lastActive: time.Now(),
+			lastActive: l.now(),
 		}
-		l.buckets[id] = b
+		s.buckets[id] = b
+		l.created.Add(1)
 	}
 
 	return b
@@ -85,7 +137,7 @@ func (l *Limiter) Consume(id string, cost float64, capacity, refillRate float64)
 	b.refillRate = refillRate
 
 	// Refill tokens based on elapsed time
-	b.refillLocked(time.Now())
+	b.refillLocked(l.now())
 
 	// Check if we have enough tokens
 	if b.tokens < cost {
@@ -96,14 +148,20 @@ func (l *Limiter) Consume(id string, cost float64, capacity, refillRate float64)
 	return true
 }
 
+// AllowDaily consumes one token from a bucket sized for dailyCap events per day.
+// A dailyCap <= 0 means no cap.
+func (l *Limiter) AllowDaily(id string, dailyCap float64) bool {
+	if dailyCap <= 0 {
+		return true
+	}
+	return l.Allow(id, dailyCap, dailyCap/secondsPerDay)
+}
+
 // GetTokens returns the current token count for a bucket (for debugging/monitoring).
 // This method is intended for internal use and debugging purposes only.
 func (l *Limiter) GetTokens(id string) float64 {
-	l.mu.RLock()
-	b, exists := l.buckets[id]
-	l.mu.RUnlock()
-
-	if !exists {
+	b := l.bucket(id)
+	if b == nil {
 		return 0
 	}
 
@@ -111,10 +169,25 @@ func (l *Limiter) GetTokens(id string) float64 {
 	defer b.mu.Unlock()
 
 	// Refill before returning
-	b.refillLocked(time.Now())
+	b.refillLocked(l.now())
 	return b.tokens
 }
 
+// Peek returns the tokens the bucket would hold now, without consuming or refreshing it.
+// A bucket that doesn't exist yet would start full, so Peek returns capacity for it.
+func (l *Limiter) Peek(id string, capacity, refillRate float64) float64 {
+	b := l.bucket(id)
+	if b == nil {
+		return capacity
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elapsed := max(l.now().Sub(b.lastActive).Seconds(), 0)
+	return min(b.tokens+elapsed*refillRate, capacity)
+}
+
 // refillLocked refills tokens based on elapsed time.
 // Must be called with b.mu held.
 func (b *Bucket) refillLocked(now time.Time) {
@@ -130,16 +203,32 @@ func (b *Bucket) refillLocked(now time.Time) {
 
 // Clean scans through the buckets and removes the ones that are too old.
 // Uses lastActive as the last activity timestamp for TTL calculation.
+// Shards are cleaned one at a time, so the others keep serving meanwhile.
 func (l *Limiter) Clean() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	now := time.Now()
-	for id, b := range l.buckets {
-		if now.Sub(b.lastActive) > l.TimeToLive {
-			delete(l.buckets, id)
+	now := l.now()
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.Lock()
+		for id, b := range s.buckets {
+			if now.Sub(b.lastActive) > l.TimeToLive {
+				delete(s.buckets, id)
+				l.cleaned.Add(1)
+			}
 		}
+		s.mu.Unlock()
+	}
+}
+
+// Stats returns the number of live buckets and how many were created and cleaned up.
+func (l *Limiter) Stats() LimiterStats {
+	stats := LimiterStats{Created: l.created.Load(), Cleaned: l.cleaned.Load()}
+	for i := range l.shards {
+		s := &l.shards[i]
+		s.mu.RLock()
+		stats.Buckets += len(s.buckets)
+		s.mu.RUnlock()
 	}
+	return stats
 }
 
 func (l *Limiter) cleaner(ctx context.Context) {
@@ -156,3 +245,19 @@ func (l *Limiter) cleaner(ctx context.Context) {
 		}
 	}
 }
+
+// withHTTPRateLimit limits the plain HTTP requests served by the handler to perMinute per
+// IP group, with bursts of up to perMinute requests, and answers the others with 429.
+// WebSocket upgrades are left to the relay, which limits what connections publish.
+func withHTTPRateLimit(limiter RateLimiter, perMinute float64, obs *Observability, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+			!limiter.Allow("http:"+rely.GetIP(r).Group(), perMinute, perMinute/60) {
+			obs.httpRateLimitedCount.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(60/perMinute))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
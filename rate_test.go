@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obs := &Observability{}
+	limiter := obs.TrackLimiter(NewLimiter(ctx))
+	limiter.Allow("alice", 10, 1)
+	limiter.Allow("alice", 10, 1)
+	limiter.Allow("bob", 10, 1)
+
+	stats := limiter.Stats()
+	if stats.Buckets != 2 || stats.Created != 2 || stats.Cleaned != 0 {
+		t.Errorf("unexpected stats after creating buckets: %+v", stats)
+	}
+
+	limiter.TimeToLive = -time.Second
+	limiter.Clean()
+	if stats := limiter.Stats(); stats.Buckets != 0 || stats.Cleaned != 2 {
+		t.Errorf("unexpected stats after cleanup: %+v", stats)
+	}
+
+	metrics := make(map[string]uint64)
+	for _, m := range obs.Snapshot() {
+		metrics[m.Name] = m.Value
+	}
+	if metrics["limiter_buckets_created"] != 2 || metrics["limiter_buckets_cleaned"] != 2 {
+		t.Errorf("unexpected limiter metrics in snapshot: %v", metrics)
+	}
+	if _, ok := metrics["rate_limited_mid"]; !ok {
+		t.Error("expected per-tier rate metrics in snapshot")
+	}
+}
+
+func TestLimiterPeek(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	limiter := NewLimiter(ctx)
+	limiter.Clock = func() time.Time { return now }
+
+	if tokens := limiter.Peek("alice", 10, 1); tokens != 10 {
+		t.Errorf("unknown bucket: expected a full bucket, got %v", tokens)
+	}
+
+	limiter.Consume("alice", 4, 10, 1)
+	if tokens := limiter.Peek("alice", 10, 1); tokens != 6 {
+		t.Errorf("expected 6 tokens after consuming 4, got %v", tokens)
+	}
+	if tokens := limiter.Peek("alice", 10, 1); tokens != 6 {
+		t.Errorf("peeking must not consume, got %v", tokens)
+	}
+
+	now = now.Add(2 * time.Second)
+	if tokens := limiter.Peek("alice", 10, 1); tokens != 8 {
+		t.Errorf("expected 8 tokens after 2s of refill, got %v", tokens)
+	}
+	now = now.Add(time.Minute)
+	if tokens := limiter.Peek("alice", 10, 1); tokens != 10 {
+		t.Errorf("expected refill to stop at capacity, got %v", tokens)
+	}
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	obs := &Observability{}
+	handler := withHTTPRateLimit(NewLimiter(ctx), 2, obs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(ip string, websocket bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Real-IP", ip)
+		if websocket {
+			r.Header.Set("Upgrade", "websocket")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	codes := []int{request("203.0.113.7", false), request("203.0.113.7", false), request("203.0.113.7", false)}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the third request to be rate-limited, got %v", codes)
+	}
+	if obs.httpRateLimitedCount.Load() != 1 {
+		t.Errorf("expected the rejection to be counted, got %d", obs.httpRateLimitedCount.Load())
+	}
+
+	// Other IP groups and WebSocket upgrades are not affected
+	if code := request("198.51.100.1", false); code != http.StatusOK {
+		t.Errorf("another IP group: got %d", code)
+	}
+	if code := request("203.0.113.7", true); code != http.StatusOK {
+		t.Errorf("WebSocket upgrade: got %d", code)
+	}
+}
+
+// BenchmarkLimiterConsume measures concurrent token consumption by distinct pubkeys whose
+// buckets share a single shard, as they all did before sharding, and spread over all shards.
+func BenchmarkLimiterConsume(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var spread, oneShard []string
+	for i := 0; len(spread) < 1000 || len(oneShard) < 1000; i++ {
+		id := fmt.Sprintf("%064x", i)
+		if len(spread) < 1000 {
+			spread = append(spread, id)
+		}
+		if shardOf(id, shardCount) == 0 && len(oneShard) < 1000 {
+			oneShard = append(oneShard, id)
+		}
+	}
+
+	for _, bench := range []struct {
+		name string
+		ids  []string
+	}{{"one-shard", oneShard}, {"all-shards", spread}} {
+		b.Run(bench.name, func(b *testing.B) {
+			limiter := NewLimiter(ctx)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					// New buckets take the shard's write lock, existing ones its read lock
+					limiter.Consume(bench.ids[i%len(bench.ids)], 1, 1e9, 1e9)
+					i++
+				}
+			})
+		})
+	}
+}
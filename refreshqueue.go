@@ -0,0 +1,96 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+)
+
+// What to do with a pubkey queued for refresh while the refresh channel is full
+const (
+	refreshOverflowDrop       = "drop"        // drop the new pubkey
+	refreshOverflowDropOldest = "drop-oldest" // drop the oldest queued pubkey to make room
+	refreshOverflowSpill      = "spill"       // spill the new pubkey to the event store
+)
+
+// refreshQueuePrefix is the key prefix under which the pubkeys spilled from the refresh
+// channel, or left in it at shutdown, are kept in the event store until the refresher
+// has room for them. The event store only uses prefixes 0-8 and 255, the honeypot
+// labels 128, the store metadata 129 and the tombstones 130.
+//   - refreshQueuePrefix <pubkey> has an empty value
+const refreshQueuePrefix byte = 131
+
+// RefreshSpill is the persistent overflow of the refresh channel, which also keeps
+// the pubkeys waiting for a refresh across restarts.
+// Spilled pubkeys are deduplicated, and popped in key order rather than in the order they were spilled.
+type RefreshSpill struct {
+	db *badger.BadgerBackend
+}
+
+func NewRefreshSpill(db *badger.BadgerBackend) *RefreshSpill {
+	return &RefreshSpill{db: db}
+}
+
+// Push spills the pubkeys. A write batch is used, as a whole refresh queue may be too big for a transaction.
+func (s *RefreshSpill) Push(pubkeys ...string) error {
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, pubkey := range pubkeys {
+		if err := batch.Set(refreshQueueKey(pubkey), nil); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// Pop removes and returns up to max spilled pubkeys.
+func (s *RefreshSpill) Pop(max int) ([]string, error) {
+	var pubkeys []string
+	err := s.db.Update(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte{refreshQueuePrefix}
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var keys [][]byte
+		for it.Rewind(); it.Valid() && len(keys) < max; it.Next() {
+			key := it.Item().KeyCopy(nil)
+			keys = append(keys, key)
+			pubkeys = append(pubkeys, string(key[1:]))
+		}
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pubkeys, nil
+}
+
+// Len returns the number of spilled pubkeys. It scans the keys, so it's meant for periodic reporting.
+func (s *RefreshSpill) Len() int {
+	count := 0
+	s.db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte{refreshQueuePrefix}
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func refreshQueueKey(pubkey string) []byte {
+	return append([]byte{refreshQueuePrefix}, pubkey...)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRefreshSpill(t *testing.T) {
+	spill := NewRefreshSpill(newTestDB(t))
+
+	if err := spill.Push("carol", "alice", "bob", "alice"); err != nil {
+		t.Fatalf("failed to spill: %v", err)
+	}
+	if n := spill.Len(); n != 3 {
+		t.Errorf("expected 3 spilled pubkeys, got %d", n)
+	}
+
+	popped, err := spill.Pop(2)
+	if err != nil {
+		t.Fatalf("failed to pop: %v", err)
+	}
+	if !slices.Equal(popped, []string{"alice", "bob"}) {
+		t.Errorf("expected [alice bob], got %v", popped)
+	}
+
+	popped, err = spill.Pop(10)
+	if err != nil {
+		t.Fatalf("failed to pop: %v", err)
+	}
+	if !slices.Equal(popped, []string{"carol"}) {
+		t.Errorf("expected [carol], got %v", popped)
+	}
+	if n := spill.Len(); n != 0 {
+		t.Errorf("expected an empty spill, got %d", n)
+	}
+}
+
+func TestRefreshOverflow(t *testing.T) {
+	tests := []struct {
+		overflow string
+		queued   []string
+		spilled  []string
+		dropped  uint64
+	}{
+		{overflow: refreshOverflowDrop, queued: []string{"alice", "bob"}, dropped: 1},
+		{overflow: refreshOverflowDropOldest, queued: []string{"bob", "carol"}, dropped: 1},
+		{overflow: refreshOverflowSpill, queued: []string{"alice", "bob"}, spilled: []string{"carol"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overflow, func(t *testing.T) {
+			obs := &Observability{}
+			cache := &RankCache{refresh: make(chan string, 2), overflow: test.overflow, obs: obs}
+			spill := NewRefreshSpill(newTestDB(t))
+			cache.Spill(spill)
+
+			for _, pubkey := range []string{"alice", "bob", "carol"} {
+				cache.tryEnqueue(pubkey)
+			}
+
+			close(cache.refresh)
+			var queued []string
+			for pubkey := range cache.refresh {
+				queued = append(queued, pubkey)
+			}
+			if !slices.Equal(queued, test.queued) {
+				t.Errorf("expected %v queued, got %v", test.queued, queued)
+			}
+
+			spilled, err := spill.Pop(10)
+			if err != nil {
+				t.Fatalf("failed to pop: %v", err)
+			}
+			if !slices.Equal(spilled, test.spilled) {
+				t.Errorf("expected %v spilled, got %v", test.spilled, spilled)
+			}
+			if dropped := obs.rankRefreshDropped.Load(); dropped != test.dropped {
+				t.Errorf("expected %d dropped, got %d", test.dropped, dropped)
+			}
+		})
+	}
+}
+
+func TestRefreshQueuePersisted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewRankCache(ctx, Config{}, &Observability{})
+	spill := NewRefreshSpill(newTestDB(t))
+	cache.Spill(spill)
+
+	for _, pubkey := range []string{"bob", "alice", "bob"} {
+		cache.tryEnqueue(pubkey)
+	}
+	cancel()
+	cache.Wait()
+
+	persisted, err := spill.Pop(10)
+	if err != nil {
+		t.Fatalf("failed to pop: %v", err)
+	}
+	if !slices.Equal(persisted, []string{"alice", "bob"}) {
+		t.Errorf("expected [alice bob] persisted, got %v", persisted)
+	}
+}
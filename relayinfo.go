@@ -0,0 +1,93 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// relayInfoHosts bounds how many hosts the NIP-11 document is cached for, when the relay
+// hosts its icon or banner: their URLs follow the Host header, which anyone can set.
+const relayInfoHosts = 16
+
+// RelayInfo serves the NIP-11 document of a relay. The document is marshaled once for each
+// configuration, and for each host when images are hosted, rather than for every request.
+// It carries an ETag and a Last-Modified time, so that clients polling it can revalidate
+// with a conditional request, and get a 304 while it stays the same.
+type RelayInfo struct {
+	info         nip11.RelayInformationDocument
+	root         string
+	icon, banner *Asset
+	now          func() time.Time
+
+	mu   sync.Mutex
+	cfg  *Config                  // the configuration the documents were marshaled from
+	docs map[string]*relayInfoDoc // by URLs of the icon and banner
+}
+
+// relayInfoDoc is a marshaled NIP-11 document.
+type relayInfoDoc struct {
+	data     []byte
+	etag     string
+	modified time.Time // when the document last changed
+}
+
+// NewRelayInfo returns the RelayInfo serving the document under root, with the hosted
+// icon and banner (if not nil). now is the time documents are last modified at.
+func NewRelayInfo(info nip11.RelayInformationDocument, root string, icon, banner *Asset, now func() time.Time) *RelayInfo {
+	return &RelayInfo{info: info, root: root, icon: icon, banner: banner, now: now, docs: make(map[string]*relayInfoDoc)}
+}
+
+// document returns the document served through the request under cfg, marshaling it
+// only if cfg isn't the configuration of the cached documents or it isn't cached for
+// the request's host. A configuration is never changed, only replaced: see Settings.
+func (ri *RelayInfo) document(r *http.Request, cfg *Config) *relayInfoDoc {
+	info := ri.info
+	info.Icon = assetURL(r, ri.root, ri.info.Icon, ri.icon)
+	info.Banner = assetURL(r, ri.root, ri.info.Banner, ri.banner)
+	key := info.Icon + " " + info.Banner
+
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	previous := ri.docs[key]
+	if ri.cfg != cfg {
+		ri.cfg = cfg
+		clear(ri.docs)
+	} else if previous != nil {
+		return previous
+	}
+	if len(ri.docs) >= relayInfoHosts {
+		clear(ri.docs)
+	}
+
+	data := marshalRelayInfo(*cfg, info)
+	sum := sha256.Sum256(data)
+	doc := &relayInfoDoc{data: data, etag: `"` + hex.EncodeToString(sum[:16]) + `"`, modified: ri.now()}
+	// A new configuration that doesn't change the document doesn't invalidate the caches of clients
+	if previous != nil && previous.etag == doc.etag {
+		doc.modified = previous.modified
+	}
+	ri.docs[key] = doc
+	return doc
+}
+
+// Serve serves the document under cfg with the content type, honoring the conditional
+// headers of the request. NIP-11 requires the document to be readable from any origin,
+// whatever CORS_ORIGINS allows for the other endpoints.
+func (ri *RelayInfo) Serve(w http.ResponseWriter, r *http.Request, cfg *Config, contentType string) {
+	doc := ri.document(r, cfg)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", doc.etag)
+	http.ServeContent(w, r, "", doc.modified, bytes.NewReader(doc.data))
+}
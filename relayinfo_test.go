@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelayInfoConditional(t *testing.T) {
+	cfg := parseConfig(func(string) string { return "" })
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ri := NewRelayInfo(createRelayInfoDocument(cfg), "/", nil, nil, func() time.Time { return now })
+	serve := func(cfg *Config, header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		ri.Serve(w, r, cfg, "application/nostr+json")
+		return w
+	}
+
+	w := serve(&cfg, "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") != now.Format(http.TimeFormat) {
+		t.Fatalf("expected the document with an ETag and a Last-Modified time, got %d %q %q", w.Code, etag, w.Header().Get("Last-Modified"))
+	}
+	if w.Header().Get("Content-Type") != "application/nostr+json" || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("expected the NIP-11 document, got %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	// Any origin may read the document, even when CORS_ORIGINS restricts the other endpoints
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Origin", "https://client.example.com")
+	w = httptest.NewRecorder()
+	withCORS([]string{"https://dashboard.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ri.Serve(w, r, &cfg, "application/nostr+json")
+	})).ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected the document to be readable from any origin, got %q", got)
+	}
+
+	if w := serve(&cfg, "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("matching ETag: got status %d, want 304", w.Code)
+	}
+	if w := serve(&cfg, "If-Modified-Since", now.Format(http.TimeFormat)); w.Code != http.StatusNotModified {
+		t.Errorf("not modified since: got status %d, want 304", w.Code)
+	}
+	if w := serve(&cfg, "If-None-Match", `"stale"`); w.Code != http.StatusOK {
+		t.Errorf("stale ETag: got status %d, want 200", w.Code)
+	}
+
+	// A new configuration is marshaled again, but only changes the ETag if it changes the document
+	now = now.Add(time.Hour)
+	same := cfg
+	if w := serve(&same, "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("same document: got status %d, want 304", w.Code)
+	}
+	changed := cfg
+	changed.ReqMaxFilters++
+	w = serve(&changed, "If-None-Match", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag || w.Header().Get("Last-Modified") != now.Format(http.TimeFormat) {
+		t.Errorf("changed document: got status %d with ETag %q modified %q", w.Code, w.Header().Get("ETag"), w.Header().Get("Last-Modified"))
+	}
+	var doc relayInformation
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc.Limitation.MaxFilters != changed.ReqMaxFilters {
+		t.Errorf("expected the limits of the new configuration, got %+v (%v)", doc.Limitation, err)
+	}
+}
+
+func TestRelayInfoHosts(t *testing.T) {
+	cfg := parseConfig(func(string) string { return "" })
+	icon := &Asset{Name: "icon.png", ContentType: "image/png"}
+	ri := NewRelayInfo(createRelayInfoDocument(cfg), "/", icon, nil, time.Now)
+	fetch := func(host string) *relayInfoDoc {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = host
+		return ri.document(r, &cfg)
+	}
+
+	doc := fetch("relay.example.com")
+	if fetch("relay.example.com") != doc {
+		t.Error("expected the document to be cached for the host")
+	}
+	var info relayInformation
+	if err := json.Unmarshal(fetch("other.example.com").data, &info); err != nil || info.Icon != "http://other.example.com/icon.png" {
+		t.Errorf("expected the icon on the request's host, got %q (%v)", info.Icon, err)
+	}
+
+	for i := range 2 * relayInfoHosts {
+		fetch(fmt.Sprintf("relay%d.example.com", i))
+	}
+	if len(ri.docs) > relayInfoHosts {
+		t.Errorf("expected at most %d cached documents, got %d", relayInfoHosts, len(ri.docs))
+	}
+}
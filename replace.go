@@ -0,0 +1,134 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replaceLocks serializes the saves of the versions of each address, so that versions
+// published at the same time can't both be kept.
+var replaceLocks = &addressLocks{locks: make(map[string]*addressLock)}
+
+// addressLocks holds a mutex per address, for as long as it is in use.
+type addressLocks struct {
+	mu    sync.Mutex
+	locks map[string]*addressLock
+}
+
+type addressLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks the address, and returns the function unlocking it.
+func (l *addressLocks) lock(address string) (unlock func()) {
+	l.mu.Lock()
+	lock, ok := l.locks[address]
+	if !ok {
+		lock = &addressLock{}
+		l.locks[address] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(l.locks, address)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// isReplaceable reports whether only the latest version of the event is kept: replaceable
+// kinds (0, 3, 10000-19999) per pubkey and kind, addressable kinds (30000-39999) per
+// pubkey, kind and d tag.
+func isReplaceable(kind int) bool {
+	return nostr.IsReplaceableKind(kind) || nostr.IsAddressableKind(kind)
+}
+
+// isOlder reports whether a is an older version than b. Among versions created
+// the same second, the one with the lowest ID is the latest (NIP-01).
+func isOlder(a, b *nostr.Event) bool {
+	return a.CreatedAt < b.CreatedAt || (a.CreatedAt == b.CreatedAt && a.ID > b.ID)
+}
+
+// storedVersions returns the stored versions of the replaceable or addressable event.
+// An addressable event without a d tag has the address of an empty d tag.
+func storedVersions(ctx context.Context, e *nostr.Event, d *Deps) ([]*nostr.Event, error) {
+	filter := nostr.Filter{Kinds: []int{e.Kind}, Authors: []string{e.PubKey}}
+	dTag := e.Tags.GetD()
+	if nostr.IsAddressableKind(e.Kind) && dTag != "" {
+		filter.Tags = nostr.TagMap{"d": {dTag}}
+	}
+
+	// The d tag filter matches any d tag, while the address is the first one
+	address := eventAddress(e)
+	var versions []*nostr.Event
+	keep := func(version *nostr.Event) error {
+		if eventAddress(version) == address {
+			versions = append(versions, version)
+		}
+		return nil
+	}
+
+	// Events without a d tag don't match a filter of the empty d tag: every addressable
+	// event of the kind is looked at, beyond the store's default limit
+	if nostr.IsAddressableKind(e.Kind) && dTag == "" {
+		_, err := scanFilter(ctx, d.DB, filter, 0, keep)
+		return versions, err
+	}
+
+	events, err := d.DB.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for version := range events {
+		keep(version)
+	}
+	return versions, nil
+}
+
+// saveReplaceable saves the latest version of a replaceable or addressable event and
+// deletes the older ones, releasing their storage. Versions older than a stored one
+// are rejected with ErrOutdated.
+func saveReplaceable(ctx context.Context, e *nostr.Event, d *Deps) error {
+	unlock := replaceLocks.lock(d.Name + "/" + eventAddress(e))
+	defer unlock()
+
+	versions, err := storedVersions(ctx, e, d)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if version.ID != e.ID && !isOlder(version, e) {
+			return ErrOutdated
+		}
+	}
+
+	if err := d.DB.SaveEvent(ctx, e); err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		if version.ID == e.ID {
+			continue
+		}
+		if err := d.DB.DeleteEvent(ctx, version); err != nil {
+			eventLog.ErrorContext(ctx, "failed to delete the replaced event", "event", version.ID, "error", err)
+			continue
+		}
+		d.Retention.Deleted(version)
+		if d.Latest != nil {
+			d.Latest.Removed(version)
+		}
+	}
+	return nil
+}
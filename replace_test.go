@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSaveReplaceable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	db := newTestDB(t)
+	clock := &replayClock{}
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), db, obs, clock)
+
+	sk := nostr.GeneratePrivateKey()
+	save := func(kind int, createdAt nostr.Timestamp, tags nostr.Tags) (*nostr.Event, error) {
+		e := &nostr.Event{Kind: kind, CreatedAt: createdAt, Tags: tags, Content: "version"}
+		e.Sign(sk)
+		return e, store(ctx, e, d)
+	}
+	stored := func(kind int) []string {
+		events, _ := db.QueryEvents(ctx, nostr.Filter{Kinds: []int{kind}})
+		var ids []string
+		for e := range events {
+			ids = append(ids, e.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		kind int
+		tags nostr.Tags
+	}{
+		{name: "metadata", kind: 0},
+		{name: "follow list", kind: 3},
+		{name: "relay list", kind: 10002},
+		{name: "addressable", kind: 30000, tags: nostr.Tags{{"d", "friends"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			save(test.kind, 100, test.tags)
+			latest, err := save(test.kind, 200, test.tags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := stored(test.kind); len(ids) != 1 || ids[0] != latest.ID {
+				t.Errorf("expected only the latest version to be stored, got %v", ids)
+			}
+			if _, err := save(test.kind, 150, test.tags); !errors.Is(err, ErrOutdated) {
+				t.Errorf("older version: got %v, want %v", err, ErrOutdated)
+			}
+		})
+	}
+
+	// Addressable events are replaced per d tag, regular events accumulate
+	save(30000, 300, nostr.Tags{{"d", "family"}})
+	if ids := stored(30000); len(ids) != 2 {
+		t.Errorf("expected one version per d tag, got %d events", len(ids))
+	}
+	save(1, 100, nil)
+	save(1, 200, nil)
+	if ids := stored(1); len(ids) != 2 {
+		t.Errorf("expected regular events to accumulate, got %d events", len(ids))
+	}
+	if count := d.Retention.count.Load(); count != 7 {
+		t.Errorf("expected the replaced versions to be released, got a count of %d", count)
+	}
+
+	// A missing d tag is the empty d tag
+	save(30001, 100, nil)
+	latest, _ := save(30001, 200, nostr.Tags{{"d", ""}})
+	if ids := stored(30001); len(ids) != 1 || ids[0] != latest.ID {
+		t.Errorf("expected the version without a d tag to be replaced, got %v", ids)
+	}
+	if _, err := save(30001, 150, nil); !errors.Is(err, ErrOutdated) {
+		t.Errorf("older version without a d tag: got %v, want %v", err, ErrOutdated)
+	}
+
+	// The version without a d tag is found behind more recent addresses than a query returns
+	for i := range 300 {
+		e := &nostr.Event{Kind: 30001, CreatedAt: nostr.Timestamp(1000 + i), Tags: nostr.Tags{{"d", strconv.Itoa(i)}}}
+		e.Sign(sk)
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := save(30001, 150, nil); !errors.Is(err, ErrOutdated) {
+		t.Errorf("older version without a d tag behind other addresses: got %v, want %v", err, ErrOutdated)
+	}
+}
+
+func TestAddressLocks(t *testing.T) {
+	locks := &addressLocks{locks: make(map[string]*addressLock)}
+	unlock := locks.lock("0:alice:")
+
+	locked, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		unlock := locks.lock("0:alice:")
+		close(locked)
+		unlock()
+		close(done)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected the address to stay locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	locks.lock("0:bob:")()
+
+	unlock()
+	<-done
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("expected the unused locks to be released, got %d", len(locks.locks))
+	}
+}
+
+func TestIsOlder(t *testing.T) {
+	a := &nostr.Event{ID: strings.Repeat("a", 64), CreatedAt: 100}
+	b := &nostr.Event{ID: strings.Repeat("b", 64), CreatedAt: 100}
+	if !isOlder(b, a) || isOlder(a, b) {
+		t.Error("expected the lowest ID to win a tie")
+	}
+	if b.CreatedAt = 101; !isOlder(a, b) {
+		t.Error("expected the latest created_at to win")
+	}
+}
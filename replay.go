@@ -0,0 +1,269 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// ReplayReport summarizes what the policy pipeline decided about a replayed stream.
+type ReplayReport struct {
+	Total    int
+	Invalid  int // lines that aren't events with a valid signature
+	Accepted int
+	Rejected map[string]int // rejection reason -> count
+}
+
+// replayClock is a virtual clock following the timestamps of the replayed events,
+// so that token buckets and time windows see the original traffic pattern however
+// fast it is replayed.
+type replayClock struct {
+	unix atomic.Int64
+}
+
+func (c *replayClock) Now() time.Time {
+	return time.Unix(c.unix.Load(), 0)
+}
+
+// advance moves the clock forward to t; it never goes backwards.
+func (c *replayClock) advance(t nostr.Timestamp) {
+	for {
+		current := c.unix.Load()
+		if int64(t) <= current || c.unix.CompareAndSwap(current, int64(t)) {
+			return
+		}
+	}
+}
+
+// replayClient stands for the client that published a replayed event.
+// Captured streams carry no IP address nor authentication, so it has none.
+type replayClient struct {
+	rely.Client
+}
+
+func (replayClient) IP() rely.IP       { return rely.IP{} }
+func (replayClient) Pubkeys() []string { return nil }
+
+// replay implements `wotrlay replay [flags] <events.jsonl>`, and returns the process exit code.
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	storePath := flags.String("store", "", "scratch event store directory (default: a temporary directory, removed afterwards)")
+	verbose := flags.Bool("v", false, "print the decision about every event")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay replay [flags] <events.jsonl|->")
+		fmt.Fprintln(flags.Output(), "\nReplays a JSONL stream of events through the policy pipeline under the current configuration,")
+		fmt.Fprintln(flags.Output(), "against a scratch store, and reports what would be accepted or rejected.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input := os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if *storePath == "" {
+		dir, err := os.MkdirTemp("", "wotrlay-replay-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create scratch store: %v\n", err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		*storePath = dir
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := loadConfig()
+	cfg.StorePath = *storePath
+	obs := &Observability{}
+
+	db := &badger.BadgerBackend{Path: cfg.StorePath}
+	if err := db.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize badger backend at %s: %v\n", cfg.StorePath, err)
+		return 1
+	}
+	defer db.Close()
+	if err := migrateStore(db, eventStoreMigrations); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	clock := &replayClock{}
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), db, obs, clock)
+
+	out := io.Discard
+	if *verbose {
+		out = os.Stdout
+	}
+
+	report, err := runReplay(ctx, input, cfg, d, clock, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	printReplayReport(os.Stdout, report)
+	return 0
+}
+
+// newReplayDeps returns the dependencies of a standalone relay running on the clock.
+// Retention by age is disabled, as replayed events are typically older than it.
+func newReplayDeps(ctx context.Context, cfg Config, cache *RankCache, db *badger.BadgerBackend, obs *Observability, clock *replayClock) *Deps {
+	limiter := NewLimiter(ctx)
+	limiter.Clock = clock.Now
+	dedup := NewContentDedup(ctx, time.Duration(cfg.DuplicateContentWindowMinutes)*time.Minute)
+	dedup.Clock = clock.Now
+
+	return &Deps{
+		Name:          "replay",
+		Cache:         cache,
+		Limiter:       limiter,
+		GlobalLimiter: NewLimiter(ctx),
+		DB:            db,
+		Meta:          db,
+		Obs:           obs,
+		Retention:     NewRetention(ctx, db, 0, int64(cfg.StoreMaxEvents)),
+		Linkage:       NewIPLinkage(ctx, cfg.BannedPubkeys),
+		Dedup:         dedup,
+		Zaps:          NewZapValidator(ctx, cfg.ZapVerifyProvider),
+		ZapTrust:      NewZapTrust(),
+		Latest:        NewLatestSeen(),
+		Media:         NewMediaPolicy(ctx),
+		Flags:         NewFeatureFlags(cfg),
+		Backfill:      NewBackfill(cfg.BackfillDailyCap),
+		Clock:         clock.Now,
+		URLs:          NewURLDetector(cfg.URLTLDValidation, cfg.URLExtraTLDs),
+	}
+}
+
+// runReplay reads one event per line from r and passes them in order through the policy
+// pipeline, with the clock following their created_at timestamps. The ranks of all the
+// authors are fetched upfront, so that lookups never miss during the replay.
+// The decision about each event is written to out.
+func runReplay(ctx context.Context, r io.Reader, cfg Config, d *Deps, clock *replayClock, out io.Writer) (ReplayReport, error) {
+	report := ReplayReport{Rejected: make(map[string]int)}
+
+	var events []*nostr.Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		report.Total++
+		e := &nostr.Event{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			report.Invalid++
+			fmt.Fprintf(out, "invalid line %d: %v\n", report.Total, err)
+			continue
+		}
+		if ok, err := e.CheckSignature(); !ok {
+			report.Invalid++
+			fmt.Fprintf(out, "invalid event %s: bad signature %v\n", e.ID, err)
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("failed to read events: %w", err)
+	}
+
+	prefetchRanks(ctx, d.Cache, events)
+
+	client := replayClient{}
+	for _, e := range events {
+		clock.advance(e.CreatedAt)
+		// Shadow-banned events are acknowledged as accepted, as the relay does
+		if err := handleEvent(ctx, client, e, cfg, d); err != nil && !errors.Is(err, errShadowBanned) {
+			report.Rejected[err.Error()]++
+			fmt.Fprintf(out, "reject %s kind=%d pubkey=%s: %v\n", e.ID, e.Kind, e.PubKey, err)
+			continue
+		}
+
+		report.Accepted++
+		fmt.Fprintf(out, "accept %s kind=%d pubkey=%s\n", e.ID, e.Kind, e.PubKey)
+	}
+	return report, nil
+}
+
+// prefetchRanks refreshes the ranks of the authors of the events that aren't cached.
+// Pubkeys whose rank can't be fetched are replayed with rank 0.
+func prefetchRanks(ctx context.Context, cache *RankCache, events []*nostr.Event) {
+	seen := make(map[string]struct{}, len(events))
+	batch := make([]string, 0, MaxPubkeysToRank)
+	for _, e := range events {
+		if _, ok := seen[e.PubKey]; ok {
+			continue
+		}
+		seen[e.PubKey] = struct{}{}
+
+		if _, cached := cache.Peek(e.PubKey); cached {
+			continue
+		}
+		batch = append(batch, e.PubKey)
+		if len(batch) == MaxPubkeysToRank {
+			if err := cache.refreshBatch(ctx, batch); err != nil {
+				rankLog.Error("failed to fetch ranks", "error", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := cache.refreshBatch(ctx, batch); err != nil {
+			rankLog.Error("failed to fetch ranks", "error", err)
+		}
+	}
+}
+
+// printReplayReport writes the report to w, with rejection reasons from the most frequent.
+func printReplayReport(w io.Writer, report ReplayReport) {
+	rejected := 0
+	reasons := make([]string, 0, len(report.Rejected))
+	for reason, count := range report.Rejected {
+		rejected += count
+		reasons = append(reasons, reason)
+	}
+	slices.SortFunc(reasons, func(a, b string) int {
+		return cmp.Or(cmp.Compare(report.Rejected[b], report.Rejected[a]), cmp.Compare(a, b))
+	})
+
+	fmt.Fprintf(w, "events:   %d\n", report.Total)
+	fmt.Fprintf(w, "invalid:  %d\n", report.Invalid)
+	fmt.Fprintf(w, "accepted: %d\n", report.Accepted)
+	fmt.Fprintf(w, "rejected: %d\n", rejected)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %6d  %s\n", report.Rejected[reason], reason)
+	}
+}
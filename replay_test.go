@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRunReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+
+	db := &badger.BadgerBackend{Path: t.TempDir()}
+	if err := db.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	newcomer, trusted := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	newcomerPub, _ := nostr.GetPublicKey(newcomer)
+	trustedPub, _ := nostr.GetPublicKey(trusted)
+
+	cache := NewRankCache(ctx, cfg, obs)
+	cache.Update(time.Now(), PubRank{Pubkey: newcomerPub, Rank: 0}, PubRank{Pubkey: trustedPub, Rank: 0.9})
+
+	start := nostr.Timestamp(1700000000)
+	event := func(sk string, kind int, at nostr.Timestamp, content string) string {
+		e := nostr.Event{CreatedAt: at, Kind: kind, Content: content}
+		if err := e.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		return e.String()
+	}
+
+	stream := strings.Join([]string{
+		event(newcomer, 1, start, "first note"),
+		event(newcomer, 1, start+600, "second note, ten minutes later"),
+		event(newcomer, 7, start+700, "+"),
+		event(trusted, 7, start+800, "+"),
+		"not an event",
+		event(newcomer, 1, start+25*3600, "third note, a day later"),
+	}, "\n")
+
+	clock := &replayClock{}
+	d := newReplayDeps(ctx, cfg, cache, db, obs, clock)
+
+	report, err := runReplay(ctx, strings.NewReader(stream), cfg, d, clock, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total != 6 || report.Invalid != 1 || report.Accepted != 3 {
+		t.Errorf("got %+v, want 6 events, 1 invalid, 3 accepted", report)
+	}
+	if report.Rejected[ErrRateLimited.Error()] != 1 || report.Rejected[ErrKindNotAllowed.Error()] != 1 {
+		t.Errorf("unexpected rejections: %v", report.Rejected)
+	}
+	if got := clock.Now(); got != time.Unix(int64(start+25*3600), 0) {
+		t.Errorf("clock should follow the events, got %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replyTarget returns the ID of the event a kind 1 note replies to, following NIP-10:
+// the "e" tag marked "reply", else the one marked "root", else the last unmarked "e" tag.
+func replyTarget(e *nostr.Event) (string, bool) {
+	var root, last string
+	for _, tag := range e.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		marker := ""
+		if len(tag) >= 4 {
+			marker = tag[3]
+		}
+
+		switch marker {
+		case "reply":
+			return tag[1], true
+		case "root":
+			root = tag[1]
+		case "mention":
+		default:
+			last = tag[1]
+		}
+	}
+
+	if root != "" {
+		return root, true
+	}
+	return last, last != ""
+}
+
+// isReplyToHighTrust reports whether the event replies to an event stored on the
+// relay whose author is in the high trust tier, according to the cached rank.
+func isReplyToHighTrust(ctx context.Context, e *nostr.Event, cfg Config, d *Deps) bool {
+	target, ok := replyTarget(e)
+	if !ok {
+		return false
+	}
+
+	parent := getEventByID(ctx, d.DB, target)
+	if parent == nil {
+		return false
+	}
+
+	rank, ok := operatorRank(parent.PubKey, cfg)
+	if !ok {
+		rank, _ = d.Cache.Rank(parent.PubKey)
+	}
+	return tierFor(rank, cfg) == TierHigh
+}
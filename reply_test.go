@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReplyTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     nostr.Tags
+		expected string
+	}{
+		{name: "no e tags", tags: nostr.Tags{{"p", "alice"}}, expected: ""},
+		{name: "marked reply", tags: nostr.Tags{{"e", "root", "", "root"}, {"e", "parent", "", "reply"}}, expected: "parent"},
+		{name: "marked root only", tags: nostr.Tags{{"e", "root", "", "root"}}, expected: "root"},
+		{name: "positional", tags: nostr.Tags{{"e", "root"}, {"e", "parent"}}, expected: "parent"},
+		{name: "mentions are ignored", tags: nostr.Tags{{"e", "quoted", "", "mention"}}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := replyTarget(&nostr.Event{Kind: 1, Tags: tt.tags})
+			if got != tt.expected || ok != (tt.expected != "") {
+				t.Errorf("replyTarget() = %q, %v, want %q", got, ok, tt.expected)
+			}
+		})
+	}
+}
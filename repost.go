@@ -0,0 +1,70 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// isRepost reports whether the event is a repost (kind 6) or generic repost (kind 16).
+func isRepost(e *nostr.Event) bool {
+	return e.Kind == 6 || e.Kind == 16
+}
+
+// repostTarget returns the tag referencing the reposted event: the "e" tag,
+// or the "a" tag for generic reposts of addressable events.
+func repostTarget(e *nostr.Event) (tagName, value string, ok bool) {
+	if tag := e.Tags.Find("e"); tag != nil {
+		return "e", tag[1], true
+	}
+	if e.Kind == 16 {
+		if tag := e.Tags.Find("a"); tag != nil {
+			return "a", tag[1], true
+		}
+	}
+	return "", "", false
+}
+
+// checkRepost enforces the repost policy: reposts must reference a known event
+// from a non-banned author, each pubkey may repost a given event only once, and
+// reposts are capped per day according to the author's trust tier. The cap is only
+// peeked at: chargeRepost spends it once the event passed the other checks.
+func checkRepost(ctx context.Context, e *nostr.Event, rank float64, cfg Config, d *Deps) error {
+	tagName, target, ok := repostTarget(e)
+	if !ok {
+		return ErrRepostTarget
+	}
+
+	// "a" targets are replaceable and can't be looked up by ID, only dedup applies.
+	if tagName == "e" {
+		original := getEventByID(ctx, d.DB, target)
+		if original == nil || d.Linkage.IsBanned(original.PubKey) {
+			return ErrRepostTarget
+		}
+	}
+
+	if hasEvent(ctx, d.DB, nostr.Filter{
+		Authors: []string{e.PubKey},
+		Kinds:   []int{e.Kind},
+		Tags:    nostr.TagMap{tagName: {target}},
+	}) {
+		return ErrRepostDuplicate
+	}
+
+	dailyCap := cfg.RepostDailyCaps[tierFor(rank, cfg)]
+	if dailyCap > 0 && d.Limiter.Peek("repost:"+e.PubKey, dailyCap, dailyCap/secondsPerDay) < 1 {
+		return ErrRepostLimited
+	}
+	return nil
+}
+
+// chargeRepost spends a repost of the author's daily cap.
+func chargeRepost(e *nostr.Event, rank float64, cfg Config, d *Deps) error {
+	if !d.Limiter.AllowDaily("repost:"+e.PubKey, cfg.RepostDailyCaps[tierFor(rank, cfg)]) {
+		return ErrRepostLimited
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRepostTarget(t *testing.T) {
+	tests := []struct {
+		kind         int
+		tags         nostr.Tags
+		name, target string
+		ok           bool
+	}{
+		{6, nostr.Tags{{"e", "abc"}, {"p", "def"}}, "e", "abc", true},
+		{16, nostr.Tags{{"a", "30023:def:slug"}}, "a", "30023:def:slug", true},
+		{6, nostr.Tags{{"a", "30023:def:slug"}}, "", "", false},
+		{16, nostr.Tags{{"p", "def"}}, "", "", false},
+	}
+	for _, tt := range tests {
+		name, target, ok := repostTarget(&nostr.Event{Kind: tt.kind, Tags: tt.tags})
+		if name != tt.name || target != tt.target || ok != tt.ok {
+			t.Errorf("kind %d %v: got %q %q %v", tt.kind, tt.tags, name, target, ok)
+		}
+	}
+}
+
+func TestRepostPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{
+			"REPOST_POLICY_ENABLED": "true",
+			"REPOST_DAILY_CAP_LOW":  "2",
+			"RATE_MULTIPLIER":       "100",
+		}[key]
+	})
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	cache := NewRankCache(ctx, cfg, obs)
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+
+	var originals []*nostr.Event
+	author := nostr.GeneratePrivateKey()
+	for _, topic := range []string{"a", "b", "c"} {
+		original := signedEvent(t, author, nostr.KindTextNote, nostr.Tags{{"t", topic}})
+		if err := d.DB.SaveEvent(ctx, original); err != nil {
+			t.Fatal(err)
+		}
+		originals = append(originals, original)
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(sk)
+	cache.Update(time.Now(), PubRank{Pubkey: pubkey, Rank: 0.2})
+	repost := func(id string, cfg Config) error {
+		return handleEvent(ctx, ipClient{ip: "203.0.113.7"}, signedEvent(t, sk, nostr.KindRepost, nostr.Tags{{"e", id}}), cfg, d)
+	}
+
+	// Reposts refused by a later check, here their timestamp, don't spend the cap
+	for range 2 {
+		future := &nostr.Event{Kind: nostr.KindRepost, CreatedAt: nostr.Now() + 2*secondsPerDay, Tags: nostr.Tags{{"e", originals[0].ID}}}
+		future.Sign(sk)
+		if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, future, cfg, d); err == nil || errors.Is(err, ErrRepostLimited) {
+			t.Fatalf("repost from the future: got %v", err)
+		}
+	}
+
+	// The low tier can't publish kind 6, but may repost up to its cap
+	if err := repost(originals[0].ID, cfg); err != nil {
+		t.Fatalf("first repost: %v", err)
+	}
+	if err := repost(originals[0].ID, cfg); !errors.Is(err, ErrRepostDuplicate) {
+		t.Errorf("second repost of the same event: got %v, want %v", err, ErrRepostDuplicate)
+	}
+	if err := repost(nostr.GeneratePrivateKey(), cfg); !errors.Is(err, ErrRepostTarget) {
+		t.Errorf("repost of an unknown event: got %v, want %v", err, ErrRepostTarget)
+	}
+	if err := repost(originals[1].ID, cfg); err != nil {
+		t.Fatalf("second repost: %v", err)
+	}
+	if err := repost(originals[2].ID, cfg); !errors.Is(err, ErrRepostLimited) {
+		t.Errorf("repost over the daily cap: got %v, want %v", err, ErrRepostLimited)
+	}
+
+	// Without a cap, reposts are up to the tier's kinds
+	cfg.RepostDailyCaps[TierLow] = 0
+	if err := repost(originals[2].ID, cfg); !errors.Is(err, ErrKindNotAllowed) {
+		t.Errorf("repost without a cap: got %v, want %v", err, ErrKindNotAllowed)
+	}
+}
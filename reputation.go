@@ -0,0 +1,275 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// kindAppData is the NIP-78 application data kind, of the reputation lists relays share.
+	kindAppData = 30078
+
+	// reputationListID is the d tag of the reputation lists.
+	reputationListID = "wotrlay/reputation"
+
+	// maxReputationList bounds the size of a reputation list fetched from a peer.
+	maxReputationList = 10 << 20
+)
+
+// Reputation shares the relay's abusive pubkeys and IP groups with cooperating relays,
+// and merges theirs into its ban evasion policy. Each relay serves its list as a signed
+// NIP-78 event, to peers authenticated with NIP-98, and fetches the lists of its peers.
+// Every pubkey or IP group gets the sum of the trust weights of the peers listing it,
+// and those reaching the threshold are marked suspect in the relay's IPLinkage.
+type Reputation struct {
+	linkage   *IPLinkage
+	secretKey string             // signs the relay's list and the requests for the peers' lists
+	peers     map[string]float64 // trust weight of each peer's key
+	sources   []string           // URLs of the peers' lists
+	threshold float64
+	fetch     func(ctx context.Context, source string) (*nostr.Event, error)
+
+	mu      sync.Mutex
+	pubkeys map[string]float64      // merged scores of the pubkeys
+	groups  map[string]float64      // merged scores of the IP groups
+	last    map[string]*nostr.Event // last list fetched from each source, merged while it fails
+}
+
+// ReputationStatus is the merged state of the peers' lists, as served by /stats.
+type ReputationStatus struct {
+	Pubkeys int `json:"pubkeys"`   // pubkeys over the threshold
+	Groups  int `json:"ip_groups"` // IP groups over the threshold
+}
+
+func NewReputation(linkage *IPLinkage, cfg Config) *Reputation {
+	r := &Reputation{
+		linkage:   linkage,
+		secretKey: cfg.ReputationSecretKey,
+		peers:     cfg.ReputationPeers,
+		sources:   cfg.ReputationSources,
+		threshold: cfg.ReputationThreshold,
+		last:      make(map[string]*nostr.Event),
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	r.fetch = func(ctx context.Context, source string) (*nostr.Event, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := signHTTPAuth(r.secretKey, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", auth)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		var list nostr.Event
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxReputationList)).Decode(&list); err != nil {
+			return nil, fmt.Errorf("invalid list: %w", err)
+		}
+		return &list, nil
+	}
+	return r
+}
+
+// List returns the relay's reputation list: a NIP-78 event tagging its banned
+// pubkeys (p tags) and the IP groups they were seen from (ip tags).
+func (r *Reputation) List(now time.Time) (*nostr.Event, error) {
+	pubkeys, groups := r.linkage.Abusive()
+	list := &nostr.Event{
+		Kind:      kindAppData,
+		CreatedAt: nostr.Timestamp(now.Unix()),
+		Tags:      nostr.Tags{{"d", reputationListID}},
+	}
+	for _, pubkey := range pubkeys {
+		list.Tags = append(list.Tags, nostr.Tag{"p", pubkey})
+	}
+	for _, group := range groups {
+		list.Tags = append(list.Tags, nostr.Tag{"ip", group})
+	}
+	if err := list.Sign(r.secretKey); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Sync fetches the lists of the sources and merges them. A source failing has its
+// last list merged, and if all fail, the previous merge is kept.
+func (r *Reputation) Sync(ctx context.Context) error {
+	lists := make(map[string]*nostr.Event, len(r.sources))
+	var errs []error
+	fetched := 0
+	for _, source := range r.sources {
+		list, err := r.fetch(ctx, source)
+		if err == nil {
+			err = r.verify(list)
+		}
+
+		r.mu.Lock()
+		if err == nil {
+			r.last[source] = list
+			fetched++
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %w", source, err))
+			list = r.last[source]
+		}
+		r.mu.Unlock()
+		if list == nil {
+			continue
+		}
+
+		// A peer's list may be served by several sources: keep the newest
+		if current, ok := lists[list.PubKey]; !ok || list.CreatedAt > current.CreatedAt {
+			lists[list.PubKey] = list
+		}
+	}
+	if fetched == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		relayLog.WarnContext(ctx, "failed to fetch reputation list", "error", err)
+	}
+
+	pubkeys, groups := make(map[string]float64), make(map[string]float64)
+	for peer, list := range lists {
+		weight := r.peers[peer]
+		for _, tag := range list.Tags {
+			switch {
+			case len(tag) < 2:
+			case tag[0] == "p" && nostr.IsValid32ByteHex(tag[1]):
+				pubkeys[tag[1]] += weight
+			case tag[0] == "ip":
+				groups[tag[1]] += weight
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.pubkeys, r.groups = pubkeys, groups
+	r.mu.Unlock()
+
+	reported, reportedGroups := r.reported(pubkeys), r.reported(groups)
+	r.linkage.SetShared(reported, reportedGroups)
+	relayLog.InfoContext(ctx, "merged reputation lists", "lists", len(lists), "pubkeys", len(reported), "ip_groups", len(reportedGroups))
+	return nil
+}
+
+// verify checks that the list is a reputation list signed by a peer.
+func (r *Reputation) verify(list *nostr.Event) error {
+	if list.Kind != kindAppData || list.Tags.GetD() != reputationListID {
+		return errors.New("not a reputation list")
+	}
+	if _, ok := r.peers[list.PubKey]; !ok {
+		return fmt.Errorf("list signed by %s, which is not a peer", list.PubKey)
+	}
+	if ok, _ := list.CheckSignature(); !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// reported returns the entries whose score reaches the threshold. Scores are rounded
+// so that weights like 0.1 add up to the threshold they're meant to reach.
+func (r *Reputation) reported(scores map[string]float64) []string {
+	var entries []string
+	for entry, score := range scores {
+		if math.Round(score*1e9) >= math.Round(r.threshold*1e9) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.Sort(entries)
+	return entries
+}
+
+// Status returns the number of pubkeys and IP groups over the threshold in the last merge.
+func (r *Reputation) Status() ReputationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReputationStatus{Pubkeys: len(r.reported(r.pubkeys)), Groups: len(r.reported(r.groups))}
+}
+
+// reputationHandler serves GET <root>/reputation, the relay's reputation list, to its peers,
+// and reports whether the request was for it. Requests must carry a NIP-98 authorization
+// from one of the ReputationPeers.
+func reputationHandler(w http.ResponseWriter, r *http.Request, root string, d *Deps) bool {
+	if d.Reputation == nil || d.Reputation.secretKey == "" || r.URL.Path != path.Join(root, "reputation") || r.Method != http.MethodGet {
+		return false
+	}
+
+	peer, err := verifyHTTPAuth(r, nil, d.now())
+	if _, ok := d.Reputation.peers[peer]; err == nil && !ok {
+		err = errors.New("pubkey is not a peer of this relay")
+	}
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return true
+	}
+
+	list, err := d.Reputation.List(d.now())
+	if err != nil {
+		http.Error(w, "failed to sign the reputation list", http.StatusInternalServerError)
+		return true
+	}
+	writeJSON(w, list)
+	return true
+}
+
+// signHTTPAuth returns a NIP-98 Authorization header for the request, signed with the key.
+func signHTTPAuth(secretKey, method, url string, body []byte) (string, error) {
+	hash := sha256.Sum256(body)
+	auth := nostr.Event{
+		Kind:      kindHTTPAuth,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"u", url}, {"method", method}, {"payload", hex.EncodeToString(hash[:])}},
+	}
+	if err := auth.Sign(secretKey); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// parseReputationPeers parses pubkey:weight pairs.
+func parseReputationPeers(items []string) (map[string]float64, error) {
+	peers := make(map[string]float64, len(items))
+	for _, item := range items {
+		pubkey, value, _ := strings.Cut(item, ":")
+		weight, err := strconv.ParseFloat(value, 64)
+		if !nostr.IsValid32ByteHex(pubkey) || err != nil || math.IsNaN(weight) || weight <= 0 {
+			return nil, fmt.Errorf("expected pubkey:weight pairs with a hex pubkey and a positive weight, got %q", item)
+		}
+		peers[pubkey] = weight
+	}
+	return peers, nil
+}
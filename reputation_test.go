@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestReputationExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	skA, skB := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	pubA, _ := nostr.GetPublicKey(skA)
+	pubB, _ := nostr.GetPublicKey(skB)
+	mallory := nostr.GeneratePrivateKey()
+	malloryPub, _ := nostr.GetPublicKey(mallory)
+
+	// Relay B banned mallory, seen from 1.2.3.4
+	b := &Deps{Linkage: NewIPLinkage(ctx, nil)}
+	b.Linkage.Record("1.2.3.4", malloryPub)
+	b.Linkage.Ban(malloryPub)
+	b.Reputation = NewReputation(b.Linkage, Config{ReputationSecretKey: skB, ReputationPeers: map[string]float64{pubA: 1}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !reputationHandler(w, r, "/", b) {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Relay A fetches it
+	a := NewIPLinkage(ctx, nil)
+	a.Record("1.2.3.4", "alice")
+	reputation := NewReputation(a, Config{
+		ReputationSecretKey: skA,
+		ReputationPeers:     map[string]float64{pubB: 1},
+		ReputationSources:   []string{server.URL + "/reputation"},
+		ReputationThreshold: 1,
+	})
+	if err := reputation.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.IsSuspect(malloryPub) || a.IsBanned(malloryPub) {
+		t.Error("pubkeys reported by a peer should be suspect, not banned")
+	}
+	if !a.IsSuspect("alice") {
+		t.Error("pubkeys seen from an IP group reported by a peer should be suspect")
+	}
+	a.Record("1.2.3.4", "bob")
+	if !a.IsSuspect("bob") {
+		t.Error("pubkeys showing up from an IP group reported by a peer should be suspect")
+	}
+	if status := reputation.Status(); status.Pubkeys != 1 || status.Groups != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Only peers may fetch the list
+	stranger := NewReputation(NewIPLinkage(ctx, nil), Config{
+		ReputationSecretKey: nostr.GeneratePrivateKey(),
+		ReputationPeers:     map[string]float64{pubB: 1},
+		ReputationSources:   []string{server.URL + "/reputation"},
+	})
+	if err := stranger.Sync(ctx); err == nil {
+		t.Error("the list should not be served to relays other than peers")
+	}
+}
+
+func TestReputationWeights(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lists := make(map[string]*nostr.Event)
+	list := func(sk string, kind int, pubkeys ...string) *nostr.Event {
+		tags := nostr.Tags{{"d", reputationListID}}
+		for _, pubkey := range pubkeys {
+			tags = append(tags, nostr.Tag{"p", pubkey})
+		}
+		return signedEvent(t, sk, kind, tags)
+	}
+	spammer1, spammer2 := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	skA, skB, skC := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	pubA, _ := nostr.GetPublicKey(skA)
+	pubB, _ := nostr.GetPublicKey(skB)
+
+	lists["a"] = list(skA, kindAppData, spammer1, spammer2)
+	lists["b"] = list(skB, kindAppData, spammer1)
+	lists["c"] = list(skC, kindAppData, spammer2)          // not a peer
+	lists["bad"] = list(skA, nostr.KindTextNote, spammer2) // not a list
+
+	linkage := NewIPLinkage(ctx, nil)
+	reputation := NewReputation(linkage, Config{
+		ReputationSecretKey: nostr.GeneratePrivateKey(),
+		ReputationPeers:     map[string]float64{pubA: 0.6, pubB: 0.4},
+		ReputationSources:   []string{"a", "b", "c", "bad", "down"},
+		ReputationThreshold: 1,
+	})
+	reputation.fetch = func(_ context.Context, source string) (*nostr.Event, error) {
+		if list, ok := lists[source]; ok {
+			return list, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	if err := reputation.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !linkage.IsSuspect(spammer1) {
+		t.Error("a pubkey listed by peers whose weights reach the threshold should be suspect")
+	}
+	if linkage.IsSuspect(spammer2) {
+		t.Error("a pubkey listed by peers whose weights don't reach the threshold should not be suspect")
+	}
+
+	// A peer failing has its last list merged
+	delete(lists, "b")
+	if err := reputation.Sync(ctx); err != nil || !linkage.IsSuspect(spammer1) {
+		t.Errorf("expected the last list of the failing peer to be merged, got %v", err)
+	}
+
+	// A failed sync keeps the last merge
+	reputation.sources = []string{"down"}
+	if err := reputation.Sync(ctx); err == nil || !linkage.IsSuspect(spammer1) {
+		t.Errorf("expected the sync to fail and keep the last merge, got %v", err)
+	}
+
+	// Lists are signed with the current time
+	signed, err := reputation.List(time.Now())
+	if err != nil || signed.Kind != kindAppData || signed.Tags.GetD() != reputationListID {
+		t.Errorf("unexpected list %v, %v", signed, err)
+	}
+}
+
+func TestParseReputationPeers(t *testing.T) {
+	pubkey := nostr.GeneratePrivateKey()
+	peers, err := parseReputationPeers([]string{pubkey + ":0.5"})
+	if err != nil || peers[pubkey] != 0.5 {
+		t.Errorf("unexpected peers %v, %v", peers, err)
+	}
+	for _, item := range []string{pubkey, pubkey + ":0", pubkey + ":-1", "npub:1"} {
+		if _, err := parseReputationPeers([]string{item}); err == nil {
+			t.Errorf("%q should be rejected", item)
+		}
+	}
+}
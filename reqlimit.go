@@ -0,0 +1,49 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+// reqRateLimit returns why the REQ must be closed, if the client has too many subscriptions
+// open or is querying too fast. Each filter is a store query, so filters are what is budgeted:
+// per IP group, or per pubkey for authenticated connections when ReqFiltersPerMinuteAuthed
+// is set, so they get their own budget wherever they connect from. Trusted peers are never limited.
+func reqRateLimit(c rely.Client, f nostr.Filters, cfg Config, d *Deps) error {
+	if isTrustedPeer(c, cfg) {
+		return nil
+	}
+
+	// A REQ reusing the id of an open subscription replaces it, but rely doesn't tell
+	// which id the REQ has, so it is counted as a new one: a client updating its
+	// subscriptions must stay below the cap, which is why it is off by default
+	if cfg.ReqMaxSubscriptions > 0 && len(c.Subscriptions()) >= cfg.ReqMaxSubscriptions {
+		d.Obs.tooManySubscriptionsCount.Add(1)
+		return fmt.Errorf("%w: max %d per connection", ErrTooManySubscriptions, cfg.ReqMaxSubscriptions)
+	}
+
+	id, perMinute := "", cfg.ReqFiltersPerMinute
+	if pubkeys := c.Pubkeys(); len(pubkeys) > 0 && cfg.ReqFiltersPerMinuteAuthed > 0 {
+		id, perMinute = "req:"+pubkeys[0], cfg.ReqFiltersPerMinuteAuthed
+	} else if group := c.IP().Group(); group != "" {
+		id = "req:ip:" + group
+	}
+	if id == "" || perMinute <= 0 {
+		return nil
+	}
+
+	// A minute worth of filters can be sent at once. A REQ with more filters than that
+	// must still get through eventually, so capacity is at least its number of filters
+	cost := float64(len(f))
+	capacity := max(perMinute, cost)
+	if !d.Limiter.Consume(id, cost, capacity, perMinute/60) {
+		d.Obs.reqRateLimitedCount.Add(1)
+		return ErrReqRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely"
+)
+
+type reqClient struct {
+	rely.Client
+	ip      string
+	pubkeys []string
+	subs    int
+}
+
+func (c *reqClient) IP() rely.IP                        { return rely.IP{Raw: net.ParseIP(c.ip)} }
+func (c *reqClient) Pubkeys() []string                  { return c.pubkeys }
+func (c *reqClient) Subscriptions() []rely.Subscription { return make([]rely.Subscription, c.subs) }
+
+func TestReqRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := Config{ReqMaxSubscriptions: 2, ReqFiltersPerMinute: 3, ReqFiltersPerMinuteAuthed: 10, TrustedPeers: []string{"peer"}}
+	obs := &Observability{}
+	d := &Deps{Obs: obs, Limiter: NewLimiter(ctx)}
+	filters := func(n int) nostr.Filters { return make(nostr.Filters, n) }
+
+	if err := reqRateLimit(&reqClient{ip: "203.0.113.7", subs: 2}, filters(1), cfg, d); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("REQ over the open subscriptions: got %v, want %v", err, ErrTooManySubscriptions)
+	}
+	// The cap is off by default, as REQs updating an open subscription count against it
+	if defaults := parseConfig(func(string) string { return "" }); defaults.ReqMaxSubscriptions != 0 {
+		t.Errorf("expected no subscription cap by default, got %d", defaults.ReqMaxSubscriptions)
+	}
+
+	// Connections of the same IP group share their budget
+	if err := reqRateLimit(&reqClient{ip: "203.0.113.7"}, filters(2), cfg, d); err != nil {
+		t.Fatalf("REQ within the budget: %v", err)
+	}
+	if err := reqRateLimit(&reqClient{ip: "203.0.113.7"}, filters(2), cfg, d); !errors.Is(err, ErrReqRateLimited) {
+		t.Errorf("REQ over the IP group budget: got %v, want %v", err, ErrReqRateLimited)
+	}
+	if err := reqRateLimit(&reqClient{ip: "198.51.100.1"}, filters(2), cfg, d); err != nil {
+		t.Errorf("other IP groups have their own budget: %v", err)
+	}
+
+	// Authenticated connections have the budget of their pubkey, and trusted peers have none
+	authed := &reqClient{ip: "203.0.113.7", pubkeys: []string{"alice"}}
+	if err := reqRateLimit(authed, filters(10), cfg, d); err != nil {
+		t.Errorf("authenticated REQ within the pubkey budget: %v", err)
+	}
+	if err := reqRateLimit(authed, filters(1), cfg, d); !errors.Is(err, ErrReqRateLimited) {
+		t.Errorf("REQ over the pubkey budget: got %v, want %v", err, ErrReqRateLimited)
+	}
+	peer := &reqClient{ip: "203.0.113.7", pubkeys: []string{"peer"}, subs: 100}
+	if err := reqRateLimit(peer, filters(100), cfg, d); err != nil {
+		t.Errorf("trusted peers are not limited: %v", err)
+	}
+
+	// Without an authenticated budget, authenticated connections share their IP group's
+	cfg.ReqFiltersPerMinuteAuthed = 0
+	bob := &reqClient{ip: "198.51.100.1", pubkeys: []string{"bob"}}
+	if err := reqRateLimit(bob, filters(2), cfg, d); !errors.Is(err, ErrReqRateLimited) {
+		t.Errorf("authenticated REQ over the IP group budget: got %v, want %v", err, ErrReqRateLimited)
+	}
+
+	if got := obs.reqRateLimitedCount.Load(); got != 3 {
+		t.Errorf("expected 3 rate-limited REQs, got %d", got)
+	}
+	if got := obs.tooManySubscriptionsCount.Load(); got != 1 {
+		t.Errorf("expected 1 REQ over the open subscriptions, got %d", got)
+	}
+}
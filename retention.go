@@ -0,0 +1,228 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pruneBatchSize is the number of events examined per query while pruning.
+const pruneBatchSize = 500
+
+// pruneProgressEvents is how many deleted events a long prune logs its progress after.
+const pruneProgressEvents = 10000
+
+// errTrimCutoff stops the scan looking for the newest event trimmed from a store over its size cap.
+var errTrimCutoff = errors.New("trim cutoff found")
+
+// Retention enforces a relay's storage settings: events older than the retention
+// of their kind are pruned periodically, as are the oldest events of a store over
+// MaxBytes, and no new events are stored once the relay holds MaxEvents.
+// Exempt kinds (profiles, follow lists, ...) are never pruned, unless KindMaxAge
+// gives them a retention.
+type Retention struct {
+	db    EventStore
+	count atomic.Int64 // approximate number of stored events
+
+	MaxAge     time.Duration         // Events older than this are pruned by the prune job (0 keeps events forever)
+	KindMaxAge map[int]time.Duration // Retention of some kinds instead of MaxAge (0 keeps them forever)
+	MaxBytes   int64                 // Size of the store on disk beyond which its oldest events are pruned (0 means no cap)
+	Size       func() (int64, error) // Bytes the store takes on disk, required with MaxBytes
+	MaxEvents  int64                 // Maximum number of stored events (0 means no quota)
+	Ledger     *StorageLedger        // Accounts for the bytes stored per tier, nil to skip accounting
+}
+
+func NewRetention(ctx context.Context, db EventStore, maxAge time.Duration, maxEvents int64) *Retention {
+	r := &Retention{
+		db:        db,
+		MaxAge:    maxAge,
+		MaxEvents: maxEvents,
+	}
+
+	if maxEvents > 0 {
+		count, err := db.CountEvents(ctx, nostr.Filter{})
+		if err != nil {
+			relayLog.Error("failed to count stored events", "error", err)
+		}
+		r.count.Store(count)
+	}
+	return r
+}
+
+// Full reports whether the store has reached its event quota.
+func (r *Retention) Full() bool {
+	return r.MaxEvents > 0 && r.count.Load() >= r.MaxEvents
+}
+
+// Stored records that an event was added to the store.
+func (r *Retention) Stored() {
+	r.count.Add(1)
+}
+
+// Deleted records that the event was removed from the store, other than by pruning.
+func (r *Retention) Deleted(e *nostr.Event) {
+	r.count.Add(-1)
+	r.Ledger.Debit(e)
+}
+
+// Prune deletes stored events older than the retention of their kind, then the oldest
+// events while the store is over MaxBytes, and returns how many were deleted.
+func (r *Retention) Prune(ctx context.Context) int {
+	now := time.Now()
+	deleted := 0
+	for _, kind := range slices.Sorted(maps.Keys(r.KindMaxAge)) {
+		if maxAge := r.KindMaxAge[kind]; maxAge > 0 {
+			until := nostr.Timestamp(now.Add(-maxAge).Unix())
+			deleted += r.pruneBefore(ctx, nostr.Filter{Kinds: []int{kind}}, until, func(int) bool { return false }, 0)
+		}
+	}
+
+	if r.MaxAge > 0 {
+		// Kinds with a retention of their own were pruned above
+		skip := func(kind int) bool {
+			_, ok := r.KindMaxAge[kind]
+			return ok || exemptKinds[kind]
+		}
+		deleted += r.pruneBefore(ctx, nostr.Filter{}, nostr.Timestamp(now.Add(-r.MaxAge).Unix()), skip, 0)
+	}
+
+	if r.MaxBytes > 0 && ctx.Err() == nil {
+		deleted += r.trim(ctx)
+	}
+	return deleted
+}
+
+// keptForever reports whether events of the kind are never pruned.
+func (r *Retention) keptForever(kind int) bool {
+	if maxAge, ok := r.KindMaxAge[kind]; ok {
+		return maxAge <= 0
+	}
+	return exemptKinds[kind]
+}
+
+// trim deletes the oldest events that may be pruned while the store is over MaxBytes.
+// Deleting events only frees disk space once the backend reclaims it, so how many
+// to delete is estimated from the average size of an event on disk, rather than
+// by checking the size again.
+func (r *Retention) trim(ctx context.Context) int {
+	size, err := r.Size()
+	if err != nil {
+		relayLog.Error("failed to get the store size", "error", err)
+		return 0
+	}
+	if size <= r.MaxBytes {
+		return 0
+	}
+
+	total, err := r.db.CountEvents(ctx, nostr.Filter{})
+	if err != nil {
+		relayLog.Error("failed to count stored events", "error", err)
+		return 0
+	}
+	var kept int64
+	var keptKinds []int
+	for kind := range exemptKinds {
+		if r.keptForever(kind) {
+			keptKinds = append(keptKinds, kind)
+		}
+	}
+	for kind := range r.KindMaxAge {
+		if r.keptForever(kind) && !exemptKinds[kind] {
+			keptKinds = append(keptKinds, kind)
+		}
+	}
+	if len(keptKinds) > 0 {
+		if kept, err = r.db.CountEvents(ctx, nostr.Filter{Kinds: keptKinds}); err != nil {
+			relayLog.Error("failed to count stored events", "error", err)
+			return 0
+		}
+	}
+
+	prunable := total - kept
+	excess := min(int64(math.Ceil(float64(size-r.MaxBytes)/float64(size)*float64(total))), prunable)
+	if excess <= 0 {
+		relayLog.Warn("store over its size cap, but only holds events kept forever", "size", size, "max", r.MaxBytes)
+		return 0
+	}
+
+	// The newest of the events to delete comes after the newest prunable ones to keep
+	until := nostr.Now()
+	if keep := prunable - excess; keep > 0 {
+		visited := int64(0)
+		_, err := scanEvents(ctx, r.db, 0, func(e *nostr.Event) error {
+			if r.keptForever(e.Kind) {
+				return nil
+			}
+			if visited++; visited > keep {
+				until = e.CreatedAt
+				return errTrimCutoff
+			}
+			return nil
+		})
+		if !errors.Is(err, errTrimCutoff) {
+			if err != nil {
+				relayLog.Error("failed to scan events to trim", "error", err)
+			}
+			return 0
+		}
+	}
+
+	relayLog.Info("store over its size cap, pruning its oldest events", "size", size, "max", r.MaxBytes, "events", excess)
+	return r.pruneBefore(ctx, nostr.Filter{}, until, r.keptForever, int(excess))
+}
+
+// pruneBefore deletes the events of the filter created at or before until, newest first,
+// except those of the kinds skipped, and returns how many were deleted. Deletion stops
+// after max events, unless max is 0.
+func (r *Retention) pruneBefore(ctx context.Context, filter nostr.Filter, until nostr.Timestamp, skip func(kind int) bool, max int) int {
+	filter.Limit = pruneBatchSize
+	deleted := 0
+	for {
+		filter.Until = &until
+		events, err := r.db.QueryEvents(ctx, filter)
+		if err != nil {
+			relayLog.Error("failed to query events to prune", "error", err)
+			return deleted
+		}
+
+		seen, removed := 0, 0
+		oldest := until
+		for event := range events {
+			seen++
+			oldest = min(oldest, event.CreatedAt)
+			if skip(event.Kind) || max > 0 && deleted+removed >= max {
+				continue
+			}
+			if err := r.db.DeleteEvent(ctx, event); err != nil {
+				relayLog.Error("failed to prune event", "event", event.ID, "error", err)
+				continue
+			}
+			r.Ledger.Debit(event)
+			removed++
+		}
+
+		deleted += removed
+		r.count.Add(-int64(removed))
+		if deleted/pruneProgressEvents > (deleted-removed)/pruneProgressEvents {
+			relayLog.InfoContext(ctx, "pruning events", "deleted", deleted, "reached", until.Time().UTC())
+		}
+		if seen < pruneBatchSize || max > 0 && deleted >= max || ctx.Err() != nil {
+			return deleted
+		}
+
+		// Only skipped events left at this timestamp, move past them
+		if removed == 0 && oldest == until {
+			oldest--
+		}
+		until = oldest
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRetentionPrune(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	sk := nostr.GeneratePrivateKey()
+
+	old := nostr.Timestamp(time.Now().Add(-48 * time.Hour).Unix())
+	oldNote := signedEvent(t, sk, 1, nil)
+	oldNote.CreatedAt = old
+	oldNote.Sign(sk)
+	oldProfile := signedEvent(t, sk, 0, nil)
+	oldProfile.CreatedAt = old
+	oldProfile.Sign(sk)
+	recent := signedEvent(t, sk, 1, nostr.Tags{{"t", "recent"}})
+
+	for _, e := range []*nostr.Event{oldNote, oldProfile, recent} {
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retention := NewRetention(ctx, db, 24*time.Hour, 0)
+	if deleted := retention.Prune(ctx); deleted != 1 {
+		t.Errorf("expected 1 event to be pruned, got %d", deleted)
+	}
+
+	if getEventByID(ctx, db, oldNote.ID) != nil {
+		t.Error("old note should be pruned")
+	}
+	if getEventByID(ctx, db, oldProfile.ID) == nil {
+		t.Error("exempt kinds should never be pruned")
+	}
+	if getEventByID(ctx, db, recent.ID) == nil {
+		t.Error("recent events should be kept")
+	}
+}
+
+func TestRetentionQuota(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	if err := db.SaveEvent(ctx, signedEvent(t, nostr.GeneratePrivateKey(), 1, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	retention := NewRetention(ctx, db, 0, 2)
+	if retention.Full() {
+		t.Fatal("store with 1 of 2 events should not be full")
+	}
+	retention.Stored()
+	if !retention.Full() {
+		t.Error("store with 2 of 2 events should be full")
+	}
+
+	if NewRetention(ctx, db, 0, 0).Full() {
+		t.Error("store without quota should never be full")
+	}
+}
+
+func TestRetentionKinds(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	sk := nostr.GeneratePrivateKey()
+
+	aged := func(kind int, age time.Duration) *nostr.Event {
+		e := signedEvent(t, sk, kind, nostr.Tags{{"t", age.String()}})
+		e.CreatedAt = nostr.Timestamp(time.Now().Add(-age).Unix())
+		e.Sign(sk)
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	oldNote := aged(nostr.KindTextNote, 100*24*time.Hour)
+	note := aged(nostr.KindTextNote, 40*24*time.Hour)
+	reaction := aged(nostr.KindReaction, 40*24*time.Hour)
+	profile := aged(nostr.KindProfileMetadata, 100*24*time.Hour)
+	followList := aged(nostr.KindFollowList, 100*24*time.Hour)
+
+	retention := NewRetention(ctx, db, 30*24*time.Hour, 0)
+	retention.KindMaxAge = map[int]time.Duration{
+		nostr.KindTextNote:   90 * 24 * time.Hour,
+		nostr.KindFollowList: 60 * 24 * time.Hour,
+		nostr.KindReaction:   0,
+	}
+	if deleted := retention.Prune(ctx); deleted != 2 {
+		t.Errorf("expected 2 events to be pruned, got %d", deleted)
+	}
+
+	for _, e := range []*nostr.Event{oldNote, followList} {
+		if getEventByID(ctx, db, e.ID) != nil {
+			t.Errorf("kind %d event should be pruned after the retention of its kind", e.Kind)
+		}
+	}
+	for _, e := range []*nostr.Event{note, reaction, profile} {
+		if getEventByID(ctx, db, e.ID) == nil {
+			t.Errorf("kind %d event should be kept", e.Kind)
+		}
+	}
+}
+
+func TestRetentionTrim(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	sk := nostr.GeneratePrivateKey()
+
+	var notes []*nostr.Event
+	for i := range 10 {
+		e := signedEvent(t, sk, nostr.KindTextNote, nil)
+		e.CreatedAt -= nostr.Timestamp(10 - i)
+		e.Sign(sk)
+		notes = append(notes, e)
+	}
+	profile := signedEvent(t, sk, nostr.KindProfileMetadata, nil)
+	profile.CreatedAt -= 20
+	profile.Sign(sk)
+	for _, e := range append([]*nostr.Event{profile}, notes...) {
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 11 events taking 1100 bytes: 4 must go to fit in 700
+	retention := NewRetention(ctx, db, 0, 0)
+	retention.MaxBytes = 700
+	retention.Size = func() (int64, error) { return 1100, nil }
+	if deleted := retention.Prune(ctx); deleted != 4 {
+		t.Fatalf("expected 4 events to be pruned, got %d", deleted)
+	}
+
+	for i, e := range notes {
+		if kept := getEventByID(ctx, db, e.ID) != nil; kept != (i >= 4) {
+			t.Errorf("note %d: kept = %v, expected the oldest notes to be pruned", i, kept)
+		}
+	}
+	if getEventByID(ctx, db, profile.ID) == nil {
+		t.Error("exempt kinds should never be trimmed")
+	}
+
+	retention.Size = func() (int64, error) { return 600, nil }
+	if deleted := retention.Prune(ctx); deleted != 0 {
+		t.Errorf("store under its cap should not be trimmed, got %d", deleted)
+	}
+}
+
+func TestParseRetentionKinds(t *testing.T) {
+	kinds, err := parseIntPairs([]string{"0:0", "1:90"}, "kind:days")
+	if err != nil || len(kinds) != 2 || kinds[0] != 0 || kinds[1] != 90 {
+		t.Errorf("unexpected kinds %v, %v", kinds, err)
+	}
+	for _, item := range []string{"1", "1:-5", "note:90", "-1:3"} {
+		if _, err := parseIntPairs([]string{item}, "kind:days"); err == nil {
+			t.Errorf("%q should be rejected", item)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the hex SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Client is a minimal client of the S3 API (AWS, MinIO, R2, ...) for storing snapshots,
+// using path-style URLs and AWS Signature Version 4.
+type S3Client struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+	clock  func() time.Time
+}
+
+// NewS3Client returns a client of the bucket at the endpoint, signing requests for the region.
+func NewS3Client(endpoint, bucket, region, accessKey, secretKey string) *S3Client {
+	return &S3Client{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		client:    &http.Client{Timeout: time.Hour},
+		clock:     time.Now,
+	}
+}
+
+// Put uploads the body, of the size and hex SHA-256, under the key.
+// S3 rejects the upload if the body doesn't match the hash.
+func (s *S3Client) Put(ctx context.Context, key string, body io.Reader, size int64, sha256Hex string) error {
+	req, err := s.request(ctx, http.MethodPut, key, nil, body, sha256Hex)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = s.do(req)
+	return err
+}
+
+// Delete removes the object with the key.
+func (s *S3Client) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil, nil, emptySHA256)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req)
+	return err
+}
+
+// List returns the keys of the objects starting with the prefix, in lexical order.
+func (s *S3Client) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", query, nil, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		body, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode S3 listing: %w", err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			slices.Sort(keys)
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do sends the request and returns the response body, or an error for non-2xx responses.
+func (s *S3Client) do(req *http.Request) ([]byte, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// request builds a request signed with AWS Signature Version 4.
+func (s *S3Client) request(ctx context.Context, method, key string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	path := "/" + s3Escape(s.Bucket)
+	if key != "" {
+		path += "/" + s3Escape(key)
+	}
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, s.Endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawPath, req.URL.RawQuery = path, rawQuery
+
+	now := s.clock().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		method,
+		path,
+		rawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape URI-encodes the key as S3 expects, keeping slashes.
+func s3Escape(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
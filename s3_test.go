@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory bucket serving PUT, DELETE and ListObjectsV2, one key per page.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	auth    []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != r.Header.Get("x-amz-content-sha256") {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		if len(keys) > 0 {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", keys[0])
+		}
+		if len(keys) > 1 {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[0])
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+func TestS3Client(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewS3Client(server.URL, "bucket", "eu-west-1", "AKID", "secret")
+	client.clock = func() time.Time { return time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	for _, key := range []string{"wotrlay/a", "wotrlay/b", "other/c"} {
+		body := []byte("snapshot " + key)
+		sum := sha256.Sum256(body)
+		if err := client.Put(ctx, key, strings.NewReader(string(body)), int64(len(body)), hex.EncodeToString(sum[:])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(fake.auth[0], "AWS4-HMAC-SHA256 Credential=AKID/20250101/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("unexpected Authorization header %q", fake.auth[0])
+	}
+
+	// A body not matching its hash is rejected
+	if err := client.Put(ctx, "wotrlay/d", strings.NewReader("tampered"), 8, emptySHA256); err == nil {
+		t.Errorf("expected a hash mismatch to fail the upload")
+	}
+
+	keys, err := client.List(ctx, "wotrlay/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"wotrlay/a", "wotrlay/b"}) {
+		t.Errorf("expected both pages of keys, got %v", keys)
+	}
+
+	if err := client.Delete(ctx, "wotrlay/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["wotrlay/a"]; ok {
+		t.Errorf("expected the object to be deleted")
+	}
+}
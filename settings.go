@@ -0,0 +1,298 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrUnknownSetting = errors.New("unknown or read-only setting")
+	ErrInvalidSetting = errors.New("invalid setting value")
+)
+
+// setting is a configuration variable that can be changed at runtime in the config editor.
+// Only settings read from the Config on every event are editable, as the others are
+// baked into the dependencies of the relay at startup.
+type setting struct {
+	Key         string
+	Group       string // "thresholds", "rates", "policies" or "lists"
+	Type        string // "float", "int", "bool", "kinds", "list" or "string"
+	Description string
+	apply       func(dst *Config, src Config)
+}
+
+var settings = []setting{
+	{"MID_THRESHOLD", "thresholds", "float", "trust score above which all kinds are allowed",
+		func(dst *Config, src Config) { dst.MidThreshold = src.MidThreshold }},
+	{"HIGH_THRESHOLD", "thresholds", "float", "trust score of the high tier (empty means no high tier)",
+		func(dst *Config, src Config) { dst.HighThreshold = src.HighThreshold }},
+	{"RATE_MULTIPLIER", "rates", "float", "factor applied to the daily rates of all tiers",
+		func(dst *Config, src Config) { dst.RateMultiplier = src.RateMultiplier }},
+	{"LOW_TIER_KINDS", "policies", "kinds", "kinds accepted from the low tier",
+		func(dst *Config, src Config) { dst.LowTierKinds = src.LowTierKinds }},
+	{"MID_TIER_KINDS", "policies", "kinds", "kinds accepted from the mid tier",
+		func(dst *Config, src Config) { dst.MidTierKinds = src.MidTierKinds }},
+	{"HIGH_TIER_KINDS", "policies", "kinds", "kinds accepted from the high tier",
+		func(dst *Config, src Config) { dst.HighTierKinds = src.HighTierKinds }},
+	{"HELLTHREAD_THRESHOLD", "policies", "int", "max p-tagged participants below the mid tier (0 disables)",
+		func(dst *Config, src Config) { dst.HellthreadThreshold = src.HellthreadThreshold }},
+	{"ENTITY_SPAM_THRESHOLD", "policies", "int", "max nostr entity references below the mid tier (0 disables)",
+		func(dst *Config, src Config) { dst.EntitySpamThreshold = src.EntitySpamThreshold }},
+	{"DUPLICATE_CONTENT_THRESHOLD", "policies", "int", "max low-trust pubkeys publishing identical content (0 disables)",
+		func(dst *Config, src Config) { dst.DuplicateContentThreshold = src.DuplicateContentThreshold }},
+	{"CONTENT_QUALITY_ACTION", "policies", "string", "off, cost or reject",
+		func(dst *Config, src Config) { dst.ContentQualityAction = src.ContentQualityAction }},
+	{"URL_REPLY_EXEMPTION", "policies", "bool", "replies to high-trust events may contain URLs",
+		func(dst *Config, src Config) { dst.URLReplyExemption = src.URLReplyExemption }},
+	{"REPOST_POLICY_ENABLED", "policies", "bool", "enforce target checks, dedup and daily caps on reposts",
+		func(dst *Config, src Config) { dst.RepostPolicyEnabled = src.RepostPolicyEnabled }},
+	{"COMMUNITY_MODERATION_ENABLED", "policies", "bool", "only serve NIP-72 community posts approved by moderators",
+		func(dst *Config, src Config) { dst.CommunityModerationEnabled = src.CommunityModerationEnabled }},
+	{"BANNED_PUBKEYS", "lists", "list", "pubkeys whose events are rejected outright",
+		func(dst *Config, src Config) { dst.BannedPubkeys = src.BannedPubkeys }},
+	{"ALLOWED_PUBKEYS", "lists", "list", "pubkeys treated as high-trust without asking the rank provider",
+		func(dst *Config, src Config) { dst.AllowedPubkeys = src.AllowedPubkeys }},
+	{"FILE_ALLOWED_HOSTS", "lists", "list", "media hosts file metadata may point to (empty means any host)",
+		func(dst *Config, src Config) { dst.FileAllowedHosts = src.FileAllowedHosts }},
+	{"RANK_OVERRIDES", "lists", "list", "pubkey:rank pairs used instead of the rank provider's",
+		func(dst *Config, src Config) { dst.RankOverrides = src.RankOverrides }},
+}
+
+// findSetting returns the editable setting with the key, or nil.
+func findSetting(key string) *setting {
+	for i := range settings {
+		if settings[i].Key == key {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// validate returns an error if the value can't be parsed as the setting's type.
+// Empty values are valid, and reset the setting to its default.
+func (s *setting) validate(value string) error {
+	if strings.ContainsAny(value, "'\n") {
+		return fmt.Errorf("%w: %s must not contain quotes or newlines", ErrInvalidSetting, s.Key)
+	}
+	if value == "" {
+		return nil
+	}
+
+	var err error
+	switch s.Type {
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "int":
+		_, err = strconv.Atoi(value)
+	case "bool":
+		switch strings.ToLower(value) {
+		case "true", "1", "yes", "on", "false", "0", "no", "off":
+		default:
+			err = errors.New("not a boolean")
+		}
+	case "kinds":
+		_, err = ParseKindSet(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidSetting, s.Key, err)
+	}
+	return nil
+}
+
+// SettingValue is a setting as shown in the config editor.
+type SettingValue struct {
+	Key         string `json:"key"`
+	Group       string `json:"group"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Value       string `json:"value"` // empty means default
+}
+
+// Settings holds the live configuration of a relay. Changes made in the config editor
+// are validated, persisted and then applied to the events that follow.
+type Settings struct {
+	mu        sync.Mutex
+	current   atomic.Pointer[Config]
+	overrides map[string]string
+	getenv    func(string) string
+	persist   func(changes map[string]string) error
+}
+
+// NewSettings returns the live configuration of a relay started with cfg, parsed
+// from the overrides layered over getenv. persist, if not nil, saves the changes.
+func NewSettings(cfg Config, overrides map[string]string, getenv func(string) string, persist func(map[string]string) error) *Settings {
+	s := &Settings{
+		overrides: maps.Clone(overrides),
+		getenv:    getenv,
+		persist:   persist,
+	}
+	if s.overrides == nil {
+		s.overrides = make(map[string]string)
+	}
+	s.current.Store(&cfg)
+	return s
+}
+
+// Config returns the current configuration.
+func (s *Settings) Config() Config {
+	return *s.current.Load()
+}
+
+// live returns the current configuration, which Update replaces rather than changes,
+// so that callers can tell that it changed by its address.
+func (s *Settings) live() *Config {
+	return s.current.Load()
+}
+
+// Values returns the editable settings with their current value.
+func (s *Settings) Values() []SettingValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	getenv := tenantEnv(s.overrides, s.getenv)
+	values := make([]SettingValue, len(settings))
+	for i, st := range settings {
+		values[i] = SettingValue{Key: st.Key, Group: st.Group, Type: st.Type, Description: st.Description, Value: getenv(st.Key)}
+	}
+	return values
+}
+
+// Update validates, persists and applies the changes, and returns the new configuration.
+// Nothing is applied if any change is invalid or can't be persisted.
+func (s *Settings) Update(changes map[string]string) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	overrides := maps.Clone(s.overrides)
+	values := make(map[string]string, len(changes))
+	for key, value := range changes {
+		st := findSetting(key)
+		if st == nil {
+			return Config{}, fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+		}
+		value = strings.TrimSpace(value)
+		if err := st.validate(value); err != nil {
+			return Config{}, err
+		}
+		values[key], overrides[key] = value, value
+	}
+
+	parsed, err := buildConfig(tenantEnv(overrides, s.getenv))
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: %v", ErrInvalidSetting, err)
+	}
+	cfg := s.Config()
+	for _, st := range settings {
+		st.apply(&cfg, parsed)
+	}
+
+	if s.persist != nil {
+		if err := s.persist(values); err != nil {
+			return Config{}, fmt.Errorf("failed to persist settings: %w", err)
+		}
+	}
+
+	s.overrides = overrides
+	s.current.Store(&cfg)
+	return cfg, nil
+}
+
+// persistEnvFile writes the changes to a .env file, replacing the lines of the
+// variables already set in it and appending the others.
+func persistEnvFile(path string, changes map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	written := make(map[string]bool, len(changes))
+	for i, line := range lines {
+		key, _, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "export "), "=")
+		key = strings.TrimSpace(key)
+		if value, changed := changes[key]; ok && changed {
+			lines[i] = key + "='" + value + "'"
+			written[key] = true
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(changes)) {
+		if !written[key] {
+			lines = append(lines, key+"='"+changes[key]+"'")
+		}
+	}
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// tenantsFileMu serializes the changes of virtual relays to the TENANTS_FILE.
+var tenantsFileMu sync.Mutex
+
+// persistTenantEnv writes the changes to the env of a virtual relay in the TENANTS_FILE,
+// leaving the rest of the file as it is.
+func persistTenantEnv(path, name string, changes map[string]string) error {
+	tenantsFileMu.Lock()
+	defer tenantsFileMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var specs []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return fmt.Errorf("failed to decode tenants file: %w", err)
+	}
+
+	for _, spec := range specs {
+		var specName string
+		if err := json.Unmarshal(spec["name"], &specName); err != nil || specName != name {
+			continue
+		}
+
+		env := make(map[string]string)
+		if raw, ok := spec["env"]; ok {
+			if err := json.Unmarshal(raw, &env); err != nil {
+				return fmt.Errorf("failed to decode env of tenant %q: %w", name, err)
+			}
+		}
+		maps.Copy(env, changes)
+		if spec["env"], err = json.Marshal(env); err != nil {
+			return err
+		}
+
+		if data, err = json.MarshalIndent(specs, "", "  "); err != nil {
+			return err
+		}
+		return writeFileAtomic(path, append(data, '\n'))
+	}
+	return fmt.Errorf("tenant %q not found in %s", name, path)
+}
+
+// writeFileAtomic replaces the file with data, keeping its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingsUpdate(t *testing.T) {
+	env := map[string]string{"MID_THRESHOLD": "0.5", "STORE_PATH": "/data"}
+	getenv := func(key string) string { return env[key] }
+	cfg := parseConfig(getenv)
+	cfg.RelatrSecretKey = "generated"
+
+	var persisted map[string]string
+	s := NewSettings(cfg, nil, getenv, func(changes map[string]string) error {
+		persisted = changes
+		return nil
+	})
+
+	updated, err := s.Update(map[string]string{"MID_THRESHOLD": " 0.7 ", "BANNED_PUBKEYS": "a,b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.MidThreshold != 0.7 || len(updated.BannedPubkeys) != 2 || s.Config().MidThreshold != 0.7 {
+		t.Errorf("changes not applied: %+v", updated)
+	}
+	if updated.RelatrSecretKey != "generated" || updated.StorePath != "/data" {
+		t.Error("settings that can't be edited should be kept")
+	}
+	if persisted["MID_THRESHOLD"] != "0.7" || persisted["BANNED_PUBKEYS"] != "a,b" {
+		t.Errorf("unexpected persisted changes %v", persisted)
+	}
+
+	invalid := []struct {
+		changes map[string]string
+		err     error
+	}{
+		{map[string]string{"STORE_PATH": "/tmp"}, ErrUnknownSetting},
+		{map[string]string{"MID_THRESHOLD": "high"}, ErrInvalidSetting},
+		{map[string]string{"MID_THRESHOLD": "2"}, ErrInvalidSetting},
+		{map[string]string{"LOW_TIER_KINDS": "1,x"}, ErrInvalidSetting},
+		{map[string]string{"BANNED_PUBKEYS": "a'b"}, ErrInvalidSetting},
+	}
+	for _, test := range invalid {
+		if _, err := s.Update(test.changes); !errors.Is(err, test.err) {
+			t.Errorf("%v: got error %v, want %v", test.changes, err, test.err)
+		}
+	}
+	if s.Config().MidThreshold != 0.7 {
+		t.Error("invalid changes should not be applied")
+	}
+
+	// Empty values reset to the default
+	if updated, _ := s.Update(map[string]string{"MID_THRESHOLD": ""}); updated.MidThreshold != 0.5 {
+		t.Errorf("got %v, want the environment's 0.5", updated.MidThreshold)
+	}
+}
+
+func TestPersistEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	original := "# Trust threshold\nMID_THRESHOLD=0.5\n# BANNED_PUBKEYS=x\nDEBUG=1\n"
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := persistEnvFile(path, map[string]string{"MID_THRESHOLD": "0.7", "BANNED_PUBKEYS": "a,b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "# Trust threshold\nMID_THRESHOLD='0.7'\n# BANNED_PUBKEYS=x\nDEBUG=1\nBANNED_PUBKEYS='a,b'\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("permissions changed to %v", info.Mode().Perm())
+	}
+}
+
+func TestPersistTenantEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	original := `[{"name":"cats","path":"/cats","env":{"RELAY_NAME":"Cats"}},{"name":"dogs","hosts":["dogs.example.com"]}]`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := persistTenantEnv(path, "dogs", map[string]string{"MID_THRESHOLD": "0.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := persistTenantEnv(path, "birds", map[string]string{"MID_THRESHOLD": "0.3"}); err == nil {
+		t.Error("expected an error for an unknown tenant")
+	}
+
+	specs, err := loadTenantSpecs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if specs[0].Env["RELAY_NAME"] != "Cats" || specs[0].Env["MID_THRESHOLD"] != "" {
+		t.Errorf("other tenants should be left as they are: %+v", specs[0])
+	}
+	if specs[1].Env["MID_THRESHOLD"] != "0.3" || specs[1].Hosts[0] != "dogs.example.com" {
+		t.Errorf("unexpected tenant %+v", specs[1])
+	}
+
+	var raw []map[string]any
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &raw)
+	if _, ok := raw[1]["path"]; ok {
+		t.Error("fields not in the file should not be added")
+	}
+}
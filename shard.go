@@ -0,0 +1,109 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// shardCount is how many shards the rank cache and the limiter's buckets are split
+// into, each with its own lock, so that the events of different pubkeys rarely wait
+// on the same lock.
+const shardCount = 64
+
+// minShardSize is the fewest entries a shard of the rank cache holds: smaller caches
+// get fewer shards, down to one, so that eviction stays close to least recently used.
+const minShardSize = 1024
+
+// shardOf returns which of n shards the key belongs to, by its FNV-1a hash.
+func shardOf(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// shardedLRU is an LRU cache split into shards by key. Recency is tracked per shard,
+// so a full shard evicts its own least recently used entry, which is close to the
+// cache's as long as shards hold many entries.
+type shardedLRU[V any] struct {
+	shards []*lru.Cache[string, V]
+}
+
+// newShardedLRU returns a cache of size entries in total, calling onEvict (if not nil)
+// with the entries evicted to make room.
+func newShardedLRU[V any](size int, onEvict func(string, V)) (*shardedLRU[V], error) {
+	n := min(shardCount, max(1, size/minShardSize))
+	c := &shardedLRU[V]{shards: make([]*lru.Cache[string, V], n)}
+	for i := range n {
+		shardSize := size / n
+		if i < size%n {
+			shardSize++
+		}
+		shard, err := lru.NewWithEvict(shardSize, onEvict)
+		if err != nil {
+			return nil, err
+		}
+		c.shards[i] = shard
+	}
+	return c, nil
+}
+
+func (c *shardedLRU[V]) shard(key string) *lru.Cache[string, V] {
+	return c.shards[shardOf(key, len(c.shards))]
+}
+
+// Get returns the value of the key, marking it as recently used.
+func (c *shardedLRU[V]) Get(key string) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+// Peek returns the value of the key, without marking it as recently used.
+func (c *shardedLRU[V]) Peek(key string) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+// Contains returns whether the key is cached, without marking it as recently used.
+func (c *shardedLRU[V]) Contains(key string) bool {
+	return c.shard(key).Contains(key)
+}
+
+// Add caches the value of the key, evicting the least recently used entry of its shard if full.
+func (c *shardedLRU[V]) Add(key string, value V) {
+	c.shard(key).Add(key, value)
+}
+
+// Remove removes the key from the cache.
+func (c *shardedLRU[V]) Remove(key string) {
+	c.shard(key).Remove(key)
+}
+
+// Len returns how many entries are cached.
+func (c *shardedLRU[V]) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Keys returns the cached keys, shard by shard.
+func (c *shardedLRU[V]) Keys() []string {
+	keys := make([]string, 0, c.Len())
+	for _, shard := range c.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Values returns the cached values, shard by shard.
+func (c *shardedLRU[V]) Values() []V {
+	values := make([]V, 0, c.Len())
+	for _, shard := range c.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
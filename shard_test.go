@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	counts := make([]int, shardCount)
+	for i := range 64000 {
+		counts[shardOf(fmt.Sprintf("pubkey-%d", i), shardCount)]++
+	}
+	for shard, n := range counts {
+		if n < 700 || n > 1300 {
+			t.Errorf("shard %d holds %d keys out of 64000, expected about 1000", shard, n)
+		}
+	}
+	if shardOf("alice", shardCount) != shardOf("alice", shardCount) {
+		t.Error("a key must always map to the same shard")
+	}
+}
+
+func TestShardedLRU(t *testing.T) {
+	for _, tc := range []struct{ size, shards int }{
+		{1, 1},
+		{minShardSize, 1},
+		{10 * minShardSize, 10},
+		{100000, shardCount},
+	} {
+		evicted := 0
+		c, err := newShardedLRU(tc.size, func(string, int) { evicted++ })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.shards) != tc.shards {
+			t.Errorf("size %d: expected %d shards, got %d", tc.size, tc.shards, len(c.shards))
+		}
+
+		// The shards hold the size of the cache, and no more
+		for i := range 2 * tc.size {
+			c.Add(fmt.Sprintf("pubkey-%d", i), i)
+		}
+		if c.Len() != tc.size || evicted != tc.size || len(c.Keys()) != tc.size || len(c.Values()) != tc.size {
+			t.Errorf("size %d: expected %d entries and as many evicted, got %d and %d", tc.size, tc.size, c.Len(), evicted)
+		}
+		last := fmt.Sprintf("pubkey-%d", 2*tc.size-1)
+		if value, ok := c.Get(last); !ok || value != 2*tc.size-1 {
+			t.Errorf("size %d: expected the last entry to be cached, got %d (%v)", tc.size, value, ok)
+		}
+		c.Remove(last)
+		if c.Contains(last) {
+			t.Errorf("size %d: expected the entry to be removed", tc.size)
+		}
+	}
+}
+
+// BenchmarkShardedLRU measures concurrent lookups in a cache of one shard, as the rank
+// cache was before sharding, and of shardCount shards.
+func BenchmarkShardedLRU(b *testing.B) {
+	pubkeys := make([]string, 1000)
+	for i := range pubkeys {
+		pubkeys[i] = fmt.Sprintf("%064x", i)
+	}
+	for _, size := range []int{minShardSize, shardCount * minShardSize} {
+		c, err := newShardedLRU[TimeRank](size, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, pubkey := range pubkeys {
+			c.Add(pubkey, TimeRank{Rank: 0.5})
+		}
+		b.Run(fmt.Sprintf("shards=%d", len(c.shards)), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.Get(pubkeys[i%len(pubkeys)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+var ErrSmoketestFailed = errors.New("smoke test failed")
+
+// smoketest implements `wotrlay smoketest [flags] <relay-url>`, and returns the process exit code.
+func smoketest(args []string) int {
+	flags := flag.NewFlagSet("smoketest", flag.ContinueOnError)
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each step")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay smoketest [flags] <relay-url>")
+		fmt.Fprintln(flags.Output(), "\nPublishes a signed test event to the relay, queries it back and checks its NIP-11 document.")
+		fmt.Fprintln(flags.Output(), "The event is signed with SMOKETEST_SECRET_KEY, or a fresh key if unset.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := runSmoketest(context.Background(), flags.Arg(0), os.Getenv("SMOKETEST_SECRET_KEY"), *timeout, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runSmoketest checks the relay at url as a client: it fetches its NIP-11 document, publishes an
+// event signed with secretKey (a fresh key if empty) and queries it back, reporting each step to out.
+func runSmoketest(ctx context.Context, url, secretKey string, timeout time.Duration, out io.Writer) error {
+	step := func(name string, fn func(ctx context.Context) (string, error)) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		start := time.Now()
+		detail, err := fn(ctx)
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %s: %v\n", name, err)
+			return fmt.Errorf("%w: %s: %v", ErrSmoketestFailed, name, err)
+		}
+		fmt.Fprintf(out, "ok   %s (%s) %s\n", name, time.Since(start).Round(time.Millisecond), detail)
+		return nil
+	}
+
+	if secretKey == "" {
+		secretKey = nostr.GeneratePrivateKey()
+	}
+	pubkey, err := nostr.GetPublicKey(secretKey)
+	if err != nil {
+		return fmt.Errorf("%w: invalid SMOKETEST_SECRET_KEY: %v", ErrSmoketestFailed, err)
+	}
+
+	err = step("nip11", func(ctx context.Context) (string, error) {
+		info, err := nip11.Fetch(ctx, url)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("name=%q software=%q version=%q", info.Name, info.Software, info.Version), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// A bare connection: the goroutines of nostr.Relay race with its Close, which a
+	// short-lived client hits every time
+	var conn *nostr.Connection
+	err = step("connect", func(ctx context.Context) (string, error) {
+		conn, err = nostr.NewConnection(ctx, nostr.NormalizeURL(url), nil, nil)
+		return url, err
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	event := nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: nostr.Now(),
+		Kind:      1,
+		Content:   fmt.Sprintf("wotrlay smoke test %d", time.Now().UnixNano()),
+	}
+	if err := event.Sign(secretKey); err != nil {
+		return fmt.Errorf("%w: failed to sign event: %v", ErrSmoketestFailed, err)
+	}
+
+	err = step("publish", func(ctx context.Context) (string, error) {
+		if err := writeEnvelope(ctx, conn, &nostr.EventEnvelope{Event: event}); err != nil {
+			return "", err
+		}
+		for {
+			env, err := readEnvelope(ctx, conn)
+			if err != nil {
+				return "", err
+			}
+			if ok, isOK := env.(*nostr.OKEnvelope); isOK && ok.EventID == event.ID {
+				if !ok.OK {
+					return "", fmt.Errorf("rejected: %s", ok.Reason)
+				}
+				return "id=" + event.ID, nil
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return step("query", func(ctx context.Context) (string, error) {
+		const subID = "smoketest"
+		if err := writeEnvelope(ctx, conn, &nostr.ReqEnvelope{SubscriptionID: subID, Filters: nostr.Filters{{IDs: []string{event.ID}}}}); err != nil {
+			return "", err
+		}
+		found := false
+		for {
+			env, err := readEnvelope(ctx, conn)
+			if err != nil {
+				return "", err
+			}
+			switch env := env.(type) {
+			case *nostr.EventEnvelope:
+				if env.SubscriptionID != nil && *env.SubscriptionID == subID && env.ID == event.ID {
+					found = true
+				}
+			case *nostr.ClosedEnvelope:
+				if env.SubscriptionID == subID {
+					return "", fmt.Errorf("subscription closed: %s", env.Reason)
+				}
+			case *nostr.EOSEEnvelope:
+				if string(*env) != subID {
+					continue
+				}
+				if !found {
+					return "", fmt.Errorf("published event %s not returned", event.ID)
+				}
+				return "id=" + event.ID, nil
+			}
+		}
+	})
+}
+
+// writeEnvelope sends the message over the connection.
+func writeEnvelope(ctx context.Context, conn *nostr.Connection, env nostr.Envelope) error {
+	data, err := env.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(ctx, data)
+}
+
+// readEnvelope returns the next message of the connection, skipping those it can't parse.
+func readEnvelope(ctx context.Context, conn *nostr.Connection) (nostr.Envelope, error) {
+	for {
+		var buf bytes.Buffer
+		if err := conn.ReadMessage(ctx, &buf); err != nil {
+			return nil, err
+		}
+		if env := nostr.ParseMessage(buf.String()); env != nil {
+			return env, nil
+		}
+	}
+}
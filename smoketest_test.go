@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/pippellia-btc/rely"
+)
+
+// smoketestRelay serves a relay that stores events in memory, and rejects them if reject is set.
+func smoketestRelay(t *testing.T, ctx context.Context, reject bool) string {
+	var mu sync.Mutex
+	var events []nostr.Event
+
+	relay := rely.NewRelay(rely.WithInfo(nip11.RelayInformationDocument{Name: "test", Software: "wotrlay"}))
+	relay.On.Event = func(_ rely.Client, e *nostr.Event) error {
+		if reject {
+			return ErrRateLimited
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, *e)
+		return nil
+	}
+	relay.On.Req = func(_ context.Context, _ rely.Client, filters nostr.Filters) ([]nostr.Event, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var matched []nostr.Event
+		for _, e := range events {
+			if filters.Match(&e) {
+				matched = append(matched, e)
+			}
+		}
+		return matched, nil
+	}
+	relay.Start(ctx)
+
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestRunSmoketest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out bytes.Buffer
+	if err := runSmoketest(ctx, smoketestRelay(t, ctx, false), "", 5*time.Second, &out); err != nil {
+		t.Fatalf("smoke test failed: %v\n%s", err, out.String())
+	}
+	for _, step := range []string{"nip11", "connect", "publish", "query"} {
+		if !strings.Contains(out.String(), "ok   "+step) {
+			t.Errorf("step %s not reported ok:\n%s", step, out.String())
+		}
+	}
+}
+
+func TestRunSmoketestRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out bytes.Buffer
+	err := runSmoketest(ctx, smoketestRelay(t, ctx, true), "", 5*time.Second, &out)
+	if !errors.Is(err, ErrSmoketestFailed) {
+		t.Fatalf("got %v, want ErrSmoketestFailed", err)
+	}
+	if !strings.Contains(out.String(), "FAIL publish") {
+		t.Errorf("publish failure not reported:\n%s", out.String())
+	}
+}
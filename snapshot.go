@@ -0,0 +1,371 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+)
+
+// snapshotSuffix ends the name of every snapshot file.
+const snapshotSuffix = ".badger.bak"
+
+// Schedule is a cron schedule: minute, hour, day of month, month and day of week.
+type Schedule struct {
+	minute, hour, dom, month, dow [64]bool
+	domAny, dowAny                bool
+}
+
+// cronFields are the bounds of each field of a cron expression.
+var cronFields = [5]struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+// ParseSchedule parses a five-field cron expression, e.g. "0 3 * * *" for every day at 03:00.
+// Fields can be *, numbers, ranges (1-5), lists (1,15) and steps (*/6, 0-30/10).
+// Day of week is 0-7, where both 0 and 7 are Sunday.
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron schedule %q must have 5 fields", expr)
+	}
+
+	s := &Schedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	sets := [5]*[64]bool{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		bounds := cronFields[i]
+		for _, part := range strings.Split(field, ",") {
+			rng, stepStr, hasStep := strings.Cut(part, "/")
+			step := 1
+			if hasStep {
+				var err error
+				if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+					return nil, fmt.Errorf("invalid step %q in the %s field", stepStr, bounds.name)
+				}
+			}
+
+			lo, hi := bounds.min, bounds.max
+			if rng != "*" {
+				from, to, isRange := strings.Cut(rng, "-")
+				var err1, err2 error
+				lo, err1 = strconv.Atoi(from)
+				hi, err2 = lo, nil
+				if isRange {
+					hi, err2 = strconv.Atoi(to)
+				} else if hasStep {
+					hi = bounds.max
+				}
+				if err1 != nil || err2 != nil || lo < bounds.min || hi > bounds.max || lo > hi {
+					return nil, fmt.Errorf("invalid %s %q", bounds.name, rng)
+				}
+			}
+			for v := lo; v <= hi; v += step {
+				sets[i][v] = true
+			}
+		}
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	return s, nil
+}
+
+// matches reports whether the schedule fires at the minute of t.
+func (s *Schedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[t.Month()] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		// As in cron, a restricted day of month and day of week match either
+		return dom || dow
+	}
+}
+
+// Next returns the first time after t at which the schedule fires, or the zero time
+// if it never fires within 5 years (e.g. "0 0 31 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for end := next.AddDate(5, 0, 0); next.Before(end); next = next.Add(time.Minute) {
+		if s.matches(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// snapshotTarget is where snapshots are kept.
+type snapshotTarget interface {
+	// Store saves the verified snapshot file under the name
+	Store(ctx context.Context, name, path string, size int64, sha256Hex string) error
+	// List returns the names of the snapshots starting with the prefix, oldest first
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes the snapshot with the name
+	Delete(ctx context.Context, name string) error
+}
+
+// dirTarget keeps snapshots in a local directory, next to the files with their SHA-256.
+type dirTarget struct {
+	dir string
+}
+
+func (t dirTarget) Store(_ context.Context, name, path string, _ int64, sha256Hex string) error {
+	if err := os.WriteFile(filepath.Join(t.dir, name+".sha256"), []byte(sha256Hex+"  "+name+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(t.dir, name))
+}
+
+func (t dirTarget) List(_ context.Context, prefix string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(t.dir, prefix+"*"+snapshotSuffix))
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	slices.Sort(names)
+	return names, err
+}
+
+func (t dirTarget) Delete(_ context.Context, name string) error {
+	os.Remove(filepath.Join(t.dir, name+".sha256"))
+	return os.Remove(filepath.Join(t.dir, name))
+}
+
+// s3Target uploads snapshots to an S3 bucket, under a key prefix.
+type s3Target struct {
+	client *S3Client
+	prefix string
+}
+
+func (t s3Target) Store(ctx context.Context, name, path string, size int64, sha256Hex string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := t.client.Put(ctx, t.prefix+name, file, size, sha256Hex); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (t s3Target) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := t.client.List(ctx, t.prefix+prefix)
+	var names []string
+	for _, key := range keys {
+		if name := strings.TrimPrefix(key, t.prefix); strings.HasSuffix(name, snapshotSuffix) {
+			names = append(names, name)
+		}
+	}
+	return names, err
+}
+
+func (t s3Target) Delete(ctx context.Context, name string) error {
+	return t.client.Delete(ctx, t.prefix+name)
+}
+
+// Snapshotter takes scheduled snapshots of the relays' event stores. Each snapshot is a
+// Badger backup, verified by restoring it into a scratch database before it is kept.
+// Only the last Keep snapshots of each store are kept.
+type Snapshotter struct {
+	target  snapshotTarget
+	staging string // directory where snapshots are written and verified
+	stores  map[string]*badger.BadgerBackend
+
+	Keep  int
+	Clock func() time.Time // Source of the current time, time.Now if nil
+}
+
+// NewSnapshotter returns the snapshotter configured by cfg, or nil if snapshots are disabled.
+func NewSnapshotter(cfg Config) (*Snapshotter, error) {
+	if cfg.SnapshotSchedule == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.SnapshotDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create SNAPSHOT_DIR: %w", err)
+	}
+
+	var target snapshotTarget = dirTarget{dir: cfg.SnapshotDir}
+	if cfg.SnapshotS3Bucket != "" {
+		client := NewS3Client(cfg.SnapshotS3Endpoint, cfg.SnapshotS3Bucket, cfg.SnapshotS3Region, cfg.SnapshotS3AccessKey, cfg.SnapshotS3SecretKey)
+		target = s3Target{client: client, prefix: cfg.SnapshotS3Prefix}
+	}
+
+	return &Snapshotter{
+		target:  target,
+		staging: cfg.SnapshotDir,
+		stores:  make(map[string]*badger.BadgerBackend),
+		Keep:    cfg.SnapshotKeep,
+	}, nil
+}
+
+func (s *Snapshotter) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// Add schedules snapshots of the event store of the relay.
+func (s *Snapshotter) Add(relay string, db *badger.BadgerBackend) {
+	s.stores[relay] = db
+}
+
+// SnapshotAll takes a snapshot of the event store of every relay, which is the backup job.
+func (s *Snapshotter) SnapshotAll(ctx context.Context) error {
+	var errs []error
+	for _, relay := range slices.Sorted(maps.Keys(s.stores)) {
+		if name, err := s.Snapshot(ctx, relay); err != nil {
+			relayLog.Error("ALERT: snapshot failed", "relay", relay, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+		} else {
+			relayLog.Info("snapshot saved", "relay", relay, "name", name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Snapshot backs up the event store of the relay, verifies and keeps the snapshot,
+// then deletes the snapshots beyond Keep. It returns the name of the snapshot.
+func (s *Snapshotter) Snapshot(ctx context.Context, relay string) (string, error) {
+	db, ok := s.stores[relay]
+	if !ok {
+		return "", fmt.Errorf("unknown relay %q", relay)
+	}
+
+	prefix := relay + "-"
+	name := prefix + s.now().UTC().Format("20060102T150405Z") + snapshotSuffix
+	path := filepath.Join(s.staging, "."+name+".tmp")
+	defer os.Remove(path)
+
+	size, sum, err := writeSnapshot(db, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := verifySnapshot(path, sum); err != nil {
+		return "", fmt.Errorf("snapshot failed verification: %w", err)
+	}
+	if err := s.target.Store(ctx, name, path, size, sum); err != nil {
+		return "", fmt.Errorf("failed to store snapshot: %w", err)
+	}
+
+	// Retention only counts the snapshots of this relay
+	names, err := s.target.List(ctx, prefix)
+	if err != nil {
+		return name, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	names = slices.DeleteFunc(names, func(n string) bool { return !isSnapshotOf(n, relay) })
+	for len(names) > s.Keep {
+		if err := s.target.Delete(ctx, names[0]); err != nil {
+			relayLog.Error("failed to delete old snapshot", "name", names[0], "error", err)
+		}
+		names = names[1:]
+	}
+	return name, nil
+}
+
+// isSnapshotOf reports whether the snapshot name belongs to the relay, and not to
+// another relay whose name starts with the same prefix (e.g. "dev" and "dev-eu").
+func isSnapshotOf(name, relay string) bool {
+	stamp, ok := strings.CutPrefix(strings.TrimSuffix(name, snapshotSuffix), relay+"-")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse("20060102T150405Z", stamp)
+	return err == nil
+}
+
+// writeSnapshot writes a full backup of the store to the file, and returns its size and hex SHA-256.
+func writeSnapshot(db *badger.BadgerBackend, path string) (int64, string, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, hash)}
+	if _, err := db.Backup(counter, 0); err != nil {
+		return 0, "", err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, "", err
+	}
+	return counter.n, hex.EncodeToString(hash.Sum(nil)), file.Close()
+}
+
+// verifySnapshot checks that the file has the SHA-256 and restores it into a scratch
+// database, which fails if the backup is truncated or corrupt.
+func verifySnapshot(path, sha256Hex string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != sha256Hex {
+		return errors.New("checksum mismatch")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	scratch, err := os.MkdirTemp(filepath.Dir(path), ".verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	db, err := badgerdb.Open(badgerdb.DefaultOptions(scratch).WithLogger(nil))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Badger panics on some malformed backups instead of returning an error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed backup: %v", r)
+		}
+	}()
+	return db.Load(file, 16)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
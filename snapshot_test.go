@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2025, 1, 1, 10, 30, 20, 0, time.UTC) // a Wednesday
+
+	for _, tc := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2025, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 9 1,15 * *", time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 1", time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)}, // day of month or day of week
+	} {
+		s, err := ParseSchedule(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if next := s.Next(base); !next.Equal(tc.next) {
+			t.Errorf("%q: expected next run at %v, got %v", tc.expr, tc.next, next)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+
+	never, _ := ParseSchedule("0 0 31 2 *")
+	if next := never.Next(base); !next.IsZero() {
+		t.Errorf("expected February 31st never to fire, got %v", next)
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.SaveEvent(context.Background(), testEvent(1, nostr.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	s, err := NewSnapshotter(Config{SnapshotSchedule: "0 3 * * *", SnapshotDir: dir, SnapshotKeep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)
+	s.Clock = func() time.Time { return now }
+	s.Add("dev", db)
+	s.Add("dev-eu", newTestDB(t))
+
+	var names []string
+	for range 3 {
+		name, err := s.Snapshot(context.Background(), "dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		now = now.Add(24 * time.Hour)
+	}
+	if _, err := s.Snapshot(context.Background(), "dev-eu"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest snapshot of "dev" is gone, the one of "dev-eu" doesn't count against it
+	kept, err := s.target.List(context.Background(), "dev-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 3 || kept[0] != names[1] || kept[1] != names[2] {
+		t.Errorf("expected %v and the dev-eu snapshot, got %v", names[1:], kept)
+	}
+	if _, err := os.Stat(filepath.Join(dir, names[0]+".sha256")); !os.IsNotExist(err) {
+		t.Errorf("expected the checksum of the deleted snapshot to be removed too")
+	}
+
+	// A corrupt snapshot fails verification
+	corrupt := []byte("not a backup")
+	if err := os.WriteFile(filepath.Join(dir, "corrupt"), corrupt, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySnapshot(filepath.Join(dir, names[2]), "00"); err == nil {
+		t.Errorf("expected a checksum mismatch")
+	}
+	sum := sha256.Sum256(corrupt)
+	if err := verifySnapshot(filepath.Join(dir, "corrupt"), hex.EncodeToString(sum[:])); err == nil {
+		t.Errorf("expected a corrupt snapshot to fail verification")
+	}
+}
+
+func TestIsSnapshotOf(t *testing.T) {
+	if !isSnapshotOf("dev-20250101T030000Z.badger.bak", "dev") {
+		t.Errorf("expected the snapshot to belong to dev")
+	}
+	if isSnapshotOf("dev-eu-20250101T030000Z.badger.bak", "dev") {
+		t.Errorf("expected the snapshot of dev-eu not to belong to dev")
+	}
+}
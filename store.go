@@ -0,0 +1,38 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// queryOne returns the first stored event matching the filter, or nil if none does.
+// Badger returns events newest first, so for replaceable kinds this is the latest version.
+func queryOne(ctx context.Context, db EventStore, filter nostr.Filter) *nostr.Event {
+	filter.Limit = 1
+	events, err := db.QueryEvents(ctx, filter)
+	if err != nil {
+		return nil
+	}
+
+	var found *nostr.Event
+	for event := range events {
+		if found == nil {
+			found = event
+		}
+	}
+	return found
+}
+
+// getEventByID returns the stored event with the given ID, or nil if not found.
+func getEventByID(ctx context.Context, db EventStore, id string) *nostr.Event {
+	return queryOne(ctx, db, nostr.Filter{IDs: []string{id}})
+}
+
+// hasEvent reports whether at least one stored event matches the filter.
+func hasEvent(ctx context.Context, db EventStore, filter nostr.Filter) bool {
+	return queryOne(ctx, db, filter) != nil
+}
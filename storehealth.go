@@ -0,0 +1,188 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+)
+
+// storeFault is the class of an error returned by the event store on a write.
+type storeFault int
+
+const (
+	faultNone      storeFault = iota // the write failed for a reason of its own (duplicate, invalid event)
+	faultTransient                   // the write may succeed if retried (transaction conflict)
+	faultFatal                       // the store can't take writes until the operator steps in
+)
+
+// classifyStoreError returns the class of an error returned by the event store on a write.
+func classifyStoreError(err error) storeFault {
+	switch {
+	case errors.Is(err, eventstore.ErrDupEvent):
+		return faultNone
+	case errors.Is(err, badgerdb.ErrConflict):
+		return faultTransient
+	case errors.Is(err, syscall.ENOSPC),
+		errors.Is(err, syscall.EROFS),
+		errors.Is(err, syscall.EIO),
+		errors.Is(err, badgerdb.ErrDBClosed),
+		errors.Is(err, badgerdb.ErrBlockedWrites):
+		return faultFatal
+	default:
+		return faultNone
+	}
+}
+
+// StoreHealth puts a relay in a degraded, read-only mode when its event store becomes
+// unwritable (disk full, read-only filesystem, I/O errors, closed database), so clients
+// get a clear rejection instead of an opaque error for every event. Subscriptions keep
+// being served from the store. While degraded, one write per RetryInterval is let
+// through to find out whether the store recovered.
+type StoreHealth struct {
+	mu        sync.Mutex
+	degraded  bool
+	reason    string
+	lastRetry time.Time
+
+	relay         string
+	obs           *Observability
+	alertURL      string
+	client        *http.Client
+	RetryInterval time.Duration    // How often a write is attempted while degraded
+	Clock         func() time.Time // Source of the current time, time.Now if nil
+}
+
+// storeAlert is the body of the request sent to the alert webhook.
+type storeAlert struct {
+	Relay    string `json:"relay"`
+	Degraded bool   `json:"degraded"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// NewStoreHealth returns the store health of the relay. If alertURL is set, changes
+// of mode are also POSTed to it as JSON.
+func NewStoreHealth(relay string, alertURL string, obs *Observability) *StoreHealth {
+	return &StoreHealth{
+		relay:         relay,
+		obs:           obs,
+		alertURL:      alertURL,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		RetryInterval: time.Minute,
+	}
+}
+
+func (h *StoreHealth) now() time.Time {
+	if h.Clock != nil {
+		return h.Clock()
+	}
+	return time.Now()
+}
+
+// Writable reports whether a write should be attempted.
+func (h *StoreHealth) Writable() bool {
+	if h == nil {
+		return true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.degraded {
+		return true
+	}
+	if now := h.now(); now.Sub(h.lastRetry) >= h.RetryInterval {
+		h.lastRetry = now
+		return true
+	}
+	return false
+}
+
+// Degraded reports whether the store is in read-only mode, and why.
+func (h *StoreHealth) Degraded() (bool, string) {
+	if h == nil {
+		return false, ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.degraded, h.reason
+}
+
+// Record updates the mode with the outcome of a write, and returns the error to report
+// to the client: ErrStoreBusy for transient faults, ErrStoreUnavailable for fatal ones.
+func (h *StoreHealth) Record(err error) error {
+	if h == nil {
+		return err
+	}
+
+	fault := classifyStoreError(err)
+	if err != nil && fault == faultNone {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch fault {
+	case faultTransient:
+		h.obs.storeErrorCount.Add(1)
+		return ErrStoreBusy
+
+	case faultFatal:
+		h.obs.storeErrorCount.Add(1)
+		if !h.degraded {
+			h.degraded, h.reason, h.lastRetry = true, err.Error(), h.now()
+			h.obs.storeDegraded.Add(1)
+			relayLog.Error("ALERT: store is unwritable, switching to read-only mode", "relay", h.relay, "error", err)
+			go h.alert(storeAlert{Relay: h.relay, Degraded: true, Reason: h.reason})
+		}
+		return ErrStoreUnavailable
+
+	default:
+		if h.degraded {
+			h.degraded, h.reason = false, ""
+			h.obs.storeDegraded.Add(^uint64(0))
+			relayLog.Info("store is writable again, leaving read-only mode", "relay", h.relay)
+			go h.alert(storeAlert{Relay: h.relay})
+		}
+		return nil
+	}
+}
+
+// alert posts the alert to the webhook, if any.
+func (h *StoreHealth) alert(alert storeAlert) {
+	if h.alertURL == "" {
+		return
+	}
+
+	body, _ := json.Marshal(alert)
+	ctx, cancel := context.WithTimeout(context.Background(), h.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.alertURL, bytes.NewReader(body))
+	if err != nil {
+		relayLog.Error("failed to send store alert", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		relayLog.Error("failed to send store alert", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		relayLog.Error("failed to send store alert", "status", resp.Status)
+	}
+}
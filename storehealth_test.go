@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore"
+)
+
+func TestClassifyStoreError(t *testing.T) {
+	tests := []struct {
+		err   error
+		fault storeFault
+	}{
+		{nil, faultNone},
+		{eventstore.ErrDupEvent, faultNone},
+		{errors.New("event with values out of expected boundaries"), faultNone},
+		{badgerdb.ErrConflict, faultTransient},
+		{fmt.Errorf("write: %w", syscall.ENOSPC), faultFatal},
+		{syscall.EROFS, faultFatal},
+		{badgerdb.ErrDBClosed, faultFatal},
+		{badgerdb.ErrBlockedWrites, faultFatal},
+	}
+
+	for _, test := range tests {
+		if fault := classifyStoreError(test.err); fault != test.fault {
+			t.Errorf("%v: expected fault %d, got %d", test.err, test.fault, fault)
+		}
+	}
+}
+
+func TestStoreHealth(t *testing.T) {
+	alerts := make(chan storeAlert, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert storeAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer webhook.Close()
+
+	now := time.Now()
+	obs := &Observability{}
+	health := NewStoreHealth("default", webhook.URL, obs)
+	health.Clock = func() time.Time { return now }
+
+	if err := health.Record(eventstore.ErrDupEvent); err != eventstore.ErrDupEvent {
+		t.Errorf("duplicates should be reported as is, got %v", err)
+	}
+	if err := health.Record(badgerdb.ErrConflict); err != ErrStoreBusy {
+		t.Errorf("conflicts should be reported as ErrStoreBusy, got %v", err)
+	}
+	if degraded, _ := health.Degraded(); degraded {
+		t.Fatal("transient faults should not degrade the store")
+	}
+
+	if err := health.Record(syscall.ENOSPC); err != ErrStoreUnavailable {
+		t.Errorf("disk full should be reported as ErrStoreUnavailable, got %v", err)
+	}
+	if degraded, reason := health.Degraded(); !degraded || reason != syscall.ENOSPC.Error() {
+		t.Fatalf("expected the store to be degraded, got %v %q", degraded, reason)
+	}
+	if alert := <-alerts; !alert.Degraded || alert.Relay != "default" {
+		t.Errorf("unexpected degraded alert: %+v", alert)
+	}
+	if obs.storeDegraded.Load() != 1 || obs.storeErrorCount.Load() != 2 {
+		t.Errorf("unexpected metrics: degraded=%d errors=%d", obs.storeDegraded.Load(), obs.storeErrorCount.Load())
+	}
+
+	if health.Writable() {
+		t.Error("writes should be refused right after degrading")
+	}
+	now = now.Add(health.RetryInterval)
+	if !health.Writable() {
+		t.Error("a write should be retried after RetryInterval")
+	}
+	if health.Writable() {
+		t.Error("only one write should be retried per RetryInterval")
+	}
+
+	if err := health.Record(nil); err != nil {
+		t.Errorf("successful write: got %v", err)
+	}
+	if degraded, _ := health.Degraded(); degraded || !health.Writable() {
+		t.Error("a successful write should leave read-only mode")
+	}
+	if alert := <-alerts; alert.Degraded {
+		t.Errorf("unexpected recovery alert: %+v", alert)
+	}
+	if obs.storeDegraded.Load() != 0 {
+		t.Errorf("expected store_degraded to be reset, got %d", obs.storeDegraded.Load())
+	}
+}
@@ -0,0 +1,153 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/rely"
+)
+
+// Reasons sent in CLOSED messages for subscriptions closed by the SubscriptionReaper.
+const (
+	closedAfterEOSE = "restricted: this relay closes subscriptions after EOSE"
+	closedExpired   = "restricted: subscription exceeded its maximum lifetime"
+)
+
+// eoseGrace is how long after its query completed a subscription is left open when
+// subscriptions close after EOSE, so that the EOSE is sent before the CLOSED.
+const eoseGrace = time.Second
+
+// SubscriptionReaper closes the subscriptions of connected clients that are done:
+// once their stored events were sent, when subscriptions don't stay open for live events,
+// and once they are older than MaxAge.
+//
+// rely doesn't tell the REQ handler which subscription it serves, so queries are tracked
+// per client: a subscription got its stored events when it was created before the
+// client's last completed query and no other query of the client is in flight.
+type SubscriptionReaper struct {
+	mu      sync.Mutex
+	clients map[string]*clientQueries // by client UID
+	obs     *Observability
+
+	KeepOpen     bool          // Whether subscriptions stay open for live events after EOSE
+	MaxAge       time.Duration // Subscriptions older than this are closed (0 means no limit)
+	ReapInterval time.Duration // How often to scan subscriptions, fixed when the reaper starts
+}
+
+// clientQueries tracks the REQ queries of a connected client.
+type clientQueries struct {
+	client   rely.Client
+	inFlight int
+	lastDone time.Time
+}
+
+func NewSubscriptionReaper(ctx context.Context, obs *Observability, keepOpen bool, maxAge, interval time.Duration) *SubscriptionReaper {
+	r := &SubscriptionReaper{
+		clients:      make(map[string]*clientQueries, 100),
+		obs:          obs,
+		KeepOpen:     keepOpen,
+		MaxAge:       maxAge,
+		ReapInterval: interval,
+	}
+
+	if r.enabled() {
+		go r.reaper(ctx)
+	}
+	return r
+}
+
+// enabled reports whether the reaper has anything to close.
+func (r *SubscriptionReaper) enabled() bool {
+	return !r.KeepOpen || r.MaxAge > 0
+}
+
+// Connect starts tracking the client.
+func (r *SubscriptionReaper) Connect(c rely.Client) {
+	if !r.enabled() {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c.UID()] = &clientQueries{client: c}
+}
+
+// Disconnect stops tracking the client.
+func (r *SubscriptionReaper) Disconnect(c rely.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.UID())
+}
+
+// QueryStarted records that a REQ of the client was received. Its query is
+// in flight until QueryDone, even while it waits in rely's processing queue.
+func (r *SubscriptionReaper) QueryStarted(c rely.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if q, ok := r.clients[c.UID()]; ok {
+		q.inFlight++
+	}
+}
+
+// QueryDone records that a REQ query of the client completed.
+func (r *SubscriptionReaper) QueryDone(c rely.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if q, ok := r.clients[c.UID()]; ok {
+		q.inFlight--
+		q.lastDone = time.Now()
+	}
+}
+
+// Reap closes the subscriptions that are done, and returns how many were closed.
+func (r *SubscriptionReaper) Reap() int {
+	type expired struct {
+		sub    rely.Subscription
+		reason string
+	}
+	var done []expired
+
+	r.mu.Lock()
+	now := time.Now()
+	for _, q := range r.clients {
+		// The grace lets the EOSE of the last query go out before the CLOSED
+		queried := q.inFlight == 0 && now.Sub(q.lastDone) >= eoseGrace
+		for _, sub := range q.client.Subscriptions() {
+			switch {
+			case r.MaxAge > 0 && now.Sub(sub.CreatedAt()) > r.MaxAge:
+				done = append(done, expired{sub, closedExpired})
+			case !r.KeepOpen && queried && sub.CreatedAt().Before(q.lastDone):
+				done = append(done, expired{sub, closedAfterEOSE})
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	// Closing sends a message to the client, so do it without holding the lock
+	for _, d := range done {
+		d.sub.Close(d.reason)
+	}
+	r.obs.closedSubscriptionCount.Add(uint64(len(done)))
+	return len(done)
+}
+
+func (r *SubscriptionReaper) reaper(ctx context.Context) {
+	timer := time.NewTicker(r.ReapInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-timer.C:
+			r.Reap()
+		}
+	}
+}
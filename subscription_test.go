@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/rely"
+)
+
+type fakeClient struct {
+	rely.Client
+	uid  string
+	subs []rely.Subscription
+}
+
+func (c *fakeClient) UID() string                        { return c.uid }
+func (c *fakeClient) Subscriptions() []rely.Subscription { return c.subs }
+
+type fakeSubscription struct {
+	rely.Subscription
+	createdAt time.Time
+	closed    string
+}
+
+func (s *fakeSubscription) CreatedAt() time.Time { return s.createdAt }
+func (s *fakeSubscription) Close(reason string)  { s.closed = reason }
+
+func TestSubscriptionReaperClosesAfterEOSE(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reaper := NewSubscriptionReaper(ctx, &Observability{}, false, 0, time.Hour)
+
+	sub := &fakeSubscription{createdAt: time.Now().Add(-time.Minute)}
+	client := &fakeClient{uid: "client", subs: []rely.Subscription{sub}}
+	reaper.Connect(client)
+
+	reaper.QueryStarted(client)
+	if reaper.Reap() != 0 {
+		t.Fatal("subscriptions must not be closed while their query is in flight")
+	}
+
+	reaper.QueryDone(client)
+	if reaper.Reap() != 0 {
+		t.Fatal("subscriptions must not be closed before the EOSE grace period")
+	}
+
+	reaper.clients["client"].lastDone = time.Now().Add(-eoseGrace)
+	if reaper.Reap() != 1 || sub.closed != closedAfterEOSE {
+		t.Fatalf("expected the subscription to be closed after EOSE, got %q", sub.closed)
+	}
+
+	// A subscription created after the last query completed is still waiting for its own
+	late := &fakeSubscription{createdAt: time.Now()}
+	client.subs = []rely.Subscription{late}
+	if reaper.Reap() != 0 || late.closed != "" {
+		t.Error("subscriptions whose query didn't run must stay open")
+	}
+
+	reaper.Disconnect(client)
+	if len(reaper.clients) != 0 {
+		t.Error("disconnected clients should not be tracked")
+	}
+}
+
+func TestSubscriptionReaperMaxAge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reaper := NewSubscriptionReaper(ctx, &Observability{}, true, time.Hour, time.Hour)
+
+	old := &fakeSubscription{createdAt: time.Now().Add(-2 * time.Hour)}
+	fresh := &fakeSubscription{createdAt: time.Now().Add(-time.Minute)}
+	client := &fakeClient{uid: "client", subs: []rely.Subscription{old, fresh}}
+	reaper.Connect(client)
+	reaper.QueryStarted(client)
+	reaper.QueryDone(client)
+	reaper.clients["client"].lastDone = time.Now().Add(-time.Minute)
+
+	if closed := reaper.Reap(); closed != 1 {
+		t.Fatalf("expected 1 subscription closed, got %d", closed)
+	}
+	if old.closed != closedExpired {
+		t.Errorf("old subscription: got %q, want %q", old.closed, closedExpired)
+	}
+	if fresh.closed != "" {
+		t.Error("subscriptions stay open after EOSE when KeepOpen is set")
+	}
+	if got := reaper.obs.closedSubscriptionCount.Load(); got != 1 {
+		t.Errorf("expected closed_subscriptions to be 1, got %d", got)
+	}
+}
+
+func TestSubscriptionReaperDisabled(t *testing.T) {
+	reaper := NewSubscriptionReaper(context.Background(), &Observability{}, true, 0, time.Hour)
+	reaper.Connect(&fakeClient{uid: "client"})
+	if len(reaper.clients) != 0 {
+		t.Error("clients should not be tracked when the reaper has nothing to close")
+	}
+}
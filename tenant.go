@@ -0,0 +1,125 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultRelayName names the relay serving requests not routed to a virtual relay.
+const defaultRelayName = "default"
+
+// TenantSpec describes a virtual relay, as declared in the TENANTS_FILE.
+// Env holds configuration variables (e.g. "MID_THRESHOLD", "RELAY_NAME") that
+// override the process environment for this virtual relay only.
+type TenantSpec struct {
+	Name  string            `json:"name"`
+	Hosts []string          `json:"hosts"`
+	Path  string            `json:"path"`
+	Env   map[string]string `json:"env"`
+}
+
+// loadTenantSpecs reads and validates the virtual relay declarations from a JSON file.
+func loadTenantSpecs(path string) ([]TenantSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants file: %w", err)
+	}
+
+	var specs []TenantSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("failed to decode tenants file: %w", err)
+	}
+
+	names := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("tenant #%d has no name", i)
+		}
+		if spec.Name == defaultRelayName {
+			return nil, fmt.Errorf("tenant name %q is reserved", defaultRelayName)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("tenant %q is declared twice", spec.Name)
+		}
+		names[spec.Name] = true
+
+		if len(spec.Hosts) == 0 && spec.Path == "" {
+			return nil, fmt.Errorf("tenant %q must have hosts or a path", spec.Name)
+		}
+		if spec.Path != "" {
+			if !strings.HasPrefix(spec.Path, "/") || spec.Path == "/" {
+				return nil, fmt.Errorf("tenant %q path must start with / and not be the root", spec.Name)
+			}
+			specs[i].Path = strings.TrimSuffix(spec.Path, "/")
+		}
+		for j, host := range spec.Hosts {
+			specs[i].Hosts[j] = strings.ToLower(host)
+		}
+	}
+	return specs, nil
+}
+
+// tenantEnv returns a lookup function that resolves variables from the overrides
+// first, falling back to the base lookup.
+func tenantEnv(overrides map[string]string, base func(string) string) func(string) string {
+	return func(key string) string {
+		if value, ok := overrides[key]; ok {
+			return value
+		}
+		return base(key)
+	}
+}
+
+// tenantRoute binds a virtual relay's HTTP handler to its hosts and path.
+type tenantRoute struct {
+	spec    TenantSpec
+	handler http.Handler
+}
+
+// TenantRouter dispatches HTTP requests to virtual relays by Host header or path
+// prefix, falling back to the default relay when no virtual relay matches.
+type TenantRouter struct {
+	routes   []tenantRoute
+	fallback http.Handler
+}
+
+func NewTenantRouter(fallback http.Handler) *TenantRouter {
+	return &TenantRouter{fallback: fallback}
+}
+
+// Add registers the handler of the virtual relay.
+func (t *TenantRouter) Add(spec TenantSpec, handler http.Handler) {
+	t.routes = append(t.routes, tenantRoute{spec: spec, handler: handler})
+}
+
+func (t *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.match(r).ServeHTTP(w, r)
+}
+
+// match returns the handler for the request. A route matches when its hosts (if any)
+// include the request host and its path (if any) prefixes the request path.
+func (t *TenantRouter) match(r *http.Request) http.Handler {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, route := range t.routes {
+		if len(route.spec.Hosts) > 0 && !slices.Contains(route.spec.Hosts, host) {
+			continue
+		}
+		if route.spec.Path != "" && r.URL.Path != route.spec.Path && !strings.HasPrefix(r.URL.Path, route.spec.Path+"/") {
+			continue
+		}
+		return route.handler
+	}
+	return t.fallback
+}
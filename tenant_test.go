@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// namedHandler is an http.Handler that identifies which route served a request.
+type namedHandler string
+
+func (h namedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(h))
+}
+
+func TestTenantRouterMatch(t *testing.T) {
+	router := NewTenantRouter(namedHandler("default"))
+	router.Add(TenantSpec{Name: "cats", Hosts: []string{"cats.example.com"}}, namedHandler("cats"))
+	router.Add(TenantSpec{Name: "dogs", Path: "/dogs"}, namedHandler("dogs"))
+	router.Add(TenantSpec{Name: "birds", Hosts: []string{"pets.example.com"}, Path: "/birds"}, namedHandler("birds"))
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://cats.example.com/", expected: "cats"},
+		{url: "http://CATS.example.com:3334/", expected: "cats"},
+		{url: "http://relay.example.com/dogs", expected: "dogs"},
+		{url: "http://relay.example.com/dogs/", expected: "dogs"},
+		{url: "http://relay.example.com/dogsitter", expected: "default"},
+		{url: "http://pets.example.com/birds", expected: "birds"},
+		{url: "http://relay.example.com/birds", expected: "default"},
+		{url: "http://relay.example.com/", expected: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if got := w.Body.String(); got != tt.expected {
+				t.Errorf("request to %s routed to %q, want %q", tt.url, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadTenantSpecs(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "tenants.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	specs, err := loadTenantSpecs(write(t, `[{"name":"cats","hosts":["Cats.Example.com"],"path":"/cats/","env":{"MID_THRESHOLD":"0.3"}}]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if specs[0].Hosts[0] != "cats.example.com" || specs[0].Path != "/cats" {
+		t.Errorf("expected normalized host and path, got %v and %q", specs[0].Hosts, specs[0].Path)
+	}
+
+	invalid := []string{
+		`[{"hosts":["cats.example.com"]}]`,
+		`[{"name":"cats"}]`,
+		`[{"name":"cats","path":"/"}]`,
+		`[{"name":"cats","path":"/cats"},{"name":"cats","path":"/dogs"}]`,
+		`not json`,
+	}
+	for _, content := range invalid {
+		if _, err := loadTenantSpecs(write(t, content)); err == nil {
+			t.Errorf("expected error for %s", content)
+		}
+	}
+}
+
+func TestTenantConfigOverrides(t *testing.T) {
+	base := func(key string) string {
+		switch key {
+		case "MID_THRESHOLD":
+			return "0.5"
+		case "RELAY_NAME":
+			return "wotrlay"
+		}
+		return ""
+	}
+
+	cfg := parseConfig(tenantEnv(map[string]string{"MID_THRESHOLD": "0.3"}, base))
+	if cfg.MidThreshold != 0.3 {
+		t.Errorf("expected tenant override of MID_THRESHOLD, got %f", cfg.MidThreshold)
+	}
+	if cfg.RelayName != "wotrlay" {
+		t.Errorf("expected RELAY_NAME to fall back to the base environment, got %q", cfg.RelayName)
+	}
+}
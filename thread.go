@@ -0,0 +1,238 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	threadFetchBatch  = 50   // max events fetched per query
+	threadQueueSize   = 1000 // events waiting to be fetched, beyond which new ones are dropped
+	threadRecentLimit = 10000
+)
+
+// ThreadFetcher completes the threads of the relay: when a reply is stored, its parent
+// and the root of its thread are fetched in the background from the configured relays,
+// if they aren't stored yet. Fetched events bypass rate limits, as they are context
+// for readers rather than new content, but otherwise go through the policy with the
+// rank of their own author (see checkFetched): a reply can't pull in what its
+// parent's author couldn't publish.
+type ThreadFetcher struct {
+	d      *Deps
+	cfg    Config // the startup config, superseded by the live settings
+	queue  chan string
+	recent *lru.Cache[string, struct{}] // IDs queued recently, which aren't queued again
+	fetch  func(ctx context.Context, ids []string) []*nostr.Event
+
+	Interval time.Duration // How long queued IDs wait to be fetched together
+}
+
+func NewThreadFetcher(ctx context.Context, cfg Config, d *Deps) *ThreadFetcher {
+	pool := nostr.NewSimplePool(ctx)
+	recent, _ := lru.New[string, struct{}](threadRecentLimit)
+	relays := cfg.ThreadFetchRelays
+	f := &ThreadFetcher{
+		d:        d,
+		cfg:      cfg,
+		queue:    make(chan string, threadQueueSize),
+		recent:   recent,
+		Interval: time.Second,
+	}
+
+	f.fetch = func(ctx context.Context, ids []string) []*nostr.Event {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		var events []*nostr.Event
+		for e := range pool.FetchMany(ctx, relays, nostr.Filter{IDs: ids}) {
+			events = append(events, e.Event)
+		}
+		return events
+	}
+
+	go f.fetcher(ctx)
+	return f
+}
+
+// threadContext returns the IDs of the events a note replies to: its parent and,
+// when it's marked, the root of its thread.
+func threadContext(e *nostr.Event) []string {
+	parent, ok := replyTarget(e)
+	if !ok || !nostr.IsValid32ByteHex(parent) {
+		return nil
+	}
+
+	ids := []string{parent}
+	for _, tag := range e.Tags {
+		if len(tag) >= 4 && tag[0] == "e" && tag[3] == "root" && tag[1] != parent && nostr.IsValid32ByteHex(tag[1]) {
+			ids = append(ids, tag[1])
+			break
+		}
+	}
+	return ids
+}
+
+// Enqueue queues the thread context of the stored reply to be fetched, without blocking.
+func (f *ThreadFetcher) Enqueue(e *nostr.Event) {
+	for _, id := range threadContext(e) {
+		if ok, _ := f.recent.ContainsOrAdd(id, struct{}{}); ok {
+			continue
+		}
+
+		select {
+		case f.queue <- id:
+		default:
+			f.d.Obs.threadDroppedCount.Add(1)
+		}
+	}
+}
+
+// Fetch fetches the events that aren't stored yet, and stores those that are found.
+func (f *ThreadFetcher) Fetch(ctx context.Context, ids []string) {
+	missing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if getEventByID(ctx, f.d.DB, id) == nil {
+			missing[id] = true
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	query := make([]string, 0, len(missing))
+	for id := range missing {
+		query = append(query, id)
+	}
+
+	cfg := f.d.config(f.cfg)
+	stored := 0
+	for _, e := range f.fetch(ctx, query) {
+		// Relays may send anything, so only the valid events asked for are kept
+		if !missing[e.ID] || !e.CheckID() {
+			continue
+		}
+		if ok, err := e.CheckSignature(); err != nil || !ok {
+			continue
+		}
+		if f.d.Retention.Full() {
+			continue
+		}
+		if err := checkFetched(ctx, e, cfg, f.d); err != nil {
+			eventLog.DebugContext(ctx, "refused fetched thread event", "event", e.ID, "pubkey", e.PubKey, "reason", err)
+			continue
+		}
+
+		if err := store(ctx, e, f.d); err != nil {
+			continue
+		}
+		if f.d.Live != nil {
+			f.d.Live.Stream(e)
+		}
+		delete(missing, e.ID)
+		stored++
+	}
+
+	f.d.Obs.threadFetchedCount.Add(uint64(stored))
+	f.d.Obs.threadMissedCount.Add(uint64(len(missing)))
+}
+
+// checkFetched applies the policy of handleEvent to an event fetched from another relay,
+// with the rank of its author, except for what only makes sense for events sent by a
+// client: rate limits, IP groups and proof of work. Checks that would count toward the
+// author's quotas or history (file metadata, reposts, copypasta) refuse the event instead.
+func checkFetched(ctx context.Context, e *nostr.Event, cfg Config, d *Deps) error {
+	if d.Linkage.IsBanned(e.PubKey) {
+		return ErrBanned
+	}
+	if isDeleted(d.Meta, e) {
+		return ErrDeleted
+	}
+	if _, _, ok := d.Blocklist.Blocked(e); ok {
+		return ErrBlocklisted
+	}
+	if err := checkEventLimits(e, cfg); err != nil {
+		return err
+	}
+	now := d.now()
+	eventTime, err := checkCreatedAt(e, now, cfg, d.Obs)
+	if err != nil {
+		return err
+	}
+	if exemptKinds[e.Kind] {
+		return nil
+	}
+
+	rank := lookupRank(ctx, e.PubKey, cfg, d)
+	tier := tierFor(rank, cfg)
+	if d.Flags.Enabled(FlagShadowBan, tier) {
+		return errShadowBanned
+	}
+	if err := checkWriteWindow(tier, now, cfg); err != nil {
+		return err
+	}
+	if isTooOld(eventTime, now, tier, cfg) {
+		return ErrEventTooOld
+	}
+
+	switch {
+	case e.Kind == kindLongform:
+		if err := checkLongform(e, tier, cfg); err != nil {
+			return err
+		}
+	case e.Kind == kindFileMetadata, e.Kind == 9735, cfg.RepostPolicyEnabled && isRepost(e):
+		return ErrKindNotAllowed
+	case !allowedKinds(tier, cfg).Contains(e.Kind):
+		return ErrKindNotAllowed
+	}
+
+	if d.Flags.Enabled(FlagURLPolicy, tier) && e.Kind == 1 && d.URLs.Contains(e.Content) {
+		if !cfg.URLReplyExemption || !isReplyToHighTrust(ctx, e, cfg, d) {
+			return ErrURLNotAllowed
+		}
+	}
+	if rank < cfg.MidThreshold {
+		if cfg.HellthreadAction == hellthreadReject && isHellthread(e, cfg.HellthreadThreshold) {
+			return ErrHellthread
+		}
+		if isEntitySpam(e.Content, cfg.EntitySpamThreshold) {
+			return ErrEntitySpam
+		}
+		if cfg.ContentQualityAction != qualityOff && lowQualityReason(e.Content) != "" {
+			return ErrLowQuality
+		}
+	}
+	return nil
+}
+
+func (f *ThreadFetcher) fetcher(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	var batch []string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case id := <-f.queue:
+			batch = append(batch, id)
+			if len(batch) < threadFetchBatch {
+				continue
+			}
+
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		f.Fetch(ctx, batch)
+		batch = nil
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestThreadFetcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	root := signedEvent(t, sk, nostr.KindTextNote, nil)
+	parent := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"e", root.ID, "", "root"}})
+	reply := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"e", root.ID, "", "root"}, {"e", parent.ID, "", "reply"}})
+	unrelated := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"t", "unrelated"}})
+	forged := *root
+	forged.Content = "forged"
+
+	if got := threadContext(reply); !slices.Equal(got, []string{parent.ID, root.ID}) {
+		t.Fatalf("thread context: got %v, want the parent then the root", got)
+	}
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, NewRankCache(ctx, cfg, obs), newTestDB(t), obs, clock)
+
+	var queried []string
+	recent, _ := lru.New[string, struct{}](10)
+	f := &ThreadFetcher{d: d, cfg: cfg, queue: make(chan string, 1), recent: recent}
+	f.fetch = func(_ context.Context, ids []string) []*nostr.Event {
+		queried = append(queried, ids...)
+		return []*nostr.Event{&forged, parent, unrelated}
+	}
+
+	// The queue holds one ID, and IDs already queued aren't queued again
+	f.Enqueue(reply)
+	f.Enqueue(reply)
+	if len(f.queue) != 1 || obs.threadDroppedCount.Load() != 1 {
+		t.Errorf("expected 1 queued and 1 dropped ID, got %d and %d", len(f.queue), obs.threadDroppedCount.Load())
+	}
+
+	f.Fetch(ctx, threadContext(reply))
+	if getEventByID(ctx, d.DB, parent.ID) == nil {
+		t.Error("the parent should have been stored")
+	}
+	if getEventByID(ctx, d.DB, root.ID) != nil || getEventByID(ctx, d.DB, unrelated.ID) != nil {
+		t.Error("forged and unrequested events must not be stored")
+	}
+	if obs.threadFetchedCount.Load() != 1 || obs.threadMissedCount.Load() != 1 {
+		t.Errorf("expected 1 fetched and 1 missed event, got %d and %d", obs.threadFetchedCount.Load(), obs.threadMissedCount.Load())
+	}
+
+	// Stored events aren't fetched again
+	queried = nil
+	f.Fetch(ctx, threadContext(reply))
+	if !slices.Equal(queried, []string{root.ID}) {
+		t.Errorf("expected only the root to be fetched again, got %v", queried)
+	}
+
+	// Fetched events go through the policy with their author's rank: unranked authors
+	// may only have kind 1 notes stored, without spam
+	f.cfg = parseConfig(func(key string) string {
+		return map[string]string{"ENTITY_SPAM_THRESHOLD": "5"}[key]
+	})
+	reaction := signedEvent(t, nostr.GeneratePrivateKey(), nostr.KindReaction, nil)
+	spam := signedEvent(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, nil)
+	spam.Content = strings.Repeat("nostr:npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6 ", 20)
+	spam.Sign(nostr.GeneratePrivateKey())
+	note := signedEvent(t, nostr.GeneratePrivateKey(), nostr.KindTextNote, nil)
+	f.fetch = func(_ context.Context, ids []string) []*nostr.Event {
+		return []*nostr.Event{reaction, spam, note}
+	}
+	f.Fetch(ctx, []string{reaction.ID, spam.ID, note.ID})
+	if getEventByID(ctx, d.DB, reaction.ID) != nil || getEventByID(ctx, d.DB, spam.ID) != nil {
+		t.Error("events their author couldn't publish must not be stored")
+	}
+	if getEventByID(ctx, d.DB, note.ID) == nil {
+		t.Error("the note of an unranked author should have been stored")
+	}
+}
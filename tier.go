@@ -0,0 +1,195 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Tier is a coarse trust bucket derived from a pubkey's rank and the configured thresholds.
+type Tier int
+
+const (
+	// TierLow: rank below MidThreshold (LowTierKinds only)
+	TierLow Tier = iota
+	// TierMid: rank between MidThreshold and HighThreshold
+	TierMid
+	// TierHigh: rank at or above HighThreshold, or above MidThreshold when there is no high tier
+	TierHigh
+)
+
+// tierFor returns the trust tier of the rank under the given configuration.
+func tierFor(rank float64, cfg Config) Tier {
+	switch {
+	case rank < cfg.MidThreshold:
+		return TierLow
+	case cfg.HighThreshold != nil && rank < *cfg.HighThreshold:
+		return TierMid
+	default:
+		return TierHigh
+	}
+}
+
+func (t Tier) String() string {
+	switch t {
+	case TierLow:
+		return "low"
+	case TierMid:
+		return "mid"
+	default:
+		return "high"
+	}
+}
+
+// parseTier parses a tier name ("low", "mid" or "high"), case-insensitively.
+func parseTier(s string) (Tier, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return TierLow, true
+	case "mid":
+		return TierMid, true
+	case "high":
+		return TierHigh, true
+	default:
+		return TierLow, false
+	}
+}
+
+// maxKind is the largest event kind (NIP-01 kinds are between 0 and 65535).
+const maxKind = 65535
+
+// KindRange is an inclusive range of event kinds. In JSON, a single kind is a number
+// and a range a "from-to" string.
+type KindRange struct {
+	From, To int
+}
+
+func (r KindRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return strconv.Itoa(r.From) + "-" + strconv.Itoa(r.To)
+}
+
+func (r KindRange) MarshalJSON() ([]byte, error) {
+	if r.From == r.To {
+		return json.Marshal(r.From)
+	}
+	return json.Marshal(r.String())
+}
+
+func (r *KindRange) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.From); err == nil {
+		r.To = r.From
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := parseKindRange(s)
+	*r = parsed
+	return err
+}
+
+// parseKindRange parses a kind ("7") or a range of kinds ("30000-39999").
+func parseKindRange(s string) (KindRange, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	r := KindRange{}
+	var err1, err2 error
+	r.From, err1 = strconv.Atoi(from)
+	r.To, err2 = r.From, nil
+	if isRange {
+		r.To, err2 = strconv.Atoi(to)
+	}
+	if err1 != nil || err2 != nil || r.From < 0 || r.To > maxKind || r.From > r.To {
+		return KindRange{}, fmt.Errorf("%q is not a kind or a range of kinds", s)
+	}
+	return r, nil
+}
+
+// KindSet is a set of event kinds a tier may publish, e.g. "1,7,30000-39999", or "*" for any kind.
+type KindSet struct {
+	Any    bool
+	Ranges []KindRange // sorted and non-overlapping
+}
+
+// ParseKindSet parses a comma-separated list of kinds and ranges of kinds, or "*".
+func ParseKindSet(s string) (KindSet, error) {
+	var set KindSet
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if item == "*" {
+			set.Any = true
+			continue
+		}
+		r, err := parseKindRange(item)
+		if err != nil {
+			return KindSet{}, err
+		}
+		set.Ranges = append(set.Ranges, r)
+	}
+	if !set.Any && len(set.Ranges) == 0 {
+		return KindSet{}, fmt.Errorf("%q has no kinds", s)
+	}
+	if set.Any {
+		set.Ranges = nil
+		return set, nil
+	}
+
+	// Merge overlapping and adjacent ranges, so that String is canonical
+	slices.SortFunc(set.Ranges, func(a, b KindRange) int { return a.From - b.From })
+	merged := set.Ranges[:1]
+	for _, r := range set.Ranges[1:] {
+		if last := &merged[len(merged)-1]; r.From <= last.To+1 {
+			last.To = max(last.To, r.To)
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	set.Ranges = merged
+	return set, nil
+}
+
+// Contains reports whether the kind is in the set.
+func (k KindSet) Contains(kind int) bool {
+	if k.Any {
+		return true
+	}
+	for _, r := range k.Ranges {
+		if kind >= r.From && kind <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+func (k KindSet) String() string {
+	if k.Any {
+		return "*"
+	}
+	items := make([]string, len(k.Ranges))
+	for i, r := range k.Ranges {
+		items[i] = r.String()
+	}
+	return strings.Join(items, ",")
+}
+
+// allowedKinds returns the kinds the tier may publish.
+func allowedKinds(tier Tier, cfg Config) KindSet {
+	switch tier {
+	case TierLow:
+		return cfg.LowTierKinds
+	case TierMid:
+		return cfg.MidTierKinds
+	default:
+		return cfg.HighTierKinds
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestTierFor(t *testing.T) {
+	high := 0.9
+	withHigh := Config{MidThreshold: 0.5, HighThreshold: &high}
+	withoutHigh := Config{MidThreshold: 0.5}
+
+	tests := []struct {
+		name     string
+		rank     float64
+		cfg      Config
+		expected Tier
+	}{
+		{name: "zero rank", rank: 0, cfg: withHigh, expected: TierLow},
+		{name: "below mid", rank: 0.49, cfg: withHigh, expected: TierLow},
+		{name: "at mid", rank: 0.5, cfg: withHigh, expected: TierMid},
+		{name: "below high", rank: 0.89, cfg: withHigh, expected: TierMid},
+		{name: "at high", rank: 0.9, cfg: withHigh, expected: TierHigh},
+		{name: "no high tier below mid", rank: 0.2, cfg: withoutHigh, expected: TierLow},
+		{name: "no high tier above mid", rank: 0.6, cfg: withoutHigh, expected: TierHigh},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tierFor(tt.rank, tt.cfg); got != tt.expected {
+				t.Errorf("tierFor(%.2f) = %s, want %s", tt.rank, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKindSet(t *testing.T) {
+	kinds, err := ParseKindSet("1, 30000-39999, 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for kind, want := range map[int]bool{1: true, 7: true, 30000: true, 30023: true, 39999: true, 0: false, 6: false, 40000: false} {
+		if kinds.Contains(kind) != want {
+			t.Errorf("kind %d: expected %v", kind, want)
+		}
+	}
+
+	data, err := json.Marshal(kinds.Ranges)
+	if err != nil || string(data) != `[1,7,"30000-39999"]` {
+		t.Errorf("unexpected JSON %s, %v", data, err)
+	}
+	var decoded []KindRange
+	if err := json.Unmarshal(data, &decoded); err != nil || !slices.Equal(decoded, kinds.Ranges) {
+		t.Errorf("expected the JSON to decode back, got %v, %v", decoded, err)
+	}
+
+	if all, _ := ParseKindSet("*"); !all.Contains(65535) || all.String() != "*" {
+		t.Errorf("expected * to contain every kind, got %v", all)
+	}
+	for _, invalid := range []string{"", ",", "a", "-3", "5-", "3-2"} {
+		if _, err := ParseKindSet(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestAllowedKinds(t *testing.T) {
+	high := 0.9
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"LOW_TIER_KINDS": "1,7", "MID_TIER_KINDS": "0-10000"}[key]
+	})
+	cfg.HighThreshold = &high
+
+	for _, tt := range []struct {
+		rank float64
+		kind int
+		want bool
+	}{
+		{0, 7, true},
+		{0, 6, false},
+		{0.6, 6, true},
+		{0.6, 30023, false},
+		{0.95, 30023, true},
+	} {
+		if got := allowedKinds(tierFor(tt.rank, cfg), cfg).Contains(tt.kind); got != tt.want {
+			t.Errorf("rank %v, kind %d: got %v, want %v", tt.rank, tt.kind, got, tt.want)
+		}
+	}
+}
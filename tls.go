@@ -0,0 +1,106 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLS returns the TLS configuration of the HTTP server, or nil to serve plain HTTP.
+// Certificates come from Let's Encrypt when ACMEDomains is set, from TLSCertFile otherwise.
+func serverTLS(cfg Config) (*tls.Config, error) {
+	switch {
+	case len(cfg.ACMEDomains) > 0:
+		// Certificates are obtained through the TLS-ALPN-01 challenge, on the TLS listener itself
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+		return m.TLSConfig(), nil
+
+	case cfg.TLSCertFile != "":
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// certReloader serves a certificate from files, and loads it again when they change,
+// so that renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time // latest modification time of the files when cert was loaded
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate and key files. Must be called with r.mu held, or before r is shared.
+func (r *certReloader) load() error {
+	modified, err := r.lastModified()
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert, r.modified = &cert, modified
+	return nil
+}
+
+// GetCertificate returns the current certificate, loading it again if its files changed.
+// If the new files can't be loaded (e.g. halfway through a renewal), the previous certificate is served.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if modified, err := r.lastModified(); err == nil && modified.After(r.modified) {
+		if err := r.load(); err != nil {
+			relayLog.Error("keeping the previous TLS certificate", "error", err)
+		} else {
+			relayLog.Info("reloaded TLS certificate", "path", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
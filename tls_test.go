@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for the name and its key to the files.
+func writeTestCert(t *testing.T, name, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, "old.example.com", certFile, keyFile)
+
+	cfg := parseConfig(func(string) string { return "" })
+	if tlsConfig, err := serverTLS(cfg); tlsConfig != nil || err != nil {
+		t.Fatalf("expected plain HTTP by default, got %v, %v", tlsConfig, err)
+	}
+
+	cfg.TLSCertFile, cfg.TLSKeyFile = certFile, keyFile
+	tlsConfig, err := serverTLS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := tlsConfig.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "old.example.com" {
+		t.Errorf("unexpected certificate %q", leaf.Subject.CommonName)
+	}
+
+	// A renewed certificate is picked up on the next handshake
+	writeTestCert(t, "new.example.com", certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	cert, _ = tlsConfig.GetCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "new.example.com" {
+		t.Errorf("expected the renewed certificate, got %q", leaf.Subject.CommonName)
+	}
+
+	// A broken renewal keeps the previous certificate
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	if cert, err := tlsConfig.GetCertificate(nil); err != nil || cert == nil {
+		t.Errorf("expected the previous certificate, got %v, %v", cert, err)
+	}
+
+	cfg.TLSCertFile = filepath.Join(dir, "missing.pem")
+	if _, err := serverTLS(cfg); err == nil {
+		t.Error("expected an error for a missing certificate")
+	}
+
+	cfg.TLSCertFile, cfg.TLSKeyFile, cfg.ACMEDomains = "", "", []string{"relay.example.com"}
+	if tlsConfig, err := serverTLS(cfg); err != nil || !slices.Contains(tlsConfig.NextProtos, "acme-tls/1") {
+		t.Errorf("expected an ACME configuration answering TLS-ALPN-01 challenges, got %v, %v", tlsConfig, err)
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	if _, err := buildConfig(env(map[string]string{"TLS_CERT_FILE": "cert.pem"})); err == nil {
+		t.Error("expected an error for a certificate without a key")
+	}
+	if _, err := buildConfig(env(map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "ACME_DOMAINS": "relay.example.com"})); err == nil {
+		t.Error("expected an error for both certificate files and ACME")
+	}
+	cfg, err := buildConfig(env(map[string]string{"LISTEN_ADDR": ":443", "ACME_DOMAINS": "relay.example.com"}))
+	if err != nil || cfg.ListenAddr != ":443" || cfg.ACMECacheDir != "./autocert" {
+		t.Errorf("unexpected config %q %q: %v", cfg.ListenAddr, cfg.ACMECacheDir, err)
+	}
+}
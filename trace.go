@@ -0,0 +1,24 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+)
+
+type traceKey struct{}
+
+// withTrace returns a context carrying a new short trace ID, which logHandler adds to
+// every log line about the event being handled with that context.
+func withTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey{}, fmt.Sprintf("%08x", rand.Uint32()))
+}
+
+// traceID returns the trace ID carried by the context, or "" if there is none.
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLoggers(&buf, Config{})[moduleEvent]
+
+	log.InfoContext(context.Background(), "untraced")
+	if got := buf.String(); strings.Contains(got, "trace=") {
+		t.Errorf("without trace: got %q", got)
+	}
+
+	ctx := withTrace(context.Background())
+	id := traceID(ctx)
+	if len(id) != 8 {
+		t.Fatalf("trace ID %q should be 8 hex characters", id)
+	}
+	if other := traceID(withTrace(context.Background())); other == id {
+		t.Errorf("two traces got the same ID %q", id)
+	}
+
+	buf.Reset()
+	log.InfoContext(ctx, "traced event")
+	if got := buf.String(); !strings.Contains(got, `msg="traced event" module=event trace=`+id) {
+		t.Errorf("with trace: got %q", got)
+	}
+}
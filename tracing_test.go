@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpans(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	cfg := parseConfig(func(string) string { return "" })
+	obs := &Observability{}
+	cache := NewRankCache(ctx, cfg, obs)
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+
+	sk := nostr.GeneratePrivateKey()
+	e := signedEvent(t, sk, nostr.KindTextNote, nil)
+	cache.Update(time.Now(), PubRank{Pubkey: e.PubKey, Rank: 0.9})
+	if err := handleEvent(ctx, ipClient{ip: "203.0.113.7"}, e, cfg, d); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Query(ctx, nil, nostr.Filters{{Kinds: []int{nostr.KindTextNote}}}, cfg, d); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for child, parent := range map[string]string{"lookupRank": "handleEvent", "store": "handleEvent", "QueryEvents": "Query"} {
+		if spans[child] == nil || spans[parent] == nil {
+			t.Fatalf("expected %s and %s spans, got %v", child, parent, spans)
+		}
+		if spans[child].Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of %s", child, parent)
+		}
+	}
+
+	for _, attr := range spans["QueryEvents"].Attributes() {
+		if attr.Key == "wotrlay.filter.returned" && attr.Value.AsInt64() != 1 {
+			t.Errorf("expected 1 returned event, got %d", attr.Value.AsInt64())
+		}
+	}
+}
+
+func TestOTLPTracesURL(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://localhost:4318":                   "http://localhost:4318/v1/traces",
+		"https://otlp.example.com/":               "https://otlp.example.com/v1/traces",
+		"https://gateway.example.com/otlp/tenant": "https://gateway.example.com/otlp/tenant/v1/traces",
+	} {
+		if got := otlpTracesURL(endpoint); got != want {
+			t.Errorf("otlpTracesURL(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+func TestSetupTracingExportsToTracesPath(t *testing.T) {
+	paths := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer server.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL}[key]
+	})
+	shutdown, err := setupTracing(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case path := <-paths:
+		if path != "/v1/traces" {
+			t.Errorf("expected spans to be posted to /v1/traces, got %s", path)
+		}
+	default:
+		t.Error("expected spans to be exported on shutdown")
+	}
+}
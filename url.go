@@ -6,7 +6,11 @@ package main
 import (
 	"net"
 	"regexp"
+	"slices"
 	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
 // urlCandidateRegex finds URL-ish substrings in text content.
@@ -17,24 +21,105 @@ import (
 //
 // We keep validation (e.g. localhost/private IP exclusion) in Go code because
 // Go's regexp engine (RE2) does not support lookahead/lookbehind.
-var urlCandidateRegex = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s]+|(?:[a-z0-9-]+\.)+[a-z]{2,}(?:/[^\s]*)?`)
+//
+// Bare domains may use internationalized labels (e.g. "пример.рф") or their
+// punycode form (e.g. "xn--e1afmkfd.xn--p1ai").
+var urlCandidateRegex = regexp.MustCompile(`(?i)(?:https?://|www\.)[^\s]+|(?:[\p{L}\p{N}-]+\.)+(?:xn--[a-z0-9-]+|\p{L}{2,})(?:/[^\s]*)?`)
+
+// URLDetector finds URLs in text content.
+type URLDetector struct {
+	// ValidateTLD: whether bare domains (without scheme or "www.") must end in a TLD
+	// from the public suffix list, so that e.g. "notes.txt" is not taken for a link
+	ValidateTLD bool
+
+	// ExtraTLDs: additional lowercase TLDs accepted for bare domains (e.g. "eth", "bit")
+	ExtraTLDs map[string]bool
+}
+
+func NewURLDetector(validateTLD bool, extraTLDs []string) *URLDetector {
+	d := &URLDetector{
+		ValidateTLD: validateTLD,
+		ExtraTLDs:   make(map[string]bool, len(extraTLDs)),
+	}
+	for _, tld := range extraTLDs {
+		tld = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tld), "."))
+		if ascii, err := idna.Lookup.ToASCII(tld); err == nil {
+			tld = ascii
+		}
+		d.ExtraTLDs[tld] = true
+	}
+	return d
+}
+
+// defaultURLDetector accepts any alphabetic TLD.
+var defaultURLDetector = &URLDetector{}
 
 func isDomainChar(b byte) bool {
 	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '-' || b == '_'
 }
 
-// ContainsURL returns true if the content contains a URL.
-// This is used to enforce URL policy for low-trust users.
+// ContainsURL returns true if the content contains a URL, accepting any alphabetic TLD.
 func ContainsURL(content string) bool {
-	if content == "" {
+	return defaultURLDetector.Contains(content)
+}
+
+// ExtractURLs returns the URLs found in the content, accepting any alphabetic TLD.
+func ExtractURLs(content string) []string {
+	return defaultURLDetector.Extract(content)
+}
+
+// Contains returns true if the content contains a URL.
+// This is used to enforce URL policy for low-trust users.
+func (d *URLDetector) Contains(content string) bool {
+	found := false
+	d.scan(content, func(string) bool {
+		found = true
 		return false
+	})
+	return found
+}
+
+// Extract returns the distinct URLs found in the content, in order of appearance.
+// Obfuscated links are returned in their normalized form (e.g. "example.com" for "example[.]com").
+func (d *URLDetector) Extract(content string) []string {
+	var urls []string
+	d.scan(content, func(url string) bool {
+		if !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+		return true
+	})
+	return urls
+}
+
+// scan calls yield with every URL found in the content, including links obfuscated
+// with invisible characters, fullwidth or bracketed dots, or Cyrillic/Greek look-alikes.
+// Scanning stops early when yield returns false.
+func (d *URLDetector) scan(content string, yield func(url string) bool) {
+	if content == "" {
+		return
+	}
+
+	content = normalizeURLText(content)
+	if !d.scanNormalized(content, yield) {
+		return
 	}
 
+	// Look-alikes are folded in a second pass only, so that genuine
+	// internationalized domains are still recognized as they are.
+	if folded := foldConfusables(content); folded != content {
+		d.scanNormalized(folded, yield)
+	}
+}
+
+// scanNormalized calls yield with every URL found in the (normalized) content.
+// It returns false if yield stopped the scan.
+func (d *URLDetector) scanNormalized(content string, yield func(url string) bool) bool {
 	// Avoid FindAll* to keep allocations minimal on the hot path.
 	for off := 0; off < len(content); {
 		loc := urlCandidateRegex.FindStringIndex(content[off:])
 		if loc == nil {
-			return false
+			return true
 		}
 		start := off + loc[0]
 		end := off + loc[1]
@@ -59,25 +144,28 @@ func ContainsURL(content string) bool {
 			continue
 		}
 
-		if isAllowedURLCandidate(candidate) {
-			return true
+		if d.isAllowedURLCandidate(candidate) && !yield(candidate) {
+			return false
 		}
 	}
 
-	return false
+	return true
 }
 
-func isAllowedURLCandidate(candidate string) bool {
+func (d *URLDetector) isAllowedURLCandidate(candidate string) bool {
 	// Only treat http/https + www.* + bare domains as URLs.
 	// (Non-HTTP schemes are ignored by construction: the regex doesn't match them.)
 
 	// Extract host (strip scheme, path, query, fragment, and port).
 	// Keep parsing simple to reduce allocations.
 	s := candidate
+	bare := false
 	if len(s) >= 7 && strings.EqualFold(s[:7], "http://") {
 		s = s[7:]
 	} else if len(s) >= 8 && strings.EqualFold(s[:8], "https://") {
 		s = s[8:]
+	} else if len(s) < 4 || !strings.EqualFold(s[:4], "www.") {
+		bare = true
 	}
 
 	// Cut at first path/query/fragment delimiter.
@@ -125,5 +213,39 @@ func isAllowedURLCandidate(candidate string) bool {
 	}
 
 	// Minimal hostname sanity: must contain at least one dot.
-	return strings.Contains(hostLower, ".")
+	if !strings.Contains(hostLower, ".") {
+		return false
+	}
+
+	if bare && d.ValidateTLD {
+		return d.isKnownTLD(hostLower[strings.LastIndexByte(hostLower, '.')+1:])
+	}
+	return true
+}
+
+// isKnownTLD reports whether the TLD, in unicode or punycode form, is an ICANN TLD
+// of the public suffix list or one of the ExtraTLDs.
+func (d *URLDetector) isKnownTLD(tld string) bool {
+	if !isASCII(tld) {
+		ascii, err := idna.Lookup.ToASCII(tld)
+		if err != nil {
+			return false
+		}
+		tld = ascii
+	}
+
+	if d.ExtraTLDs[tld] {
+		return true
+	}
+	_, icann := publicsuffix.PublicSuffix(tld)
+	return icann
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
 }
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -286,3 +287,183 @@ func TestContainsURL_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestURLDetector_InternationalDomains(t *testing.T) {
+	detector := NewURLDetector(true, nil)
+
+	tests := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{
+			name:     "cyrillic domain",
+			content:  "купить тут пример.рф",
+			expected: true,
+		},
+		{
+			name:     "punycode domain",
+			content:  "buy at xn--e1afmkfd.xn--p1ai now",
+			expected: true,
+		},
+		{
+			name:     "unicode domain with ascii TLD",
+			content:  "visit bücher.de",
+			expected: true,
+		},
+		{
+			name:     "https URL with unicode host",
+			content:  "https://пример.рф/путь",
+			expected: true,
+		},
+		{
+			name:     "file name is not a domain",
+			content:  "see notes.txt for details",
+			expected: false,
+		},
+		{
+			name:     "unknown punycode TLD",
+			content:  "example.xn--zzzzzz",
+			expected: false,
+		},
+		{
+			name:     "unknown TLD with scheme is still a URL",
+			content:  "http://example.notatld",
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := detector.Contains(tt.content)
+			if result != tt.expected {
+				t.Errorf("Contains(%q) = %v, expected %v", tt.content, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestURLDetector_ExtraTLDs(t *testing.T) {
+	if NewURLDetector(true, nil).Contains("vitalik.eth") {
+		t.Error("eth is not in the public suffix list and should not be a URL by default")
+	}
+	if !NewURLDetector(true, []string{".ETH"}).Contains("vitalik.eth") {
+		t.Error("configured extra TLDs should be accepted")
+	}
+	if !NewURLDetector(false, nil).Contains("notes.txt") {
+		t.Error("without TLD validation any alphabetic TLD should be accepted")
+	}
+}
+
+func TestURLDetector_Obfuscation(t *testing.T) {
+	detector := NewURLDetector(true, nil)
+
+	tests := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{
+			name:     "zero-width space in domain",
+			content:  "visit exam\u200bple.c\u200bom",
+			expected: true,
+		},
+		{
+			name:     "zero-width joiner before dot",
+			content:  "example\u200d.com",
+			expected: true,
+		},
+		{
+			name:     "ideographic full stop",
+			content:  "example。com",
+			expected: true,
+		},
+		{
+			name:     "fullwidth domain",
+			content:  "ｅｘａｍｐｌｅ．ｃｏｍ",
+			expected: true,
+		},
+		{
+			name:     "bracketed dot",
+			content:  "go to example[.]com",
+			expected: true,
+		},
+		{
+			name:     "parenthesized dot word",
+			content:  "go to example (dot) com",
+			expected: true,
+		},
+		{
+			name:     "cyrillic look-alikes",
+			content:  "ехаmрlе.соm",
+			expected: true,
+		},
+		{
+			name:     "greek look-alikes in TLD",
+			content:  "example.cοm",
+			expected: true,
+		},
+		{
+			name:     "plain russian text",
+			content:  "привет, как дела?",
+			expected: false,
+		},
+		{
+			name:     "text with brackets but no dot",
+			content:  "a list [one] (two) {three}",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := detector.Contains(tt.content)
+			if result != tt.expected {
+				t.Errorf("Contains(%q) = %v, expected %v", tt.content, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:     "no URLs",
+			content:  "just text, test@example.com and 1.2.3",
+			expected: nil,
+		},
+		{
+			name:     "several URLs in order",
+			content:  "see https://example.com/a?b=c, (www.test.org) and foo.io.",
+			expected: []string{"https://example.com/a?b=c", "www.test.org", "foo.io"},
+		},
+		{
+			name:     "duplicates are returned once",
+			content:  "example.com example.com",
+			expected: []string{"example.com"},
+		},
+		{
+			name:     "obfuscated URL is normalized",
+			content:  "example[.]com",
+			expected: []string{"example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractURLs(tt.content)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("ExtractURLs(%q) = %q, expected %q", tt.content, got, tt.expected)
+			}
+		})
+	}
+
+	// With TLD validation the look-alike form itself isn't a URL, only its folded form is
+	if got := NewURLDetector(true, nil).Extract("ехаmрlе.соm"); !slices.Equal(got, []string{"example.com"}) {
+		t.Errorf("Extract of look-alike URL = %q, expected [example.com]", got)
+	}
+}
@@ -0,0 +1,249 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/fiatjaf/eventstore/badger"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Key prefixes of the event store: raw events are kept under rawEventPrefix <idx>,
+// and every index key of an event, under prefixes 1 to lastIndexPrefix, ends with its idx.
+const (
+	rawEventPrefix  byte = 0
+	lastIndexPrefix byte = 8
+)
+
+// VerifyReport is the outcome of verifying an event store.
+type VerifyReport struct {
+	Events   int // stored events scanned
+	Corrupt  int // stored events that can't be decoded
+	Invalid  int // stored events whose ID or signature is wrong
+	Dangling int // index entries of events that aren't stored
+	Deleted  int // entries deleted, events and index entries alike
+}
+
+// Problems returns the number of problems found.
+func (r VerifyReport) Problems() int {
+	return r.Corrupt + r.Invalid + r.Dangling
+}
+
+// verify implements `wotrlay verify [flags]`, and returns the process exit code.
+func verify(args []string) int {
+	cfg := loadConfig()
+
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	storePath := flags.String("store", cfg.StorePath, "event store to verify")
+	remove := flags.Bool("delete", false, "delete corrupt and invalid events and dangling index entries")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wotrlay verify [flags]")
+		fmt.Fprintln(flags.Output(), "\nScans the event store, re-validating the ID and signature of every event.")
+		fmt.Fprintln(flags.Output(), "Exits with status 1 if problems were found and left in place.")
+		fmt.Fprintln(flags.Output(), "Badger stores can't be opened by two processes: stop the relay or verify a copy.\n\nflags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	// Only Badger's keys are checked
+	if cfg.EventStoreBackend != backendBadger {
+		fmt.Fprintf(os.Stderr, "verify requires EVENTSTORE_BACKEND=badger, got %s\n", cfg.EventStoreBackend)
+		return 1
+	}
+
+	db := &badger.BadgerBackend{Path: *storePath}
+	if err := db.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open store at %s: %v\n", *storePath, err)
+		return 1
+	}
+	defer db.Close()
+	if err := checkStoreVersion(db, eventStoreMigrations); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	report, err := verifyStore(context.Background(), db, *remove, func(problem string) {
+		fmt.Println(problem)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "verified %d events: %d corrupt, %d invalid, %d dangling index entries\n",
+		report.Events, report.Corrupt, report.Invalid, report.Dangling)
+	if *remove {
+		fmt.Fprintf(os.Stderr, "deleted %d entries\n", report.Deleted)
+	}
+	if report.Problems() > 0 && !*remove {
+		return 1
+	}
+	return 0
+}
+
+// verifyStore checks that every stored event can be decoded and has a valid ID and
+// signature, and that every index entry points to a stored event. Each problem is
+// passed to report. With remove, the bad events are deleted with their index entries,
+// as are the dangling index entries, as they make the queries reaching them fail.
+func verifyStore(ctx context.Context, db *badger.BadgerBackend, remove bool, report func(string)) (VerifyReport, error) {
+	var r VerifyReport
+	stored := make(map[uint32]bool) // idx of the stored events
+	bad := make(map[uint32]bool)    // idx of the corrupt and invalid events
+	var doomed [][]byte             // keys to delete
+
+	err := db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{rawEventPrefix}, PrefetchValues: true})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if len(item.Key()) != 5 {
+				continue
+			}
+			idx := binary.BigEndian.Uint32(item.Key()[1:])
+			stored[idx] = true
+			r.Events++
+
+			var e nostr.Event
+			err := item.Value(func(value []byte) error { return decodeStoredEvent(value, &e) })
+			switch {
+			case err != nil:
+				r.Corrupt++
+				report(fmt.Sprintf("corrupt event at %x: %v", item.Key(), err))
+			case !e.CheckID():
+				r.Invalid++
+				report(fmt.Sprintf("invalid event %s: ID doesn't match its content", e.ID))
+			default:
+				if ok, _ := e.CheckSignature(); ok {
+					continue
+				}
+				r.Invalid++
+				report(fmt.Sprintf("invalid event %s: bad signature", e.ID))
+			}
+			bad[idx] = true
+			doomed = append(doomed, item.KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return r, fmt.Errorf("failed to scan events: %w", err)
+	}
+
+	err = db.View(func(txn *badgerdb.Txn) error {
+		for prefix := rawEventPrefix + 1; prefix <= lastIndexPrefix; prefix++ {
+			it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{prefix}})
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().Key()
+				if len(key) < 5 {
+					continue
+				}
+				idx := binary.BigEndian.Uint32(key[len(key)-4:])
+				if !stored[idx] {
+					r.Dangling++
+					report(fmt.Sprintf("dangling index entry %x", key))
+				}
+				if !stored[idx] || bad[idx] {
+					doomed = append(doomed, it.Item().KeyCopy(nil))
+				}
+			}
+			it.Close()
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return r, fmt.Errorf("failed to scan indexes: %w", err)
+	}
+
+	if !remove || len(doomed) == 0 {
+		return r, nil
+	}
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range doomed {
+		if err := batch.Delete(key); err != nil {
+			return r, fmt.Errorf("failed to delete %x: %w", key, err)
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return r, fmt.Errorf("failed to delete bad entries: %w", err)
+	}
+	r.Deleted = len(doomed)
+	return r, nil
+}
+
+// decodeStoredEvent decodes an event in the binary encoding of the event store,
+// which eventstore doesn't export. Unlike eventstore, it checks every length, so
+// truncated or garbled entries are reported instead of decoded into bogus events.
+func decodeStoredEvent(data []byte, e *nostr.Event) error {
+	if len(data) < 138 {
+		return fmt.Errorf("entry of %d bytes is too short", len(data))
+	}
+	e.ID = hex.EncodeToString(data[0:32])
+	e.PubKey = hex.EncodeToString(data[32:64])
+	e.Sig = hex.EncodeToString(data[64:128])
+	e.CreatedAt = nostr.Timestamp(binary.BigEndian.Uint32(data[128:132]))
+	e.Kind = int(binary.BigEndian.Uint16(data[132:134]))
+
+	next := func(n int, at int) ([]byte, error) {
+		if at+n > len(data) {
+			return nil, fmt.Errorf("entry truncated at byte %d", at)
+		}
+		return data[at : at+n], nil
+	}
+
+	length := int(binary.BigEndian.Uint16(data[134:136]))
+	content, err := next(length, 136)
+	if err != nil {
+		return err
+	}
+	e.Content = string(content)
+
+	curr := 136 + length
+	count, err := next(2, curr)
+	if err != nil {
+		return err
+	}
+	e.Tags = make(nostr.Tags, binary.BigEndian.Uint16(count))
+	curr += 2
+	for t := range e.Tags {
+		items, err := next(1, curr)
+		if err != nil {
+			return err
+		}
+		curr++
+		tag := make(nostr.Tag, items[0])
+		for i := range tag {
+			size, err := next(2, curr)
+			if err != nil {
+				return err
+			}
+			// Each item is followed by a zero byte
+			item, err := next(int(binary.BigEndian.Uint16(size))+1, curr+2)
+			if err != nil {
+				return err
+			}
+			tag[i] = string(item[:len(item)-1])
+			curr += 2 + len(item)
+		}
+		e.Tags[t] = tag
+	}
+	return nil
+}
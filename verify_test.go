@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	badgerdb "github.com/dgraph-io/badger/v4"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestVerifyStore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	sk := nostr.GeneratePrivateKey()
+	var events []*nostr.Event
+	for i, content := range []string{"intact", "garbled", "tampered", "missing"} {
+		e := signedEvent(t, sk, nostr.KindTextNote, nostr.Tags{{"t", content}, {"e", nostr.GeneratePrivateKey(), "", "root"}})
+		e.Content = content
+		e.CreatedAt += nostr.Timestamp(i)
+		if err := e.Sign(sk); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SaveEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+
+	// Find the stored entry of each event, checking that it decodes to the event
+	keys := make(map[string][]byte)
+	err := db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.IteratorOptions{Prefix: []byte{rawEventPrefix}, PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var e nostr.Event
+			if err := it.Item().Value(func(value []byte) error { return decodeStoredEvent(value, &e) }); err != nil {
+				return err
+			}
+			keys[e.Content] = it.Item().KeyCopy(nil)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if stored := getEventByID(ctx, db, e.ID); stored == nil || stored.String() != e.String() {
+			t.Fatalf("event %q was not decoded as stored", e.Content)
+		}
+	}
+
+	// Garble one entry, tamper with the content of another, and lose a third
+	err = db.Update(func(txn *badgerdb.Txn) error {
+		if err := txn.Set(keys["garbled"], []byte("garbage")); err != nil {
+			return err
+		}
+		item, err := txn.Get(keys["tampered"])
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		value[136] = 'T'
+		if err := txn.Set(keys["tampered"], value); err != nil {
+			return err
+		}
+		return txn.Delete(keys["missing"])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var problems []string
+	report, err := verifyStore(ctx, db, false, func(problem string) { problems = append(problems, problem) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Events != 3 || report.Corrupt != 1 || report.Invalid != 1 || report.Dangling == 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(problems) != report.Problems() {
+		t.Errorf("expected %d reported problems, got %d", report.Problems(), len(problems))
+	}
+
+	if report, err = verifyStore(ctx, db, true, func(string) {}); err != nil || report.Deleted == 0 {
+		t.Fatalf("deleting: %+v, %v", report, err)
+	}
+	if report, err = verifyStore(ctx, db, false, func(string) {}); err != nil || report.Events != 1 || report.Problems() != 0 {
+		t.Errorf("after deleting: %+v, %v", report, err)
+	}
+
+	// Queries no longer run into the bad entries
+	stored, err := db.QueryEvents(ctx, nostr.Filter{Kinds: []int{nostr.KindTextNote}, Tags: nostr.TagMap{"t": {"intact", "garbled", "tampered", "missing"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for e := range stored {
+		found = append(found, e.Content)
+	}
+	if len(found) != 1 || found[0] != "intact" {
+		t.Errorf("expected only the intact event, got %v", found)
+	}
+}
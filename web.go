@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"image"
 	"image/color"
 	"image/png"
@@ -10,13 +11,27 @@ import (
 	"github.com/nbd-wtf/go-nostr/nip11"
 )
 
-// generateFavicon creates a simple 16x16 PNG favicon with a blue background
-func generateFavicon() []byte {
+// defaultThemeColor is the accent color of the landing page and generated favicon.
+const defaultThemeColor = "#3498db"
+
+// parseHexColor parses a color in the #rrggbb form.
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	rgb, err := hex.DecodeString(s[1:])
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}, true
+}
+
+// generateFavicon creates a simple 16x16 PNG favicon with a background of the given color
+func generateFavicon(bgColor color.RGBA) []byte {
 	// Create a 16x16 image
 	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
 
-	// Fill with a nice blue color (#3498db)
-	bgColor := color.RGBA{52, 152, 219, 255}
+	// Fill with the background color
 	for y := range 16 {
 		for x := range 16 {
 			img.Set(x, y, bgColor)
@@ -49,11 +64,23 @@ func generateFavicon() []byte {
 	return buf.Bytes()
 }
 
-// serveFavicon handles favicon requests
-func serveFavicon() http.HandlerFunc {
-	favicon := generateFavicon()
+// serveFavicon handles favicon requests, serving the operator's FAVICON if it can be loaded,
+// and otherwise one generated in the theme color
+func serveFavicon(cfg Config) http.HandlerFunc {
+	contentType := "image/png"
+	bgColor, _ := parseHexColor(cfg.ThemeColor)
+	favicon := generateFavicon(bgColor)
+
+	asset, err := loadAsset("favicon", cfg.Favicon)
+	if err != nil {
+		relayLog.Warn("failed to load FAVICON, using the generated one", "error", err)
+	}
+	if asset != nil {
+		contentType, favicon = asset.ContentType, asset.Data
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 1 day
 		w.WriteHeader(http.StatusOK)
 		w.Write(favicon)
@@ -61,7 +88,7 @@ func serveFavicon() http.HandlerFunc {
 }
 
 // serveHTMLPage handles HTTP requests for the root path and serves a simple HTML page
-func serveHTMLPage(cfg Config, _ nip11.RelayInformationDocument) http.HandlerFunc {
+func serveHTMLPage(cfg Config, info nip11.RelayInformationDocument) http.HandlerFunc {
 	// Pre-render the HTML page once at startup
 	html := `<!DOCTYPE html>
 <html lang="en">
@@ -69,7 +96,8 @@ func serveHTMLPage(cfg Config, _ nip11.RelayInformationDocument) http.HandlerFun
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>` + cfg.RelayName + ` - Nostr Relay</title>
-    <link rel="icon" type="image/png" href="/favicon.ico">
+    <meta name="theme-color" content="` + cfg.ThemeColor + `">
+    <link rel="icon" href="/favicon.ico">
     <style>
         body {
             font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
@@ -107,7 +135,7 @@ func serveHTMLPage(cfg Config, _ nip11.RelayInformationDocument) http.HandlerFun
             margin: 10px 0;
         }
         .nip-badge {
-            background: #3498db;
+            background: ` + cfg.ThemeColor + `;
             color: white;
             padding: 4px 8px;
             border-radius: 4px;
@@ -125,17 +153,43 @@ func serveHTMLPage(cfg Config, _ nip11.RelayInformationDocument) http.HandlerFun
             margin-top: 10px;
         }
         .contact a {
-            color: #3498db;
+            color: ` + cfg.ThemeColor + `;
             text-decoration: none;
         }
         .contact a:hover {
             text-decoration: underline;
         }
+        .banner {
+            display: block;
+            width: 100%;
+            max-height: 200px;
+            object-fit: cover;
+            border-radius: 8px;
+            margin-bottom: 20px;
+        }
+        .icon {
+            width: 48px;
+            height: 48px;
+            border-radius: 50%;
+            vertical-align: middle;
+            margin-right: 12px;
+        }
     </style>
 </head>
 <body>
-    <div class="container">
-        <h1>Welcome to ` + cfg.RelayName + `</h1>
+    <div class="container">`
+
+	// Add banner and icon if configured
+	if info.Banner != "" {
+		html += `
+        <img class="banner" src="` + info.Banner + `" alt="">`
+	}
+	html += `
+        <h1>`
+	if info.Icon != "" {
+		html += `<img class="icon" src="` + info.Icon + `" alt="">`
+	}
+	html += `Welcome to ` + cfg.RelayName + `</h1>
         
         <div class="info-section">
             <p class="description">` + cfg.RelayDescription + `</p>
@@ -187,3 +241,169 @@ func serveHTMLPage(cfg Config, _ nip11.RelayInformationDocument) http.HandlerFun
 		w.Write([]byte(html))
 	}
 }
+
+// adminPage is the config editor. It holds no data itself: it asks for the ADMIN_TOKEN
+// and reads and changes the settings and feature flags through the admin API.
+const adminPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Relay settings</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+            max-width: 900px;
+            margin: 50px auto;
+            padding: 20px;
+            background: #f5f5f5;
+            color: #333;
+        }
+        .container {
+            background: white;
+            padding: 30px;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        h1, h2 {
+            color: #2c3e50;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+        td {
+            padding: 6px 4px;
+            border-bottom: 1px solid #eee;
+            vertical-align: top;
+        }
+        td input[type=text] {
+            width: 100%;
+            box-sizing: border-box;
+        }
+        .key {
+            font-family: monospace;
+            font-weight: bold;
+        }
+        .description {
+            color: #888;
+            font-size: 12px;
+        }
+        #status {
+            margin: 10px 0;
+            font-weight: bold;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Relay settings</h1>
+        <div id="status"></div>
+        <form id="settings"></form>
+        <p><button id="save" type="button">Save</button></p>
+        <h2>Feature flags</h2>
+        <table id="flags"></table>
+    </div>
+    <script>
+        const status = document.getElementById("status");
+        let values = {};
+
+        async function api(url, body) {
+            let token = sessionStorage.getItem("adminToken");
+            if (!token) {
+                token = prompt("Admin token");
+                sessionStorage.setItem("adminToken", token);
+            }
+            const init = {headers: {"Authorization": "Bearer " + token}};
+            if (body !== undefined) {
+                init.method = "POST";
+                init.body = JSON.stringify(body);
+            }
+            const res = await fetch(url, init);
+            if (res.status === 401) {
+                sessionStorage.removeItem("adminToken");
+            }
+            if (!res.ok) {
+                throw new Error(await res.text());
+            }
+            return res.json();
+        }
+
+        function renderSettings(settings) {
+            const form = document.getElementById("settings");
+            form.replaceChildren();
+            values = {};
+            let table;
+            let group;
+            for (const s of settings) {
+                values[s.key] = s.value;
+                if (s.group !== group) {
+                    group = s.group;
+                    const title = document.createElement("h2");
+                    title.textContent = group[0].toUpperCase() + group.slice(1);
+                    table = document.createElement("table");
+                    form.append(title, table);
+                }
+                const row = table.insertRow();
+                const label = row.insertCell();
+                label.innerHTML = '<div class="key"></div><div class="description"></div>';
+                label.children[0].textContent = s.key;
+                label.children[1].textContent = s.description;
+                const input = document.createElement("input");
+                input.type = "text";
+                input.name = s.key;
+                input.value = s.value;
+                input.placeholder = "default";
+                row.insertCell().append(input);
+            }
+        }
+
+        function renderFlags(flags) {
+            const table = document.getElementById("flags");
+            table.replaceChildren();
+            for (const [flag, tiers] of Object.entries(flags)) {
+                const row = table.insertRow();
+                row.insertCell().textContent = flag;
+                for (const tier of ["low", "mid", "high"]) {
+                    const box = document.createElement("input");
+                    box.type = "checkbox";
+                    box.checked = tiers[tier];
+                    box.onchange = () => api("admin/flags", {flag, tier, enabled: box.checked})
+                        .then(renderFlags)
+                        .catch(err => status.textContent = err.message);
+                    const cell = row.insertCell();
+                    cell.append(box, " " + tier);
+                }
+            }
+        }
+
+        document.getElementById("save").onclick = async () => {
+            const changes = {};
+            for (const input of document.querySelectorAll("#settings input")) {
+                if (input.value !== values[input.name]) {
+                    changes[input.name] = input.value;
+                }
+            }
+            try {
+                renderSettings(await api("admin/config", changes));
+                status.textContent = "Saved";
+            } catch (err) {
+                status.textContent = err.message;
+            }
+        };
+
+        api("admin/config")
+            .then(renderSettings)
+            .then(() => api("admin/flags"))
+            .then(renderFlags)
+            .catch(err => status.textContent = err.message);
+    </script>
+</body>
+</html>`
+
+// serveAdminPage serves the config editor
+func serveAdminPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(adminPage))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/color"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	if c, ok := parseHexColor("#3498db"); !ok || c != (color.RGBA{52, 152, 219, 255}) {
+		t.Errorf("got %v, %v", c, ok)
+	}
+	for _, s := range []string{"", "3498db", "#3498d", "#34980g", "#3498dbff"} {
+		if _, ok := parseHexColor(s); ok {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestServeFavicon(t *testing.T) {
+	serve := func(cfg Config) (string, []byte) {
+		w := httptest.NewRecorder()
+		serveFavicon(cfg)(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+		return w.Header().Get("Content-Type"), w.Body.Bytes()
+	}
+
+	generated := generateFavicon(color.RGBA{255, 0, 0, 255})
+	if contentType, body := serve(Config{ThemeColor: "#ff0000"}); contentType != "image/png" || !bytes.Equal(body, generated) {
+		t.Errorf("expected the favicon generated in the theme color, got %s", contentType)
+	}
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	cfg := Config{ThemeColor: "#ff0000", Favicon: "base64:" + base64.StdEncoding.EncodeToString(svg)}
+	if contentType, body := serve(cfg); contentType != "image/svg+xml" || !bytes.Equal(body, svg) {
+		t.Errorf("expected the operator's favicon, got %s", contentType)
+	}
+
+	cfg.Favicon = "/nonexistent/favicon.png"
+	if _, body := serve(cfg); !bytes.Equal(body, generated) {
+		t.Error("expected the generated favicon when FAVICON can't be loaded")
+	}
+}
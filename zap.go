@@ -0,0 +1,453 @@
+// Package main implements a Web-of-Trust (WoT) based Nostr relay
+// with reputation-driven rate limiting. It enforces community spam-protection
+// using external trust scores, with rate limits determined by a pubkey's reputation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Bolt11 layout, in 5-bit words: a 35-bit timestamp, tagged fields, and a 65-byte signature.
+const (
+	bolt11TimestampWords = 7
+	bolt11SignatureWords = 104
+	// bolt11MaxAmountDigits bounds the digits of an invoice amount
+	bolt11MaxAmountDigits = 19
+	// bolt11DescriptionHashField is the tagged field type of "h" (sha256 of the description)
+	bolt11DescriptionHashField = 23
+)
+
+// zapProviderFetchers is how many LNURL endpoints are fetched concurrently, in the background.
+const zapProviderFetchers = 4
+
+// zapMaxAwaiting bounds the stored receipts waiting for the provider of an LNURL endpoint.
+const zapMaxAwaiting = 100
+
+var (
+	// ErrZapPending is returned for zap receipts whose recipient's LNURL provider isn't known yet.
+	// It is fetched in the background, so a retry shortly after is checked without waiting on it.
+	ErrZapPending = errors.New("error: verifying the recipient's lnurl provider, please retry shortly")
+
+	// errZapRecipientUnknown is returned, wrapped in ErrInvalidZap, for zap receipts whose
+	// recipient's profile isn't stored, so that their LNURL provider can't be checked.
+	errZapRecipientUnknown = errors.New("recipient profile unknown")
+)
+
+// ZapValidator verifies kind 9735 zap receipts against the embedded zap request,
+// the bolt11 invoice, and the recipient's declared LNURL provider.
+//
+// LNURL endpoints come from profiles anyone can publish, so they are only fetched over
+// HTTPS from public addresses, and never while an event waits: Validate returns ErrZapPending
+// for a provider that isn't cached while it is fetched in the background. LNURL providers
+// publish a receipt once, so the relay stores such receipts and removes them once the
+// provider turns out not to have signed them (see Recheck). Stored profiles declaring a
+// lightning address are fetched ahead (see Prefetch).
+type ZapValidator struct {
+	client *http.Client
+	fetch  func(ctx context.Context, endpoint string) (string, error)
+
+	// providers caches LNURL endpoint -> provider nostrPubkey ("" if the endpoint doesn't support zaps)
+	providers *expirable.LRU[string, string]
+	// unreachable caches the LNURL endpoints that failed, so they aren't fetched for every receipt
+	unreachable *expirable.LRU[string, error]
+
+	mu       sync.Mutex
+	pending  map[string]bool              // endpoints queued or being fetched
+	awaiting map[string][]awaitingReceipt // endpoint -> stored receipts to check once it's fetched
+	queue    chan string
+
+	// VerifyProvider: whether to check the receipt author against the recipient's LNURL provider.
+	// This requires fetching the LNURL endpoint over HTTPS.
+	VerifyProvider bool
+}
+
+func NewZapValidator(ctx context.Context, verifyProvider bool) *ZapValidator {
+	z := &ZapValidator{
+		client:         newPublicClient(5 * time.Second),
+		providers:      expirable.NewLRU[string, string](10000, nil, time.Hour),
+		unreachable:    expirable.NewLRU[string, error](10000, nil, 5*time.Minute),
+		pending:        make(map[string]bool),
+		awaiting:       make(map[string][]awaitingReceipt),
+		queue:          make(chan string, 1000),
+		VerifyProvider: verifyProvider,
+	}
+	z.fetch = z.fetchProvider
+
+	if verifyProvider {
+		for range zapProviderFetchers {
+			go z.fetcher(ctx)
+		}
+	}
+	return z
+}
+
+// awaitingReceipt is a stored zap receipt waiting for the provider of its recipient, and
+// what to do with it if the provider didn't sign it.
+type awaitingReceipt struct {
+	receipt *nostr.Event
+	reject  func(ctx context.Context, receipt *nostr.Event)
+}
+
+// lnurlPayResponse is the subset of the LNURL-pay response relevant to NIP-57.
+type lnurlPayResponse struct {
+	AllowsNostr bool   `json:"allowsNostr"`
+	NostrPubkey string `json:"nostrPubkey"`
+}
+
+// profileMetadata is the subset of kind 0 metadata declaring a lightning address.
+type profileMetadata struct {
+	LUD06 string `json:"lud06"`
+	LUD16 string `json:"lud16"`
+}
+
+// Validate returns nil if the zap receipt is consistent with its zap request and invoice,
+// and (if VerifyProvider is set) was signed by the recipient's LNURL provider.
+func (z *ZapValidator) Validate(ctx context.Context, receipt *nostr.Event, db EventStore) error {
+	invoice := receipt.Tags.Find("bolt11")
+	description := receipt.Tags.Find("description")
+	recipient := receipt.Tags.Find("p")
+	if invoice == nil || description == nil || recipient == nil {
+		return fmt.Errorf("%w: missing bolt11, description or p tag", ErrInvalidZap)
+	}
+
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(description[1]), &request); err != nil {
+		return fmt.Errorf("%w: description is not a zap request", ErrInvalidZap)
+	}
+	if request.Kind != 9734 {
+		return fmt.Errorf("%w: description is not a zap request", ErrInvalidZap)
+	}
+	if ok, err := request.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("%w: invalid zap request signature", ErrInvalidZap)
+	}
+
+	if p := request.Tags.Find("p"); p == nil || p[1] != recipient[1] {
+		return fmt.Errorf("%w: zap request recipient mismatch", ErrInvalidZap)
+	}
+	if e := receipt.Tags.Find("e"); e != nil {
+		if requested := request.Tags.Find("e"); requested == nil || requested[1] != e[1] {
+			return fmt.Errorf("%w: zapped event mismatch", ErrInvalidZap)
+		}
+	}
+
+	amount, descriptionHash, err := decodeBolt11(invoice[1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidZap, err)
+	}
+	hash := sha256.Sum256([]byte(description[1]))
+	if !bytes.Equal(descriptionHash, hash[:]) {
+		return fmt.Errorf("%w: invoice description hash mismatch", ErrInvalidZap)
+	}
+	if requested := request.Tags.Find("amount"); requested != nil {
+		msats, err := strconv.ParseInt(requested[1], 10, 64)
+		if err != nil || msats != amount {
+			return fmt.Errorf("%w: invoice amount mismatch", ErrInvalidZap)
+		}
+	}
+
+	if !z.VerifyProvider {
+		return nil
+	}
+
+	provider, err := z.providerPubkey(ctx, recipient[1], db)
+	if errors.Is(err, ErrZapPending) {
+		return err
+	}
+	if errors.Is(err, errZapRecipientUnknown) {
+		return fmt.Errorf("%w: %w", ErrInvalidZap, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidZap, err)
+	}
+	if provider != receipt.PubKey {
+		return fmt.Errorf("%w: receipt not signed by the recipient's lnurl provider", ErrInvalidZap)
+	}
+	return nil
+}
+
+// providerPubkey returns the nostrPubkey of the LNURL provider declared in the
+// recipient's stored profile, or ErrZapPending if it isn't cached yet.
+func (z *ZapValidator) providerPubkey(ctx context.Context, recipient string, db EventStore) (string, error) {
+	endpoint, err := recipientEndpoint(ctx, recipient, db)
+	if err != nil {
+		return "", err
+	}
+
+	pubkey, ok := z.providers.Get(endpoint)
+	if !ok {
+		if err, failed := z.unreachable.Get(endpoint); failed {
+			return "", err
+		}
+		z.enqueue(endpoint)
+		return "", ErrZapPending
+	}
+	if pubkey == "" {
+		return "", errors.New("recipient lnurl provider doesn't support zaps")
+	}
+	return pubkey, nil
+}
+
+// recipientEndpoint returns the LNURL endpoint declared in the recipient's stored profile.
+func recipientEndpoint(ctx context.Context, recipient string, db EventStore) (string, error) {
+	profile := queryOne(ctx, db, nostr.Filter{Authors: []string{recipient}, Kinds: []int{0}})
+	if profile == nil {
+		return "", errZapRecipientUnknown
+	}
+	return lnurlEndpoint(profile)
+}
+
+// Recheck checks the stored receipt Validate returned ErrZapPending for once its provider is
+// fetched, and passes it to reject if the provider didn't sign it, or couldn't be fetched.
+func (z *ZapValidator) Recheck(ctx context.Context, receipt *nostr.Event, db EventStore, reject func(ctx context.Context, receipt *nostr.Event)) {
+	recipient := receipt.Tags.Find("p")
+	if recipient == nil {
+		return
+	}
+	if endpoint, err := recipientEndpoint(ctx, recipient[1], db); err == nil {
+		z.mu.Lock()
+		if z.pending[endpoint] && len(z.awaiting[endpoint]) < zapMaxAwaiting {
+			z.awaiting[endpoint] = append(z.awaiting[endpoint], awaitingReceipt{receipt: receipt, reject: reject})
+			z.mu.Unlock()
+			return
+		}
+		z.mu.Unlock()
+	}
+
+	// The provider was fetched meanwhile, or too many receipts wait for it
+	if err := z.Validate(ctx, receipt, db); err != nil {
+		reject(ctx, receipt)
+	}
+}
+
+// Prefetch queues the LNURL endpoint declared by the profile for a background fetch,
+// unless it is cached, so zap receipts for its author are checked without waiting.
+func (z *ZapValidator) Prefetch(profile *nostr.Event) {
+	if !z.VerifyProvider || profile.Kind != 0 {
+		return
+	}
+	endpoint, err := lnurlEndpoint(profile)
+	if err != nil || z.providers.Contains(endpoint) || z.unreachable.Contains(endpoint) {
+		return
+	}
+	z.enqueue(endpoint)
+}
+
+// enqueue queues the endpoint for a background fetch, unless it already is or the queue is full.
+func (z *ZapValidator) enqueue(endpoint string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.pending[endpoint] {
+		return
+	}
+	select {
+	case z.queue <- endpoint:
+		z.pending[endpoint] = true
+	default:
+	}
+}
+
+// fetcher fetches the queued LNURL endpoints, caching their provider pubkey or failure.
+func (z *ZapValidator) fetcher(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case endpoint := <-z.queue:
+			pubkey, err := z.fetch(ctx, endpoint)
+			if err != nil {
+				eventLog.DebugContext(ctx, "failed to fetch lnurl provider", "endpoint", endpoint, "error", err)
+				z.unreachable.Add(endpoint, err)
+			} else {
+				z.providers.Add(endpoint, pubkey)
+			}
+
+			z.mu.Lock()
+			delete(z.pending, endpoint)
+			awaiting := z.awaiting[endpoint]
+			delete(z.awaiting, endpoint)
+			z.mu.Unlock()
+
+			for _, a := range awaiting {
+				if err != nil || pubkey == "" || pubkey != a.receipt.PubKey {
+					a.reject(ctx, a.receipt)
+				}
+			}
+		}
+	}
+}
+
+// fetchProvider queries the LNURL-pay endpoint, over HTTPS and from a public address only,
+// and returns its nostrPubkey, or "" if it doesn't support nostr zaps.
+func (z *ZapValidator) fetchProvider(ctx context.Context, endpoint string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid lnurl endpoint: %w", err)
+	}
+
+	resp, err := z.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch lnurl endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("lnurl endpoint returned status %d", resp.StatusCode)
+	}
+
+	var pay lnurlPayResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&pay); err != nil {
+		return "", fmt.Errorf("failed to decode lnurl response: %w", err)
+	}
+
+	if !pay.AllowsNostr || !nostr.IsValidPublicKey(pay.NostrPubkey) {
+		return "", nil
+	}
+	return pay.NostrPubkey, nil
+}
+
+// lnurlEndpoint returns the LNURL-pay URL declared by the profile's lud16 or lud06 field,
+// which must be an HTTPS URL.
+func lnurlEndpoint(profile *nostr.Event) (string, error) {
+	var meta profileMetadata
+	if err := json.Unmarshal([]byte(profile.Content), &meta); err != nil {
+		return "", errors.New("recipient profile is not valid json")
+	}
+
+	if name, domain, ok := strings.Cut(meta.LUD16, "@"); ok && name != "" && domain != "" {
+		return "https://" + domain + "/.well-known/lnurlp/" + name, nil
+	}
+
+	if meta.LUD06 != "" {
+		hrp, words, err := bech32.DecodeNoLimit(meta.LUD06)
+		if err != nil || hrp != "lnurl" {
+			return "", errors.New("recipient lud06 is not a valid lnurl")
+		}
+		raw, err := bech32.ConvertBits(words, 5, 8, false)
+		if err != nil {
+			return "", errors.New("recipient lud06 is not a valid lnurl")
+		}
+		if u, err := url.Parse(string(raw)); err != nil || u.Scheme != "https" || u.Host == "" {
+			return "", errors.New("recipient lud06 is not an https url")
+		}
+		return string(raw), nil
+	}
+
+	return "", errors.New("recipient has no lightning address")
+}
+
+// decodeBolt11 returns the invoice amount in millisatoshis (0 if unspecified)
+// and its description hash ("h" field).
+func decodeBolt11(invoice string) (amount int64, descriptionHash []byte, err error) {
+	hrp, words, err := bech32.DecodeNoLimit(invoice)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid bolt11: %w", err)
+	}
+
+	amount, err = parseBolt11Amount(hrp)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(words) < bolt11TimestampWords+bolt11SignatureWords {
+		return 0, nil, errors.New("invalid bolt11: too short")
+	}
+
+	fields := words[bolt11TimestampWords : len(words)-bolt11SignatureWords]
+	for len(fields) >= 3 {
+		kind := fields[0]
+		length := int(fields[1])<<5 | int(fields[2])
+		fields = fields[3:]
+		if length > len(fields) {
+			return 0, nil, errors.New("invalid bolt11: truncated field")
+		}
+
+		if kind == bolt11DescriptionHashField && length == 52 {
+			descriptionHash, err = bech32.ConvertBits(fields[:length], 5, 8, false)
+			if err != nil {
+				return 0, nil, errors.New("invalid bolt11: bad description hash")
+			}
+		}
+		fields = fields[length:]
+	}
+
+	if descriptionHash == nil {
+		return 0, nil, errors.New("invalid bolt11: missing description hash")
+	}
+	return amount, descriptionHash, nil
+}
+
+// parseBolt11Amount parses the amount from the invoice human-readable part
+// (e.g. "lnbc2500u") and returns it in millisatoshis.
+func parseBolt11Amount(hrp string) (int64, error) {
+	if !strings.HasPrefix(hrp, "ln") {
+		return 0, errors.New("invalid bolt11: not a lightning invoice")
+	}
+
+	// Skip the currency prefix (bc, tb, tbs, bcrt, ...)
+	i := 2
+	for i < len(hrp) && (hrp[i] < '0' || hrp[i] > '9') {
+		i++
+	}
+	value := hrp[i:]
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := value[len(value)-1]
+	if multiplier >= '0' && multiplier <= '9' {
+		multiplier = 0
+	} else {
+		value = value[:len(value)-1]
+	}
+
+	// All the bitcoin there will ever be fits in 19 digits of millisatoshis: longer
+	// amounts are bogus, and would overflow once multiplied
+	if len(value) > bolt11MaxAmountDigits {
+		return 0, errors.New("invalid bolt11: amount is too large")
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid bolt11: bad amount")
+	}
+
+	var msats int64
+	switch multiplier {
+	case 0:
+		msats = 100_000_000_000
+	case 'm':
+		msats = 100_000_000
+	case 'u':
+		msats = 100_000
+	case 'n':
+		msats = 100
+	case 'p':
+		if n%10 != 0 {
+			return 0, errors.New("invalid bolt11: sub-millisatoshi amount")
+		}
+		return n / 10, nil
+	default:
+		return 0, errors.New("invalid bolt11: bad amount multiplier")
+	}
+	if n > math.MaxInt64/msats {
+		return 0, errors.New("invalid bolt11: amount is too large")
+	}
+	return n * msats, nil
+}
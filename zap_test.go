@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeBolt11 builds a structurally valid (but unsigned) invoice carrying the description hash.
+func fakeBolt11(t *testing.T, hrp string, description string) string {
+	t.Helper()
+
+	hash := sha256.Sum256([]byte(description))
+	hashWords, err := bech32.ConvertBits(hash[:], 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words := make([]byte, bolt11TimestampWords)
+	words = append(words, bolt11DescriptionHashField, byte(len(hashWords)>>5), byte(len(hashWords)&31))
+	words = append(words, hashWords...)
+	words = append(words, make([]byte, bolt11SignatureWords)...)
+
+	invoice, err := bech32.Encode(hrp, words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invoice
+}
+
+func TestParseBolt11Amount(t *testing.T) {
+	tests := []struct {
+		hrp      string
+		expected int64
+		wantErr  bool
+	}{
+		{hrp: "lnbc", expected: 0},
+		{hrp: "lnbc1", expected: 100_000_000_000},
+		{hrp: "lnbc10u", expected: 1_000_000},
+		{hrp: "lnbc2500u", expected: 250_000_000},
+		{hrp: "lnbc1m", expected: 100_000_000},
+		{hrp: "lntb20n", expected: 2_000},
+		{hrp: "lnbcrt10p", expected: 1},
+		{hrp: "lnbc15p", wantErr: true},
+		{hrp: "lnbc99999999999", wantErr: true},
+		{hrp: "lnbc123456789012345678901u", wantErr: true},
+		{hrp: "bc1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hrp, func(t *testing.T) {
+			got, err := parseBolt11Amount(tt.hrp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBolt11Amount(%q) error = %v, wantErr %v", tt.hrp, err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("parseBolt11Amount(%q) = %d, want %d", tt.hrp, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestZapValidatorConsistency(t *testing.T) {
+	sender := nostr.GeneratePrivateKey()
+	recipient := nostr.GeneratePrivateKey()
+	recipientPub, _ := nostr.GetPublicKey(recipient)
+
+	request := nostr.Event{
+		Kind:      9734,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"p", recipientPub},
+			{"amount", "1000000"},
+		},
+	}
+	if err := request.Sign(sender); err != nil {
+		t.Fatal(err)
+	}
+	description := request.String()
+
+	receipt := func(hrp, p string) *nostr.Event {
+		return &nostr.Event{
+			Kind: 9735,
+			Tags: nostr.Tags{
+				{"p", p},
+				{"bolt11", fakeBolt11(t, hrp, description)},
+				{"description", description},
+			},
+		}
+	}
+
+	z := NewZapValidator(context.Background(), false)
+	ctx := context.Background()
+
+	if err := z.Validate(ctx, receipt("lnbc10u", recipientPub), nil); err != nil {
+		t.Errorf("expected consistent receipt to be valid, got %v", err)
+	}
+	if err := z.Validate(ctx, receipt("lnbc20u", recipientPub), nil); !errors.Is(err, ErrInvalidZap) {
+		t.Errorf("expected amount mismatch to be rejected, got %v", err)
+	}
+	if err := z.Validate(ctx, receipt("lnbc10u", "someone-else"), nil); !errors.Is(err, ErrInvalidZap) {
+		t.Errorf("expected recipient mismatch to be rejected, got %v", err)
+	}
+
+	tampered := receipt("lnbc10u", recipientPub)
+	tampered.Tags[2][1] = description + " "
+	if err := z.Validate(ctx, tampered, nil); !errors.Is(err, ErrInvalidZap) {
+		t.Errorf("expected description hash mismatch to be rejected, got %v", err)
+	}
+}
+
+func TestZapValidatorProvider(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := newTestDB(t)
+	recipient, provider := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	recipientPub, _ := nostr.GetPublicKey(recipient)
+	providerPub, _ := nostr.GetPublicKey(provider)
+	profile := &nostr.Event{Kind: 0, CreatedAt: nostr.Now(), Content: `{"lud16":"alice@example.com"}`}
+	profile.Sign(recipient)
+	if err := db.SaveEvent(ctx, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	request := nostr.Event{Kind: 9734, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", recipientPub}}}
+	request.Sign(nostr.GeneratePrivateKey())
+	receipt := func(sk string) *nostr.Event {
+		e := &nostr.Event{Kind: 9735, CreatedAt: nostr.Now(), Tags: nostr.Tags{
+			{"p", recipientPub},
+			{"bolt11", fakeBolt11(t, "lnbc10u", request.String())},
+			{"description", request.String()},
+		}}
+		e.Sign(sk)
+		return e
+	}
+
+	z := NewZapValidator(ctx, true)
+	fetched := make(chan string, 10)
+	z.fetch = func(_ context.Context, endpoint string) (string, error) {
+		fetched <- endpoint
+		return providerPub, nil
+	}
+
+	// The provider is fetched in the background, and the receipt checked once it is known
+	if err := z.Validate(ctx, receipt(provider), db); !errors.Is(err, ErrZapPending) {
+		t.Fatalf("expected the receipt to wait for the provider, got %v", err)
+	}
+	select {
+	case endpoint := <-fetched:
+		if endpoint != "https://example.com/.well-known/lnurlp/alice" {
+			t.Errorf("unexpected endpoint %s", endpoint)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the provider to be fetched")
+	}
+	for !z.providers.Contains("https://example.com/.well-known/lnurlp/alice") {
+		time.Sleep(time.Millisecond)
+	}
+	if err := z.Validate(ctx, receipt(provider), db); err != nil {
+		t.Errorf("expected the receipt of the provider to be valid, got %v", err)
+	}
+	if err := z.Validate(ctx, receipt(nostr.GeneratePrivateKey()), db); !errors.Is(err, ErrInvalidZap) {
+		t.Errorf("expected a receipt from another pubkey to be rejected, got %v", err)
+	}
+
+	// Profiles are fetched ahead of their zaps
+	z.Prefetch(&nostr.Event{Kind: 0, Content: `{"lud16":"bob@example.org"}`})
+	select {
+	case endpoint := <-fetched:
+		if endpoint != "https://example.org/.well-known/lnurlp/bob" {
+			t.Errorf("unexpected endpoint %s", endpoint)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the provider of the profile to be prefetched")
+	}
+}
+
+func TestLNURLEndpoint(t *testing.T) {
+	profile := &nostr.Event{Kind: 0, Content: `{"name":"alice","lud16":"alice@example.com"}`}
+	endpoint, err := lnurlEndpoint(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint != "https://example.com/.well-known/lnurlp/alice" {
+		t.Errorf("unexpected endpoint %s", endpoint)
+	}
+
+	if _, err := lnurlEndpoint(&nostr.Event{Kind: 0, Content: `{"name":"bob"}`}); err == nil {
+		t.Error("expected error for profile without lightning address")
+	}
+
+	words, _ := bech32.ConvertBits([]byte("http://192.168.1.1/lnurlp/carol"), 8, 5, true)
+	lud06, _ := bech32.Encode("lnurl", words)
+	if _, err := lnurlEndpoint(&nostr.Event{Kind: 0, Content: `{"lud06":"` + lud06 + `"}`}); err == nil {
+		t.Error("expected error for a plain http lud06")
+	}
+}
+
+func TestZapReceiptsRechecked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{"ZAP_VALIDATION_ENABLED": "true"}[key]
+	})
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	cache := NewRankCache(ctx, cfg, obs)
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+
+	recipient, provider, forger := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	recipientPub, _ := nostr.GetPublicKey(recipient)
+	providerPub, _ := nostr.GetPublicKey(provider)
+	forgerPub, _ := nostr.GetPublicKey(forger)
+	cache.Update(time.Now(), PubRank{Pubkey: providerPub, Rank: 0.9}, PubRank{Pubkey: forgerPub, Rank: 0.9})
+
+	release := make(chan struct{})
+	d.Zaps.fetch = func(context.Context, string) (string, error) {
+		<-release
+		return providerPub, nil
+	}
+
+	publish := func(sk, recipientPub string) (*nostr.Event, error) {
+		request := nostr.Event{Kind: 9734, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", recipientPub}}}
+		request.Sign(nostr.GeneratePrivateKey())
+		receipt := &nostr.Event{Kind: 9735, CreatedAt: nostr.Now(), Tags: nostr.Tags{
+			{"p", recipientPub},
+			{"bolt11", fakeBolt11(t, "lnbc10u", request.String())},
+			{"description", request.String()},
+		}}
+		receipt.Sign(sk)
+		return receipt, handleEvent(ctx, ipClient{ip: "203.0.113.7"}, receipt, cfg, d)
+	}
+
+	// A receipt for a recipient whose profile isn't stored can't be checked against its provider
+	stranger, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if _, err := publish(forger, stranger); err != nil {
+		t.Errorf("receipt for an unknown recipient: %v", err)
+	}
+
+	profile := &nostr.Event{Kind: 0, CreatedAt: nostr.Now(), Content: `{"lud16":"alice@example.com"}`}
+	profile.Sign(recipient)
+	if err := d.DB.SaveEvent(ctx, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	// Receipts are stored while the provider is fetched, and those it didn't sign removed after
+	valid, err := publish(provider, recipientPub)
+	if err != nil {
+		t.Fatalf("receipt of the provider: %v", err)
+	}
+	forged, err := publish(forger, recipientPub)
+	if err != nil {
+		t.Fatalf("receipt waiting for the provider: %v", err)
+	}
+	if getEventByID(ctx, d.DB, forged.ID) == nil {
+		t.Fatal("expected the receipt to be stored while the provider is fetched")
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for getEventByID(ctx, d.DB, forged.ID) != nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if getEventByID(ctx, d.DB, forged.ID) != nil {
+		t.Error("expected the receipt not signed by the provider to be removed")
+	}
+	if getEventByID(ctx, d.DB, valid.ID) == nil {
+		t.Error("expected the receipt of the provider to be kept")
+	}
+	if got := obs.invalidZapCount.Load(); got != 1 {
+		t.Errorf("expected 1 invalid zap, got %d", got)
+	}
+
+	// Once the provider is known, receipts are checked right away
+	if _, err := publish(forger, recipientPub); !errors.Is(err, ErrInvalidZap) {
+		t.Errorf("forged receipt: got %v, want %v", err, ErrInvalidZap)
+	}
+}
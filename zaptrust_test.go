@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestZapTrustBoost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := parseConfig(func(key string) string {
+		return map[string]string{
+			"HIGH_THRESHOLD":         "0.8",
+			"ZAP_VALIDATION_ENABLED": "true",
+			"ZAP_VERIFY_PROVIDER":    "false",
+			"ZAP_TRUST_ENABLED":      "true",
+			"ZAP_TRUST_SATS":         "2000",
+			"ZAP_TRUST_MAX_BONUS":    "0.3",
+		}[key]
+	})
+	obs := &Observability{}
+	clock := &replayClock{}
+	clock.advance(nostr.Now())
+	cache := NewRankCache(ctx, cfg, obs)
+	d := newReplayDeps(ctx, cfg, cache, newTestDB(t), obs, clock)
+
+	recipient, trusted, stranger := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	recipientPub, _ := nostr.GetPublicKey(recipient)
+	trustedPub, _ := nostr.GetPublicKey(trusted)
+	cache.Update(time.Now(), PubRank{Pubkey: trustedPub, Rank: 0.9})
+
+	// zap stores the receipt of a zap of the sender, for the amount of the invoice
+	zap := func(sender, hrp string, forged bool) {
+		request := nostr.Event{Kind: 9734, CreatedAt: nostr.Now(), Content: hrp, Tags: nostr.Tags{{"p", recipientPub}}}
+		request.Sign(sender)
+		description := request.String()
+		invoice := fakeBolt11(t, hrp, description)
+		if forged {
+			invoice = fakeBolt11(t, hrp, "another description")
+		}
+		receipt := &nostr.Event{Kind: 9735, CreatedAt: nostr.Now(), Tags: nostr.Tags{
+			{"p", recipientPub},
+			{"bolt11", invoice},
+			{"description", description},
+		}}
+		receipt.Sign(nostr.GeneratePrivateKey())
+		if err := d.DB.SaveEvent(ctx, receipt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zap(trusted, "lnbc10u", false)  // 1000 sats
+	zap(trusted, "lnbc1m", true)    // forged: the invoice isn't for the request
+	zap(stranger, "lnbc1m", false)  // not from a high-trust pubkey
+	zap(recipient, "lnbc1m", false) // self-zap
+
+	// Only the valid receipt of the trusted pubkey counts: half the sats for the full bonus
+	if got := d.ZapTrust.Boost(ctx, recipientPub, 0.1, cfg, d); math.Abs(got-0.25) > 1e-9 {
+		t.Errorf("expected a rank of 0.25, got %v", got)
+	}
+	if got := d.ZapTrust.Boost(ctx, recipientPub, 0.9, cfg, d); got != 1 {
+		t.Errorf("expected the rank to be clamped to 1, got %v", got)
+	}
+}